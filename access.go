@@ -0,0 +1,53 @@
+package fsx
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Access mode bits for Access, matching the values used by access(2).
+const (
+	// F_OK tests for the existence of the file.
+	F_OK = internal.F_OK
+	// X_OK tests for execute (or search) permission.
+	X_OK = internal.X_OK
+	// W_OK tests for write permission.
+	W_OK = internal.W_OK
+	// R_OK tests for read permission.
+	R_OK = internal.R_OK
+)
+
+// AccessFS is the interface implemented by a file system that can check
+// whether the caller may access a file without opening it.
+type AccessFS interface {
+	fs.FS
+
+	// Access checks whether the caller can access the named file with the
+	// given mode, a combination of R_OK, W_OK and X_OK (or F_OK to check
+	// for existence only). It returns nil if access is granted.
+	// If access is denied, the error wraps fs.ErrPermission.
+	Access(name string, mode uint32) error
+}
+
+// Access checks whether the caller can access the named file with the given
+// mode (a combination of R_OK, W_OK and X_OK, or F_OK).
+//
+// If fsys implements AccessFS, it calls fsys.Access.
+// If the operation is not supported or not implemented, it falls back to a
+// heuristic based on Stat: the owner permission bits of the file must grant
+// the requested access.
+func Access(fsys fs.FS, name string, mode uint32) error {
+	if afs, ok := fsys.(AccessFS); ok {
+		if err := afs.Access(name, mode); !errors.Is(err, errors.ErrUnsupported) {
+			return internal.IntoPathErr("access", name, err)
+		}
+	}
+
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return internal.IntoPathErr("access", name, err)
+	}
+	return internal.IntoPathErr("access", name, internal.CheckAccess(info, mode))
+}
@@ -0,0 +1,59 @@
+package fsx_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestAccess(t *testing.T) {
+	t.Run("Fallback", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"ro":  {Mode: 0444},
+			"exe": {Mode: 0755},
+		}
+
+		if err := fsx.Access(fsys, "ro", fsx.R_OK); err != nil {
+			t.Errorf("expected read access, got %v", err)
+		}
+		if err := fsx.Access(fsys, "ro", fsx.W_OK); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("expected ErrPermission, got %v", err)
+		}
+		if err := fsx.Access(fsys, "exe", fsx.R_OK|fsx.W_OK|fsx.X_OK); err != nil {
+			t.Errorf("expected full access, got %v", err)
+		}
+		var pathErr *fs.PathError
+		if err := fsx.Access(fsys, "missing", fsx.F_OK); !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &pathErr) || pathErr.Op != "access" {
+			t.Errorf("expected access PathError wrapping ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("OSFS", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("file", filepath.Join(dir, "link")); err != nil {
+			t.Fatal(err)
+		}
+		fsys, err := osfs.New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{".", "file", "link"} {
+			if err := fsx.Access(fsys, name, fsx.R_OK|fsx.W_OK); err != nil {
+				t.Errorf("Access(%s) failed: %v", name, err)
+			}
+		}
+		if err := fsx.Access(fsys, "missing", fsx.F_OK); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected ErrNotExist, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package fsx
+
+import (
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Attributes is a set of file attributes in the sense of Windows, such as
+// hidden or system, kept apart from the permission bits.
+type Attributes = internal.Attributes
+
+const (
+	// AttrReadOnly marks a file that cannot be written.
+	AttrReadOnly = internal.AttrReadOnly
+	// AttrHidden marks a file that is not listed by default.
+	AttrHidden = internal.AttrHidden
+	// AttrSystem marks a file used by the operating system.
+	AttrSystem = internal.AttrSystem
+	// AttrArchive marks a file that changed since it was last backed up.
+	AttrArchive = internal.AttrArchive
+	// AttrReparsePoint marks a reparse point, such as a symbolic link or a
+	// junction.
+	AttrReparsePoint = internal.AttrReparsePoint
+)
+
+// AttributeInfo is implemented by FileInfo values that know the attributes of
+// the file they describe, such as those returned by ExtendFileInfo.
+type AttributeInfo = internal.AttributeInfo
+
+// FileAttributes returns the attributes of the file described by info.
+//
+// On Windows, the attributes of files from the OS are reported as stored.
+// Elsewhere, and for FileInfo values that do not implement AttributeInfo,
+// they are derived so that consumers see consistent behavior: dot files are
+// hidden, files without write permission are read-only, and symbolic links
+// are reparse points.
+func FileAttributes(info fs.FileInfo) Attributes {
+	return internal.FileAttributes(info)
+}
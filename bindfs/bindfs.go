@@ -2,13 +2,13 @@ package bindfs
 
 import (
 	"context"
+	"io"
 	"io/fs"
 	"os"
-	"path"
-	"time"
 
 	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
 )
 
 func Static[T any](val T) func(context.Context, string) T {
@@ -23,19 +23,43 @@ type Config struct {
 	RevokePerm func(ctx context.Context, name string) fs.FileMode
 	Owner      func(ctx context.Context, name string) string
 	Group      func(ctx context.Context, name string) string
-}
-
+	// Resolver, if set, maps the names of the bound view to the identities
+	// stored by the underlying filesystem: Chown and Lchown pass down the
+	// ids of the names they are given, and FileInfo reports the names of the
+	// stored ids unless Owner or Group override them.
+	Resolver contextual.Resolver
+	// AuditSafe keeps GrantPerm from widening the permissions of the
+	// underlying filesystem: the permission bits it grants are only kept if
+	// the underlying mode already has them, so that the bound view cannot
+	// grant more access than the backing store. Overrides can still narrow
+	// permissions with RevokePerm.
+	AuditSafe bool
+	// OnWiden, if set, is called in AuditSafe mode with the permission bits
+	// of name that GrantPerm would have added, each time they are dropped
+	// from a reported mode, so that misconfigured overrides can be logged.
+	OnWiden func(ctx context.Context, name string, dropped fs.FileMode)
+	// Lifetime selects the context given to the functions above when they
+	// are called by the FileInfo and DirEntry values returned by the
+	// filesystem, which can be used after the call that returned them.
+	// contextual.Detached, the default, keeps the values of the context of
+	// that call without its cancellation, so that a FileInfo looked at
+	// after the request is over still reports the overridden metadata.
+	Lifetime contextual.Lifetime
+}
+
+// filesystem overrides the methods reporting or changing metadata and
+// leaves the others to the embedded PassthroughFS.
 type filesystem struct {
+	contextual.PassthroughFS
 	Config
-	fs contextual.FS
 }
 
 // New creates a new bindfs that delegates all operations to fsys but
 // overrides metadata according to config.
 func New(fsys contextual.FS, config Config) contextual.FileSystem {
 	f := &filesystem{
-		Config: config,
-		fs:     fsys,
+		PassthroughFS: contextual.PassthroughFS{Inner: fsys},
+		Config:        config,
 	}
 	return f
 }
@@ -51,6 +75,11 @@ func (fi *fileInfo) Owner() string {
 	if fi.fs.Owner != nil {
 		return fi.fs.Owner(fi.ctx, fi.name)
 	}
+	if fi.fs.Resolver != nil {
+		if name, err := fi.fs.Resolver.UserName(fi.ctx, fi.FileInfo.Owner()); err == nil {
+			return name
+		}
+	}
 	return fi.FileInfo.Owner()
 }
 
@@ -58,13 +87,27 @@ func (fi *fileInfo) Group() string {
 	if fi.fs.Group != nil {
 		return fi.fs.Group(fi.ctx, fi.name)
 	}
+	if fi.fs.Resolver != nil {
+		if name, err := fi.fs.Resolver.GroupName(fi.ctx, fi.FileInfo.Group()); err == nil {
+			return name
+		}
+	}
 	return fi.FileInfo.Group()
 }
 
 func (fi *fileInfo) Mode() fs.FileMode {
 	mode := fi.FileInfo.Mode()
 	if fi.fs.GrantPerm != nil {
-		mode |= fi.fs.GrantPerm(fi.ctx, fi.name).Perm()
+		grant := fi.fs.GrantPerm(fi.ctx, fi.name).Perm()
+		if fi.fs.AuditSafe {
+			if dropped := grant &^ mode.Perm(); dropped != 0 {
+				grant &^= dropped
+				if fi.fs.OnWiden != nil {
+					fi.fs.OnWiden(fi.ctx, fi.name, dropped)
+				}
+			}
+		}
+		mode |= grant
 	}
 	if fi.fs.RevokePerm != nil {
 		mode &= ^fi.fs.RevokePerm(fi.ctx, fi.name).Perm()
@@ -77,9 +120,15 @@ type dirEntry struct {
 	ctx  context.Context
 	name string
 	fs   *filesystem
+	// err is set if the entry has no valid name to look its attributes
+	// up with.
+	err error
 }
 
 func (d *dirEntry) Info() (fs.FileInfo, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
 	fi, err := d.DirEntry.Info()
 	if err != nil {
 		return nil, err
@@ -93,7 +142,7 @@ func (f *filesystem) wrapFileInfo(ctx context.Context, name string, fi fs.FileIn
 	}
 	return &fileInfo{
 		FileInfo: contextual.ExtendFileInfo(fi),
-		ctx:      ctx,
+		ctx:      f.Lifetime.Context(ctx),
 		name:     name,
 		fs:       f,
 	}
@@ -103,11 +152,13 @@ func (f *filesystem) wrapDirEntry(ctx context.Context, parent string, de fs.DirE
 	if de == nil {
 		return nil
 	}
+	name, err := contextual.JoinValid(parent, de.Name())
 	return &dirEntry{
 		DirEntry: de,
-		ctx:      ctx,
-		name:     path.Join(parent, de.Name()),
+		ctx:      f.Lifetime.Context(ctx),
+		name:     name,
 		fs:       f,
+		err:      err,
 	}
 }
 
@@ -126,51 +177,92 @@ func (f *fileWrapper) Stat() (fs.FileInfo, error) {
 	return f.fs.wrapFileInfo(f.ctx, f.name, fi), nil
 }
 
-func (f *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
-	file, err := contextual.OpenFile(ctx, f.fs, name, os.O_RDONLY, 0)
+func (f *fileWrapper) Restat() (fs.FileInfo, error) {
+	fi, err := internal.Restat(f.File)
 	if err != nil {
 		return nil, err
 	}
-	return &fileWrapper{File: file, ctx: ctx, name: name, fs: f}, nil
+	return f.fs.wrapFileInfo(f.ctx, f.name, fi), nil
 }
 
-func (f *filesystem) Create(ctx context.Context, name string) (fsx.File, error) {
-	file, err := contextual.Create(ctx, f.fs, name)
-	if err != nil {
-		return nil, err
+// ReadDir reads the entries of a directory. Entries read through it carry
+// the overridden metadata, like those returned by filesystem.ReadDir. It is
+// only exposed, through internal.WrapFile, if the file implements
+// fs.ReadDirFile.
+func (f *fileWrapper) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.File.(fs.ReadDirFile).ReadDir(n)
+	for i, e := range entries {
+		entries[i] = f.fs.wrapDirEntry(f.ctx, f.name, e)
 	}
-	return &fileWrapper{File: file, ctx: ctx, name: name, fs: f}, nil
+	return entries, err
 }
 
-func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
-	file, err := contextual.OpenFile(ctx, f.fs, name, flag, mode)
-	if err != nil {
+// WriteTo and ReadFrom let the file serve or fill itself without buffering,
+// as the contents are those of the underlying file.
+func (f *fileWrapper) WriteTo(w io.Writer) (int64, error) {
+	return internal.WriteTo(w, f.File)
+}
+
+func (f *fileWrapper) ReadFrom(r io.Reader) (int64, error) {
+	return internal.ReadFrom(f.File, r)
+}
+
+func (f *filesystem) wrapFile(ctx context.Context, name string, file fsx.File) fsx.File {
+	return internal.WrapFile(&fileWrapper{File: file, ctx: contextual.IOContext(ctx), name: name, fs: f}, file)
+}
+
+func (f *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
+	if err := internal.CheckPath("open", name); err != nil {
 		return nil, err
 	}
-	return &fileWrapper{File: file, ctx: ctx, name: name, fs: f}, nil
+	file, err := contextual.OpenFile(ctx, f.Inner, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return f.wrapFile(ctx, name, file), nil
 }
 
-func (f *filesystem) Remove(ctx context.Context, name string) error {
-	return contextual.Remove(ctx, f.fs, name)
+func (f *filesystem) Create(ctx context.Context, name string) (fsx.File, error) {
+	if err := internal.CheckPath("open", name); err != nil {
+		return nil, err
+	}
+	file, err := contextual.Create(ctx, f.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return f.wrapFile(ctx, name, file), nil
 }
 
-func (f *filesystem) ReadFile(ctx context.Context, name string) ([]byte, error) {
-	return contextual.ReadFile(ctx, f.fs, name)
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	if err := internal.CheckOpen(name, flag); err != nil {
+		return nil, err
+	}
+	file, err := contextual.OpenFile(ctx, f.Inner, name, flag, mode)
+	if err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return f.wrapFile(ctx, name, file), nil
 }
 
 func (f *filesystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
-	fi, err := contextual.Stat(ctx, f.fs, name)
-	if err != nil {
+	if err := internal.CheckPath("stat", name); err != nil {
 		return nil, err
 	}
+	fi, err := contextual.Stat(ctx, f.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("stat", name, err)
+	}
 	return f.wrapFileInfo(ctx, name, fi), nil
 }
 
 func (f *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
-	entries, err := contextual.ReadDir(ctx, f.fs, name)
-	if err != nil {
+	if err := internal.CheckPath("readdir", name); err != nil {
 		return nil, err
 	}
+	entries, err := contextual.ReadDir(ctx, f.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("readdir", name, err)
+	}
 	wrapped := make([]fs.DirEntry, len(entries))
 	for i, e := range entries {
 		wrapped[i] = f.wrapDirEntry(ctx, name, e)
@@ -178,60 +270,86 @@ func (f *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, e
 	return wrapped, nil
 }
 
-func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
-	return contextual.Mkdir(ctx, f.fs, name, perm)
-}
-
-func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
-	return contextual.MkdirAll(ctx, f.fs, name, perm)
-}
-
-func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
-	return contextual.RemoveAll(ctx, f.fs, name)
-}
-
-func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
-	return contextual.Rename(ctx, f.fs, oldname, newname)
-}
-
-func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
-	return contextual.Symlink(ctx, f.fs, oldname, newname)
-}
-
-func (f *filesystem) ReadLink(ctx context.Context, name string) (string, error) {
-	return contextual.ReadLink(ctx, f.fs, name)
+// ReadDirInfos reads the named directory with the overridden attributes, so
+// that it agrees with ReadDir.
+func (f *filesystem) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	list, err := contextual.ReadDirInfos(ctx, f.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("readdir", name, err)
+	}
+	for i, e := range list {
+		child, err := contextual.JoinValid(name, e.Name())
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		list[i].FileInfo = f.wrapFileInfo(ctx, child, e.FileInfo)
+	}
+	return list, nil
 }
 
 func (f *filesystem) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
-	fi, err := contextual.Lstat(ctx, f.fs, name)
-	if err != nil {
+	if err := internal.CheckPath("lstat", name); err != nil {
 		return nil, err
 	}
+	fi, err := contextual.Lstat(ctx, f.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("lstat", name, err)
+	}
 	return f.wrapFileInfo(ctx, name, fi), nil
 }
 
 func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
-	return contextual.Lchown(ctx, f.fs, name, owner, group)
-}
-
-func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
-	return contextual.Truncate(ctx, f.fs, name, size)
-}
-
-func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
-	return contextual.WriteFile(ctx, f.fs, name, data, perm)
+	if err := internal.CheckPath("lchown", name); err != nil {
+		return err
+	}
+	owner, group, err := f.resolve(ctx, owner, group)
+	if err != nil {
+		return internal.Decorate("lchown", name, err)
+	}
+	return internal.Decorate("lchown", name, contextual.Lchown(ctx, f.Inner, name, owner, group))
 }
 
 func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
-	return contextual.Chown(ctx, f.fs, name, owner, group)
+	if err := internal.CheckPath("chown", name); err != nil {
+		return err
+	}
+	owner, group, err := f.resolve(ctx, owner, group)
+	if err != nil {
+		return internal.Decorate("chown", name, err)
+	}
+	return internal.Decorate("chown", name, contextual.Chown(ctx, f.Inner, name, owner, group))
 }
 
-func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
-	return contextual.Chmod(ctx, f.fs, name, mode)
+// resolve translates owner and group to the ids stored by the underlying
+// filesystem if a Resolver is set.
+func (f *filesystem) resolve(ctx context.Context, owner, group string) (string, string, error) {
+	if f.Resolver == nil {
+		return owner, group, nil
+	}
+	return contextual.TranslateOwner(ctx, f.Resolver, nil, owner, group)
 }
 
-func (f *filesystem) Chtimes(ctx context.Context, name string, atime, ctime time.Time) error {
-	return contextual.Chtimes(ctx, f.fs, name, atime, ctime)
+// Access checks the requested access against the overridden permission bits,
+// so that it agrees with the modes reported by Stat.
+func (f *filesystem) Access(ctx context.Context, name string, mode uint32) error {
+	if err := internal.CheckPath("access", name); err != nil {
+		return err
+	}
+	fi, err := f.Stat(ctx, name)
+	if err != nil {
+		return internal.Decorate("access", name, err)
+	}
+	if err := internal.CheckAccess(fi, mode); err != nil {
+		return &fs.PathError{Op: "access", Path: name, Err: err}
+	}
+	return nil
 }
 
 var _ contextual.FileSystem = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
+var _ contextual.AccessFS = &filesystem{}
+var _ contextual.ReadDirInfoFS = &filesystem{}
+var _ contextual.SpecialFS = &filesystem{}
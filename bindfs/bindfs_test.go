@@ -5,13 +5,18 @@ import (
 	"errors"
 	"io/fs"
 	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/bindfs"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
 	"github.com/gwangyi/fsx/mockfs"
 	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"github.com/gwangyi/fsx/osfs"
 	"go.uber.org/mock/gomock"
 )
 
@@ -526,3 +531,293 @@ func TestFileInfo_NoOverrides(t *testing.T) {
 		t.Errorf("Expected mode 0644, got %v", xfi.Mode())
 	}
 }
+
+func TestBindFS_Resolver(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockFS := cmockfs.NewMockFileSystem(ctrl)
+	mockFI := mockfs.NewMockFileInfo(ctrl)
+	ctx := t.Context()
+
+	fsys := bindfs.New(mockFS, bindfs.Config{Resolver: fsxtest.Resolver{
+		Users:  map[string]string{"alice": "1000"},
+		Groups: map[string]string{"staff": "50"},
+	}})
+
+	mockFS.EXPECT().Chown(ctx, "test.txt", "1000", "50").Return(nil)
+	if err := contextual.Chown(ctx, fsys, "test.txt", "alice", "staff"); err != nil {
+		t.Errorf("Chown failed: %v", err)
+	}
+	mockFS.EXPECT().Lchown(ctx, "test.txt", "1000", "").Return(nil)
+	if err := contextual.Lchown(ctx, fsys, "test.txt", "alice", ""); err != nil {
+		t.Errorf("Lchown failed: %v", err)
+	}
+	if err := contextual.Chown(ctx, fsys, "test.txt", "bob", ""); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist for an unknown user, got %v", err)
+	}
+
+	mockFS.EXPECT().Stat(ctx, "test.txt").Return(mockFI, nil)
+	fi, err := fsys.Stat(ctx, "test.txt")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	xfi := fi.(fsx.FileInfo)
+	mockFI.EXPECT().Owner().Return("1000")
+	if xfi.Owner() != "alice" {
+		t.Errorf("Expected owner alice, got %s", xfi.Owner())
+	}
+	// Unknown ids are reported as is.
+	mockFI.EXPECT().Group().Return("60").Times(2)
+	if xfi.Group() != "60" {
+		t.Errorf("Expected group 60, got %s", xfi.Group())
+	}
+}
+
+func TestBindFS_Lifetime(t *testing.T) {
+	for _, tc := range []struct {
+		lifetime contextual.Lifetime
+		want     error
+	}{
+		{contextual.Detached, nil},
+		{contextual.Bound, context.Canceled},
+	} {
+		t.Run(tc.lifetime.String(), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockFS := cmockfs.NewMockFileSystem(ctrl)
+			mockFI := mockfs.NewMockFileInfo(ctrl)
+
+			var got error
+			fsys := bindfs.New(mockFS, bindfs.Config{
+				Owner: func(ctx context.Context, name string) string {
+					got = ctx.Err()
+					return "alice"
+				},
+				Lifetime: tc.lifetime,
+			})
+
+			ctx, cancel := context.WithCancel(t.Context())
+			mockFS.EXPECT().Stat(ctx, "test.txt").Return(mockFI, nil)
+			fi, err := fsys.Stat(ctx, "test.txt")
+			if err != nil {
+				t.Fatalf("Stat failed: %v", err)
+			}
+			// The FileInfo is looked at once the request is over.
+			cancel()
+			if owner := fi.(fsx.FileInfo).Owner(); owner != "alice" || got != tc.want {
+				t.Errorf("Owner() = %q with context error %v; want alice with %v", owner, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBindFS_Umask(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockFS := cmockfs.NewMockFileSystem(ctrl)
+	fsys := bindfs.New(mockFS, bindfs.Config{})
+	ctx := contextual.WithUmask(t.Context(), 0o022)
+
+	mockFile := mockfs.NewMockFile(ctrl)
+	mockFS.EXPECT().OpenFile(ctx, "new.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, fs.FileMode(0o644)).Return(mockFile, nil)
+	if _, err := fsys.Create(ctx, "new.txt"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	mockFS.EXPECT().Mkdir(ctx, "dir", fs.FileMode(0o755)).Return(nil)
+	if err := fsys.Mkdir(ctx, "dir", 0o777); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+}
+
+func TestBindFS_Access(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockFS := cmockfs.NewMockFileSystem(ctrl)
+	ctx := t.Context()
+	fsys := bindfs.New(mockFS, bindfs.Config{
+		GrantPerm:  bindfs.Static(fs.FileMode(0100)),
+		RevokePerm: bindfs.Static(fs.FileMode(0200)),
+	})
+
+	mockFI := mockfs.NewMockFileInfo(ctrl)
+	mockFI.EXPECT().Mode().Return(fs.FileMode(0644)).AnyTimes()
+	mockFS.EXPECT().Stat(ctx, "test.txt").Return(mockFI, nil).Times(2)
+
+	if err := contextual.Access(ctx, fsys, "test.txt", fsx.R_OK|fsx.X_OK); err != nil {
+		t.Errorf("expected granted execute access, got %v", err)
+	}
+	if err := contextual.Access(ctx, fsys, "test.txt", fsx.W_OK); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected revoked write access, got %v", err)
+	}
+}
+
+// infoFS lists directories with their FileInfo through ReadDirInfos.
+type infoFS struct {
+	contextual.FS
+}
+
+func (i infoFS) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	return contextual.ReadDirInfos(ctx, i.FS, name)
+}
+
+func TestBindFS_ReadDirInfos(t *testing.T) {
+	ctx := t.Context()
+	fsys := bindfs.New(infoFS{contextual.ToContextual(fstest.MapFS{
+		"dir/f": {Mode: 0644},
+	})}, bindfs.Config{RevokePerm: bindfs.Static(fs.FileMode(0044))})
+
+	list, err := contextual.ReadDirInfos(ctx, fsys, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Mode().Perm() != 0600 {
+		t.Errorf("ReadDirInfos(dir) = %v; want f with the revoked bits cleared", list)
+	}
+}
+
+func TestBindFS_RenameWithOptions(t *testing.T) {
+	ctx := t.Context()
+	root, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := bindfs.New(contextual.ToContextual(root), bindfs.Config{})
+	if _, ok := fsys.(contextual.RenameOptionsFS); !ok {
+		t.Fatal("bindfs does not forward RenameWithOptions")
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := contextual.WriteFile(ctx, fsys, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := contextual.RenameWithOptions(ctx, fsys, "a", "b", fsx.RenameNoReplace); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("RenameWithOptions(noreplace) error = %v; want ErrExist", err)
+	}
+	if err := contextual.RenameWithOptions(ctx, fsys, "a", "b", fsx.RenameExchange); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := contextual.ReadFile(ctx, fsys, "a"); err != nil || string(data) != "b" {
+		t.Errorf("ReadFile(a) = %q, %v; want b", data, err)
+	}
+}
+
+func TestBindFS_AuditSafe(t *testing.T) {
+	ctx := t.Context()
+	type widened struct {
+		name    string
+		dropped fs.FileMode
+	}
+	var got []widened
+	fsys := bindfs.New(contextual.ToContextual(fstest.MapFS{
+		"script": {Mode: 0750},
+		"data":   {Mode: 0600},
+	}), bindfs.Config{
+		GrantPerm:  bindfs.Static(fs.FileMode(0755)),
+		RevokePerm: bindfs.Static(fs.FileMode(0010)),
+		AuditSafe:  true,
+		OnWiden: func(ctx context.Context, name string, dropped fs.FileMode) {
+			got = append(got, widened{name, dropped})
+		},
+	})
+
+	for name, want := range map[string]fs.FileMode{"script": 0740, "data": 0600} {
+		got = nil
+		fi, err := contextual.Stat(ctx, fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != want {
+			t.Errorf("%s: expected mode %v, got %v", name, want, perm)
+		}
+		if name == "script" && (len(got) != 1 || got[0].dropped != 0005) || name == "data" && (len(got) != 1 || got[0].dropped != 0155) {
+			t.Errorf("%s: unexpected widening reports %v", name, got)
+		}
+	}
+
+	got = nil
+	if err := contextual.Access(ctx, fsys, "data", fsx.X_OK); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected execute access to be refused, got %v", err)
+	}
+	if len(got) != 1 || got[0].name != "data" {
+		t.Errorf("expected the dropped grant of data to be reported, got %v", got)
+	}
+}
+
+func TestFileWrapper_ReadDir(t *testing.T) {
+	ctx := t.Context()
+	fsys := bindfs.New(contextual.ToContextual(fstest.MapFS{
+		"dir/a": {Mode: 0600},
+		"dir/b": {Mode: 0640},
+	}), bindfs.Config{
+		GrantPerm: bindfs.Static(fs.FileMode(0044)),
+		Owner:     bindfs.Static("alice"),
+	})
+
+	f, err := fsys.Open(ctx, "dir")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		t.Fatal("expected fs.ReadDirFile")
+	}
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		fi, err := e.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0644 {
+			t.Errorf("%s: expected mode 0644, got %v", e.Name(), fi.Mode().Perm())
+		}
+		if owner := fi.(fsx.FileInfo).Owner(); owner != "alice" {
+			t.Errorf("%s: expected owner alice, got %s", e.Name(), owner)
+		}
+	}
+}
+
+func TestBindFS_SendFile(t *testing.T) {
+	ctx := t.Context()
+	osFS, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := bindfs.New(contextual.ToContextual(osFS), bindfs.Config{Owner: bindfs.Static("alice")})
+	if err := contextual.WriteFile(ctx, fsys, "file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	result, err := contextual.SendFile(ctx, fsys, "file", &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Strategy != contextual.TransferWriterTo || b.String() != "data" {
+		t.Errorf("SendFile() = %v, %q; want the file to write itself", result.Strategy, b.String())
+	}
+}
+
+func TestBindFS_ErrorOps(t *testing.T) {
+	osFS, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsxtest.CheckErrorOps(t, bindfs.New(contextual.ToContextual(osFS), bindfs.Config{}), "missing")
+}
+
+func TestBindFS_InvalidPaths(t *testing.T) {
+	// Invalid names never reach the backend.
+	ctrl := gomock.NewController(t)
+	fsxtest.CheckInvalidPaths(t, bindfs.New(cmockfs.NewMockFileSystem(ctrl), bindfs.Config{}))
+}
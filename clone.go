@@ -0,0 +1,39 @@
+package fsx
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// CloneFile is the interface implemented by an open file that can duplicate
+// itself.
+//
+// Layers that read a file twice, such as hashing it and then serving it, can
+// clone the handle they already hold instead of opening the file again by
+// name, which would open another file if it was renamed or replaced in the
+// meantime.
+type CloneFile interface {
+	File
+
+	// Clone returns a new handle of the same file, opened with the same
+	// access mode. The new handle has its own offset, starting at the
+	// current offset of the file, and must be closed separately.
+	Clone() (File, error)
+}
+
+// Clone returns a new handle of the file opened as f, with its own offset
+// starting at the current offset of f.
+// If f implements CloneFile, it calls f.Clone. Otherwise, it returns an
+// error wrapping errors.ErrUnsupported, and the file must be opened again
+// by name. Layers wrapping the files of another file system hide its
+// CloneFile unless they implement it themselves.
+func Clone(f fs.File) (File, error) {
+	if cf, ok := f.(CloneFile); ok {
+		return cf.Clone()
+	}
+	name := ""
+	if info, err := f.Stat(); err == nil {
+		name = info.Name()
+	}
+	return nil, &fs.PathError{Op: "clone", Path: name, Err: errors.ErrUnsupported}
+}
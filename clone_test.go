@@ -0,0 +1,90 @@
+package fsx_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestClone(t *testing.T) {
+	t.Run("osfs", func(t *testing.T) {
+		fsys, err := osfs.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fsx.WriteFile(fsys, "file", []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fsys.Open("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatal(err)
+		}
+
+		// The clone keeps reading the same file once it was replaced.
+		if err := fsx.Rename(fsys, "file", "moved"); err != nil {
+			t.Fatal(err)
+		}
+		if err := fsx.WriteFile(fsys, "file", []byte("replaced"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		clone, err := fsx.Clone(f)
+		if err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				t.Skip("cloning is not supported on this platform")
+			}
+			t.Fatal(err)
+		}
+		defer func() { _ = clone.Close() }()
+		if data, err := io.ReadAll(clone); err != nil || string(data) != "456789" {
+			t.Errorf("clone read %q, %v, want 456789", data, err)
+		}
+		if info, err := clone.Stat(); err != nil || info.Name() != "file" {
+			t.Errorf("clone Stat() = %v, %v, want file", info, err)
+		}
+		// The offsets are independent.
+		if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "4567" {
+			t.Errorf("read %q, %v, want 4567", buf, err)
+		}
+		if _, err := clone.Write([]byte("x")); err == nil {
+			t.Error("clone of a read-only file is writable")
+		}
+
+		w, err := fsx.OpenFile(fsys, "moved", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = w.Close() }()
+		wc, err := fsx.Clone(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = wc.Close() }()
+		if _, err := wc.Write([]byte("ab")); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := fs.ReadFile(fsys, "moved"); err != nil || string(data) != "ab23456789" {
+			t.Errorf("ReadFile() = %q, %v, want ab23456789", data, err)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		f, err := fstest.MapFS{"file": {Data: []byte("data")}}.Open("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fsx.Clone(f); !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("Clone() = %v, want ErrUnsupported", err)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package fsx
+
+import (
+	"io"
+	"io/fs"
+)
+
+// CloserFS is the interface implemented by a file system that holds resources,
+// such as open handles, connections or background goroutines, which must be
+// released when the file system is no longer used.
+//
+// A file system wrapping others closes them too, so that closing the top of a
+// wrap chain releases everything beneath it. Closing a file system twice
+// should not fail, and a closed file system may fail further operations.
+type CloserFS interface {
+	fs.FS
+	io.Closer
+}
+
+// Close releases the resources held by fsys.
+// If fsys implements CloserFS, it calls fsys.Close.
+// Otherwise, there is nothing to release and it returns nil.
+func Close(fsys fs.FS) error {
+	if cfs, ok := fsys.(CloserFS); ok {
+		return cfs.Close()
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package fsx_test
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx"
+)
+
+// closerFS is a MapFS that records being closed.
+type closerFS struct {
+	fstest.MapFS
+	closed int
+	err    error
+}
+
+func (c *closerFS) Close() error {
+	c.closed++
+	return c.err
+}
+
+func TestClose(t *testing.T) {
+	t.Run("closer", func(t *testing.T) {
+		want := errors.New("close failed")
+		fsys := &closerFS{err: want}
+		if err := fsx.Close(fsys); !errors.Is(err, want) {
+			t.Errorf("expected %v, got %v", want, err)
+		}
+		if fsys.closed != 1 {
+			t.Errorf("Close called %d times, want 1", fsys.closed)
+		}
+	})
+
+	t.Run("not a closer", func(t *testing.T) {
+		if err := fsx.Close(fstest.MapFS{}); err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+	})
+}
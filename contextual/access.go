@@ -0,0 +1,37 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// AccessFS is the interface implemented by a file system that supports
+// context-aware permission checks.
+type AccessFS interface {
+	FS
+
+	// Access checks whether the caller identified by ctx can access the named
+	// file with the given mode, a combination of fsx.R_OK, fsx.W_OK and
+	// fsx.X_OK (or fsx.F_OK to check for existence only).
+	// If access is denied, the error wraps fs.ErrPermission.
+	Access(ctx context.Context, name string, mode uint32) error
+}
+
+// Access checks whether the caller can access the named file with the given mode.
+// If fsys implements AccessFS, it calls fsys.Access. Otherwise, or if that is
+// unsupported, the owner permission bits reported by Stat must grant the access.
+func Access(ctx context.Context, fsys FS, name string, mode uint32) error {
+	if afs, ok := fsys.(AccessFS); ok {
+		if err := afs.Access(ctx, name, mode); !errors.Is(err, errors.ErrUnsupported) {
+			return intoPathErr("access", name, err)
+		}
+	}
+
+	info, err := Stat(ctx, fsys, name)
+	if err != nil {
+		return intoPathErr("access", name, err)
+	}
+	return intoPathErr("access", name, internal.CheckAccess(info, mode))
+}
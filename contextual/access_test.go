@@ -0,0 +1,52 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/mockfs"
+	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAccess(t *testing.T) {
+	ctx := t.Context()
+	cfs := contextual.ToContextual(fstest.MapFS{
+		"ro":  {Mode: 0444},
+		"exe": {Mode: 0700},
+	})
+
+	if err := contextual.Access(ctx, cfs, "ro", fsx.R_OK); err != nil {
+		t.Errorf("expected read access, got %v", err)
+	}
+	if err := contextual.Access(ctx, cfs, "ro", fsx.W_OK); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected ErrPermission, got %v", err)
+	}
+	if err := contextual.Access(ctx, cfs, "exe", fsx.X_OK); err != nil {
+		t.Errorf("expected execute access, got %v", err)
+	}
+
+	// Without AccessFS the check is derived from Stat.
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := cmockfs.NewMockStatFS(ctrl)
+	info := mockfs.NewMockFileInfo(ctrl)
+	info.EXPECT().Mode().Return(fs.FileMode(0444)).AnyTimes()
+	m.EXPECT().Stat(ctx, "ro").Return(info, nil)
+	if err := contextual.Access(ctx, m, "ro", fsx.W_OK); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected ErrPermission, got %v", err)
+	}
+
+	// FromContextual exposes the check as fsx.AccessFS.
+	back := contextual.FromContextual(cfs, ctx)
+	if _, ok := back.(fsx.AccessFS); !ok {
+		t.Fatal("expected FromContextual to implement fsx.AccessFS")
+	}
+	if err := fsx.Access(back, "exe", fsx.R_OK|fsx.X_OK); err != nil {
+		t.Errorf("expected access through FromContextual, got %v", err)
+	}
+}
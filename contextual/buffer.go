@@ -0,0 +1,26 @@
+package contextual
+
+import "github.com/gwangyi/fsx/internal"
+
+// DefaultBufferSize is the size of the pooled buffers unless changed with
+// SetBufferSize.
+const DefaultBufferSize = internal.DefaultBufferSize
+
+// SetBufferSize sets the size of the buffers shared by the helpers and
+// layers of this module to copy file contents: SendFile, TransferFile,
+// ReadFileInto and the copy fallback of Rename, as well as the copy-ups of
+// unionfs and the copies of syncfs. OpenSeq uses them too when its chunk
+// size matches. Values less than 1 select DefaultBufferSize.
+//
+// The buffers are pooled, so that a copy allocates nothing once the pool is
+// warm. Larger buffers reduce the number of reads and writes of large copies
+// at the cost of memory per concurrent copy; services copying many small
+// files may prefer smaller ones. It is meant to be called once, at startup.
+func SetBufferSize(n int) {
+	internal.SetBufferSize(n)
+}
+
+// BufferSize returns the size of the pooled buffers.
+func BufferSize() int {
+	return internal.BufferSize()
+}
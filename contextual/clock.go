@@ -0,0 +1,55 @@
+package contextual
+
+import "time"
+
+// Clock is a source of time. Layers that expire or schedule things take a
+// Clock in their configuration, so that tests can drive time explicitly
+// instead of sleeping.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a Timer that sends the current time on its channel
+	// after at least duration d.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event scheduled by a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It reports whether the call
+	// stopped the timer.
+	Stop() bool
+	// Reset changes the timer to expire after duration d. It reports
+	// whether the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// RealClock is the Clock of the system, based on the time package.
+var RealClock Clock = realClock{}
+
+// ClockOr returns c, or RealClock if c is nil.
+func ClockOr(c Clock) Clock {
+	if c == nil {
+		return RealClock
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
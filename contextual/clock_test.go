@@ -0,0 +1,38 @@
+package contextual_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+)
+
+func TestClockOr(t *testing.T) {
+	if contextual.ClockOr(nil) != contextual.RealClock {
+		t.Error("ClockOr(nil) should be RealClock")
+	}
+	clock := fsxtest.NewClock(time.Time{})
+	if contextual.ClockOr(clock) != clock {
+		t.Error("ClockOr() should keep a clock")
+	}
+}
+
+func TestRealClock(t *testing.T) {
+	before := time.Now()
+	if now := contextual.RealClock.Now(); now.Before(before) {
+		t.Errorf("Now() = %v, before %v", now, before)
+	}
+
+	timer := contextual.RealClock.NewTimer(time.Millisecond)
+	<-timer.C()
+	if timer.Stop() {
+		t.Error("Stop() of a fired timer should report false")
+	}
+	if timer.Reset(time.Hour) {
+		t.Error("Reset() of a fired timer should report false")
+	}
+	if !timer.Stop() {
+		t.Error("Stop() of a reset timer should report true")
+	}
+}
@@ -0,0 +1,42 @@
+package contextual_test
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"github.com/gwangyi/fsx/osfs"
+	"go.uber.org/mock/gomock"
+)
+
+func TestClose(t *testing.T) {
+	t.Run("NotACloser", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		if err := contextual.Close(cmockfs.NewMockFS(ctrl)); err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+	})
+
+	t.Run("Propagates", func(t *testing.T) {
+		ctx := t.Context()
+		base, err := osfs.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Closing the outermost wrapper closes the OS root beneath.
+		fsys := contextual.FromContextual(contextual.ToContextual(base), ctx)
+		if err := fsx.Close(fsys); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if _, err := fs.Stat(base, "."); err == nil {
+			t.Error("expected Stat to fail on a closed filesystem")
+		}
+		if err := fsx.Close(fsys); err != nil {
+			t.Errorf("second Close failed: %v", err)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package contextual
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Coalesce returns a filesystem forwarding to fsys, like PassthroughFS,
+// whose Stat, Lstat, ReadLink and ReadDir share the call in flight for the
+// same name instead of issuing another one. It suits hot paths looking the
+// same names up concurrently above slow filesystems.
+//
+// Nothing is kept once a call returns, so a call never sees a result older
+// than the moment the call it joined started. A mutation made through the
+// returned filesystem detaches the calls in flight when it returns, so that
+// calls starting after it see its effect. Changes made behind its back, and
+// writes to files opened from it, are not tracked: a call starting while
+// another is in flight may miss them, as it would if it had been a little
+// faster.
+//
+// A call shared by several callers is canceled when all of them gave up.
+func Coalesce(fsys FS) FileSystem {
+	return &coalescingFS{
+		PassthroughFS: PassthroughFS{Inner: fsys},
+		calls:         make(map[coalesceKey]*coalescedCall),
+	}
+}
+
+// coalescingFS overrides the metadata methods of the embedded PassthroughFS
+// to share calls in flight, and its mutating methods to detach them.
+type coalescingFS struct {
+	PassthroughFS
+
+	mu    sync.Mutex
+	calls map[coalesceKey]*coalescedCall
+}
+
+// Unwrap returns the filesystem the calls are forwarded to.
+func (c *coalescingFS) Unwrap() FS {
+	return c.Inner
+}
+
+// coalesceOp identifies a coalesced operation.
+type coalesceOp uint8
+
+const (
+	coalesceStat coalesceOp = iota
+	coalesceLstat
+	coalesceReadLink
+	coalesceReadDir
+)
+
+// coalesceKey identifies the calls that can be shared.
+type coalesceKey struct {
+	op   coalesceOp
+	name string
+}
+
+// coalescedCall is a call in flight and the callers waiting for it.
+type coalescedCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	// The result, set before done is closed.
+	info    fs.FileInfo
+	link    string
+	entries []fs.DirEntry
+	err     error
+}
+
+// do waits for the call in flight for key, or starts one running fn.
+func (c *coalescingFS) do(ctx context.Context, key coalesceKey, fn func(ctx context.Context, call *coalescedCall)) (*coalescedCall, error) {
+	c.mu.Lock()
+	call, ok := c.calls[key]
+	if !ok {
+		// The call outlives the caller starting it if others joined it, so
+		// it only keeps the values of its context.
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		c.calls[key] = call
+		go func() {
+			defer cancel()
+			fn(callCtx, call)
+			c.mu.Lock()
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+			c.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+		}
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// detach makes the calls in flight unavailable to the calls starting from
+// now on. It is deferred by the mutating methods.
+func (c *coalescingFS) detach() {
+	c.mu.Lock()
+	clear(c.calls)
+	c.mu.Unlock()
+}
+
+// Stat returns a FileInfo describing the named file, sharing the call in
+// flight for it.
+func (c *coalescingFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("stat", name); err != nil {
+		return nil, err
+	}
+	call, err := c.do(ctx, coalesceKey{coalesceStat, name}, func(ctx context.Context, call *coalescedCall) {
+		call.info, call.err = c.PassthroughFS.Stat(ctx, name)
+	})
+	if err != nil {
+		return nil, internal.Decorate("stat", name, err)
+	}
+	return call.info, call.err
+}
+
+// Lstat returns a FileInfo describing the named file, without following
+// links, sharing the call in flight for it.
+func (c *coalescingFS) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("lstat", name); err != nil {
+		return nil, err
+	}
+	call, err := c.do(ctx, coalesceKey{coalesceLstat, name}, func(ctx context.Context, call *coalescedCall) {
+		call.info, call.err = c.PassthroughFS.Lstat(ctx, name)
+	})
+	if err != nil {
+		return nil, internal.Decorate("lstat", name, err)
+	}
+	return call.info, call.err
+}
+
+// ReadLink returns the destination of the named symbolic link, sharing the
+// call in flight for it.
+func (c *coalescingFS) ReadLink(ctx context.Context, name string) (string, error) {
+	if err := internal.CheckPath("readlink", name); err != nil {
+		return "", err
+	}
+	call, err := c.do(ctx, coalesceKey{coalesceReadLink, name}, func(ctx context.Context, call *coalescedCall) {
+		call.link, call.err = c.PassthroughFS.ReadLink(ctx, name)
+	})
+	if err != nil {
+		return "", internal.Decorate("readlink", name, err)
+	}
+	return call.link, call.err
+}
+
+// ReadDir reads the named directory, sharing the call in flight for it. The
+// returned slice is the caller's to change.
+func (c *coalescingFS) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	call, err := c.do(ctx, coalesceKey{coalesceReadDir, name}, func(ctx context.Context, call *coalescedCall) {
+		call.entries, call.err = c.PassthroughFS.ReadDir(ctx, name)
+	})
+	if err != nil {
+		return nil, internal.Decorate("readdir", name, err)
+	}
+	return slices.Clone(call.entries), call.err
+}
+
+func (c *coalescingFS) Create(ctx context.Context, name string) (File, error) {
+	defer c.detach()
+	return c.PassthroughFS.Create(ctx, name)
+}
+
+func (c *coalescingFS) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (File, error) {
+	defer c.detach()
+	return c.PassthroughFS.OpenFile(ctx, name, flag, mode)
+}
+
+func (c *coalescingFS) Remove(ctx context.Context, name string) error {
+	defer c.detach()
+	return c.PassthroughFS.Remove(ctx, name)
+}
+
+func (c *coalescingFS) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	defer c.detach()
+	return c.PassthroughFS.Mkdir(ctx, name, perm)
+}
+
+func (c *coalescingFS) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	defer c.detach()
+	return c.PassthroughFS.MkdirAll(ctx, name, perm)
+}
+
+func (c *coalescingFS) RemoveAll(ctx context.Context, name string) error {
+	defer c.detach()
+	return c.PassthroughFS.RemoveAll(ctx, name)
+}
+
+func (c *coalescingFS) Rename(ctx context.Context, oldname, newname string) error {
+	defer c.detach()
+	return c.PassthroughFS.Rename(ctx, oldname, newname)
+}
+
+func (c *coalescingFS) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	defer c.detach()
+	return c.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+}
+
+func (c *coalescingFS) Symlink(ctx context.Context, oldname, newname string) error {
+	defer c.detach()
+	return c.PassthroughFS.Symlink(ctx, oldname, newname)
+}
+
+func (c *coalescingFS) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	defer c.detach()
+	return c.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+}
+
+func (c *coalescingFS) Lchown(ctx context.Context, name, owner, group string) error {
+	defer c.detach()
+	return c.PassthroughFS.Lchown(ctx, name, owner, group)
+}
+
+func (c *coalescingFS) Chown(ctx context.Context, name, owner, group string) error {
+	defer c.detach()
+	return c.PassthroughFS.Chown(ctx, name, owner, group)
+}
+
+func (c *coalescingFS) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	defer c.detach()
+	return c.PassthroughFS.Chmod(ctx, name, mode)
+}
+
+func (c *coalescingFS) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	defer c.detach()
+	return c.PassthroughFS.Chtimes(ctx, name, atime, mtime)
+}
+
+func (c *coalescingFS) Truncate(ctx context.Context, name string, size int64) error {
+	defer c.detach()
+	return c.PassthroughFS.Truncate(ctx, name, size)
+}
+
+func (c *coalescingFS) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	defer c.detach()
+	return c.PassthroughFS.WriteFile(ctx, name, data, perm)
+}
+
+var _ FileSystem = &coalescingFS{}
+var _ SpecialFS = &coalescingFS{}
+var _ RenameOptionsFS = &coalescingFS{}
@@ -0,0 +1,180 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+// gatedFS counts the calls to Stat and ReadDir, and holds them until gate
+// is closed.
+type gatedFS struct {
+	contextual.FileSystem
+	gate    chan struct{}
+	entered chan struct{}
+	calls   atomic.Int32
+}
+
+func (f *gatedFS) wait(ctx context.Context) error {
+	f.calls.Add(1)
+	f.entered <- struct{}{}
+	select {
+	case <-f.gate:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *gatedFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.FileSystem.Stat(ctx, name)
+}
+
+func (f *gatedFS) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	return contextual.ReadDir(ctx, f.FileSystem, name)
+}
+
+// waitingContext signals on waiting when a caller starts waiting on it.
+type waitingContext struct {
+	context.Context
+	waiting chan<- struct{}
+}
+
+func (c waitingContext) Done() <-chan struct{} {
+	c.waiting <- struct{}{}
+	return c.Context.Done()
+}
+
+func newGatedFS(t *testing.T) *gatedFS {
+	t.Helper()
+	inner := memfs.New(memfs.Config{})
+	if err := contextual.WriteFile(t.Context(), inner, "file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &gatedFS{FileSystem: inner, gate: make(chan struct{}), entered: make(chan struct{}, 16)}
+}
+
+func TestCoalesce(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("shares calls in flight", func(t *testing.T) {
+		inner := newGatedFS(t)
+		fsys := contextual.Coalesce(inner)
+
+		first := make(chan error)
+		go func() {
+			_, err := fsys.ReadDir(ctx, ".")
+			first <- err
+		}()
+		<-inner.entered
+
+		waiting := make(chan struct{}, 3)
+		results := make(chan []fs.DirEntry, 3)
+		for range 3 {
+			go func() {
+				entries, _ := fsys.ReadDir(waitingContext{ctx, waiting}, ".")
+				results <- entries
+			}()
+		}
+		for range 3 {
+			<-waiting
+		}
+		close(inner.gate)
+		if err := <-first; err != nil {
+			t.Fatal(err)
+		}
+		for range 3 {
+			if entries := <-results; len(entries) != 1 || entries[0].Name() != "file" {
+				t.Errorf("ReadDir() = %v; want [file]", entries)
+			}
+		}
+		if n := inner.calls.Load(); n != 1 {
+			t.Errorf("expected 1 call, got %d", n)
+		}
+
+		// Nothing is kept once the call returned.
+		if _, err := fsys.ReadDir(ctx, "."); err != nil {
+			t.Fatal(err)
+		}
+		if n := inner.calls.Load(); n != 2 {
+			t.Errorf("expected a new call, got %d calls", n)
+		}
+	})
+
+	t.Run("mutations detach calls in flight", func(t *testing.T) {
+		inner := newGatedFS(t)
+		fsys := contextual.Coalesce(inner)
+
+		first := make(chan fs.FileInfo)
+		go func() {
+			info, _ := fsys.Stat(ctx, "file")
+			first <- info
+		}()
+		<-inner.entered
+		if err := fsys.WriteFile(ctx, "file", []byte("longer data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		second := make(chan fs.FileInfo)
+		go func() {
+			info, _ := fsys.Stat(ctx, "file")
+			second <- info
+		}()
+		// The call started after the write does not join the one before.
+		<-inner.entered
+		close(inner.gate)
+		<-first
+		if info := <-second; info == nil || info.Size() != int64(len("longer data")) {
+			t.Errorf("expected the written size, got %v", info)
+		}
+		if n := inner.calls.Load(); n != 2 {
+			t.Errorf("expected 2 calls, got %d", n)
+		}
+	})
+
+	t.Run("cancels when all callers gave up", func(t *testing.T) {
+		inner := newGatedFS(t)
+		fsys := contextual.Coalesce(inner)
+
+		cctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			_, err := fsys.Stat(cctx, "file")
+			done <- err
+		}()
+		<-inner.entered
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+
+		// A new call is started, rather than joining the abandoned one.
+		close(inner.gate)
+		if _, err := fsys.Stat(ctx, "file"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("checks names", func(t *testing.T) {
+		fsys := contextual.Coalesce(memfs.New(memfs.Config{}))
+		if _, err := fsys.Stat(ctx, "../x"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected fs.ErrInvalid, got %v", err)
+		}
+		if _, err := fsys.Lstat(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected fs.ErrNotExist, got %v", err)
+		}
+		if _, err := fsys.ReadLink(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected fs.ErrNotExist, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package contextual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Codec converts values to and from the bytes stored in a file.
+type Codec interface {
+	// Marshal returns the encoding of v.
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec is the Codec for JSON, based on encoding/json.
+// Marshal indents its output to keep files readable and diffable.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{".json": JSONCodec}
+)
+
+// RegisterCodec makes c the Codec used by ReadValue and WriteValue for files
+// whose name ends in ext (for example ".yaml"). The extension is matched
+// case-insensitively. Registering a nil codec removes the extension.
+// Only ".json" is registered by default; YAML, TOML and other formats can be
+// registered by the program with the library of its choice.
+func RegisterCodec(ext string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if c == nil {
+		delete(codecs, strings.ToLower(ext))
+		return
+	}
+	codecs[strings.ToLower(ext)] = c
+}
+
+// LookupCodec returns the Codec registered for the extension of name.
+func LookupCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[strings.ToLower(path.Ext(name))]
+	return c, ok
+}
+
+// ReadFileString reads the named file and returns its contents as a string.
+func ReadFileString(ctx context.Context, fsys FS, name string) (string, error) {
+	data, err := ReadFile(ctx, fsys, name)
+	return string(data), err
+}
+
+// WriteFileString writes s to the named file, creating it if necessary.
+func WriteFileString(ctx context.Context, fsys FS, name string, s string, perm fs.FileMode) error {
+	return WriteFile(ctx, fsys, name, []byte(s), perm)
+}
+
+// ReadWith reads the named file and decodes it into v using c.
+func ReadWith(ctx context.Context, fsys FS, name string, c Codec, v any) error {
+	data, err := ReadFile(ctx, fsys, name)
+	if err != nil {
+		return err
+	}
+	return intoPathErr("unmarshal", name, c.Unmarshal(data, v))
+}
+
+// WriteWith encodes v using c and writes it to the named file with
+// WriteFileAtomic, so that readers never observe a partially written value.
+func WriteWith(ctx context.Context, fsys FS, name string, c Codec, v any, perm fs.FileMode) error {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return intoPathErr("marshal", name, err)
+	}
+	return WriteFileAtomic(ctx, fsys, name, data, perm)
+}
+
+// ReadJSON reads the named file and decodes its JSON contents into v.
+func ReadJSON(ctx context.Context, fsys FS, name string, v any) error {
+	return ReadWith(ctx, fsys, name, JSONCodec, v)
+}
+
+// WriteJSON encodes v as JSON and atomically writes it to the named file.
+func WriteJSON(ctx context.Context, fsys FS, name string, v any, perm fs.FileMode) error {
+	return WriteWith(ctx, fsys, name, JSONCodec, v, perm)
+}
+
+// ReadValue reads the named file and decodes it into v using the Codec
+// registered for its extension. If there is none, it returns an error
+// wrapping errors.ErrUnsupported.
+func ReadValue(ctx context.Context, fsys FS, name string, v any) error {
+	c, ok := LookupCodec(name)
+	if !ok {
+		return intoPathErr("unmarshal", name, errors.ErrUnsupported)
+	}
+	return ReadWith(ctx, fsys, name, c, v)
+}
+
+// WriteValue encodes v using the Codec registered for the extension of name
+// and atomically writes it to the named file. If there is no such codec, it
+// returns an error wrapping errors.ErrUnsupported.
+func WriteValue(ctx context.Context, fsys FS, name string, v any, perm fs.FileMode) error {
+	c, ok := LookupCodec(name)
+	if !ok {
+		return intoPathErr("marshal", name, errors.ErrUnsupported)
+	}
+	return WriteWith(ctx, fsys, name, c, v, perm)
+}
@@ -0,0 +1,166 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// upperCodec is a toy Codec that stores strings in upper case.
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	return []byte(strings.ToUpper(*v.(*string))), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	*v.(*string) = strings.ToLower(string(data))
+	return nil
+}
+
+// racingFS creates the file racing, holding "theirs", whenever a file is
+// created exclusively, as another writer would.
+type racingFS struct {
+	contextual.FileSystem
+	racing string
+}
+
+func (r racingFS) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (contextual.File, error) {
+	f, err := r.FileSystem.OpenFile(ctx, name, flag, mode)
+	if err == nil && flag&os.O_EXCL != 0 {
+		err = contextual.WriteFile(ctx, r.FileSystem, r.racing, []byte("theirs"), 0644)
+	}
+	return f, err
+}
+
+func TestCodecs(t *testing.T) {
+	ctx := t.Context()
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfs := contextual.ToContextual(fsys)
+
+	t.Run("String", func(t *testing.T) {
+		if err := contextual.WriteFileString(ctx, cfs, "s.txt", "hello", 0644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := contextual.ReadFileString(ctx, cfs, "s.txt")
+		if err != nil || s != "hello" {
+			t.Errorf("ReadFileString() = %q, %v", s, err)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		type config struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		}
+		in := config{Name: "fsx", Count: 3}
+		if err := contextual.WriteJSON(ctx, cfs, "config.json", in, 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out config
+		if err := contextual.ReadJSON(ctx, cfs, "config.json", &out); err != nil {
+			t.Fatal(err)
+		}
+		if out != in {
+			t.Errorf("ReadJSON() = %+v, want %+v", out, in)
+		}
+
+		entries, err := contextual.ReadDir(ctx, cfs, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if strings.Contains(e.Name(), ".tmp") {
+				t.Errorf("temporary file left behind: %s", e.Name())
+			}
+		}
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		if err := contextual.WriteFileString(ctx, cfs, "bad.json", "{", 0644); err != nil {
+			t.Fatal(err)
+		}
+		var v map[string]any
+		err := contextual.ReadJSON(ctx, cfs, "bad.json", &v)
+		var pErr *fs.PathError
+		if !errors.As(err, &pErr) || pErr.Op != "unmarshal" {
+			t.Errorf("expected unmarshal error, got %v", err)
+		}
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		contextual.RegisterCodec(".UP", upperCodec{})
+		defer contextual.RegisterCodec(".up", nil)
+
+		in := "value"
+		if err := contextual.WriteValue(ctx, cfs, "v.up", &in, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if s, _ := contextual.ReadFileString(ctx, cfs, "v.up"); s != "VALUE" {
+			t.Errorf("unexpected contents %q", s)
+		}
+		var out string
+		if err := contextual.ReadValue(ctx, cfs, "v.up", &out); err != nil || out != "value" {
+			t.Errorf("ReadValue() = %q, %v", out, err)
+		}
+
+		if err := contextual.WriteValue(ctx, cfs, "v.unknown", &in, 0644); !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported, got %v", err)
+		}
+	})
+
+	t.Run("Atomic replace", func(t *testing.T) {
+		if err := contextual.WriteFileAtomic(ctx, cfs, "a.txt", []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFileAtomic(ctx, cfs, "a.txt", []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if s, _ := contextual.ReadFileString(ctx, cfs, "a.txt"); s != "new" {
+			t.Errorf("unexpected contents %q", s)
+		}
+		if err := contextual.WriteFileAtomic(ctx, cfs, "missing/a.txt", nil, 0644); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected ErrNotExist, got %v", err)
+		}
+
+		// A file created meanwhile is not replaced.
+		racing := racingFS{FileSystem: cfs.(contextual.FileSystem), racing: "c.txt"}
+		if err := contextual.WriteFileAtomic(ctx, racing, "c.txt", []byte("ours"), 0644); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("expected ErrExist, got %v", err)
+		}
+		if s, _ := contextual.ReadFileString(ctx, cfs, "c.txt"); s != "theirs" {
+			t.Errorf("unexpected contents %q", s)
+		}
+	})
+
+	t.Run("Atomic create", func(t *testing.T) {
+		if err := contextual.CreateFileAtomic(ctx, cfs, "b.txt", []byte("first"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.CreateFileAtomic(ctx, cfs, "b.txt", []byte("second"), 0644); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("expected ErrExist, got %v", err)
+		}
+		if s, _ := contextual.ReadFileString(ctx, cfs, "b.txt"); s != "first" {
+			t.Errorf("unexpected contents %q", s)
+		}
+		// The temporary file of the failed attempt is removed.
+		entries, err := contextual.ReadDir(ctx, cfs, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), ".b.txt") {
+				t.Errorf("leftover temporary file %s", e.Name())
+			}
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package contextual
+
+import (
+	"context"
+	"io"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// contextIOKey marks the context of an OpenFileWithContextIO call.
+type contextIOKey struct{}
+
+// OpenFileWithContextIO is like OpenFile, but ties the I/O on the returned
+// file to ctx: once ctx is done, Read, Write, Truncate, ReadAt and ReadDir
+// fail with an *fs.PathError wrapping the error of ctx. Closing the file
+// still works.
+//
+// The filesystems opening the file learn from IOContext that ctx governs its
+// I/O, so that the requests they make on behalf of the file, such as those
+// of a network-backed file, are canceled with ctx too.
+func OpenFileWithContextIO(ctx context.Context, fsys FS, name string, flag int, mode fs.FileMode) (File, error) {
+	ctx = context.WithValue(ctx, contextIOKey{}, true)
+	f, err := OpenFile(ctx, fsys, name, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	return internal.WrapFile(&contextFile{File: f, ctx: ctx, name: name}, f), nil
+}
+
+// IOContext returns the context to use for the I/O of a file opened with
+// ctx. It is ctx itself if the file is opened by OpenFileWithContextIO.
+// Otherwise, since the file may outlive ctx, it is a context carrying the
+// values of ctx that is never canceled, which filesystems use instead of
+// context.Background().
+func IOContext(ctx context.Context) context.Context {
+	if ctx.Value(contextIOKey{}) != nil {
+		return ctx
+	}
+	return context.WithoutCancel(ctx)
+}
+
+// contextFile checks its context before each I/O call.
+type contextFile struct {
+	File
+	ctx  context.Context
+	name string
+}
+
+// check returns the error of op if the context of the file is done.
+func (f *contextFile) check(op string) error {
+	if err := f.ctx.Err(); err != nil {
+		return &fs.PathError{Op: op, Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *contextFile) Read(p []byte) (int, error) {
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *contextFile) Write(p []byte) (int, error) {
+	if err := f.check("write"); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *contextFile) Truncate(size int64) error {
+	if err := f.check("truncate"); err != nil {
+		return err
+	}
+	return f.File.Truncate(size)
+}
+
+func (f *contextFile) Restat() (fs.FileInfo, error) {
+	if err := f.check("stat"); err != nil {
+		return nil, err
+	}
+	return internal.Restat(f.File)
+}
+
+// ReadAt is only exposed, through internal.WrapFile, if the file implements
+// io.ReaderAt.
+func (f *contextFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	return f.File.(io.ReaderAt).ReadAt(p, off)
+}
+
+// ReadDir is only exposed, through internal.WrapFile, if the file implements
+// fs.ReadDirFile.
+func (f *contextFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if err := f.check("readdir"); err != nil {
+		return nil, err
+	}
+	return f.File.(fs.ReadDirFile).ReadDir(n)
+}
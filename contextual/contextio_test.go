@@ -0,0 +1,81 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// ioContextFS records the I/O context of the files it opens.
+type ioContextFS struct {
+	contextual.PassthroughFS
+	ioCtx *context.Context
+}
+
+func (i ioContextFS) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (contextual.File, error) {
+	*i.ioCtx = contextual.IOContext(ctx)
+	return i.PassthroughFS.OpenFile(ctx, name, flag, mode)
+}
+
+func TestOpenFileWithContextIO(t *testing.T) {
+	root, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ioCtx context.Context
+	fsys := ioContextFS{contextual.PassthroughFS{Inner: contextual.ToContextual(root)}, &ioCtx}
+	if err := contextual.WriteFile(t.Context(), fsys, "file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("tied", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		f, err := contextual.OpenFileWithContextIO(ctx, fsys, "file", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("OpenFileWithContextIO: %v", err)
+		}
+		if ioCtx.Done() == nil {
+			t.Error("IOContext is not canceled with the context of the open call")
+		}
+		buf := make([]byte, 2)
+		if _, err := f.Read(buf); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+
+		cancel()
+		if _, err := f.Read(buf); !errors.Is(err, context.Canceled) {
+			t.Errorf("Read error = %v; want Canceled", err)
+		}
+		if _, err := f.Write(buf); !errors.Is(err, context.Canceled) {
+			t.Errorf("Write error = %v; want Canceled", err)
+		}
+		var pathErr *fs.PathError
+		if err := f.Truncate(0); !errors.As(err, &pathErr) || pathErr.Op != "truncate" || !errors.Is(err, context.Canceled) {
+			t.Errorf("Truncate error = %v; want a truncate PathError wrapping Canceled", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+
+	t.Run("untied", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		f, err := contextual.OpenFile(ctx, fsys, "file", os.O_RDONLY, 0)
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		defer func() { _ = f.Close() }()
+		cancel()
+		if ioCtx.Err() != nil || ioCtx.Done() != nil {
+			t.Error("IOContext of a plain open call is canceled with it")
+		}
+		if _, err := f.Read(make([]byte, 2)); err != nil {
+			t.Errorf("Read after cancel: %v", err)
+		}
+	})
+}
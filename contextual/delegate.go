@@ -0,0 +1,137 @@
+package contextual
+
+//go:generate go run ../internal/gendelegate -spec delegate.go -out delegate_gen.go
+
+import (
+	"context"
+	"io/fs"
+	"time"
+
+	"github.com/gwangyi/fsx"
+)
+
+// delegated is the single definition of the methods forwarded by the
+// delegating types of this package, generated into delegate_gen.go so that
+// the contextual and non-contextual surfaces stay in lockstep. Each method
+// is forwarded to the helper of this package by the same name.
+//
+// The //delegate:implements directive names the fsx or io/fs interface the
+// method implements for nonContextualFS, the non-contextual FS returned by
+// FromContextual, which calls the helper with its context. The
+// //delegate:passthrough directive names the operation reported by the
+// errors of the method of PassthroughFS, which checks the names before
+// calling the helper with Inner; methods without it are not forwarded by
+// PassthroughFS. The doc comments are those of the methods of PassthroughFS.
+//
+// Methods needing more than forwarding, such as Open, are written by hand.
+type delegated interface {
+	// Create creates or truncates the named file.
+	//delegate:implements fsx.WriterFS
+	//delegate:passthrough open
+	Create(ctx context.Context, name string) (File, error)
+	// OpenFile is the generalized open call.
+	//delegate:implements fsx.WriterFS
+	//delegate:passthrough open
+	OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (File, error)
+	// Remove removes the named file or (empty) directory.
+	//delegate:implements fsx.WriterFS
+	//delegate:passthrough remove
+	Remove(ctx context.Context, name string) error
+	// ReadFile reads the named file and returns its contents.
+	//delegate:implements fs.ReadFileFS
+	//delegate:passthrough readfile
+	ReadFile(ctx context.Context, name string) ([]byte, error)
+	// Stat returns a FileInfo describing the named file.
+	//delegate:implements fs.StatFS
+	//delegate:passthrough stat
+	Stat(ctx context.Context, name string) (fs.FileInfo, error)
+	// ReadDir reads the named directory and returns a list of directory
+	// entries.
+	//delegate:implements fs.ReadDirFS
+	//delegate:passthrough readdir
+	ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error)
+	// Mkdir creates a new directory.
+	//delegate:implements fsx.DirFS
+	//delegate:passthrough mkdir
+	Mkdir(ctx context.Context, name string, perm fs.FileMode) error
+	// MkdirAll creates a directory and all necessary parents.
+	//delegate:implements fsx.MkdirAllFS
+	//delegate:passthrough mkdir
+	MkdirAll(ctx context.Context, name string, perm fs.FileMode) error
+	// RemoveAll removes path and any children it contains.
+	//delegate:implements fsx.RemoveAllFS
+	//delegate:passthrough removeall
+	RemoveAll(ctx context.Context, name string) error
+	// Rename renames a file.
+	//delegate:implements fsx.RenameFS
+	//delegate:passthrough rename
+	Rename(ctx context.Context, oldname, newname string) error
+	// RenameWithOptions renames a file as directed by flags.
+	//delegate:implements fsx.RenameOptionsFS
+	RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error
+	// Symlink creates newname as a symbolic link to oldname.
+	//delegate:implements fsx.SymlinkFS
+	//delegate:passthrough symlink
+	Symlink(ctx context.Context, oldname, newname string) error
+	// ReadLink returns the destination of the named symbolic link.
+	//delegate:implements fs.ReadLinkFS
+	//delegate:passthrough readlink
+	ReadLink(ctx context.Context, name string) (string, error)
+	// Lstat returns a FileInfo describing the named file, without following
+	// links.
+	//delegate:implements fs.ReadLinkFS
+	//delegate:passthrough lstat
+	Lstat(ctx context.Context, name string) (fs.FileInfo, error)
+	// Lchown changes the owner and group of the named file, without
+	// following links.
+	//delegate:implements fsx.LchownFS
+	//delegate:passthrough lchown
+	Lchown(ctx context.Context, name, owner, group string) error
+	// Truncate changes the size of the named file.
+	//delegate:implements fsx.TruncateFS
+	//delegate:passthrough truncate
+	Truncate(ctx context.Context, name string, size int64) error
+	// WriteFile writes data to the named file, creating it if necessary.
+	//delegate:implements fsx.WriteFileFS
+	//delegate:passthrough writefile
+	WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error
+	// Chown changes the owner and group of the named file.
+	//delegate:implements fsx.ChangeFS
+	//delegate:passthrough chown
+	Chown(ctx context.Context, name, owner, group string) error
+	// Chmod changes the mode of the named file.
+	//delegate:implements fsx.ChangeFS
+	//delegate:passthrough chmod
+	Chmod(ctx context.Context, name string, mode fs.FileMode) error
+	// Chtimes changes the access and modification times of the named file.
+	//delegate:implements fsx.ChangeFS
+	//delegate:passthrough chtimes
+	Chtimes(ctx context.Context, name string, atime, mtime time.Time) error
+	// Access checks whether the named file can be accessed with mode.
+	//delegate:implements fsx.AccessFS
+	Access(ctx context.Context, name string, mode uint32) error
+	// CreateSpecial creates a special file.
+	//delegate:implements fsx.SpecialFS
+	//delegate:passthrough mknod
+	CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error
+	// GetXattr returns the value of the extended attribute attr of the
+	// named file.
+	//delegate:implements fsx.XattrFS
+	//delegate:passthrough getxattr
+	GetXattr(ctx context.Context, name, attr string) ([]byte, error)
+	// SetXattr sets the extended attribute attr of the named file.
+	//delegate:implements fsx.XattrFS
+	//delegate:passthrough setxattr
+	SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error
+	// ListXattr lists the extended attributes of the named file.
+	//delegate:implements fsx.XattrFS
+	//delegate:passthrough listxattr
+	ListXattr(ctx context.Context, name string) ([]string, error)
+	// RemoveXattr removes the extended attribute attr of the named file.
+	//delegate:implements fsx.XattrFS
+	//delegate:passthrough removexattr
+	RemoveXattr(ctx context.Context, name, attr string) error
+	// Glob returns the names of all files matching pattern.
+	//delegate:implements fs.GlobFS
+	Glob(ctx context.Context, pattern string) ([]string, error)
+}
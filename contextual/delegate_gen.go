@@ -0,0 +1,379 @@
+// Code generated by gendelegate from delegate.go. DO NOT EDIT.
+
+package contextual
+
+import (
+	"context"
+	"io/fs"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Create creates or truncates the named file.
+func (p PassthroughFS) Create(ctx context.Context, name string) (File, error) {
+	if err := internal.CheckPath("open", name); err != nil {
+		return nil, err
+	}
+	v, err := Create(ctx, p.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return v, nil
+}
+
+// OpenFile is the generalized open call.
+func (p PassthroughFS) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (File, error) {
+	if err := internal.CheckOpen(name, flag); err != nil {
+		return nil, err
+	}
+	v, err := OpenFile(ctx, p.Inner, name, flag, mode)
+	if err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return v, nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (p PassthroughFS) Remove(ctx context.Context, name string) error {
+	if err := internal.CheckPath("remove", name); err != nil {
+		return err
+	}
+	return internal.Decorate("remove", name, Remove(ctx, p.Inner, name))
+}
+
+// ReadFile reads the named file and returns its contents.
+func (p PassthroughFS) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := internal.CheckPath("readfile", name); err != nil {
+		return nil, err
+	}
+	v, err := ReadFile(ctx, p.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("readfile", name, err)
+	}
+	return v, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (p PassthroughFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("stat", name); err != nil {
+		return nil, err
+	}
+	v, err := Stat(ctx, p.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("stat", name, err)
+	}
+	return v, nil
+}
+
+// ReadDir reads the named directory and returns a list of directory
+// entries.
+func (p PassthroughFS) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	v, err := ReadDir(ctx, p.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("readdir", name, err)
+	}
+	return v, nil
+}
+
+// Mkdir creates a new directory.
+func (p PassthroughFS) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := internal.CheckPath("mkdir", name); err != nil {
+		return err
+	}
+	return internal.Decorate("mkdir", name, Mkdir(ctx, p.Inner, name, perm))
+}
+
+// MkdirAll creates a directory and all necessary parents.
+func (p PassthroughFS) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := internal.CheckPath("mkdir", name); err != nil {
+		return err
+	}
+	return internal.Decorate("mkdir", name, MkdirAll(ctx, p.Inner, name, perm))
+}
+
+// RemoveAll removes path and any children it contains.
+func (p PassthroughFS) RemoveAll(ctx context.Context, name string) error {
+	if err := internal.CheckPath("removeall", name); err != nil {
+		return err
+	}
+	return internal.Decorate("removeall", name, RemoveAll(ctx, p.Inner, name))
+}
+
+// Rename renames a file.
+func (p PassthroughFS) Rename(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("rename", oldname, newname); err != nil {
+		return err
+	}
+	return internal.DecorateLink("rename", oldname, newname, Rename(ctx, p.Inner, oldname, newname))
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (p PassthroughFS) Symlink(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("symlink", oldname, newname); err != nil {
+		return err
+	}
+	return internal.DecorateLink("symlink", oldname, newname, Symlink(ctx, p.Inner, oldname, newname))
+}
+
+// ReadLink returns the destination of the named symbolic link.
+func (p PassthroughFS) ReadLink(ctx context.Context, name string) (string, error) {
+	if err := internal.CheckPath("readlink", name); err != nil {
+		return "", err
+	}
+	v, err := ReadLink(ctx, p.Inner, name)
+	if err != nil {
+		return "", internal.Decorate("readlink", name, err)
+	}
+	return v, nil
+}
+
+// Lstat returns a FileInfo describing the named file, without following
+// links.
+func (p PassthroughFS) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("lstat", name); err != nil {
+		return nil, err
+	}
+	v, err := Lstat(ctx, p.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("lstat", name, err)
+	}
+	return v, nil
+}
+
+// Lchown changes the owner and group of the named file, without
+// following links.
+func (p PassthroughFS) Lchown(ctx context.Context, name, owner, group string) error {
+	if err := internal.CheckPath("lchown", name); err != nil {
+		return err
+	}
+	return internal.Decorate("lchown", name, Lchown(ctx, p.Inner, name, owner, group))
+}
+
+// Truncate changes the size of the named file.
+func (p PassthroughFS) Truncate(ctx context.Context, name string, size int64) error {
+	if err := internal.CheckPath("truncate", name); err != nil {
+		return err
+	}
+	return internal.Decorate("truncate", name, Truncate(ctx, p.Inner, name, size))
+}
+
+// WriteFile writes data to the named file, creating it if necessary.
+func (p PassthroughFS) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	if err := internal.CheckPath("writefile", name); err != nil {
+		return err
+	}
+	return internal.Decorate("writefile", name, WriteFile(ctx, p.Inner, name, data, perm))
+}
+
+// Chown changes the owner and group of the named file.
+func (p PassthroughFS) Chown(ctx context.Context, name, owner, group string) error {
+	if err := internal.CheckPath("chown", name); err != nil {
+		return err
+	}
+	return internal.Decorate("chown", name, Chown(ctx, p.Inner, name, owner, group))
+}
+
+// Chmod changes the mode of the named file.
+func (p PassthroughFS) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	if err := internal.CheckPath("chmod", name); err != nil {
+		return err
+	}
+	return internal.Decorate("chmod", name, Chmod(ctx, p.Inner, name, mode))
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (p PassthroughFS) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	if err := internal.CheckPath("chtimes", name); err != nil {
+		return err
+	}
+	return internal.Decorate("chtimes", name, Chtimes(ctx, p.Inner, name, atime, mtime))
+}
+
+// CreateSpecial creates a special file.
+func (p PassthroughFS) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	if err := internal.CheckPath("mknod", name); err != nil {
+		return err
+	}
+	return internal.Decorate("mknod", name, CreateSpecial(ctx, p.Inner, name, mode, dev))
+}
+
+// GetXattr returns the value of the extended attribute attr of the
+// named file.
+func (p PassthroughFS) GetXattr(ctx context.Context, name, attr string) ([]byte, error) {
+	if err := internal.CheckPath("getxattr", name); err != nil {
+		return nil, err
+	}
+	v, err := GetXattr(ctx, p.Inner, name, attr)
+	if err != nil {
+		return nil, internal.Decorate("getxattr", name, err)
+	}
+	return v, nil
+}
+
+// SetXattr sets the extended attribute attr of the named file.
+func (p PassthroughFS) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	if err := internal.CheckPath("setxattr", name); err != nil {
+		return err
+	}
+	return internal.Decorate("setxattr", name, SetXattr(ctx, p.Inner, name, attr, value, flags))
+}
+
+// ListXattr lists the extended attributes of the named file.
+func (p PassthroughFS) ListXattr(ctx context.Context, name string) ([]string, error) {
+	if err := internal.CheckPath("listxattr", name); err != nil {
+		return nil, err
+	}
+	v, err := ListXattr(ctx, p.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("listxattr", name, err)
+	}
+	return v, nil
+}
+
+// RemoveXattr removes the extended attribute attr of the named file.
+func (p PassthroughFS) RemoveXattr(ctx context.Context, name, attr string) error {
+	if err := internal.CheckPath("removexattr", name); err != nil {
+		return err
+	}
+	return internal.Decorate("removexattr", name, RemoveXattr(ctx, p.Inner, name, attr))
+}
+
+// Create implements fsx.WriterFS.
+func (n *nonContextualFS) Create(name string) (File, error) {
+	return Create(n.ctx, n.fsys, name)
+}
+
+// OpenFile implements fsx.WriterFS.
+func (n *nonContextualFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
+	return OpenFile(n.ctx, n.fsys, name, flag, mode)
+}
+
+// Remove implements fsx.WriterFS.
+func (n *nonContextualFS) Remove(name string) error {
+	return Remove(n.ctx, n.fsys, name)
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (n *nonContextualFS) ReadFile(name string) ([]byte, error) {
+	return ReadFile(n.ctx, n.fsys, name)
+}
+
+// Stat implements fs.StatFS.
+func (n *nonContextualFS) Stat(name string) (fs.FileInfo, error) {
+	return Stat(n.ctx, n.fsys, name)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (n *nonContextualFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return ReadDir(n.ctx, n.fsys, name)
+}
+
+// Mkdir implements fsx.DirFS.
+func (n *nonContextualFS) Mkdir(name string, perm fs.FileMode) error {
+	return Mkdir(n.ctx, n.fsys, name, perm)
+}
+
+// MkdirAll implements fsx.MkdirAllFS.
+func (n *nonContextualFS) MkdirAll(name string, perm fs.FileMode) error {
+	return MkdirAll(n.ctx, n.fsys, name, perm)
+}
+
+// RemoveAll implements fsx.RemoveAllFS.
+func (n *nonContextualFS) RemoveAll(name string) error {
+	return RemoveAll(n.ctx, n.fsys, name)
+}
+
+// Rename implements fsx.RenameFS.
+func (n *nonContextualFS) Rename(oldname, newname string) error {
+	return Rename(n.ctx, n.fsys, oldname, newname)
+}
+
+// RenameWithOptions implements fsx.RenameOptionsFS.
+func (n *nonContextualFS) RenameWithOptions(oldname, newname string, flags fsx.RenameFlags) error {
+	return RenameWithOptions(n.ctx, n.fsys, oldname, newname, flags)
+}
+
+// Symlink implements fsx.SymlinkFS.
+func (n *nonContextualFS) Symlink(oldname, newname string) error {
+	return Symlink(n.ctx, n.fsys, oldname, newname)
+}
+
+// ReadLink implements fs.ReadLinkFS.
+func (n *nonContextualFS) ReadLink(name string) (string, error) {
+	return ReadLink(n.ctx, n.fsys, name)
+}
+
+// Lstat implements fs.ReadLinkFS.
+func (n *nonContextualFS) Lstat(name string) (fs.FileInfo, error) {
+	return Lstat(n.ctx, n.fsys, name)
+}
+
+// Lchown implements fsx.LchownFS.
+func (n *nonContextualFS) Lchown(name, owner, group string) error {
+	return Lchown(n.ctx, n.fsys, name, owner, group)
+}
+
+// Truncate implements fsx.TruncateFS.
+func (n *nonContextualFS) Truncate(name string, size int64) error {
+	return Truncate(n.ctx, n.fsys, name, size)
+}
+
+// WriteFile implements fsx.WriteFileFS.
+func (n *nonContextualFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return WriteFile(n.ctx, n.fsys, name, data, perm)
+}
+
+// Chown implements fsx.ChangeFS.
+func (n *nonContextualFS) Chown(name, owner, group string) error {
+	return Chown(n.ctx, n.fsys, name, owner, group)
+}
+
+// Chmod implements fsx.ChangeFS.
+func (n *nonContextualFS) Chmod(name string, mode fs.FileMode) error {
+	return Chmod(n.ctx, n.fsys, name, mode)
+}
+
+// Chtimes implements fsx.ChangeFS.
+func (n *nonContextualFS) Chtimes(name string, atime, mtime time.Time) error {
+	return Chtimes(n.ctx, n.fsys, name, atime, mtime)
+}
+
+// Access implements fsx.AccessFS.
+func (n *nonContextualFS) Access(name string, mode uint32) error {
+	return Access(n.ctx, n.fsys, name, mode)
+}
+
+// CreateSpecial implements fsx.SpecialFS.
+func (n *nonContextualFS) CreateSpecial(name string, mode fs.FileMode, dev uint64) error {
+	return CreateSpecial(n.ctx, n.fsys, name, mode, dev)
+}
+
+// GetXattr implements fsx.XattrFS.
+func (n *nonContextualFS) GetXattr(name, attr string) ([]byte, error) {
+	return GetXattr(n.ctx, n.fsys, name, attr)
+}
+
+// SetXattr implements fsx.XattrFS.
+func (n *nonContextualFS) SetXattr(name, attr string, value []byte, flags int) error {
+	return SetXattr(n.ctx, n.fsys, name, attr, value, flags)
+}
+
+// ListXattr implements fsx.XattrFS.
+func (n *nonContextualFS) ListXattr(name string) ([]string, error) {
+	return ListXattr(n.ctx, n.fsys, name)
+}
+
+// RemoveXattr implements fsx.XattrFS.
+func (n *nonContextualFS) RemoveXattr(name, attr string) error {
+	return RemoveXattr(n.ctx, n.fsys, name, attr)
+}
+
+// Glob implements fs.GlobFS.
+func (n *nonContextualFS) Glob(pattern string) ([]string, error) {
+	return Glob(n.ctx, n.fsys, pattern)
+}
@@ -5,8 +5,9 @@ import (
 	"errors"
 	"io/fs"
 	"path"
-	"sort"
 	"syscall"
+
+	"github.com/gwangyi/fsx"
 )
 
 // ReadDirFS is the interface implemented by a file system that supports
@@ -40,16 +41,19 @@ type MkdirAllFS interface {
 }
 
 // Mkdir creates a new directory with the specified name and permission bits.
+// The umask carried by ctx, if any, is applied to perm.
 func Mkdir(ctx context.Context, fsys FS, name string, perm fs.FileMode) error {
 	if fsys, ok := fsys.(DirFS); ok {
-		return intoPathErr("mkdir", name, fsys.Mkdir(ctx, name, perm))
+		return intoPathErr("mkdir", name, fsys.Mkdir(ctx, name, applyUmask(ctx, perm)))
 	}
 
 	return errors.ErrUnsupported
 }
 
 // MkdirAll creates a directory named path, along with any necessary parents.
+// The umask carried by ctx, if any, is applied to perm.
 func MkdirAll(ctx context.Context, fsys FS, name string, perm fs.FileMode) error {
+	perm = applyUmask(ctx, perm)
 	if fsys, ok := fsys.(MkdirAllFS); ok {
 		if err := fsys.MkdirAll(ctx, name, perm); !errors.Is(err, errors.ErrUnsupported) {
 			return intoPathErr("mkdir", name, err)
@@ -73,10 +77,82 @@ func MkdirAll(ctx context.Context, fsys FS, name string, perm fs.FileMode) error
 	return Mkdir(ctx, fsys, name, perm)
 }
 
+// ReadDirOption configures how ReadDir and NormalizeDirEntries post-process
+// directory entries.
+type ReadDirOption func(*readDirOptions)
+
+// readDirOptions holds the settings applied by ReadDirOption.
+type readDirOptions struct {
+	sort       *bool
+	dedupe     bool
+	skipHidden bool
+}
+
+// SortEntries controls whether entries are sorted by name.
+//
+// By default ReadDir trusts the order returned by a ReadDirFS backend, which
+// is required to be sorted, and sorts entries read through a directory handle.
+// SortEntries(true) sorts the entries of backends that do not honor that
+// contract; SortEntries(false) skips sorting entirely.
+func SortEntries(enabled bool) ReadDirOption {
+	return func(o *readDirOptions) { o.sort = &enabled }
+}
+
+// Deduplicate drops entries whose name was already seen, keeping the first one.
+func Deduplicate() ReadDirOption {
+	return func(o *readDirOptions) { o.dedupe = true }
+}
+
+// SkipHidden drops entries whose name starts with a dot.
+func SkipHidden() ReadDirOption {
+	return func(o *readDirOptions) { o.skipHidden = true }
+}
+
+// NormalizeDirEntries applies opts to entries and returns the result.
+// Unless disabled with SortEntries(false), the result is sorted by name.
+// Sorting is stable, so entries kept by Deduplicate are those that came first.
+// The entries slice may be modified in place.
+func NormalizeDirEntries(entries []fs.DirEntry, opts ...ReadDirOption) []fs.DirEntry {
+	sorted := true
+	return normalizeDirEntries(entries, &sorted, opts)
+}
+
+// normalizeDirEntries applies opts to entries. sorted is the sort setting
+// used when no SortEntries option is given; nil means not to sort.
+func normalizeDirEntries(entries []fs.DirEntry, sorted *bool, opts []ReadDirOption) []fs.DirEntry {
+	o := readDirOptions{sort: sorted}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.dedupe || o.skipHidden {
+		seen := make(map[string]bool, len(entries))
+		entries = fsx.FilterDirEntries(entries, func(e fs.DirEntry) bool {
+			if o.skipHidden && !fsx.Visible(e) {
+				return false
+			}
+			if o.dedupe {
+				if seen[e.Name()] {
+					return false
+				}
+				seen[e.Name()] = true
+			}
+			return true
+		})
+	}
+
+	if o.sort != nil && *o.sort {
+		fsx.SortDirEntries(entries, fsx.ByName)
+	}
+	return entries
+}
+
 // ReadDir reads the named directory and returns a list of directory entries sorted by filename.
-func ReadDir(ctx context.Context, fsys FS, name string) ([]fs.DirEntry, error) {
+// The entries can be further post-processed with opts.
+func ReadDir(ctx context.Context, fsys FS, name string, opts ...ReadDirOption) ([]fs.DirEntry, error) {
 	if fsys, ok := fsys.(ReadDirFS); ok {
-		return fsys.ReadDir(ctx, name)
+		list, err := fsys.ReadDir(ctx, name)
+		return normalizeDirEntries(list, nil, opts), err
 	}
 
 	file, err := fsys.Open(ctx, name)
@@ -91,6 +167,6 @@ func ReadDir(ctx context.Context, fsys FS, name string) ([]fs.DirEntry, error) {
 	}
 
 	list, err := dir.ReadDir(-1)
-	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
-	return list, err
+	sorted := true
+	return normalizeDirEntries(list, &sorted, opts), err
 }
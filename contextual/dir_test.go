@@ -3,6 +3,7 @@ package contextual_test
 import (
 	"errors"
 	"io/fs"
+	"slices"
 	"syscall"
 	"testing"
 
@@ -226,6 +227,40 @@ func TestReadDir(t *testing.T) {
 		}
 	})
 
+	t.Run("ReadDirFS with options", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mfs := cmockfs.NewMockReadDirFS(ctrl)
+		mfs.EXPECT().ReadDir(ctx, ".").Return(mockDirEntries(ctrl, "b", ".hidden", "a", "b"), nil)
+
+		entries, err := contextual.ReadDir(ctx, mfs, ".", contextual.SortEntries(true), contextual.Deduplicate(), contextual.SkipHidden())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := entryNames(entries); !slices.Equal(got, []string{"a", "b"}) {
+			t.Errorf("unexpected entries: %v", got)
+		}
+	})
+
+	t.Run("fallback to Open sorting disabled", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mfs := cmockfs.NewMockFS(ctrl)
+		rdf := mockfs.NewMockReadDirFile(ctrl)
+		rdf.EXPECT().Close().Return(nil)
+		rdf.EXPECT().ReadDir(-1).Return(mockDirEntries(ctrl, "b", "a"), nil)
+		mfs.EXPECT().Open(ctx, ".").Return(rdf, nil)
+
+		entries, err := contextual.ReadDir(ctx, mfs, ".", contextual.SortEntries(false))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := entryNames(entries); !slices.Equal(got, []string{"b", "a"}) {
+			t.Errorf("unexpected entries: %v", got)
+		}
+	})
+
 	t.Run("fallback to Open not a directory", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
@@ -259,3 +294,40 @@ func TestReadDir(t *testing.T) {
 		}
 	})
 }
+
+func TestNormalizeDirEntries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	first := mockfs.NewMockDirEntry(ctrl)
+	first.EXPECT().Name().Return("x").AnyTimes()
+	second := mockfs.NewMockDirEntry(ctrl)
+	second.EXPECT().Name().Return("x").AnyTimes()
+	entries := append(mockDirEntries(ctrl, "c", ".a"), first, second)
+
+	got := contextual.NormalizeDirEntries(entries, contextual.Deduplicate())
+	if names := entryNames(got); !slices.Equal(names, []string{".a", "c", "x"}) {
+		t.Fatalf("unexpected entries: %v", names)
+	}
+	if got[2] != first {
+		t.Error("expected the first duplicate to be kept")
+	}
+}
+
+func mockDirEntries(ctrl *gomock.Controller, names ...string) []fs.DirEntry {
+	var entries []fs.DirEntry
+	for _, name := range names {
+		e := mockfs.NewMockDirEntry(ctrl)
+		e.EXPECT().Name().Return(name).AnyTimes()
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func entryNames(entries []fs.DirEntry) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
@@ -0,0 +1,126 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// FileInfoEntry is a directory entry along with its FileInfo, fetched when
+// the directory was listed. It implements both fs.DirEntry and FileInfo; the
+// extended information comes from ExtendFileInfo.
+type FileInfoEntry struct {
+	fs.FileInfo
+}
+
+// FileInfoToDirEntry returns a DirEntry describing info, like
+// fs.FileInfoToDirEntry, except that the extended information of info, such
+// as its owner and access time, is kept: the FileInfo returned by the Info
+// method of the entry implements FileInfo. If info is nil, it returns nil.
+func FileInfoToDirEntry(info fs.FileInfo) fs.DirEntry {
+	if info == nil {
+		return nil
+	}
+	return FileInfoEntry{FileInfo: ExtendFileInfo(info)}
+}
+
+// DirEntryInfo returns the FileInfo of entry, extended with ExtendFileInfo.
+func DirEntryInfo(entry fs.DirEntry) (FileInfo, error) {
+	info, err := entry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return ExtendFileInfo(info), nil
+}
+
+// Type returns the type bits of the entry.
+func (e FileInfoEntry) Type() fs.FileMode {
+	return e.Mode().Type()
+}
+
+// Info returns the FileInfo fetched with the listing.
+func (e FileInfoEntry) Info() (fs.FileInfo, error) {
+	return e.FileInfo, nil
+}
+
+// Owner returns the user name of the owner of the entry.
+func (e FileInfoEntry) Owner() string {
+	return ExtendFileInfo(e.FileInfo).Owner()
+}
+
+// Group returns the group name of the group of the entry.
+func (e FileInfoEntry) Group() string {
+	return ExtendFileInfo(e.FileInfo).Group()
+}
+
+// AccessTime returns the last access time of the entry.
+func (e FileInfoEntry) AccessTime() time.Time {
+	return ExtendFileInfo(e.FileInfo).AccessTime()
+}
+
+// ChangeTime returns the last status change time of the entry.
+func (e FileInfoEntry) ChangeTime() time.Time {
+	return ExtendFileInfo(e.FileInfo).ChangeTime()
+}
+
+// Attributes returns the attributes of the entry.
+func (e FileInfoEntry) Attributes() internal.Attributes {
+	return internal.FileAttributes(e.FileInfo)
+}
+
+var _ fs.DirEntry = FileInfoEntry{}
+var _ FileInfo = FileInfoEntry{}
+var _ internal.AttributeInfo = FileInfoEntry{}
+
+// ReadDirInfoFS is the interface implemented by a file system that can list
+// a directory along with the FileInfo of its entries in one operation, like
+// the READDIRPLUS call of NFS, sparing the round-trip per entry of a listing
+// followed by a Stat of every entry.
+type ReadDirInfoFS interface {
+	FS
+	// ReadDirInfos reads the named directory and returns its entries with
+	// their FileInfo, sorted by filename. The FileInfo describes the entry
+	// itself, without following symbolic links, like fs.DirEntry.Info. An
+	// error wrapping errors.ErrUnsupported makes the ReadDirInfos helper
+	// list the directory with ReadDir.
+	ReadDirInfos(ctx context.Context, name string) ([]FileInfoEntry, error)
+}
+
+// ReadDirInfos reads the named directory and returns its entries with their
+// FileInfo, sorted by filename.
+//
+// If fsys implements ReadDirInfoFS, it calls fsys.ReadDirInfos. Otherwise,
+// or if that is unsupported, it lists the directory with ReadDir and calls
+// the Info method of every entry; entries removed in the meantime are left
+// out.
+func ReadDirInfos(ctx context.Context, fsys FS, name string) ([]FileInfoEntry, error) {
+	if rfs, ok := fsys.(ReadDirInfoFS); ok {
+		list, err := rfs.ReadDirInfos(ctx, name)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return list, intoPathErr("readdir", name, err)
+		}
+	}
+
+	entries, err := ReadDir(ctx, fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]FileInfoEntry, 0, len(entries))
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		list = append(list, FileInfoEntry{FileInfo: info})
+	}
+	return list, nil
+}
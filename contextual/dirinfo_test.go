@@ -0,0 +1,128 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// infoFS lists directories with ReadDirInfos.
+type infoFS struct {
+	contextual.FS
+	entries []contextual.FileInfoEntry
+}
+
+func (f infoFS) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	if name != "." {
+		return nil, fs.ErrNotExist
+	}
+	return f.entries, nil
+}
+
+// fakeInfo is a FileInfo of a regular file.
+type fakeInfo string
+
+func (n fakeInfo) Name() string       { return string(n) }
+func (n fakeInfo) Size() int64        { return 1 }
+func (n fakeInfo) Mode() fs.FileMode  { return 0644 }
+func (n fakeInfo) ModTime() time.Time { return time.Time{} }
+func (n fakeInfo) IsDir() bool        { return false }
+func (n fakeInfo) Sys() any           { return nil }
+
+func TestReadDirInfos(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("fallback", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "b"), []byte("bb"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(dir, "a"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		fsys, err := osfs.New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := contextual.ReadDirInfos(ctx, contextual.ToContextual(fsys), ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 || entries[0].Name() != "a" || !entries[0].IsDir() || entries[0].Type() != fs.ModeDir ||
+			entries[1].Name() != "b" || entries[1].Size() != 2 {
+			t.Errorf("ReadDirInfos() = %v", entries)
+		}
+		if info, err := entries[1].Info(); err != nil || info.Size() != 2 {
+			t.Errorf("Info() = %v, %v", info, err)
+		}
+	})
+
+	t.Run("native", func(t *testing.T) {
+		fsys := infoFS{entries: []contextual.FileInfoEntry{{FileInfo: fakeInfo("x")}}}
+		entries, err := contextual.ReadDirInfos(ctx, fsys, ".")
+		if err != nil || len(entries) != 1 || entries[0].Name() != "x" {
+			t.Errorf("ReadDirInfos() = %v, %v", entries, err)
+		}
+
+		_, err = contextual.ReadDirInfos(ctx, fsys, "missing")
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || pathErr.Op != "readdir" || pathErr.Path != "missing" {
+			t.Errorf("expected a readdir error on missing, got %v", err)
+		}
+	})
+}
+
+// ownedInfo is a FileInfo with extended information.
+type ownedInfo struct {
+	fakeInfo
+	atime time.Time
+}
+
+func (ownedInfo) Owner() string           { return "alice" }
+func (ownedInfo) Group() string           { return "staff" }
+func (i ownedInfo) AccessTime() time.Time { return i.atime }
+func (i ownedInfo) ChangeTime() time.Time { return i.atime }
+
+func TestFileInfoToDirEntry(t *testing.T) {
+	if contextual.FileInfoToDirEntry(nil) != nil {
+		t.Error("FileInfoToDirEntry(nil) should be nil")
+	}
+
+	atime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entry := contextual.FileInfoToDirEntry(ownedInfo{fakeInfo: "file", atime: atime})
+	if entry.Name() != "file" || entry.IsDir() || entry.Type() != 0 {
+		t.Errorf("unexpected entry %v", entry)
+	}
+	info, err := contextual.DirEntryInfo(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Owner() != "alice" || info.Group() != "staff" || !info.AccessTime().Equal(atime) {
+		t.Errorf("extended information was lost: %v", info)
+	}
+	if e := entry.(contextual.FileInfoEntry); e.Owner() != "alice" || e.Group() != "staff" || !e.AccessTime().Equal(atime) || !e.ChangeTime().Equal(atime) {
+		t.Errorf("entry does not report the extended information: %v", e)
+	}
+
+	// Plain FileInfo values are extended with the defaults of
+	// ExtendFileInfo.
+	info, err = contextual.DirEntryInfo(fs.FileInfoToDirEntry(fakeInfo(".hidden")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.AccessTime().Equal(info.ModTime()) {
+		t.Errorf("AccessTime() = %v, want the modification time", info.AccessTime())
+	}
+	if attrs := fsx.FileAttributes(contextual.FileInfoEntry{FileInfo: fakeInfo(".hidden")}); attrs&fsx.AttrHidden == 0 {
+		t.Errorf("Attributes() = %v, want hidden", attrs)
+	}
+}
@@ -0,0 +1,180 @@
+package contextual
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// DumpOption configures DumpTree.
+type DumpOption func(*dumpOptions)
+
+// dumpOptions holds the settings applied by DumpOption.
+type dumpOptions struct {
+	depth    int
+	exclude  []string
+	noTimes  bool
+	noOwners bool
+}
+
+// DumpDepth limits DumpTree to the entries at most depth levels below the
+// root: 0 lists the root only, 1 its entries too, and so on. A negative
+// depth, the default, lists the whole subtree.
+func DumpDepth(depth int) DumpOption {
+	return func(o *dumpOptions) { o.depth = depth }
+}
+
+// DumpExclude leaves out of DumpTree the entries matching one of patterns,
+// with the syntax of path.Match, and the entries below them. A pattern
+// holding a slash is matched against the name of the entry relative to the
+// root, others against its base name. Invalid patterns match nothing.
+func DumpExclude(patterns ...string) DumpOption {
+	return func(o *dumpOptions) { o.exclude = append(o.exclude, patterns...) }
+}
+
+// DumpTimes controls whether DumpTree lists the modification times. They
+// are listed by default; golden files of trees built at test time usually
+// leave them out.
+func DumpTimes(enabled bool) DumpOption {
+	return func(o *dumpOptions) { o.noTimes = !enabled }
+}
+
+// DumpOwners controls whether DumpTree lists the owners and groups. They
+// are listed by default.
+func DumpOwners(enabled bool) DumpOption {
+	return func(o *dumpOptions) { o.noOwners = !enabled }
+}
+
+// excluded reports whether rel, the name of an entry relative to the root,
+// matches an exclude pattern.
+func (o *dumpOptions) excluded(rel string) bool {
+	for _, pattern := range o.exclude {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DumpTree writes to w a listing of the subtree of fsys at root, one line
+// per file, for golden-file tests and support bundles. The listing is
+// deterministic and friendly to diffs: entries are listed depth-first in
+// the order of their names, without following symbolic links, each on a line
+// of the form
+//
+//	<mode> <owner>:<group> <size> <mtime> <name>[ -> <target>]
+//
+// where mode is formatted by fs.FileMode.String, an unknown owner or group
+// is shown as "-", the size of directories, which depends on the backend, is
+// shown as "-", mtime is in UTC with the layout time.RFC3339Nano, and the
+// target of symbolic links follows the name. Fields left out with
+// DumpOwners or DumpTimes are dropped from the line.
+//
+// An entry that cannot be read is listed as "? <name>: <error>" and the
+// listing goes on with the others. The errors are then returned in a
+// *MultiPathError of Op "dump". An error writing to w is returned at once.
+func DumpTree(ctx context.Context, fsys FS, root string, w io.Writer, opts ...DumpOption) error {
+	o := dumpOptions{depth: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := internal.CheckPath("dump", root); err != nil {
+		return err
+	}
+
+	var errs []error
+	failed := func(name string, err error) error {
+		errs = append(errs, err)
+		_, werr := fmt.Fprintf(w, "? %s: %v\n", name, err)
+		return werr
+	}
+
+	var dump func(name, rel string, depth int) error
+	dump = func(name, rel string, depth int) error {
+		if err := ctx.Err(); err != nil {
+			return failed(name, &fs.PathError{Op: "lstat", Path: name, Err: err})
+		}
+		info, err := Lstat(ctx, fsys, name)
+		if err != nil {
+			return failed(name, intoPathErr("lstat", name, err))
+		}
+		target := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if target, err = ReadLink(ctx, fsys, name); err != nil {
+				return failed(name, intoPathErr("readlink", name, err))
+			}
+		}
+		if _, err := io.WriteString(w, o.line(name, target, info)); err != nil {
+			return err
+		}
+
+		if !info.IsDir() || depth == o.depth {
+			return nil
+		}
+		entries, err := ReadDir(ctx, fsys, name, SortEntries(true))
+		if err != nil {
+			return failed(name, intoPathErr("readdir", name, err))
+		}
+		for _, entry := range entries {
+			child, err := JoinValid(name, entry.Name())
+			if err != nil {
+				if err := failed(entry.Name(), err); err != nil {
+					return err
+				}
+				continue
+			}
+			childRel := path.Join(rel, entry.Name())
+			if o.excluded(childRel) {
+				continue
+			}
+			if err := dump(child, childRel, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := dump(root, ".", 0); err != nil {
+		return err
+	}
+	return newMultiPathError("dump", root, errs)
+}
+
+// line formats the line of DumpTree listing name.
+func (o *dumpOptions) line(name, target string, info FileInfo) string {
+	fields := []string{info.Mode().String()}
+	if !o.noOwners {
+		fields = append(fields, orDash(info.Owner())+":"+orDash(info.Group()))
+	}
+	if info.IsDir() {
+		fields = append(fields, "-")
+	} else {
+		fields = append(fields, strconv.FormatInt(info.Size(), 10))
+	}
+	if !o.noTimes {
+		fields = append(fields, info.ModTime().UTC().Format(time.RFC3339Nano))
+	}
+	fields = append(fields, name)
+	if target != "" {
+		fields = append(fields, "->", target)
+	}
+	return strings.Join(fields, " ") + "\n"
+}
+
+// orDash returns s, or "-" if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
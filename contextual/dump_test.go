@@ -0,0 +1,93 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+func TestDumpTree(t *testing.T) {
+	ctx := t.Context()
+	clock := fsxtest.NewClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	fsys := memfs.New(memfs.Config{Clock: clock, Owner: "root", Group: "wheel"})
+	if err := contextual.MkdirAll(ctx, fsys, "dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"dir/b": "bb", "dir/a": "a", "dir/sub/c": "ccc", "dir/.cache": ""} {
+		if err := contextual.WriteFile(ctx, fsys, name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := contextual.Symlink(ctx, fsys, "a", "dir/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := func(root string, opts ...contextual.DumpOption) string {
+		t.Helper()
+		var b strings.Builder
+		if err := contextual.DumpTree(ctx, fsys, root, &b, opts...); err != nil {
+			t.Fatalf("DumpTree(%s) error = %v", root, err)
+		}
+		return b.String()
+	}
+
+	got := dump("dir", contextual.DumpExclude(".*"))
+	want := `drwxr-xr-x root:wheel - 2024-01-02T03:04:05Z dir
+-rw-r--r-- root:wheel 1 2024-01-02T03:04:05Z dir/a
+-rw-r--r-- root:wheel 2 2024-01-02T03:04:05Z dir/b
+Lrwxrwxrwx root:wheel 1 2024-01-02T03:04:05Z dir/link -> a
+drwxr-xr-x root:wheel - 2024-01-02T03:04:05Z dir/sub
+-rw-r--r-- root:wheel 3 2024-01-02T03:04:05Z dir/sub/c
+`
+	if got != want {
+		t.Errorf("DumpTree() =\n%s\nwant\n%s", got, want)
+	}
+
+	got = dump("dir", contextual.DumpDepth(1), contextual.DumpExclude("sub", "link"),
+		contextual.DumpTimes(false), contextual.DumpOwners(false))
+	want = `drwxr-xr-x - dir
+-rw-r--r-- 0 dir/.cache
+-rw-r--r-- 1 dir/a
+-rw-r--r-- 2 dir/b
+`
+	if got != want {
+		t.Errorf("DumpTree(depth 1) =\n%s\nwant\n%s", got, want)
+	}
+
+	if got, want := dump(".", contextual.DumpDepth(0), contextual.DumpTimes(false)), "drwxr-xr-x root:wheel - .\n"; got != want {
+		t.Errorf("DumpTree(depth 0) = %q; want %q", got, want)
+	}
+}
+
+func TestDumpTree_Errors(t *testing.T) {
+	ctx := t.Context()
+	fsys := memfs.New(memfs.Config{})
+	if err := contextual.MkdirAll(ctx, fsys, "dir/bad", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.WriteFile(ctx, fsys, "dir/ok", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	errBroken := errors.New("broken")
+	broken := fsxtest.NewErrorFS(fsys, map[fsxtest.Fault]error{{Op: "readdir", Path: "dir/bad"}: errBroken})
+
+	var b strings.Builder
+	err := contextual.DumpTree(ctx, broken, "dir", &b, contextual.DumpTimes(false), contextual.DumpOwners(false))
+	var multi *contextual.MultiPathError
+	if !errors.As(err, &multi) || multi.Op != "dump" || len(multi.Errors) != 1 || !errors.Is(err, errBroken) {
+		t.Fatalf("DumpTree() error = %v; want a MultiPathError of the broken directory", err)
+	}
+	if !strings.Contains(b.String(), "? dir/bad: readdir dir/bad: broken\n") || !strings.HasSuffix(b.String(), "-rw-r--r-- 0 dir/ok\n") {
+		t.Errorf("DumpTree() =\n%s\nwant the error and the listing of dir/ok", b.String())
+	}
+
+	if err := contextual.DumpTree(ctx, fsys, "missing", &b); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("DumpTree(missing) error = %v; want ErrNotExist", err)
+	}
+}
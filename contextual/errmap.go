@@ -0,0 +1,101 @@
+package contextual
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+// ErrorMapper translates the errors of a backend, such as those of a network
+// or cloud SDK, into errors wrapping the sentinels of io/fs, so that checks
+// like errors.Is(err, fs.ErrNotExist), which unionfs and evictfs rely on,
+// hold for them. It must return nil for nil, and leave the errors it does
+// not know about, including those already wrapping a sentinel, as they are.
+// TranslateError helps keeping the original error.
+type ErrorMapper func(err error) error
+
+// TranslateError returns an error with the message of err, wrapping both err
+// and target, so that errors.Is reports target while errors.As still finds
+// the error of the backend. It returns err itself if it is nil, or already
+// wraps target or target is nil.
+func TranslateError(err, target error) error {
+	if err == nil || target == nil || errors.Is(err, target) {
+		return err
+	}
+	return &translatedError{err: err, target: target}
+}
+
+// translatedError is err, translated to target by TranslateError.
+type translatedError struct {
+	err, target error
+}
+
+func (e *translatedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *translatedError) Unwrap() []error {
+	return []error{e.err, e.target}
+}
+
+// StatusError returns the error matching the HTTP status code, or nil if
+// there is none, such as for successful codes:
+//
+//   - 400 Bad Request: fs.ErrInvalid
+//   - 401 Unauthorized and 403 Forbidden: fs.ErrPermission
+//   - 404 Not Found and 410 Gone: fs.ErrNotExist
+//   - 405 Method Not Allowed and 501 Not Implemented: errors.ErrUnsupported
+//   - 408 Request Timeout and 504 Gateway Timeout: os.ErrDeadlineExceeded
+//   - 409 Conflict and 412 Precondition Failed: fs.ErrExist
+//   - 507 Insufficient Storage: syscall.ENOSPC
+func StatusError(code int) error {
+	switch code {
+	case http.StatusBadRequest:
+		return fs.ErrInvalid
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fs.ErrPermission
+	case http.StatusNotFound, http.StatusGone:
+		return fs.ErrNotExist
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return errors.ErrUnsupported
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return os.ErrDeadlineExceeded
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return fs.ErrExist
+	case http.StatusInsufficientStorage:
+		return syscall.ENOSPC
+	}
+	return nil
+}
+
+// MapHTTPErrors is an ErrorMapper translating the errors carrying an HTTP
+// status code, as reported by a StatusCode() int or an HTTPStatusCode() int
+// method of an error in their chain, with StatusError. SDKs reporting the
+// code otherwise can be given an ErrorMapper calling StatusError themselves.
+func MapHTTPErrors(err error) error {
+	if err == nil || translated(err) {
+		return err
+	}
+	var code interface{ StatusCode() int }
+	if errors.As(err, &code) {
+		return TranslateError(err, StatusError(code.StatusCode()))
+	}
+	var httpCode interface{ HTTPStatusCode() int }
+	if errors.As(err, &httpCode) {
+		return TranslateError(err, StatusError(httpCode.HTTPStatusCode()))
+	}
+	return err
+}
+
+// translated reports whether err already wraps one of the sentinels of
+// io/fs.
+func translated(err error) bool {
+	for _, target := range []error{fs.ErrInvalid, fs.ErrPermission, fs.ErrExist, fs.ErrNotExist, fs.ErrClosed, errors.ErrUnsupported} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+)
+
+// sdkError is the error of an SDK reporting an HTTP status code.
+type sdkError struct {
+	code int
+}
+
+func (e *sdkError) Error() string       { return "request failed" }
+func (e *sdkError) HTTPStatusCode() int { return e.code }
+
+func TestStatusError(t *testing.T) {
+	for code, want := range map[int]error{
+		200: nil,
+		400: fs.ErrInvalid,
+		403: fs.ErrPermission,
+		404: fs.ErrNotExist,
+		409: fs.ErrExist,
+		501: errors.ErrUnsupported,
+		504: os.ErrDeadlineExceeded,
+		507: syscall.ENOSPC,
+		500: nil,
+	} {
+		if got := contextual.StatusError(code); got != want {
+			t.Errorf("StatusError(%d) = %v; want %v", code, got, want)
+		}
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	orig := errors.New("backend failure")
+	err := contextual.TranslateError(orig, fs.ErrNotExist)
+	if !errors.Is(err, fs.ErrNotExist) || !errors.Is(err, orig) || err.Error() != orig.Error() {
+		t.Errorf("TranslateError() = %v; want %v wrapping fs.ErrNotExist", err, orig)
+	}
+	if err := contextual.TranslateError(nil, fs.ErrNotExist); err != nil {
+		t.Errorf("TranslateError(nil) = %v; want nil", err)
+	}
+	if err := contextual.TranslateError(orig, nil); err != orig {
+		t.Errorf("TranslateError(err, nil) = %v; want err", err)
+	}
+}
+
+func TestMapHTTPErrors(t *testing.T) {
+	fsxtest.CheckErrorMapper(t, contextual.MapHTTPErrors, map[error]error{
+		&sdkError{code: 404}:                     fs.ErrNotExist,
+		&sdkError{code: 401}:                     fs.ErrPermission,
+		&fs.PathError{Err: &sdkError{code: 412}}: fs.ErrExist,
+		&sdkError{code: 507}:                     syscall.ENOSPC,
+	})
+
+	// Unknown codes and errors without a code are kept as they are.
+	for _, err := range []error{&sdkError{code: 500}, errors.New("other")} {
+		if got := contextual.MapHTTPErrors(err); got != err {
+			t.Errorf("MapHTTPErrors(%v) = %v; want it unchanged", err, got)
+		}
+	}
+	// Errors already translated are not translated again.
+	err := &fs.PathError{Op: "open", Path: "x", Err: errors.Join(fs.ErrPermission, &sdkError{code: 404})}
+	if got := contextual.MapHTTPErrors(err); errors.Is(got, fs.ErrNotExist) {
+		t.Errorf("MapHTTPErrors(%v) = %v; want it unchanged", err, got)
+	}
+}
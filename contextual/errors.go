@@ -1,9 +1,79 @@
 package contextual
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/gwangyi/fsx/internal"
 )
 
+// MultiPathError reports the failures of a recursive operation that was run
+// with ContinueOnError. Each failure is recorded with the path it happened
+// on, so that a large tree can be remediated without rerunning the whole
+// operation to find the next failure.
+//
+// It implements Unwrap() []error, so errors.Is and errors.As look through all
+// of the failures.
+type MultiPathError struct {
+	// Op is the recursive operation, such as "removeall".
+	Op string
+	// Path is the root of the operation.
+	Path string
+	// Errors are the failures, in the order they happened. Each one is an
+	// *fs.PathError or an *os.LinkError naming the path that failed.
+	Errors []error
+}
+
+// Error returns the operation, the root and all of the failures.
+func (e *MultiPathError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Op + " " + e.Path + ": " + e.Errors[0].Error()
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%s %s: %d errors: %s", e.Op, e.Path, len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the failures.
+func (e *MultiPathError) Unwrap() []error {
+	return e.Errors
+}
+
+// newMultiPathError returns a *MultiPathError for errs, or nil if errs is
+// empty.
+func newMultiPathError(op, path string, errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &MultiPathError{Op: op, Path: path, Errors: errs}
+}
+
+// RecursiveOption configures recursive helpers such as RemoveAll.
+type RecursiveOption func(*recursiveOptions)
+
+// recursiveOptions holds the settings applied by RecursiveOption.
+type recursiveOptions struct {
+	continueOnError bool
+}
+
+// ContinueOnError makes a recursive helper carry on past failures instead of
+// stopping at the first one. If anything failed, the helper returns a
+// *MultiPathError listing every failure.
+func ContinueOnError() RecursiveOption {
+	return func(o *recursiveOptions) { o.continueOnError = true }
+}
+
+// applyRecursiveOptions returns the settings selected by opts.
+func applyRecursiveOptions(opts []RecursiveOption) recursiveOptions {
+	var o recursiveOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 func intoPathErr(op, path string, err error) error {
 	return internal.IntoPathErr(op, path, err)
 }
@@ -0,0 +1,73 @@
+package contextual
+
+import (
+	"context"
+	"io/fs"
+	"os"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// ValidateFlags returns an error matching fs.ErrInvalid and syscall.EINVAL if
+// flag combines open flags in a way whose outcome depends on the backend: an
+// access mode other than os.O_RDONLY, os.O_WRONLY and os.O_RDWR, os.O_TRUNC
+// on a file opened read-only, or os.O_EXCL without os.O_CREATE.
+//
+// The filesystems of this module reject such flags in OpenFile, with an
+// *fs.PathError wrapping that error, before reaching their backends.
+func ValidateFlags(flag int) error {
+	return internal.ValidateFlags(flag)
+}
+
+// OpenFlags is a valid combination of the flag and mode arguments of
+// OpenFile, built by ReadOnly, ReadWrite, CreateTrunc, CreateExcl or Append.
+type OpenFlags struct {
+	flag int
+	mode fs.FileMode
+}
+
+// ReadOnly opens an existing file for reading.
+func ReadOnly() OpenFlags {
+	return OpenFlags{flag: os.O_RDONLY}
+}
+
+// ReadWrite opens an existing file for reading and writing.
+func ReadWrite() OpenFlags {
+	return OpenFlags{flag: os.O_RDWR}
+}
+
+// CreateTrunc opens a file for reading and writing, creating it with perm if
+// it does not exist and truncating it otherwise, like Create.
+func CreateTrunc(perm fs.FileMode) OpenFlags {
+	return OpenFlags{flag: os.O_RDWR | os.O_CREATE | os.O_TRUNC, mode: perm}
+}
+
+// CreateExcl creates a file with perm and opens it for reading and writing,
+// failing with fs.ErrExist if it already exists.
+func CreateExcl(perm fs.FileMode) OpenFlags {
+	return OpenFlags{flag: os.O_RDWR | os.O_CREATE | os.O_EXCL, mode: perm}
+}
+
+// Append opens a file for writing at its end, creating it with perm if it
+// does not exist.
+func Append(perm fs.FileMode) OpenFlags {
+	return OpenFlags{flag: os.O_WRONLY | os.O_CREATE | os.O_APPEND, mode: perm}
+}
+
+// Sync returns the flags with os.O_SYNC added, so that writes wait for the
+// storage.
+func (o OpenFlags) Sync() OpenFlags {
+	o.flag |= os.O_SYNC
+	return o
+}
+
+// Flag returns the flag argument of OpenFile.
+func (o OpenFlags) Flag() int { return o.flag }
+
+// Mode returns the mode argument of OpenFile.
+func (o OpenFlags) Mode() fs.FileMode { return o.mode }
+
+// OpenFile opens the named file of fsys with the flags, like OpenFile.
+func (o OpenFlags) OpenFile(ctx context.Context, fsys FS, name string) (File, error) {
+	return OpenFile(ctx, fsys, name, o.flag, o.mode)
+}
@@ -0,0 +1,90 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestValidateFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		flag  int
+		valid bool
+	}{
+		{"read", os.O_RDONLY, true},
+		{"create trunc", os.O_RDWR | os.O_CREATE | os.O_TRUNC, true},
+		{"create excl", os.O_WRONLY | os.O_CREATE | os.O_EXCL, true},
+		{"read append", os.O_RDONLY | os.O_APPEND, true},
+		{"access mode", os.O_WRONLY | os.O_RDWR, false},
+		{"read trunc", os.O_RDONLY | os.O_TRUNC, false},
+		{"excl without create", os.O_RDWR | os.O_EXCL, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := contextual.ValidateFlags(tt.flag)
+			if tt.valid {
+				if err != nil {
+					t.Errorf("ValidateFlags() = %v; want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, fs.ErrInvalid) || !errors.Is(err, syscall.EINVAL) {
+				t.Errorf("ValidateFlags() = %v; want ErrInvalid and EINVAL", err)
+			}
+		})
+	}
+}
+
+func TestOpenFlags(t *testing.T) {
+	ctx := t.Context()
+	root, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := contextual.ToContextual(root)
+
+	for _, flags := range []contextual.OpenFlags{
+		contextual.ReadOnly(), contextual.ReadWrite(), contextual.CreateTrunc(0644),
+		contextual.CreateExcl(0600), contextual.Append(0644).Sync(),
+	} {
+		if err := contextual.ValidateFlags(flags.Flag()); err != nil {
+			t.Errorf("ValidateFlags(%#x) = %v", flags.Flag(), err)
+		}
+	}
+
+	f, err := contextual.CreateExcl(0600).OpenFile(ctx, fsys, "file")
+	if err != nil {
+		t.Fatalf("CreateExcl: %v", err)
+	}
+	_, _ = f.Write([]byte("data"))
+	_ = f.Close()
+	if _, err := contextual.CreateExcl(0600).OpenFile(ctx, fsys, "file"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("CreateExcl on an existing file error = %v; want ErrExist", err)
+	}
+	f, err = contextual.Append(0644).OpenFile(ctx, fsys, "file")
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	_, _ = f.Write([]byte("!"))
+	_ = f.Close()
+	if data, err := contextual.ReadFile(ctx, fsys, "file"); err != nil || string(data) != "data!" {
+		t.Errorf("ReadFile = %q, %v; want data!", data, err)
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		_, err := contextual.OpenFile(ctx, fsys, "file", os.O_RDONLY|os.O_TRUNC, 0)
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || pathErr.Op != "open" || !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("OpenFile(O_RDONLY|O_TRUNC) error = %v; want an open PathError wrapping ErrInvalid", err)
+		}
+		if data, _ := contextual.ReadFile(ctx, fsys, "file"); string(data) != "data!" {
+			t.Errorf("file truncated to %q", data)
+		}
+	})
+}
@@ -6,7 +6,6 @@ package contextual
 import (
 	"context"
 	"errors"
-	"io"
 	"io/fs"
 	"os"
 
@@ -87,9 +86,15 @@ func Open(ctx context.Context, fsys FS, name string) (fs.File, error) {
 
 // Create creates or truncates the named file in the given filesystem.
 // If fsys implements WriterFS, it calls fsys.Create(ctx, name).
+// If ctx carries a umask (see WithUmask), the file is created through
+// fsys.OpenFile instead so that the masked mode can be passed along.
 // Otherwise, it returns errors.ErrUnsupported.
 func Create(ctx context.Context, fsys FS, name string) (File, error) {
 	if xfs, ok := fsys.(WriterFS); ok {
+		if _, ok := Umask(ctx); ok {
+			f, err := xfs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, applyUmask(ctx, 0666))
+			return f, intoPathErr("open", name, err)
+		}
 		f, err := xfs.Create(ctx, name)
 		return f, intoPathErr("open", name, err)
 	}
@@ -98,11 +103,18 @@ func Create(ctx context.Context, fsys FS, name string) (File, error) {
 }
 
 // OpenFile opens the named file with specified flag and mode in the given filesystem.
-// If fsys implements WriterFS, it calls fsys.OpenFile(ctx, name, flag, mode).
+// If fsys implements WriterFS, it calls fsys.OpenFile(ctx, name, flag, mode),
+// emulating O_APPEND with fsx.EmulateAppend if fsys fails to open the file
+// with it with errors.ErrUnsupported.
 // Otherwise, it attempts a fallback for read-only access.
+// The umask carried by ctx, if any, is applied to mode.
 func OpenFile(ctx context.Context, fsys FS, name string, flag int, mode fs.FileMode) (File, error) {
+	mode = applyUmask(ctx, mode)
 	if xfs, ok := fsys.(WriterFS); ok {
-		if f, err := xfs.OpenFile(ctx, name, flag, mode); !errors.Is(err, errors.ErrUnsupported) {
+		f, err := internal.OpenAppend(name, flag, func(flag int) (File, error) {
+			return xfs.OpenFile(ctx, name, flag, mode)
+		})
+		if !errors.Is(err, errors.ErrUnsupported) {
 			return f, intoPathErr("open", name, err)
 		}
 	}
@@ -112,7 +124,7 @@ func OpenFile(ctx context.Context, fsys FS, name string, flag int, mode fs.FileM
 		if err != nil {
 			return nil, intoPathErr("open", name, err)
 		}
-		return internal.ReadOnlyFile{File: f}, nil
+		return internal.WrapFile(internal.ReadOnlyFile{File: f}, f), nil
 	}
 
 	return nil, errors.ErrUnsupported
@@ -128,6 +140,9 @@ func Remove(ctx context.Context, fsys FS, name string) error {
 }
 
 // ReadFile reads the named file from the given filesystem and returns its contents.
+// If fsys does not implement ReadFileFS, the file is read into a buffer sized
+// once from its FileInfo, or from the StatFS of fsys or by seeking to its end
+// if the file cannot report it.
 func ReadFile(ctx context.Context, fsys FS, name string) ([]byte, error) {
 	if fsys, ok := fsys.(ReadFileFS); ok {
 		return fsys.ReadFile(ctx, name)
@@ -139,7 +154,7 @@ func ReadFile(ctx context.Context, fsys FS, name string) ([]byte, error) {
 	}
 	defer func() { _ = f.Close() }()
 
-	return io.ReadAll(f)
+	return readAll(ctx, fsys, name, f, nil)
 }
 
 type FileSystem interface {
@@ -1,6 +1,7 @@
 package contextual_test
 
 import (
+	"context"
 	"errors"
 	"io"
 	"io/fs"
@@ -10,6 +11,8 @@ import (
 
 	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
 	"github.com/gwangyi/fsx/mockfs"
 	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
 	"go.uber.org/mock/gomock"
@@ -328,7 +331,10 @@ func TestReadFile(t *testing.T) {
 		file := mockfs.NewMockFile(ctrl)
 		content := []byte("hello")
 
-		// ReadAll reads until EOF
+		// The buffer is sized from Stat when it succeeds, and grown as the
+		// file is read otherwise.
+		file.EXPECT().Stat().Return(nil, fs.ErrInvalid)
+		// ReadFile reads until EOF
 		file.EXPECT().Read(gomock.Any()).DoAndReturn(func(p []byte) (int, error) {
 			copy(p, content)
 			return len(content), nil
@@ -360,3 +366,21 @@ func TestReadFile(t *testing.T) {
 		}
 	})
 }
+
+// noAppendFS can write files but not open them with O_APPEND.
+type noAppendFS struct {
+	contextual.FileSystem
+}
+
+func (f noAppendFS) OpenFile(ctx context.Context, name string, flag int, perm fs.FileMode) (contextual.File, error) {
+	if flag&os.O_APPEND != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+	}
+	return f.FileSystem.OpenFile(ctx, name, flag, perm)
+}
+
+func TestOpenFile_EmulateAppend(t *testing.T) {
+	ctx := t.Context()
+	fsys := noAppendFS{memfs.New(memfs.Config{})}
+	fsxtest.CheckAppend(t, contextual.FromContextual(fsys, ctx), "appended")
+}
@@ -0,0 +1,52 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// GlobFS is the interface implemented by a file system that can match names
+// against a pattern itself.
+type GlobFS interface {
+	FS
+	// Glob returns the names of all files matching pattern, with the
+	// syntax of path.Match. An error wrapping errors.ErrUnsupported makes
+	// the Glob helper match the names itself.
+	Glob(ctx context.Context, pattern string) ([]string, error)
+}
+
+// Glob returns the names of all files matching pattern, or nil if there is
+// no matching file. The syntax of patterns is the same as in path.Match,
+// and the only possible error is path.ErrBadPattern.
+//
+// If fsys implements GlobFS, it calls fsys.Glob. Otherwise, or if that is
+// unsupported, it uses fs.Glob, which lists the directories of the pattern
+// with ReadDir.
+func Glob(ctx context.Context, fsys FS, pattern string) ([]string, error) {
+	if gfs, ok := fsys.(GlobFS); ok {
+		if names, err := gfs.Glob(ctx, pattern); !errors.Is(err, errors.ErrUnsupported) {
+			return names, err
+		}
+	}
+	return fs.Glob(globFS{fsys: fsys, ctx: ctx}, pattern)
+}
+
+// globFS exposes to fs.Glob the methods of a contextual FS it uses, but
+// not Glob, which would call Glob back.
+type globFS struct {
+	fsys FS
+	ctx  context.Context
+}
+
+func (g globFS) Open(name string) (fs.File, error) {
+	return g.fsys.Open(g.ctx, name)
+}
+
+func (g globFS) Stat(name string) (fs.FileInfo, error) {
+	return Stat(g.ctx, g.fsys, name)
+}
+
+func (g globFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return ReadDir(g.ctx, g.fsys, name)
+}
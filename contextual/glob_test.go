@@ -0,0 +1,37 @@
+package contextual_test
+
+import (
+	"path"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+func TestGlob(t *testing.T) {
+	ctx := t.Context()
+	fsys := contextual.ToContextual(fstest.MapFS{
+		"a.txt":     {Data: []byte("a")},
+		"b.log":     {Data: []byte("b")},
+		"dir/c.txt": {Data: []byte("c")},
+	})
+
+	got, err := contextual.Glob(ctx, fsys, "*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.txt"}; !slices.Equal(got, want) {
+		t.Errorf("Glob(*.txt) = %v; want %v", got, want)
+	}
+	got, err = contextual.Glob(ctx, fsys, "*/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dir/c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("Glob(*/*.txt) = %v; want %v", got, want)
+	}
+	if _, err := contextual.Glob(ctx, fsys, "["); err != path.ErrBadPattern {
+		t.Errorf("Glob([) error = %v; want ErrBadPattern", err)
+	}
+}
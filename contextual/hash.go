@@ -0,0 +1,39 @@
+package contextual
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// HashFS is the interface implemented by a file system that knows the
+// SHA-256 digests of its files without reading them whole, such as an object
+// store keeping the checksums of its objects.
+type HashFS interface {
+	FS
+	// Hash returns the SHA-256 digest of the contents of the named file.
+	// If there is an error, it should be of type *fs.PathError.
+	Hash(ctx context.Context, name string) ([]byte, error)
+}
+
+// Hash returns the SHA-256 digest of the contents of the named file. If fsys
+// implements HashFS, it calls fsys.Hash. Otherwise it reads the file.
+func Hash(ctx context.Context, fsys FS, name string) ([]byte, error) {
+	if hfs, ok := fsys.(HashFS); ok {
+		sum, err := hfs.Hash(ctx, name)
+		return sum, intoPathErr("hash", name, err)
+	}
+
+	f, err := fsys.Open(ctx, name)
+	if err != nil {
+		return nil, intoPathErr("hash", name, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := internal.Copy(h, f); err != nil {
+		return nil, intoPathErr("hash", name, err)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,42 @@
+package contextual_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+// digestFS knows the digests of its files.
+type digestFS struct {
+	contextual.FS
+}
+
+func (digestFS) Hash(ctx context.Context, name string) ([]byte, error) {
+	return []byte("digest of " + name), nil
+}
+
+func TestHash(t *testing.T) {
+	ctx := t.Context()
+	fsys := memfs.New(memfs.Config{})
+	if err := contextual.WriteFile(ctx, fsys, "a", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sha256.Sum256([]byte("data"))
+	if sum, err := contextual.Hash(ctx, fsys, "a"); err != nil || !bytes.Equal(sum, want[:]) {
+		t.Errorf("Hash(a) = %x, %v; want %x", sum, err, want)
+	}
+	var pathErr *fs.PathError
+	if _, err := contextual.Hash(ctx, fsys, "missing"); !errors.As(err, &pathErr) || pathErr.Op != "hash" || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Hash(missing) = %v; want ErrNotExist", err)
+	}
+	if sum, err := contextual.Hash(ctx, digestFS{fsys}, "a"); err != nil || string(sum) != "digest of a" {
+		t.Errorf("Hash(a) = %q, %v; want the digest of the filesystem", sum, err)
+	}
+}
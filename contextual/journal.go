@@ -0,0 +1,87 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrCursorExpired is returned by ChangeJournalFS.Changes when the changes
+// following the cursor are no longer retained. The caller has to rescan the
+// filesystem and continue from the cursor returned along with the error.
+var ErrCursorExpired = errors.New("change journal cursor expired")
+
+// Cursor is a position in a change journal. The zero Cursor is the start of
+// the journal.
+type Cursor uint64
+
+// ChangeOp is the kind of a Change.
+type ChangeOp int
+
+const (
+	// ChangeCreate records that a file, directory or link was created.
+	ChangeCreate ChangeOp = iota + 1
+	// ChangeWrite records that the contents of a file were modified.
+	ChangeWrite
+	// ChangeRemove records that a file was removed. For RemoveAll, the
+	// removal of everything beneath Name is implied.
+	ChangeRemove
+	// ChangeRename records that OldName was moved to Name.
+	ChangeRename
+	// ChangeMetadata records that the mode, ownership, times or extended
+	// attributes of a file were changed.
+	ChangeMetadata
+)
+
+// String returns the name of the operation.
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeCreate:
+		return "create"
+	case ChangeWrite:
+		return "write"
+	case ChangeRemove:
+		return "remove"
+	case ChangeRename:
+		return "rename"
+	case ChangeMetadata:
+		return "metadata"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is an entry of a change journal.
+type Change struct {
+	// Op is the kind of change.
+	Op ChangeOp
+	// Name is the path of the changed file.
+	Name string
+	// OldName is the previous path of a renamed file.
+	OldName string
+	// Time is when the change was recorded.
+	Time time.Time
+}
+
+// ChangeJournalFS is the interface implemented by a file system that keeps a
+// journal of the changes made to it, so that tools such as incremental
+// backups can catch up without rescanning.
+type ChangeJournalFS interface {
+	FS
+
+	// Changes returns the changes recorded after since, in order, and the
+	// cursor to pass to the next call. If the changes following since are
+	// no longer retained, it returns an error wrapping ErrCursorExpired
+	// together with the current cursor.
+	Changes(ctx context.Context, since Cursor) ([]Change, Cursor, error)
+}
+
+// Changes returns the changes recorded in fsys after since.
+// If fsys implements ChangeJournalFS, it calls fsys.Changes.
+// Otherwise, it returns an error wrapping errors.ErrUnsupported.
+func Changes(ctx context.Context, fsys FS, since Cursor) ([]Change, Cursor, error) {
+	if jfs, ok := fsys.(ChangeJournalFS); ok {
+		return jfs.Changes(ctx, since)
+	}
+	return nil, since, intoPathErr("changes", ".", errors.ErrUnsupported)
+}
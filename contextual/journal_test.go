@@ -0,0 +1,26 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"go.uber.org/mock/gomock"
+)
+
+func TestChanges_Unsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := cmockfs.NewMockFS(ctrl)
+	changes, cursor, err := contextual.Changes(t.Context(), m, 7)
+	var pErr *fs.PathError
+	if !errors.As(err, &pErr) || pErr.Op != "changes" || !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected changes ErrUnsupported, got %v", err)
+	}
+	if changes != nil || cursor != 7 {
+		t.Errorf("Changes() = %v, %d", changes, cursor)
+	}
+}
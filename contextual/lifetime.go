@@ -0,0 +1,64 @@
+package contextual
+
+import "context"
+
+// Lifetime selects the context of the work a filesystem does for a request
+// after the request returns, such as the lookups made by the FileInfo it
+// returned, or of the background work started by its constructor.
+type Lifetime int
+
+const (
+	// Detached work runs with the values of the context of the request, but
+	// is not canceled with it: it lasts as long as the object it is done
+	// for.
+	Detached Lifetime = iota
+	// Bound work runs with the context of the request itself, and is
+	// canceled with it.
+	Bound
+)
+
+// Context returns the context of the work outliving a request made with ctx.
+func (l Lifetime) Context(ctx context.Context) context.Context {
+	if l == Bound {
+		return ctx
+	}
+	return context.WithoutCancel(ctx)
+}
+
+// Detach returns the context of work done for the request made with ctx that
+// outlives it, such as a background task or a call shared with later
+// requests. It carries the values of ctx, such as the identity of the caller
+// or tracing baggage, so that the work is still attributed to the request,
+// but it is canceled, and has a deadline, only as lifetime is: the context of
+// whatever the work is done for, such as an open filesystem. The values of
+// ctx shadow those of lifetime. Work that is never canceled uses
+// context.WithoutCancel instead.
+func Detach(ctx, lifetime context.Context) context.Context {
+	// WithoutCancel also keeps context.Cause and derived contexts from
+	// finding the cancelation of ctx among its values.
+	return detachedContext{Context: lifetime, values: context.WithoutCancel(ctx)}
+}
+
+// detachedContext is the context returned by Detach for a lifetime.
+type detachedContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c detachedContext) Value(key any) any {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// String returns the name of the lifetime.
+func (l Lifetime) String() string {
+	switch l {
+	case Detached:
+		return "detached"
+	case Bound:
+		return "bound"
+	}
+	return "unknown"
+}
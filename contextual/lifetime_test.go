@@ -0,0 +1,66 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+type lifetimeKey struct{}
+
+func TestLifetime(t *testing.T) {
+	for _, tc := range []struct {
+		lifetime contextual.Lifetime
+		name     string
+		want     error
+	}{
+		{contextual.Detached, "detached", nil},
+		{contextual.Bound, "bound", context.Canceled},
+		{contextual.Lifetime(-1), "unknown", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.lifetime.String(); got != tc.name {
+				t.Errorf("String() = %q; want %q", got, tc.name)
+			}
+			ctx, cancel := context.WithCancel(context.WithValue(t.Context(), lifetimeKey{}, "v"))
+			wctx := tc.lifetime.Context(ctx)
+			cancel()
+			if err := wctx.Err(); err != tc.want {
+				t.Errorf("Err() after cancel = %v; want %v", err, tc.want)
+			}
+			if v := wctx.Value(lifetimeKey{}); v != "v" {
+				t.Errorf("Value() = %v; want v", v)
+			}
+		})
+	}
+}
+
+func TestDetach(t *testing.T) {
+	lifetime, stop := context.WithCancelCause(context.WithValue(t.Context(), lifetimeKey{}, "lifetime"))
+	ctx, cancel := context.WithCancel(context.WithValue(t.Context(), lifetimeKey{}, "request"))
+	detached := contextual.Detach(ctx, lifetime)
+	derived, cancelDerived := context.WithCancel(detached)
+	defer cancelDerived()
+
+	// The values of the request shadow those of the lifetime, and its
+	// cancelation is ignored.
+	cancel()
+	if v := derived.Value(lifetimeKey{}); v != "request" {
+		t.Errorf("Value() = %v; want request", v)
+	}
+	if err := derived.Err(); err != nil {
+		t.Fatalf("Err() after canceling the request = %v; want nil", err)
+	}
+
+	cause := errors.New("closed")
+	stop(cause)
+	<-derived.Done()
+	if err := detached.Err(); err != context.Canceled {
+		t.Errorf("Err() after the lifetime ends = %v; want %v", err, context.Canceled)
+	}
+	if err := context.Cause(derived); err != cause {
+		t.Errorf("Cause() = %v; want %v", err, cause)
+	}
+}
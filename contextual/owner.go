@@ -0,0 +1,122 @@
+package contextual
+
+import (
+	"context"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Resolver translates between the user and group names passed to Chown and
+// Lchown, or reported by FileInfo.Owner and FileInfo.Group, and the
+// identities a backend stores, such as the numeric ids of an operating
+// system or the principals of an object store.
+//
+// Ids are the common currency between naming schemes: two layers naming
+// identities differently can exchange them through the ids both resolve to.
+type Resolver interface {
+	// UserID returns the id of the user named name.
+	UserID(ctx context.Context, name string) (string, error)
+	// GroupID returns the id of the group named name.
+	GroupID(ctx context.Context, name string) (string, error)
+	// UserName returns the name of the user with the given id.
+	UserName(ctx context.Context, id string) (string, error)
+	// GroupName returns the name of the group with the given id.
+	GroupName(ctx context.Context, id string) (string, error)
+}
+
+// OSResolver is the Resolver of the user and group databases of the
+// operating system, based on os/user. Numeric names are taken as ids.
+var OSResolver Resolver = osResolver{}
+
+type osResolver struct{}
+
+func (osResolver) UserID(ctx context.Context, name string) (string, error) {
+	return internal.UserID(name)
+}
+
+func (osResolver) GroupID(ctx context.Context, name string) (string, error) {
+	return internal.GroupID(name)
+}
+
+func (osResolver) UserName(ctx context.Context, id string) (string, error) {
+	return internal.UserName(id)
+}
+
+func (osResolver) GroupName(ctx context.Context, id string) (string, error) {
+	return internal.GroupName(id)
+}
+
+// resolverKey is the context key under which WithResolver stores the
+// Resolver.
+type resolverKey struct{}
+
+// WithResolver returns a copy of ctx carrying r as the Resolver of the names
+// passed to Chown and Lchown.
+//
+// The filesystems returned by ToContextual translate those names to ids with
+// r before passing them to the backend, so that backends expecting ids, like
+// osfs, resolve names the same way whatever the program runs on. Layers keep
+// passing names down unchanged.
+func WithResolver(ctx context.Context, r Resolver) context.Context {
+	return context.WithValue(ctx, resolverKey{}, r)
+}
+
+// ResolverFrom returns the Resolver carried by ctx, or OSResolver if there
+// is none.
+func ResolverFrom(ctx context.Context) Resolver {
+	if r, ok := ctx.Value(resolverKey{}).(Resolver); ok {
+		return r
+	}
+	return OSResolver
+}
+
+// resolveOwner translates owner and group to ids with the Resolver carried
+// by ctx, if any.
+func resolveOwner(ctx context.Context, owner, group string) (string, string, error) {
+	r, ok := ctx.Value(resolverKey{}).(Resolver)
+	if !ok {
+		return owner, group, nil
+	}
+	return TranslateOwner(ctx, r, nil, owner, group)
+}
+
+// TranslateOwner translates owner and group from the names of the naming
+// scheme of from to those of to, through the ids both resolve to. It is
+// meant to carry the ownership of a file to a layer naming identities
+// differently. If to is nil, the ids are returned. Empty names, which leave
+// the ownership unchanged, are kept.
+func TranslateOwner(ctx context.Context, from, to Resolver, owner, group string) (string, string, error) {
+	var err error
+	if owner != "" {
+		if owner, err = from.UserID(ctx, owner); err != nil {
+			return "", "", err
+		}
+		if to != nil {
+			if owner, err = to.UserName(ctx, owner); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	if group != "" {
+		if group, err = from.GroupID(ctx, group); err != nil {
+			return "", "", err
+		}
+		if to != nil {
+			if group, err = to.GroupName(ctx, group); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	return owner, group, nil
+}
+
+// chownWith resolves owner and group with the Resolver carried by ctx and
+// calls chown with the result, decorating resolution errors with op.
+func chownWith(ctx context.Context, op, name, owner, group string, chown func(owner, group string) error) error {
+	owner, group, err := resolveOwner(ctx, owner, group)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return chown(owner, group)
+}
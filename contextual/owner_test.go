@@ -0,0 +1,56 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/mockfs"
+	"go.uber.org/mock/gomock"
+)
+
+func TestTranslateOwner(t *testing.T) {
+	ctx := t.Context()
+	local := fsxtest.Resolver{Users: map[string]string{"alice": "1000"}, Groups: map[string]string{"staff": "50"}}
+	remote := fsxtest.Resolver{Users: map[string]string{"user/alice": "1000"}, Groups: map[string]string{"group/staff": "50"}}
+
+	owner, group, err := contextual.TranslateOwner(ctx, local, remote, "alice", "staff")
+	if err != nil || owner != "user/alice" || group != "group/staff" {
+		t.Errorf("TranslateOwner() = %q, %q, %v", owner, group, err)
+	}
+	owner, group, err = contextual.TranslateOwner(ctx, local, nil, "alice", "")
+	if err != nil || owner != "1000" || group != "" {
+		t.Errorf("TranslateOwner() to ids = %q, %q, %v", owner, group, err)
+	}
+	if _, _, err := contextual.TranslateOwner(ctx, local, remote, "bob", ""); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist for an unknown user, got %v", err)
+	}
+}
+
+func TestWithResolver(t *testing.T) {
+	if r := contextual.ResolverFrom(t.Context()); r != contextual.OSResolver {
+		t.Errorf("ResolverFrom() = %v, want OSResolver", r)
+	}
+
+	r := fsxtest.Resolver{Users: map[string]string{"alice": "1000"}, Groups: map[string]string{"staff": "50"}}
+	ctx := contextual.WithResolver(t.Context(), r)
+	if got := contextual.ResolverFrom(ctx); got == nil {
+		t.Fatal("ResolverFrom() = nil")
+	}
+
+	ctrl := gomock.NewController(t)
+	m := mockfs.NewMockChangeFS(ctrl)
+	m.EXPECT().Chown("file", "1000", "50").Return(nil)
+	fsys := contextual.ToContextual(m)
+	if err := contextual.Chown(ctx, fsys, "file", "alice", "staff"); err != nil {
+		t.Errorf("Chown() = %v", err)
+	}
+
+	var pathErr *fs.PathError
+	err := contextual.Chown(ctx, fsys, "file", "bob", "")
+	if !errors.As(err, &pathErr) || pathErr.Op != "chown" || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a chown error for an unknown user, got %v", err)
+	}
+}
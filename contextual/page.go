@@ -0,0 +1,63 @@
+package contextual
+
+import (
+	"context"
+	"io/fs"
+	"sort"
+)
+
+// ReadDirPageFS is the interface implemented by a file system that can list
+// a directory a page at a time, resuming from a cursor, so that enormous
+// directories can be browsed without listing them from the start for every
+// page.
+type ReadDirPageFS interface {
+	FS
+	// ReadDirPage reads at most n entries of the named directory, sorted by
+	// filename, following the position described by cursor, and returns
+	// them with the cursor of the next page. The empty cursor selects the
+	// first page, and the returned cursor is empty once the listing is
+	// complete. If n <= 0, it returns all of the remaining entries.
+	ReadDirPage(ctx context.Context, name, cursor string, n int) ([]fs.DirEntry, string, error)
+}
+
+// ReadDirPage reads at most n entries of the named directory, sorted by
+// filename, following the position described by cursor, and returns them
+// with the cursor of the next page. The empty cursor selects the first page,
+// and the returned cursor is empty once the listing is complete. If n <= 0,
+// it returns all of the remaining entries.
+//
+// Cursors are opaque strings, which can be handed to clients, such as the
+// page tokens of an HTTP API, and must be given back to the filesystem that
+// returned them. They stay valid while the directory changes: the entries
+// added or removed after the position of a cursor are seen by the following
+// pages, the others are not.
+//
+// If fsys implements ReadDirPageFS, it calls fsys.ReadDirPage. Otherwise it
+// lists the whole directory with ReadDir and returns the entries whose names
+// sort after the cursor, which is the name of the last entry of the previous
+// page.
+func ReadDirPage(ctx context.Context, fsys FS, name, cursor string, n int) ([]fs.DirEntry, string, error) {
+	if fsys, ok := fsys.(ReadDirPageFS); ok {
+		list, next, err := fsys.ReadDirPage(ctx, name, cursor, n)
+		return list, next, intoPathErr("readdir", name, err)
+	}
+
+	entries, err := ReadDir(ctx, fsys, name)
+	if err != nil {
+		return nil, "", err
+	}
+	list, next := page(entries, cursor, n)
+	return list, next, nil
+}
+
+// page returns the page of entries selected by cursor and n from the entries
+// of a whole directory sorted by name, along with the cursor of the next
+// page.
+func page(entries []fs.DirEntry, cursor string, n int) ([]fs.DirEntry, string) {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].Name() > cursor })
+	entries = entries[i:]
+	if n <= 0 || n >= len(entries) {
+		return entries, ""
+	}
+	return entries[:n], entries[n-1].Name()
+}
@@ -0,0 +1,94 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// pageFS lists directories with ReadDirPage.
+type pageFS struct {
+	contextual.FS
+}
+
+func (pageFS) ReadDirPage(ctx context.Context, name, cursor string, n int) ([]fs.DirEntry, string, error) {
+	if name != "." {
+		return nil, "", fs.ErrNotExist
+	}
+	return []fs.DirEntry{fs.FileInfoToDirEntry(fakeInfo(cursor + "x"))}, cursor + "x", nil
+}
+
+// names returns the names of entries.
+func names(entries []fs.DirEntry) []string {
+	list := make([]string, len(entries))
+	for i, e := range entries {
+		list[i] = e.Name()
+	}
+	return list
+}
+
+func TestReadDirPage(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("fallback", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ofs, err := osfs.New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys := contextual.ToContextual(ofs)
+
+		entries, cursor, err := contextual.ReadDirPage(ctx, fsys, ".", "", 2)
+		if err != nil || !slices.Equal(names(entries), []string{"a", "b"}) || cursor == "" {
+			t.Fatalf("first page = %v, %q, %v", names(entries), cursor, err)
+		}
+		// Entries added after the cursor show up in the following pages,
+		// those before it do not.
+		for _, name := range []string{"0", "bb"} {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		entries, cursor, err = contextual.ReadDirPage(ctx, fsys, ".", cursor, 2)
+		if err != nil || !slices.Equal(names(entries), []string{"bb", "c"}) || cursor == "" {
+			t.Fatalf("second page = %v, %q, %v", names(entries), cursor, err)
+		}
+		entries, cursor, err = contextual.ReadDirPage(ctx, fsys, ".", cursor, 0)
+		if err != nil || !slices.Equal(names(entries), []string{"d", "e"}) || cursor != "" {
+			t.Fatalf("last page = %v, %q, %v", names(entries), cursor, err)
+		}
+		entries, cursor, err = contextual.ReadDirPage(ctx, fsys, ".", "", 7)
+		if err != nil || len(entries) != 7 || cursor != "" {
+			t.Errorf("whole page = %v, %q, %v", names(entries), cursor, err)
+		}
+
+		if _, _, err := contextual.ReadDirPage(ctx, fsys, "missing", "", 2); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("native", func(t *testing.T) {
+		entries, cursor, err := contextual.ReadDirPage(ctx, pageFS{}, ".", "a", 1)
+		if err != nil || !slices.Equal(names(entries), []string{"ax"}) || cursor != "ax" {
+			t.Errorf("ReadDirPage() = %v, %q, %v", names(entries), cursor, err)
+		}
+
+		_, _, err = contextual.ReadDirPage(ctx, pageFS{}, "missing", "", 1)
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || pathErr.Op != "readdir" || pathErr.Path != "missing" {
+			t.Errorf("expected a readdir error on missing, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// PassthroughFS forwards the methods of FileSystem, SpecialFS, CloserFS and
+// the optional interfaces of this package, such as RenameOptionsFS and
+// XattrFS, to Inner through the helpers of this package, so that a layer
+// embedding it only has to implement the methods it changes. Names are
+// checked with fs.ValidPath before they are forwarded, and errors are
+// decorated with the operation and the names, like those of the layers of
+// this module.
+//
+// RenameWithOptions, Access, ReadDirInfos and Glob fail with
+// errors.ErrUnsupported if Inner does not implement them, so that the
+// helpers of this package fall back to the methods of the layer, such as
+// Rename or Stat, rather than to those of Inner. A layer changing the
+// metadata of the files reported by Stat or ReadDir has to implement Access
+// and ReadDirInfos itself, or they report the metadata of Inner. Inner is
+// not reported to Unwrap, so that a layer confining Inner does
+// not hand it out: a layer that does not confine it opts in with an Unwrap
+// method of its own.
+type PassthroughFS struct {
+	// Inner is the filesystem the methods are forwarded to.
+	Inner FS
+}
+
+// Open opens the named file for reading.
+func (p PassthroughFS) Open(ctx context.Context, name string) (fs.File, error) {
+	if err := internal.CheckPath("open", name); err != nil {
+		return nil, err
+	}
+	f, err := p.Inner.Open(ctx, name)
+	return f, internal.Decorate("open", name, err)
+}
+
+// RenameWithOptions renames a file as directed by flags, if Inner
+// implements RenameOptionsFS.
+func (p PassthroughFS) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	if err := internal.CheckLink("rename", oldname, newname); err != nil {
+		return err
+	}
+	rfs, ok := p.Inner.(RenameOptionsFS)
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+	}
+	return internal.DecorateLink("rename", oldname, newname, rfs.RenameWithOptions(ctx, oldname, newname, flags))
+}
+
+// Access checks whether the named file can be accessed with mode, if Inner
+// implements AccessFS.
+func (p PassthroughFS) Access(ctx context.Context, name string, mode uint32) error {
+	if err := internal.CheckPath("access", name); err != nil {
+		return err
+	}
+	afs, ok := p.Inner.(AccessFS)
+	if !ok {
+		return &fs.PathError{Op: "access", Path: name, Err: errors.ErrUnsupported}
+	}
+	return internal.Decorate("access", name, afs.Access(ctx, name, mode))
+}
+
+// ReadDirInfos reads the named directory and returns its entries with their
+// FileInfo, if Inner implements ReadDirInfoFS.
+func (p PassthroughFS) ReadDirInfos(ctx context.Context, name string) ([]FileInfoEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	rfs, ok := p.Inner.(ReadDirInfoFS)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.ErrUnsupported}
+	}
+	list, err := rfs.ReadDirInfos(ctx, name)
+	if err != nil {
+		return nil, internal.Decorate("readdir", name, err)
+	}
+	return list, nil
+}
+
+// Glob returns the names of all files matching pattern, if Inner implements
+// GlobFS.
+func (p PassthroughFS) Glob(ctx context.Context, pattern string) ([]string, error) {
+	gfs, ok := p.Inner.(GlobFS)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return gfs.Glob(ctx, pattern)
+}
+
+// Close closes Inner if it implements CloserFS.
+func (p PassthroughFS) Close() error {
+	return Close(p.Inner)
+}
+
+var _ FileSystem = PassthroughFS{}
+var _ SpecialFS = PassthroughFS{}
+var _ CloserFS = PassthroughFS{}
+var _ RenameOptionsFS = PassthroughFS{}
+var _ AccessFS = PassthroughFS{}
+var _ XattrFS = PassthroughFS{}
+var _ ReadDirInfoFS = PassthroughFS{}
+var _ GlobFS = PassthroughFS{}
@@ -0,0 +1,119 @@
+package contextual_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// upperFS reports the contents of files in upper case and forwards
+// everything else.
+type upperFS struct {
+	contextual.PassthroughFS
+}
+
+func (u upperFS) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	data, err := u.PassthroughFS.ReadFile(ctx, name)
+	return bytes.ToUpper(data), err
+}
+
+func TestPassthroughFS(t *testing.T) {
+	ctx := t.Context()
+	root, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := contextual.ToContextual(root)
+	fsys := upperFS{contextual.PassthroughFS{Inner: base}}
+
+	if err := contextual.MkdirAll(ctx, fsys, "dir/sub", 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := contextual.WriteFile(ctx, fsys, "dir/a", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := contextual.Rename(ctx, fsys, "dir/a", "dir/b"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+
+	// The override is used.
+	if data, err := contextual.ReadFile(ctx, fsys, "dir/b"); err != nil || string(data) != "HELLO" {
+		t.Errorf("ReadFile = %q, %v; want HELLO", data, err)
+	}
+	// The other methods reach the inner filesystem.
+	if data, err := contextual.ReadFile(ctx, base, "dir/b"); err != nil || string(data) != "hello" {
+		t.Errorf("inner ReadFile = %q, %v; want hello", data, err)
+	}
+	if err := contextual.Truncate(ctx, fsys, "dir/b", 2); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	if fi, err := contextual.Stat(ctx, fsys, "dir/b"); err != nil || fi.Size() != 2 {
+		t.Errorf("Stat = %v, %v; want size 2", fi, err)
+	}
+	if entries, err := contextual.ReadDir(ctx, fsys, "dir"); err != nil || len(entries) != 2 {
+		t.Errorf("ReadDir = %v, %v; want 2 entries", entries, err)
+	}
+	if err := contextual.RemoveAll(ctx, fsys, "dir"); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	fsxtest.CheckNotExist(t, root, "dir")
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := contextual.Stat(ctx, fsys, "missing")
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || pathErr.Op != "stat" || pathErr.Path != "missing" {
+			t.Errorf("Stat error = %v; want a stat PathError for missing", err)
+		}
+		fsxtest.CheckErrorOps(t, fsys, "missing")
+		fsxtest.CheckInvalidPaths(t, fsys)
+	})
+
+	t.Run("optional interfaces", func(t *testing.T) {
+		if err := contextual.MkdirAll(ctx, fsys, "dir/sub", 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"dir/a", "dir/b"} {
+			if err := contextual.WriteFile(ctx, fsys, name, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := fsys.RenameWithOptions(ctx, "dir/b", "dir/a", fsx.RenameNoReplace); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("RenameWithOptions(noreplace) error = %v; want ErrExist", err)
+		}
+		if err := fsys.Access(ctx, "dir/a", fsx.R_OK); err != nil {
+			t.Errorf("Access(dir/a) = %v", err)
+		}
+		if list, err := contextual.ReadDirInfos(ctx, fsys, "dir"); err != nil || len(list) != 3 {
+			t.Errorf("ReadDirInfos(dir) = %v, %v; want 3 entries", list, err)
+		}
+
+		// Without the interfaces in Inner, the helpers fall back to the
+		// methods of the layer.
+		bare := upperFS{contextual.PassthroughFS{Inner: struct{ contextual.FileSystem }{base.(contextual.FileSystem)}}}
+		if err := bare.RenameWithOptions(ctx, "dir/b", "dir/a", fsx.RenameNoReplace); !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("RenameWithOptions error = %v; want ErrUnsupported", err)
+		}
+		if err := contextual.RenameWithOptions(ctx, bare, "dir/b", "dir/a", fsx.RenameNoReplace); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("emulated RenameWithOptions(noreplace) error = %v; want ErrExist", err)
+		}
+		if err := contextual.Access(ctx, bare, "dir/a", fsx.R_OK); err != nil {
+			t.Errorf("emulated Access(dir/a) = %v", err)
+		}
+		if list, err := contextual.ReadDirInfos(ctx, bare, "dir"); err != nil || len(list) != 3 {
+			t.Errorf("emulated ReadDirInfos(dir) = %v, %v; want 3 entries", list, err)
+		}
+		if names, err := contextual.Glob(ctx, bare, "dir/?"); err != nil || len(names) != 2 {
+			t.Errorf("emulated Glob(dir/?) = %v, %v; want 2 names", names, err)
+		}
+		if layers := contextual.Unwrap(fsys); layers != nil {
+			t.Errorf("Unwrap() = %v; want Inner hidden", layers)
+		}
+	})
+}
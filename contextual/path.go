@@ -0,0 +1,27 @@
+package contextual
+
+import "github.com/gwangyi/fsx/internal"
+
+// JoinValid returns the path of the entry elem of the directory dir, as
+// listed by ReadDir. It returns an *fs.PathError with Err set to
+// fs.ErrInvalid if elem is not a single valid path element, such as "..",
+// so that a layer joining the names listed by its backend cannot be led
+// outside dir.
+func JoinValid(dir, elem string) (string, error) {
+	return internal.JoinValid(dir, elem)
+}
+
+// HasPathPrefix reports whether the clean path name is prefix or lies
+// beneath it. Unlike strings.HasPrefix, it compares whole elements, so
+// "dir2/a" does not have the prefix "dir", and every name has the prefix
+// ".".
+func HasPathPrefix(name, prefix string) bool {
+	return internal.HasPathPrefix(name, prefix)
+}
+
+// SplitDirFile splits name into its parent directory and its last element.
+// Unlike path.Split, the directory is a valid path, "." for names at the
+// top level, and can be passed to the other functions of this package.
+func SplitDirFile(name string) (dir, file string) {
+	return internal.SplitDirFile(name)
+}
@@ -0,0 +1,67 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+func TestJoinValid(t *testing.T) {
+	for _, tc := range []struct {
+		dir, elem, want string
+	}{
+		{".", "a", "a"},
+		{"dir", "a", "dir/a"},
+		{"dir/sub", ".a", "dir/sub/.a"},
+	} {
+		got, err := contextual.JoinValid(tc.dir, tc.elem)
+		if err != nil || got != tc.want {
+			t.Errorf("JoinValid(%q, %q) = %q, %v; want %q", tc.dir, tc.elem, got, err, tc.want)
+		}
+	}
+	for _, elem := range []string{"", ".", "..", "a/b", "a/", "/a"} {
+		got, err := contextual.JoinValid("dir", elem)
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("JoinValid(dir, %q) = %q, %v; want an fs.ErrInvalid PathError", elem, got, err)
+		}
+	}
+}
+
+func TestHasPathPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		name, prefix string
+		want         bool
+	}{
+		{"dir", "dir", true},
+		{"dir/a", "dir", true},
+		{"dir/a/b", "dir/a", true},
+		{"dir2", "dir", false},
+		{"dir2/a", "dir", false},
+		{"di", "dir", false},
+		{"a", ".", true},
+		{".", ".", true},
+		{".", "a", false},
+	} {
+		if got := contextual.HasPathPrefix(tc.name, tc.prefix); got != tc.want {
+			t.Errorf("HasPathPrefix(%q, %q) = %v; want %v", tc.name, tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestSplitDirFile(t *testing.T) {
+	for _, tc := range []struct {
+		name, dir, file string
+	}{
+		{"a", ".", "a"},
+		{"dir/a", "dir", "a"},
+		{"dir/sub/a", "dir/sub", "a"},
+	} {
+		dir, file := contextual.SplitDirFile(tc.name)
+		if dir != tc.dir || file != tc.file {
+			t.Errorf("SplitDirFile(%q) = %q, %q; want %q, %q", tc.name, dir, file, tc.dir, tc.file)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package contextual
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// ProbeOption configures Probe.
+type ProbeOption func(*probeOptions)
+
+// probeOptions holds the settings applied by ProbeOption.
+type probeOptions struct {
+	canary string
+	clock  Clock
+}
+
+// Canary makes Probe write a file named name through the probed filesystem,
+// read it back and remove it, so that the readiness of the whole stack for
+// writes is checked too. name should be reserved for the probe, such as
+// ".probe", as a file by that name is overwritten and removed.
+func Canary(name string) ProbeOption {
+	return func(o *probeOptions) { o.canary = name }
+}
+
+// ProbeClock sets the clock timing the probes. If nil, RealClock is used.
+func ProbeClock(clock Clock) ProbeOption {
+	return func(o *probeOptions) { o.clock = clock }
+}
+
+// ProbeResult is the outcome of a probe of a filesystem.
+type ProbeResult struct {
+	// FS is the filesystem probed.
+	FS FS
+	// Depth is the number of layers above FS, 0 for the filesystem given to
+	// Probe.
+	Depth int
+	// Latency is the time the probe took.
+	Latency time.Duration
+	// Err is the error of the probe, or nil if it succeeded.
+	Err error
+}
+
+// Report is the outcome of Probe.
+type Report struct {
+	// Layers holds the results of the Stat of "." of the probed filesystem
+	// and of every layer beneath it, found with Unwrap, in depth-first
+	// order: a layer comes right before the layers it is built on.
+	Layers []ProbeResult
+	// Canary is the result of the round trip of the file set with the
+	// Canary option through the probed filesystem, or nil without it.
+	Canary *ProbeResult
+}
+
+// Err returns the errors of the probes joined with errors.Join, or nil if
+// they all succeeded, so that a readiness endpoint can fail on it.
+func (r Report) Err() error {
+	var errs []error
+	for _, l := range r.Layers {
+		errs = append(errs, l.Err)
+	}
+	if r.Canary != nil {
+		errs = append(errs, r.Canary.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// Probe checks that fsys is available, for readiness endpoints of services
+// depending on it. It stats the root of fsys and of each layer it is built
+// on, which tells the layer that fails or slows down the stack, and runs the
+// round trip set with Canary, if any. Only the canary changes anything.
+//
+// The probes run one after the other, each with ctx: give it a deadline so
+// that a hung layer does not hold the probe forever.
+func Probe(ctx context.Context, fsys FS, opts ...ProbeOption) Report {
+	var o probeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	clock := ClockOr(o.clock)
+	timed := func(fsys FS, depth int, probe func() error) ProbeResult {
+		start := clock.Now()
+		err := probe()
+		return ProbeResult{FS: fsys, Depth: depth, Latency: clock.Now().Sub(start), Err: err}
+	}
+
+	var r Report
+	var walk func(fsys FS, depth int)
+	walk = func(fsys FS, depth int) {
+		r.Layers = append(r.Layers, timed(fsys, depth, func() error {
+			_, err := Stat(ctx, fsys, ".")
+			return err
+		}))
+		for _, layer := range Unwrap(fsys) {
+			walk(layer, depth+1)
+		}
+	}
+	walk(fsys, 0)
+
+	if o.canary != "" {
+		result := timed(fsys, 0, func() error {
+			return probeCanary(ctx, fsys, o.canary, clock)
+		})
+		r.Canary = &result
+	}
+	return r
+}
+
+// probeCanary writes, reads back and removes the canary file name.
+func probeCanary(ctx context.Context, fsys FS, name string, clock Clock) error {
+	if err := internal.CheckPath("probe", name); err != nil {
+		return err
+	}
+	data := []byte("fsx probe " + strconv.FormatInt(clock.Now().UnixNano(), 10))
+	if err := WriteFile(ctx, fsys, name, data, 0644); err != nil {
+		return err
+	}
+	got, err := ReadFile(ctx, fsys, name)
+	if err == nil && !bytes.Equal(got, data) {
+		err = &fs.PathError{Op: "probe", Path: name, Err: fmt.Errorf("read back %d bytes differing from the %d written", len(got), len(data))}
+	}
+	return errors.Join(err, Remove(ctx, fsys, name))
+}
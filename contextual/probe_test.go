@@ -0,0 +1,81 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+// slowFS makes its Stat take delay on clock.
+type slowFS struct {
+	contextual.PassthroughFS
+	clock *fsxtest.Clock
+	delay time.Duration
+}
+
+func (s slowFS) Unwrap() contextual.FS {
+	return s.Inner
+}
+
+func (s slowFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	s.clock.Advance(s.delay)
+	return s.PassthroughFS.Stat(ctx, name)
+}
+
+func TestProbe(t *testing.T) {
+	ctx := t.Context()
+	clock := fsxtest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	t.Run("healthy", func(t *testing.T) {
+		base := memfs.New(memfs.Config{})
+		fsys := contextual.Coalesce(slowFS{PassthroughFS: contextual.PassthroughFS{Inner: base}, clock: clock, delay: time.Second})
+		r := contextual.Probe(ctx, fsys, contextual.Canary(".probe"), contextual.ProbeClock(clock))
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Layers) != 3 {
+			t.Fatalf("expected 3 layers, got %d", len(r.Layers))
+		}
+		for i, l := range r.Layers {
+			if l.Depth != i {
+				t.Errorf("layer %d: got depth %d", i, l.Depth)
+			}
+		}
+		if r.Layers[2].FS != base || r.Layers[2].Latency != 0 || r.Layers[1].Latency != time.Second {
+			t.Errorf("unexpected layers %+v", r.Layers)
+		}
+		if r.Canary == nil || r.Canary.Err != nil {
+			t.Errorf("unexpected canary %+v", r.Canary)
+		}
+		if _, err := contextual.Stat(ctx, base, ".probe"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the canary to be removed, got %v", err)
+		}
+	})
+
+	t.Run("failing layer", func(t *testing.T) {
+		fsys := contextual.Coalesce(fsxtest.NewErrorFS(memfs.New(memfs.Config{}), map[fsxtest.Fault]error{{Op: "stat"}: fs.ErrPermission}))
+		r := contextual.Probe(ctx, fsys)
+		if err := r.Err(); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("expected ErrPermission, got %v", err)
+		}
+		if r.Layers[0].Err == nil || r.Layers[1].Err == nil || r.Layers[2].Err != nil {
+			t.Errorf("unexpected layers %+v", r.Layers)
+		}
+		if r.Canary != nil {
+			t.Errorf("unexpected canary %+v", r.Canary)
+		}
+	})
+
+	t.Run("invalid canary", func(t *testing.T) {
+		r := contextual.Probe(ctx, memfs.New(memfs.Config{}), contextual.Canary("../probe"))
+		if err := r.Err(); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,325 @@
+package contextual
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// DefaultReadFileCacheSize is the total size of the contents kept by
+// CacheReadFile unless ReadFileCacheConfig.MaxBytes is given.
+const DefaultReadFileCacheSize = 16 << 20
+
+// ETagFS is the interface implemented by a file system that can report a
+// validator of the contents of a file, such as the ETag of an object store,
+// which changes whenever the contents do.
+type ETagFS interface {
+	FS
+	// ETag returns the validator of the contents of the named file. An
+	// error wrapping errors.ErrUnsupported makes callers fall back to the
+	// modification time and size of the file.
+	ETag(ctx context.Context, name string) (string, error)
+}
+
+// ReadFileCacheConfig specifies the configuration of CacheReadFile.
+type ReadFileCacheConfig struct {
+	// MaxBytes is the total size of the cached contents. When it is
+	// exceeded, the least recently read files are dropped. If 0,
+	// DefaultReadFileCacheSize is used.
+	MaxBytes int64
+	// MaxEntrySize is the size of the largest file cached. If 0,
+	// SmallFileSize is used.
+	MaxEntrySize int64
+}
+
+// CacheReadFile returns a filesystem forwarding to fsys, like PassthroughFS,
+// whose ReadFile keeps the contents of small files in memory. It suits
+// frequently read small files, such as configurations and manifests, above
+// slow filesystems.
+//
+// The contents are cached along with a validator of the file: its ETag if
+// fsys implements ETagFS, or else its modification time and size. Every
+// ReadFile looks the validator up and only returns the cached contents if it
+// did not change, so that changes made behind the back of the cache are
+// seen, provided they change the validator; placing the cache above a
+// filesystem caching Stat trades that for fewer round-trips. Changes made
+// through the cache drop the contents of the files they change, whose
+// validator may stay the same, such as a rewrite of the same size within
+// the resolution of the modification time, and a file open for writing
+// through the cache is not cached until it is closed.
+func CacheReadFile(fsys FS, config ReadFileCacheConfig) FileSystem {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultReadFileCacheSize
+	}
+	if config.MaxEntrySize <= 0 {
+		config.MaxEntrySize = SmallFileSize
+	}
+	return &readFileCache{
+		PassthroughFS: PassthroughFS{Inner: fsys},
+		config:        config,
+		cache:         make(map[string]*list.Element),
+		lru:           list.New(),
+		writers:       make(map[string]int),
+	}
+}
+
+// readFileCache overrides the ReadFile method of the embedded PassthroughFS.
+type readFileCache struct {
+	PassthroughFS
+	config ReadFileCacheConfig
+
+	mu sync.Mutex
+	// cache maps names to their elements in lru. The front of lru holds the
+	// most recently read contents.
+	cache map[string]*list.Element
+	lru   *list.List
+	size  int64
+	// gen counts the changes made through the cache, so that contents read
+	// across one are not cached.
+	gen uint64
+	// writers counts the files open for writing through the cache by name.
+	writers map[string]int
+}
+
+// cachedContents holds the contents of a file read with the given validator.
+type cachedContents struct {
+	name      string
+	validator string
+	data      []byte
+}
+
+// Unwrap returns the filesystem the files are read from.
+func (c *readFileCache) Unwrap() FS {
+	return c.Inner
+}
+
+// ReadFile reads the named file and returns its contents, from the cache if
+// the file did not change since it was cached. The returned slice is the
+// caller's to change.
+func (c *readFileCache) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := internal.CheckPath("readfile", name); err != nil {
+		return nil, err
+	}
+	validator, ok := c.validator(ctx, name)
+	if !ok {
+		data, err := ReadFile(ctx, c.Inner, name)
+		return data, internal.Decorate("readfile", name, err)
+	}
+	data, gen, ok := c.lookup(name, validator)
+	if ok {
+		return data, nil
+	}
+
+	data, err := ReadFile(ctx, c.Inner, name)
+	if err != nil {
+		return nil, internal.Decorate("readfile", name, err)
+	}
+	// The file may have changed while it was read; the contents are only
+	// cached if the validator is the same on both sides of the read.
+	if after, ok := c.validator(ctx, name); ok && after == validator && int64(len(data)) <= c.config.MaxEntrySize {
+		c.store(name, validator, slices.Clone(data), gen)
+	}
+	return data, nil
+}
+
+// validator returns the validator of the contents of the named file. It
+// reports false if it cannot be found, or if the file is not one to cache.
+func (c *readFileCache) validator(ctx context.Context, name string) (string, bool) {
+	if fsys, ok := c.Inner.(ETagFS); ok {
+		etag, err := fsys.ETag(ctx, name)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return etag, err == nil
+		}
+	}
+	info, err := Stat(ctx, c.Inner, name)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > c.config.MaxEntrySize {
+		return "", false
+	}
+	return strconv.FormatInt(info.ModTime().UnixNano(), 10) + "/" + strconv.FormatInt(info.Size(), 10), true
+}
+
+// lookup returns a copy of the contents of name cached with validator.
+// Otherwise, it returns the generation of the cache to store the contents
+// read next with.
+func (c *readFileCache) lookup(name, validator string) ([]byte, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.cache[name]
+	if !ok {
+		return nil, c.gen, false
+	}
+	e := el.Value.(*cachedContents)
+	if e.validator != validator {
+		c.dropLocked(el)
+		return nil, c.gen, false
+	}
+	c.lru.MoveToFront(el)
+	return slices.Clone(e.data), 0, true
+}
+
+// store caches data as the contents of name read with validator, dropping
+// the least recently read contents beyond MaxBytes. It does nothing if the
+// files were changed through the cache since generation gen, or if name is
+// open for writing.
+func (c *readFileCache) store(name, validator string, data []byte, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gen != gen || c.writers[name] > 0 {
+		return
+	}
+	if el, ok := c.cache[name]; ok {
+		c.dropLocked(el)
+	}
+	c.cache[name] = c.lru.PushFront(&cachedContents{name: name, validator: validator, data: data})
+	c.size += int64(len(data))
+	for c.size > c.config.MaxBytes {
+		c.dropLocked(c.lru.Back())
+	}
+}
+
+// dropLocked removes el from the cache.
+// It must be called with c.mu held.
+func (c *readFileCache) dropLocked(el *list.Element) {
+	e := c.lru.Remove(el).(*cachedContents)
+	delete(c.cache, e.name)
+	c.size -= int64(len(e.data))
+}
+
+// invalidate drops the cached contents of the named files, and of the files
+// beneath them, once they were changed through the cache.
+func (c *readFileCache) invalidate(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		name := el.Value.(*cachedContents).name
+		if slices.ContainsFunc(names, func(changed string) bool { return HasPathPrefix(name, changed) }) {
+			c.dropLocked(el)
+		}
+		el = next
+	}
+}
+
+// openWriter keeps the contents of name from being cached until closeWriter
+// is called.
+func (c *readFileCache) openWriter(name string) {
+	c.mu.Lock()
+	c.writers[name]++
+	c.mu.Unlock()
+	c.invalidate(name)
+}
+
+// closeWriter undoes openWriter once the file open for writing is closed.
+func (c *readFileCache) closeWriter(name string) {
+	c.mu.Lock()
+	if c.writers[name]--; c.writers[name] == 0 {
+		delete(c.writers, name)
+	}
+	c.mu.Unlock()
+	c.invalidate(name)
+}
+
+// Create creates or truncates the named file.
+func (c *readFileCache) Create(ctx context.Context, name string) (File, error) {
+	return c.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the named file, keeping it from being cached until it is
+// closed if it is opened for writing.
+func (c *readFileCache) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return c.PassthroughFS.OpenFile(ctx, name, flag, mode)
+	}
+	c.openWriter(name)
+	f, err := c.PassthroughFS.OpenFile(ctx, name, flag, mode)
+	if err != nil {
+		c.closeWriter(name)
+		return nil, err
+	}
+	return internal.WrapFile(&writerFile{File: f, c: c, name: name}, f), nil
+}
+
+// WriteFile writes data to the named file.
+func (c *readFileCache) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	defer c.invalidate(name)
+	return c.PassthroughFS.WriteFile(ctx, name, data, perm)
+}
+
+// Truncate changes the size of the named file.
+func (c *readFileCache) Truncate(ctx context.Context, name string, size int64) error {
+	defer c.invalidate(name)
+	return c.PassthroughFS.Truncate(ctx, name, size)
+}
+
+// Chtimes changes the access and modification times of the named file,
+// which may give it back the validator of contents it no longer has.
+func (c *readFileCache) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	defer c.invalidate(name)
+	return c.PassthroughFS.Chtimes(ctx, name, atime, mtime)
+}
+
+// Remove removes the named file or empty directory.
+func (c *readFileCache) Remove(ctx context.Context, name string) error {
+	defer c.invalidate(name)
+	return c.PassthroughFS.Remove(ctx, name)
+}
+
+// RemoveAll removes name and any children it contains.
+func (c *readFileCache) RemoveAll(ctx context.Context, name string) error {
+	defer c.invalidate(name)
+	return c.PassthroughFS.RemoveAll(ctx, name)
+}
+
+// Rename renames oldname to newname.
+func (c *readFileCache) Rename(ctx context.Context, oldname, newname string) error {
+	defer c.invalidate(oldname, newname)
+	return c.PassthroughFS.Rename(ctx, oldname, newname)
+}
+
+// RenameWithOptions renames oldname to newname as directed by flags.
+func (c *readFileCache) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	defer c.invalidate(oldname, newname)
+	return c.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (c *readFileCache) Symlink(ctx context.Context, oldname, newname string) error {
+	defer c.invalidate(newname)
+	return c.PassthroughFS.Symlink(ctx, oldname, newname)
+}
+
+// CreateSpecial creates the named special file.
+func (c *readFileCache) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	defer c.invalidate(name)
+	return c.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+}
+
+// writerFile is a file open for writing through a readFileCache, which it
+// lets cache its contents again once closed. It is exposed through
+// internal.WrapFile, keeping the optional interfaces of the file.
+type writerFile struct {
+	File
+	c    *readFileCache
+	name string
+	once sync.Once
+}
+
+// Close closes the file.
+func (f *writerFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(func() { f.c.closeWriter(f.name) })
+	return err
+}
@@ -0,0 +1,179 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+// countingFS counts the calls to ReadFile.
+type countingFS struct {
+	contextual.FileSystem
+	reads int
+}
+
+func (f *countingFS) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	f.reads++
+	return f.FileSystem.ReadFile(ctx, name)
+}
+
+// etagFS reports the validators held in etags, or none if it is nil.
+type etagFS struct {
+	*countingFS
+	etags map[string]string
+}
+
+func (f etagFS) ETag(ctx context.Context, name string) (string, error) {
+	if f.etags == nil {
+		return "", errors.ErrUnsupported
+	}
+	etag, ok := f.etags[name]
+	if !ok {
+		return "", fs.ErrNotExist
+	}
+	return etag, nil
+}
+
+func TestCacheReadFile(t *testing.T) {
+	ctx := t.Context()
+
+	read := func(t *testing.T, fsys contextual.FS, name, want string) {
+		t.Helper()
+		data, err := contextual.ReadFile(ctx, fsys, name)
+		if err != nil || string(data) != want {
+			t.Fatalf("ReadFile(%s) = %q, %v; want %q", name, data, err, want)
+		}
+		// The caller owns the returned contents.
+		for i := range data {
+			data[i] = 'x'
+		}
+	}
+
+	t.Run("ModTime", func(t *testing.T) {
+		clock := fsxtest.NewClock(time.Unix(1000, 0))
+		inner := &countingFS{FileSystem: memfs.New(memfs.Config{Clock: clock})}
+		fsys := contextual.CacheReadFile(inner, contextual.ReadFileCacheConfig{MaxEntrySize: 4})
+		for name, data := range map[string]string{"small": "abc", "large": "abcde"} {
+			if err := contextual.WriteFile(ctx, inner, name, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		read(t, fsys, "small", "abc")
+		read(t, fsys, "small", "abc")
+		if inner.reads != 1 {
+			t.Errorf("expected 1 read of the backend, got %d", inner.reads)
+		}
+		read(t, fsys, "large", "abcde")
+		read(t, fsys, "large", "abcde")
+		if inner.reads != 3 {
+			t.Errorf("expected large files not to be cached, got %d reads", inner.reads)
+		}
+
+		// A change behind the back of the cache is seen once the validator
+		// changes.
+		clock.Advance(time.Second)
+		if err := contextual.WriteFile(ctx, inner, "small", []byte("xyz"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "small", "xyz")
+
+		if _, err := contextual.ReadFile(ctx, fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected ErrNotExist, got %v", err)
+		}
+		if _, err := contextual.ReadFile(ctx, fsys, "/invalid"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("ETag", func(t *testing.T) {
+		inner := etagFS{countingFS: &countingFS{FileSystem: memfs.New(memfs.Config{})}, etags: map[string]string{"a": "1", "b": "1"}}
+		fsys := contextual.CacheReadFile(inner, contextual.ReadFileCacheConfig{MaxBytes: 4})
+		for _, name := range []string{"a", "b"} {
+			if err := contextual.WriteFile(ctx, inner, name, []byte(name+name+name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		read(t, fsys, "a", "aaa")
+		read(t, fsys, "a", "aaa")
+		if inner.reads != 1 {
+			t.Errorf("expected 1 read of the backend, got %d", inner.reads)
+		}
+		// Caching b evicts a, as both do not fit in MaxBytes.
+		read(t, fsys, "b", "bbb")
+		read(t, fsys, "a", "aaa")
+		if inner.reads != 3 {
+			t.Errorf("expected a to be evicted, got %d reads", inner.reads)
+		}
+
+		if err := contextual.WriteFile(ctx, inner, "a", []byte("AAA"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "a", "aaa")
+		inner.etags["a"] = "2"
+		read(t, fsys, "a", "AAA")
+	})
+
+	t.Run("local writes", func(t *testing.T) {
+		// The clock never moves, so that rewrites of the same size keep the
+		// validator.
+		inner := &countingFS{FileSystem: memfs.New(memfs.Config{Clock: fsxtest.NewClock(time.Unix(1000, 0))})}
+		fsys := contextual.CacheReadFile(inner, contextual.ReadFileCacheConfig{})
+		if err := contextual.WriteFile(ctx, fsys, "a", []byte("aaa"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "a", "aaa")
+		if err := contextual.WriteFile(ctx, fsys, "a", []byte("bbb"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "a", "bbb")
+
+		f, err := contextual.OpenFile(ctx, fsys, "a", os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "a", "bbb")
+		if _, err := f.Write([]byte("ccc")); err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "a", "ccc")
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "a", "ccc")
+		reads := inner.reads
+		read(t, fsys, "a", "ccc")
+		if inner.reads != reads {
+			t.Errorf("expected a to be cached again once closed, got %d reads", inner.reads-reads)
+		}
+
+		if err := contextual.Rename(ctx, fsys, "a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, inner, "a", []byte("ddd"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "a", "ddd")
+	})
+
+	t.Run("unsupported ETag", func(t *testing.T) {
+		inner := etagFS{countingFS: &countingFS{FileSystem: memfs.New(memfs.Config{})}}
+		fsys := contextual.CacheReadFile(inner, contextual.ReadFileCacheConfig{})
+		if err := contextual.WriteFile(ctx, inner, "a", []byte("aaa"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		read(t, fsys, "a", "aaa")
+		read(t, fsys, "a", "aaa")
+		if inner.reads != 1 {
+			t.Errorf("expected the modification time to validate, got %d reads", inner.reads)
+		}
+	})
+}
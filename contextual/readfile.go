@@ -0,0 +1,134 @@
+package contextual
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"slices"
+)
+
+// SmallFileSize is the size up to which ReadFileInto and AppendFile read a
+// file with the ReadFileFS of its filesystem, in a single call. Larger files
+// are streamed through Open so that they are never held in memory at once.
+const SmallFileSize = 64 << 10
+
+// ReadFileInto writes the contents of the named file to w and returns the
+// number of bytes written. Unlike ReadFile, it does not hold the whole file
+// in memory: only files of at most SmallFileSize bytes are read with
+// fsys.ReadFile if fsys implements ReadFileFS, and the others are copied
+// from the opened file like SendFile does.
+func ReadFileInto(ctx context.Context, fsys FS, name string, w io.Writer) (int64, error) {
+	if readsWhole(ctx, fsys, name) {
+		data, err := ReadFile(ctx, fsys, name)
+		if err != nil {
+			return 0, intoPathErr("readfile", name, err)
+		}
+		n, err := w.Write(data)
+		return int64(n), intoPathErr("readfile", name, err)
+	}
+
+	f, err := fsys.Open(ctx, name)
+	if err != nil {
+		return 0, intoPathErr("readfile", name, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	result, err := copyContents(w, f)
+	return result.Bytes, intoPathErr("readfile", name, err)
+}
+
+// AppendFile appends the contents of the named file to buf and returns the
+// extended buffer, so that a caller reading many files can reuse one
+// buffer. The buffer is grown once to the size reported by the file, and
+// files of at most SmallFileSize bytes are read with fsys.ReadFile if fsys
+// implements ReadFileFS. On error, the returned buffer holds what was read
+// before the error.
+func AppendFile(ctx context.Context, fsys FS, name string, buf []byte) ([]byte, error) {
+	if readsWhole(ctx, fsys, name) {
+		data, err := ReadFile(ctx, fsys, name)
+		return append(buf, data...), intoPathErr("readfile", name, err)
+	}
+
+	f, err := fsys.Open(ctx, name)
+	if err != nil {
+		return buf, intoPathErr("readfile", name, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	buf, err = readAll(ctx, fsys, name, f, buf)
+	return buf, intoPathErr("readfile", name, err)
+}
+
+// readAll appends the contents of f, the named file of fsys, to buf and
+// returns the extended buffer. Unlike io.ReadAll, which grows the buffer as
+// it reads, it grows it once to the size given by fileSize.
+func readAll(ctx context.Context, fsys FS, name string, f fs.File, buf []byte) ([]byte, error) {
+	size, err := fileSize(ctx, fsys, name, f)
+	if err != nil {
+		return buf, err
+	}
+	if size > 0 {
+		// One more byte lets the final read report io.EOF without growing
+		// the buffer.
+		buf = slices.Grow(buf, int(size)+1)
+	}
+	for {
+		if len(buf) == cap(buf) {
+			buf = slices.Grow(buf, 512)
+		}
+		n, err := f.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			return buf, nil
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
+}
+
+// fileSize returns the size of f, the named file of fsys just opened, or -1
+// if it is unknown. The size is reported by f, or by the StatFS of fsys if
+// f cannot report it, and a file reported by neither is measured by seeking
+// to its end and back. An error is returned only if f could not be seeked
+// back.
+func fileSize(ctx context.Context, fsys FS, name string, f fs.File) (int64, error) {
+	info, err := f.Stat()
+	if sfs, ok := fsys.(StatFS); ok && err != nil {
+		info, err = sfs.Stat(ctx, name)
+	}
+	if err == nil {
+		if !info.Mode().IsRegular() {
+			return -1, nil
+		}
+		return info.Size(), nil
+	}
+
+	s, ok := f.(io.Seeker)
+	if !ok {
+		return -1, nil
+	}
+	offset, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1, nil
+	}
+	end, err := s.Seek(0, io.SeekEnd)
+	if _, serr := s.Seek(offset, io.SeekStart); serr != nil {
+		return -1, serr
+	}
+	if err != nil || end < offset {
+		return -1, nil
+	}
+	return end - offset, nil
+}
+
+// readsWhole reports whether the named file is read with the ReadFileFS of
+// fsys: it must be implemented, and the file must be a regular file of at
+// most SmallFileSize bytes.
+func readsWhole(ctx context.Context, fsys FS, name string) bool {
+	if _, ok := fsys.(ReadFileFS); !ok {
+		return false
+	}
+	info, err := Stat(ctx, fsys, name)
+	return err == nil && info.Mode().IsRegular() && info.Size() <= SmallFileSize
+}
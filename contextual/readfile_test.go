@@ -0,0 +1,209 @@
+package contextual_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// readFileCounter counts the calls to its ReadFile.
+type readFileCounter struct {
+	contextual.FS
+	calls *int
+}
+
+func (r readFileCounter) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	*r.calls++
+	return contextual.ReadFile(ctx, r.FS, name)
+}
+
+func TestReadFileInto(t *testing.T) {
+	ctx := t.Context()
+	base, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backing := contextual.ToContextual(base)
+	var calls int
+	fsys := readFileCounter{FS: backing, calls: &calls}
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large"), contextual.SmallFileSize)
+	for name, data := range map[string][]byte{"small": small, "large": large} {
+		if err := contextual.WriteFile(ctx, backing, name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		readFiles int
+	}{
+		{"small", small, 1},
+		{"large", large, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			var buf bytes.Buffer
+			n, err := contextual.ReadFileInto(ctx, fsys, tt.name, &buf)
+			if err != nil || n != int64(len(tt.data)) || !bytes.Equal(buf.Bytes(), tt.data) {
+				t.Errorf("ReadFileInto() = %d, %v, wrote %d bytes", n, err, buf.Len())
+			}
+
+			prefix := []byte("prefix:")
+			got, err := contextual.AppendFile(ctx, fsys, tt.name, prefix)
+			if err != nil || !bytes.Equal(got, append(prefix, tt.data...)) {
+				t.Errorf("AppendFile() = %d bytes, %v", len(got), err)
+			}
+			if calls != 2*tt.readFiles {
+				t.Errorf("ReadFile called %d times, want %d", calls, 2*tt.readFiles)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := contextual.ReadFileInto(ctx, fsys, "missing", &buf); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("ReadFileInto() error = %v, want ErrNotExist", err)
+		}
+		got, err := contextual.AppendFile(ctx, fsys, "missing", []byte("kept"))
+		if !errors.Is(err, fs.ErrNotExist) || string(got) != "kept" {
+			t.Errorf("AppendFile() = %q, %v, want kept and ErrNotExist", got, err)
+		}
+	})
+}
+
+// openOnly hides the ReadFileFS of its filesystem, so that ReadFile falls
+// back to reading the opened file.
+type openOnly struct {
+	fsys contextual.FS
+}
+
+func (o openOnly) Open(ctx context.Context, name string) (fs.File, error) {
+	return o.fsys.Open(ctx, name)
+}
+
+func BenchmarkReadFile_Fallback(b *testing.B) {
+	ctx := b.Context()
+	base, err := osfs.New(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	backing := contextual.ToContextual(base)
+	data := bytes.Repeat([]byte("data"), 256<<10)
+	if err := contextual.WriteFile(ctx, backing, "file", data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	fsys := openOnly{fsys: backing}
+
+	b.Run("ReadAll", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			f, err := fsys.Open(ctx, "file")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.ReadAll(f); err != nil {
+				b.Fatal(err)
+			}
+			_ = f.Close()
+		}
+	})
+	b.Run("ReadFile", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := contextual.ReadFile(ctx, fsys, "file"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// sizelessFile is a file that cannot report its FileInfo, and counts its
+// reads.
+type sizelessFile struct {
+	*bytes.Reader
+	reads *int
+}
+
+func (f sizelessFile) Read(p []byte) (int, error) {
+	*f.reads++
+	return f.Reader.Read(p)
+}
+
+func (sizelessFile) Stat() (fs.FileInfo, error) { return nil, errors.ErrUnsupported }
+func (sizelessFile) Close() error               { return nil }
+
+// sizelessFS opens sizelessFiles holding data.
+type sizelessFS struct {
+	data  []byte
+	reads *int
+}
+
+func (s sizelessFS) Open(ctx context.Context, name string) (fs.File, error) {
+	return sizelessFile{Reader: bytes.NewReader(s.data), reads: s.reads}, nil
+}
+
+// sizedFS is a sizelessFS with a StatFS, but whose files cannot seek.
+type sizedFS struct {
+	sizelessFS
+	stats *int
+}
+
+func (s sizedFS) Open(ctx context.Context, name string) (fs.File, error) {
+	f, err := s.sizelessFS.Open(ctx, name)
+	return struct{ fs.File }{f}, err
+}
+
+func (s sizedFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	*s.stats++
+	return sizeInfo{size: int64(len(s.data))}, nil
+}
+
+// sizeInfo describes a regular file of the given size.
+type sizeInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i sizeInfo) Size() int64     { return i.size }
+func (sizeInfo) Mode() fs.FileMode { return 0644 }
+
+func TestReadFile_Presized(t *testing.T) {
+	ctx := t.Context()
+	data := bytes.Repeat([]byte("data"), 64<<10)
+	var reads, stats int
+
+	tests := []struct {
+		name  string
+		fsys  contextual.FS
+		reads int
+	}{
+		// A presized buffer takes one read for the contents and one for
+		// io.EOF.
+		{"stat", sizedFS{sizelessFS{data, &reads}, &stats}, 2},
+		{"seek", sizelessFS{data, &reads}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reads = 0
+			got, err := contextual.ReadFile(ctx, tt.fsys, "file")
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("ReadFile() = %d bytes, %v", len(got), err)
+			}
+			if reads != tt.reads {
+				t.Errorf("ReadFile() read %d times, want %d", reads, tt.reads)
+			}
+		})
+	}
+	if stats != 1 {
+		t.Errorf("Stat called %d times, want 1", stats)
+	}
+}
@@ -0,0 +1,71 @@
+package contextual
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"sync"
+)
+
+// readFilesConcurrency is the number of files ReadFiles reads at once when
+// fsys does not implement ReadFilesFS.
+const readFilesConcurrency = 8
+
+// ReadFilesFS is the interface implemented by a file system that can read
+// many files in one operation, such as an object store fetching a batch of
+// objects in a single request.
+type ReadFilesFS interface {
+	FS
+	// ReadFiles reads the named files and returns the contents of those it
+	// could read, keyed by name. If some could not be read, the error is a
+	// *MultiPathError of Op "readfiles" and Path "." listing an error naming
+	// each of them.
+	ReadFiles(ctx context.Context, names []string) (map[string][]byte, error)
+}
+
+// ReadFiles reads the named files and returns their contents keyed by name,
+// sparing callers loading many small files, such as configurations, from
+// reading them one after the other.
+//
+// A file that cannot be read does not fail the others: the returned map holds
+// the contents of every file that was read, and the error, if any, is a
+// *MultiPathError of Op "readfiles" and Path "." listing the *fs.PathError of
+// each of the others, in name order.
+//
+// If fsys implements ReadFilesFS, it calls fsys.ReadFiles. Otherwise it reads
+// the files with ReadFile, several at a time. Names are read once even if
+// they are repeated.
+func ReadFiles(ctx context.Context, fsys FS, names []string) (map[string][]byte, error) {
+	if fsys, ok := fsys.(ReadFilesFS); ok {
+		return fsys.ReadFiles(ctx, names)
+	}
+
+	names = slices.Compact(slices.Sorted(slices.Values(names)))
+	var (
+		mu       sync.Mutex
+		contents = make(map[string][]byte, len(names))
+		errs     = make([]error, len(names))
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, readFilesConcurrency)
+	)
+	for i, name := range names {
+		if err := ctx.Err(); err != nil {
+			errs[i] = &fs.PathError{Op: "readfile", Path: name, Err: err}
+			continue
+		}
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			data, err := ReadFile(ctx, fsys, name)
+			if err != nil {
+				errs[i] = intoPathErr("readfile", name, err)
+				return
+			}
+			mu.Lock()
+			contents[name] = data
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return contents, newMultiPathError("readfiles", ".", slices.DeleteFunc(errs, func(err error) bool { return err == nil }))
+}
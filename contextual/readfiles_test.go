@@ -0,0 +1,74 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+// batchFS reads files in batches of its own.
+type batchFS struct {
+	contextual.FS
+	batches int
+}
+
+func (f *batchFS) ReadFiles(ctx context.Context, names []string) (map[string][]byte, error) {
+	f.batches++
+	return map[string][]byte{names[0]: []byte("batched")}, nil
+}
+
+func TestReadFiles(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("fallback", func(t *testing.T) {
+		fsys := memfs.New(memfs.Config{})
+		for _, name := range []string{"a", "b", "dir/c"} {
+			if err := contextual.MkdirAll(ctx, fsys, "dir", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := contextual.WriteFile(ctx, fsys, name, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		contents, err := contextual.ReadFiles(ctx, fsys, []string{"dir/c", "a", "missing", "b", "a", "dir"})
+		if len(contents) != 3 || string(contents["a"]) != "a" || string(contents["b"]) != "b" || string(contents["dir/c"]) != "dir/c" {
+			t.Errorf("ReadFiles() contents = %q", contents)
+		}
+		var multi *contextual.MultiPathError
+		if !errors.As(err, &multi) || multi.Op != "readfiles" || len(multi.Errors) != 2 {
+			t.Fatalf("ReadFiles() error = %v; want the errors of dir and missing", err)
+		}
+		for i, want := range []struct {
+			name string
+			err  error
+		}{{"dir", fsx.ErrIsDir}, {"missing", fs.ErrNotExist}} {
+			var pathErr *fs.PathError
+			if !errors.As(multi.Errors[i], &pathErr) || pathErr.Path != want.name || !errors.Is(pathErr, want.err) {
+				t.Errorf("error %d = %v; want %v on %s", i, multi.Errors[i], want.err, want.name)
+			}
+		}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := contextual.ReadFiles(canceled, fsys, []string{"a"}); !errors.Is(err, context.Canceled) {
+			t.Errorf("ReadFiles() with a canceled context = %v; want context.Canceled", err)
+		}
+		if contents, err := contextual.ReadFiles(ctx, fsys, nil); err != nil || len(contents) != 0 {
+			t.Errorf("ReadFiles() of no file = %v, %v", contents, err)
+		}
+	})
+
+	t.Run("native", func(t *testing.T) {
+		fsys := &batchFS{}
+		contents, err := contextual.ReadFiles(ctx, fsys, []string{"x", "y"})
+		if err != nil || string(contents["x"]) != "batched" || fsys.batches != 1 {
+			t.Errorf("ReadFiles() = %q, %v after %d batches", contents, err, fsys.batches)
+		}
+	})
+}
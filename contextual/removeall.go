@@ -2,6 +2,7 @@ package contextual
 
 import (
 	"context"
+	"io/fs"
 	"os"
 	"path"
 )
@@ -16,11 +17,23 @@ type RemoveAllFS interface {
 }
 
 // RemoveAll removes path and any children it contains.
-func RemoveAll(ctx context.Context, fsys FS, name string) error {
+//
+// By default, the fallback implementation stops at the first error. With
+// ContinueOnError, it removes everything it can and returns a
+// *MultiPathError listing the paths it could not remove. A file system
+// implementing RemoveAllFS is trusted with the whole operation, and its error
+// is returned as is.
+func RemoveAll(ctx context.Context, fsys FS, name string, opts ...RecursiveOption) error {
 	if rfs, ok := fsys.(RemoveAllFS); ok {
 		return intoPathErr("remove", name, rfs.RemoveAll(ctx, name))
 	}
 
+	if applyRecursiveOptions(opts).continueOnError {
+		var errs []error
+		removeAllCollect(ctx, fsys, name, &errs)
+		return newMultiPathError("removeall", name, errs)
+	}
+
 	err := Remove(ctx, fsys, name)
 	if err == nil || os.IsNotExist(err) {
 		return nil
@@ -40,3 +53,35 @@ func RemoveAll(ctx context.Context, fsys FS, name string) error {
 
 	return Remove(ctx, fsys, name)
 }
+
+// removeAllCollect removes name and any children it contains, appending
+// failures to errs. A directory is only removed if all of its children were.
+func removeAllCollect(ctx context.Context, fsys FS, name string, errs *[]error) {
+	if err := ctx.Err(); err != nil {
+		*errs = append(*errs, &fs.PathError{Op: "remove", Path: name, Err: err})
+		return
+	}
+
+	err := Remove(ctx, fsys, name)
+	if err == nil || os.IsNotExist(err) {
+		return
+	}
+
+	entries, readErr := ReadDir(ctx, fsys, name)
+	if readErr != nil {
+		*errs = append(*errs, intoPathErr("remove", name, err))
+		return
+	}
+
+	failed := len(*errs)
+	for _, entry := range entries {
+		removeAllCollect(ctx, fsys, path.Join(name, entry.Name()), errs)
+	}
+	if len(*errs) > failed {
+		return
+	}
+
+	if err := Remove(ctx, fsys, name); err != nil && !os.IsNotExist(err) {
+		*errs = append(*errs, intoPathErr("remove", name, err))
+	}
+}
@@ -133,4 +133,60 @@ func TestRemoveAll(t *testing.T) {
 			t.Fatal("expected error, got nil")
 		}
 	})
+
+	t.Run("ContinueOnError", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mfs := cmockfs.NewMockDirFS(ctrl)
+
+		denied := errors.New("denied")
+		entries := make([]fs.DirEntry, 3)
+		for i, name := range []string{"a", "b", "c"} {
+			e := mockfs.NewMockDirEntry(ctrl)
+			e.EXPECT().Name().Return(name)
+			entries[i] = e
+		}
+		mfs.EXPECT().Remove(ctx, "dir").Return(errors.New("not empty"))
+		mfs.EXPECT().ReadDir(ctx, "dir").Return(entries, nil)
+		// Both failures are collected, and the removal of b goes on between
+		// them.
+		mfs.EXPECT().Remove(ctx, "dir/a").Return(&fs.PathError{Op: "remove", Path: "dir/a", Err: denied})
+		mfs.EXPECT().ReadDir(ctx, "dir/a").Return(nil, errors.New("not a directory"))
+		mfs.EXPECT().Remove(ctx, "dir/b").Return(nil)
+		mfs.EXPECT().Remove(ctx, "dir/c").Return(&fs.PathError{Op: "remove", Path: "dir/c", Err: denied})
+		mfs.EXPECT().ReadDir(ctx, "dir/c").Return(nil, errors.New("not a directory"))
+		// dir is not removed again, since it cannot be empty.
+
+		err := contextual.RemoveAll(ctx, mfs, "dir", contextual.ContinueOnError())
+		var mErr *contextual.MultiPathError
+		if !errors.As(err, &mErr) {
+			t.Fatalf("expected *MultiPathError, got %T: %v", err, err)
+		}
+		if mErr.Op != "removeall" || mErr.Path != "dir" || len(mErr.Errors) != 2 {
+			t.Errorf("unexpected error: %#v", mErr)
+		}
+		var pErr *fs.PathError
+		if !errors.As(err, &pErr) || pErr.Path != "dir/a" {
+			t.Errorf("expected a PathError for dir/a, got %v", pErr)
+		}
+		if !errors.Is(err, denied) {
+			t.Errorf("expected %v, got %v", denied, err)
+		}
+		want := "removeall dir: 2 errors: remove dir/a: denied; remove dir/c: denied"
+		if err.Error() != want {
+			t.Errorf("Error() = %q, want %q", err.Error(), want)
+		}
+	})
+
+	t.Run("ContinueOnErrorSuccess", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockWriterFS(ctrl)
+		m.EXPECT().Remove(ctx, "foo").Return(nil)
+
+		if err := contextual.RemoveAll(ctx, m, "foo", contextual.ContinueOnError()); err != nil {
+			t.Errorf("expected nil, got %v", err)
+		}
+	})
 }
@@ -0,0 +1,45 @@
+package contextual
+
+import (
+	"context"
+	"io/fs"
+)
+
+// RemoveManyFS is the interface implemented by a file system that can remove
+// many files in one operation, such as an object store deleting a batch of
+// objects in a single request.
+type RemoveManyFS interface {
+	WriterFS
+	// RemoveMany removes the named files or (empty) directories. If some
+	// could not be removed, the error is a *MultiPathError of Op
+	// "removemany" and Path "." listing an error naming each of them.
+	RemoveMany(ctx context.Context, names []string) error
+}
+
+// RemoveMany removes the named files or (empty) directories, sparing
+// callers removing many files, such as caches evicting them, a request per
+// file on backends that can batch them.
+//
+// A file that cannot be removed does not keep the others from being
+// removed: the error, if any, is a *MultiPathError of Op "removemany" and
+// Path "." listing the *fs.PathError of each of them, in the order of names.
+//
+// If fsys implements RemoveManyFS, it calls fsys.RemoveMany. Otherwise it
+// removes the files with Remove, one after the other.
+func RemoveMany(ctx context.Context, fsys FS, names []string) error {
+	if rfs, ok := fsys.(RemoveManyFS); ok {
+		return rfs.RemoveMany(ctx, names)
+	}
+
+	var errs []error
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, &fs.PathError{Op: "remove", Path: name, Err: err})
+			continue
+		}
+		if err := Remove(ctx, fsys, name); err != nil {
+			errs = append(errs, intoPathErr("remove", name, err))
+		}
+	}
+	return newMultiPathError("removemany", ".", errs)
+}
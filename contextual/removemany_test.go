@@ -0,0 +1,68 @@
+package contextual_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+// batchRemoveFS removes files in batches of its own.
+type batchRemoveFS struct {
+	contextual.WriterFS
+	batches [][]string
+}
+
+func (f *batchRemoveFS) RemoveMany(ctx context.Context, names []string) error {
+	f.batches = append(f.batches, names)
+	return nil
+}
+
+func TestRemoveMany(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("fallback", func(t *testing.T) {
+		fsys := memfs.New(memfs.Config{})
+		for _, name := range []string{"a", "b", "dir/c"} {
+			if err := contextual.MkdirAll(ctx, fsys, "dir", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := contextual.WriteFile(ctx, fsys, name, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := contextual.RemoveMany(ctx, fsys, []string{"a", "missing", "dir", "dir/c", "b"})
+		var multi *contextual.MultiPathError
+		if !errors.As(err, &multi) || multi.Op != "removemany" || len(multi.Errors) != 2 {
+			t.Fatalf("RemoveMany() error = %v; want the errors of missing and dir", err)
+		}
+		for i, name := range []string{"missing", "dir"} {
+			var pathErr *fs.PathError
+			if !errors.As(multi.Errors[i], &pathErr) || pathErr.Path != name {
+				t.Errorf("error %d = %v; want an error on %s", i, multi.Errors[i], name)
+			}
+		}
+		for _, name := range []string{"a", "b", "dir/c"} {
+			if _, err := contextual.Stat(ctx, fsys, name); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected %s to be removed, got %v", name, err)
+			}
+		}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := contextual.RemoveMany(canceled, fsys, []string{"dir"}); !errors.Is(err, context.Canceled) {
+			t.Errorf("RemoveMany() with a canceled context = %v; want context.Canceled", err)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		fsys := &batchRemoveFS{WriterFS: memfs.New(memfs.Config{})}
+		if err := contextual.RemoveMany(ctx, fsys, []string{"a", "b"}); err != nil || len(fsys.batches) != 1 || len(fsys.batches[0]) != 2 {
+			t.Errorf("RemoveMany() = %v with batches %q; want a single batch", err, fsys.batches)
+		}
+	})
+}
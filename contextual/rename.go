@@ -2,9 +2,15 @@ package contextual
 
 import (
 	"context"
-	"io"
+	"errors"
 	"io/fs"
+	"math/rand/v2"
 	"os"
+	"path"
+	"strconv"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/internal"
 )
 
 // RenameFS is the interface implemented by a file system that supports
@@ -44,7 +50,7 @@ func Rename(ctx context.Context, fsys FS, oldname, newname string) error {
 		return intoLinkErr("rename", oldname, newname, err)
 	}
 
-	if _, err := io.Copy(dst, src); err != nil {
+	if _, err := internal.Copy(dst, src); err != nil {
 		_ = dst.Close()
 		return intoLinkErr("rename", oldname, newname, err)
 	}
@@ -62,3 +68,73 @@ func Rename(ctx context.Context, fsys FS, oldname, newname string) error {
 
 	return nil
 }
+
+// RenameOptionsFS is the interface implemented by a file system that can
+// rename files with fsx.RenameFlags, such as renameat2(2) on Linux.
+type RenameOptionsFS interface {
+	RenameFS
+
+	// RenameWithOptions moves oldname to newname as modified by flags. It
+	// returns an error wrapping errors.ErrUnsupported, having changed
+	// nothing, if it cannot honor flags for these files.
+	RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error
+}
+
+// RenameWithOptions moves oldname to newname as modified by flags, which
+// are either zero, fsx.RenameNoReplace or fsx.RenameExchange.
+//
+// If fsys implements RenameOptionsFS and supports the request, it calls
+// fsys.RenameWithOptions. Otherwise the flags are emulated with Lstat and
+// Rename, which is NOT atomic: fsx.RenameNoReplace checks that newname does
+// not exist before renaming, so a file created in between is replaced, and
+// fsx.RenameExchange moves the files through a temporary name in the
+// directory of newname, so that another process may observe either name
+// missing. Callers that rely on the atomicity must check that fsys
+// implements RenameOptionsFS.
+func RenameWithOptions(ctx context.Context, fsys FS, oldname, newname string, flags fsx.RenameFlags) error {
+	if flags&^(fsx.RenameNoReplace|fsx.RenameExchange) != 0 || flags == fsx.RenameNoReplace|fsx.RenameExchange {
+		return intoLinkErr("rename", oldname, newname, fs.ErrInvalid)
+	}
+	if rfs, ok := fsys.(RenameOptionsFS); ok {
+		if err := rfs.RenameWithOptions(ctx, oldname, newname, flags); !errors.Is(err, errors.ErrUnsupported) {
+			return intoLinkErr("rename", oldname, newname, err)
+		}
+	}
+
+	switch flags {
+	case fsx.RenameNoReplace:
+		if _, err := Lstat(ctx, fsys, newname); err == nil {
+			return intoLinkErr("rename", oldname, newname, fs.ErrExist)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return intoLinkErr("rename", oldname, newname, err)
+		}
+	case fsx.RenameExchange:
+		return exchange(ctx, fsys, oldname, newname)
+	}
+	return Rename(ctx, fsys, oldname, newname)
+}
+
+// exchange emulates fsx.RenameExchange with three renames, undoing the first
+// ones if a later one fails.
+func exchange(ctx context.Context, fsys FS, oldname, newname string) error {
+	for _, name := range []string{oldname, newname} {
+		if _, err := Lstat(ctx, fsys, name); err != nil {
+			return intoLinkErr("rename", oldname, newname, err)
+		}
+	}
+	dir, base := SplitDirFile(newname)
+	tmp := path.Join(dir, "."+base+".xchg"+strconv.FormatUint(rand.Uint64(), 36))
+	if err := Rename(ctx, fsys, newname, tmp); err != nil {
+		return intoLinkErr("rename", oldname, newname, err)
+	}
+	if err := Rename(ctx, fsys, oldname, newname); err != nil {
+		_ = Rename(ctx, fsys, tmp, newname)
+		return intoLinkErr("rename", oldname, newname, err)
+	}
+	if err := Rename(ctx, fsys, tmp, oldname); err != nil {
+		_ = Rename(ctx, fsys, newname, oldname)
+		_ = Rename(ctx, fsys, tmp, newname)
+		return intoLinkErr("rename", oldname, newname, err)
+	}
+	return nil
+}
@@ -7,9 +7,11 @@ import (
 	"os"
 	"testing"
 
+	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/contextual"
 	"github.com/gwangyi/fsx/mockfs"
 	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"github.com/gwangyi/fsx/osfs"
 	"go.uber.org/mock/gomock"
 )
 
@@ -222,3 +224,40 @@ func TestRename(t *testing.T) {
 		}
 	})
 }
+
+func TestRenameWithOptions(t *testing.T) {
+	ctx := t.Context()
+	base, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	native := contextual.ToContextual(base)
+	for name, data := range map[string]string{"a": "a", "b": "b"} {
+		if err := contextual.WriteFile(ctx, native, name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Hides RenameOptionsFS so that the flags are emulated.
+	emulated := struct{ contextual.RenameFS }{native.(contextual.RenameFS)}
+
+	for _, fsys := range []contextual.FS{native, emulated} {
+		if err := contextual.RenameWithOptions(ctx, fsys, "a", "b", fsx.RenameNoReplace); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("RenameWithOptions(RenameNoReplace) = %v, want ErrExist", err)
+		}
+		if err := contextual.RenameWithOptions(ctx, fsys, "a", "b", fsx.RenameExchange); err != nil {
+			t.Errorf("RenameWithOptions(RenameExchange) = %v", err)
+		}
+	}
+	// Exchanged twice.
+	for name, want := range map[string]string{"a": "a", "b": "b"} {
+		if got, err := contextual.ReadFile(ctx, native, name); err != nil || string(got) != want {
+			t.Errorf("ReadFile(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if entries, err := contextual.ReadDir(ctx, native, "."); err != nil || len(entries) != 2 {
+		t.Errorf("ReadDir() = %v, %v, want a and b only", entries, err)
+	}
+	if err := contextual.RenameWithOptions(ctx, native, "a", "b", 4); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("RenameWithOptions(4) = %v, want ErrInvalid", err)
+	}
+}
@@ -0,0 +1,190 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"iter"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// DefaultChunkSize is the size of the chunks yielded by OpenSeq unless
+// ChunkSize is given.
+const DefaultChunkSize = internal.DefaultBufferSize
+
+// DefaultPageSize is the number of entries ReadDirSeq reads at a time from a
+// ReadDirPageFS unless PageSize is given.
+const DefaultPageSize = 1024
+
+// Result is the sequence of values produced by a streaming operation, along
+// with the error that ended it. An iter.Seq cannot return an error, so it is
+// captured and reported by Err once the iteration is over.
+type Result[T any] struct {
+	run func(yield func(T) bool) error
+	err error
+}
+
+// NewResult returns the Result of the operation run, which yields the values
+// and returns the error ending the sequence, or nil. It is run for every
+// iteration of All. Filesystems implementing streaming interfaces, such as
+// ReadDirSeqFS, use it to build their results.
+func NewResult[T any](run func(yield func(T) bool) error) *Result[T] {
+	return &Result[T]{run: run}
+}
+
+// All returns an iterator over the values. Every iteration runs the
+// operation again and replaces the error reported by Err.
+func (r *Result[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		r.err = r.run(yield)
+	}
+}
+
+// Err returns the error that ended the last iteration of All, or nil if it
+// reached the end of the sequence or was stopped by the caller.
+func (r *Result[T]) Err() error {
+	return r.err
+}
+
+// SeqOption configures streaming helpers such as OpenSeq.
+type SeqOption func(*seqOptions)
+
+// seqOptions holds the settings applied by SeqOption.
+type seqOptions struct {
+	chunkSize int
+	pageSize  int
+}
+
+// applySeqOptions returns the settings selected by opts, with the defaults
+// for those left unset.
+func applySeqOptions(opts []SeqOption) seqOptions {
+	var o seqOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.chunkSize < 1 {
+		o.chunkSize = DefaultChunkSize
+	}
+	if o.pageSize < 1 {
+		o.pageSize = DefaultPageSize
+	}
+	return o
+}
+
+// ChunkSize sets the size of the chunks yielded by OpenSeq. Values less than
+// 1 select DefaultChunkSize.
+func ChunkSize(n int) SeqOption {
+	return func(o *seqOptions) { o.chunkSize = n }
+}
+
+// PageSize sets the number of entries ReadDirSeq reads at a time from a
+// ReadDirPageFS. Values less than 1 select DefaultPageSize.
+func PageSize(n int) SeqOption {
+	return func(o *seqOptions) { o.pageSize = n }
+}
+
+// OpenSeq returns the contents of the named file as a sequence of chunks, so
+// that large files can be processed without reading them into memory like
+// ReadFile does. Every chunk but the last holds exactly the chunk size.
+//
+// The file is opened when the sequence is iterated, and closed when the
+// iteration ends. A chunk is only valid until the next one is yielded, since
+// its buffer is reused; callers keeping it must copy it. The iteration stops
+// with ctx's error, wrapped in an *fs.PathError, once ctx is done.
+func OpenSeq(ctx context.Context, fsys FS, name string, opts ...SeqOption) *Result[[]byte] {
+	o := applySeqOptions(opts)
+
+	return &Result[[]byte]{run: func(yield func([]byte) bool) error {
+		if err := ctx.Err(); err != nil {
+			return &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		f, err := fsys.Open(ctx, name)
+		if err != nil {
+			return intoPathErr("open", name, err)
+		}
+		defer func() { _ = f.Close() }()
+
+		var buf []byte
+		if o.chunkSize == internal.BufferSize() {
+			b := internal.GetBuffer()
+			defer internal.PutBuffer(b)
+			buf = *b
+		} else {
+			buf = make([]byte, o.chunkSize)
+		}
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return &fs.PathError{Op: "read", Path: name, Err: err}
+			}
+			n, err := io.ReadFull(f, buf)
+			if n > 0 && !yield(buf[:n]) {
+				return nil
+			}
+			switch {
+			case err == nil:
+			case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+				return nil
+			default:
+				return intoPathErr("read", name, err)
+			}
+		}
+	}}
+}
+
+// ReadDirSeqFS is the interface implemented by a file system that can
+// stream the entries of a directory itself.
+type ReadDirSeqFS interface {
+	FS
+	// ReadDirSeq returns the entries of the named directory, sorted by
+	// filename, as a sequence.
+	ReadDirSeq(ctx context.Context, name string, opts ...SeqOption) *Result[fs.DirEntry]
+}
+
+// ReadDirSeq returns the entries of the named directory, sorted by filename,
+// as a sequence, so that enormous directories can be walked without holding
+// their listing in memory like ReadDir does. The directory is read when the
+// sequence is iterated, and the iteration stops with ctx's error, wrapped in
+// an *fs.PathError, once ctx is done.
+//
+// If fsys implements ReadDirSeqFS, it calls fsys.ReadDirSeq. Otherwise, if
+// fsys implements ReadDirPageFS, the entries are read a page at a time, of
+// the size given by PageSize, so that only a page is held in memory. Other
+// filesystems are listed whole with ReadDir.
+func ReadDirSeq(ctx context.Context, fsys FS, name string, opts ...SeqOption) *Result[fs.DirEntry] {
+	if fsys, ok := fsys.(ReadDirSeqFS); ok {
+		return fsys.ReadDirSeq(ctx, name, opts...)
+	}
+	o := applySeqOptions(opts)
+	pfs, paged := fsys.(ReadDirPageFS)
+
+	return &Result[fs.DirEntry]{run: func(yield func(fs.DirEntry) bool) error {
+		cursor := ""
+		for {
+			if err := ctx.Err(); err != nil {
+				return &fs.PathError{Op: "readdir", Path: name, Err: err}
+			}
+			var entries []fs.DirEntry
+			var err error
+			if paged {
+				entries, cursor, err = pfs.ReadDirPage(ctx, name, cursor, o.pageSize)
+				err = intoPathErr("readdir", name, err)
+			} else {
+				entries, err = ReadDir(ctx, fsys, name)
+			}
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if !yield(e) {
+					return nil
+				}
+			}
+			if !paged || cursor == "" {
+				return nil
+			}
+		}
+	}}
+}
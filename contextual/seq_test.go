@@ -0,0 +1,176 @@
+package contextual_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestOpenSeq(t *testing.T) {
+	ctx := t.Context()
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfs := contextual.ToContextual(fsys)
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	if err := contextual.WriteFile(ctx, cfs, "file", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("default chunk size", func(t *testing.T) {
+		r := contextual.OpenSeq(ctx, cfs, "file")
+		var got []byte
+		chunks := 0
+		for chunk := range r.All() {
+			got = append(got, chunk...)
+			chunks++
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("got %d bytes, want %d", len(got), len(data))
+		}
+		if want := (len(data) + contextual.DefaultChunkSize - 1) / contextual.DefaultChunkSize; chunks != want {
+			t.Errorf("got %d chunks, want %d", chunks, want)
+		}
+	})
+
+	t.Run("chunk size", func(t *testing.T) {
+		r := contextual.OpenSeq(ctx, cfs, "file", contextual.ChunkSize(300))
+		var sizes []int
+		for chunk := range r.All() {
+			sizes = append(sizes, len(chunk))
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(sizes) != 334 || sizes[0] != 300 || sizes[len(sizes)-1] != 100 {
+			t.Errorf("unexpected chunk sizes: %d chunks, first %d, last %d", len(sizes), sizes[0], sizes[len(sizes)-1])
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		r := contextual.OpenSeq(ctx, cfs, "file", contextual.ChunkSize(10))
+		for chunk := range r.All() {
+			if string(chunk) != "0123456789" {
+				t.Errorf("unexpected chunk %q", chunk)
+			}
+			break
+		}
+		if err := r.Err(); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("not exist", func(t *testing.T) {
+		r := contextual.OpenSeq(ctx, cfs, "missing")
+		for range r.All() {
+			t.Error("unexpected chunk")
+		}
+		var pathErr *fs.PathError
+		if err := r.Err(); !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &pathErr) || pathErr.Op != "open" {
+			t.Errorf("expected open ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		r := contextual.OpenSeq(ctx, cfs, "file", contextual.ChunkSize(10))
+		chunks := 0
+		for range r.All() {
+			chunks++
+			cancel()
+		}
+		if chunks != 1 {
+			t.Errorf("got %d chunks after cancel, want 1", chunks)
+		}
+		if err := r.Err(); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+// countingPageFS counts the pages read from the embedded ReadDirPageFS.
+type countingPageFS struct {
+	contextual.ReadDirPageFS
+	pages int
+}
+
+func (f *countingPageFS) ReadDirPage(ctx context.Context, name, cursor string, n int) ([]fs.DirEntry, string, error) {
+	f.pages++
+	return f.ReadDirPageFS.ReadDirPage(ctx, name, cursor, n)
+}
+
+func TestReadDirSeq(t *testing.T) {
+	ctx := t.Context()
+	mfs := memfs.New(memfs.Config{})
+	want := []string{"a", "b", "c", "d", "e"}
+	for _, name := range want {
+		if err := contextual.WriteFile(ctx, mfs, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	collect := func(r *contextual.Result[fs.DirEntry]) []string {
+		var got []string
+		for e := range r.All() {
+			got = append(got, e.Name())
+		}
+		return got
+	}
+
+	t.Run("paged", func(t *testing.T) {
+		fsys := &countingPageFS{ReadDirPageFS: mfs.(contextual.ReadDirPageFS)}
+		r := contextual.ReadDirSeq(ctx, fsys, ".", contextual.PageSize(2))
+		if got := collect(r); !slices.Equal(got, want) {
+			t.Errorf("ReadDirSeq() = %v; want %v", got, want)
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if fsys.pages != 3 {
+			t.Errorf("read %d pages, want 3", fsys.pages)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		fsys := struct{ contextual.FS }{mfs}
+		r := contextual.ReadDirSeq(ctx, fsys, ".")
+		if got := collect(r); !slices.Equal(got, want) {
+			t.Errorf("ReadDirSeq() = %v; want %v", got, want)
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		fsys := &countingPageFS{ReadDirPageFS: mfs.(contextual.ReadDirPageFS)}
+		for range contextual.ReadDirSeq(ctx, fsys, ".", contextual.PageSize(2)).All() {
+			break
+		}
+		if fsys.pages != 1 {
+			t.Errorf("read %d pages, want 1", fsys.pages)
+		}
+	})
+
+	t.Run("not exist", func(t *testing.T) {
+		r := contextual.ReadDirSeq(ctx, mfs, "missing")
+		if got := collect(r); len(got) != 0 {
+			t.Errorf("unexpected entries %v", got)
+		}
+		var pathErr *fs.PathError
+		if err := r.Err(); !errors.Is(err, fs.ErrNotExist) || !errors.As(err, &pathErr) || pathErr.Op != "readdir" {
+			t.Errorf("expected readdir ErrNotExist, got %v", err)
+		}
+	})
+}
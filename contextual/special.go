@@ -0,0 +1,37 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// SpecialFS is the interface implemented by a file system that supports
+// context-aware creation of special files: named pipes, sockets, and block or
+// character devices.
+type SpecialFS interface {
+	WriterFS
+
+	// CreateSpecial creates a special file at name. The type of the file is
+	// given by the special bits of mode, and its permissions by mode.Perm().
+	// For devices, dev is the device number as built by fsx.Mkdev.
+	CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error
+}
+
+// CreateSpecial creates a special file at name in the provided filesystem.
+// The permission bits of mode are subject to the umask of ctx.
+// If mode does not describe a special file, it returns an error wrapping
+// fs.ErrInvalid.
+// If fsys implements SpecialFS, it calls fsys.CreateSpecial.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func CreateSpecial(ctx context.Context, fsys FS, name string, mode fs.FileMode, dev uint64) error {
+	if !internal.IsSpecial(mode) {
+		return intoPathErr("mknod", name, fs.ErrInvalid)
+	}
+	if sfs, ok := fsys.(SpecialFS); ok {
+		return intoPathErr("mknod", name, sfs.CreateSpecial(ctx, name, applyUmask(ctx, mode), dev))
+	}
+	return intoPathErr("mknod", name, errors.ErrUnsupported)
+}
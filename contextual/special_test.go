@@ -0,0 +1,37 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"go.uber.org/mock/gomock"
+)
+
+func TestCreateSpecial(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockFS(ctrl)
+		if err := contextual.CreateSpecial(ctx, m, "file", 0644, 0); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockFS(ctrl)
+		err := contextual.CreateSpecial(ctx, m, "fifo", fs.ModeNamedPipe|0644, 0)
+		var pErr *fs.PathError
+		if !errors.As(err, &pErr) || pErr.Op != "mknod" || !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("expected mknod ErrUnsupported, got %v", err)
+		}
+	})
+}
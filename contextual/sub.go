@@ -0,0 +1,298 @@
+package contextual
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// SubFS is the interface implemented by a file system that can return the
+// subtree rooted at one of its directories itself.
+type SubFS interface {
+	FS
+	// Sub returns an FS corresponding to the subtree rooted at dir.
+	Sub(dir string) (FS, error)
+}
+
+// Sub returns an FS corresponding to the subtree rooted at fsys's dir, like
+// fs.Sub. If dir is ".", it returns fsys itself.
+//
+// If fsys implements SubFS, it calls fsys.Sub. Otherwise the returned
+// FileSystem prefixes the names passed to each operation with dir and
+// forwards them to fsys through the helpers of this package, reporting the
+// names it was given in its errors. The targets of symbolic links are passed
+// as is, so that a link of the subtree may point outside of it.
+func Sub(fsys FS, dir string) (FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	if dir == "." {
+		return fsys, nil
+	}
+	if fsys, ok := fsys.(SubFS); ok {
+		return fsys.Sub(dir)
+	}
+	return &subFS{fsys: fsys, dir: dir}, nil
+}
+
+// subFS is the subtree rooted at dir of fsys.
+type subFS struct {
+	fsys FS
+	dir  string
+}
+
+// full returns the name of name in fsys.
+func (s *subFS) full(op, name string) (string, error) {
+	if err := internal.CheckPath(op, name); err != nil {
+		return "", err
+	}
+	return path.Join(s.dir, name), nil
+}
+
+// fullLink returns the names of oldname and newname in fsys.
+func (s *subFS) fullLink(op, oldname, newname string) (string, string, error) {
+	if err := internal.CheckLink(op, oldname, newname); err != nil {
+		return "", "", err
+	}
+	return path.Join(s.dir, oldname), path.Join(s.dir, newname), nil
+}
+
+func (s *subFS) Open(ctx context.Context, name string) (fs.File, error) {
+	full, err := s.full("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Open(ctx, s.fsys, full)
+	return f, internal.Decorate("open", name, err)
+}
+
+func (s *subFS) Create(ctx context.Context, name string) (File, error) {
+	full, err := s.full("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Create(ctx, s.fsys, full)
+	return f, internal.Decorate("open", name, err)
+}
+
+func (s *subFS) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (File, error) {
+	if err := internal.CheckOpen(name, flag); err != nil {
+		return nil, err
+	}
+	f, err := OpenFile(ctx, s.fsys, path.Join(s.dir, name), flag, mode)
+	return f, internal.Decorate("open", name, err)
+}
+
+func (s *subFS) Remove(ctx context.Context, name string) error {
+	full, err := s.full("remove", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("remove", name, Remove(ctx, s.fsys, full))
+}
+
+func (s *subFS) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	full, err := s.full("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ReadFile(ctx, s.fsys, full)
+	return data, internal.Decorate("readfile", name, err)
+}
+
+func (s *subFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	full, err := s.full("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := Stat(ctx, s.fsys, full)
+	if err != nil {
+		return nil, internal.Decorate("stat", name, err)
+	}
+	return fi, nil
+}
+
+func (s *subFS) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	full, err := s.full("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := Lstat(ctx, s.fsys, full)
+	if err != nil {
+		return nil, internal.Decorate("lstat", name, err)
+	}
+	return fi, nil
+}
+
+func (s *subFS) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	full, err := s.full("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ReadDir(ctx, s.fsys, full)
+	return entries, internal.Decorate("readdir", name, err)
+}
+
+func (s *subFS) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	full, err := s.full("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("mkdir", name, Mkdir(ctx, s.fsys, full, perm))
+}
+
+func (s *subFS) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	full, err := s.full("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("mkdir", name, MkdirAll(ctx, s.fsys, full, perm))
+}
+
+func (s *subFS) RemoveAll(ctx context.Context, name string) error {
+	full, err := s.full("removeall", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("removeall", name, RemoveAll(ctx, s.fsys, full))
+}
+
+func (s *subFS) Rename(ctx context.Context, oldname, newname string) error {
+	oldfull, newfull, err := s.fullLink("rename", oldname, newname)
+	if err != nil {
+		return err
+	}
+	return internal.DecorateLink("rename", oldname, newname, Rename(ctx, s.fsys, oldfull, newfull))
+}
+
+func (s *subFS) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	oldfull, newfull, err := s.fullLink("rename", oldname, newname)
+	if err != nil {
+		return err
+	}
+	return internal.DecorateLink("rename", oldname, newname, RenameWithOptions(ctx, s.fsys, oldfull, newfull, flags))
+}
+
+func (s *subFS) Symlink(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("symlink", oldname, newname); err != nil {
+		return err
+	}
+	return internal.DecorateLink("symlink", oldname, newname, Symlink(ctx, s.fsys, oldname, path.Join(s.dir, newname)))
+}
+
+func (s *subFS) ReadLink(ctx context.Context, name string) (string, error) {
+	full, err := s.full("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	l, err := ReadLink(ctx, s.fsys, full)
+	return l, internal.Decorate("readlink", name, err)
+}
+
+func (s *subFS) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	full, err := s.full("mknod", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("mknod", name, CreateSpecial(ctx, s.fsys, full, mode, dev))
+}
+
+func (s *subFS) Lchown(ctx context.Context, name, owner, group string) error {
+	full, err := s.full("lchown", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("lchown", name, Lchown(ctx, s.fsys, full, owner, group))
+}
+
+func (s *subFS) Chown(ctx context.Context, name, owner, group string) error {
+	full, err := s.full("chown", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("chown", name, Chown(ctx, s.fsys, full, owner, group))
+}
+
+func (s *subFS) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	full, err := s.full("chmod", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("chmod", name, Chmod(ctx, s.fsys, full, mode))
+}
+
+func (s *subFS) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	full, err := s.full("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("chtimes", name, Chtimes(ctx, s.fsys, full, atime, mtime))
+}
+
+func (s *subFS) Truncate(ctx context.Context, name string, size int64) error {
+	full, err := s.full("truncate", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("truncate", name, Truncate(ctx, s.fsys, full, size))
+}
+
+func (s *subFS) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	full, err := s.full("writefile", name)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("writefile", name, WriteFile(ctx, s.fsys, full, data, perm))
+}
+
+// Glob matches pattern in the subtree, and returns the names relative to
+// it.
+func (s *subFS) Glob(ctx context.Context, pattern string) ([]string, error) {
+	// Checks the pattern first, since it is joined with dir below.
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if pattern == "." {
+		return []string{"."}, nil
+	}
+	names, err := Glob(ctx, s.fsys, path.Join(escapeGlob(s.dir), pattern))
+	for i, name := range names {
+		names[i] = name[len(s.dir)+1:]
+	}
+	return names, err
+}
+
+// Sub returns the subtree rooted at dir of the subtree.
+func (s *subFS) Sub(dir string) (FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	if dir == "." {
+		return s, nil
+	}
+	return &subFS{fsys: s.fsys, dir: path.Join(s.dir, dir)}, nil
+}
+
+// escapeGlob escapes the special characters of path.Match in name.
+func escapeGlob(name string) string {
+	var b []byte
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '*', '?', '[', '\\':
+			b = append(b, '\\', c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+var _ FileSystem = &subFS{}
+var _ SpecialFS = &subFS{}
+var _ RenameOptionsFS = &subFS{}
+var _ GlobFS = &subFS{}
+var _ SubFS = &subFS{}
@@ -0,0 +1,105 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestSub(t *testing.T) {
+	ctx := t.Context()
+	base, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hides the SubFS implementation of osfs.
+	fsys := struct{ contextual.FileSystem }{contextual.ToContextual(base).(contextual.FileSystem)}
+	if err := contextual.MkdirAll(ctx, fsys, "dir[1]/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir[1]/a.txt", "dir[1]/sub/b.txt", "other.txt"} {
+		if err := contextual.WriteFile(ctx, fsys, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sub, err := contextual.Sub(fsys, "dir[1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := contextual.ReadFile(ctx, sub, "sub/b.txt"); err != nil || string(data) != "dir[1]/sub/b.txt" {
+		t.Errorf("ReadFile(sub/b.txt) = %q, %v", data, err)
+	}
+	if err := contextual.WriteFile(ctx, sub, "c.txt", []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.Rename(ctx, sub, "c.txt", "sub/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := contextual.Stat(ctx, fsys, "dir[1]/sub/c.txt"); err != nil {
+		t.Errorf("expected the rename to happen in the subtree: %v", err)
+	}
+	if _, err := contextual.Stat(ctx, sub, "../other.txt"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Stat(../other.txt) error = %v; want ErrInvalid", err)
+	}
+	if layers := contextual.Unwrap(sub); layers != nil {
+		t.Errorf("Unwrap() = %v; want the filesystem holding the subtree hidden", layers)
+	}
+	var pathErr *fs.PathError
+	if _, err := contextual.Stat(ctx, sub, "missing"); !errors.As(err, &pathErr) || pathErr.Path != "missing" {
+		t.Errorf("Stat(missing) error = %v; want a PathError on missing", err)
+	}
+
+	got, err := contextual.Glob(ctx, sub, "*/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sub/b.txt", "sub/c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("Glob(*/*.txt) = %v; want %v", got, want)
+	}
+
+	subsub, err := contextual.Sub(sub, "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(contextual.FromContextual(subsub, ctx), "b.txt", "c.txt"); err != nil {
+		t.Error(err)
+	}
+	if same, err := contextual.Sub(fsys, "."); err != nil || same != contextual.FS(fsys) {
+		t.Error("expected Sub(.) to return the filesystem itself")
+	}
+	if _, err := contextual.Sub(fsys, "/abs"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Sub(/abs) error = %v; want ErrInvalid", err)
+	}
+}
+
+func TestFromContextual_Interfaces(t *testing.T) {
+	fsys := contextual.FromContextual(contextual.ToContextual(fstest.MapFS{
+		"dir/a.txt": {Data: []byte("a")},
+	}), t.Context())
+	for name, ok := range map[string]bool{
+		"fs.ReadFileFS": is[fs.ReadFileFS](fsys),
+		"fs.ReadDirFS":  is[fs.ReadDirFS](fsys),
+		"fs.StatFS":     is[fs.StatFS](fsys),
+		"fs.ReadLinkFS": is[fs.ReadLinkFS](fsys),
+		"fs.GlobFS":     is[fs.GlobFS](fsys),
+		"fs.SubFS":      is[fs.SubFS](fsys),
+	} {
+		if !ok {
+			t.Errorf("expected FromContextual to implement %s", name)
+		}
+	}
+	if err := fstest.TestFS(fsys, "dir/a.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func is[T any](v any) bool {
+	_, ok := v.(T)
+	return ok
+}
@@ -0,0 +1,142 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// TransferStrategy tells how Transfer or SendFile moved the contents of a
+// file.
+type TransferStrategy int
+
+const (
+	// TransferBuffer copied through a pooled buffer.
+	TransferBuffer TransferStrategy = iota
+	// TransferWriterTo let the source file write itself with io.WriterTo,
+	// which *os.File implements with sendfile or splice where available.
+	TransferWriterTo
+	// TransferReaderFrom let the destination read the source with
+	// io.ReaderFrom, which *os.File implements with copy_file_range where
+	// available.
+	TransferReaderFrom
+	// TransferNative delegated the whole copy to the TransferFS of the source
+	// filesystem, for instance as a server-side copy.
+	TransferNative
+)
+
+// String returns the name of the strategy.
+func (s TransferStrategy) String() string {
+	switch s {
+	case TransferBuffer:
+		return "buffer"
+	case TransferWriterTo:
+		return "writerto"
+	case TransferReaderFrom:
+		return "readerfrom"
+	case TransferNative:
+		return "native"
+	}
+	return "unknown"
+}
+
+// TransferResult describes a completed transfer.
+type TransferResult struct {
+	// Strategy is the way the contents were moved.
+	Strategy TransferStrategy
+	// Bytes is the number of bytes transferred.
+	Bytes int64
+}
+
+// TransferFS is the interface implemented by a file system that can copy its
+// files to another file system more efficiently than by reading and writing
+// them, such as two buckets of an object store copying server-side.
+type TransferFS interface {
+	FS
+
+	// TransferTo copies the named file to dstName in dst, creating or
+	// truncating it, and returns the number of bytes copied. It returns an
+	// error wrapping errors.ErrUnsupported if it cannot handle dst, in which
+	// case nothing was written.
+	TransferTo(ctx context.Context, name string, dst FS, dstName string) (int64, error)
+}
+
+// Transfer copies the named file of src to dstName in dst, creating or
+// truncating it with the permissions of the source, whatever umask ctx
+// carries. The parent directory of dstName must exist.
+//
+// The copy is delegated to src if it implements TransferFS and supports dst.
+// Otherwise the contents are copied with io.WriterTo or io.ReaderFrom if the
+// opened files implement them, or through a pooled buffer. The result tells
+// which strategy was used. A partial copy is removed if the transfer fails.
+func Transfer(ctx context.Context, src FS, name string, dst FS, dstName string) (TransferResult, error) {
+	if _, ok := Umask(ctx); ok {
+		ctx = WithUmask(ctx, 0)
+	}
+	if tfs, ok := src.(TransferFS); ok {
+		n, err := tfs.TransferTo(ctx, name, dst, dstName)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return TransferResult{Strategy: TransferNative, Bytes: n}, intoPathErr("transfer", name, err)
+		}
+	}
+
+	in, err := src.Open(ctx, name)
+	if err != nil {
+		return TransferResult{}, intoPathErr("transfer", name, err)
+	}
+	defer func() { _ = in.Close() }()
+
+	mode := fs.FileMode(0666)
+	if info, err := in.Stat(); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	out, err := OpenFile(ctx, dst, dstName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return TransferResult{}, intoPathErr("transfer", name, err)
+	}
+	result, err := copyContents(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = Remove(ctx, dst, dstName)
+		return result, intoPathErr("transfer", name, err)
+	}
+	return result, nil
+}
+
+// SendFile writes the contents of the named file to w, such as a network
+// connection. Like io.Copy, it lets the file write itself with io.WriterTo,
+// which *os.File implements with sendfile for sockets, or w read the file
+// with io.ReaderFrom, and otherwise copies through a pooled buffer. The
+// result tells which strategy was used.
+func SendFile(ctx context.Context, fsys FS, name string, w io.Writer) (TransferResult, error) {
+	in, err := fsys.Open(ctx, name)
+	if err != nil {
+		return TransferResult{}, intoPathErr("sendfile", name, err)
+	}
+	defer func() { _ = in.Close() }()
+
+	result, err := copyContents(w, in)
+	return result, intoPathErr("sendfile", name, err)
+}
+
+// copyContents copies src to dst, preferring the same interfaces as io.Copy
+// in the same order, and reports the strategy used.
+func copyContents(dst io.Writer, src io.Reader) (TransferResult, error) {
+	if wt, ok := src.(io.WriterTo); ok {
+		n, err := wt.WriteTo(dst)
+		return TransferResult{Strategy: TransferWriterTo, Bytes: n}, err
+	}
+	if rf, ok := dst.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(src)
+		return TransferResult{Strategy: TransferReaderFrom, Bytes: n}, err
+	}
+	n, err := internal.Copy(dst, src)
+	return TransferResult{Strategy: TransferBuffer, Bytes: n}, err
+}
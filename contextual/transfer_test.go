@@ -0,0 +1,117 @@
+package contextual_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// transferFS copies natively to destinations named "native", and leaves
+// other transfers to the fallback.
+type transferFS struct {
+	contextual.FS
+}
+
+func (transferFS) TransferTo(ctx context.Context, name string, dst contextual.FS, dstName string) (int64, error) {
+	if dstName != "native" {
+		return 0, fmt.Errorf("transfer to %s: %w", dstName, errors.ErrUnsupported)
+	}
+	return 42, contextual.WriteFile(ctx, dst, dstName, []byte("native"), 0644)
+}
+
+func TestTransfer(t *testing.T) {
+	ctx := t.Context()
+	newFS := func(t *testing.T) contextual.FS {
+		fsys, err := osfs.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return contextual.ToContextual(fsys)
+	}
+	src, dst := newFS(t), newFS(t)
+	data := bytes.Repeat([]byte("data"), 10000)
+	if err := contextual.WriteFile(ctx, src, "file", data, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("files", func(t *testing.T) {
+		result, err := contextual.Transfer(ctx, src, "file", dst, "copy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Strategy != contextual.TransferWriterTo || result.Bytes != int64(len(data)) {
+			t.Errorf("unexpected result: %v %d", result.Strategy, result.Bytes)
+		}
+		got, err := contextual.ReadFile(ctx, dst, "copy")
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("ReadFile() = %d bytes, %v", len(got), err)
+		}
+		if info, err := contextual.Stat(ctx, dst, "copy"); err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("Stat() = %v, %v", info, err)
+		}
+	})
+
+	t.Run("umask", func(t *testing.T) {
+		if _, err := contextual.Transfer(contextual.WithUmask(ctx, 077), src, "file", dst, "masked"); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := contextual.Stat(ctx, dst, "masked"); err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("Stat() = %v, %v", info, err)
+		}
+	})
+
+	t.Run("native", func(t *testing.T) {
+		result, err := contextual.Transfer(ctx, transferFS{src}, "file", dst, "native")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Strategy != contextual.TransferNative || result.Bytes != 42 {
+			t.Errorf("unexpected result: %v %d", result.Strategy, result.Bytes)
+		}
+
+		result, err = contextual.Transfer(ctx, transferFS{src}, "file", dst, "fallback")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Strategy == contextual.TransferNative {
+			t.Error("expected a fallback strategy")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := contextual.Transfer(ctx, src, "missing", dst, "missing")
+		var pErr *fs.PathError
+		if !errors.As(err, &pErr) || pErr.Op != "transfer" || !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected transfer ErrNotExist, got %v", err)
+		}
+	})
+}
+
+func TestSendFile(t *testing.T) {
+	ctx := t.Context()
+	fsys := contextual.ToContextual(fstest.MapFS{"file": {Data: []byte("hello")}})
+
+	var buf bytes.Buffer
+	result, err := contextual.SendFile(ctx, fsys, "file", &buf)
+	if err != nil || result.Strategy != contextual.TransferReaderFrom || buf.String() != "hello" {
+		t.Errorf("SendFile() = %v, %v, wrote %q", result.Strategy, err, buf.String())
+	}
+
+	buf.Reset()
+	result, err = contextual.SendFile(ctx, fsys, "file", struct{ io.Writer }{&buf})
+	if err != nil || result.Strategy != contextual.TransferBuffer || result.Bytes != 5 || buf.String() != "hello" {
+		t.Errorf("SendFile() = %v, %v, wrote %q", result.Strategy, err, buf.String())
+	}
+
+	if s := contextual.TransferNative.String(); s != "native" {
+		t.Errorf("String() = %q", s)
+	}
+}
@@ -16,6 +16,8 @@ type TruncateFS interface {
 }
 
 // Truncate changes the size of the named file.
+// If fsys does not implement TruncateFS, the file is opened for writing and
+// truncated through the File handle; an error from closing it is reported.
 func Truncate(ctx context.Context, fsys FS, name string, size int64) error {
 	if tfs, ok := fsys.(TruncateFS); ok {
 		if err := tfs.Truncate(ctx, name, size); !errors.Is(err, errors.ErrUnsupported) {
@@ -27,7 +29,9 @@ func Truncate(ctx context.Context, fsys FS, name string, size int64) error {
 	if err != nil {
 		return intoPathErr("truncate", name, err)
 	}
-	defer func() { _ = f.Close() }()
-
-	return intoPathErr("truncate", name, f.Truncate(size))
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return intoPathErr("truncate", name, err)
+	}
+	return intoPathErr("truncate", name, f.Close())
 }
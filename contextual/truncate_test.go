@@ -43,6 +43,23 @@ func TestTruncate(t *testing.T) {
 		}
 	})
 
+	t.Run("Fallback Close error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockWriterFS(ctrl)
+		f := mockfs.NewMockFile(ctrl)
+		closeErr := errors.New("close failed")
+
+		m.EXPECT().OpenFile(ctx, "foo", os.O_WRONLY, fs.FileMode(0)).Return(f, nil)
+		f.EXPECT().Truncate(int64(100)).Return(nil)
+		f.EXPECT().Close().Return(closeErr)
+
+		if err := contextual.Truncate(ctx, m, "foo", 100); !errors.Is(err, closeErr) {
+			t.Errorf("expected close error, got %v", err)
+		}
+	})
+
 	t.Run("Unsupported", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
@@ -0,0 +1,398 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// ErrTxDone is returned by the methods of a Tx that was already committed or
+// rolled back.
+var ErrTxDone = errors.New("transaction already committed or rolled back")
+
+// ErrTxConflict is returned by Commit when the filesystem was changed by
+// others since the transaction began, in a way the backend cannot reconcile
+// with the changes of the transaction. None of them is applied.
+var ErrTxConflict = errors.New("transaction conflicts with a concurrent change")
+
+// TxStagingPrefix starts the names of the directories in which BeginTx
+// stages the files written by the transactions it emulates.
+const TxStagingPrefix = ".fsx-tx-"
+
+// Tx is a transaction of a filesystem. Changes made through it take effect
+// on the filesystem when it is committed, and are discarded when it is
+// rolled back. Once it ended, it changes nothing: its methods fail with
+// ErrTxDone or change a detached copy.
+type Tx interface {
+	FileSystem
+	// Commit applies the changes of the transaction to the filesystem and
+	// ends it. Files opened through the transaction must be closed before.
+	Commit() error
+	// Rollback discards the changes of the transaction and ends it. It
+	// returns ErrTxDone if the transaction already ended, which deferred
+	// calls can ignore.
+	Rollback() error
+}
+
+// TxFS is the interface implemented by a file system that supports
+// transactions natively, so that a series of changes is applied all at once
+// or not at all.
+type TxFS interface {
+	FS
+	// BeginTx starts a transaction. It returns an error wrapping
+	// errors.ErrUnsupported if the filesystem cannot start one as
+	// configured, in which case BeginTx emulates it.
+	BeginTx(ctx context.Context) (Tx, error)
+}
+
+// BeginTx starts a transaction of fsys, bound to ctx until it ends.
+//
+// If fsys implements TxFS, it calls fsys.BeginTx. Otherwise, the transaction
+// is emulated: the files written through it are staged in a directory of
+// fsys named with TxStagingPrefix, and their other changes, such as Mkdir or
+// Remove, are queued. Commit applies them in order, moving the staged files
+// into place with Rename, and removes the staging directory, as does
+// Rollback. Emulated transactions only isolate the contents of the files
+// they write: apart from those, they read the filesystem as it is. The
+// errors of the queued changes are reported by Commit, which leaves the
+// changes applied before the failure in place.
+func BeginTx(ctx context.Context, fsys FS) (Tx, error) {
+	if tfs, ok := fsys.(TxFS); ok {
+		tx, err := tfs.BeginTx(ctx)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return tx, err
+		}
+	}
+	return &stagedTx{
+		PassthroughFS: PassthroughFS{Inner: fsys},
+		ctx:           ctx,
+		staging:       TxStagingPrefix + strconv.FormatUint(txSeq.Add(1), 36) + strconv.FormatInt(time.Now().UnixNano(), 36),
+		files:         make(map[string]string),
+	}, nil
+}
+
+// txSeq tells apart the staging directories of the transactions of a
+// process.
+var txSeq atomic.Uint64
+
+// stagedTx is a transaction emulated by BeginTx. Its reading methods are
+// those of the embedded PassthroughFS.
+type stagedTx struct {
+	PassthroughFS
+	ctx context.Context
+	// staging is the directory of the staged files, created with the first
+	// of them.
+	staging string
+
+	mu sync.Mutex
+	// ops are the changes applied by Commit, in order.
+	ops []func(ctx context.Context) error
+	// files maps the names of the files written by the transaction to their
+	// staged copies.
+	files  map[string]string
+	staged int
+	done   bool
+}
+
+// queue queues fn, the change op of name, for Commit. moved are the names
+// fn moves or removes.
+func (t *stagedTx) queue(op, name string, fn func(ctx context.Context) error, moved ...string) error {
+	if err := internal.CheckPath(op, name); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return &fs.PathError{Op: op, Path: name, Err: ErrTxDone}
+	}
+	t.ops = append(t.ops, fn)
+	// The staged copies of the files fn may move or remove are forgotten:
+	// later writes start again from the files as they will be.
+	for staged := range t.files {
+		for _, n := range moved {
+			if HasPathPrefix(staged, n) {
+				delete(t.files, staged)
+			}
+		}
+	}
+	return nil
+}
+
+// stage returns the staged copy of name, made with the contents of the file
+// unless empty is set. perm is the mode of the copy if the file does not
+// exist.
+func (t *stagedTx) stage(op, name string, empty bool, perm fs.FileMode) (string, error) {
+	if err := internal.CheckPath(op, name); err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return "", &fs.PathError{Op: op, Path: name, Err: ErrTxDone}
+	}
+	if staged, ok := t.files[name]; ok {
+		return staged, nil
+	}
+
+	var data []byte
+	info, err := Stat(t.ctx, t.Inner, name)
+	if err == nil {
+		perm = info.Mode().Perm()
+		if !empty {
+			data, err = ReadFile(t.ctx, t.Inner, name)
+		}
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", internal.Decorate(op, name, err)
+	}
+	if t.staged == 0 {
+		if err := Mkdir(t.ctx, t.Inner, t.staging, 0700); err != nil {
+			return "", internal.Decorate(op, name, err)
+		}
+	}
+	t.staged++
+	staged := path.Join(t.staging, strconv.Itoa(t.staged))
+	if err := WriteFile(t.ctx, t.Inner, staged, data, perm); err != nil {
+		return "", internal.Decorate(op, name, err)
+	}
+	t.files[name] = staged
+	t.ops = append(t.ops, func(ctx context.Context) error {
+		return Rename(ctx, t.Inner, staged, name)
+	})
+	return staged, nil
+}
+
+// read returns the name to read name from: its staged copy, if any.
+func (t *stagedTx) read(name string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if staged, ok := t.files[name]; ok {
+		return staged
+	}
+	return name
+}
+
+func (t *stagedTx) Open(ctx context.Context, name string) (fs.File, error) {
+	if err := internal.CheckPath("open", name); err != nil {
+		return nil, err
+	}
+	f, err := Open(ctx, t.Inner, t.read(name))
+	return f, internal.Decorate("open", name, err)
+}
+
+func (t *stagedTx) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := internal.CheckPath("readfile", name); err != nil {
+		return nil, err
+	}
+	data, err := ReadFile(ctx, t.Inner, t.read(name))
+	return data, internal.Decorate("readfile", name, err)
+}
+
+func (t *stagedTx) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("stat", name); err != nil {
+		return nil, err
+	}
+	info, err := Stat(ctx, t.Inner, t.read(name))
+	return info, internal.Decorate("stat", name, err)
+}
+
+func (t *stagedTx) Create(ctx context.Context, name string) (File, error) {
+	return t.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the named file. Files opened for writing are staged copies.
+func (t *stagedTx) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		if err := internal.CheckPath("open", name); err != nil {
+			return nil, err
+		}
+		f, err := OpenFile(ctx, t.Inner, t.read(name), flag, mode)
+		return f, internal.Decorate("open", name, err)
+	}
+
+	_, err := Stat(ctx, t, name)
+	switch {
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE == 0:
+		return nil, internal.Decorate("open", name, err)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return nil, internal.Decorate("open", name, err)
+	}
+	staged, err := t.stage("open", name, flag&os.O_TRUNC != 0, applyUmask(ctx, mode).Perm())
+	if err != nil {
+		return nil, err
+	}
+	f, err := OpenFile(ctx, t.Inner, staged, flag&^(os.O_CREATE|os.O_EXCL|os.O_TRUNC), 0)
+	return f, internal.Decorate("open", name, err)
+}
+
+func (t *stagedTx) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	staged, err := t.stage("writefile", name, true, applyUmask(ctx, perm).Perm())
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("writefile", name, WriteFile(ctx, t.Inner, staged, data, perm))
+}
+
+func (t *stagedTx) Truncate(ctx context.Context, name string, size int64) error {
+	staged, err := t.stage("truncate", name, false, 0)
+	if err != nil {
+		return err
+	}
+	return internal.Decorate("truncate", name, Truncate(ctx, t.Inner, staged, size))
+}
+
+func (t *stagedTx) Remove(ctx context.Context, name string) error {
+	return t.queue("remove", name, func(ctx context.Context) error {
+		return Remove(ctx, t.Inner, name)
+	}, name)
+}
+
+func (t *stagedTx) RemoveAll(ctx context.Context, name string) error {
+	return t.queue("removeall", name, func(ctx context.Context) error {
+		return RemoveAll(ctx, t.Inner, name)
+	}, name)
+}
+
+func (t *stagedTx) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	return t.queue("mkdir", name, func(ctx context.Context) error {
+		return Mkdir(ctx, t.Inner, name, perm)
+	})
+}
+
+func (t *stagedTx) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	return t.queue("mkdir", name, func(ctx context.Context) error {
+		return MkdirAll(ctx, t.Inner, name, perm)
+	})
+}
+
+func (t *stagedTx) Rename(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("rename", oldname, newname); err != nil {
+		return err
+	}
+	return t.queue("rename", oldname, func(ctx context.Context) error {
+		return Rename(ctx, t.Inner, oldname, newname)
+	}, oldname, newname)
+}
+
+func (t *stagedTx) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	if err := internal.CheckLink("rename", oldname, newname); err != nil {
+		return err
+	}
+	return t.queue("rename", oldname, func(ctx context.Context) error {
+		return RenameWithOptions(ctx, t.Inner, oldname, newname, flags)
+	}, oldname, newname)
+}
+
+func (t *stagedTx) Symlink(ctx context.Context, oldname, newname string) error {
+	return t.queue("symlink", newname, func(ctx context.Context) error {
+		return Symlink(ctx, t.Inner, oldname, newname)
+	})
+}
+
+func (t *stagedTx) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	return t.queue("mknod", name, func(ctx context.Context) error {
+		return CreateSpecial(ctx, t.Inner, name, mode, dev)
+	})
+}
+
+func (t *stagedTx) Lchown(ctx context.Context, name, owner, group string) error {
+	return t.queue("lchown", name, func(ctx context.Context) error {
+		return Lchown(ctx, t.Inner, name, owner, group)
+	})
+}
+
+func (t *stagedTx) Chown(ctx context.Context, name, owner, group string) error {
+	return t.queue("chown", name, func(ctx context.Context) error {
+		return Chown(ctx, t.Inner, name, owner, group)
+	})
+}
+
+func (t *stagedTx) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	return t.queue("chmod", name, func(ctx context.Context) error {
+		return Chmod(ctx, t.Inner, name, mode)
+	})
+}
+
+func (t *stagedTx) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	return t.queue("chtimes", name, func(ctx context.Context) error {
+		return Chtimes(ctx, t.Inner, name, atime, mtime)
+	})
+}
+
+func (t *stagedTx) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	value = slices.Clone(value)
+	return t.queue("setxattr", name, func(ctx context.Context) error {
+		return SetXattr(ctx, t.Inner, name, attr, value, flags)
+	})
+}
+
+func (t *stagedTx) RemoveXattr(ctx context.Context, name, attr string) error {
+	return t.queue("removexattr", name, func(ctx context.Context) error {
+		return RemoveXattr(ctx, t.Inner, name, attr)
+	})
+}
+
+// end ends the transaction, returning the queued changes.
+func (t *stagedTx) end() ([]func(ctx context.Context) error, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return nil, ErrTxDone
+	}
+	t.done = true
+	return t.ops, nil
+}
+
+// Commit applies the queued changes in order, stopping at the first error.
+func (t *stagedTx) Commit() error {
+	ops, err := t.end()
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if err = op(t.ctx); err != nil {
+			break
+		}
+	}
+	return errors.Join(err, t.cleanup())
+}
+
+// Rollback removes the staged files.
+func (t *stagedTx) Rollback() error {
+	if _, err := t.end(); err != nil {
+		return err
+	}
+	return t.cleanup()
+}
+
+// Close rolls the transaction back unless it ended. It leaves the
+// filesystem open.
+func (t *stagedTx) Close() error {
+	if err := t.Rollback(); !errors.Is(err, ErrTxDone) {
+		return err
+	}
+	return nil
+}
+
+// cleanup removes the staging directory.
+func (t *stagedTx) cleanup() error {
+	if t.staged == 0 {
+		return nil
+	}
+	return RemoveAll(t.ctx, t.Inner, t.staging)
+}
+
+var _ Tx = &stagedTx{}
+var _ SpecialFS = &stagedTx{}
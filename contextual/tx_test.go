@@ -0,0 +1,196 @@
+package contextual_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestBeginTx(t *testing.T) {
+	ctx := t.Context()
+	newFS := func(t *testing.T) contextual.FileSystem {
+		t.Helper()
+		fsys, err := osfs.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfs := contextual.ToContextual(fsys).(contextual.FileSystem)
+		if err := contextual.WriteFile(ctx, cfs, "old", []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return cfs
+	}
+	// entries returns the names of the root of fsys.
+	entries := func(t *testing.T, fsys contextual.FS) string {
+		t.Helper()
+		list, err := contextual.ReadDir(ctx, fsys, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range list {
+			names = append(names, e.Name())
+		}
+		return strings.Join(names, " ")
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		fsys := newFS(t)
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "new", []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := contextual.OpenFile(ctx, tx, "old", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("er")); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Mkdir(ctx, tx, "dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		// The transaction reads what it wrote, the filesystem does not.
+		if data, err := contextual.ReadFile(ctx, tx, "old"); err != nil || string(data) != "older" {
+			t.Errorf("ReadFile(tx) = %q, %v; want older", data, err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "old"); err != nil || string(data) != "old" {
+			t.Errorf("ReadFile() = %q, %v; want old", data, err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, "new"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected new to be staged, got %v", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := entries(t, fsys); got != "dir new old" {
+			t.Errorf("entries after Commit = %q", got)
+		}
+		if info, err := contextual.Stat(ctx, fsys, "old"); err != nil || info.Mode().Perm() != 0600 || info.Size() != 5 {
+			t.Errorf("Stat(old) = %v, %v; want the mode kept and the data appended", info, err)
+		}
+		if err := tx.Commit(); !errors.Is(err, contextual.ErrTxDone) {
+			t.Errorf("expected ErrTxDone, got %v", err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "late", nil, 0644); !errors.Is(err, contextual.ErrTxDone) {
+			t.Errorf("expected ErrTxDone, got %v", err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		fsys := newFS(t)
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "old", []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Remove(ctx, tx, "old"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if got := entries(t, fsys); got != "old" {
+			t.Errorf("entries after Rollback = %q", got)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "old"); err != nil || string(data) != "old" {
+			t.Errorf("ReadFile() = %q, %v; want old", data, err)
+		}
+		if err := tx.Rollback(); !errors.Is(err, contextual.ErrTxDone) {
+			t.Errorf("expected ErrTxDone, got %v", err)
+		}
+	})
+
+	t.Run("metadata between writes", func(t *testing.T) {
+		fsys := newFS(t)
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "old", []byte("new"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Chmod(ctx, tx, "old", 0640); err != nil {
+			t.Fatal(err)
+		}
+		f, err := contextual.OpenFile(ctx, tx, "old", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("er")); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "old"); err != nil || string(data) != "newer" {
+			t.Errorf("ReadFile() = %q, %v; want newer", data, err)
+		}
+		if info, err := contextual.Stat(ctx, fsys, "old"); err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("Stat(old) = %v, %v; want the mode set by Chmod", info, err)
+		}
+	})
+
+	t.Run("rename with options", func(t *testing.T) {
+		fsys := newFS(t)
+		if err := contextual.WriteFile(ctx, fsys, "other", []byte("other"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.RenameWithOptions(ctx, tx, "old", "other", fsx.RenameExchange); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "old"); err != nil || string(data) != "old" {
+			t.Errorf("ReadFile(old) = %q, %v; want the exchange held until Commit", data, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "old"); err != nil || string(data) != "other" {
+			t.Errorf("ReadFile(old) = %q, %v; want other", data, err)
+		}
+	})
+
+	t.Run("failing commit", func(t *testing.T) {
+		fsys := newFS(t)
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "a", nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Remove(ctx, tx, "missing"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the error of Remove, got %v", err)
+		}
+		// The changes before the failure stay, the staging directory goes.
+		if got := entries(t, fsys); got != "a old" {
+			t.Errorf("entries after Commit = %q", got)
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package contextual
+
+import (
+	"context"
+	"io/fs"
+)
+
+// umaskKey is the context key under which WithUmask stores the mask.
+type umaskKey struct{}
+
+// WithUmask returns a copy of ctx carrying mask as the file mode creation mask.
+//
+// The Create, OpenFile, Mkdir, MkdirAll and WriteFile helpers clear the
+// permission bits set in mask from the mode they pass to the backend, so the
+// modes of created files and directories can be controlled per request.
+// Backends that are called directly should apply Umask themselves.
+func WithUmask(ctx context.Context, mask fs.FileMode) context.Context {
+	return context.WithValue(ctx, umaskKey{}, mask.Perm())
+}
+
+// Umask returns the file mode creation mask carried by ctx, if any.
+func Umask(ctx context.Context) (fs.FileMode, bool) {
+	mask, ok := ctx.Value(umaskKey{}).(fs.FileMode)
+	return mask, ok
+}
+
+// applyUmask clears the permission bits of mode that are set in the umask
+// carried by ctx. It returns mode unchanged if ctx carries no umask.
+func applyUmask(ctx context.Context, mode fs.FileMode) fs.FileMode {
+	if mask, ok := Umask(ctx); ok {
+		return mode &^ mask
+	}
+	return mode
+}
@@ -0,0 +1,114 @@
+package contextual_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"github.com/gwangyi/fsx/osfs"
+	"go.uber.org/mock/gomock"
+)
+
+func TestUmask(t *testing.T) {
+	if _, ok := contextual.Umask(t.Context()); ok {
+		t.Error("expected no umask in plain context")
+	}
+
+	ctx := contextual.WithUmask(t.Context(), 0o4022)
+	mask, ok := contextual.Umask(ctx)
+	if !ok || mask != 0o022 {
+		t.Errorf("Umask() = %v, %v; want 0022, true", mask, ok)
+	}
+}
+
+func TestUmask_Helpers(t *testing.T) {
+	ctx := contextual.WithUmask(t.Context(), 0o027)
+
+	t.Run("Create", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockWriterFS(ctrl)
+		m.EXPECT().OpenFile(ctx, "foo", os.O_RDWR|os.O_CREATE|os.O_TRUNC, fs.FileMode(0o640)).Return(nil, nil)
+		if _, err := contextual.Create(ctx, m, "foo"); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	})
+
+	t.Run("OpenFile", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockWriterFS(ctrl)
+		m.EXPECT().OpenFile(ctx, "foo", os.O_WRONLY|os.O_CREATE, fs.FileMode(0o750)).Return(nil, nil)
+		if _, err := contextual.OpenFile(ctx, m, "foo", os.O_WRONLY|os.O_CREATE, 0o777); err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+	})
+
+	t.Run("Mkdir", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockDirFS(ctrl)
+		m.EXPECT().Mkdir(ctx, "dir", fs.FileMode(0o750)).Return(nil)
+		if err := contextual.Mkdir(ctx, m, "dir", 0o777); err != nil {
+			t.Fatalf("Mkdir failed: %v", err)
+		}
+	})
+
+	t.Run("MkdirAll", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockMkdirAllFS(ctrl)
+		m.EXPECT().MkdirAll(ctx, "a/b", fs.FileMode(0o750)).Return(nil)
+		if err := contextual.MkdirAll(ctx, m, "a/b", 0o777); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+	})
+
+	t.Run("WriteFile", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		m := cmockfs.NewMockWriteFileFS(ctrl)
+		m.EXPECT().WriteFile(ctx, "foo", []byte("data"), fs.FileMode(0o640)).Return(nil)
+		if err := contextual.WriteFile(ctx, m, "foo", []byte("data"), 0o666); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	})
+}
+
+func TestUmask_ToContextual(t *testing.T) {
+	dir := t.TempDir()
+	fsys, err := osfs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfs := contextual.ToContextual(fsys).(contextual.DirFS)
+
+	// The process umask may only narrow the resulting modes further.
+	ctx := contextual.WithUmask(t.Context(), 0o077)
+	f, err := cfs.Create(ctx, "file")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	_ = f.Close()
+	if err := cfs.Mkdir(ctx, "dir", 0o777); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	for name, want := range map[string]fs.FileMode{"file": 0o600, "dir": 0o700} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got&^want != 0 {
+			t.Errorf("%s has mode %v, want at most %v", name, got, want)
+		}
+	}
+}
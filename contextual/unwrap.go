@@ -0,0 +1,17 @@
+package contextual
+
+// Unwrap returns the filesystems fsys is layered on, as reported by an
+// Unwrap() FS method, like that of statcachefs, or an Unwrap() []FS method,
+// like that of unionfs. It returns nil if fsys has neither, such as a
+// backend or a layer confining the filesystem it is built on, like Sub.
+func Unwrap(fsys FS) []FS {
+	switch u := fsys.(type) {
+	case interface{ Unwrap() FS }:
+		if inner := u.Unwrap(); inner != nil {
+			return []FS{inner}
+		}
+	case interface{ Unwrap() []FS }:
+		return u.Unwrap()
+	}
+	return nil
+}
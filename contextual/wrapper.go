@@ -3,13 +3,19 @@ package contextual
 import (
 	"context"
 	"io/fs"
+	"os"
 	"time"
 
 	"github.com/gwangyi/fsx"
 )
 
 // ToContextual converts a non-contextual fs.FS to a contextual FS.
-// The returned FS ignores the context.
+// Directories of an fsys implementing fs.ReadDirFS are opened as
+// fs.ReadDirFile, even if fsys does not return them as such. The returned
+// FS ignores the context, except for the umask set with
+// WithUmask, which is applied to the modes of created files and directories,
+// and the Resolver set with WithResolver, which translates the names passed
+// to Chown and Lchown to ids.
 func ToContextual(fsys fs.FS) FS {
 	return &contextualFS{fsys: fsys}
 }
@@ -19,15 +25,22 @@ type contextualFS struct {
 }
 
 func (c *contextualFS) Open(ctx context.Context, name string) (fs.File, error) {
-	return c.fsys.Open(name)
+	f, err := c.fsys.Open(name)
+	if rfs, ok := c.fsys.(fs.ReadDirFS); ok && err == nil {
+		return fsx.AsReadDirFile(f, func() ([]fs.DirEntry, error) { return rfs.ReadDir(name) }), nil
+	}
+	return f, err
 }
 
 func (c *contextualFS) Create(ctx context.Context, name string) (File, error) {
+	if _, ok := Umask(ctx); ok {
+		return fsx.OpenFile(c.fsys, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, applyUmask(ctx, 0666))
+	}
 	return fsx.Create(c.fsys, name)
 }
 
 func (c *contextualFS) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (File, error) {
-	return fsx.OpenFile(c.fsys, name, flag, mode)
+	return fsx.OpenFile(c.fsys, name, flag, applyUmask(ctx, mode))
 }
 
 func (c *contextualFS) Remove(ctx context.Context, name string) error {
@@ -47,11 +60,11 @@ func (c *contextualFS) ReadDir(ctx context.Context, name string) ([]fs.DirEntry,
 }
 
 func (c *contextualFS) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
-	return fsx.Mkdir(c.fsys, name, perm)
+	return fsx.Mkdir(c.fsys, name, applyUmask(ctx, perm))
 }
 
 func (c *contextualFS) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
-	return fsx.MkdirAll(c.fsys, name, perm)
+	return fsx.MkdirAll(c.fsys, name, applyUmask(ctx, perm))
 }
 
 func (c *contextualFS) RemoveAll(ctx context.Context, name string) error {
@@ -62,6 +75,10 @@ func (c *contextualFS) Rename(ctx context.Context, oldname, newname string) erro
 	return fsx.Rename(c.fsys, oldname, newname)
 }
 
+func (c *contextualFS) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	return fsx.RenameWithOptions(c.fsys, oldname, newname, flags)
+}
+
 func (c *contextualFS) Symlink(ctx context.Context, oldname, newname string) error {
 	return fsx.Symlink(c.fsys, oldname, newname)
 }
@@ -75,7 +92,9 @@ func (c *contextualFS) Lstat(ctx context.Context, name string) (fs.FileInfo, err
 }
 
 func (c *contextualFS) Lchown(ctx context.Context, name, owner, group string) error {
-	return fsx.Lchown(c.fsys, name, owner, group)
+	return chownWith(ctx, "lchown", name, owner, group, func(owner, group string) error {
+		return fsx.Lchown(c.fsys, name, owner, group)
+	})
 }
 
 func (c *contextualFS) Truncate(ctx context.Context, name string, size int64) error {
@@ -83,11 +102,13 @@ func (c *contextualFS) Truncate(ctx context.Context, name string, size int64) er
 }
 
 func (c *contextualFS) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
-	return fsx.WriteFile(c.fsys, name, data, perm)
+	return fsx.WriteFile(c.fsys, name, data, applyUmask(ctx, perm))
 }
 
 func (c *contextualFS) Chown(ctx context.Context, name, owner, group string) error {
-	return fsx.Chown(c.fsys, name, owner, group)
+	return chownWith(ctx, "chown", name, owner, group, func(owner, group string) error {
+		return fsx.Chown(c.fsys, name, owner, group)
+	})
 }
 
 func (c *contextualFS) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
@@ -98,6 +119,34 @@ func (c *contextualFS) Chtimes(ctx context.Context, name string, atime, ctime ti
 	return fsx.Chtimes(c.fsys, name, atime, ctime)
 }
 
+func (c *contextualFS) Access(ctx context.Context, name string, mode uint32) error {
+	return fsx.Access(c.fsys, name, mode)
+}
+
+func (c *contextualFS) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	return fsx.CreateSpecial(c.fsys, name, applyUmask(ctx, mode), dev)
+}
+
+func (c *contextualFS) GetXattr(ctx context.Context, name, attr string) ([]byte, error) {
+	return fsx.GetXattr(c.fsys, name, attr)
+}
+
+func (c *contextualFS) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	return fsx.SetXattr(c.fsys, name, attr, value, flags)
+}
+
+func (c *contextualFS) ListXattr(ctx context.Context, name string) ([]string, error) {
+	return fsx.ListXattr(c.fsys, name)
+}
+
+func (c *contextualFS) RemoveXattr(ctx context.Context, name, attr string) error {
+	return fsx.RemoveXattr(c.fsys, name, attr)
+}
+
+func (c *contextualFS) Close() error {
+	return fsx.Close(c.fsys)
+}
+
 // FromContextual converts a contextual FS to a non-contextual fs.FS.
 // The returned filesystem satisfies fsx.FileSystem and all standard io/fs interfaces
 // by using the provided context for every operation.
@@ -118,104 +167,36 @@ type nonContextualFS struct {
 	ctx  context.Context
 }
 
-// Open implements fs.FS.
+// Open implements fs.FS. Directories of filesystems implementing ReadDirFS
+// are opened as fs.ReadDirFile, even if the filesystem does not return them
+// as such.
 func (n *nonContextualFS) Open(name string) (fs.File, error) {
-	return n.fsys.Open(n.ctx, name)
-}
-
-// Create implements fsx.WriterFS.
-func (n *nonContextualFS) Create(name string) (File, error) {
-	return Create(n.ctx, n.fsys, name)
-}
-
-// OpenFile implements fsx.WriterFS.
-func (n *nonContextualFS) OpenFile(name string, flag int, mode fs.FileMode) (File, error) {
-	return OpenFile(n.ctx, n.fsys, name, flag, mode)
-}
-
-// Remove implements fsx.WriterFS.
-func (n *nonContextualFS) Remove(name string) error {
-	return Remove(n.ctx, n.fsys, name)
-}
-
-// ReadFile implements fs.ReadFileFS.
-func (n *nonContextualFS) ReadFile(name string) ([]byte, error) {
-	return ReadFile(n.ctx, n.fsys, name)
-}
-
-// Stat implements fs.StatFS.
-func (n *nonContextualFS) Stat(name string) (fs.FileInfo, error) {
-	return Stat(n.ctx, n.fsys, name)
-}
-
-// ReadDir implements fs.ReadDirFS.
-func (n *nonContextualFS) ReadDir(name string) ([]fs.DirEntry, error) {
-	return ReadDir(n.ctx, n.fsys, name)
-}
-
-// Mkdir implements fsx.DirFS.
-func (n *nonContextualFS) Mkdir(name string, perm fs.FileMode) error {
-	return Mkdir(n.ctx, n.fsys, name, perm)
-}
-
-// MkdirAll implements fsx.MkdirAllFS.
-func (n *nonContextualFS) MkdirAll(name string, perm fs.FileMode) error {
-	return MkdirAll(n.ctx, n.fsys, name, perm)
-}
-
-// RemoveAll implements fsx.RemoveAllFS.
-func (n *nonContextualFS) RemoveAll(name string) error {
-	return RemoveAll(n.ctx, n.fsys, name)
-}
-
-// Rename implements fsx.RenameFS.
-func (n *nonContextualFS) Rename(oldname, newname string) error {
-	return Rename(n.ctx, n.fsys, oldname, newname)
-}
-
-// Symlink implements fsx.SymlinkFS.
-func (n *nonContextualFS) Symlink(oldname, newname string) error {
-	return Symlink(n.ctx, n.fsys, oldname, newname)
-}
-
-// ReadLink implements fs.ReadLinkFS.
-func (n *nonContextualFS) ReadLink(name string) (string, error) {
-	return ReadLink(n.ctx, n.fsys, name)
-}
-
-// Lstat implements fs.ReadLinkFS.
-func (n *nonContextualFS) Lstat(name string) (fs.FileInfo, error) {
-	return Lstat(n.ctx, n.fsys, name)
-}
-
-// Lchown implements fsx.LchownFS.
-func (n *nonContextualFS) Lchown(name, owner, group string) error {
-	return Lchown(n.ctx, n.fsys, name, owner, group)
-}
-
-// Truncate implements fsx.TruncateFS.
-func (n *nonContextualFS) Truncate(name string, size int64) error {
-	return Truncate(n.ctx, n.fsys, name, size)
-}
-
-// WriteFile implements fsx.WriteFileFS.
-func (n *nonContextualFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
-	return WriteFile(n.ctx, n.fsys, name, data, perm)
-}
-
-// Chown implements fsx.ChangeFS.
-func (n *nonContextualFS) Chown(name, owner, group string) error {
-	return Chown(n.ctx, n.fsys, name, owner, group)
+	f, err := n.fsys.Open(n.ctx, name)
+	if rfs, ok := n.fsys.(ReadDirFS); ok && err == nil {
+		return fsx.AsReadDirFile(f, func() ([]fs.DirEntry, error) { return rfs.ReadDir(n.ctx, name) }), nil
+	}
+	return f, err
 }
 
-// Chmod implements fsx.ChangeFS.
-func (n *nonContextualFS) Chmod(name string, mode fs.FileMode) error {
-	return Chmod(n.ctx, n.fsys, name, mode)
+// Sub implements fs.SubFS. The subtree uses the same context.
+func (n *nonContextualFS) Sub(dir string) (fs.FS, error) {
+	sub, err := Sub(n.fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return FromContextual(sub, n.ctx), nil
 }
 
-// Chtimes implements fsx.ChangeFS.
-func (n *nonContextualFS) Chtimes(name string, atime, ctime time.Time) error {
-	return Chtimes(n.ctx, n.fsys, name, atime, ctime)
+// Close implements fsx.CloserFS.
+func (n *nonContextualFS) Close() error {
+	return Close(n.fsys)
 }
 
 var _ fsx.FileSystem = &nonContextualFS{}
+var _ fsx.AccessFS = &nonContextualFS{}
+var _ fsx.SpecialFS = &nonContextualFS{}
+var _ fsx.RenameOptionsFS = &nonContextualFS{}
+var _ fsx.XattrFS = &nonContextualFS{}
+var _ fsx.CloserFS = &nonContextualFS{}
+var _ fs.GlobFS = &nonContextualFS{}
+var _ fs.SubFS = &nonContextualFS{}
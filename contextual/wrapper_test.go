@@ -1,6 +1,7 @@
 package contextual_test
 
 import (
+	"context"
 	"errors"
 	"io/fs"
 	"os"
@@ -47,6 +48,72 @@ func TestToContextual_Open(t *testing.T) {
 	}
 }
 
+// plainDirs opens directories as plain files, while listing them with
+// ReadDir.
+type plainDirs struct {
+	fstest.MapFS
+}
+
+func (p plainDirs) Open(name string) (fs.File, error) {
+	f, err := p.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ fs.File }{f}, nil
+}
+
+// plainContextualDirs is plainDirs for contextual filesystems.
+type plainContextualDirs struct {
+	contextual.ReadDirFS
+}
+
+func (p plainContextualDirs) Open(ctx context.Context, name string) (fs.File, error) {
+	f, err := p.ReadDirFS.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ fs.File }{f}, nil
+}
+
+func TestDirectoryHandles(t *testing.T) {
+	mapFS := fstest.MapFS{"dir/a": {Data: []byte("a")}, "dir/b": {Data: []byte("b")}}
+
+	t.Run("ToContextual", func(t *testing.T) {
+		fsys := contextual.ToContextual(plainDirs{mapFS})
+		f, err := fsys.Open(t.Context(), "dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		if dir, ok := f.(fs.ReadDirFile); !ok {
+			t.Error("expected a ReadDirFile")
+		} else if entries, err := dir.ReadDir(-1); err != nil || len(entries) != 2 {
+			t.Errorf("ReadDir(-1) = %v, %v; want 2 entries", entries, err)
+		}
+	})
+
+	t.Run("FromContextual", func(t *testing.T) {
+		inner := contextual.ToContextual(mapFS).(contextual.ReadDirFS)
+		fsys := contextual.FromContextual(plainContextualDirs{inner}, t.Context())
+		var names []string
+		err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			names = append(names, name)
+			return err
+		})
+		if err != nil || len(names) != 4 {
+			t.Errorf("WalkDir() listed %q, %v; want ., dir, dir/a and dir/b", names, err)
+		}
+		f, err := fsys.Open("dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		if _, ok := f.(fs.ReadDirFile); !ok {
+			t.Error("expected a ReadDirFile")
+		}
+	})
+}
+
 func TestToContextual_WriterFS(t *testing.T) {
 	t.Run("Create", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
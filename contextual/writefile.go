@@ -4,7 +4,12 @@ import (
 	"context"
 	"errors"
 	"io/fs"
+	"math/rand/v2"
 	"os"
+	"path"
+	"strconv"
+
+	"github.com/gwangyi/fsx"
 )
 
 // WriteFileFS is the interface implemented by a filesystem that provides
@@ -17,7 +22,9 @@ type WriteFileFS interface {
 }
 
 // WriteFile writes data to the named file, creating it if necessary.
+// The umask carried by ctx, if any, is applied to perm.
 func WriteFile(ctx context.Context, fsys FS, name string, data []byte, perm fs.FileMode) error {
+	perm = applyUmask(ctx, perm)
 	if wfs, ok := fsys.(WriteFileFS); ok {
 		if err := wfs.WriteFile(ctx, name, data, perm); !errors.Is(err, errors.ErrUnsupported) {
 			return intoPathErr("writefile", name, err)
@@ -34,3 +41,54 @@ func WriteFile(ctx context.Context, fsys FS, name string, data []byte, perm fs.F
 	}
 	return intoPathErr("writefile", name, err)
 }
+
+// WriteFileAtomic writes data to the named file so that concurrent readers
+// see either its previous contents or data, but never a partial write.
+// The data is written to a temporary file in the same directory, which is
+// then renamed over name; on failure the temporary file is removed.
+// The umask carried by ctx, if any, is applied to perm.
+//
+// If name does not exist when WriteFileAtomic starts, the temporary file is
+// renamed with fsx.RenameNoReplace, like CreateFileAtomic does, so that a
+// file created by someone else meanwhile is not silently replaced: the
+// call fails with an error wrapping fs.ErrExist instead.
+func WriteFileAtomic(ctx context.Context, fsys FS, name string, data []byte, perm fs.FileMode) error {
+	var flags fsx.RenameFlags
+	if _, err := Lstat(ctx, fsys, name); errors.Is(err, fs.ErrNotExist) {
+		flags = fsx.RenameNoReplace
+	}
+	return writeAtomic(ctx, fsys, name, data, perm, flags)
+}
+
+// CreateFileAtomic is like WriteFileAtomic, but fails with an error wrapping
+// fs.ErrExist if name already exists, leaving it untouched. The temporary
+// file is renamed with fsx.RenameNoReplace, so that checking for name and
+// creating it is atomic if fsys implements RenameOptionsFS; otherwise it is
+// emulated as described by RenameWithOptions.
+func CreateFileAtomic(ctx context.Context, fsys FS, name string, data []byte, perm fs.FileMode) error {
+	return writeAtomic(ctx, fsys, name, data, perm, fsx.RenameNoReplace)
+}
+
+// writeAtomic writes data to a temporary file and renames it to name with
+// flags.
+func writeAtomic(ctx context.Context, fsys FS, name string, data []byte, perm fs.FileMode, flags fsx.RenameFlags) error {
+	dir, base := SplitDirFile(name)
+	tmp := path.Join(dir, "."+base+".tmp"+strconv.FormatUint(rand.Uint64(), 36))
+
+	f, err := OpenFile(ctx, fsys, tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return intoPathErr("writefile", name, err)
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = RenameWithOptions(ctx, fsys, tmp, name, flags)
+	}
+	if err != nil {
+		_ = Remove(ctx, fsys, tmp)
+		return intoPathErr("writefile", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package contextual
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// XattrFS is the interface implemented by a file system that supports
+// context-aware access to extended attributes. Attribute names follow the
+// conventions of fsx.XattrFS.
+type XattrFS interface {
+	FS
+
+	// GetXattr returns the value of the extended attribute attr of the named
+	// file. If the attribute does not exist, the error wraps fsx.ErrNoXattr.
+	GetXattr(ctx context.Context, name, attr string) ([]byte, error)
+
+	// SetXattr sets the value of the extended attribute attr of the named
+	// file. flags is zero, fsx.XATTR_CREATE or fsx.XATTR_REPLACE.
+	SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error
+
+	// ListXattr returns the names of the extended attributes of the named
+	// file.
+	ListXattr(ctx context.Context, name string) ([]string, error)
+
+	// RemoveXattr removes the extended attribute attr of the named file.
+	RemoveXattr(ctx context.Context, name, attr string) error
+}
+
+// GetXattr returns the value of the extended attribute attr of the named
+// file in the provided filesystem.
+// If fsys implements XattrFS, it calls fsys.GetXattr.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func GetXattr(ctx context.Context, fsys FS, name, attr string) ([]byte, error) {
+	if err := internal.CheckXattr("getxattr", name, attr); err != nil {
+		return nil, err
+	}
+	if xfs, ok := fsys.(XattrFS); ok {
+		value, err := xfs.GetXattr(ctx, name, attr)
+		return value, intoPathErr("getxattr", name, err)
+	}
+	return nil, intoPathErr("getxattr", name, errors.ErrUnsupported)
+}
+
+// SetXattr sets the value of the extended attribute attr of the named file
+// in the provided filesystem. flags is zero, fsx.XATTR_CREATE or
+// fsx.XATTR_REPLACE.
+// If fsys implements XattrFS, it calls fsys.SetXattr.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func SetXattr(ctx context.Context, fsys FS, name, attr string, value []byte, flags int) error {
+	if err := internal.CheckXattr("setxattr", name, attr); err != nil {
+		return err
+	}
+	if flags&^(internal.XATTR_CREATE|internal.XATTR_REPLACE) != 0 || flags == internal.XATTR_CREATE|internal.XATTR_REPLACE {
+		return intoPathErr("setxattr", name, fs.ErrInvalid)
+	}
+	if xfs, ok := fsys.(XattrFS); ok {
+		return intoPathErr("setxattr", name, xfs.SetXattr(ctx, name, attr, value, flags))
+	}
+	return intoPathErr("setxattr", name, errors.ErrUnsupported)
+}
+
+// ListXattr returns the names of the extended attributes of the named file
+// in the provided filesystem.
+// If fsys implements XattrFS, it calls fsys.ListXattr.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func ListXattr(ctx context.Context, fsys FS, name string) ([]string, error) {
+	if err := internal.CheckPath("listxattr", name); err != nil {
+		return nil, err
+	}
+	if xfs, ok := fsys.(XattrFS); ok {
+		names, err := xfs.ListXattr(ctx, name)
+		return names, intoPathErr("listxattr", name, err)
+	}
+	return nil, intoPathErr("listxattr", name, errors.ErrUnsupported)
+}
+
+// RemoveXattr removes the extended attribute attr of the named file in the
+// provided filesystem.
+// If fsys implements XattrFS, it calls fsys.RemoveXattr.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func RemoveXattr(ctx context.Context, fsys FS, name, attr string) error {
+	if err := internal.CheckXattr("removexattr", name, attr); err != nil {
+		return err
+	}
+	if xfs, ok := fsys.(XattrFS); ok {
+		return intoPathErr("removexattr", name, xfs.RemoveXattr(ctx, name, attr))
+	}
+	return intoPathErr("removexattr", name, errors.ErrUnsupported)
+}
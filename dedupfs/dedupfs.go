@@ -0,0 +1,615 @@
+// Package dedupfs provides a contextual filesystem wrapper that stores file
+// contents by content hash.
+//
+// Every regular file written through the wrapper is kept in the underlying
+// filesystem as a small manifest that names the hash of its contents, while
+// the contents themselves are stored once per distinct hash in a blob area.
+// Identical files therefore share a single blob. Reads resolve manifests
+// transparently, so users of the wrapper see ordinary files. Files in the
+// underlying filesystem that are not manifests are passed through as is.
+//
+// Blobs are never removed implicitly when the last manifest referring to them
+// goes away; call Collect to reclaim them.
+package dedupfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// DefaultBlobDir is the directory of the underlying filesystem where contents
+// are stored unless Config.BlobDir is set.
+const DefaultBlobDir = ".dedup"
+
+// manifestMagic is the first line of every manifest.
+const manifestMagic = "dedupfs-manifest v1\n"
+
+// maxManifestSize bounds the size of a manifest. Larger files are never read
+// to check whether they are manifests.
+const maxManifestSize = 512
+
+// Config specifies the configuration for dedupfs.
+type Config struct {
+	// BlobDir is the directory of the underlying filesystem that holds the
+	// contents. It is hidden from the namespace of the wrapper.
+	// If empty, DefaultBlobDir is used.
+	BlobDir string
+	// Hash creates the hash used to address contents.
+	// If nil, SHA-256 is used.
+	Hash func() hash.Hash
+}
+
+// filesystem is a contextual filesystem that deduplicates file contents.
+type filesystem struct {
+	fsys   contextual.FS
+	config Config
+}
+
+// New creates a new dedupfs storing its namespace and contents in fsys.
+func New(fsys contextual.FS, config Config) contextual.FileSystem {
+	if config.BlobDir == "" {
+		config.BlobDir = DefaultBlobDir
+	}
+	config.BlobDir = path.Clean(config.BlobDir)
+	if config.Hash == nil {
+		config.Hash = sha256.New
+	}
+	return &filesystem{fsys: fsys, config: config}
+}
+
+// Unwrap returns the filesystem the dedupfs is layered on.
+func (f *filesystem) Unwrap() contextual.FS {
+	return f.fsys
+}
+
+// manifest describes the contents of a deduplicated file.
+type manifest struct {
+	digest string
+	size   int64
+}
+
+// encode returns the on-disk form of m.
+func (m manifest) encode() []byte {
+	return fmt.Appendf([]byte(manifestMagic), "%s %d\n", m.digest, m.size)
+}
+
+// parseManifest decodes data, reporting false if it is not a manifest.
+func parseManifest(data []byte) (manifest, bool) {
+	rest, ok := bytes.CutPrefix(data, []byte(manifestMagic))
+	if !ok {
+		return manifest{}, false
+	}
+	digest, size, ok := strings.Cut(strings.TrimSuffix(string(rest), "\n"), " ")
+	if !ok || digest == "" {
+		return manifest{}, false
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return manifest{}, false
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return manifest{}, false
+	}
+	return manifest{digest: digest, size: n}, true
+}
+
+// isBlobPath reports whether name lies in the blob area.
+func (f *filesystem) isBlobPath(name string) bool {
+	return contextual.HasPathPrefix(name, f.config.BlobDir)
+}
+
+// guard rejects names in the blob area, which is not part of the namespace.
+func (f *filesystem) guard(op, name string) error {
+	if f.isBlobPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// blobPath returns the path of the blob with the given digest.
+func (f *filesystem) blobPath(digest string) string {
+	if len(digest) <= 2 {
+		return path.Join(f.config.BlobDir, digest)
+	}
+	return path.Join(f.config.BlobDir, digest[:2], digest[2:])
+}
+
+// store saves data in the blob area unless a blob with the same contents
+// already exists, and returns the manifest describing it.
+func (f *filesystem) store(ctx context.Context, data []byte) (manifest, error) {
+	h := f.config.Hash()
+	h.Write(data)
+	m := manifest{digest: hex.EncodeToString(h.Sum(nil)), size: int64(len(data))}
+
+	blob := f.blobPath(m.digest)
+	if _, err := contextual.Stat(ctx, f.fsys, blob); err == nil {
+		return m, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return manifest{}, err
+	}
+
+	if err := contextual.MkdirAll(ctx, f.fsys, path.Dir(blob), 0755); err != nil {
+		return manifest{}, err
+	}
+	// A concurrent store of the same contents may create the blob first.
+	if err := contextual.CreateFileAtomic(ctx, f.fsys, blob, data, 0444); err != nil && !errors.Is(err, fs.ErrExist) {
+		return manifest{}, err
+	}
+	return m, nil
+}
+
+// save stores data and points the manifest at name to it.
+func (f *filesystem) save(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	m, err := f.store(ctx, data)
+	if err != nil {
+		return err
+	}
+	return contextual.WriteFile(ctx, f.fsys, name, m.encode(), perm)
+}
+
+// load returns the manifest stored at name. It reports false if name is not a
+// regular file holding a manifest.
+func (f *filesystem) load(ctx context.Context, name string, info fs.FileInfo) (manifest, bool, error) {
+	if !info.Mode().IsRegular() || info.Size() > maxManifestSize {
+		return manifest{}, false, nil
+	}
+	data, err := contextual.ReadFile(ctx, f.fsys, name)
+	if err != nil {
+		return manifest{}, false, err
+	}
+	m, ok := parseManifest(data)
+	return m, ok, nil
+}
+
+// resolve stats name and loads its manifest, if it holds one.
+func (f *filesystem) resolve(ctx context.Context, name string) (fs.FileInfo, manifest, bool, error) {
+	info, err := contextual.Stat(ctx, f.fsys, name)
+	if err != nil {
+		return nil, manifest{}, false, err
+	}
+	m, ok, err := f.load(ctx, name, info)
+	if err != nil {
+		return nil, manifest{}, false, err
+	}
+	return info, m, ok, nil
+}
+
+// fileInfo reports the size of the deduplicated contents instead of the size
+// of the manifest.
+type fileInfo struct {
+	contextual.FileInfo
+	size int64
+}
+
+// Size returns the size of the contents.
+func (fi *fileInfo) Size() int64 { return fi.size }
+
+// dirEntry resolves the manifest of the entry when its Info is requested.
+type dirEntry struct {
+	fs.DirEntry
+	ctx  context.Context
+	name string
+	fs   *filesystem
+}
+
+// Info returns the FileInfo of the entry with the size of its contents.
+func (d *dirEntry) Info() (fs.FileInfo, error) {
+	info, err := d.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return d.fs.wrapInfo(d.ctx, d.name, info)
+}
+
+// wrapInfo replaces the size of info if name holds a manifest.
+func (f *filesystem) wrapInfo(ctx context.Context, name string, info fs.FileInfo) (fs.FileInfo, error) {
+	m, ok, err := f.load(ctx, name, info)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return info, nil
+	}
+	return &fileInfo{FileInfo: contextual.ExtendFileInfo(info), size: m.size}, nil
+}
+
+// blobFile is an open blob presented under the name of its manifest. It is
+// exposed through internal.WrapFile, keeping the optional interfaces of the
+// blob.
+type blobFile struct {
+	fsx.File
+	info fs.FileInfo
+}
+
+// Stat returns the FileInfo of the manifest with the size of the contents.
+func (b *blobFile) Stat() (fs.FileInfo, error) {
+	return b.info, nil
+}
+
+// Open opens the named file for reading.
+func (f *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
+	return f.OpenFile(ctx, name, os.O_RDONLY, 0)
+}
+
+// Create creates or truncates the named file.
+func (f *filesystem) Create(ctx context.Context, name string) (fsx.File, error) {
+	return f.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the named file. Files opened for reading read the stored
+// contents directly. Files opened for writing are buffered in memory and
+// their contents are stored when they are closed.
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	if err := internal.CheckOpen(name, flag); err != nil {
+		return nil, err
+	}
+	if err := f.guard("open", name); err != nil {
+		return nil, err
+	}
+
+	if flag&fsx.O_ACCMODE == os.O_RDONLY && flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		info, m, ok, err := f.resolve(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return contextual.OpenFile(ctx, f.fsys, name, flag, mode)
+		}
+		blob, err := contextual.OpenFile(ctx, f.fsys, f.blobPath(m.digest), os.O_RDONLY, 0)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		b := &blobFile{File: blob, info: &fileInfo{FileInfo: contextual.ExtendFileInfo(info), size: m.size}}
+		return internal.WrapFile(b, blob), nil
+	}
+
+	var data []byte
+	_, err := contextual.Stat(ctx, f.fsys, name)
+	switch {
+	case err == nil:
+		if flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+		if flag&os.O_TRUNC == 0 {
+			if data, err = f.ReadFile(ctx, name); err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		// Create the file right away so that it is visible while open.
+		if err := f.save(ctx, name, nil, mode); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	return &writeFile{fs: f, ctx: contextual.IOContext(ctx), name: name, flag: flag, data: data}, nil
+}
+
+// Remove removes the named file or empty directory.
+func (f *filesystem) Remove(ctx context.Context, name string) error {
+	if err := internal.CheckPath("remove", name); err != nil {
+		return err
+	}
+	if err := f.guard("remove", name); err != nil {
+		return err
+	}
+	return contextual.Remove(ctx, f.fsys, name)
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f *filesystem) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := internal.CheckPath("readfile", name); err != nil {
+		return nil, err
+	}
+	if err := f.guard("readfile", name); err != nil {
+		return nil, err
+	}
+	info, m, ok, err := f.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if info.IsDir() {
+			return nil, &fs.PathError{Op: "readfile", Path: name, Err: fsx.ErrIsDir}
+		}
+		return contextual.ReadFile(ctx, f.fsys, name)
+	}
+	data, err := contextual.ReadFile(ctx, f.fsys, f.blobPath(m.digest))
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// WriteFile stores data and points the named file to it.
+func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	if err := internal.CheckPath("writefile", name); err != nil {
+		return err
+	}
+	if err := f.guard("writefile", name); err != nil {
+		return err
+	}
+	return f.save(ctx, name, data, perm)
+}
+
+// Stat returns a FileInfo describing the named file, reporting the size of
+// its contents.
+func (f *filesystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("stat", name); err != nil {
+		return nil, err
+	}
+	if err := f.guard("stat", name); err != nil {
+		return nil, err
+	}
+	info, err := contextual.Stat(ctx, f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapInfo(ctx, name, info)
+}
+
+// Lstat returns a FileInfo describing the named file without following
+// symbolic links, reporting the size of its contents.
+func (f *filesystem) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("lstat", name); err != nil {
+		return nil, err
+	}
+	if err := f.guard("lstat", name); err != nil {
+		return nil, err
+	}
+	info, err := contextual.Lstat(ctx, f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapInfo(ctx, name, info)
+}
+
+// ReadDir reads the named directory, hiding the blob area.
+func (f *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	if err := f.guard("readdir", name); err != nil {
+		return nil, err
+	}
+	entries, err := contextual.ReadDir(ctx, f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	// The entries resolve their manifests when asked, after the call returns.
+	ctx = contextual.Detached.Context(ctx)
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		p := path.Join(name, e.Name())
+		if f.isBlobPath(p) {
+			continue
+		}
+		list = append(list, &dirEntry{DirEntry: e, ctx: ctx, name: p, fs: f})
+	}
+	return list, nil
+}
+
+// Mkdir creates a new directory.
+func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := internal.CheckPath("mkdir", name); err != nil {
+		return err
+	}
+	if err := f.guard("mkdir", name); err != nil {
+		return err
+	}
+	return contextual.Mkdir(ctx, f.fsys, name, perm)
+}
+
+// MkdirAll creates a directory named name, along with any necessary parents.
+func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := internal.CheckPath("mkdir", name); err != nil {
+		return err
+	}
+	if err := f.guard("mkdir", name); err != nil {
+		return err
+	}
+	return contextual.MkdirAll(ctx, f.fsys, name, perm)
+}
+
+// RemoveAll removes name and any children it contains. The blobs of removed
+// files are kept until Collect is called.
+func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
+	if err := internal.CheckPath("removeall", name); err != nil {
+		return err
+	}
+	if name == "." {
+		entries, err := f.ReadDir(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := contextual.RemoveAll(ctx, f.fsys, e.Name()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := f.guard("removeall", name); err != nil {
+		return err
+	}
+	return contextual.RemoveAll(ctx, f.fsys, name)
+}
+
+// Rename renames oldname to newname. Only the manifest is moved.
+func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("rename", oldname, newname); err != nil {
+		return err
+	}
+	if f.isBlobPath(oldname) || f.isBlobPath(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	return contextual.Rename(ctx, f.fsys, oldname, newname)
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("symlink", oldname, newname); err != nil {
+		return err
+	}
+	if err := f.guard("symlink", newname); err != nil {
+		return err
+	}
+	return contextual.Symlink(ctx, f.fsys, oldname, newname)
+}
+
+// ReadLink returns the destination of the named symbolic link.
+func (f *filesystem) ReadLink(ctx context.Context, name string) (string, error) {
+	if err := internal.CheckPath("readlink", name); err != nil {
+		return "", err
+	}
+	if err := f.guard("readlink", name); err != nil {
+		return "", err
+	}
+	return contextual.ReadLink(ctx, f.fsys, name)
+}
+
+// Lchown changes the ownership of the named file without following links.
+func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
+	if err := internal.CheckPath("lchown", name); err != nil {
+		return err
+	}
+	if err := f.guard("lchown", name); err != nil {
+		return err
+	}
+	return contextual.Lchown(ctx, f.fsys, name, owner, group)
+}
+
+// Truncate changes the size of the named file, storing the resized contents.
+func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
+	if err := internal.CheckPath("truncate", name); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := f.ReadFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	if size <= int64(len(data)) {
+		data = data[:size]
+	} else {
+		data = append(data, make([]byte, size-int64(len(data)))...)
+	}
+	return f.save(ctx, name, data, 0)
+}
+
+// Chown changes the ownership of the named file.
+func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
+	if err := internal.CheckPath("chown", name); err != nil {
+		return err
+	}
+	if err := f.guard("chown", name); err != nil {
+		return err
+	}
+	return contextual.Chown(ctx, f.fsys, name, owner, group)
+}
+
+// Chmod changes the mode of the named file.
+func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	if err := internal.CheckPath("chmod", name); err != nil {
+		return err
+	}
+	if err := f.guard("chmod", name); err != nil {
+		return err
+	}
+	return contextual.Chmod(ctx, f.fsys, name, mode)
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (f *filesystem) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	if err := internal.CheckPath("chtimes", name); err != nil {
+		return err
+	}
+	if err := f.guard("chtimes", name); err != nil {
+		return err
+	}
+	return contextual.Chtimes(ctx, f.fsys, name, atime, mtime)
+}
+
+// Collect removes the blobs of fsys, which must have been created by New,
+// that are no longer referenced by any manifest.
+//
+// Collect must not run concurrently with writes through the filesystem:
+// a blob stored by a write whose manifest is not yet in place would be
+// considered unreferenced and removed.
+func Collect(ctx context.Context, fsys contextual.FS) error {
+	f := fsys.(*filesystem)
+	under := contextual.FromContextual(f.fsys, ctx)
+
+	referenced := make(map[string]bool)
+	err := fs.WalkDir(under, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.isBlobPath(name) {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		m, ok, err := f.load(ctx, name, info)
+		if err != nil {
+			return err
+		}
+		if ok {
+			referenced[f.blobPath(m.digest)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	err = fs.WalkDir(under, f.config.BlobDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && name == f.config.BlobDir {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || referenced[name] {
+			return nil
+		}
+		if err := contextual.Remove(ctx, f.fsys, name); err != nil {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	return errors.Join(append(errs, err)...)
+}
+
+// Close closes the underlying filesystem.
+func (f *filesystem) Close() error {
+	return contextual.Close(f.fsys)
+}
+
+var _ contextual.FileSystem = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
@@ -0,0 +1,243 @@
+package dedupfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/dedupfs"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func newBackend(t *testing.T) (string, contextual.FS) {
+	t.Helper()
+	dir := t.TempDir()
+	fsys, err := osfs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, contextual.ToContextual(fsys)
+}
+
+func countBlobs(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(filepath.Join(dir, dedupfs.DefaultBlobDir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDedupFS(t *testing.T) {
+	ctx := t.Context()
+	dir, backend := newBackend(t)
+	fsys := dedupfs.New(backend, dedupfs.Config{})
+
+	if err := fsys.MkdirAll(ctx, "a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("identical artifact")
+	for _, name := range []string{"one", "a/two", "a/b/three"} {
+		if err := fsys.WriteFile(ctx, name, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fsys.WriteFile(ctx, "other", []byte("different"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countBlobs(t, dir); n != 2 {
+		t.Errorf("expected 2 blobs, got %d", n)
+	}
+
+	t.Run("ReadFile", func(t *testing.T) {
+		data, err := fsys.ReadFile(ctx, "a/b/three")
+		if err != nil || string(data) != string(content) {
+			t.Errorf("ReadFile() = %q, %v", data, err)
+		}
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		f, err := fsys.Open(ctx, "a/two")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = f.Close() }()
+		data, err := io.ReadAll(f)
+		if err != nil || string(data) != string(content) {
+			t.Errorf("read %q, %v", data, err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != "two" || info.Size() != int64(len(content)) {
+			t.Errorf("unexpected info %s %d", info.Name(), info.Size())
+		}
+		// The optional interfaces of the blob are kept.
+		ra, ok := f.(io.ReaderAt)
+		if !ok {
+			t.Fatal("file does not implement io.ReaderAt")
+		}
+		buf := make([]byte, 8)
+		if n, err := ra.ReadAt(buf, 10); err != nil || string(buf[:n]) != "artifact" {
+			t.Errorf("ReadAt() = %q, %v", buf[:n], err)
+		}
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		info, err := fsys.Stat(ctx, "one")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(content)) {
+			t.Errorf("expected size %d, got %d", len(content), info.Size())
+		}
+	})
+
+	t.Run("ReadDir hides blobs", func(t *testing.T) {
+		entries, err := fsys.ReadDir(ctx, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Name() == dedupfs.DefaultBlobDir {
+				t.Error("blob area is visible")
+			}
+			if e.Name() == "other" {
+				info, err := e.Info()
+				if err != nil || info.Size() != int64(len("different")) {
+					t.Errorf("unexpected info %v, %v", info, err)
+				}
+			}
+		}
+		if _, err := fsys.Stat(ctx, dedupfs.DefaultBlobDir); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("OpenFile write", func(t *testing.T) {
+		f, err := fsys.OpenFile(ctx, "one", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("!")); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := fsys.ReadFile(ctx, "one")
+		if err != nil || string(data) != string(content)+"!" {
+			t.Errorf("ReadFile() = %q, %v", data, err)
+		}
+		// The other copies are unaffected.
+		data, err = fsys.ReadFile(ctx, "a/two")
+		if err != nil || string(data) != string(content) {
+			t.Errorf("ReadFile() = %q, %v", data, err)
+		}
+	})
+
+	t.Run("Create exclusive", func(t *testing.T) {
+		if _, err := fsys.OpenFile(ctx, "other", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("expected ErrExist, got %v", err)
+		}
+		f, err := fsys.Create(ctx, "new")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fsys.Stat(ctx, "new"); err != nil {
+			t.Errorf("expected file to exist while open: %v", err)
+		}
+		if _, err := f.Write([]byte("different")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if n, err := f.(io.ReaderAt).ReadAt(buf, 2); err != nil || string(buf[:n]) != "ffer" {
+			t.Errorf("ReadAt() = %q, %v", buf[:n], err)
+		}
+		if n, err := f.(io.ReaderAt).ReadAt(buf, 7); err != io.EOF || string(buf[:n]) != "nt" {
+			t.Errorf("ReadAt() at the end = %q, %v; want EOF", buf[:n], err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		if err := fsys.Truncate(ctx, "new", 4); err != nil {
+			t.Fatal(err)
+		}
+		data, err := fsys.ReadFile(ctx, "new")
+		if err != nil || string(data) != "diff" {
+			t.Errorf("ReadFile() = %q, %v", data, err)
+		}
+	})
+
+	t.Run("Collect", func(t *testing.T) {
+		before := countBlobs(t, dir)
+		if err := fsys.Remove(ctx, "other"); err != nil {
+			t.Fatal(err)
+		}
+		if err := fsys.Remove(ctx, "new"); err != nil {
+			t.Fatal(err)
+		}
+		if n := countBlobs(t, dir); n != before {
+			t.Errorf("expected blobs to be kept until Collect, got %d", n)
+		}
+		if err := dedupfs.Collect(ctx, fsys); err != nil {
+			t.Fatal(err)
+		}
+		// Left: the original content (two, three) and the appended one.
+		if n := countBlobs(t, dir); n != 2 {
+			t.Errorf("expected 2 blobs after Collect, got %d", n)
+		}
+		data, err := fsys.ReadFile(ctx, "a/b/three")
+		if err != nil || string(data) != string(content) {
+			t.Errorf("ReadFile() = %q, %v", data, err)
+		}
+	})
+}
+
+func TestDedupFS_PlainFiles(t *testing.T) {
+	ctx := t.Context()
+	dir, backend := newBackend(t)
+	if err := os.WriteFile(filepath.Join(dir, "plain"), []byte("not a manifest"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := dedupfs.New(backend, dedupfs.Config{})
+
+	data, err := fsys.ReadFile(ctx, "plain")
+	if err != nil || string(data) != "not a manifest" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+	info, err := fsys.Stat(ctx, "plain")
+	if err != nil || info.Size() != int64(len("not a manifest")) {
+		t.Errorf("Stat() = %v, %v", info, err)
+	}
+	if err := dedupfs.Collect(ctx, fsys); err != nil {
+		t.Errorf("Collect without blobs failed: %v", err)
+	}
+}
+
+func TestDedupFS_InvalidPaths(t *testing.T) {
+	_, backend := newBackend(t)
+	fsxtest.CheckInvalidPaths(t, dedupfs.New(backend, dedupfs.Config{}))
+}
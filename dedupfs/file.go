@@ -0,0 +1,156 @@
+package dedupfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// writeFile is a file opened for writing. Its contents are buffered in memory
+// and stored in the blob area when it is closed.
+type writeFile struct {
+	fs   *filesystem
+	ctx  context.Context
+	name string
+	flag int
+
+	mu     sync.Mutex
+	data   []byte
+	off    int64
+	closed bool
+}
+
+// Read reads from the buffered contents if the file was opened for reading.
+func (w *writeFile) Read(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, &fs.PathError{Op: "read", Path: w.name, Err: fs.ErrClosed}
+	}
+	if w.flag&fsx.O_ACCMODE == os.O_WRONLY {
+		return 0, &fs.PathError{Op: "read", Path: w.name, Err: fsx.ErrBadFileDescriptor}
+	}
+	if w.off >= int64(len(w.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, w.data[w.off:])
+	w.off += int64(n)
+	return n, nil
+}
+
+// ReadAt reads from the buffered contents at offset off, without moving the
+// offset of the file.
+func (w *writeFile) ReadAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, &fs.PathError{Op: "read", Path: w.name, Err: fs.ErrClosed}
+	}
+	if w.flag&fsx.O_ACCMODE == os.O_WRONLY {
+		return 0, &fs.PathError{Op: "read", Path: w.name, Err: fsx.ErrBadFileDescriptor}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: w.name, Err: fs.ErrInvalid}
+	}
+	if off >= int64(len(w.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, w.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write writes to the buffered contents, at the end of the file if it was
+// opened with os.O_APPEND.
+func (w *writeFile) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: fs.ErrClosed}
+	}
+	if w.flag&fsx.O_ACCMODE == os.O_RDONLY {
+		return 0, &fs.PathError{Op: "write", Path: w.name, Err: fsx.ErrBadFileDescriptor}
+	}
+	if w.flag&os.O_APPEND != 0 {
+		w.off = int64(len(w.data))
+	}
+	if end := w.off + int64(len(p)); end > int64(len(w.data)) {
+		w.data = append(w.data, make([]byte, end-int64(len(w.data)))...)
+	}
+	n := copy(w.data[w.off:], p)
+	w.off += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset for the next Read or Write.
+func (w *writeFile) Seek(offset int64, whence int) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += w.off
+	case io.SeekEnd:
+		offset += int64(len(w.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: w.name, Err: fs.ErrInvalid}
+	}
+	w.off = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the buffered contents.
+func (w *writeFile) Truncate(size int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return &fs.PathError{Op: "truncate", Path: w.name, Err: fs.ErrClosed}
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: w.name, Err: fs.ErrInvalid}
+	}
+	if size <= int64(len(w.data)) {
+		w.data = w.data[:size]
+	} else {
+		w.data = append(w.data, make([]byte, size-int64(len(w.data)))...)
+	}
+	return nil
+}
+
+// Stat returns the FileInfo of the file with the size of the buffered contents.
+func (w *writeFile) Stat() (fs.FileInfo, error) {
+	info, err := contextual.Stat(w.ctx, w.fs.fsys, w.name)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return &fileInfo{FileInfo: contextual.ExtendFileInfo(info), size: int64(len(w.data))}, nil
+}
+
+// Close stores the buffered contents and points the file to them.
+func (w *writeFile) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return &fs.PathError{Op: "close", Path: w.name, Err: fs.ErrClosed}
+	}
+	w.closed = true
+	return w.fs.save(w.ctx, w.name, w.data, 0)
+}
+
+var _ fsx.File = &writeFile{}
+var _ io.ReaderAt = &writeFile{}
@@ -2,6 +2,7 @@ package fsx
 
 import (
 	"errors"
+	"io"
 	"io/fs"
 	"path"
 	"syscall"
@@ -23,6 +24,60 @@ type DirFS interface {
 // ReadDirFile is a file that supports reading directory entries.
 type ReadDirFile = fs.ReadDirFile
 
+// AsReadDirFile returns file as a ReadDirFile if it is a directory that does
+// not implement ReadDirFile itself, listing the directory with readDir, which
+// must return the entries of the directory file was opened from, sorted by
+// name. Other files are returned as they are.
+//
+// It lets filesystems whose Open returns plain files for directories, while
+// they list directories otherwise, such as with a ReadDir method, hand out
+// directory handles that fs.ReadDir and fs.WalkDir can list. Filesystems
+// doing the opposite, listing directories only through their handles, need
+// no adapter, as fs.ReadDir falls back to them.
+func AsReadDirFile(file fs.File, readDir func() ([]fs.DirEntry, error)) fs.File {
+	if _, ok := file.(ReadDirFile); ok || file == nil {
+		return file
+	}
+	if info, err := file.Stat(); err != nil || !info.IsDir() {
+		return file
+	}
+	return &dirFile{File: file, readDir: readDir}
+}
+
+// dirFile is a directory handle listing its entries with readDir.
+type dirFile struct {
+	fs.File
+	readDir func() ([]fs.DirEntry, error)
+
+	// entries are the entries left to return, once read.
+	entries []fs.DirEntry
+	read    bool
+}
+
+// ReadDir returns the next n entries of the directory, like
+// fs.ReadDirFile. The directory is listed on the first call.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.readDir()
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
 // MkdirAllFS is an interface for filesystems that support creating a directory
 // along with any necessary parents (mkdir -p).
 type MkdirAllFS interface {
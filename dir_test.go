@@ -2,8 +2,11 @@ package fsx_test
 
 import (
 	"errors"
+	"io"
 	"io/fs"
+	"slices"
 	"testing"
+	"testing/fstest"
 
 	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/mockfs"
@@ -156,3 +159,67 @@ func TestMkdirAll(t *testing.T) {
 		}
 	})
 }
+
+// plainDirFS is a filesystem opening directories as plain files, while
+// listing them with ReadDir.
+type plainDirFS struct {
+	fstest.MapFS
+}
+
+func (p plainDirFS) Open(name string) (fs.File, error) {
+	f, err := p.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ fs.File }{f}, nil
+}
+
+func TestAsReadDirFile(t *testing.T) {
+	fsys := plainDirFS{fstest.MapFS{
+		"dir/a": &fstest.MapFile{Data: []byte("a")},
+		"dir/b": &fstest.MapFile{Data: []byte("b")},
+		"dir/c": &fstest.MapFile{Data: []byte("c")},
+	}}
+	open := func(name string) fs.File {
+		t.Helper()
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = f.Close() })
+		return fsx.AsReadDirFile(f, func() ([]fs.DirEntry, error) { return fsys.ReadDir(name) })
+	}
+
+	if _, ok := open("dir/a").(fs.ReadDirFile); ok {
+		t.Error("expected a regular file not to be a ReadDirFile")
+	}
+
+	dir, ok := open("dir").(fs.ReadDirFile)
+	if !ok {
+		t.Fatal("expected a directory to be a ReadDirFile")
+	}
+	var names []string
+	for {
+		entries, err := dir.ReadDir(2)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil || len(entries) == 0 || len(entries) > 2 {
+			t.Fatalf("ReadDir(2) = %v, %v", entries, err)
+		}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+	}
+	if !slices.Equal(names, []string{"a", "b", "c"}) {
+		t.Errorf("names = %q; want a, b and c", names)
+	}
+	if entries, err := dir.ReadDir(-1); err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir(-1) at the end = %v, %v; want nothing", entries, err)
+	}
+
+	dir = open("dir").(fs.ReadDirFile)
+	if entries, err := dir.ReadDir(-1); err != nil || len(entries) != 3 {
+		t.Errorf("ReadDir(-1) = %v, %v; want 3 entries", entries, err)
+	}
+}
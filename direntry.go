@@ -0,0 +1,88 @@
+package fsx
+
+import (
+	"cmp"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SortOrder selects how SortDirEntries orders directory entries. Orders
+// combine with |; ByName is the zero value.
+type SortOrder uint
+
+const (
+	// ByName orders entries by name, byte-wise, like fs.ReadDir.
+	ByName SortOrder = 0
+	// ByNameFolded orders entries by name ignoring case. Names that only
+	// differ in case keep their byte-wise order.
+	ByNameFolded SortOrder = 1 << (iota - 1)
+	// DirsFirst lists directories before any other entry.
+	DirsFirst
+	// ByModTime orders entries from the most recently modified one, falling
+	// back to the name order for equal times. Entries whose FileInfo cannot
+	// be read sort as if never modified.
+	ByModTime
+)
+
+// SortDirEntries sorts entries in place according to order. Sorting is
+// stable, so entries comparing equal keep their relative order.
+func SortDirEntries(entries []fs.DirEntry, order SortOrder) {
+	type key struct {
+		entry  fs.DirEntry
+		folded string
+		mtime  time.Time
+	}
+	keys := make([]key, len(entries))
+	for i, e := range entries {
+		keys[i].entry = e
+		if order&ByNameFolded != 0 {
+			keys[i].folded = strings.ToLower(e.Name())
+		}
+		if order&ByModTime != 0 {
+			if info, err := e.Info(); err == nil {
+				keys[i].mtime = info.ModTime()
+			}
+		}
+	}
+
+	slices.SortStableFunc(keys, func(a, b key) int {
+		if order&DirsFirst != 0 && a.entry.IsDir() != b.entry.IsDir() {
+			if a.entry.IsDir() {
+				return -1
+			}
+			return 1
+		}
+		if c := b.mtime.Compare(a.mtime); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.folded, b.folded); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.entry.Name(), b.entry.Name())
+	})
+	for i, k := range keys {
+		entries[i] = k.entry
+	}
+}
+
+// FilterDirEntries returns the entries for which keep reports true, keeping
+// their order. The entries slice is filtered in place, and the elements past
+// the returned slice are cleared.
+func FilterDirEntries(entries []fs.DirEntry, keep func(fs.DirEntry) bool) []fs.DirEntry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if keep(e) {
+			kept = append(kept, e)
+		}
+	}
+	clear(entries[len(kept):])
+	return kept
+}
+
+// Visible reports whether e is not a hidden entry, that is whether its name
+// does not start with a dot. It is meant to be passed to FilterDirEntries.
+func Visible(e fs.DirEntry) bool {
+	return !strings.HasPrefix(e.Name(), ".")
+}
@@ -0,0 +1,78 @@
+package fsx_test
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gwangyi/fsx"
+)
+
+func entryNames(entries []fs.DirEntry) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestSortDirEntries(t *testing.T) {
+	now := time.Now()
+	fsys := fstest.MapFS{
+		"b":        {ModTime: now.Add(-3 * time.Hour)},
+		"A":        {ModTime: now.Add(-time.Hour)},
+		"a":        {ModTime: now.Add(-time.Hour)},
+		"C/file":   {},
+		"d":        {ModTime: now},
+		".hidden":  {ModTime: now.Add(-2 * time.Hour)},
+		"e/nested": {},
+	}
+
+	tests := []struct {
+		name  string
+		order fsx.SortOrder
+		want  []string
+	}{
+		{"by name", fsx.ByName, []string{".hidden", "A", "C", "a", "b", "d", "e"}},
+		{"folded", fsx.ByNameFolded, []string{".hidden", "A", "a", "b", "C", "d", "e"}},
+		{"dirs first", fsx.DirsFirst, []string{"C", "e", ".hidden", "A", "a", "b", "d"}},
+		{"dirs first folded", fsx.DirsFirst | fsx.ByNameFolded, []string{"C", "e", ".hidden", "A", "a", "b", "d"}},
+		{"mod time", fsx.ByModTime | fsx.DirsFirst, []string{"C", "e", "d", "A", "a", ".hidden", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := fs.ReadDir(fsys, ".")
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Reverse(entries)
+			fsx.SortDirEntries(entries, tt.order)
+			if got := entryNames(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("SortDirEntries(%v) = %v, want %v", tt.order, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterDirEntries(t *testing.T) {
+	fsys := fstest.MapFS{".git/config": {}, ".profile": {}, "docs/a": {}, "main.go": {}}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visible := fsx.FilterDirEntries(entries, fsx.Visible)
+	if got, want := entryNames(visible), []string{"docs", "main.go"}; !slices.Equal(got, want) {
+		t.Errorf("FilterDirEntries(Visible) = %v, want %v", got, want)
+	}
+	if entries[len(entries)-1] != nil {
+		t.Error("expected the filtered out tail to be cleared")
+	}
+
+	dirs := fsx.FilterDirEntries(visible, fs.DirEntry.IsDir)
+	if got, want := entryNames(dirs), []string{"docs"}; !slices.Equal(got, want) {
+		t.Errorf("FilterDirEntries(IsDir) = %v, want %v", got, want)
+	}
+}
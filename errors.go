@@ -4,16 +4,25 @@ import (
 	"github.com/gwangyi/fsx/internal"
 )
 
+// The errors below are the values of package syscall, so that the errors of
+// the os package match them with errors.Is. Layers wrap them in an
+// *fs.PathError, and callers test them with the Is helpers below rather than
+// with syscall values.
 var (
 	// ErrBadFileDescriptor is returned when an operation is performed on a file descriptor
 	// that is not open for that operation (e.g., writing to a read-only file).
 	ErrBadFileDescriptor = internal.ErrBadFileDescriptor
 
-	// ErrNotDir is returned when a directory operation is requested on a non-directory file.
+	// ErrNotDir is returned when a directory operation is requested on a non-directory file,
+	// or when a path goes through one.
 	ErrNotDir = internal.ErrNotDir
 
 	// ErrIsDir is returned when a file operation is requested on a directory.
 	ErrIsDir = internal.ErrIsDir
+
+	// ErrReadOnly is returned when a change is requested on a read-only filesystem, or on a
+	// read-only part of it, such as a read-only layer of a union.
+	ErrReadOnly = internal.ErrReadOnly
 )
 
 // IsInvalid checks if the provided error represents an invalid operation or path.
@@ -21,6 +30,21 @@ func IsInvalid(err error) bool {
 	return internal.IsInvalid(err)
 }
 
+// IsNotDir reports whether err, or an error it wraps, is ErrNotDir.
+func IsNotDir(err error) bool {
+	return internal.IsNotDir(err)
+}
+
+// IsIsDir reports whether err, or an error it wraps, is ErrIsDir.
+func IsIsDir(err error) bool {
+	return internal.IsIsDir(err)
+}
+
+// IsReadOnly reports whether err, or an error it wraps, is ErrReadOnly.
+func IsReadOnly(err error) bool {
+	return internal.IsReadOnly(err)
+}
+
 // IsUnsupported checks if the provided error indicates that an operation is not supported.
 func IsUnsupported(err error) bool {
 	return internal.IsUnsupported(err)
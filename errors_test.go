@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
 )
 
 func TestIsInvalid(t *testing.T) {
@@ -112,3 +114,46 @@ func TestIsUnsupported(t *testing.T) {
 		})
 	}
 }
+
+func TestIsHelpers(t *testing.T) {
+	ctx := t.Context()
+	mfs := memfs.New(memfs.Config{})
+	if err := contextual.WriteFile(ctx, mfs, "file", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.Mkdir(ctx, mfs, "dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, notDir := contextual.ReadDir(ctx, mfs, "file")
+	_, isDir := contextual.ReadFile(ctx, mfs, "dir")
+
+	tests := []struct {
+		name                      string
+		err                       error
+		notDir, isDir, isReadOnly bool
+	}{
+		{name: "ErrNotDir", err: fsx.ErrNotDir, notDir: true},
+		{name: "syscall.ENOTDIR", err: syscall.ENOTDIR, notDir: true},
+		{name: "ReadDir of a file", err: notDir, notDir: true},
+		{name: "ErrIsDir", err: fsx.ErrIsDir, isDir: true},
+		{name: "ReadFile of a directory", err: isDir, isDir: true},
+		{name: "ErrReadOnly", err: &fs.PathError{Op: "write", Path: "file", Err: fsx.ErrReadOnly}, isReadOnly: true},
+		{name: "syscall.EROFS", err: syscall.EROFS, isReadOnly: true},
+		{name: "fs.ErrPermission", err: fs.ErrPermission},
+		{name: "nil error", err: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fsx.IsNotDir(tt.err); got != tt.notDir {
+				t.Errorf("IsNotDir(%v) = %v, want %v", tt.err, got, tt.notDir)
+			}
+			if got := fsx.IsIsDir(tt.err); got != tt.isDir {
+				t.Errorf("IsIsDir(%v) = %v, want %v", tt.err, got, tt.isDir)
+			}
+			if got := fsx.IsReadOnly(tt.err); got != tt.isReadOnly {
+				t.Errorf("IsReadOnly(%v) = %v, want %v", tt.err, got, tt.isReadOnly)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package evictfs
+
+import (
+	"context"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// CostEstimator estimates how expensive it is to fetch the named file again
+// once it has been evicted, such as the latency of its origin or of its size
+// class there. fi describes the file as found in the filesystem.
+type CostEstimator func(ctx context.Context, name string, fi contextual.FileInfo) time.Duration
+
+// costKey is the context key under which WithCost stores the cost hint.
+type costKey struct{}
+
+// WithCost returns a copy of ctx carrying cost as the re-fetch cost of the
+// files written with it.
+//
+// WriteFile, Create and OpenFile with os.O_CREATE record the hint for the file
+// they write, in place of the estimate of Config.Cost, until the file is
+// written again with another hint or stops being tracked.
+func WithCost(ctx context.Context, cost time.Duration) context.Context {
+	return context.WithValue(ctx, costKey{}, cost)
+}
+
+// Cost returns the re-fetch cost hint carried by ctx, if any.
+func Cost(ctx context.Context) (time.Duration, bool) {
+	cost, ok := ctx.Value(costKey{}).(time.Duration)
+	return cost, ok
+}
+
+// cost returns the re-fetch cost of the named file that starts being tracked,
+// which is the hint carried by ctx if stored is set, or else the estimate of
+// Config.Cost. Files cost nothing if there is neither.
+func (e *filesystem) cost(ctx context.Context, name string, fi contextual.FileInfo, stored bool) time.Duration {
+	if cost, ok := Cost(ctx); ok && stored {
+		return cost
+	}
+	if e.config.Cost != nil {
+		return e.config.Cost(ctx, name, fi)
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package evictfs_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/evictfs"
+	"github.com/gwangyi/fsx/fsxtest"
+)
+
+func TestFilesystem_Cost(t *testing.T) {
+	ctx := t.Context()
+
+	// writeAll writes a, b and c a minute apart, a with ctxA, so that b is
+	// the file to evict unless a is cheaper to fetch again.
+	writeAll := func(t *testing.T, config evictfs.Config, ctxA context.Context) contextual.FS {
+		t.Helper()
+		clock := fsxtest.NewClock(time.Now())
+		primary := newOSFS(t)
+		config.MaxFiles = 2
+		config.Clock = clock
+		config.TrackAccessTime = true
+		fsys, err := evictfs.New(ctx, primary, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = contextual.Close(fsys) })
+
+		for _, name := range []string{"a", "b", "c"} {
+			wctx := ctx
+			if name == "a" {
+				wctx = ctxA
+			}
+			if err := contextual.WriteFile(wctx, fsys, name, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(time.Minute)
+		}
+		return primary
+	}
+	expectEvicted := func(t *testing.T, primary contextual.FS, evicted string) {
+		t.Helper()
+		waitFor(t, func() bool {
+			_, err := contextual.Stat(ctx, primary, evicted)
+			return os.IsNotExist(err)
+		})
+		for _, name := range []string{"a", "b", "c"} {
+			if _, err := contextual.Stat(ctx, primary, name); name != evicted && err != nil {
+				t.Errorf("expected %s to be kept, got %v", name, err)
+			}
+		}
+	}
+
+	t.Run("Hint", func(t *testing.T) {
+		primary := writeAll(t, evictfs.Config{}, evictfs.WithCost(ctx, time.Hour))
+		expectEvicted(t, primary, "b")
+	})
+
+	t.Run("HintOverridesEstimator", func(t *testing.T) {
+		config := evictfs.Config{
+			Cost: func(_ context.Context, name string, _ contextual.FileInfo) time.Duration {
+				if name == "b" {
+					return time.Hour
+				}
+				return 0
+			},
+		}
+		primary := writeAll(t, config, evictfs.WithCost(ctx, 2*time.Hour))
+		expectEvicted(t, primary, "c")
+	})
+
+	t.Run("Estimator", func(t *testing.T) {
+		config := evictfs.Config{
+			Cost: func(_ context.Context, name string, _ contextual.FileInfo) time.Duration {
+				if name == "a" {
+					return time.Hour
+				}
+				return 0
+			},
+		}
+		primary := writeAll(t, config, ctx)
+		expectEvicted(t, primary, "b")
+	})
+
+	t.Run("CostMetadata", func(t *testing.T) {
+		costs := make(chan time.Duration, 3)
+		config := evictfs.Config{
+			CostMetadata: func(fi contextual.FileInfo, cost time.Duration) evictfs.Metadata {
+				costs <- cost
+				// Evict in name order, whatever the cost.
+				return nameMetadata{fi}
+			},
+		}
+		primary := writeAll(t, config, evictfs.WithCost(ctx, time.Second))
+		expectEvicted(t, primary, "a")
+		close(costs)
+		var got []time.Duration
+		for cost := range costs {
+			got = append(got, cost)
+		}
+		if len(got) != 3 || got[0] != time.Second || got[1] != 0 || got[2] != 0 {
+			t.Errorf("expected costs [1s 0 0], got %v", got)
+		}
+	})
+}
+
+// nameMetadata evicts the files in name order.
+type nameMetadata struct {
+	contextual.FileInfo
+}
+
+func (m nameMetadata) Less(other evictfs.Metadata) bool {
+	return m.Name() < other.(nameMetadata).Name()
+}
+
+func (m nameMetadata) Update(contextual.FileInfo) {}
@@ -0,0 +1,81 @@
+package evictfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// transfer copies the named file from src to dst, creating parent directories
+// in dst as needed, and removes it from src once the copy is complete.
+func transfer(ctx context.Context, src, dst contextual.FS, name string) error {
+	if err := copyFile(ctx, src, dst, name); err != nil {
+		return err
+	}
+	return contextual.Remove(ctx, src, name)
+}
+
+// copyFile copies the named file from src to dst, creating parent directories
+// in dst as needed.
+func copyFile(ctx context.Context, src, dst contextual.FS, name string) error {
+	if parent := path.Dir(name); parent != "." {
+		if err := contextual.MkdirAll(ctx, dst, parent, 0755); err != nil {
+			return err
+		}
+	}
+	_, err := contextual.Transfer(ctx, src, name, dst, name)
+	return err
+}
+
+// evict removes the named file from the primary filesystem. If Config.DemoteTo
+// is set, the file is moved there instead, and kept if it could not be
+// copied there, which evict reports.
+func (e *filesystem) evict(ctx context.Context, name string) (kept bool) {
+	if e.config.DemoteTo != nil {
+		if err := copyFile(ctx, e.Inner, e.config.DemoteTo, name); err != nil {
+			return true
+		}
+	}
+	_ = contextual.Remove(ctx, e.Inner, name)
+	return false
+}
+
+// promote moves the named file back from Config.DemoteTo to the primary
+// filesystem and starts tracking it again.
+// It returns false if there is no demotion tier or the file could not be
+// fetched from it.
+func (e *filesystem) promote(ctx context.Context, name string) bool {
+	if e.config.DemoteTo == nil {
+		return false
+	}
+	if err := transfer(ctx, e.config.DemoteTo, e.Inner, name); err != nil {
+		return false
+	}
+	e.touch(ctx, name)
+	return true
+}
+
+// promoteOnMiss promotes the named file if err reports that the file does not
+// exist in the primary filesystem. It reports whether the caller should retry.
+func (e *filesystem) promoteOnMiss(ctx context.Context, name string, err error) bool {
+	return errors.Is(err, fs.ErrNotExist) && e.promote(ctx, name)
+}
+
+// removeDemoted removes the named file or tree from Config.DemoteTo so that
+// it cannot be fetched back after being removed through the filesystem.
+// It reports whether anything was removed.
+func (e *filesystem) removeDemoted(ctx context.Context, name string, all bool) bool {
+	if e.config.DemoteTo == nil {
+		return false
+	}
+	if _, err := contextual.Lstat(ctx, e.config.DemoteTo, name); err != nil {
+		return false
+	}
+	if all {
+		return contextual.RemoveAll(ctx, e.config.DemoteTo, name) == nil
+	}
+	return contextual.Remove(ctx, e.config.DemoteTo, name) == nil
+}
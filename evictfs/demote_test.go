@@ -0,0 +1,126 @@
+package evictfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/evictfs"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func newOSFS(t *testing.T) contextual.FS {
+	t.Helper()
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contextual.ToContextual(fsys)
+}
+
+// waitFor polls cond until it returns true or the test deadline approaches.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFilesystem_DemoteTo(t *testing.T) {
+	ctx := t.Context()
+	primary := newOSFS(t)
+	slow := newOSFS(t)
+
+	fsys, err := evictfs.New(ctx, primary, evictfs.Config{
+		MaxFiles: 1,
+		DemoteTo: slow,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := contextual.MkdirAll(ctx, fsys, "dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.WriteFile(ctx, fsys, "dir/file1", []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.WriteFile(ctx, fsys, "file2", []byte("two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(fsys contextual.FS, name string) bool {
+		_, err := contextual.Stat(ctx, fsys, name)
+		return err == nil
+	}
+
+	// One of the files is demoted to the slow tier.
+	waitFor(t, func() bool { return exists(slow, "dir/file1") || exists(slow, "file2") })
+	demoted := "dir/file1"
+	if exists(slow, "file2") {
+		demoted = "file2"
+	}
+	if exists(primary, demoted) {
+		t.Errorf("expected %s to be removed from the primary filesystem", demoted)
+	}
+
+	// Accessing the demoted file fetches it back.
+	data, err := contextual.ReadFile(ctx, fsys, demoted)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed: %v", demoted, err)
+	}
+	if want := map[string]string{"dir/file1": "one", "file2": "two"}[demoted]; string(data) != want {
+		t.Errorf("ReadFile(%s) = %q, want %q", demoted, data, want)
+	}
+	if exists(slow, demoted) {
+		t.Errorf("expected %s to be removed from the slow tier after promotion", demoted)
+	}
+
+	// Removing a file removes the demoted copy as well.
+	waitFor(t, func() bool { return exists(slow, "dir/file1") || exists(slow, "file2") })
+	for _, name := range []string{"dir/file1", "file2"} {
+		if err := contextual.Remove(ctx, fsys, name); err != nil {
+			t.Errorf("Remove(%s) failed: %v", name, err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to be gone, got %v", name, err)
+		}
+	}
+}
+
+func TestFilesystem_DemotionFailure(t *testing.T) {
+	ctx := t.Context()
+	primary := newOSFS(t)
+	if err := contextual.WriteFile(ctx, primary, "f0", []byte("f0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	slow := fsxtest.NewCountingFS(fsxtest.NewErrorFS(newOSFS(t), map[fsxtest.Fault]error{{Op: "open"}: fs.ErrPermission}))
+	fsys, err := evictfs.New(ctx, primary, evictfs.Config{
+		MaxFiles:        1,
+		DemoteTo:        slow,
+		Clock:           fsxtest.NewClock(time.Now().Add(time.Hour)),
+		TrackAccessTime: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+
+	// f0 is evicted, but cannot be demoted: it is kept and tracked again.
+	if err := contextual.WriteFile(ctx, fsys, "f1", []byte("f1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		return slow.Count("open") > 0 && evictfs.PendingEvictions(fsys).Files == 1
+	})
+	if data, err := contextual.ReadFile(ctx, primary, "f0"); err != nil || string(data) != "f0" {
+		t.Errorf("ReadFile(f0) = %q, %v; want f0 kept", data, err)
+	}
+}
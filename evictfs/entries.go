@@ -0,0 +1,67 @@
+package evictfs
+
+import (
+	"cmp"
+	"context"
+	"iter"
+	"slices"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// EntryInfo describes a file tracked by an evictfs, as reported by Entries.
+type EntryInfo struct {
+	// Name is the path of the file.
+	Name string
+	// Size is the size of the file as tracked by its Metadata.
+	Size int64
+	// AccessTime is the time the file was last accessed, as tracked by its
+	// Metadata.
+	AccessTime time.Time
+	// Cost is the re-fetch cost the Metadata of the file was created with.
+	Cost time.Duration
+	// Rank is the position of the file in the eviction order of the policy:
+	// 0 for the file evicted first, 1 for the next one, and so on.
+	Rank int
+}
+
+// Entries returns the files tracked by fsys, an evictfs made by New, in the
+// order the policy would evict them, so that operators can tell what a cache
+// holds and why, and tests can check what it tracks. The files are those
+// tracked when Entries is called: later changes are not reflected. Files
+// the policy ranks equally are ordered by name. The iteration stops once
+// ctx is done.
+func Entries(ctx context.Context, fsys contextual.FS) iter.Seq[EntryInfo] {
+	e := fsys.(*filesystem)
+	e.mu.Lock()
+	items := slices.Clone(e.pq.items)
+	entries := make([]EntryInfo, len(items))
+	slices.SortFunc(items, func(a, b *item) int {
+		switch {
+		case a.metadata.Less(b.metadata):
+			return -1
+		case b.metadata.Less(a.metadata):
+			return 1
+		}
+		return cmp.Compare(a.name, b.name)
+	})
+	for i, it := range items {
+		entries[i] = EntryInfo{
+			Name:       it.name,
+			Size:       it.metadata.Size(),
+			AccessTime: it.metadata.AccessTime(),
+			Cost:       it.cost,
+			Rank:       i,
+		}
+	}
+	e.mu.Unlock()
+
+	return func(yield func(EntryInfo) bool) {
+		for _, entry := range entries {
+			if ctx.Err() != nil || !yield(entry) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package evictfs_test
+
+import (
+	"context"
+	"path"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/evictfs"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+func TestEntries(t *testing.T) {
+	ctx := t.Context()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := fsxtest.NewClock(start)
+	fsys, err := evictfs.New(ctx, memfs.New(memfs.Config{Clock: clock}), evictfs.Config{
+		Clock:           clock,
+		TrackAccessTime: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+
+	for _, name := range []string{"a", "b", "c"} {
+		clock.Advance(time.Minute)
+		if err := contextual.WriteFile(evictfs.WithCost(ctx, time.Second), fsys, name, []byte(name+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Minute)
+	if _, err := contextual.ReadFile(ctx, fsys, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := slices.Collect(evictfs.Entries(ctx, fsys))
+	var names []string
+	for i, e := range entries {
+		names = append(names, e.Name)
+		if e.Rank != i || e.Size != 2 || e.Cost != time.Second {
+			t.Errorf("unexpected entry %+v", e)
+		}
+	}
+	if want := []string{"b", "c", "a"}; !slices.Equal(names, want) {
+		t.Errorf("Entries() = %v; want %v", names, want)
+	}
+	if len(entries) == 3 && !entries[2].AccessTime.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("AccessTime of a = %v; want %v", entries[2].AccessTime, start.Add(4*time.Minute))
+	}
+
+	// The snapshot does not follow later changes.
+	seq := evictfs.Entries(ctx, fsys)
+	if err := contextual.Remove(ctx, fsys, "b"); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range seq {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("expected the snapshot to hold 3 entries, got %d", n)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	for e := range evictfs.Entries(canceled, fsys) {
+		t.Errorf("unexpected entry %+v after cancellation", e)
+	}
+}
+
+func TestEntries_RemoveAll(t *testing.T) {
+	ctx := t.Context()
+	fsys, err := evictfs.New(ctx, memfs.New(memfs.Config{}), evictfs.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+
+	for _, name := range []string{"dir/a", "dir2/b", "c"} {
+		if err := contextual.MkdirAll(ctx, fsys, path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, fsys, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func() []string {
+		var names []string
+		for e := range evictfs.Entries(ctx, fsys) {
+			names = append(names, e.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	// Only the files beneath dir are untracked, not those of dir2.
+	if err := contextual.RemoveAll(ctx, fsys, "dir"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(), []string{"c", "dir2/b"}; !slices.Equal(got, want) {
+		t.Errorf("Entries() after RemoveAll(dir) = %v; want %v", got, want)
+	}
+
+	// Removing the root untracks everything.
+	if err := contextual.RemoveAll(ctx, fsys, "."); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); len(got) != 0 {
+		t.Errorf("Entries() after RemoveAll(.) = %v; want none", got)
+	}
+}
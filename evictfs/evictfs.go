@@ -1,17 +1,24 @@
 // Package evictfs provides a contextual filesystem wrapper that automatically evicts files
 // based on configurable limits such as maximum file count or total size.
+// Simulate replays a trace of accesses against the same policies, to size
+// the limits before deploying. Evictions can be batched and paced, and
+// PendingEvictions reports the backlog they leave. Entries lists the tracked
+// files in the order they would be evicted.
 package evictfs
 
 import (
 	"container/heap"
 	"context"
+	"errors"
+	"io"
 	"io/fs"
 	"os"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
 )
 
 // Metadata represents the eviction-related metadata for a file.
@@ -45,13 +52,91 @@ type Config struct {
 	// for a file when it is first discovered or created.
 	// If nil, it defaults to an LRU policy.
 	Metadata func(fi contextual.FileInfo) Metadata
+
+	// CostMetadata is like Metadata, but is also given the cost of fetching
+	// the file again once evicted, so that the policy can prefer evicting
+	// files that are cheap to re-fetch. It takes precedence over Metadata.
+	// If both are nil, the default LRU policy takes a file that costs more
+	// as accessed that much later.
+	CostMetadata func(fi contextual.FileInfo, cost time.Duration) Metadata
+
+	// Cost, if set, estimates the re-fetch cost of the files that start
+	// being tracked and have no cost hint from WithCost.
+	Cost CostEstimator
+
+	// DemoteTo is an optional slower or cheaper filesystem that evicted files
+	// are moved to instead of being deleted. A file that could not be copied
+	// there stays in the primary filesystem, and ends the eviction pass.
+	// When a file is missing from the primary filesystem on access, it is
+	// fetched back from DemoteTo and tracked again. Files in DemoteTo are not
+	// listed by ReadDir and do not count towards the limits.
+	DemoteTo contextual.FS
+
+	// LowWatermark is the total size, in bytes, that an eviction pass frees
+	// down to. MaxSize acts as the high watermark: exceeding it starts a
+	// pass, which then evicts files until the total size is at most
+	// LowWatermark, so that writes hovering near MaxSize do not trigger an
+	// eviction each. If 0 or not less than MaxSize, passes stop at MaxSize.
+	LowWatermark int64
+
+	// OnEvict, if set, is called by the background eviction loop after each
+	// pass that evicted files.
+	OnEvict func(Pass)
+
+	// EvictRate, if positive, is the maximum number of files evicted per
+	// second, so that a pass evicting many files does not saturate a network
+	// backend right when it is busiest: the batches of the pass are spread
+	// over time. Backlog reports the files still waiting.
+	EvictRate float64
+	// EvictBatch is the number of files a pass evicts at once, removed with
+	// a single contextual.RemoveMany call, which backends implementing
+	// contextual.RemoveManyFS serve with a request per batch. Values less
+	// than 2 evict files one at a time.
+	EvictBatch int
+	// EvictPause is the time a pass waits between two batches, on top of
+	// the wait EvictRate imposes, if any.
+	EvictPause time.Duration
+
+	// Clock is the source of time used for MaxAge and to time eviction
+	// passes. If nil, contextual.RealClock is used.
+	Clock contextual.Clock
+
+	// TrackAccessTime makes evictfs record when files are accessed through
+	// it, according to Clock, and report that time as the AccessTime of the
+	// FileInfo given to Metadata instead of the one of the backend. It suits
+	// backends that do not maintain access times, such as object stores or
+	// mounts with noatime, where LRU and MaxAge would otherwise see a frozen
+	// atime. Files found by New are taken as last accessed at their
+	// modification time.
+	TrackAccessTime bool
+
+	// Lifetime selects the context of the background eviction loop, derived
+	// from the context given to New. contextual.Detached, the default, keeps
+	// its values, such as a contextual.Resolver, and runs the loop until
+	// Close, which also cancels the eviction in progress. contextual.Bound
+	// also stops the loop, and cancels the eviction in progress, once that
+	// context is done; files are then no longer evicted, as after Close.
+	Lifetime contextual.Lifetime
+}
+
+// Pass describes an eviction pass.
+type Pass struct {
+	// Files is the number of files evicted.
+	Files int
+	// Bytes is the total size of the evicted files.
+	Bytes int64
+	// Batches is the number of batches the files were evicted in.
+	Batches int
+	// Duration is how long the pass took.
+	Duration time.Duration
 }
 
 // filesystem is a contextual filesystem that evicts files based on a threshold.
-// It tracks file metadata in memory to determine which files should be removed
-// when limits are reached.
+// It tracks the metadata of the files of the embedded PassthroughFS in memory,
+// overriding the methods that create, use or remove them, to determine which
+// files should be removed when limits are reached.
 type filesystem struct {
-	fsys   contextual.FS
+	contextual.PassthroughFS
 	config Config
 
 	mu sync.Mutex
@@ -61,38 +146,76 @@ type filesystem struct {
 	files       map[string]*item
 	pq          *priorityQueue
 	currentSize int64
+	// passing is set while an eviction pass has started evicting files, and
+	// frees down to the low watermark.
+	passing bool
 
 	evictSignal chan struct{}
+	// stop is called by Close to end the lifetime of the eviction loop,
+	// stopping evictLoop, which closes stopped when it returns.
+	stop      context.CancelFunc
+	stopped   chan struct{}
+	closeOnce sync.Once
 }
 
 // New creates a new evictfs instance wrapping the provided fsys.
 // It initializes the internal state by walking the existing files in fsys.
 func New(ctx context.Context, fsys contextual.FS, config Config) (contextual.FS, error) {
-	if config.Metadata == nil {
-		// Default to LRU if no priority function is provided.
-		config.Metadata = newLRU
+	e := newFilesystem(fsys, config)
+	if err := e.init(ctx); err != nil {
+		return nil, err
 	}
 
-	e := &filesystem{
-		fsys:        fsys,
-		config:      config,
-		files:       make(map[string]*item),
-		pq:          &priorityQueue{},
-		evictSignal: make(chan struct{}, 1),
+	// The eviction loop keeps the values of ctx, but lasts until Close, or
+	// until ctx is done if it is bound to it.
+	lifetime := context.Background()
+	if config.Lifetime == contextual.Bound {
+		lifetime = ctx
 	}
+	lifetime, e.stop = context.WithCancel(lifetime)
+	go e.evictLoop(contextual.Detach(ctx, lifetime))
 
-	if err := e.init(ctx); err != nil {
-		return nil, err
+	return e, nil
+}
+
+// newFilesystem returns an evictfs tracking no file yet, with the defaults of
+// config applied.
+func newFilesystem(fsys contextual.FS, config Config) *filesystem {
+	if config.CostMetadata == nil {
+		if metadata := config.Metadata; metadata != nil {
+			config.CostMetadata = func(fi contextual.FileInfo, _ time.Duration) Metadata {
+				return metadata(fi)
+			}
+		} else {
+			// Default to LRU if no priority function is provided.
+			config.CostMetadata = newLRU
+		}
 	}
 
-	go e.evictLoop()
+	return &filesystem{
+		PassthroughFS: contextual.PassthroughFS{Inner: fsys},
+		config:        config,
+		files:         make(map[string]*item),
+		pq:            &priorityQueue{},
+		evictSignal:   make(chan struct{}, 1),
+		stopped:       make(chan struct{}),
+	}
+}
 
-	return e, nil
+// Unwrap returns the filesystem the evictfs is layered on.
+func (f *filesystem) Unwrap() contextual.FS {
+	return f.Inner
 }
 
 // init scans the entire filesystem to build the initial priority queue and size tracking.
+// Backends implementing contextual.ReadDirInfoFS return the FileInfo of the
+// entries with the listings, and are not stat'ed file by file.
 func (e *filesystem) init(ctx context.Context) error {
-	fsys := contextual.FromContextual(e.fsys, ctx)
+	if _, ok := e.Inner.(contextual.ReadDirInfoFS); ok {
+		return e.scan(ctx, ".")
+	}
+
+	fsys := contextual.FromContextual(e.Inner, ctx)
 	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -105,18 +228,50 @@ func (e *filesystem) init(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-		extInfo := contextual.ExtendFileInfo(info)
-		e.mu.Lock()
-		e.addFileLocked(name, e.config.Metadata(extInfo))
-		e.mu.Unlock()
+		e.track(ctx, name, info)
 		return nil
 	})
 }
 
+// scan tracks the files in dir and its subdirectories, listed with
+// contextual.ReadDirInfos.
+func (e *filesystem) scan(ctx context.Context, dir string) error {
+	entries, err := contextual.ReadDirInfos(ctx, e.Inner, dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name, err := contextual.JoinValid(dir, entry.Name())
+		if err != nil {
+			// A name that cannot be opened through the layer is not tracked.
+			continue
+		}
+		if !entry.IsDir() {
+			e.track(ctx, name, entry.FileInfo)
+		} else if err := e.scan(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// track adds the file name described by info found by init.
+func (e *filesystem) track(ctx context.Context, name string, info fs.FileInfo) {
+	extInfo := contextual.ExtendFileInfo(info)
+	if e.config.TrackAccessTime {
+		extInfo = e.accessInfo(extInfo, extInfo.ModTime())
+	}
+	cost := e.cost(ctx, name, extInfo, false)
+	e.mu.Lock()
+	e.addFileLocked(name, extInfo, cost)
+	e.mu.Unlock()
+}
+
 // addFileLocked adds a file to the internal tracking state.
 // It must be called with e.mu held.
-func (e *filesystem) addFileLocked(name string, metadata Metadata) {
-	it := &item{name: name, metadata: metadata}
+func (e *filesystem) addFileLocked(name string, info contextual.FileInfo, cost time.Duration) {
+	metadata := e.config.CostMetadata(info, cost)
+	it := &item{name: name, metadata: metadata, cost: cost}
 	e.files[name] = it
 	heap.Push(e.pq, it)
 	e.currentSize += metadata.Size()
@@ -134,10 +289,21 @@ func (e *filesystem) removeFileLocked(it *item) {
 // If the file was not previously tracked, it is added.
 // This method also triggers eviction if limits are exceeded.
 func (e *filesystem) touch(ctx context.Context, name string) {
+	e.update(ctx, name, false)
+}
+
+// store is touch for a file whose contents were written by WriteFile or a
+// creating open, which records the cost hint carried by ctx.
+func (e *filesystem) store(ctx context.Context, name string) {
+	e.update(ctx, name, true)
+}
+
+// update implements touch and store.
+func (e *filesystem) update(ctx context.Context, name string, stored bool) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	info, err := contextual.Stat(ctx, e.fsys, name)
+	info, err := contextual.Stat(ctx, e.Inner, name)
 	if err != nil {
 		// If the file no longer exists or can't be stated, stop tracking it.
 		if it, ok := e.files[name]; ok {
@@ -149,54 +315,172 @@ func (e *filesystem) touch(ctx context.Context, name string) {
 	if info.IsDir() {
 		return
 	}
+	e.refreshLocked(ctx, name, e.accessInfo(info, contextual.ClockOr(e.config.Clock).Now()), stored)
+
+	select {
+	case e.evictSignal <- struct{}{}:
+	default:
+	}
+}
 
-	if it, ok := e.files[name]; ok {
+// refreshLocked updates the metadata of the file name, described by info,
+// because it was accessed or modified, or starts tracking it.
+// It must be called with e.mu held.
+func (e *filesystem) refreshLocked(ctx context.Context, name string, info contextual.FileInfo, stored bool) {
+	cost, hinted := Cost(ctx)
+	if it, ok := e.files[name]; ok && !(stored && hinted && cost != it.cost) {
 		// Update existing item.
 		e.currentSize -= it.metadata.Size()
 		it.metadata.Update(info)
 		e.currentSize += it.metadata.Size()
 		heap.Fix(e.pq, it.index)
 	} else {
-		// Add new item.
-		md := e.config.Metadata(info)
-		e.addFileLocked(name, md)
+		// Add new item, or replace the one whose cost changed.
+		if ok {
+			e.removeFileLocked(it)
+		}
+		e.addFileLocked(name, info, e.cost(ctx, name, info, stored))
 	}
+}
 
-	select {
-	case e.evictSignal <- struct{}{}:
-	default:
+// evictLoop runs in the background and processes eviction signals until
+// ctx, which Close cancels, is done.
+func (e *filesystem) evictLoop(ctx context.Context) {
+	defer close(e.stopped)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.evictSignal:
+		}
+		e.evictPass(ctx)
 	}
 }
 
-// evictLoop runs in the background and processes eviction signals.
-func (e *filesystem) evictLoop() {
-	ctx := context.Background()
-	for range e.evictSignal {
-		for {
-			var name string
-			var metadata Metadata
-
-			e.mu.Lock()
-			if (e.config.MaxFiles > 0 && len(e.files) > e.config.MaxFiles) ||
-				(e.config.MaxSize > 0 && e.currentSize > e.config.MaxSize) {
-				// We expect the PQ to never be empty here because the loop condition
-				// is based on tracked files.
-				it := heap.Pop(e.pq).(*item)
-				delete(e.files, it.name)
-				e.currentSize -= it.metadata.Size()
-				name = it.name
-				metadata = it.metadata
-			}
-			e.mu.Unlock()
+// evictPass evicts files if the limits are exceeded, until the filesystem is
+// back under its low watermark, in batches paced as configured, and reports
+// the pass to Config.OnEvict.
+func (e *filesystem) evictPass(ctx context.Context) {
+	var pass Pass
+	clock := contextual.ClockOr(e.config.Clock)
+	start := clock.Now()
+	defer func() {
+		e.mu.Lock()
+		e.passing = false
+		e.mu.Unlock()
+		if pass.Files > 0 && e.config.OnEvict != nil {
+			pass.Duration = clock.Now().Sub(start)
+			e.config.OnEvict(pass)
+		}
+	}()
+
+	batch := max(e.config.EvictBatch, 1)
+	for {
+		if ctx.Err() != nil {
+			return
+		}
 
-			if name == "" {
+		e.mu.Lock()
+		var names []string
+		victims := make(map[string]*item)
+		for len(names) < batch {
+			it := e.popVictimLocked(&pass)
+			if it == nil {
 				break
 			}
+			names = append(names, it.name)
+			victims[it.name] = it
+		}
+		e.passing = pass.Files > 0
+		e.mu.Unlock()
 
-			_ = contextual.Remove(ctx, e.fsys, name)
-			_ = metadata // metadata is popped, but we could use it if needed
+		if len(names) == 0 {
+			return
 		}
+		batchStart := clock.Now()
+		kept := e.evictBatch(ctx, names)
+		pass.Batches++
+
+		e.mu.Lock()
+		for _, name := range kept {
+			e.keepLocked(ctx, victims[name], &pass)
+		}
+		// The pass ends when a file could not be demoted, rather than
+		// evicting the others in its place; the next access starts another.
+		more := len(kept) == 0 && e.evictingLocked(&pass)
+		e.mu.Unlock()
+		if !more || !e.pace(ctx, clock, batchStart, len(names)) {
+			return
+		}
+	}
+}
+
+// popVictimLocked stops tracking the next file the eviction pass evicts and
+// returns it, adding it to pass, or returns nil if the pass is over.
+// It must be called with e.mu held.
+func (e *filesystem) popVictimLocked(pass *Pass) *item {
+	if !e.evictingLocked(pass) {
+		return nil
 	}
+	// We expect the PQ to never be empty here because the loop condition
+	// is based on tracked files.
+	it := heap.Pop(e.pq).(*item)
+	delete(e.files, it.name)
+	e.currentSize -= it.metadata.Size()
+	pass.Files++
+	pass.Bytes += it.metadata.Size()
+	return it
+}
+
+// keepLocked tracks the file of it again, taking it out of pass, after the
+// pass that popped it could not demote it, unless the file was tracked again
+// or removed meanwhile.
+// It must be called with e.mu held.
+func (e *filesystem) keepLocked(ctx context.Context, it *item, pass *Pass) {
+	pass.Files--
+	pass.Bytes -= it.metadata.Size()
+	if _, ok := e.files[it.name]; ok {
+		return
+	}
+	if _, err := contextual.Lstat(ctx, e.Inner, it.name); err != nil {
+		return
+	}
+	e.files[it.name] = it
+	heap.Push(e.pq, it)
+	e.currentSize += it.metadata.Size()
+}
+
+// evictingLocked reports whether the eviction pass must evict another file.
+// It must be called with e.mu held.
+func (e *filesystem) evictingLocked(pass *Pass) bool {
+	return pass.Files > 0 && e.aboveLowLocked() || e.overLimitLocked()
+}
+
+// overLimitLocked reports whether the tracked files exceed MaxFiles or
+// MaxSize, which starts an eviction pass.
+// It must be called with e.mu held.
+func (e *filesystem) overLimitLocked() bool {
+	return (e.config.MaxFiles > 0 && len(e.files) > e.config.MaxFiles) ||
+		(e.config.MaxSize > 0 && e.currentSize > e.config.MaxSize)
+}
+
+// aboveLowLocked reports whether a running eviction pass must go on: the
+// tracked files exceed MaxFiles, or their total size exceeds the low
+// watermark.
+// It must be called with e.mu held.
+func (e *filesystem) aboveLowLocked() bool {
+	low := e.lowWatermark()
+	return (e.config.MaxFiles > 0 && len(e.files) > e.config.MaxFiles) ||
+		(low > 0 && e.currentSize > low)
+}
+
+// lowWatermark returns the total size an eviction pass frees down to.
+func (e *filesystem) lowWatermark() int64 {
+	low := e.config.MaxSize
+	if e.config.LowWatermark > 0 && e.config.LowWatermark < low {
+		low = e.config.LowWatermark
+	}
+	return low
 }
 
 // checkExpired checks if a file is expired and deletes it if it is.
@@ -205,22 +489,35 @@ func (e *filesystem) checkExpired(ctx context.Context, name string) error {
 		return nil
 	}
 	e.mu.Lock()
-	it, ok := e.files[name]
-	if !ok || time.Since(it.metadata.AccessTime()) <= e.config.MaxAge {
-		e.mu.Unlock()
+	expired := e.expireLocked(name, contextual.ClockOr(e.config.Clock).Now())
+	e.mu.Unlock()
+	if !expired {
 		return nil
 	}
-	e.removeFileLocked(it)
-	e.mu.Unlock()
-	_ = contextual.Remove(ctx, e.fsys, name)
+	_ = contextual.Remove(ctx, e.Inner, name)
 	return fs.ErrNotExist
 }
 
+// expireLocked stops tracking the file name if it was last accessed more than
+// MaxAge before now, and reports whether it did.
+// It must be called with e.mu held.
+func (e *filesystem) expireLocked(name string, now time.Time) bool {
+	it, ok := e.files[name]
+	if !ok || now.Sub(it.metadata.AccessTime()) <= e.config.MaxAge {
+		return false
+	}
+	e.removeFileLocked(it)
+	return true
+}
+
 // Open opens the named file for reading.
 func (e *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("open", name); err != nil {
 		return nil, err
 	}
+	if err := e.checkExpired(ctx, name); err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
 	return e.OpenFile(ctx, name, os.O_RDONLY, 0)
 }
 
@@ -231,24 +528,43 @@ func (e *filesystem) Create(ctx context.Context, name string) (contextual.File,
 
 // OpenFile is the generalized open call.
 func (e *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (contextual.File, error) {
+	if err := internal.CheckOpen(name, flag); err != nil {
+		return nil, err
+	}
 	// If O_CREATE is not set, we should check expiration.
 	// If O_CREATE is set, it might be an access to existing file or creating a new one.
 	if flag&os.O_CREATE == 0 {
 		if err := e.checkExpired(ctx, name); err != nil {
-			return nil, err
+			return nil, internal.Decorate("open", name, err)
 		}
 	}
-	f, err := contextual.OpenFile(ctx, e.fsys, name, flag, mode)
+	f, err := contextual.OpenFile(ctx, e.Inner, name, flag, mode)
+	if flag&os.O_TRUNC == 0 && e.promoteOnMiss(ctx, name, err) {
+		f, err = contextual.OpenFile(ctx, e.Inner, name, flag, mode)
+	} else if err == nil && flag&os.O_CREATE != 0 {
+		// A newly created file shadows any demoted copy.
+		e.removeDemoted(ctx, name, false)
+	}
 	if err != nil {
-		return nil, err
+		return nil, internal.Decorate("open", name, err)
 	}
-	e.touch(ctx, name)
-	return &evictFile{File: f, fs: e, name: name}, nil
+	if flag&os.O_CREATE != 0 {
+		e.store(ctx, name)
+	} else {
+		e.touch(ctx, name)
+	}
+	return internal.WrapFile(&evictFile{File: f, fs: e, ctx: contextual.IOContext(ctx), name: name}, f), nil
 }
 
 // Remove removes the named file or (empty) directory.
 func (e *filesystem) Remove(ctx context.Context, name string) error {
-	err := contextual.Remove(ctx, e.fsys, name)
+	if err := internal.CheckPath("remove", name); err != nil {
+		return err
+	}
+	err := contextual.Remove(ctx, e.Inner, name)
+	if e.removeDemoted(ctx, name, false) && errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
 	if err == nil {
 		e.mu.Lock()
 		if it, ok := e.files[name]; ok {
@@ -256,179 +572,264 @@ func (e *filesystem) Remove(ctx context.Context, name string) error {
 		}
 		e.mu.Unlock()
 	}
-	return err
+	return internal.Decorate("remove", name, err)
 }
 
 // ReadFile reads the named file and returns its contents.
 func (e *filesystem) ReadFile(ctx context.Context, name string) ([]byte, error) {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("readfile", name); err != nil {
 		return nil, err
 	}
-	data, err := contextual.ReadFile(ctx, e.fsys, name)
+	if err := e.checkExpired(ctx, name); err != nil {
+		return nil, internal.Decorate("readfile", name, err)
+	}
+	data, err := contextual.ReadFile(ctx, e.Inner, name)
+	if e.promoteOnMiss(ctx, name, err) {
+		data, err = contextual.ReadFile(ctx, e.Inner, name)
+	}
 	if err == nil {
 		e.touch(ctx, name)
 	}
-	return data, err
+	return data, internal.Decorate("readfile", name, err)
 }
 
 // Stat returns a FileInfo describing the named file.
 func (e *filesystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("stat", name); err != nil {
 		return nil, err
 	}
-	fi, err := contextual.Stat(ctx, e.fsys, name)
+	if err := e.checkExpired(ctx, name); err != nil {
+		return nil, internal.Decorate("stat", name, err)
+	}
+	fi, err := contextual.Stat(ctx, e.Inner, name)
+	if e.promoteOnMiss(ctx, name, err) {
+		fi, err = contextual.Stat(ctx, e.Inner, name)
+	}
 	if err == nil {
 		e.touch(ctx, name)
 	}
-	return fi, err
-}
-
-// ReadDir reads the named directory and returns a list of directory entries.
-func (e *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
-	return contextual.ReadDir(ctx, e.fsys, name)
-}
-
-// Mkdir creates a new directory.
-func (e *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
-	return contextual.Mkdir(ctx, e.fsys, name, perm)
+	return fi, internal.Decorate("stat", name, err)
 }
 
-// MkdirAll creates a directory and all necessary parents.
-func (e *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
-	return contextual.MkdirAll(ctx, e.fsys, name, perm)
+// ReadDirInfos reads the named directory and returns its entries with their
+// FileInfo.
+func (e *filesystem) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	entries, err := contextual.ReadDirInfos(ctx, e.Inner, name)
+	return entries, internal.Decorate("readdir", name, err)
 }
 
 // RemoveAll removes path and any children it contains.
 func (e *filesystem) RemoveAll(ctx context.Context, name string) error {
-	err := contextual.RemoveAll(ctx, e.fsys, name)
+	if err := internal.CheckPath("removeall", name); err != nil {
+		return err
+	}
+	err := contextual.RemoveAll(ctx, e.Inner, name)
+	e.removeDemoted(ctx, name, true)
 	if err == nil {
 		e.mu.Lock()
 		for p, it := range e.files {
-			if p == name || strings.HasPrefix(p, name+"/") {
+			if contextual.HasPathPrefix(p, name) {
 				e.removeFileLocked(it)
 			}
 		}
 		e.mu.Unlock()
 	}
-	return err
+	return internal.Decorate("removeall", name, err)
 }
 
 // Rename renames a file.
 func (e *filesystem) Rename(ctx context.Context, oldname, newname string) error {
-	if err := e.checkExpired(ctx, oldname); err != nil {
+	return e.rename(ctx, oldname, newname, 0)
+}
+
+// RenameWithOptions renames a file as directed by flags.
+func (e *filesystem) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	return e.rename(ctx, oldname, newname, flags)
+}
+
+// rename is Rename with flags, tracking the files it moves.
+func (e *filesystem) rename(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	if err := internal.CheckLink("rename", oldname, newname); err != nil {
 		return err
 	}
-	err := contextual.Rename(ctx, e.fsys, oldname, newname)
+	if err := e.checkExpired(ctx, oldname); err != nil {
+		return internal.DecorateLink("rename", oldname, newname, err)
+	}
+	if e.config.DemoteTo != nil {
+		// Bring a demoted source back so that it can be renamed in place.
+		if _, err := contextual.Lstat(ctx, e.Inner, oldname); e.promoteOnMiss(ctx, oldname, err) && flags == 0 {
+			e.removeDemoted(ctx, newname, false)
+		}
+		// A demoted destination is kept or exchanged: it has to be found
+		// in place.
+		if flags != 0 {
+			_, err := contextual.Lstat(ctx, e.Inner, newname)
+			e.promoteOnMiss(ctx, newname, err)
+		}
+	}
+	var err error
+	if flags == 0 {
+		err = contextual.Rename(ctx, e.Inner, oldname, newname)
+	} else {
+		err = contextual.RenameWithOptions(ctx, e.Inner, oldname, newname, flags)
+	}
 	if err == nil {
 		e.mu.Lock()
-		if it, ok := e.files[oldname]; ok {
-			e.removeFileLocked(it)
+		for _, name := range []string{oldname, newname} {
+			if it, ok := e.files[name]; ok && (name == oldname || flags == fsx.RenameExchange) {
+				e.removeFileLocked(it)
+			}
 		}
 		e.mu.Unlock()
 		e.touch(ctx, newname)
+		if flags == fsx.RenameExchange {
+			e.touch(ctx, oldname)
+		}
 	}
-	return err
+	return internal.DecorateLink("rename", oldname, newname, err)
+}
+
+// CreateSpecial creates a special file.
+func (e *filesystem) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	if err := internal.CheckPath("mknod", name); err != nil {
+		return err
+	}
+	err := contextual.CreateSpecial(ctx, e.Inner, name, mode, dev)
+	if err == nil {
+		e.touch(ctx, name)
+	}
+	return internal.Decorate("mknod", name, err)
 }
 
 // Symlink creates a symbolic link.
 func (e *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
-	err := contextual.Symlink(ctx, e.fsys, oldname, newname)
+	if err := internal.CheckLink("symlink", oldname, newname); err != nil {
+		return err
+	}
+	err := contextual.Symlink(ctx, e.Inner, oldname, newname)
 	if err == nil {
 		e.touch(ctx, newname)
 	}
-	return err
-}
-
-// ReadLink returns the destination of the named symbolic link.
-func (e *filesystem) ReadLink(ctx context.Context, name string) (string, error) {
-	return contextual.ReadLink(ctx, e.fsys, name)
+	return internal.DecorateLink("symlink", oldname, newname, err)
 }
 
 // Lstat returns a FileInfo describing the named file, without following links.
 func (e *filesystem) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("lstat", name); err != nil {
 		return nil, err
 	}
-	fi, err := contextual.Lstat(ctx, e.fsys, name)
+	if err := e.checkExpired(ctx, name); err != nil {
+		return nil, internal.Decorate("lstat", name, err)
+	}
+	fi, err := contextual.Lstat(ctx, e.Inner, name)
+	if e.promoteOnMiss(ctx, name, err) {
+		fi, err = contextual.Lstat(ctx, e.Inner, name)
+	}
 	if err == nil {
 		e.touch(ctx, name)
 	}
-	return fi, err
+	return fi, internal.Decorate("lstat", name, err)
 }
 
 // Lchown changes the owner and group of the named file, without following links.
 func (e *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("lchown", name); err != nil {
 		return err
 	}
-	err := contextual.Lchown(ctx, e.fsys, name, owner, group)
+	if err := e.checkExpired(ctx, name); err != nil {
+		return internal.Decorate("lchown", name, err)
+	}
+	err := contextual.Lchown(ctx, e.Inner, name, owner, group)
 	if err == nil {
 		e.touch(ctx, name)
 	}
-	return err
+	return internal.Decorate("lchown", name, err)
 }
 
 // Truncate changes the size of the named file.
 func (e *filesystem) Truncate(ctx context.Context, name string, size int64) error {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("truncate", name); err != nil {
 		return err
 	}
-	err := contextual.Truncate(ctx, e.fsys, name, size)
+	if err := e.checkExpired(ctx, name); err != nil {
+		return internal.Decorate("truncate", name, err)
+	}
+	err := contextual.Truncate(ctx, e.Inner, name, size)
 	if err == nil {
 		e.touch(ctx, name)
 	}
-	return err
+	return internal.Decorate("truncate", name, err)
 }
 
 // WriteFile writes data to the named file.
 func (e *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
-	err := contextual.WriteFile(ctx, e.fsys, name, data, perm)
+	if err := internal.CheckPath("writefile", name); err != nil {
+		return err
+	}
+	err := contextual.WriteFile(ctx, e.Inner, name, data, perm)
 	if err == nil {
-		e.touch(ctx, name)
+		e.removeDemoted(ctx, name, false)
+		e.store(ctx, name)
 	}
-	return err
+	return internal.Decorate("writefile", name, err)
 }
 
 // Chown changes the owner and group of the named file.
 func (e *filesystem) Chown(ctx context.Context, name, owner, group string) error {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("chown", name); err != nil {
 		return err
 	}
-	err := contextual.Chown(ctx, e.fsys, name, owner, group)
+	if err := e.checkExpired(ctx, name); err != nil {
+		return internal.Decorate("chown", name, err)
+	}
+	err := contextual.Chown(ctx, e.Inner, name, owner, group)
 	if err == nil {
 		e.touch(ctx, name)
 	}
-	return err
+	return internal.Decorate("chown", name, err)
 }
 
 // Chmod changes the mode of the named file.
 func (e *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("chmod", name); err != nil {
 		return err
 	}
-	err := contextual.Chmod(ctx, e.fsys, name, mode)
+	if err := e.checkExpired(ctx, name); err != nil {
+		return internal.Decorate("chmod", name, err)
+	}
+	err := contextual.Chmod(ctx, e.Inner, name, mode)
 	if err == nil {
 		e.touch(ctx, name)
 	}
-	return err
+	return internal.Decorate("chmod", name, err)
 }
 
 // Chtimes changes the access and modification times of the named file.
 func (e *filesystem) Chtimes(ctx context.Context, name string, atime, ctime time.Time) error {
-	if err := e.checkExpired(ctx, name); err != nil {
+	if err := internal.CheckPath("chtimes", name); err != nil {
 		return err
 	}
-	err := contextual.Chtimes(ctx, e.fsys, name, atime, ctime)
+	if err := e.checkExpired(ctx, name); err != nil {
+		return internal.Decorate("chtimes", name, err)
+	}
+	err := contextual.Chtimes(ctx, e.Inner, name, atime, ctime)
 	if err == nil {
 		e.touch(ctx, name)
 	}
-	return err
+	return internal.Decorate("chtimes", name, err)
 }
 
 // evictFile wraps a contextual.File to track write and truncate operations.
+// It is exposed through internal.WrapFile, keeping the optional interfaces of
+// the file.
 type evictFile struct {
 	contextual.File
-	fs   *filesystem
+	fs *filesystem
+	// ctx is the context of the open call, for the I/O of the file.
+	ctx  context.Context
 	name string
 }
 
@@ -436,16 +837,37 @@ type evictFile struct {
 func (f *evictFile) Write(p []byte) (int, error) {
 	n, err := f.File.Write(p)
 	if n > 0 {
-		f.fs.touch(context.Background(), f.name)
+		f.fs.touch(f.ctx, f.name)
 	}
 	return n, err
 }
 
+// ReadFrom fills the file from r, letting the file read r itself if it can,
+// and touches it like Write.
+func (f *evictFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := internal.ReadFrom(f.File, r)
+	if n > 0 {
+		f.fs.touch(f.ctx, f.name)
+	}
+	return n, err
+}
+
+// WriteTo writes the contents of the file to w, letting the file write
+// itself if it can.
+func (f *evictFile) WriteTo(w io.Writer) (int64, error) {
+	return internal.WriteTo(w, f.File)
+}
+
+// Restat describes the file as it is now.
+func (f *evictFile) Restat() (fs.FileInfo, error) {
+	return internal.Restat(f.File)
+}
+
 // Truncate changes the size of the file and touches it.
 func (f *evictFile) Truncate(size int64) error {
 	err := f.File.Truncate(size)
 	if err == nil {
-		f.fs.touch(context.Background(), f.name)
+		f.fs.touch(f.ctx, f.name)
 	}
 	return err
 }
@@ -454,7 +876,9 @@ func (f *evictFile) Truncate(size int64) error {
 type item struct {
 	name     string
 	metadata Metadata
-	index    int // index in the priority queue (maintained by heap.Interface).
+	// cost is the re-fetch cost the metadata was created with.
+	cost  time.Duration
+	index int // index in the priority queue (maintained by heap.Interface).
 }
 
 // priorityQueue implements heap.Interface to manage file eviction priority.
@@ -482,4 +906,23 @@ func (pq *priorityQueue) Pop() any {
 	return it
 }
 
+// Close stops the background eviction, waiting for an eviction in progress to
+// finish, and closes the underlying filesystem and DemoteTo. Files are no
+// longer evicted once it is called. Closing again does nothing.
+func (e *filesystem) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		e.stop()
+		<-e.stopped
+		err = contextual.Close(e.Inner)
+		if e.config.DemoteTo != nil {
+			err = errors.Join(err, contextual.Close(e.config.DemoteTo))
+		}
+	})
+	return err
+}
+
 var _ contextual.FileSystem = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
+var _ contextual.SpecialFS = &filesystem{}
+var _ contextual.ReadDirInfoFS = &filesystem{}
@@ -9,8 +9,10 @@ import (
 
 	"github.com/gwangyi/fsx/contextual"
 	"github.com/gwangyi/fsx/evictfs"
+	"github.com/gwangyi/fsx/fsxtest"
 	"github.com/gwangyi/fsx/mockfs"
 	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"github.com/gwangyi/fsx/osfs"
 	"go.uber.org/mock/gomock"
 )
 
@@ -355,6 +357,65 @@ func TestFilesystem_RemoveAll_Recursive(t *testing.T) {
 	_ = contextual.RemoveAll(ctx, fsys, "dir")
 }
 
+// infoFS counts the listings made with ReadDirInfos.
+type infoFS struct {
+	contextual.FileSystem
+	calls *int
+}
+
+func (f infoFS) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	*f.calls++
+	return contextual.ReadDirInfos(ctx, f.FileSystem, name)
+}
+
+func TestFilesystem_Init_ReadDirInfos(t *testing.T) {
+	ctx := t.Context()
+	root := t.TempDir()
+	if err := os.MkdirAll(root+"/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "dir/b"} {
+		if err := os.WriteFile(root+"/"+name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	osFS, err := osfs.New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	fsys, err := evictfs.New(ctx, infoFS{FileSystem: contextual.ToContextual(osFS).(contextual.FileSystem), calls: &calls}, evictfs.Config{MaxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+	if calls != 2 {
+		t.Errorf("expected both directories to be listed with ReadDirInfos, got %d listings", calls)
+	}
+
+	// Both files were tracked, so writing a third one evicts two files.
+	if err := contextual.WriteFile(ctx, fsys, "c", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left := 0
+		for _, name := range []string{"a", "dir/b", "c"} {
+			if _, err := os.Stat(root + "/" + name); err == nil {
+				left++
+			}
+		}
+		if left == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d files left, want 1", left)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
 func TestFilesystem_Init_Extra(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -534,6 +595,95 @@ func TestFilesystem_OnAccessExpiration(t *testing.T) {
 	}
 }
 
+func TestFilesystem_MaxAge_Clock(t *testing.T) {
+	ctx := t.Context()
+	primary := newOSFS(t)
+	clock := fsxtest.NewClock(time.Now())
+	fsys, err := evictfs.New(ctx, primary, evictfs.Config{MaxAge: time.Hour, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+
+	if err := contextual.WriteFile(ctx, fsys, "file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := contextual.Stat(ctx, fsys, "file"); err != nil {
+		t.Fatalf("expected the file to be kept, got %v", err)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if _, err := contextual.Stat(ctx, fsys, "file"); !os.IsNotExist(err) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if _, err := contextual.Stat(ctx, primary, "file"); !os.IsNotExist(err) {
+		t.Errorf("expected the expired file to be removed, got %v", err)
+	}
+}
+
+func TestFilesystem_TrackAccessTime(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("MaxAge", func(t *testing.T) {
+		clock := fsxtest.NewClock(time.Now())
+		fsys, err := evictfs.New(ctx, newOSFS(t), evictfs.Config{MaxAge: time.Hour, Clock: clock, TrackAccessTime: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = contextual.Close(fsys) }()
+
+		if err := contextual.WriteFile(ctx, fsys, "file", []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Each access pushes the expiration back, whatever the backend
+		// reports as the access time.
+		for range 3 {
+			clock.Advance(45 * time.Minute)
+			if _, err := contextual.ReadFile(ctx, fsys, "file"); err != nil {
+				t.Fatalf("expected the file to be kept, got %v", err)
+			}
+		}
+		clock.Advance(2 * time.Hour)
+		if _, err := contextual.Stat(ctx, fsys, "file"); !os.IsNotExist(err) {
+			t.Errorf("expected ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("LRU", func(t *testing.T) {
+		clock := fsxtest.NewClock(time.Now())
+		primary := newOSFS(t)
+		fsys, err := evictfs.New(ctx, primary, evictfs.Config{MaxFiles: 2, Clock: clock, TrackAccessTime: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = contextual.Close(fsys) }()
+
+		for _, name := range []string{"a", "b"} {
+			if err := contextual.WriteFile(ctx, fsys, name, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(time.Minute)
+		}
+		if _, err := contextual.ReadFile(ctx, fsys, "a"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+		if err := contextual.WriteFile(ctx, fsys, "c", []byte("c"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			_, err := contextual.Stat(ctx, primary, "b")
+			return os.IsNotExist(err)
+		})
+		for _, name := range []string{"a", "c"} {
+			if _, err := contextual.Stat(ctx, primary, name); err != nil {
+				t.Errorf("expected %s to be kept, got %v", name, err)
+			}
+		}
+	})
+}
+
 func TestFilesystem_checkExpired_Coverage(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -749,3 +899,194 @@ func TestFilesystem_FileOps(t *testing.T) {
 	mf1.EXPECT().Truncate(gomock.Any()).Return(nil)
 	_ = f.Truncate(5)
 }
+
+func TestFilesystem_Close(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	m := cmockfs.NewMockFileSystem(ctrl)
+	ctx := t.Context()
+
+	dot := mockfs.NewMockFileInfo(ctrl)
+	dot.EXPECT().IsDir().Return(true).AnyTimes()
+	m.EXPECT().Stat(gomock.Any(), ".").Return(dot, nil)
+	m.EXPECT().ReadDir(gomock.Any(), ".").Return(nil, nil)
+
+	fsys, err := evictfs.New(ctx, m, evictfs.Config{MaxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.Close(fsys); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := contextual.Close(fsys); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+
+	// Nothing is evicted once closed: Remove is never expected.
+	for _, name := range []string{"file1", "file2"} {
+		m.EXPECT().OpenFile(gomock.Any(), name, gomock.Any(), gomock.Any()).Return(nil, nil)
+		m.EXPECT().Stat(gomock.Any(), name).Return(newMockFileInfo(ctrl, name, 10, time.Now()), nil)
+		if _, err := contextual.Create(ctx, fsys, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestFilesystem_ErrorOps(t *testing.T) {
+	osFS, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := evictfs.New(t.Context(), contextual.ToContextual(osFS), evictfs.Config{MaxFiles: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+	fsxtest.CheckErrorOps(t, fsys, "missing")
+}
+
+func TestFilesystem_Watermarks(t *testing.T) {
+	ctx := t.Context()
+	osFS, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	passes := make(chan evictfs.Pass, 10)
+	fsys, err := evictfs.New(ctx, contextual.ToContextual(osFS), evictfs.Config{
+		MaxSize:      100,
+		LowWatermark: 40,
+		OnEvict:      func(p evictfs.Pass) { passes <- p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+
+	data := make([]byte, 30)
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if err := contextual.WriteFile(ctx, fsys, name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := base.Add(time.Duration(i) * time.Minute)
+		if err := contextual.Chtimes(ctx, fsys, name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Writing d exceeds MaxSize; the pass frees down to LowWatermark at once.
+	select {
+	case p := <-passes:
+		if p.Files != 3 || p.Bytes != 90 || p.Duration <= 0 {
+			t.Errorf("unexpected pass: %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no eviction pass")
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := fs.Stat(osFS, name); err == nil {
+			t.Errorf("expected %s to be evicted", name)
+		}
+	}
+	if _, err := fs.Stat(osFS, "d"); err != nil {
+		t.Errorf("expected d to be kept: %v", err)
+	}
+}
+
+func TestFilesystem_InvalidPaths(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	m := cmockfs.NewMockFileSystem(ctrl)
+	dot := mockfs.NewMockFileInfo(ctrl)
+	dot.EXPECT().IsDir().Return(true).AnyTimes()
+	m.EXPECT().Stat(gomock.Any(), ".").Return(dot, nil)
+	m.EXPECT().ReadDir(gomock.Any(), ".").Return(nil, nil)
+
+	fsys, err := evictfs.New(t.Context(), m, evictfs.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+	// Invalid names never reach the backend.
+	fsxtest.CheckInvalidPaths(t, fsys)
+}
+
+// blockingRemoveFS blocks the files removed through it until their context
+// is done, sending it to started.
+type blockingRemoveFS struct {
+	contextual.FileSystem
+	started chan context.Context
+}
+
+func (f *blockingRemoveFS) Remove(ctx context.Context, name string) error {
+	f.started <- ctx
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type requestKey struct{}
+
+func TestFilesystem_CloseCancelsEviction(t *testing.T) {
+	backend := &blockingRemoveFS{FileSystem: newOSFS(t).(contextual.FileSystem), started: make(chan context.Context, 1)}
+	ctx := context.WithValue(t.Context(), requestKey{}, "request")
+	fsys, err := evictfs.New(ctx, backend, evictfs.Config{MaxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := contextual.WriteFile(t.Context(), fsys, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evictCtx := <-backend.started
+	if v := evictCtx.Value(requestKey{}); v != "request" {
+		t.Errorf("eviction context value = %v; want request", v)
+	}
+	// Close ends the eviction in progress instead of waiting for it.
+	if err := contextual.Close(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if err := evictCtx.Err(); err != context.Canceled {
+		t.Errorf("eviction context error after Close = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestFilesystem_Lifetime(t *testing.T) {
+	for _, tc := range []struct {
+		lifetime contextual.Lifetime
+		evicted  bool
+	}{
+		{contextual.Detached, true},
+		{contextual.Bound, false},
+	} {
+		t.Run(tc.lifetime.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(t.Context())
+			primary := newOSFS(t)
+			fsys, err := evictfs.New(ctx, primary, evictfs.Config{MaxFiles: 1, Lifetime: tc.lifetime})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = contextual.Close(fsys) }()
+			// The context of New is over, but the filesystem is still used.
+			cancel()
+
+			for _, name := range []string{"a", "b"} {
+				if err := contextual.WriteFile(t.Context(), fsys, name, []byte(name), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.evicted {
+				waitFor(t, func() bool {
+					_, err := contextual.Stat(t.Context(), primary, "a")
+					return os.IsNotExist(err)
+				})
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			if _, err := contextual.Stat(t.Context(), primary, "a"); err != nil {
+				t.Errorf("expected a to be kept once the loop stopped, got %v", err)
+			}
+		})
+	}
+}
@@ -1,22 +1,47 @@
 package evictfs
 
 import (
+	"time"
+
 	"github.com/gwangyi/fsx/contextual"
 )
 
+// lruMetadata orders files by access time. A file that costs more to fetch
+// again is taken as accessed that much later, so that files equally stale are
+// evicted cheapest first.
 type lruMetadata struct {
 	contextual.FileInfo
+	cost time.Duration
 }
 
-func newLRU(fi contextual.FileInfo) Metadata {
-	return &lruMetadata{FileInfo: fi}
+func newLRU(fi contextual.FileInfo, cost time.Duration) Metadata {
+	return &lruMetadata{FileInfo: fi, cost: cost}
 }
 
 func (m *lruMetadata) Less(other Metadata) bool {
-	otherFi := other.(*lruMetadata).FileInfo
-	return m.FileInfo.AccessTime().Before(otherFi.AccessTime())
+	o := other.(*lruMetadata)
+	return m.FileInfo.AccessTime().Add(m.cost).Before(o.FileInfo.AccessTime().Add(o.cost))
 }
 
 func (m *lruMetadata) Update(info contextual.FileInfo) {
 	m.FileInfo = info
 }
+
+// accessInfo returns info, reporting atime as its access time if
+// Config.TrackAccessTime is set.
+func (e *filesystem) accessInfo(info contextual.FileInfo, atime time.Time) contextual.FileInfo {
+	if !e.config.TrackAccessTime {
+		return info
+	}
+	return accessedInfo{FileInfo: info, atime: atime}
+}
+
+// accessedInfo is a FileInfo whose access time is tracked by evictfs.
+type accessedInfo struct {
+	contextual.FileInfo
+	atime time.Time
+}
+
+func (i accessedInfo) AccessTime() time.Time {
+	return i.atime
+}
@@ -0,0 +1,89 @@
+package evictfs
+
+import (
+	"context"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// Backlog is what an evictfs holds beyond its limits, waiting to be evicted.
+type Backlog struct {
+	// Files is the number of files beyond MaxFiles.
+	Files int
+	// Bytes is the total size beyond MaxSize, or beyond the low watermark
+	// while an eviction pass is evicting files.
+	Bytes int64
+}
+
+// PendingEvictions returns the backlog of fsys, an evictfs made by New. It
+// grows when files are written faster than Config.EvictRate lets them be
+// evicted, and can be exported as a metric to size the rate.
+func PendingEvictions(fsys contextual.FS) Backlog {
+	e := fsys.(*filesystem)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var b Backlog
+	if e.config.MaxFiles > 0 {
+		b.Files = max(len(e.files)-e.config.MaxFiles, 0)
+	}
+	limit := e.config.MaxSize
+	if e.passing {
+		limit = e.lowWatermark()
+	}
+	if limit > 0 {
+		b.Bytes = max(e.currentSize-limit, 0)
+	}
+	return b
+}
+
+// evictBatch evicts the named files, which are no longer tracked. Several
+// files are removed with a single contextual.RemoveMany call, after being
+// copied to Config.DemoteTo if set. The files that could not be copied are
+// kept, and returned.
+func (e *filesystem) evictBatch(ctx context.Context, names []string) (kept []string) {
+	if len(names) == 1 {
+		if e.evict(ctx, names[0]) {
+			return names
+		}
+		return nil
+	}
+	if e.config.DemoteTo != nil {
+		var demoted []string
+		for _, name := range names {
+			if err := copyFile(ctx, e.Inner, e.config.DemoteTo, name); err != nil {
+				kept = append(kept, name)
+				continue
+			}
+			demoted = append(demoted, name)
+		}
+		names = demoted
+	}
+	if len(names) > 0 {
+		_ = contextual.RemoveMany(ctx, e.Inner, names)
+	}
+	return kept
+}
+
+// pace waits before the next batch of an eviction pass, for Config.EvictPause
+// and for as long as Config.EvictRate requires after evicting n files in a
+// batch started at start. It reports false if ctx is done meanwhile, as
+// when the filesystem is closed.
+func (e *filesystem) pace(ctx context.Context, clock contextual.Clock, start time.Time, n int) bool {
+	wait := e.config.EvictPause
+	if rate := e.config.EvictRate; rate > 0 {
+		wait += max(time.Duration(float64(n)/rate*float64(time.Second))-clock.Now().Sub(start), 0)
+	}
+	if wait <= 0 {
+		return true
+	}
+	timer := clock.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
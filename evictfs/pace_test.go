@@ -0,0 +1,168 @@
+package evictfs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/evictfs"
+	"github.com/gwangyi/fsx/fsxtest"
+)
+
+// batchRemoveFS counts the batches of files removed through it.
+type batchRemoveFS struct {
+	contextual.FileSystem
+	batches atomic.Int32
+}
+
+func (f *batchRemoveFS) RemoveMany(ctx context.Context, names []string) error {
+	f.batches.Add(1)
+	return contextual.RemoveMany(ctx, f.FileSystem, names)
+}
+
+func TestFilesystem_Pacing(t *testing.T) {
+	ctx := t.Context()
+	backend := &batchRemoveFS{FileSystem: newOSFS(t).(contextual.FileSystem)}
+	for i := range 7 {
+		if err := contextual.WriteFile(ctx, backend, fmt.Sprintf("f%d", i), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock := fsxtest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	passes := make(chan evictfs.Pass, 1)
+	fsys, err := evictfs.New(ctx, backend, evictfs.Config{
+		MaxFiles:   2,
+		EvictRate:  1,
+		EvictBatch: 2,
+		Clock:      clock,
+		OnEvict:    func(p evictfs.Pass) { passes <- p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+
+	if b := evictfs.PendingEvictions(fsys); b.Files != 5 {
+		t.Errorf("PendingEvictions() = %+v; want 5 files", b)
+	}
+	// Writing one more file starts a pass evicting 6 files, 2 every 2s.
+	if err := contextual.WriteFile(ctx, fsys, "new", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i, pending := range []int{4, 2} {
+		waitFor(t, func() bool { return clock.Timers() == 1 })
+		if got := backend.batches.Load(); got != int32(i+1) {
+			t.Errorf("batches = %d; want %d", got, i+1)
+		}
+		if b := evictfs.PendingEvictions(fsys); b.Files != pending {
+			t.Errorf("PendingEvictions() = %+v; want %d files", b, pending)
+		}
+		clock.Advance(time.Second)
+		if clock.Timers() != 1 {
+			t.Fatal("expected the pass to wait for 2s")
+		}
+		clock.Advance(time.Second)
+	}
+
+	select {
+	case p := <-passes:
+		if p.Files != 6 || p.Batches != 3 || p.Duration != 4*time.Second {
+			t.Errorf("unexpected pass: %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no eviction pass")
+	}
+	if b := evictfs.PendingEvictions(fsys); b != (evictfs.Backlog{}) {
+		t.Errorf("PendingEvictions() = %+v; want none", b)
+	}
+}
+
+func TestFilesystem_PauseAndRate(t *testing.T) {
+	ctx := t.Context()
+	backend := &batchRemoveFS{FileSystem: newOSFS(t).(contextual.FileSystem)}
+	for i := range 4 {
+		if err := contextual.WriteFile(ctx, backend, fmt.Sprintf("f%d", i), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock := fsxtest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fsys, err := evictfs.New(ctx, backend, evictfs.Config{
+		MaxFiles:   1,
+		EvictRate:  1,
+		EvictBatch: 2,
+		EvictPause: time.Second,
+		Clock:      clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+
+	// Writing one more file starts a pass evicting 4 files in 2 batches,
+	// which wait for the pause on top of the 2s the rate requires.
+	if err := contextual.WriteFile(ctx, fsys, "new", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return clock.Timers() == 1 })
+	clock.Advance(2 * time.Second)
+	if clock.Timers() != 1 || backend.batches.Load() != 1 {
+		t.Fatal("expected the pass to wait for the pause after the rate")
+	}
+	clock.Advance(time.Second)
+	waitFor(t, func() bool { return backend.batches.Load() == 2 })
+}
+
+func TestFilesystem_BatchDemotionFailure(t *testing.T) {
+	ctx := t.Context()
+	primary := newOSFS(t)
+	slow := newOSFS(t)
+	for _, name := range []string{"f0", "f1"} {
+		if err := contextual.WriteFile(ctx, primary, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	passes := make(chan evictfs.Pass, 1)
+	fsys, err := evictfs.New(ctx, primary, evictfs.Config{
+		MaxFiles:        1,
+		EvictBatch:      2,
+		DemoteTo:        fsxtest.NewErrorFS(slow, map[fsxtest.Fault]error{{Op: "open", Path: "f0"}: fs.ErrPermission}),
+		Clock:           fsxtest.NewClock(time.Now().Add(time.Hour)),
+		TrackAccessTime: true,
+		OnEvict:         func(p evictfs.Pass) { passes <- p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = contextual.Close(fsys) }()
+
+	// f0 and f1 are evicted in a batch, but f0 cannot be demoted.
+	if err := contextual.WriteFile(ctx, fsys, "f2", []byte("f2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-passes:
+		if p.Files != 1 || p.Batches != 1 {
+			t.Errorf("unexpected pass: %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no eviction pass")
+	}
+	if _, err := contextual.Stat(ctx, primary, "f0"); err != nil {
+		t.Errorf("expected f0 to be kept, got %v", err)
+	}
+	if _, err := contextual.Stat(ctx, primary, "f1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected f1 to be demoted, got %v", err)
+	}
+	if _, err := contextual.Stat(ctx, slow, "f1"); err != nil {
+		t.Errorf("expected f1 in the slow tier, got %v", err)
+	}
+	// f0 is tracked again, still beyond the limits.
+	if b := evictfs.PendingEvictions(fsys); b.Files != 1 {
+		t.Errorf("PendingEvictions() = %+v; want 1 file", b)
+	}
+}
@@ -0,0 +1,139 @@
+package evictfs
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// AccessOp is the kind of an access replayed by Simulate.
+type AccessOp int
+
+const (
+	// AccessRead is a read of the file, such as Open, ReadFile or Stat.
+	AccessRead AccessOp = iota
+	// AccessWrite is a write of the whole file, such as WriteFile or Create.
+	AccessWrite
+	// AccessRemove is a removal of the file.
+	AccessRemove
+)
+
+// AccessRecord is an access to a file, as replayed by Simulate.
+type AccessRecord struct {
+	// Time is when the access happened. Records are replayed in order, and
+	// their times stand for Config.Clock and for the access times of the
+	// files.
+	Time time.Time
+	Op   AccessOp
+	// Name is the name of the file accessed.
+	Name string
+	// Size is the size of the file after a write, or when a read misses
+	// and the file is fetched. Reads of a file already held may leave it 0.
+	Size int64
+	// Cost, if set, is the re-fetch cost hint of a write, as given by
+	// WithCost.
+	Cost time.Duration
+}
+
+// Simulation is the outcome of Simulate.
+type Simulation struct {
+	// Hits is the number of reads of a file that was held.
+	Hits int
+	// Misses is the number of reads of a file that was not held, because it
+	// was never written, was evicted or had expired.
+	Misses int
+	// Evictions is the number of files evicted to keep within the limits,
+	// and EvictedBytes their total size.
+	Evictions    int
+	EvictedBytes int64
+	// Expirations is the number of files dropped by MaxAge.
+	Expirations int
+	// PeakSize is the largest total size of the files held, reached before
+	// the evictions it started.
+	PeakSize int64
+}
+
+// Simulate replays trace against the eviction policy of config without
+// touching any filesystem, so that the limits and the policy can be chosen
+// before deploying, and reports the hits and misses they would get. It
+// shares the code of the filesystems returned by New, including Metadata,
+// CostMetadata and Cost, which is given a context.Background().
+//
+// A read missing the file is taken as fetching it, written with the size of
+// the record, like a cache filling itself. Eviction passes run right after
+// each access, while those of New run in the background soon after. Clock,
+// TrackAccessTime, DemoteTo and OnEvict do not apply: the files are accessed
+// at the times of the records, and evicted files are dropped.
+func Simulate(trace []AccessRecord, config Config) Simulation {
+	ctx := context.Background()
+	e := newFilesystem(nil, config)
+	files := make(map[string]simulatedInfo)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var s Simulation
+	for _, r := range trace {
+		write := r.Op == AccessWrite
+		switch r.Op {
+		case AccessRemove:
+			delete(files, r.Name)
+			if it, ok := e.files[r.Name]; ok {
+				e.removeFileLocked(it)
+			}
+			continue
+		case AccessRead:
+			if config.MaxAge > 0 && e.expireLocked(r.Name, r.Time) {
+				s.Expirations++
+			}
+			if _, ok := e.files[r.Name]; ok {
+				s.Hits++
+				if r.Size == 0 {
+					r.Size = files[r.Name].size
+				}
+			} else {
+				s.Misses++
+				write = true
+			}
+		}
+
+		info := files[r.Name]
+		info.name = path.Base(r.Name)
+		info.size = r.Size
+		if write {
+			info.modTime = r.Time
+		}
+		files[r.Name] = info
+
+		wctx := ctx
+		if r.Cost != 0 {
+			wctx = WithCost(ctx, r.Cost)
+		}
+		e.refreshLocked(wctx, r.Name, accessedInfo{FileInfo: contextual.ExtendFileInfo(info), atime: r.Time}, write)
+		s.PeakSize = max(s.PeakSize, e.currentSize)
+
+		var pass Pass
+		for it := e.popVictimLocked(&pass); it != nil; it = e.popVictimLocked(&pass) {
+			delete(files, it.name)
+		}
+		s.Evictions += pass.Files
+		s.EvictedBytes += pass.Bytes
+	}
+	return s
+}
+
+// simulatedInfo describes a file of Simulate.
+type simulatedInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i simulatedInfo) Name() string       { return i.name }
+func (i simulatedInfo) Size() int64        { return i.size }
+func (i simulatedInfo) Mode() fs.FileMode  { return 0644 }
+func (i simulatedInfo) ModTime() time.Time { return i.modTime }
+func (i simulatedInfo) IsDir() bool        { return false }
+func (i simulatedInfo) Sys() any           { return nil }
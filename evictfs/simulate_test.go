@@ -0,0 +1,73 @@
+package evictfs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/evictfs"
+)
+
+func TestSimulate(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	read := func(minutes int, name string, size int64) evictfs.AccessRecord {
+		return evictfs.AccessRecord{Time: at(minutes), Op: evictfs.AccessRead, Name: name, Size: size}
+	}
+	trace := []evictfs.AccessRecord{
+		{Time: at(0), Op: evictfs.AccessWrite, Name: "a", Size: 10},
+		read(1, "b", 20),
+		read(2, "a", 0),
+		read(3, "c", 30),
+		read(4, "b", 20),
+		read(5, "a", 10),
+		{Time: at(6), Op: evictfs.AccessRemove, Name: "a"},
+		read(7, "a", 10),
+	}
+
+	t.Run("LRU", func(t *testing.T) {
+		got := evictfs.Simulate(trace, evictfs.Config{MaxSize: 50})
+		// c evicts b, the least recently used file, b evicts a, and a evicts
+		// c.
+		want := evictfs.Simulation{Hits: 1, Misses: 5, Evictions: 3, EvictedBytes: 60, PeakSize: 60}
+		if got != want {
+			t.Errorf("Simulate() = %+v; want %+v", got, want)
+		}
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		got := evictfs.Simulate(trace, evictfs.Config{})
+		want := evictfs.Simulation{Hits: 3, Misses: 3, PeakSize: 60}
+		if got != want {
+			t.Errorf("Simulate() = %+v; want %+v", got, want)
+		}
+	})
+
+	t.Run("MaxAge", func(t *testing.T) {
+		got := evictfs.Simulate(trace, evictfs.Config{MaxAge: 150 * time.Second})
+		// b and then a are read again 3 minutes after their last access.
+		want := evictfs.Simulation{Hits: 1, Misses: 5, Expirations: 2, PeakSize: 60}
+		if got != want {
+			t.Errorf("Simulate() = %+v; want %+v", got, want)
+		}
+	})
+
+	t.Run("Cost", func(t *testing.T) {
+		config := evictfs.Config{
+			MaxFiles: 2,
+			Cost: func(_ context.Context, name string, _ contextual.FileInfo) time.Duration {
+				if name == "b" {
+					return time.Hour
+				}
+				return 0
+			},
+		}
+		got := evictfs.Simulate(trace, config)
+		// b costs too much to be evicted, so c evicts a, and a evicts c.
+		want := evictfs.Simulation{Hits: 2, Misses: 4, Evictions: 2, EvictedBytes: 40, PeakSize: 60}
+		if got != want {
+			t.Errorf("Simulate() = %+v; want %+v", got, want)
+		}
+	})
+}
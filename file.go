@@ -0,0 +1,29 @@
+package fsx
+
+import "io"
+
+// SeekableFile is a File that can move its offset, such as a regular file of
+// most backends. It is an optional interface: check for it, or for
+// io.Seeker, on the files returned by OpenFile before relying on it.
+type SeekableFile interface {
+	File
+	io.Seeker
+}
+
+// ReaderAtFile is a File that can be read at any offset without moving its
+// own, so that several readers can share it. It is an optional interface,
+// like SeekableFile.
+type ReaderAtFile interface {
+	File
+	io.ReaderAt
+}
+
+// SyncFile is a File whose written contents can be committed to stable
+// storage, as *os.File does. It is an optional interface, like
+// SeekableFile.
+type SyncFile interface {
+	File
+
+	// Sync commits the current contents of the file to stable storage.
+	Sync() error
+}
@@ -7,10 +7,11 @@
 // overlays, and testing mocks where write capabilities are required.
 package fsx
 
-//go:generate mockgen -destination mockfs/mockfs.go -package mockfs . WriterFS,DirEntry,File,ReadDirFile,FileInfo,ChangeFS,DirFS,LchownFS,MkdirAllFS,RemoveAllFS,RenameFS,SymlinkFS,TruncateFS,WriteFileFS,FileSystem
+//go:generate mockgen -destination mockfs/mockfs.go -package mockfs . WriterFS,DirEntry,File,ReadDirFile,SeekableFile,ReaderAtFile,SyncFile,FileInfo,ChangeFS,DirFS,LchownFS,MkdirAllFS,RemoveAllFS,RenameFS,SymlinkFS,TruncateFS,WriteFileFS,FileSystem
 
 import (
 	"errors"
+	"io"
 	"io/fs"
 	"os"
 
@@ -77,7 +78,9 @@ func Create(fsys fs.FS, name string) (File, error) {
 // OpenFile opens the named file with specified flag and mode in the given filesystem.
 // It provides a generalized open call similar to os.OpenFile.
 //
-// If fsys implements fsx.WriterFS, it calls fsys.OpenFile.
+// If fsys implements fsx.WriterFS, it calls fsys.OpenFile. If that fails with
+// ErrUnsupported and flag includes O_APPEND, the file is opened again without
+// it, and appends are emulated with EmulateAppend.
 // If the operation is not supported by the filesystem implementation (returns ErrUnsupported)
 // or if fsys is not an fsx.WriterFS, it attempts a fallback for read-only access:
 // if the flag requests read-only access (O_RDONLY), it falls back to fsys.Open.
@@ -85,7 +88,10 @@ func Create(fsys fs.FS, name string) (File, error) {
 func OpenFile(fsys fs.FS, name string, flag int, mode fs.FileMode) (File, error) {
 	if xfs, ok := fsys.(WriterFS); ok {
 		// Try the specific OpenFile implementation first.
-		if f, err := xfs.OpenFile(name, flag, mode); !errors.Is(err, errors.ErrUnsupported) {
+		f, err := internal.OpenAppend(name, flag, func(flag int) (File, error) {
+			return xfs.OpenFile(name, flag, mode)
+		})
+		if !errors.Is(err, errors.ErrUnsupported) {
 			return f, internal.IntoPathErr("open", name, err)
 		}
 	}
@@ -96,8 +102,9 @@ func OpenFile(fsys fs.FS, name string, flag int, mode fs.FileMode) (File, error)
 		if err != nil {
 			return nil, internal.IntoPathErr("open", name, err)
 		}
-		// Wrap the standard fs.File in a internal.ReadOnlyFile to satisfy the fsx.File interface.
-		return internal.ReadOnlyFile{File: f}, nil
+		// Wrap the standard fs.File in a internal.ReadOnlyFile to satisfy the fsx.File interface,
+		// keeping the optional interfaces of f.
+		return internal.WrapFile(internal.ReadOnlyFile{File: f}, f), nil
 	}
 
 	return nil, errors.ErrUnsupported
@@ -115,6 +122,48 @@ func Remove(fsys fs.FS, name string) error {
 	return errors.ErrUnsupported
 }
 
+// WrapFile returns file, a wrapper around inner, extended with exactly the
+// optional interfaces among io.Seeker, io.ReaderAt and fs.ReadDirFile that
+// inner implements. Wrappers overriding a few methods of a file can use it so
+// that callers still discover what the inner file supports. The methods of
+// those interfaces are taken from file if it defines them, and from inner
+// otherwise.
+//
+// io.WriterTo and io.ReaderFrom, which let io.Copy serve a file without
+// buffering it, such as with sendfile, are only exposed if file defines them
+// itself, since those of inner would bypass the Read and Write of file, and
+// inner, a regular file implementing io.Seeker and io.ReaderAt, implements
+// them too. Wrappers leaving the contents of inner alone can define them
+// with WriteTo and ReadFrom.
+func WrapFile(file File, inner fs.File) File {
+	return internal.WrapFile(file, inner)
+}
+
+// WriteTo writes the contents of r to w with the io.WriterTo of r, if it
+// implements it, or through a pooled buffer.
+func WriteTo(w io.Writer, r io.Reader) (int64, error) {
+	return internal.WriteTo(w, r)
+}
+
+// ReadFrom reads r into w with the io.ReaderFrom of w, if it implements it,
+// or through a pooled buffer.
+func ReadFrom(w io.Writer, r io.Reader) (int64, error) {
+	return internal.ReadFrom(w, r)
+}
+
+// EmulateAppend returns file, opened without O_APPEND, wrapped so that every
+// write appends to the file as if it had been opened with O_APPEND: it moves
+// to the end of the file first, under a lock held by the handle. The file
+// must implement io.Seeker; otherwise EmulateAppend returns
+// errors.ErrUnsupported. Writes through other handles of the file are not
+// serialized with those of file, unlike with a native O_APPEND.
+//
+// OpenFile and contextual.OpenFile use it for backends that can write files
+// but fail to open them with O_APPEND with errors.ErrUnsupported.
+func EmulateAppend(file File) (File, error) {
+	return internal.EmulateAppend(file)
+}
+
 // ExtendFileInfo returns a FileInfo that wraps the provided fs.FileInfo,
 // attempting to extract extended system-specific information.
 func ExtendFileInfo(fi fs.FileInfo) FileInfo {
@@ -2,13 +2,16 @@ package fsx_test
 
 import (
 	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"testing"
 	"testing/fstest"
 
 	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/fsxtest"
 	"github.com/gwangyi/fsx/mockfs"
+	"github.com/gwangyi/fsx/osfs"
 	"go.uber.org/mock/gomock"
 )
 
@@ -241,3 +244,62 @@ func TestRemove(t *testing.T) {
 		}
 	})
 }
+
+// noAppendFS can write files but not open them with O_APPEND.
+type noAppendFS struct {
+	fsx.WriterFS
+}
+
+func (f noAppendFS) OpenFile(name string, flag int, perm fs.FileMode) (fsx.File, error) {
+	if flag&os.O_APPEND != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+	}
+	return f.WriterFS.OpenFile(name, flag, perm)
+}
+
+func TestEmulateAppend(t *testing.T) {
+	dir, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsxtest.CheckAppend(t, noAppendFS{dir.(fsx.WriterFS)}, "appended")
+
+	// Files that cannot seek cannot append.
+	ctrl := gomock.NewController(t)
+	if _, err := fsx.EmulateAppend(mockfs.NewMockFile(ctrl)); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("EmulateAppend() on a file without Seek: got %v; want ErrUnsupported", err)
+	}
+}
+
+func TestWrapFile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	seekable := mockfs.NewMockSeekableFile(ctrl)
+	seekable.EXPECT().Seek(int64(4), io.SeekStart).Return(int64(4), nil)
+	f := fsx.WrapFile(struct{ fsx.File }{seekable}, seekable)
+	if _, ok := f.(io.ReaderAt); ok {
+		t.Error("expected no io.ReaderAt for a file that only seeks")
+	}
+	if s, ok := f.(io.Seeker); !ok {
+		t.Error("expected io.Seeker to be kept")
+	} else if off, err := s.Seek(4, io.SeekStart); off != 4 || err != nil {
+		t.Errorf("Seek() = %d, %v; want 4", off, err)
+	}
+
+	readerAt := mockfs.NewMockReaderAtFile(ctrl)
+	readerAt.EXPECT().ReadAt(gomock.Any(), int64(2)).DoAndReturn(func(p []byte, off int64) (int, error) {
+		return copy(p, "ta"), io.EOF
+	})
+	f = fsx.WrapFile(struct{ fsx.File }{readerAt}, readerAt)
+	if _, ok := f.(io.Seeker); ok {
+		t.Error("expected no io.Seeker for a file that only reads at offsets")
+	}
+	if r, ok := f.(io.ReaderAt); !ok {
+		t.Error("expected io.ReaderAt to be kept")
+	} else {
+		buf := make([]byte, 4)
+		if n, err := r.ReadAt(buf, 2); string(buf[:n]) != "ta" || err != io.EOF {
+			t.Errorf("ReadAt() = %q, %v; want ta, EOF", buf[:n], err)
+		}
+	}
+}
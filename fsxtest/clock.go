@@ -0,0 +1,122 @@
+package fsxtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// Clock is a contextual.Clock whose time only moves when told to, for
+// testing expiration and scheduling without sleeping. The zero value starts
+// at the zero time; use NewClock to start elsewhere.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+// NewClock returns a Clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a Timer firing once the clock is advanced by at least d.
+// A Timer for a non-positive duration fires right away.
+func (c *Clock) NewTimer(d time.Duration) contextual.Timer {
+	t := &timer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d and fires the timers that expire.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fireLocked()
+}
+
+// Set moves the clock to now and fires the timers that expire.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	c.fireLocked()
+}
+
+// Timers returns the number of timers waiting to fire. Tests use it to wait
+// until the code under test has scheduled its next event before advancing
+// the clock.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// fireLocked fires and drops the expired timers. It must be called with
+// c.mu held.
+func (c *Clock) fireLocked() {
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+	clear(c.timers[len(pending):])
+	c.timers = pending
+}
+
+// stopLocked drops t from the waiting timers and reports whether it was
+// waiting. It must be called with c.mu held.
+func (c *Clock) stopLocked(t *timer) bool {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// timer is a contextual.Timer of a Clock.
+type timer struct {
+	clock *Clock
+	c     chan time.Time
+	when  time.Time
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.stopLocked(t)
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := c.stopLocked(t)
+	t.when = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	c.fireLocked()
+	return active
+}
+
+var _ contextual.Clock = &Clock{}
@@ -0,0 +1,372 @@
+package fsxtest
+
+import (
+	"context"
+	"io/fs"
+	"maps"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// The test doubles below wrap a filesystem and act on each operation before
+// forwarding it. Operations are named after the Op of their errors, such as
+// "open" for Open, Create and OpenFile, "stat", "mkdir" for Mkdir and
+// MkdirAll, "rename" or "mknod". The doubles compose by wrapping each other,
+// and are unwrapped with contextual.Unwrap. Reads and writes made through
+// open files are forwarded untouched.
+
+// hookFS is a contextual.FileSystem calling hook with the operation and its
+// names before forwarding each operation to Inner. An error of hook fails
+// the operation.
+type hookFS struct {
+	contextual.PassthroughFS
+	hook func(ctx context.Context, op string, names ...string) error
+}
+
+// Unwrap returns the filesystem the operations are forwarded to.
+func (h *hookFS) Unwrap() contextual.FS {
+	return h.Inner
+}
+
+func (h *hookFS) Open(ctx context.Context, name string) (fs.File, error) {
+	if err := h.hook(ctx, "open", name); err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return h.PassthroughFS.Open(ctx, name)
+}
+
+func (h *hookFS) Create(ctx context.Context, name string) (contextual.File, error) {
+	if err := h.hook(ctx, "open", name); err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return h.PassthroughFS.Create(ctx, name)
+}
+
+func (h *hookFS) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (contextual.File, error) {
+	if err := h.hook(ctx, "open", name); err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return h.PassthroughFS.OpenFile(ctx, name, flag, mode)
+}
+
+func (h *hookFS) Remove(ctx context.Context, name string) error {
+	if err := h.hook(ctx, "remove", name); err != nil {
+		return internal.Decorate("remove", name, err)
+	}
+	return h.PassthroughFS.Remove(ctx, name)
+}
+
+func (h *hookFS) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := h.hook(ctx, "readfile", name); err != nil {
+		return nil, internal.Decorate("readfile", name, err)
+	}
+	return h.PassthroughFS.ReadFile(ctx, name)
+}
+
+func (h *hookFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := h.hook(ctx, "stat", name); err != nil {
+		return nil, internal.Decorate("stat", name, err)
+	}
+	return h.PassthroughFS.Stat(ctx, name)
+}
+
+func (h *hookFS) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := h.hook(ctx, "lstat", name); err != nil {
+		return nil, internal.Decorate("lstat", name, err)
+	}
+	return h.PassthroughFS.Lstat(ctx, name)
+}
+
+func (h *hookFS) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := h.hook(ctx, "readdir", name); err != nil {
+		return nil, internal.Decorate("readdir", name, err)
+	}
+	return h.PassthroughFS.ReadDir(ctx, name)
+}
+
+func (h *hookFS) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := h.hook(ctx, "mkdir", name); err != nil {
+		return internal.Decorate("mkdir", name, err)
+	}
+	return h.PassthroughFS.Mkdir(ctx, name, perm)
+}
+
+func (h *hookFS) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := h.hook(ctx, "mkdir", name); err != nil {
+		return internal.Decorate("mkdir", name, err)
+	}
+	return h.PassthroughFS.MkdirAll(ctx, name, perm)
+}
+
+func (h *hookFS) RemoveAll(ctx context.Context, name string) error {
+	if err := h.hook(ctx, "removeall", name); err != nil {
+		return internal.Decorate("removeall", name, err)
+	}
+	return h.PassthroughFS.RemoveAll(ctx, name)
+}
+
+func (h *hookFS) Rename(ctx context.Context, oldname, newname string) error {
+	if err := h.hook(ctx, "rename", oldname, newname); err != nil {
+		return internal.DecorateLink("rename", oldname, newname, err)
+	}
+	return h.PassthroughFS.Rename(ctx, oldname, newname)
+}
+
+func (h *hookFS) Symlink(ctx context.Context, oldname, newname string) error {
+	if err := h.hook(ctx, "symlink", newname); err != nil {
+		return internal.DecorateLink("symlink", oldname, newname, err)
+	}
+	return h.PassthroughFS.Symlink(ctx, oldname, newname)
+}
+
+func (h *hookFS) ReadLink(ctx context.Context, name string) (string, error) {
+	if err := h.hook(ctx, "readlink", name); err != nil {
+		return "", internal.Decorate("readlink", name, err)
+	}
+	return h.PassthroughFS.ReadLink(ctx, name)
+}
+
+func (h *hookFS) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	if err := h.hook(ctx, "mknod", name); err != nil {
+		return internal.Decorate("mknod", name, err)
+	}
+	return h.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+}
+
+func (h *hookFS) Lchown(ctx context.Context, name, owner, group string) error {
+	if err := h.hook(ctx, "lchown", name); err != nil {
+		return internal.Decorate("lchown", name, err)
+	}
+	return h.PassthroughFS.Lchown(ctx, name, owner, group)
+}
+
+func (h *hookFS) Chown(ctx context.Context, name, owner, group string) error {
+	if err := h.hook(ctx, "chown", name); err != nil {
+		return internal.Decorate("chown", name, err)
+	}
+	return h.PassthroughFS.Chown(ctx, name, owner, group)
+}
+
+func (h *hookFS) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	if err := h.hook(ctx, "chmod", name); err != nil {
+		return internal.Decorate("chmod", name, err)
+	}
+	return h.PassthroughFS.Chmod(ctx, name, mode)
+}
+
+func (h *hookFS) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	if err := h.hook(ctx, "chtimes", name); err != nil {
+		return internal.Decorate("chtimes", name, err)
+	}
+	return h.PassthroughFS.Chtimes(ctx, name, atime, mtime)
+}
+
+func (h *hookFS) Truncate(ctx context.Context, name string, size int64) error {
+	if err := h.hook(ctx, "truncate", name); err != nil {
+		return internal.Decorate("truncate", name, err)
+	}
+	return h.PassthroughFS.Truncate(ctx, name, size)
+}
+
+func (h *hookFS) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	if err := h.hook(ctx, "writefile", name); err != nil {
+		return internal.Decorate("writefile", name, err)
+	}
+	return h.PassthroughFS.WriteFile(ctx, name, data, perm)
+}
+
+func (h *hookFS) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	if err := h.hook(ctx, "rename", oldname, newname); err != nil {
+		return internal.DecorateLink("rename", oldname, newname, err)
+	}
+	return h.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+}
+
+func (h *hookFS) Access(ctx context.Context, name string, mode uint32) error {
+	if err := h.hook(ctx, "access", name); err != nil {
+		return internal.Decorate("access", name, err)
+	}
+	return h.PassthroughFS.Access(ctx, name, mode)
+}
+
+func (h *hookFS) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	if err := h.hook(ctx, "readdir", name); err != nil {
+		return nil, internal.Decorate("readdir", name, err)
+	}
+	return h.PassthroughFS.ReadDirInfos(ctx, name)
+}
+
+func (h *hookFS) Glob(ctx context.Context, pattern string) ([]string, error) {
+	if err := h.hook(ctx, "glob", pattern); err != nil {
+		return nil, err
+	}
+	return h.PassthroughFS.Glob(ctx, pattern)
+}
+
+func (h *hookFS) GetXattr(ctx context.Context, name, attr string) ([]byte, error) {
+	if err := h.hook(ctx, "getxattr", name); err != nil {
+		return nil, internal.Decorate("getxattr", name, err)
+	}
+	return h.PassthroughFS.GetXattr(ctx, name, attr)
+}
+
+func (h *hookFS) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	if err := h.hook(ctx, "setxattr", name); err != nil {
+		return internal.Decorate("setxattr", name, err)
+	}
+	return h.PassthroughFS.SetXattr(ctx, name, attr, value, flags)
+}
+
+func (h *hookFS) ListXattr(ctx context.Context, name string) ([]string, error) {
+	if err := h.hook(ctx, "listxattr", name); err != nil {
+		return nil, internal.Decorate("listxattr", name, err)
+	}
+	return h.PassthroughFS.ListXattr(ctx, name)
+}
+
+func (h *hookFS) RemoveXattr(ctx context.Context, name, attr string) error {
+	if err := h.hook(ctx, "removexattr", name); err != nil {
+		return internal.Decorate("removexattr", name, err)
+	}
+	return h.PassthroughFS.RemoveXattr(ctx, name, attr)
+}
+
+// Latency configures NewLatencyFS.
+type Latency struct {
+	// Delay is how long each operation is delayed.
+	Delay time.Duration
+	// Jitter, if positive, adds a random delay of up to Jitter, drawn anew
+	// for each operation.
+	Jitter time.Duration
+	// Ops, if set, overrides Delay for the operations it names.
+	Ops map[string]time.Duration
+	// Clock is the clock timing the delays. If nil, contextual.RealClock is
+	// used. With a Clock of this package, operations wait for the test to
+	// advance it.
+	Clock contextual.Clock
+}
+
+// NewLatencyFS returns fsys with every operation delayed as set by latency,
+// for testing timeouts, hedging or the reporting of slow layers. An
+// operation whose context is done while it waits fails with the error of
+// the context, without reaching fsys.
+func NewLatencyFS(fsys contextual.FS, latency Latency) contextual.FileSystem {
+	clock := contextual.ClockOr(latency.Clock)
+	ops := maps.Clone(latency.Ops)
+	return &hookFS{
+		PassthroughFS: contextual.PassthroughFS{Inner: fsys},
+		hook: func(ctx context.Context, op string, names ...string) error {
+			delay, ok := ops[op]
+			if !ok {
+				delay = latency.Delay
+			}
+			if latency.Jitter > 0 {
+				delay += rand.N(latency.Jitter)
+			}
+			if delay <= 0 {
+				return ctx.Err()
+			}
+			timer := clock.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C():
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// Fault selects the operations NewErrorFS fails. An empty Op or Path matches
+// any operation or path.
+type Fault struct {
+	// Op is the operation, such as "open" or "stat".
+	Op string
+	// Path is the name the operation is applied to. Both names of Rename
+	// are matched.
+	Path string
+}
+
+// NewErrorFS returns fsys with the operations matching a key of faults
+// failing with its error, wrapped in an *fs.PathError or *os.LinkError,
+// without reaching fsys. A fault for both the operation and the path is
+// preferred over one for the path only, then over one for the operation
+// only.
+func NewErrorFS(fsys contextual.FS, faults map[Fault]error) contextual.FileSystem {
+	faults = maps.Clone(faults)
+	return &hookFS{
+		PassthroughFS: contextual.PassthroughFS{Inner: fsys},
+		hook: func(ctx context.Context, op string, names ...string) error {
+			var keys []Fault
+			for _, name := range names {
+				keys = append(keys, Fault{Op: op, Path: name})
+			}
+			for _, name := range names {
+				keys = append(keys, Fault{Path: name})
+			}
+			for _, key := range append(keys, Fault{Op: op}, Fault{}) {
+				if err, ok := faults[key]; ok {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// CountingFS is a contextual.FileSystem counting the operations forwarded
+// to the filesystem it wraps, for asserting that a layer caches, batches or
+// avoids calls without setting up a mock.
+type CountingFS struct {
+	hookFS
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewCountingFS returns a CountingFS wrapping fsys.
+func NewCountingFS(fsys contextual.FS) *CountingFS {
+	c := &CountingFS{counts: make(map[string]int)}
+	c.hookFS = hookFS{
+		PassthroughFS: contextual.PassthroughFS{Inner: fsys},
+		hook: func(ctx context.Context, op string, names ...string) error {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.counts[op]++
+			return nil
+		},
+	}
+	return c
+}
+
+// Count returns the number of calls to op.
+func (c *CountingFS) Count(op string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[op]
+}
+
+// Counts returns the number of calls to each operation called at least
+// once.
+func (c *CountingFS) Counts() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.counts)
+}
+
+// Reset sets the counts back to zero.
+func (c *CountingFS) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.counts)
+}
+
+var _ contextual.FileSystem = &hookFS{}
+var _ contextual.SpecialFS = &hookFS{}
+var _ contextual.FileSystem = &CountingFS{}
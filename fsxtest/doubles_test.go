@@ -0,0 +1,116 @@
+package fsxtest_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+func TestNewLatencyFS(t *testing.T) {
+	ctx := t.Context()
+	clock := fsxtest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	base := memfs.New(memfs.Config{})
+	if err := contextual.WriteFile(ctx, base, "a", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fsxtest.NewLatencyFS(base, fsxtest.Latency{
+		Delay: time.Second,
+		Ops:   map[string]time.Duration{"readfile": 0},
+		Clock: clock,
+	})
+
+	if data, err := contextual.ReadFile(ctx, fsys, "a"); err != nil || string(data) != "a" {
+		t.Errorf("ReadFile() = %q, %v; want it right away", data, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := contextual.Stat(ctx, fsys, "a")
+		done <- err
+	}()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("Stat() = %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := contextual.Stat(canceled, fsys, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Stat() with a canceled context = %v; want context.Canceled", err)
+	}
+
+	jittery := fsxtest.NewLatencyFS(base, fsxtest.Latency{Jitter: time.Millisecond})
+	if _, err := contextual.Stat(ctx, jittery, "a"); err != nil {
+		t.Errorf("Stat() = %v", err)
+	}
+}
+
+func TestNewErrorFS(t *testing.T) {
+	ctx := t.Context()
+	base := memfs.New(memfs.Config{})
+	for _, name := range []string{"a", "b", "c"} {
+		if err := contextual.WriteFile(ctx, base, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := fsxtest.NewErrorFS(base, map[fsxtest.Fault]error{
+		{Op: "stat", Path: "a"}: fs.ErrPermission,
+		{Path: "a"}:             fs.ErrInvalid,
+		{Op: "remove"}:          fs.ErrClosed,
+	})
+
+	if _, err := contextual.Stat(ctx, fsys, "a"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Stat(a) = %v; want ErrPermission", err)
+	}
+	if _, err := contextual.ReadFile(ctx, fsys, "a"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("ReadFile(a) = %v; want ErrInvalid", err)
+	}
+	var pathErr *fs.PathError
+	if err := contextual.Remove(ctx, fsys, "b"); !errors.As(err, &pathErr) || pathErr.Op != "remove" || !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Remove(b) = %v; want ErrClosed", err)
+	}
+	if err := contextual.Rename(ctx, fsys, "c", "a"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Rename(c, a) = %v; want ErrInvalid", err)
+	}
+	if data, err := contextual.ReadFile(ctx, fsys, "c"); err != nil || string(data) != "c" {
+		t.Errorf("ReadFile(c) = %q, %v; want it forwarded", data, err)
+	}
+	if layers := contextual.Unwrap(fsys); len(layers) != 1 || layers[0] != base {
+		t.Errorf("Unwrap() = %v; want the wrapped filesystem", layers)
+	}
+}
+
+func TestCountingFS(t *testing.T) {
+	ctx := t.Context()
+	fsys := fsxtest.NewCountingFS(memfs.New(memfs.Config{}))
+	if err := contextual.WriteFile(ctx, fsys, "a", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := contextual.OpenFile(ctx, fsys, "b", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	for range 2 {
+		if _, err := contextual.Stat(ctx, fsys, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fsys.Counts(); len(got) != 3 || got["writefile"] != 1 || got["open"] != 1 || got["stat"] != 2 {
+		t.Errorf("Counts() = %v", got)
+	}
+	fsys.Reset()
+	if got := fsys.Count("stat"); got != 0 {
+		t.Errorf("Count(stat) = %d after Reset; want 0", got)
+	}
+}
@@ -0,0 +1,403 @@
+// Package fsxtest provides cheap, targeted test helpers for filesystem
+// implementations: assertions on which optional fsx interfaces a filesystem
+// exposes, checks of a few behaviors every backend is expected to share, and
+// test doubles delaying, failing or counting the operations of a filesystem.
+package fsxtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// Capability is a set of optional filesystem interfaces. Capabilities can be
+// combined with the | operator.
+type Capability uint64
+
+const (
+	// Writer is fsx.WriterFS or contextual.WriterFS.
+	Writer Capability = 1 << iota
+	// ReadFile is fs.ReadFileFS or contextual.ReadFileFS.
+	ReadFile
+	// ReadDir is fs.ReadDirFS or contextual.ReadDirFS.
+	ReadDir
+	// Stat is fs.StatFS or contextual.StatFS.
+	Stat
+	// ReadLink is fs.ReadLinkFS or contextual.ReadLinkFS.
+	ReadLink
+	// Truncate is fsx.TruncateFS or contextual.TruncateFS.
+	Truncate
+	// WriteFile is fsx.WriteFileFS or contextual.WriteFileFS.
+	WriteFile
+	// Dir is fsx.DirFS or contextual.DirFS.
+	Dir
+	// MkdirAll is fsx.MkdirAllFS or contextual.MkdirAllFS.
+	MkdirAll
+	// RemoveAll is fsx.RemoveAllFS or contextual.RemoveAllFS.
+	RemoveAll
+	// Rename is fsx.RenameFS or contextual.RenameFS.
+	Rename
+	// Symlink is fsx.SymlinkFS or contextual.SymlinkFS.
+	Symlink
+	// Lchown is fsx.LchownFS or contextual.LchownFS.
+	Lchown
+	// Change is fsx.ChangeFS or contextual.ChangeFS.
+	Change
+	// Access is fsx.AccessFS or contextual.AccessFS.
+	Access
+	// Special is fsx.SpecialFS or contextual.SpecialFS.
+	Special
+	// Closer is fsx.CloserFS or contextual.CloserFS.
+	Closer
+	// Xattr is fsx.XattrFS or contextual.XattrFS.
+	Xattr
+)
+
+// FileSystem is the set of capabilities making up fsx.FileSystem and
+// contextual.FileSystem.
+const FileSystem = Writer | ReadFile | ReadDir | Stat | ReadLink | Truncate |
+	WriteFile | Dir | MkdirAll | RemoveAll | Rename | Symlink | Lchown | Change
+
+// capabilities describes each Capability, in bit order.
+var capabilities = []struct {
+	name string
+	is   func(fsys any) bool
+}{
+	{"Writer", either[fsx.WriterFS, contextual.WriterFS]},
+	{"ReadFile", either[fs.ReadFileFS, contextual.ReadFileFS]},
+	{"ReadDir", either[fs.ReadDirFS, contextual.ReadDirFS]},
+	{"Stat", either[fs.StatFS, contextual.StatFS]},
+	{"ReadLink", either[fs.ReadLinkFS, contextual.ReadLinkFS]},
+	{"Truncate", either[fsx.TruncateFS, contextual.TruncateFS]},
+	{"WriteFile", either[fsx.WriteFileFS, contextual.WriteFileFS]},
+	{"Dir", either[fsx.DirFS, contextual.DirFS]},
+	{"MkdirAll", either[fsx.MkdirAllFS, contextual.MkdirAllFS]},
+	{"RemoveAll", either[fsx.RemoveAllFS, contextual.RemoveAllFS]},
+	{"Rename", either[fsx.RenameFS, contextual.RenameFS]},
+	{"Symlink", either[fsx.SymlinkFS, contextual.SymlinkFS]},
+	{"Lchown", either[fsx.LchownFS, contextual.LchownFS]},
+	{"Change", either[fsx.ChangeFS, contextual.ChangeFS]},
+	{"Access", either[fsx.AccessFS, contextual.AccessFS]},
+	{"Special", either[fsx.SpecialFS, contextual.SpecialFS]},
+	{"Closer", either[fsx.CloserFS, contextual.CloserFS]},
+	{"Xattr", either[fsx.XattrFS, contextual.XattrFS]},
+}
+
+// either reports whether v implements P or C.
+func either[P, C any](v any) bool {
+	if _, ok := v.(P); ok {
+		return true
+	}
+	_, ok := v.(C)
+	return ok
+}
+
+// String returns the names of the capabilities in c joined by "|".
+func (c Capability) String() string {
+	var names []string
+	for i, desc := range capabilities {
+		if c&(1<<i) != 0 {
+			names = append(names, desc.name)
+		}
+	}
+	if rest := c >> len(capabilities); rest != 0 {
+		names = append(names, "Unknown")
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, "|")
+}
+
+// Implements returns the capabilities of fsys, which may be either an fs.FS
+// or a contextual.FS.
+func Implements(fsys any) Capability {
+	var c Capability
+	for i, desc := range capabilities {
+		if desc.is(fsys) {
+			c |= 1 << i
+		}
+	}
+	return c
+}
+
+// AssertImplements reports an error listing the capabilities in want that
+// fsys does not implement.
+func AssertImplements(t testing.TB, fsys any, want Capability) {
+	t.Helper()
+	if missing := want &^ Implements(fsys); missing != 0 {
+		t.Errorf("%T does not implement %v", fsys, missing)
+	}
+}
+
+// AssertNotImplements reports an error listing the capabilities in unwanted
+// that fsys implements. It is useful to make sure a wrapper does not expose
+// surfaces its backend lacks.
+func AssertNotImplements(t testing.TB, fsys any, unwanted Capability) {
+	t.Helper()
+	if extra := unwanted & Implements(fsys); extra != 0 {
+		t.Errorf("%T unexpectedly implements %v", fsys, extra)
+	}
+}
+
+// CheckNotExist checks that opening and statting the missing file name fail
+// with an *fs.PathError that names the path and wraps fs.ErrNotExist.
+func CheckNotExist(t testing.TB, fsys fs.FS, name string) {
+	t.Helper()
+	if f, err := fsys.Open(name); err == nil {
+		_ = f.Close()
+		t.Errorf("Open(%q): expected an error", name)
+	} else {
+		checkPathError(t, "Open", name, err, fs.ErrNotExist)
+	}
+	if _, err := fs.Stat(fsys, name); err == nil {
+		t.Errorf("Stat(%q): expected an error", name)
+	} else {
+		checkPathError(t, "Stat", name, err, fs.ErrNotExist)
+	}
+}
+
+// CheckCreateExclusive checks flag handling of OpenFile with os.O_EXCL:
+// creating the missing file name succeeds, and creating it again fails with
+// an *fs.PathError wrapping fs.ErrExist. The file is left in place.
+func CheckCreateExclusive(t testing.TB, fsys fs.FS, name string) {
+	t.Helper()
+	flag := os.O_RDWR | os.O_CREATE | os.O_EXCL
+	f, err := fsx.OpenFile(fsys, name, flag, 0644)
+	if err != nil {
+		t.Errorf("OpenFile(%q, O_CREATE|O_EXCL): %v", name, err)
+		return
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("Close(%q): %v", name, err)
+	}
+
+	if f, err := fsx.OpenFile(fsys, name, flag, 0644); err == nil {
+		_ = f.Close()
+		t.Errorf("OpenFile(%q, O_CREATE|O_EXCL) on an existing file: expected an error", name)
+	} else {
+		checkPathError(t, "OpenFile", name, err, fs.ErrExist)
+	}
+}
+
+// CheckAppend checks that writes to a file opened with O_APPEND go to its
+// end: name is created with O_WRONLY|O_CREATE|O_APPEND and written twice,
+// seeking back to its start in between if the handle can seek, then opened
+// again with O_APPEND and written once more. The parent directory of name
+// must exist and name must not.
+func CheckAppend(t testing.TB, fsys fs.FS, name string) {
+	t.Helper()
+	write := func(flag int, data ...string) bool {
+		f, err := fsx.OpenFile(fsys, name, flag, 0644)
+		if err != nil {
+			t.Errorf("OpenFile(%q, O_APPEND): %v", name, err)
+			return false
+		}
+		defer func() { _ = f.Close() }()
+		for i, d := range data {
+			if s, ok := f.(io.Seeker); ok && i > 0 {
+				if _, err := s.Seek(0, io.SeekStart); err != nil {
+					t.Errorf("Seek(%q): %v", name, err)
+				}
+			}
+			if _, err := f.Write([]byte(d)); err != nil {
+				t.Errorf("Write(%q) with O_APPEND: %v", name, err)
+				return false
+			}
+		}
+		return true
+	}
+	if !write(os.O_WRONLY|os.O_CREATE|os.O_APPEND, "a", "b") || !write(os.O_WRONLY|os.O_APPEND, "c") {
+		return
+	}
+	if data, err := fs.ReadFile(fsys, name); err != nil || string(data) != "abc" {
+		t.Errorf("ReadFile(%q) after appending a, b and c = %q, %v; want abc", name, data, err)
+	}
+}
+
+// CheckReadOnlyHandle checks that writing to and truncating the existing
+// file name through a read-only handle fail.
+func CheckReadOnlyHandle(t testing.TB, fsys fs.FS, name string) {
+	t.Helper()
+	f, err := fsx.OpenFile(fsys, name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Errorf("OpenFile(%q, O_RDONLY): %v", name, err)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Errorf("Write(%q) on a read-only handle: expected an error", name)
+	}
+	if err := f.Truncate(0); err == nil {
+		t.Errorf("Truncate(%q) on a read-only handle: expected an error", name)
+	}
+}
+
+// CheckErrorOps checks that the methods of fsys fail on the missing file
+// name with errors of the canonical type and Op: an *fs.PathError naming the
+// path as passed, with Op "open", "stat", "lstat", "readdir", "readfile",
+// "readlink", "remove", "mkdir", "truncate", "writefile", "chmod", "chtimes"
+// or "access", or an *os.LinkError with Op "rename" or "symlink". The errors
+// must wrap fs.ErrNotExist. Methods are called directly rather than through
+// the contextual helpers, which would decorate their errors, and those of
+// optional interfaces that fsys does not implement are skipped.
+//
+// Operations creating files do so beneath name, so name's parent directory
+// must exist and name must not.
+func CheckErrorOps(t testing.TB, fsys contextual.FS, name string) {
+	t.Helper()
+	checkOps(t, fsys, name, path.Join(name, "child"), fs.ErrNotExist)
+}
+
+// CheckInvalidPaths checks that the methods of fsys reject names that are not
+// valid according to fs.ValidPath, such as "../x" or "/x", with errors of the
+// canonical type and Op, like CheckErrorOps, wrapping fs.ErrInvalid. The old
+// name of a symlink, which is the target of the link, is not checked.
+func CheckInvalidPaths(t testing.TB, fsys contextual.FS) {
+	t.Helper()
+	for _, name := range []string{"../escape", "/absolute", "dir/../file", "dir//file", "./file", ""} {
+		checkOps(t, fsys, name, name+"/child", fs.ErrInvalid)
+	}
+}
+
+// CheckErrorMapper checks that mapper translates the errors of a backend as
+// contextual.ErrorMapper requires: nil stays nil, errors already wrapping a
+// sentinel of io/fs or a context error keep it, and each error of cases,
+// such as the "not found" error of an SDK, is translated into an error
+// wrapping the value it is mapped to, and still wrapping the original one.
+func CheckErrorMapper(t testing.TB, mapper contextual.ErrorMapper, cases map[error]error) {
+	t.Helper()
+	if err := mapper(nil); err != nil {
+		t.Errorf("mapper(nil) = %v; want nil", err)
+	}
+	for _, target := range []error{fs.ErrNotExist, fs.ErrExist, fs.ErrPermission, fs.ErrInvalid, context.Canceled} {
+		err := &fs.PathError{Op: "open", Path: "file", Err: target}
+		if got := mapper(err); !errors.Is(got, target) {
+			t.Errorf("mapper(%v) = %v; want an error wrapping %v", err, got, target)
+		}
+	}
+	for err, target := range cases {
+		got := mapper(err)
+		if !errors.Is(got, target) {
+			t.Errorf("mapper(%v) = %v; want an error wrapping %v", err, got, target)
+		}
+		if !errors.Is(got, err) {
+			t.Errorf("mapper(%v) = %v; want the original error kept", err, got)
+		}
+	}
+}
+
+// checkOps checks that the methods of fsys fail on name, or on child for
+// operations creating files, with errors of the canonical type and Op
+// wrapping target.
+func checkOps(t testing.TB, fsys contextual.FS, name, child string, target error) {
+	t.Helper()
+	ctx := t.Context()
+
+	check := func(op, name string, err error) {
+		t.Helper()
+		var pErr *fs.PathError
+		if !errors.As(err, &pErr) {
+			t.Errorf("%s(%q): expected *fs.PathError, got %T: %v", op, name, err, err)
+			return
+		}
+		if pErr.Op != op {
+			t.Errorf("%s(%q): PathError.Op = %q", op, name, pErr.Op)
+		}
+		checkPathError(t, op, name, err, target)
+	}
+	checkLink := func(op, oldname, newname string, err error) {
+		t.Helper()
+		var lErr *os.LinkError
+		if !errors.As(err, &lErr) {
+			t.Errorf("%s(%q, %q): expected *os.LinkError, got %T: %v", op, oldname, newname, err, err)
+			return
+		}
+		if lErr.Op != op || lErr.Old != oldname || lErr.New != newname {
+			t.Errorf("%s(%q, %q): got LinkError %q %q %q", op, oldname, newname, lErr.Op, lErr.Old, lErr.New)
+		}
+		if !errors.Is(err, target) {
+			t.Errorf("%s(%q, %q): expected error wrapping %v, got %v", op, oldname, newname, target, err)
+		}
+	}
+	closeFile := func(f io.Closer, err error) error {
+		if err == nil {
+			_ = f.Close()
+		}
+		return err
+	}
+
+	check("open", name, closeFile(fsys.Open(ctx, name)))
+	if fsys, ok := fsys.(contextual.WriterFS); ok {
+		check("open", name, closeFile(fsys.OpenFile(ctx, name, os.O_RDONLY, 0)))
+		check("open", child, closeFile(fsys.Create(ctx, child)))
+		check("remove", name, fsys.Remove(ctx, name))
+	}
+	if fsys, ok := fsys.(contextual.StatFS); ok {
+		_, err := fsys.Stat(ctx, name)
+		check("stat", name, err)
+	}
+	if fsys, ok := fsys.(contextual.ReadLinkFS); ok {
+		_, err := fsys.Lstat(ctx, name)
+		check("lstat", name, err)
+		_, err = fsys.ReadLink(ctx, name)
+		check("readlink", name, err)
+	}
+	if fsys, ok := fsys.(contextual.ReadDirFS); ok {
+		_, err := fsys.ReadDir(ctx, name)
+		check("readdir", name, err)
+	}
+	if fsys, ok := fsys.(contextual.ReadFileFS); ok {
+		_, err := fsys.ReadFile(ctx, name)
+		check("readfile", name, err)
+	}
+	if fsys, ok := fsys.(contextual.DirFS); ok {
+		check("mkdir", child, fsys.Mkdir(ctx, child, 0755))
+	}
+	if fsys, ok := fsys.(contextual.TruncateFS); ok {
+		check("truncate", name, fsys.Truncate(ctx, name, 0))
+	}
+	if fsys, ok := fsys.(contextual.WriteFileFS); ok {
+		check("writefile", child, fsys.WriteFile(ctx, child, nil, 0644))
+	}
+	if fsys, ok := fsys.(contextual.ChangeFS); ok {
+		check("chmod", name, fsys.Chmod(ctx, name, 0644))
+		check("chtimes", name, fsys.Chtimes(ctx, name, time.Now(), time.Now()))
+	}
+	if fsys, ok := fsys.(contextual.AccessFS); ok {
+		check("access", name, fsys.Access(ctx, name, 0))
+	}
+	if fsys, ok := fsys.(contextual.RenameFS); ok {
+		renamed := name + ".renamed"
+		checkLink("rename", name, renamed, fsys.Rename(ctx, name, renamed))
+	}
+	if fsys, ok := fsys.(contextual.SymlinkFS); ok {
+		checkLink("symlink", "target", child, fsys.Symlink(ctx, "target", child))
+	}
+}
+
+// checkPathError checks that err is an *fs.PathError for name wrapping target.
+func checkPathError(t testing.TB, op, name string, err, target error) {
+	t.Helper()
+	var pErr *fs.PathError
+	if !errors.As(err, &pErr) {
+		t.Errorf("%s(%q): expected *fs.PathError, got %T: %v", op, name, err, err)
+		return
+	}
+	if pErr.Path != name {
+		t.Errorf("%s(%q): PathError.Path = %q", op, name, pErr.Path)
+	}
+	if !errors.Is(err, target) {
+		t.Errorf("%s(%q): expected error wrapping %v, got %v", op, name, target, err)
+	}
+}
@@ -0,0 +1,173 @@
+package fsxtest_test
+
+import (
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// recorder is a testing.TB that records reported errors.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestImplements(t *testing.T) {
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// os.Root cannot truncate by name, so osfs relies on the OpenFile fallback.
+	fsxtest.AssertImplements(t, fsys, fsxtest.FileSystem&^fsxtest.Truncate|fsxtest.Access|fsxtest.Special|fsxtest.Closer)
+	fsxtest.AssertNotImplements(t, fsys, fsxtest.Truncate)
+	fsxtest.AssertImplements(t, contextual.ToContextual(fsys), fsxtest.FileSystem|fsxtest.Access|fsxtest.Special|fsxtest.Closer)
+	fsxtest.AssertImplements(t, contextual.FromContextual(contextual.ToContextual(fsys), t.Context()), fsxtest.FileSystem)
+
+	if got := fsxtest.Implements(fstest.MapFS{}); got != fsxtest.ReadFile|fsxtest.ReadDir|fsxtest.Stat|fsxtest.ReadLink {
+		t.Errorf("unexpected capabilities of MapFS: %v", got)
+	}
+
+	r := &recorder{TB: t}
+	fsxtest.AssertImplements(r, fstest.MapFS{}, fsxtest.Writer|fsxtest.Stat|fsxtest.Symlink)
+	if len(r.errors) != 1 {
+		t.Fatalf("expected one error, got %v", r.errors)
+	}
+	if want := "fstest.MapFS does not implement Writer|Symlink"; r.errors[0] != want {
+		t.Errorf("got %q, want %q", r.errors[0], want)
+	}
+
+	r = &recorder{TB: t}
+	fsxtest.AssertNotImplements(r, fstest.MapFS{}, fsxtest.Writer|fsxtest.Stat)
+	if len(r.errors) != 1 {
+		t.Errorf("expected one error, got %v", r.errors)
+	}
+}
+
+func TestCapability_String(t *testing.T) {
+	for c, want := range map[fsxtest.Capability]string{
+		0:                            "0",
+		fsxtest.Writer:               "Writer",
+		fsxtest.Dir | fsxtest.Change: "Dir|Change",
+		fsxtest.Special | 1<<63:      "Special|Unknown",
+	} {
+		if got := c.String(); got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestChecks(t *testing.T) {
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsxtest.CheckNotExist(t, fsys, "missing")
+	fsxtest.CheckCreateExclusive(t, fsys, "new")
+	fsxtest.CheckReadOnlyHandle(t, fsys, "new")
+	fsxtest.CheckAppend(t, fsys, "appended")
+
+	mapfs := fstest.MapFS{"file": {Data: []byte("data")}}
+	fsxtest.CheckNotExist(t, mapfs, "missing")
+	fsxtest.CheckReadOnlyHandle(t, mapfs, "file")
+
+	r := &recorder{TB: t}
+	fsxtest.CheckNotExist(r, mapfs, "file")
+	if len(r.errors) != 2 {
+		t.Errorf("expected errors for Open and Stat, got %v", r.errors)
+	}
+
+	r = &recorder{TB: t}
+	fsxtest.CheckCreateExclusive(r, mapfs, "new")
+	if len(r.errors) != 1 {
+		t.Errorf("expected an error for the read-only filesystem, got %v", r.errors)
+	}
+
+	r = &recorder{TB: t}
+	fsxtest.CheckAppend(r, mapfs, "new")
+	if len(r.errors) != 1 {
+		t.Errorf("expected an error for the read-only filesystem, got %v", r.errors)
+	}
+}
+
+func TestCheckErrorOps(t *testing.T) {
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// os.Root reports backend-specific ops such as "statat".
+	r := &recorder{TB: t}
+	fsxtest.CheckErrorOps(r, contextual.ToContextual(fsys), "missing")
+	if len(r.errors) == 0 {
+		t.Error("expected errors for the undecorated filesystem")
+	}
+}
+
+func TestCheckInvalidPaths(t *testing.T) {
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// os.Root rejects the names with its own errors.
+	r := &recorder{TB: t}
+	fsxtest.CheckInvalidPaths(r, contextual.ToContextual(fsys))
+	if len(r.errors) == 0 {
+		t.Error("expected errors for the undecorated filesystem")
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := fsxtest.NewClock(start)
+
+	fired := func(timer contextual.Timer) bool {
+		select {
+		case <-timer.C():
+			return true
+		default:
+			return false
+		}
+	}
+
+	short := clock.NewTimer(time.Second)
+	long := clock.NewTimer(time.Minute)
+	stopped := clock.NewTimer(time.Second)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Stop() should report only the first stop")
+	}
+	if clock.Timers() != 2 {
+		t.Errorf("Timers() = %d, want 2", clock.Timers())
+	}
+
+	clock.Advance(time.Second)
+	if got := clock.Now(); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("Now() = %v", got)
+	}
+	if !fired(short) || fired(long) || fired(stopped) {
+		t.Error("only the short timer should fire")
+	}
+	if short.Reset(time.Second) {
+		t.Error("Reset() of a fired timer should report it inactive")
+	}
+
+	clock.Set(start.Add(time.Hour))
+	if !fired(short) || !fired(long) || clock.Timers() != 0 {
+		t.Error("all timers should fire")
+	}
+	if !fired(clock.NewTimer(0)) {
+		t.Error("a timer for no duration should fire right away")
+	}
+}
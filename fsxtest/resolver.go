@@ -0,0 +1,56 @@
+package fsxtest
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// Resolver is a contextual.Resolver backed by maps, for testing code that
+// translates identities without depending on the user database of the host.
+type Resolver struct {
+	// Users maps user names to ids.
+	Users map[string]string
+	// Groups maps group names to ids.
+	Groups map[string]string
+}
+
+// UserID returns the id of the user named name.
+func (r Resolver) UserID(ctx context.Context, name string) (string, error) {
+	return lookup(r.Users, "user", name)
+}
+
+// GroupID returns the id of the group named name.
+func (r Resolver) GroupID(ctx context.Context, name string) (string, error) {
+	return lookup(r.Groups, "group", name)
+}
+
+// UserName returns the name of the user with the given id.
+func (r Resolver) UserName(ctx context.Context, id string) (string, error) {
+	return reverse(r.Users, "user id", id)
+}
+
+// GroupName returns the name of the group with the given id.
+func (r Resolver) GroupName(ctx context.Context, id string) (string, error) {
+	return reverse(r.Groups, "group id", id)
+}
+
+func lookup(m map[string]string, kind, key string) (string, error) {
+	if v, ok := m[key]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown %s %q: %w", kind, key, fs.ErrNotExist)
+}
+
+func reverse(m map[string]string, kind, value string) (string, error) {
+	for k, v := range m {
+		if v == value {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown %s %q: %w", kind, value, fs.ErrNotExist)
+}
+
+var _ contextual.Resolver = Resolver{}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"io/fs"
+)
+
+// Access mode bits, matching the values used by access(2).
+const (
+	// F_OK tests for the existence of the file.
+	F_OK = 0x0
+	// X_OK tests for execute (or search) permission.
+	X_OK = 0x1
+	// W_OK tests for write permission.
+	W_OK = 0x2
+	// R_OK tests for read permission.
+	R_OK = 0x4
+)
+
+// CheckAccess reports whether the permission bits of info grant the access
+// described by mode (a combination of R_OK, W_OK and X_OK).
+//
+// Since the identity of the caller is not known, it uses the owner class of
+// the permission bits, i.e. it answers as if the caller owned the file.
+// It returns fs.ErrPermission if any of the requested bits is missing.
+func CheckAccess(info fs.FileInfo, mode uint32) error {
+	perm := uint32(info.Mode().Perm()>>6) & 0x7
+	if mode&0x7&^perm != 0 {
+		return fs.ErrPermission
+	}
+	return nil
+}
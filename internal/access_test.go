@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheckAccess(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rw":  {Mode: 0640},
+		"rx":  {Mode: 0500},
+		"dir": {Mode: fs.ModeDir | 0700},
+	}
+
+	for _, tt := range []struct {
+		name string
+		mode uint32
+		ok   bool
+	}{
+		{"rw", F_OK, true},
+		{"rw", R_OK | W_OK, true},
+		{"rw", X_OK, false},
+		{"rx", R_OK | X_OK, true},
+		{"rx", W_OK, false},
+		{"dir", R_OK | W_OK | X_OK, true},
+	} {
+		info, err := fs.Stat(fsys, tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CheckAccess(info, tt.mode)
+		if tt.ok && err != nil {
+			t.Errorf("CheckAccess(%s, %#x) = %v, want nil", tt.name, tt.mode, err)
+		}
+		if !tt.ok && !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("CheckAccess(%s, %#x) = %v, want ErrPermission", tt.name, tt.mode, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// appendFile emulates O_APPEND on a file opened without it: every write
+// moves to the end of the file first. The mutex keeps the seek and the write
+// of a call together, so that writes and seeks through the handle cannot
+// interleave between them.
+type appendFile struct {
+	File
+	mu sync.Mutex
+}
+
+// Write writes p at the end of the file.
+func (f *appendFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.File.(io.Seeker).Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+// Seek sets the offset of the next read; writes still go to the end.
+func (f *appendFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.File.(io.Seeker).Seek(offset, whence)
+}
+
+// Restat describes the file as it is now.
+func (f *appendFile) Restat() (fs.FileInfo, error) {
+	return Restat(f.File)
+}
+
+// EmulateAppend returns file, opened without O_APPEND, wrapped so that its
+// writes append to the file as if it had been opened with it. The file must
+// implement io.Seeker; otherwise EmulateAppend returns errors.ErrUnsupported.
+//
+// Each write seeks to the end of the file before writing, under a lock held
+// by the handle. Unlike a native O_APPEND, this does not make writes through
+// other handles of the file atomic with respect to each other.
+func EmulateAppend(file File) (File, error) {
+	if _, ok := file.(io.Seeker); !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return WrapFile(&appendFile{File: file}, file), nil
+}
+
+// OpenAppend calls open with flag, and if it fails with
+// errors.ErrUnsupported while flag includes O_APPEND, calls it again without
+// O_APPEND and emulates it with EmulateAppend. Helpers opening files use it
+// so that O_APPEND behaves the same on backends that can write but not
+// append.
+func OpenAppend(name string, flag int, open func(flag int) (File, error)) (File, error) {
+	f, err := open(flag)
+	if flag&os.O_APPEND == 0 || !errors.Is(err, errors.ErrUnsupported) {
+		return f, err
+	}
+	f, err = open(flag &^ os.O_APPEND)
+	if err != nil {
+		return nil, err
+	}
+	af, err := EmulateAppend(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return af, nil
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"io/fs"
+	"strings"
+)
+
+// Attributes is a set of file attributes in the sense of Windows: flags kept
+// next to, but apart from, the permission bits.
+type Attributes uint32
+
+const (
+	// AttrReadOnly marks a file that cannot be written.
+	AttrReadOnly Attributes = 1 << iota
+	// AttrHidden marks a file that is not listed by default.
+	AttrHidden
+	// AttrSystem marks a file used by the operating system.
+	AttrSystem
+	// AttrArchive marks a file that changed since it was last backed up.
+	AttrArchive
+	// AttrReparsePoint marks a reparse point, such as a symbolic link or a
+	// junction.
+	AttrReparsePoint
+)
+
+// attributeNames are the names of the attributes, in bit order.
+var attributeNames = []string{"readonly", "hidden", "system", "archive", "reparse-point"}
+
+// String returns the names of the attributes in a joined by "|".
+func (a Attributes) String() string {
+	var names []string
+	for i, name := range attributeNames {
+		if a&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, "|")
+}
+
+// AttributeInfo is implemented by FileInfo values that know the attributes of
+// the file they describe. FileInfo values returned by ExtendFileInfo
+// implement it.
+type AttributeInfo interface {
+	fs.FileInfo
+
+	// Attributes returns the attributes of the file.
+	Attributes() Attributes
+}
+
+// FileAttributes returns the attributes of the file described by info. If
+// info does not implement AttributeInfo, they are derived from its name and
+// mode by deriveAttributes.
+func FileAttributes(info fs.FileInfo) Attributes {
+	if ai, ok := info.(AttributeInfo); ok {
+		return ai.Attributes()
+	}
+	return deriveAttributes(info)
+}
+
+// deriveAttributes returns the attributes systems without native attributes
+// imply: dot files are hidden, files without write permission are read-only,
+// and symbolic links are reparse points.
+func deriveAttributes(info fs.FileInfo) Attributes {
+	var a Attributes
+	if name := info.Name(); strings.HasPrefix(name, ".") && name != "." && name != ".." {
+		a |= AttrHidden
+	}
+	if info.Mode().Perm()&0222 == 0 {
+		a |= AttrReadOnly
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		a |= AttrReparsePoint
+	}
+	return a
+}
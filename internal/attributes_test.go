@@ -0,0 +1,64 @@
+package internal_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx"
+)
+
+func TestFileAttributes(t *testing.T) {
+	fsys := fstest.MapFS{
+		".hidden":  {Mode: 0644},
+		"readonly": {Mode: 0444},
+		"link":     {Mode: fs.ModeSymlink | 0777, Data: []byte("readonly")},
+		"plain":    {Mode: 0644},
+	}
+	for name, want := range map[string]fsx.Attributes{
+		".hidden":  fsx.AttrHidden,
+		"readonly": fsx.AttrReadOnly,
+		"link":     fsx.AttrReparsePoint,
+		"plain":    0,
+	} {
+		info, err := fs.Lstat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fsx.FileAttributes(info); got != want {
+			t.Errorf("FileAttributes(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	if got, want := (fsx.AttrReadOnly | fsx.AttrHidden | fsx.AttrReparsePoint).String(), "readonly|hidden|reparse-point"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := fsx.Attributes(0).String(); got != "0" {
+		t.Errorf("String() = %q, want \"0\"", got)
+	}
+}
+
+func TestExtendFileInfo_Attributes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("attributes are stored on Windows")
+	}
+	name := filepath.Join(t.TempDir(), ".config")
+	if err := os.WriteFile(name, nil, 0444); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xfi := fsx.ExtendFileInfo(fi)
+	if _, ok := xfi.(fsx.AttributeInfo); !ok {
+		t.Fatalf("%T does not implement AttributeInfo", xfi)
+	}
+	if got, want := fsx.FileAttributes(xfi), fsx.AttrHidden|fsx.AttrReadOnly; got != want {
+		t.Errorf("FileAttributes() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBufferSize is the size of the buffers handed out by GetBuffer
+// unless changed with SetBufferSize.
+const DefaultBufferSize = 32 * 1024
+
+// bufferSize is the size set with SetBufferSize, or zero for
+// DefaultBufferSize.
+var bufferSize atomic.Int64
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, BufferSize())
+		return &b
+	},
+}
+
+// BufferSize returns the size of the buffers handed out by GetBuffer.
+func BufferSize() int {
+	if n := bufferSize.Load(); n > 0 {
+		return int(n)
+	}
+	return DefaultBufferSize
+}
+
+// SetBufferSize sets the size of the buffers handed out by GetBuffer. Values
+// less than 1 select DefaultBufferSize. Buffers of the previous size still
+// in use are dropped when they are handed back.
+func SetBufferSize(n int) {
+	bufferSize.Store(int64(max(n, 0)))
+}
+
+// GetBuffer returns a buffer of BufferSize bytes from a shared pool. It must
+// be handed back with PutBuffer once it is no longer used.
+func GetBuffer() *[]byte {
+	b := bufferPool.Get().(*[]byte)
+	if size := BufferSize(); len(*b) != size {
+		*b = make([]byte, size)
+	}
+	return b
+}
+
+// PutBuffer returns a buffer obtained from GetBuffer to the pool.
+func PutBuffer(b *[]byte) {
+	if len(*b) == BufferSize() {
+		bufferPool.Put(b)
+	}
+}
+
+// Copy copies from src to dst like io.Copy, using a pooled buffer instead of
+// allocating one for every call.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	b := GetBuffer()
+	defer PutBuffer(b)
+	return io.CopyBuffer(dst, src, *b)
+}
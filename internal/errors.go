@@ -21,6 +21,10 @@ var (
 	// ErrIsDir is returned when a file operation is requested on a directory.
 	// It is an alias for syscall.EISDIR.
 	ErrIsDir = syscall.EISDIR
+
+	// ErrReadOnly is returned when a change is requested on a read-only
+	// filesystem. It is an alias for syscall.EROFS.
+	ErrReadOnly = syscall.EROFS
 )
 
 func underlyingError(err error) error {
@@ -55,6 +59,29 @@ func IntoLinkErr(op, oldpath, newpath string, err error) error {
 	return &os.LinkError{Op: op, Old: oldpath, New: newpath, Err: underlyingError(err)}
 }
 
+// Decorate returns err as an *fs.PathError carrying the canonical name of
+// the operation, such as "open", "stat" or "readdir", and the path as passed
+// by the caller. Layers use it at the boundary of their methods so that
+// callers can rely on the type and Op of the errors whatever the backend:
+// bare errors are wrapped, and the path or link errors of the layers below,
+// whose Op may be backend-specific (e.g. "statat") and whose Path may have
+// been translated, are wrapped again. It returns nil if err is nil.
+func Decorate(op, name string, err error) error {
+	if pErr, ok := err.(*fs.PathError); ok && pErr.Op == op && pErr.Path == name {
+		return err
+	}
+	return IntoPathErr(op, name, err)
+}
+
+// DecorateLink is Decorate for operations on two paths, such as "rename" and
+// "symlink", returning an *os.LinkError.
+func DecorateLink(op, oldname, newname string, err error) error {
+	if lErr, ok := err.(*os.LinkError); ok && lErr.Op == op && lErr.Old == oldname && lErr.New == newname {
+		return err
+	}
+	return IntoLinkErr(op, oldname, newname, err)
+}
+
 // IsInvalid checks if the provided error represents an invalid operation or path.
 func IsInvalid(err error) bool {
 	if err == nil {
@@ -73,6 +100,23 @@ func IsInvalid(err error) bool {
 	return false
 }
 
+// IsNotDir reports whether err indicates that a directory was expected.
+func IsNotDir(err error) bool {
+	return errors.Is(err, ErrNotDir)
+}
+
+// IsIsDir reports whether err indicates that a file other than a directory
+// was expected.
+func IsIsDir(err error) bool {
+	return errors.Is(err, ErrIsDir)
+}
+
+// IsReadOnly reports whether err indicates that the filesystem refused a
+// change because it is read-only.
+func IsReadOnly(err error) bool {
+	return errors.Is(err, ErrReadOnly)
+}
+
 // IsUnsupported checks if the provided error indicates that an operation is not supported.
 func IsUnsupported(err error) bool {
 	if err == nil {
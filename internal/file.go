@@ -49,6 +49,25 @@ func (r ReadOnlyFile) ReadAt(p []byte, off int64) (n int, err error) {
 	return 0, errors.ErrUnsupported
 }
 
+// ReadDir implements fs.ReadDirFile if the underlying file supports it.
+func (r ReadOnlyFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d, ok := r.File.(fs.ReadDirFile); ok {
+		return d.ReadDir(n)
+	}
+	return nil, errors.ErrUnsupported
+}
+
+// WriteTo implements io.WriterTo, letting the underlying file write itself
+// if it supports it.
+func (r ReadOnlyFile) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r.File)
+}
+
+// Restat describes the underlying file as it is now.
+func (r ReadOnlyFile) Restat() (fs.FileInfo, error) {
+	return Restat(r.File)
+}
+
 // Seek implements io.Seeker if the underlying file supports it.
 func (r ReadOnlyFile) Seek(offset int64, whence int) (int64, error) {
 	if s, ok := r.File.(io.Seeker); ok {
@@ -36,6 +36,10 @@ type defaultFileInfo struct {
 	group      string
 	accessTime time.Time
 	changeTime time.Time
+	// attributes are the native attributes of the file, if sys reported
+	// them. Otherwise they are derived when asked for.
+	attributes    Attributes
+	hasAttributes bool
 }
 
 // Owner returns the owner name.
@@ -50,6 +54,14 @@ func (d *defaultFileInfo) AccessTime() time.Time { return d.accessTime }
 // ChangeTime returns the last status change time.
 func (d *defaultFileInfo) ChangeTime() time.Time { return d.changeTime }
 
+// Attributes returns the file attributes.
+func (d *defaultFileInfo) Attributes() Attributes {
+	if d.hasAttributes {
+		return d.attributes
+	}
+	return deriveAttributes(d.FileInfo)
+}
+
 // ExtendFileInfo returns a FileInfo that wraps the provided fs.FileInfo.
 //
 // It attempts to extract extended system-specific information from the underlying
@@ -3,7 +3,6 @@
 package internal
 
 import (
-	"os/user"
 	"strconv"
 	"syscall"
 	"time"
@@ -18,16 +17,16 @@ func fillFromSys(dfi *defaultFileInfo, sys any) {
 	if st, ok := sys.(*syscall.Stat_t); ok {
 		// Try to lookup owner name, fall back to numeric ID.
 		uidStr := strconv.Itoa(int(st.Uid))
-		if u, err := user.LookupId(uidStr); err == nil {
-			dfi.owner = u.Username
+		if name, err := UserName(uidStr); err == nil {
+			dfi.owner = name
 		} else {
 			dfi.owner = uidStr
 		}
 
 		// Try to lookup group name, fall back to numeric ID.
 		gidStr := strconv.Itoa(int(st.Gid))
-		if g, err := user.LookupGroupId(gidStr); err == nil {
-			dfi.group = g.Name
+		if name, err := GroupName(gidStr); err == nil {
+			dfi.group = name
 		} else {
 			dfi.group = gidStr
 		}
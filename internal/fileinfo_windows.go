@@ -10,11 +10,30 @@ import (
 // fillFromSys attempts to populate defaultFileInfo fields from the Sys() source
 // using Windows-specific syscall.Win32FileAttributeData structure.
 //
-// It currently only extracts LastAccessTime.
+// It extracts LastAccessTime and the file attributes.
 func fillFromSys(dfi *defaultFileInfo, sys any) {
 	if st, ok := sys.(*syscall.Win32FileAttributeData); ok {
 		dfi.accessTime = time.Unix(0, st.LastAccessTime.Nanoseconds())
 		// ChangeTime is not directly available in Win32FileAttributeData,
 		// so we keep the default (ModTime).
+		dfi.attributes = win32Attributes(st.FileAttributes)
+		dfi.hasAttributes = true
 	}
 }
+
+// win32Attributes converts FILE_ATTRIBUTE_* flags to Attributes.
+func win32Attributes(attrs uint32) Attributes {
+	var a Attributes
+	for flag, attr := range map[uint32]Attributes{
+		syscall.FILE_ATTRIBUTE_READONLY:      AttrReadOnly,
+		syscall.FILE_ATTRIBUTE_HIDDEN:        AttrHidden,
+		syscall.FILE_ATTRIBUTE_SYSTEM:        AttrSystem,
+		syscall.FILE_ATTRIBUTE_ARCHIVE:       AttrArchive,
+		syscall.FILE_ATTRIBUTE_REPARSE_POINT: AttrReparsePoint,
+	} {
+		if attrs&flag != 0 {
+			a |= attr
+		}
+	}
+	return a
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// flagError reports a combination of open flags that has no meaning. It
+// matches both fs.ErrInvalid and syscall.EINVAL.
+type flagError string
+
+func (e flagError) Error() string { return "invalid open flags: " + string(e) }
+
+func (e flagError) Is(target error) bool {
+	return target == fs.ErrInvalid || target == syscall.EINVAL
+}
+
+// ValidateFlags returns an error matching fs.ErrInvalid and syscall.EINVAL if
+// flag combines open flags in a way whose outcome depends on the backend:
+// an access mode other than O_RDONLY, O_WRONLY and O_RDWR, O_TRUNC on a file
+// opened read-only, or O_EXCL without O_CREATE.
+func ValidateFlags(flag int) error {
+	switch {
+	case flag&O_ACCMODE == O_ACCMODE:
+		return flagError("unknown access mode")
+	case flag&O_ACCMODE == os.O_RDONLY && flag&os.O_TRUNC != 0:
+		return flagError("O_TRUNC without write access")
+	case flag&os.O_EXCL != 0 && flag&os.O_CREATE == 0:
+		return flagError("O_EXCL without O_CREATE")
+	}
+	return nil
+}
+
+// CheckOpen is like CheckPath for the "open" operation, and also rejects the
+// flags refused by ValidateFlags. Layers call it first in OpenFile, so that
+// nonsensical flags fail the same way whatever the backend.
+func CheckOpen(name string, flag int) error {
+	if err := CheckPath("open", name); err != nil {
+		return err
+	}
+	if err := ValidateFlags(flag); err != nil {
+		return &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return nil
+}
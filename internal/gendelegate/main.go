@@ -0,0 +1,380 @@
+// Command gendelegate generates the delegating methods of the contextual
+// package from the delegated interface, so that PassthroughFS and the
+// non-contextual FS returned by FromContextual forward the same methods
+// the same way. It is run by go generate in the contextual package:
+//
+//	gendelegate -spec delegate.go -out delegate_gen.go
+//
+// With -type, it instead generates methods of the named type of another
+// package forwarding the delegated methods to a layer chosen per call,
+// through the helpers of the contextual package, for types that cannot
+// embed a PassthroughFS since their layer changes or since they forward
+// only some of the methods:
+//
+//	gendelegate -spec ../contextual/delegate.go -type 's *spillover' -layer 's.target()' -out spillover_gen.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// specType is the name of the interface listing the delegated methods.
+const specType = "delegated"
+
+func main() {
+	spec := flag.String("spec", "delegate.go", "file defining the delegated interface")
+	out := flag.String("out", "delegate_gen.go", "file to generate")
+	var l layer
+	flag.StringVar(&l.recv, "type", "", "receiver of the methods forwarding to a layer, such as 's *spillover'")
+	flag.StringVar(&l.layer, "layer", "", "expression of the layer the methods of -type forward to")
+	flag.StringVar(&l.pkg, "package", os.Getenv("GOPACKAGE"), "package of the methods of -type")
+	methods := flag.String("methods", "", "comma-separated methods of -type; all if empty")
+	flag.Parse()
+	if *methods != "" {
+		l.methods = strings.Split(*methods, ",")
+	}
+
+	src, err := os.ReadFile(*spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var code []byte
+	if l.recv != "" {
+		code, err = generateLayer(*spec, src, l)
+	} else {
+		code, err = generate(*spec, src)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, code, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// method is a method of the delegated interface.
+type method struct {
+	name string
+	doc  []string
+	// implements is the interface nonContextualFS implements with it.
+	implements string
+	// op is the operation of the errors of PassthroughFS, or empty if
+	// PassthroughFS does not forward the method.
+	op string
+	// params are the parameters after the context, and results the
+	// results, as printed in the spec.
+	params  []param
+	results []string
+}
+
+// param is a group of parameters sharing a type.
+type param struct {
+	names []string
+	typ   string
+}
+
+// generate returns the delegating methods of the delegated interface of
+// src, the spec file named filename.
+func generate(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	methods, err := parseSpec(fset, file)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gendelegate from %s. DO NOT EDIT.\n\n", filename)
+	fmt.Fprintf(&b, "package %s\n\n", file.Name.Name)
+	// The imports of the spec, with internal, keeping the standard library
+	// apart.
+	std, module := []string{}, []string{`"github.com/gwangyi/fsx/internal"`}
+	for _, imp := range file.Imports {
+		if strings.Contains(imp.Path.Value, ".") {
+			module = append(module, imp.Path.Value)
+		} else {
+			std = append(std, imp.Path.Value)
+		}
+	}
+	fmt.Fprintf(&b, "import (\n%s\n\n%s\n)\n", strings.Join(std, "\n"), strings.Join(module, "\n"))
+	for _, m := range methods {
+		if m.op != "" {
+			writePassthrough(&b, m)
+		}
+	}
+	for _, m := range methods {
+		writeNonContextual(&b, m)
+	}
+	return format.Source(b.Bytes())
+}
+
+// parseSpec returns the methods of the delegated interface of file.
+func parseSpec(fset *token.FileSet, file *ast.File) ([]method, error) {
+	var iface *ast.InterfaceType
+	ast.Inspect(file, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.TypeSpec); ok && spec.Name.Name == specType {
+			iface, _ = spec.Type.(*ast.InterfaceType)
+		}
+		return iface == nil
+	})
+	if iface == nil {
+		return nil, fmt.Errorf("no interface %s", specType)
+	}
+
+	str := func(n ast.Node) string {
+		var b strings.Builder
+		_ = printer.Fprint(&b, fset, n)
+		return b.String()
+	}
+	var methods []method
+	for _, field := range iface.Methods.List {
+		fn, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) != 1 {
+			return nil, fmt.Errorf("%s: embedded interfaces are not supported", fset.Position(field.Pos()))
+		}
+		m := method{name: field.Names[0].Name}
+		if field.Doc != nil {
+			for _, c := range field.Doc.List {
+				directive, ok := strings.CutPrefix(c.Text, "//delegate:")
+				if !ok {
+					m.doc = append(m.doc, c.Text)
+					continue
+				}
+				key, value, _ := strings.Cut(directive, " ")
+				switch key {
+				case "implements":
+					m.implements = value
+				case "passthrough":
+					m.op = value
+				default:
+					return nil, fmt.Errorf("%s: unknown directive %q", fset.Position(c.Pos()), key)
+				}
+			}
+		}
+		if fn.Results == nil || len(fn.Results.List) > 2 || str(fn.Results.List[len(fn.Results.List)-1].Type) != "error" {
+			return nil, fmt.Errorf("%s: %s does not return an error, or a value and an error", fset.Position(field.Pos()), m.name)
+		}
+		if m.implements == "" {
+			return nil, fmt.Errorf("%s: %s lacks a //delegate:implements directive", fset.Position(field.Pos()), m.name)
+		}
+
+		params := fn.Params.List
+		if len(params) == 0 || len(params[0].Names) != 1 || str(params[0].Type) != "context.Context" {
+			return nil, fmt.Errorf("%s: %s does not take a context first", fset.Position(field.Pos()), m.name)
+		}
+		for _, p := range params[1:] {
+			var names []string
+			for _, n := range p.Names {
+				names = append(names, n.Name)
+			}
+			m.params = append(m.params, param{names: names, typ: str(p.Type)})
+		}
+		for _, r := range fn.Results.List {
+			m.results = append(m.results, str(r.Type))
+		}
+		if m.op != "" && !m.named("name") && !m.linking() {
+			return nil, fmt.Errorf("%s: %s names no file to check", fset.Position(field.Pos()), m.name)
+		}
+		methods = append(methods, m)
+	}
+	return methods, nil
+}
+
+// named reports whether m has a parameter called name.
+func (m method) named(name string) bool {
+	for _, p := range m.params {
+		for _, n := range p.names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// linking reports whether m takes an oldname and a newname, like Rename.
+func (m method) linking() bool {
+	return m.named("oldname") && m.named("newname")
+}
+
+// signature returns the parameters of m, after the context, and its results.
+func (m method) signature() (params, results string) {
+	var ps []string
+	for _, p := range m.params {
+		ps = append(ps, strings.Join(p.names, ", ")+" "+p.typ)
+	}
+	results = strings.Join(m.results, ", ")
+	if len(m.results) > 1 {
+		results = "(" + results + ")"
+	}
+	return strings.Join(ps, ", "), results
+}
+
+// args returns the arguments forwarding the parameters of m after the
+// context.
+func (m method) args() string {
+	var args []string
+	for _, p := range m.params {
+		args = append(args, p.names...)
+	}
+	return strings.Join(args, ", ")
+}
+
+// zero returns the zero value of the result type typ.
+func zero(typ string) string {
+	switch typ {
+	case "string":
+		return `""`
+	case "int", "int64", "uint32", "uint64":
+		return "0"
+	case "bool":
+		return "false"
+	}
+	return "nil"
+}
+
+// writePassthrough writes the method m of PassthroughFS.
+func writePassthrough(b *bytes.Buffer, m method) {
+	params, results := m.signature()
+	b.WriteString("\n")
+	for _, line := range m.doc {
+		b.WriteString(line + "\n")
+	}
+	fmt.Fprintf(b, "func (p PassthroughFS) %s(ctx context.Context, %s) %s {\n", m.name, params, results)
+
+	var zeros []string
+	for _, r := range m.results[:len(m.results)-1] {
+		zeros = append(zeros, zero(r))
+	}
+	check := fmt.Sprintf("internal.CheckPath(%q, name)", m.op)
+	decorate := func(err string) string { return fmt.Sprintf("internal.Decorate(%q, name, %s)", m.op, err) }
+	switch {
+	case m.linking():
+		check = fmt.Sprintf("internal.CheckLink(%q, oldname, newname)", m.op)
+		decorate = func(err string) string {
+			return fmt.Sprintf("internal.DecorateLink(%q, oldname, newname, %s)", m.op, err)
+		}
+	case m.named("flag"):
+		check = "internal.CheckOpen(name, flag)"
+	}
+	fmt.Fprintf(b, "if err := %s; err != nil {\nreturn %s\n}\n", check, strings.Join(append(zeros, "err"), ", "))
+
+	call := fmt.Sprintf("%s(ctx, p.Inner, %s)", m.name, m.args())
+	if len(zeros) == 0 {
+		fmt.Fprintf(b, "return %s\n}\n", decorate(call))
+		return
+	}
+	fmt.Fprintf(b, "v, err := %s\n", call)
+	fmt.Fprintf(b, "if err != nil {\nreturn %s, %s\n}\n", zeros[0], decorate("err"))
+	b.WriteString("return v, nil\n}\n")
+}
+
+// layer describes the methods of a type forwarding to a layer.
+type layer struct {
+	// recv is the receiver of the methods, and layer the expression of
+	// the layer they forward to.
+	recv, layer string
+	// pkg is the package of the type.
+	pkg string
+	// methods are the methods to forward, or nil for all of them.
+	methods []string
+}
+
+// exported matches the names of the contextual package in the types of the
+// spec, which have to be qualified in another package.
+var exported = regexp.MustCompile(`(^|[^.\w])([A-Z]\w*)`)
+
+// qualifier matches the package qualifiers of a type.
+var qualifier = regexp.MustCompile(`(\w+)\.`)
+
+// generateLayer returns the methods of l forwarding to its layer the
+// delegated methods of src, the spec file named filename.
+func generateLayer(filename string, src []byte, l layer) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	methods, err := parseSpec(fset, file)
+	if err != nil {
+		return nil, err
+	}
+	if l.layer == "" || l.pkg == "" {
+		return nil, fmt.Errorf("-type needs -layer and -package")
+	}
+	for _, name := range l.methods {
+		if !slices.ContainsFunc(methods, func(m method) bool { return m.name == name }) {
+			return nil, fmt.Errorf("no method %s in %s", name, specType)
+		}
+	}
+
+	var body bytes.Buffer
+	used := map[string]bool{"context": true, file.Name.Name: true}
+	for _, m := range methods {
+		if l.methods != nil && !slices.Contains(l.methods, m.name) {
+			continue
+		}
+		for i, p := range m.params {
+			m.params[i].typ = exported.ReplaceAllString(p.typ, "${1}"+file.Name.Name+".$2")
+		}
+		for i, r := range m.results {
+			m.results[i] = exported.ReplaceAllString(r, "${1}"+file.Name.Name+".$2")
+		}
+		params, results := m.signature()
+		for _, match := range qualifier.FindAllStringSubmatch(params+results, -1) {
+			used[match[1]] = true
+		}
+		body.WriteString("\n")
+		for _, line := range m.doc {
+			body.WriteString(line + "\n")
+		}
+		fmt.Fprintf(&body, "func (%s) %s(ctx context.Context, %s) %s {\n", l.recv, m.name, params, results)
+		fmt.Fprintf(&body, "return %s.%s(ctx, %s, %s)\n}\n", file.Name.Name, m.name, l.layer, m.args())
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by gendelegate from %s. DO NOT EDIT.\n\n", filename)
+	fmt.Fprintf(&b, "package %s\n\n", l.pkg)
+	// The imports of the spec used by the methods, with the spec package
+	// itself, keeping the standard library apart.
+	std, module := []string{}, []string{}
+	for _, imp := range file.Imports {
+		p := strings.Trim(imp.Path.Value, `"`)
+		if !used[p[strings.LastIndex(p, "/")+1:]] {
+			continue
+		}
+		if strings.Contains(p, ".") {
+			module = append(module, imp.Path.Value)
+		} else {
+			std = append(std, imp.Path.Value)
+		}
+	}
+	module = append(module, `"github.com/gwangyi/fsx/`+file.Name.Name+`"`)
+	slices.Sort(module)
+	fmt.Fprintf(&b, "import (\n%s\n\n%s\n)\n", strings.Join(std, "\n"), strings.Join(module, "\n"))
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
+
+// writeNonContextual writes the method m of nonContextualFS.
+func writeNonContextual(b *bytes.Buffer, m method) {
+	params, results := m.signature()
+	fmt.Fprintf(b, "\n// %s implements %s.\n", m.name, m.implements)
+	fmt.Fprintf(b, "func (n *nonContextualFS) %s(%s) %s {\n", m.name, params, results)
+	fmt.Fprintf(b, "return %s(n.ctx, n.fsys, %s)\n}\n", m.name, m.args())
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGenerate_UpToDate(t *testing.T) {
+	src, err := os.ReadFile("../../contextual/delegate.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := generate("delegate.go", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("../../contextual/delegate_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("contextual/delegate_gen.go is out of date; run go generate in contextual")
+	}
+}
+
+func TestGenerateLayer_UpToDate(t *testing.T) {
+	src, err := os.ReadFile("../../contextual/delegate.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		out string
+		l   layer
+	}{
+		{"../../unionfs/spillover_gen.go", layer{recv: "s *spillover", layer: "s.target()", pkg: "unionfs"}},
+		{"../../unionfs/readonly_gen.go", layer{recv: "r readOnly", layer: "r.layer", pkg: "unionfs", methods: []string{"Stat", "ReadDir", "ReadLink", "Lstat", "GetXattr", "ListXattr"}}},
+	} {
+		got, err := generateLayer("../contextual/delegate.go", src, tc.l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := os.ReadFile(tc.out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s is out of date; run go generate in its package", tc.out)
+		}
+	}
+}
+
+func TestGenerateLayer_Errors(t *testing.T) {
+	src := []byte("package p\n\ntype delegated interface {\n//delegate:implements fsx.WriterFS\nRemove(ctx context.Context, name string) error\n}\n")
+	for _, tc := range []struct {
+		name string
+		l    layer
+		want string
+	}{
+		{"layer", layer{recv: "s *spillover", pkg: "p"}, "needs -layer"},
+		{"method", layer{recv: "s *spillover", layer: "s.fsys", pkg: "p", methods: []string{"Stat"}}, "no method Stat"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := generateLayer("spec.go", src, tc.l)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("generateLayer() error = %v; want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	for _, tc := range []struct {
+		name, spec, want string
+	}{
+		{"missing", "type other interface{}", "no interface delegated"},
+		{"context", "type delegated interface {\n//delegate:implements fsx.WriterFS\nRemove(name string) error\n}", "does not take a context first"},
+		{"implements", "type delegated interface {\nRemove(ctx context.Context, name string) error\n}", "lacks a //delegate:implements directive"},
+		{"directive", "type delegated interface {\n//delegate:other x\nRemove(ctx context.Context, name string) error\n}", "unknown directive"},
+		{"results", "type delegated interface {\n//delegate:implements fsx.WriterFS\nRemove(ctx context.Context, name string)\n}", "does not return an error"},
+		{"names", "type delegated interface {\n//delegate:implements fs.GlobFS\n//delegate:passthrough glob\nGlob(ctx context.Context, pattern string) ([]string, error)\n}", "names no file to check"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := generate("spec.go", []byte("package p\n\n"+tc.spec+"\n"))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("generate() error = %v; want %q", err, tc.want)
+			}
+		})
+	}
+}
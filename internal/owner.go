@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"os/user"
+	"strconv"
+)
+
+// UserID returns the id of the user named name in the user database of the
+// operating system. A numeric name is taken as an id.
+func UserID(name string) (string, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return name, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+// GroupID returns the id of the group named name in the group database of
+// the operating system. A numeric name is taken as an id.
+func GroupID(name string) (string, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return name, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// UserName returns the name of the user with the given id in the user
+// database of the operating system.
+func UserName(id string) (string, error) {
+	u, err := user.LookupId(id)
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// GroupName returns the name of the group with the given id in the group
+// database of the operating system.
+func GroupName(id string) (string, error) {
+	g, err := user.LookupGroupId(id)
+	if err != nil {
+		return "", err
+	}
+	return g.Name, nil
+}
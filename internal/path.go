@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// JoinValid returns the path of the entry elem of the directory dir, as
+// listed by ReadDir. It returns an *fs.PathError with Err set to
+// fs.ErrInvalid, naming the entry unchanged, if elem is not a single valid
+// path element, such as "", ".", ".." or "a/b", so that a backend listing
+// such a name cannot make a layer reach outside dir.
+func JoinValid(dir, elem string) (string, error) {
+	if elem == "." || strings.Contains(elem, "/") || !fs.ValidPath(elem) {
+		return "", &fs.PathError{Op: "readdir", Path: dir + "/" + elem, Err: fs.ErrInvalid}
+	}
+	return path.Join(dir, elem), nil
+}
+
+// HasPathPrefix reports whether name is prefix or lies beneath it, element
+// by element: "dir/a" has the prefix "dir" but "dir2/a" does not. Every
+// name has the prefix ".". Both are expected to be clean.
+func HasPathPrefix(name, prefix string) bool {
+	switch {
+	case prefix == ".", name == prefix:
+		return true
+	}
+	return strings.HasPrefix(name, prefix) && name[len(prefix)] == '/'
+}
+
+// SplitDirFile splits name into its parent directory and its last element.
+// Unlike path.Split, the directory is clean, "." for names at the top
+// level, so that it is a valid path itself.
+func SplitDirFile(name string) (dir, file string) {
+	return path.Dir(name), path.Base(name)
+}
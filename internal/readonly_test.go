@@ -93,3 +93,27 @@ func TestReadOnlyFile_Delegation(t *testing.T) {
 		t.Error("Close was not delegated")
 	}
 }
+
+// mockDirFile implements fs.ReadDirFile for testing purposes.
+type mockDirFile struct {
+	mockFSFile
+	entries []fs.DirEntry
+}
+
+func (m *mockDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return m.entries, nil
+}
+
+func TestReadOnlyFile_ReadDir(t *testing.T) {
+	f := internal.ReadOnlyFile{File: &mockFSFile{}}
+	if _, err := f.ReadDir(-1); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+
+	entries := []fs.DirEntry{nil, nil}
+	f = internal.ReadOnlyFile{File: &mockDirFile{entries: entries}}
+	got, err := f.ReadDir(-1)
+	if err != nil || len(got) != 2 {
+		t.Errorf("expected delegated entries, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,42 @@
+package internal
+
+import "io/fs"
+
+// SpecialMask is the set of mode bits that mark a special file: a named pipe,
+// a socket, or a block or character device.
+const SpecialMask = fs.ModeNamedPipe | fs.ModeSocket | fs.ModeDevice | fs.ModeCharDevice
+
+// IsSpecial reports whether mode describes a special file that has no content
+// of its own and must be recreated rather than copied.
+func IsSpecial(mode fs.FileMode) bool {
+	return mode&SpecialMask != 0
+}
+
+// Mkdev returns a device number composed of the given major and minor numbers,
+// using the encoding of Linux and glibc.
+func Mkdev(major, minor uint32) uint64 {
+	return uint64(major&0xfff)<<8 | uint64(major&^0xfff)<<32 |
+		uint64(minor&0xff) | uint64(minor&^0xff)<<12
+}
+
+// Major returns the major component of a device number built by Mkdev.
+func Major(dev uint64) uint32 {
+	return uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+}
+
+// Minor returns the minor component of a device number built by Mkdev.
+func Minor(dev uint64) uint32 {
+	return uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+}
+
+// DeviceNumber returns the device number of a device file described by info,
+// or zero if it is not a device or the number is not available.
+func DeviceNumber(info fs.FileInfo) uint64 {
+	if info == nil || info.Mode()&fs.ModeDevice == 0 {
+		return 0
+	}
+	if d, ok := info.(interface{ Rdev() uint64 }); ok {
+		return d.Rdev()
+	}
+	return rdevFromSys(info.Sys())
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package internal
+
+// rdevFromSys is not supported on this operating system and always returns zero.
+func rdevFromSys(sys any) uint64 {
+	return 0
+}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package internal
+
+import "syscall"
+
+// rdevFromSys extracts the device number from a syscall.Stat_t.
+func rdevFromSys(sys any) uint64 {
+	if st, ok := sys.(*syscall.Stat_t); ok {
+		return st.Rdev
+	}
+	return 0
+}
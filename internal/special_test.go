@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMkdev(t *testing.T) {
+	for _, tc := range []struct{ major, minor uint32 }{
+		{0, 0},
+		{1, 3},
+		{8, 1},
+		{259, 65536},
+		{0xfffff, 0xfffff},
+	} {
+		dev := Mkdev(tc.major, tc.minor)
+		if got := Major(dev); got != tc.major {
+			t.Errorf("Major(Mkdev(%d, %d)) = %d", tc.major, tc.minor, got)
+		}
+		if got := Minor(dev); got != tc.minor {
+			t.Errorf("Minor(Mkdev(%d, %d)) = %d", tc.major, tc.minor, got)
+		}
+	}
+	// /dev/null is 1:3 on Linux.
+	if dev := Mkdev(1, 3); dev != 0x103 {
+		t.Errorf("Mkdev(1, 3) = %#x, want 0x103", dev)
+	}
+}
+
+func TestIsSpecial(t *testing.T) {
+	for mode, want := range map[fs.FileMode]bool{
+		0644:                              false,
+		fs.ModeDir | 0755:                 false,
+		fs.ModeSymlink | 0777:             false,
+		fs.ModeNamedPipe | 0644:           true,
+		fs.ModeSocket | 0755:              true,
+		fs.ModeDevice | 0660:              true,
+		fs.ModeDevice | fs.ModeCharDevice: true,
+	} {
+		if got := IsSpecial(mode); got != want {
+			t.Errorf("IsSpecial(%v) = %v, want %v", mode, got, want)
+		}
+	}
+}
+
+func TestDeviceNumber(t *testing.T) {
+	fsys := fstest.MapFS{
+		"file": {Mode: 0644},
+		"dev":  {Mode: fs.ModeDevice, Sys: "unknown"},
+	}
+	for _, name := range []string{"file", "dev"} {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dev := DeviceNumber(info); dev != 0 {
+			t.Errorf("DeviceNumber(%s) = %d, want 0", name, dev)
+		}
+	}
+	if dev := DeviceNumber(nil); dev != 0 {
+		t.Errorf("DeviceNumber(nil) = %d, want 0", dev)
+	}
+}
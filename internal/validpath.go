@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"io/fs"
+	"os"
+)
+
+// CheckPath returns an *fs.PathError for op on name with Err set to
+// fs.ErrInvalid if name is not a valid path name according to fs.ValidPath,
+// or nil otherwise. Layers call it before passing caller-supplied names to
+// their backends, so that names such as "../x" or "/x" are rejected the same
+// way whatever the backend.
+func CheckPath(op, name string) error {
+	if fs.ValidPath(name) {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+}
+
+// CheckLink is like CheckPath for operations on two names, such as rename,
+// and returns an *os.LinkError. The old name of a symlink is the target of
+// the link, which may be any path, and is not checked.
+func CheckLink(op, oldname, newname string) error {
+	if (op == "symlink" || fs.ValidPath(oldname)) && fs.ValidPath(newname) {
+		return nil
+	}
+	return &os.LinkError{Op: op, Old: oldname, New: newname, Err: fs.ErrInvalid}
+}
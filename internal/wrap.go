@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"io"
+	"io/fs"
+)
+
+// readDirer is the method set of fs.ReadDirFile beyond fs.File.
+type readDirer interface {
+	ReadDir(n int) ([]fs.DirEntry, error)
+}
+
+// restater is the method set of fsx.RestatFile beyond fs.File.
+type restater interface {
+	Restat() (fs.FileInfo, error)
+}
+
+// statRestater implements restater with the Stat of a file.
+type statRestater struct {
+	file fs.File
+}
+
+func (s statRestater) Restat() (fs.FileInfo, error) {
+	return s.file.Stat()
+}
+
+// Restat returns a FileInfo describing f as it is now: the one returned by
+// its Restat method if it has one, or else by its Stat. Wrappers of files
+// whose FileInfo derives from that of the file they wrap implement Restat
+// with it.
+func Restat(f fs.File) (fs.FileInfo, error) {
+	if r, ok := f.(restater); ok {
+		return r.Restat()
+	}
+	return f.Stat()
+}
+
+// WrapFile returns file, which wraps inner, with exactly the optional
+// interfaces among io.Seeker, io.ReaderAt and fs.ReadDirFile that inner
+// implements, so that type assertions on the result behave as they would on
+// inner. The methods of those interfaces are taken from file if it defines
+// them, and from inner otherwise.
+//
+// io.WriterTo and io.ReaderFrom, which let io.Copy serve a file without
+// buffering it, such as with sendfile, would bypass the Read and Write of
+// file. They are only exposed if file defines them itself, inner implements
+// them, and inner implements io.Seeker and io.ReaderAt, like the files of
+// the backends of this module.
+//
+// The result always implements fsx.RestatFile, with the Restat of file if it
+// defines one, and its Stat otherwise, since fsx.Restat falls back to Stat
+// for files without it anyway.
+func WrapFile(file File, inner fs.File) File {
+	rs, ok := any(file).(restater)
+	if !ok {
+		rs = statRestater{file}
+	}
+	var (
+		seeker, seekOK   = optional[io.Seeker](file, inner)
+		readerAt, raOK   = optional[io.ReaderAt](file, inner)
+		dir, dirOK       = optional[readDirer](file, inner)
+		writerTo, wtOK   = own[io.WriterTo](file, inner)
+		readerFrom, rfOK = own[io.ReaderFrom](file, inner)
+	)
+	switch {
+	case seekOK && raOK && dirOK && (wtOK || rfOK):
+		return wrapFast(file, rs, seeker, readerAt, dir, writerTo, readerFrom)
+	case seekOK && raOK && (wtOK || rfOK):
+		return wrapFast(file, rs, seeker, readerAt, nil, writerTo, readerFrom)
+	case seekOK && raOK && dirOK:
+		return struct {
+			File
+			restater
+			io.Seeker
+			io.ReaderAt
+			readDirer
+		}{file, rs, seeker, readerAt, dir}
+	case seekOK && raOK:
+		return struct {
+			File
+			restater
+			io.Seeker
+			io.ReaderAt
+		}{file, rs, seeker, readerAt}
+	case seekOK && dirOK:
+		return struct {
+			File
+			restater
+			io.Seeker
+			readDirer
+		}{file, rs, seeker, dir}
+	case raOK && dirOK:
+		return struct {
+			File
+			restater
+			io.ReaderAt
+			readDirer
+		}{file, rs, readerAt, dir}
+	case seekOK:
+		return struct {
+			File
+			restater
+			io.Seeker
+		}{file, rs, seeker}
+	case raOK:
+		return struct {
+			File
+			restater
+			io.ReaderAt
+		}{file, rs, readerAt}
+	case dirOK:
+		return struct {
+			File
+			restater
+			readDirer
+		}{file, rs, dir}
+	default:
+		return struct {
+			File
+			restater
+		}{file, rs}
+	}
+}
+
+// optional returns the implementation of the optional interface T to expose
+// for inner: the one of file if any, or else the one of inner. It reports
+// false if inner does not implement T.
+func optional[T any](file File, inner fs.File) (T, bool) {
+	impl, ok := inner.(T)
+	if !ok {
+		return impl, false
+	}
+	if own, ok := any(file).(T); ok {
+		return own, true
+	}
+	return impl, true
+}
+
+// wrapFast is WrapFile for a file exposing io.WriterTo or io.ReaderFrom,
+// whichever is not nil, on top of io.Seeker, io.ReaderAt and, unless dir is
+// nil, fs.ReadDirFile.
+func wrapFast(file File, rs restater, seeker io.Seeker, readerAt io.ReaderAt, dir readDirer, writerTo io.WriterTo, readerFrom io.ReaderFrom) File {
+	switch {
+	case dir != nil && writerTo != nil && readerFrom != nil:
+		return struct {
+			File
+			restater
+			io.Seeker
+			io.ReaderAt
+			readDirer
+			io.WriterTo
+			io.ReaderFrom
+		}{file, rs, seeker, readerAt, dir, writerTo, readerFrom}
+	case dir != nil && writerTo != nil:
+		return struct {
+			File
+			restater
+			io.Seeker
+			io.ReaderAt
+			readDirer
+			io.WriterTo
+		}{file, rs, seeker, readerAt, dir, writerTo}
+	case dir != nil:
+		return struct {
+			File
+			restater
+			io.Seeker
+			io.ReaderAt
+			readDirer
+			io.ReaderFrom
+		}{file, rs, seeker, readerAt, dir, readerFrom}
+	case writerTo != nil && readerFrom != nil:
+		return struct {
+			File
+			restater
+			io.Seeker
+			io.ReaderAt
+			io.WriterTo
+			io.ReaderFrom
+		}{file, rs, seeker, readerAt, writerTo, readerFrom}
+	case writerTo != nil:
+		return struct {
+			File
+			restater
+			io.Seeker
+			io.ReaderAt
+			io.WriterTo
+		}{file, rs, seeker, readerAt, writerTo}
+	default:
+		return struct {
+			File
+			restater
+			io.Seeker
+			io.ReaderAt
+			io.ReaderFrom
+		}{file, rs, seeker, readerAt, readerFrom}
+	}
+}
+
+// own returns the implementation of the optional interface T of file, if
+// both file and inner implement it.
+func own[T any](file File, inner fs.File) (T, bool) {
+	if _, ok := inner.(T); !ok {
+		var zero T
+		return zero, false
+	}
+	impl, ok := any(file).(T)
+	return impl, ok
+}
+
+// WriteTo writes the contents of r to w with the io.WriterTo of r, if it
+// implements it, or through a pooled buffer. Wrappers of files whose reads
+// are those of the file they wrap implement io.WriterTo with it.
+func WriteTo(w io.Writer, r io.Reader) (int64, error) {
+	if wt, ok := r.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return Copy(w, struct{ io.Reader }{r})
+}
+
+// ReadFrom reads r into w with the io.ReaderFrom of w, if it implements it,
+// or through a pooled buffer. Wrappers of files whose writes are those of
+// the file they wrap implement io.ReaderFrom with it.
+func ReadFrom(w io.Writer, r io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return Copy(struct{ io.Writer }{w}, r)
+}
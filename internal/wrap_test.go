@@ -0,0 +1,124 @@
+package internal_test
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// mockSeekFile implements fs.File and io.Seeker.
+type mockSeekFile struct {
+	mockFSFile
+}
+
+func (m *mockSeekFile) Seek(offset int64, whence int) (int64, error) {
+	return offset, nil
+}
+
+// listingFile overrides ReadDir of the file it wraps.
+type listingFile struct {
+	internal.ReadOnlyFile
+}
+
+func (listingFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestWrapFile(t *testing.T) {
+	osFile, err := os.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = osFile.Close() }()
+
+	tests := []struct {
+		name                  string
+		inner                 fs.File
+		seek, readAt, readDir bool
+	}{
+		{"plain", &mockFSFile{}, false, false, false},
+		{"seeker", &mockSeekFile{}, true, false, false},
+		{"dir", &mockDirFile{}, false, false, true},
+		{"os file", osFile, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := internal.WrapFile(internal.ReadOnlyFile{File: tt.inner}, tt.inner)
+			if _, ok := f.(io.Seeker); ok != tt.seek {
+				t.Errorf("io.Seeker = %v, want %v", ok, tt.seek)
+			}
+			if _, ok := f.(io.ReaderAt); ok != tt.readAt {
+				t.Errorf("io.ReaderAt = %v, want %v", ok, tt.readAt)
+			}
+			if _, ok := f.(fs.ReadDirFile); ok != tt.readDir {
+				t.Errorf("fs.ReadDirFile = %v, want %v", ok, tt.readDir)
+			}
+		})
+	}
+
+	t.Run("override", func(t *testing.T) {
+		inner := &mockDirFile{entries: []fs.DirEntry{nil}}
+		f := internal.WrapFile(listingFile{internal.ReadOnlyFile{File: inner}}, inner)
+		if _, err := f.(fs.ReadDirFile).ReadDir(-1); err != io.ErrUnexpectedEOF {
+			t.Errorf("expected the ReadDir of the wrapper, got %v", err)
+		}
+		if _, err := f.Write(nil); err != internal.ErrBadFileDescriptor {
+			t.Errorf("expected the Write of the wrapper, got %v", err)
+		}
+	})
+}
+
+// countingWriteFile is a File wrapping an *os.File that counts its writes
+// and lets the file fill itself.
+type countingWriteFile struct {
+	*os.File
+	written int64
+}
+
+func (c *countingWriteFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := internal.ReadFrom(c.File, r)
+	c.written += n
+	return n, err
+}
+
+func TestWrapFile_Fast(t *testing.T) {
+	dir := t.TempDir()
+	regular, err := os.Create(dir + "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = regular.Close() }()
+
+	// ReadOnlyFile defines WriteTo but not ReadFrom.
+	f := internal.WrapFile(internal.ReadOnlyFile{File: regular}, regular)
+	if _, ok := f.(io.WriterTo); !ok {
+		t.Error("expected io.WriterTo to be exposed")
+	}
+	if _, ok := f.(io.ReaderFrom); ok {
+		t.Error("expected io.ReaderFrom of the inner file to be hidden")
+	}
+
+	counting := &countingWriteFile{File: regular}
+	f = internal.WrapFile(counting, regular)
+	rf, ok := f.(io.ReaderFrom)
+	if !ok {
+		t.Fatal("expected io.ReaderFrom to be exposed")
+	}
+	if _, ok := f.(io.Seeker); !ok {
+		t.Error("expected io.Seeker to be kept")
+	}
+	if n, err := rf.ReadFrom(strings.NewReader("data")); err != nil || n != 4 || counting.written != 4 {
+		t.Errorf("ReadFrom() = %d, %v; want the 4 bytes counted by the wrapper, got %d", n, err, counting.written)
+	}
+
+	// Files that do not implement them expose neither.
+	inner := &mockSeekFile{}
+	f = internal.WrapFile(internal.ReadOnlyFile{File: inner}, inner)
+	if _, ok := f.(io.WriterTo); ok {
+		t.Error("expected io.WriterTo to be hidden")
+	}
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"io/fs"
+	"strings"
+)
+
+// Flags for setting extended attributes, matching the values used by
+// setxattr(2) on Linux.
+const (
+	// XATTR_CREATE fails if the attribute already exists.
+	XATTR_CREATE = 0x1
+	// XATTR_REPLACE fails if the attribute does not exist.
+	XATTR_REPLACE = 0x2
+)
+
+// ErrNoXattr is returned when the requested extended attribute does not
+// exist. It is an alias for syscall.ENODATA on Linux.
+var ErrNoXattr error = errNoXattr
+
+// xattrNamespaces are the namespaces an extended attribute name must start
+// with.
+var xattrNamespaces = []string{"security.", "system.", "trusted.", "user."}
+
+// maxXattrName is the maximum length of an extended attribute name.
+const maxXattrName = 255
+
+// XattrNamespace returns the namespace of attr, including the trailing dot,
+// and the rest of the name. ok is false if attr does not start with a known
+// namespace followed by a non-empty name.
+func XattrNamespace(attr string) (ns, rest string, ok bool) {
+	for _, ns := range xattrNamespaces {
+		if rest, found := strings.CutPrefix(attr, ns); found && rest != "" {
+			return ns, rest, true
+		}
+	}
+	return "", "", false
+}
+
+// CheckXattr validates name like CheckPath, and attr, which must be made of
+// a known namespace, such as "user." or "trusted.", followed by a non-empty
+// name without NUL bytes, for a total of at most 255 bytes.
+func CheckXattr(op, name, attr string) error {
+	if err := CheckPath(op, name); err != nil {
+		return err
+	}
+	if _, _, ok := XattrNamespace(attr); !ok || len(attr) > maxXattrName || strings.IndexByte(attr, 0) >= 0 {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
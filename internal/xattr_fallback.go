@@ -0,0 +1,7 @@
+//go:build !linux
+
+package internal
+
+import "errors"
+
+var errNoXattr = errors.New("no such extended attribute")
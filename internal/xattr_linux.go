@@ -0,0 +1,7 @@
+//go:build linux
+
+package internal
+
+import "syscall"
+
+const errNoXattr = syscall.ENODATA
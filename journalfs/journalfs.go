@@ -0,0 +1,265 @@
+// Package journalfs provides a contextual filesystem wrapper that keeps a
+// journal of the changes made through it, implementing
+// contextual.ChangeJournalFS for any backend.
+//
+// Only changes that pass through the wrapper are recorded. The journal is
+// kept in memory and bounded; consumers that fall too far behind get
+// contextual.ErrCursorExpired and have to rescan.
+package journalfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// DefaultMaxChanges is the number of changes retained unless
+// Config.MaxChanges is set.
+const DefaultMaxChanges = 10000
+
+// Config specifies the configuration for journalfs.
+type Config struct {
+	// MaxChanges is the maximum number of changes retained in the journal.
+	// When it is exceeded, the oldest changes are dropped.
+	// If 0, DefaultMaxChanges is used.
+	MaxChanges int
+	// Clock is the source of the times of the changes.
+	// If nil, contextual.RealClock is used.
+	Clock contextual.Clock
+}
+
+// filesystem journals the changes made through the embedded PassthroughFS,
+// overriding the methods that make them.
+type filesystem struct {
+	contextual.PassthroughFS
+	config Config
+
+	mu sync.Mutex
+	// changes holds the retained changes. The change at index i was recorded
+	// at cursor first+i+1.
+	changes []contextual.Change
+	first   contextual.Cursor
+}
+
+// New creates a new journalfs wrapping fsys.
+func New(fsys contextual.FS, config Config) contextual.FileSystem {
+	if config.MaxChanges <= 0 {
+		config.MaxChanges = DefaultMaxChanges
+	}
+	return &filesystem{PassthroughFS: contextual.PassthroughFS{Inner: fsys}, config: config}
+}
+
+// Unwrap returns the filesystem the journalfs is layered on.
+func (f *filesystem) Unwrap() contextual.FS {
+	return f.Inner
+}
+
+// record appends a change to the journal if err is nil, and returns err.
+func (f *filesystem) record(err error, op contextual.ChangeOp, name, oldname string) error {
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.changes = append(f.changes, contextual.Change{Op: op, Name: name, OldName: oldname, Time: contextual.ClockOr(f.config.Clock).Now()})
+	if drop := len(f.changes) - f.config.MaxChanges; drop > 0 {
+		f.changes = append(f.changes[:0], f.changes[drop:]...)
+		f.first += contextual.Cursor(drop)
+	}
+	return nil
+}
+
+// Changes returns the changes recorded after since.
+func (f *filesystem) Changes(ctx context.Context, since contextual.Cursor) ([]contextual.Change, contextual.Cursor, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	next := f.first + contextual.Cursor(len(f.changes))
+	switch {
+	case since > next:
+		return nil, next, &fs.PathError{Op: "changes", Path: ".", Err: fmt.Errorf("cursor %d is ahead of the journal: %w", since, fs.ErrInvalid)}
+	case since < f.first:
+		return nil, next, &fs.PathError{Op: "changes", Path: ".", Err: contextual.ErrCursorExpired}
+	}
+	return append([]contextual.Change(nil), f.changes[since-f.first:]...), next, nil
+}
+
+// writeFile records a write, or the creation of the file, when a file opened
+// for writing is closed. It is exposed through internal.WrapFile, keeping the
+// optional interfaces of the file.
+type writeFile struct {
+	fsx.File
+	fs   *filesystem
+	name string
+
+	mu      sync.Mutex
+	created bool
+	written bool
+}
+
+// Write writes to the file and marks it as modified.
+func (w *writeFile) Write(p []byte) (int, error) {
+	n, err := w.File.Write(p)
+	if n > 0 {
+		w.mu.Lock()
+		w.written = true
+		w.mu.Unlock()
+	}
+	return n, err
+}
+
+// Truncate truncates the file and marks it as modified.
+func (w *writeFile) Truncate(size int64) error {
+	if err := w.File.Truncate(size); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.written = true
+	w.mu.Unlock()
+	return nil
+}
+
+// Close closes the file, recording its creation if it was created, or a
+// write if it was modified.
+func (w *writeFile) Close() error {
+	err := w.File.Close()
+	w.mu.Lock()
+	created, written := w.created, w.written
+	w.created, w.written = false, false
+	w.mu.Unlock()
+	switch {
+	case created:
+		_ = w.fs.record(nil, contextual.ChangeCreate, w.name, "")
+	case written:
+		_ = w.fs.record(nil, contextual.ChangeWrite, w.name, "")
+	}
+	return err
+}
+
+// Create creates or truncates the named file.
+func (f *filesystem) Create(ctx context.Context, name string) (fsx.File, error) {
+	return f.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the named file. Files opened for writing record their
+// creation when they are closed, if they did not exist, or else a write, if
+// they were truncated or written to.
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	created := false
+	if flag&os.O_CREATE != 0 {
+		_, err := f.PassthroughFS.Stat(ctx, name)
+		created = flag&os.O_EXCL != 0 || errors.Is(err, fs.ErrNotExist)
+	}
+	file, err := f.PassthroughFS.OpenFile(ctx, name, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	if flag&fsx.O_ACCMODE == os.O_RDONLY && flag&(os.O_CREATE|os.O_TRUNC) == 0 {
+		return file, nil
+	}
+	w := &writeFile{File: file, fs: f, name: name, created: created, written: flag&os.O_TRUNC != 0}
+	return internal.WrapFile(w, file), nil
+}
+
+// Remove removes the named file or empty directory.
+func (f *filesystem) Remove(ctx context.Context, name string) error {
+	return f.record(f.PassthroughFS.Remove(ctx, name), contextual.ChangeRemove, name, "")
+}
+
+// Mkdir creates a new directory.
+func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	return f.record(f.PassthroughFS.Mkdir(ctx, name, perm), contextual.ChangeCreate, name, "")
+}
+
+// MkdirAll creates a directory named name, along with any necessary parents.
+// A single creation of name is recorded.
+func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	return f.record(f.PassthroughFS.MkdirAll(ctx, name, perm), contextual.ChangeCreate, name, "")
+}
+
+// RemoveAll removes name and any children it contains.
+func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
+	return f.record(f.PassthroughFS.RemoveAll(ctx, name), contextual.ChangeRemove, name, "")
+}
+
+// Rename renames oldname to newname.
+func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
+	return f.record(f.PassthroughFS.Rename(ctx, oldname, newname), contextual.ChangeRename, newname, oldname)
+}
+
+// RenameWithOptions renames oldname to newname as directed by flags. An
+// exchange fails with errors.ErrUnsupported, so that
+// contextual.RenameWithOptions emulates it with renames the journal records.
+func (f *filesystem) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	if flags&fsx.RenameExchange != 0 {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.ErrUnsupported}
+	}
+	return f.record(f.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags), contextual.ChangeRename, newname, oldname)
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	return f.record(f.PassthroughFS.Symlink(ctx, oldname, newname), contextual.ChangeCreate, newname, "")
+}
+
+// CreateSpecial creates a special file.
+func (f *filesystem) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	return f.record(f.PassthroughFS.CreateSpecial(ctx, name, mode, dev), contextual.ChangeCreate, name, "")
+}
+
+// Lchown changes the ownership of the named file without following links.
+func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
+	return f.record(f.PassthroughFS.Lchown(ctx, name, owner, group), contextual.ChangeMetadata, name, "")
+}
+
+// Truncate changes the size of the named file.
+func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
+	return f.record(f.PassthroughFS.Truncate(ctx, name, size), contextual.ChangeWrite, name, "")
+}
+
+// WriteFile writes data to the named file, creating it if necessary.
+func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	return f.record(f.PassthroughFS.WriteFile(ctx, name, data, perm), contextual.ChangeWrite, name, "")
+}
+
+// Chown changes the ownership of the named file.
+func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
+	return f.record(f.PassthroughFS.Chown(ctx, name, owner, group), contextual.ChangeMetadata, name, "")
+}
+
+// Chmod changes the mode of the named file.
+func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	return f.record(f.PassthroughFS.Chmod(ctx, name, mode), contextual.ChangeMetadata, name, "")
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (f *filesystem) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	return f.record(f.PassthroughFS.Chtimes(ctx, name, atime, mtime), contextual.ChangeMetadata, name, "")
+}
+
+// SetXattr sets the extended attribute attr of the named file.
+func (f *filesystem) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	return f.record(f.PassthroughFS.SetXattr(ctx, name, attr, value, flags), contextual.ChangeMetadata, name, "")
+}
+
+// RemoveXattr removes the extended attribute attr of the named file.
+func (f *filesystem) RemoveXattr(ctx context.Context, name, attr string) error {
+	return f.record(f.PassthroughFS.RemoveXattr(ctx, name, attr), contextual.ChangeMetadata, name, "")
+}
+
+var _ contextual.FileSystem = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
+var _ contextual.ChangeJournalFS = &filesystem{}
+var _ contextual.SpecialFS = &filesystem{}
+var _ contextual.RenameOptionsFS = &filesystem{}
+var _ contextual.XattrFS = &filesystem{}
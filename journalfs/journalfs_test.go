@@ -0,0 +1,296 @@
+package journalfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/journalfs"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func newJournal(t *testing.T, config journalfs.Config) contextual.FileSystem {
+	t.Helper()
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return journalfs.New(contextual.ToContextual(fsys), config)
+}
+
+// describe flattens changes into "op name" strings, with "old->new" for
+// renames.
+func describe(changes []contextual.Change) []string {
+	var list []string
+	for _, c := range changes {
+		name := c.Name
+		if c.OldName != "" {
+			name = c.OldName + "->" + c.Name
+		}
+		list = append(list, c.Op.String()+" "+name)
+	}
+	return list
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJournalFS(t *testing.T) {
+	t.Run("times changes with the clock", func(t *testing.T) {
+		ctx := t.Context()
+		clock := fsxtest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		fsys := newJournal(t, journalfs.Config{Clock: clock})
+
+		if err := contextual.Mkdir(ctx, fsys, "dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		changes, _, err := contextual.Changes(ctx, fsys, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 1 || !changes[0].Time.Equal(clock.Now()) {
+			t.Errorf("Changes() = %v, want a change at %v", changes, clock.Now())
+		}
+	})
+
+	t.Run("records mutations", func(t *testing.T) {
+		ctx := t.Context()
+		fsys := newJournal(t, journalfs.Config{})
+
+		if err := contextual.Mkdir(ctx, fsys, "dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, fsys, "dir/a", []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Rename(ctx, fsys, "dir/a", "dir/b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Chmod(ctx, fsys, "dir/b", 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Remove(ctx, fsys, "dir/b"); err != nil {
+			t.Fatal(err)
+		}
+		// Failed operations and reads are not recorded.
+		if err := contextual.Remove(ctx, fsys, "missing"); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := contextual.ReadDir(ctx, fsys, "dir"); err != nil {
+			t.Fatal(err)
+		}
+
+		changes, cursor, err := contextual.Changes(ctx, fsys, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"create dir", "write dir/a", "rename dir/a->dir/b", "metadata dir/b", "remove dir/b"}
+		if got := describe(changes); !equal(got, want) {
+			t.Errorf("Changes() = %v, want %v", got, want)
+		}
+		if cursor != 5 {
+			t.Errorf("cursor = %d, want 5", cursor)
+		}
+
+		// Catching up from the returned cursor yields only newer changes.
+		if err := contextual.Mkdir(ctx, fsys, "other", 0755); err != nil {
+			t.Fatal(err)
+		}
+		changes, cursor, err = contextual.Changes(ctx, fsys, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := describe(changes); !equal(got, []string{"create other"}) {
+			t.Errorf("Changes() = %v", got)
+		}
+		if changes, _, err := contextual.Changes(ctx, fsys, cursor); err != nil || len(changes) != 0 {
+			t.Errorf("Changes() at head = %v, %v", changes, err)
+		}
+	})
+
+	t.Run("file handles", func(t *testing.T) {
+		ctx := t.Context()
+		fsys := newJournal(t, journalfs.Config{})
+
+		if err := contextual.WriteFile(ctx, fsys, "file", []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, cursor, _ := contextual.Changes(ctx, fsys, 0)
+
+		// A read-only handle records nothing.
+		f, err := fsys.Open(ctx, "file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+
+		// A writable handle that is never written to records nothing.
+		wf, err := fsys.OpenFile(ctx, "file", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := wf.(io.Seeker); !ok {
+			t.Error("file does not implement io.Seeker")
+		}
+		if _, ok := wf.(io.ReaderAt); !ok {
+			t.Error("file does not implement io.ReaderAt")
+		}
+		_ = wf.Close()
+
+		// The write is recorded on Close.
+		wf, err = fsys.OpenFile(ctx, "file", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wf.Write([]byte("more")); err != nil {
+			t.Fatal(err)
+		}
+		if changes, _, _ := contextual.Changes(ctx, fsys, cursor); len(changes) != 0 {
+			t.Errorf("expected no change before Close, got %v", describe(changes))
+		}
+		if err := wf.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Creating a file is recorded even if no data is written, but
+		// opening an existing file with O_CREATE is not.
+		cf, err := fsys.Create(ctx, "empty")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = cf.Close()
+		cf, err = fsys.OpenFile(ctx, "file", os.O_WRONLY|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = cf.Close()
+
+		changes, _, err := contextual.Changes(ctx, fsys, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := describe(changes); !equal(got, []string{"write file", "create empty"}) {
+			t.Errorf("Changes() = %v", got)
+		}
+	})
+
+	t.Run("expired cursor", func(t *testing.T) {
+		ctx := t.Context()
+		fsys := newJournal(t, journalfs.Config{MaxChanges: 2})
+
+		for _, name := range []string{"a", "b", "c"} {
+			if err := contextual.Mkdir(ctx, fsys, name, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, cursor, err := contextual.Changes(ctx, fsys, 0)
+		if !errors.Is(err, contextual.ErrCursorExpired) {
+			t.Errorf("expected ErrCursorExpired, got %v", err)
+		}
+		if cursor != 3 {
+			t.Errorf("cursor = %d, want 3", cursor)
+		}
+
+		changes, _, err := contextual.Changes(ctx, fsys, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := describe(changes); !equal(got, []string{"create b", "create c"}) {
+			t.Errorf("Changes() = %v", got)
+		}
+
+		if _, _, err := contextual.Changes(ctx, fsys, 10); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected ErrInvalid for a future cursor, got %v", err)
+		}
+	})
+}
+
+func TestJournalFS_RenameWithOptions(t *testing.T) {
+	ctx := t.Context()
+	fsys := newJournal(t, journalfs.Config{})
+	for _, name := range []string{"a", "b"} {
+		if err := contextual.WriteFile(ctx, fsys, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, cursor, err := contextual.Changes(ctx, fsys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := contextual.RenameWithOptions(ctx, fsys, "a", "b", fsx.RenameNoReplace); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("RenameWithOptions(noreplace) error = %v; want ErrExist", err)
+	}
+	if err := contextual.RenameWithOptions(ctx, fsys, "a", "c", fsx.RenameNoReplace); err != nil {
+		t.Fatal(err)
+	}
+	// The exchange is emulated with renames through a temporary name.
+	if err := contextual.RenameWithOptions(ctx, fsys, "b", "c", fsx.RenameExchange); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err := contextual.Changes(ctx, fsys, cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := describe(changes)
+	if len(got) != 4 || got[0] != "rename a->c" || got[2] != "rename b->c" {
+		t.Errorf("Changes() = %v; want a rename and an exchange", got)
+	}
+	if data, err := contextual.ReadFile(ctx, fsys, "b"); err != nil || len(data) != 0 {
+		t.Errorf("ReadFile(b) = %q, %v", data, err)
+	}
+}
+
+func TestJournalFS_Xattr(t *testing.T) {
+	ctx := t.Context()
+	fsys := newJournal(t, journalfs.Config{})
+	if err := contextual.WriteFile(ctx, fsys, "a", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, cursor, err := contextual.Changes(ctx, fsys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = contextual.SetXattr(ctx, fsys, "a", "user.test", []byte("v"), 0)
+	if errors.Is(err, errors.ErrUnsupported) || errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("extended attributes are not supported here")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.RemoveXattr(ctx, fsys, "a", "user.test"); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err := contextual.Changes(ctx, fsys, cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describe(changes); !equal(got, []string{"metadata a", "metadata a"}) {
+		t.Errorf("Changes() = %v", got)
+	}
+}
+
+func TestJournalFS_InvalidPaths(t *testing.T) {
+	fsys := newJournal(t, journalfs.Config{})
+	fsxtest.CheckInvalidPaths(t, fsys)
+	if changes, _, err := contextual.Changes(t.Context(), fsys, 0); err != nil || len(changes) != 0 {
+		t.Errorf("Changes() = %v, %v, want none", changes, err)
+	}
+}
@@ -0,0 +1,214 @@
+package kvfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/gwangyi/fsx"
+)
+
+// file is an open regular file. Its contents are read from the store when it
+// is opened and, if it was opened for writing, stored again when it is
+// closed.
+type file struct {
+	fs   *filesystem
+	ctx  context.Context
+	name string
+	flag int
+
+	mu     sync.Mutex
+	data   []byte
+	off    int64
+	dirty  bool
+	closed bool
+}
+
+// Read reads from the contents if the file was opened for reading.
+func (f *file) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.flag&fsx.O_ACCMODE == os.O_WRONLY {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fsx.ErrBadFileDescriptor}
+	}
+	if f.off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+// Write writes to the contents, at the end of the file if it was opened with
+// os.O_APPEND.
+func (f *file) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.flag&fsx.O_ACCMODE == os.O_RDONLY {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fsx.ErrBadFileDescriptor}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.off = int64(len(f.data))
+	}
+	if end := f.off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = resize(f.data, end)
+	}
+	n := copy(f.data[f.off:], p)
+	f.off += int64(n)
+	f.dirty = true
+	return n, nil
+}
+
+// Seek sets the offset for the next Read or Write.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the contents.
+func (f *file) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.flag&fsx.O_ACCMODE == os.O_RDONLY {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fsx.ErrBadFileDescriptor}
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.data = resize(f.data, size)
+	f.dirty = true
+	return nil
+}
+
+// Stat returns the FileInfo of the file with the size of its contents.
+func (f *file) Stat() (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &fileInfo{name: path.Base(f.name), size: int64(len(f.data)), mode: fileMode}, nil
+}
+
+// Close stores the contents if they were modified.
+func (f *file) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if !f.dirty {
+		return nil
+	}
+	if err := f.fs.store.Put(f.ctx, f.fs.key(f.name), f.data); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return nil
+}
+
+// dir is an open directory. Its entries are listed on the first ReadDir.
+type dir struct {
+	fs   *filesystem
+	ctx  context.Context
+	name string
+
+	mu      sync.Mutex
+	entries []fs.DirEntry
+	listed  bool
+	closed  bool
+}
+
+// ReadDir returns the next n entries of the directory, or all of the
+// remaining ones if n <= 0.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if !d.listed {
+		entries, err := d.fs.readDir(d.ctx, d.name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.entries, d.listed = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// Read fails since d is a directory.
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fsx.ErrIsDir}
+}
+
+// Write fails since d is a directory.
+func (d *dir) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: d.name, Err: fsx.ErrBadFileDescriptor}
+}
+
+// Truncate fails since d is a directory.
+func (d *dir) Truncate(int64) error {
+	return &fs.PathError{Op: "truncate", Path: d.name, Err: fsx.ErrIsDir}
+}
+
+// Stat returns the FileInfo of the directory.
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return &fileInfo{name: path.Base(d.name), mode: dirMode}, nil
+}
+
+// Close closes the directory.
+func (d *dir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
+
+var (
+	_ fsx.File       = &file{}
+	_ fsx.File       = &dir{}
+	_ fs.ReadDirFile = &dir{}
+	_ io.Seeker      = &file{}
+)
@@ -0,0 +1,423 @@
+package kvfs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/kvfs"
+	"github.com/gwangyi/fsx/unionfs"
+)
+
+// mapStore is an in-memory kvfs.Store.
+type mapStore struct {
+	mu   sync.Mutex
+	kv   map[string]string
+	fail error
+}
+
+func newStore(kv map[string]string) *mapStore {
+	if kv == nil {
+		kv = map[string]string{}
+	}
+	return &mapStore{kv: kv}
+}
+
+func (m *mapStore) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return nil, m.fail
+	}
+	v, ok := m.kv[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return []byte(v), nil
+}
+
+func (m *mapStore) Put(_ context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kv[key] = string(value)
+	return nil
+}
+
+func (m *mapStore) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.kv, key)
+	return nil
+}
+
+func (m *mapStore) List(_ context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail != nil {
+		return nil, m.fail
+	}
+	var keys []string
+	for k := range m.kv {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *mapStore) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Sorted(maps.Keys(m.kv))
+}
+
+func TestFS(t *testing.T) {
+	ctx := t.Context()
+	store := newStore(map[string]string{
+		"/cfg/app/name":      "demo",
+		"/cfg/app/db/url":    "postgres://",
+		"/cfg/empty/":        "",
+		"/other/not-visible": "x",
+	})
+	fsys := kvfs.New(store, kvfs.Config{Prefix: "/cfg/"})
+
+	if err := fstest.TestFS(contextual.FromContextual(fsys, ctx), "app/name", "app/db/url", "empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("write", func(t *testing.T) {
+		if err := contextual.WriteFile(ctx, fsys, "app/port", []byte("80"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		f, err := contextual.OpenFile(ctx, fsys, "app/port", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		if _, err := f.Write([]byte("80")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if data, _ := contextual.ReadFile(ctx, fsys, "app/port"); string(data) != "80" {
+			t.Errorf("ReadFile before Close = %q; want 80", data)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "app/port"); err != nil || string(data) != "8080" {
+			t.Errorf("ReadFile = %q, %v; want 8080", data, err)
+		}
+		f, err = contextual.OpenFile(ctx, fsys, "app/port", os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			t.Fatalf("OpenFile(O_TRUNC): %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "app/port"); err != nil || len(data) != 0 {
+			t.Errorf("ReadFile after O_TRUNC = %q, %v; want no contents", data, err)
+		}
+		if err := contextual.WriteFile(ctx, fsys, "app/port", []byte("8080"), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if err := contextual.Truncate(ctx, fsys, "app/port", 2); err != nil {
+			t.Fatalf("Truncate: %v", err)
+		}
+		if info, err := contextual.Stat(ctx, fsys, "app/port"); err != nil || info.Size() != 2 || info.Mode() != 0644 {
+			t.Errorf("Stat = %v, %v; want a file of 2 bytes", info, err)
+		}
+		if err := contextual.WriteFile(ctx, fsys, "missing/port", nil, 0644); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("WriteFile without parent error = %v; want ErrNotExist", err)
+		}
+		if err := contextual.WriteFile(ctx, fsys, "app/name/x", nil, 0644); !errors.Is(err, fsx.ErrNotDir) {
+			t.Errorf("WriteFile below a file error = %v; want ErrNotDir", err)
+		}
+		fsxtest.CheckCreateExclusive(t, contextual.FromContextual(fsys, ctx), "app/new")
+		fsxtest.CheckReadOnlyHandle(t, contextual.FromContextual(fsys, ctx), "app/name")
+		fsxtest.CheckAppend(t, contextual.FromContextual(fsys, ctx), "app/appended")
+	})
+
+	t.Run("directories", func(t *testing.T) {
+		if err := contextual.Mkdir(ctx, fsys, "app", 0755); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("Mkdir of an implied directory error = %v; want ErrExist", err)
+		}
+		if err := contextual.MkdirAll(ctx, fsys, "a/b/c", 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if entries, err := contextual.ReadDir(ctx, fsys, "a"); err != nil || len(entries) != 1 || !entries[0].IsDir() {
+			t.Errorf("ReadDir(a) = %v, %v; want the directory b", entries, err)
+		}
+		if err := contextual.Remove(ctx, fsys, "app"); !errors.Is(err, syscall.ENOTEMPTY) {
+			t.Errorf("Remove of a non-empty directory error = %v; want ENOTEMPTY", err)
+		}
+		if err := contextual.Remove(ctx, fsys, "empty"); err != nil {
+			t.Errorf("Remove of an empty directory: %v", err)
+		}
+		if err := contextual.Rename(ctx, fsys, "app", "a/b/c"); err != nil {
+			t.Fatalf("Rename: %v", err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "a/b/c/db/url"); err != nil || string(data) != "postgres://" {
+			t.Errorf("ReadFile after Rename = %q, %v", data, err)
+		}
+		if err := contextual.Rename(ctx, fsys, "a", "a/b/d"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Rename beneath itself error = %v; want ErrInvalid", err)
+		}
+		if err := contextual.RemoveAll(ctx, fsys, "a"); err != nil {
+			t.Fatalf("RemoveAll: %v", err)
+		}
+		want := []string{"/other/not-visible"}
+		if got := store.keys(); !slices.Equal(got, want) {
+			t.Errorf("keys = %q; want %q", got, want)
+		}
+		if entries, err := contextual.ReadDir(ctx, fsys, "."); err != nil || len(entries) != 0 {
+			t.Errorf("ReadDir(.) = %v, %v; want no entries", entries, err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		fsxtest.CheckErrorOps(t, fsys, "missing")
+		fsxtest.CheckInvalidPaths(t, fsys)
+
+		store.fail = errors.New("store down")
+		defer func() { store.fail = nil }()
+		_, err := contextual.Stat(ctx, fsys, "x")
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || pathErr.Op != "stat" || !errors.Is(err, store.fail) {
+			t.Errorf("Stat error = %v; want a stat PathError wrapping the store error", err)
+		}
+	})
+}
+
+func TestFS_Union(t *testing.T) {
+	ctx := t.Context()
+	defaults := kvfs.New(newStore(map[string]string{"level": "info", "name": "demo"}), kvfs.Config{})
+	overrides := newStore(nil)
+	union := unionfs.New(kvfs.New(overrides, kvfs.Config{}), defaults)
+
+	if err := contextual.WriteFile(ctx, union, "level", []byte("debug"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	f, err := contextual.Open(ctx, union, "name")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil || string(data) != "demo" {
+		t.Errorf("ReadAll = %q, %v; want demo", data, err)
+	}
+	if got := overrides.keys(); !slices.Equal(got, []string{"level"}) {
+		t.Errorf("override keys = %q; want [level]", got)
+	}
+}
+
+// batchStore is a mapStore getting many keys at once.
+type batchStore struct {
+	*mapStore
+	batches int
+}
+
+func (b *batchStore) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	b.batches++
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if v, err := b.Get(ctx, key); err == nil {
+			values[key] = v
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func TestFS_ReadFiles(t *testing.T) {
+	ctx := t.Context()
+	kv := map[string]string{"cfg/a": "A", "cfg/b": "B", "cfg/dir/c": "C"}
+
+	for _, tc := range []struct {
+		name  string
+		store kvfs.Store
+	}{
+		{"Store", newStore(maps.Clone(kv))},
+		{"BatchStore", &batchStore{mapStore: newStore(maps.Clone(kv))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := kvfs.New(tc.store, kvfs.Config{Prefix: "cfg/"})
+			contents, err := contextual.ReadFiles(ctx, fsys, []string{"a", "b", "dir", "missing", "dir/c"})
+			if len(contents) != 3 || string(contents["a"]) != "A" || string(contents["b"]) != "B" || string(contents["dir/c"]) != "C" {
+				t.Errorf("ReadFiles() contents = %q", contents)
+			}
+			var multi *contextual.MultiPathError
+			if !errors.As(err, &multi) || len(multi.Errors) != 2 ||
+				!errors.Is(multi.Errors[0], fsx.ErrIsDir) || !errors.Is(multi.Errors[1], fs.ErrNotExist) {
+				t.Errorf("ReadFiles() error = %v; want ErrIsDir on dir and ErrNotExist on missing", err)
+			}
+			if b, ok := tc.store.(*batchStore); ok && b.batches != 1 {
+				t.Errorf("expected 1 batch, got %d", b.batches)
+			}
+		})
+	}
+
+	store := &batchStore{mapStore: newStore(maps.Clone(kv))}
+	store.fail = errors.New("store down")
+	contents, err := contextual.ReadFiles(ctx, kvfs.New(store, kvfs.Config{Prefix: "cfg/"}), []string{"a", "b"})
+	var multi *contextual.MultiPathError
+	if len(contents) != 0 || !errors.As(err, &multi) || len(multi.Errors) != 2 || !errors.Is(err, store.fail) {
+		t.Errorf("ReadFiles() with a failing store = %q, %v", contents, err)
+	}
+}
+
+// statusError is the error of an HTTP client library, carrying a status code.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string   { return "status " + strconv.Itoa(e.code) }
+func (e *statusError) StatusCode() int { return e.code }
+
+// httpStore is a mapStore reached over HTTP, reporting missing keys with a
+// 404 status rather than fs.ErrNotExist.
+type httpStore struct {
+	*mapStore
+}
+
+func (s httpStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.mapStore.Get(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &statusError{code: 404}
+	}
+	return value, err
+}
+
+func TestFS_ErrorMapper(t *testing.T) {
+	ctx := t.Context()
+	fsxtest.CheckErrorMapper(t, contextual.MapHTTPErrors, map[error]error{
+		&statusError{code: 404}: fs.ErrNotExist,
+		&statusError{code: 403}: fs.ErrPermission,
+	})
+
+	store := httpStore{newStore(map[string]string{"app/name": "demo"})}
+	fsys := kvfs.New(store, kvfs.Config{ErrorMapper: contextual.MapHTTPErrors})
+	if data, err := contextual.ReadFile(ctx, fsys, "app/name"); err != nil || string(data) != "demo" {
+		t.Errorf("ReadFile = %q, %v; want demo", data, err)
+	}
+	if _, err := contextual.Stat(ctx, fsys, "app/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+	fsxtest.CheckErrorOps(t, fsys, "missing")
+
+	store.fail = &statusError{code: 403}
+	_, err := contextual.ReadFile(ctx, fsys, "app/name")
+	var sErr *statusError
+	if !errors.Is(err, fs.ErrPermission) || !errors.As(err, &sErr) {
+		t.Errorf("expected fs.ErrPermission keeping the error of the store, got %v", err)
+	}
+	store.fail = nil
+
+	// Without the mapper, the 404 breaks the lookup of directories.
+	fsys = kvfs.New(store, kvfs.Config{})
+	if _, err := contextual.Stat(ctx, fsys, "app"); err == nil {
+		t.Error("expected the error of the store without ErrorMapper")
+	}
+}
+
+// txMapStore is a mapStore applying transactions.
+type txMapStore struct {
+	*mapStore
+	applied int
+}
+
+func (m *txMapStore) Apply(_ context.Context, puts map[string][]byte, deletes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applied++
+	for _, key := range deletes {
+		delete(m.kv, key)
+	}
+	for key, value := range puts {
+		m.kv[key] = string(value)
+	}
+	return nil
+}
+
+func TestFS_BeginTx(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("commit", func(t *testing.T) {
+		store := &txMapStore{mapStore: newStore(map[string]string{"a/x": "x", "a/y": "y"})}
+		fsys := kvfs.New(store, kvfs.Config{})
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Rename(ctx, tx, "a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "b/z", []byte("z"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if entries, err := contextual.ReadDir(ctx, tx, "b"); err != nil || len(entries) != 3 {
+			t.Errorf("ReadDir(tx) = %v, %v; want 3 entries", entries, err)
+		}
+		if got, want := store.keys(), []string{"a/x", "a/y"}; !slices.Equal(got, want) {
+			t.Errorf("keys before Commit = %v, want %v", got, want)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := store.keys(), []string{"b/x", "b/y", "b/z"}; !slices.Equal(got, want) {
+			t.Errorf("keys after Commit = %v, want %v", got, want)
+		}
+		if store.applied != 1 {
+			t.Errorf("expected one Apply, got %d", store.applied)
+		}
+		if err := contextual.WriteFile(ctx, tx, "c", nil, 0644); !errors.Is(err, contextual.ErrTxDone) {
+			t.Errorf("expected ErrTxDone, got %v", err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		store := &txMapStore{mapStore: newStore(map[string]string{"a": "a"})}
+		tx, err := contextual.BeginTx(ctx, kvfs.New(store, kvfs.Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Remove(ctx, tx, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if got := store.keys(); !slices.Equal(got, []string{"a"}) || store.applied != 0 {
+			t.Errorf("keys after Rollback = %v, applied %d times", got, store.applied)
+		}
+	})
+
+	t.Run("emulated", func(t *testing.T) {
+		store := newStore(map[string]string{"a": "a"})
+		tx, err := contextual.BeginTx(ctx, kvfs.New(store, kvfs.Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "a", []byte("b"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := store.keys(); !slices.Equal(got, []string{"a"}) || store.kv["a"] != "b" {
+			t.Errorf("keys after Commit = %v, a = %q", got, store.kv["a"])
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package kvfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// BeginTx starts a transaction if the store is a TxStore. The changes made
+// in the transaction are kept in memory, over the keys of the store, and
+// Commit applies them with a single call to Apply. Keys changed in the
+// store meanwhile are overwritten, unless Apply detects the conflict. Other
+// stores report errors.ErrUnsupported, for contextual.BeginTx to emulate
+// the transaction.
+func (f *filesystem) BeginTx(ctx context.Context) (contextual.Tx, error) {
+	if f.apply == nil {
+		return nil, errors.ErrUnsupported
+	}
+	store := &txStore{base: f.store, puts: make(map[string][]byte), deletes: make(map[string]bool)}
+	return &tx{
+		PassthroughFS: contextual.PassthroughFS{Inner: &filesystem{store: store, config: f.config}},
+		ctx:           ctx,
+		store:         store,
+		apply:         f.apply,
+	}, nil
+}
+
+// tx is a transaction of a kvfs over a TxStore, made through a kvfs over a
+// txStore.
+type tx struct {
+	contextual.PassthroughFS
+	ctx   context.Context
+	store *txStore
+	apply func(ctx context.Context, puts map[string][]byte, deletes []string) error
+}
+
+// Commit applies the changes of the transaction to the store.
+func (t *tx) Commit() error {
+	puts, deletes, err := t.store.end()
+	if err != nil {
+		return err
+	}
+	return t.apply(t.ctx, puts, deletes)
+}
+
+// Rollback discards the changes of the transaction.
+func (t *tx) Rollback() error {
+	_, _, err := t.store.end()
+	return err
+}
+
+// txStore is a Store recording the changes made over base.
+type txStore struct {
+	base Store
+
+	mu      sync.Mutex
+	puts    map[string][]byte
+	deletes map[string]bool
+	done    bool
+}
+
+// end ends the transaction, returning its changes.
+func (s *txStore) end() (map[string][]byte, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil, nil, contextual.ErrTxDone
+	}
+	s.done = true
+	return s.puts, slices.Sorted(maps.Keys(s.deletes)), nil
+}
+
+func (s *txStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	value, put := s.puts[key]
+	deleted := s.deletes[key]
+	s.mu.Unlock()
+	switch {
+	case put:
+		return slices.Clone(value), nil
+	case deleted:
+		return nil, fs.ErrNotExist
+	}
+	return s.base.Get(ctx, key)
+}
+
+func (s *txStore) Put(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return contextual.ErrTxDone
+	}
+	s.puts[key] = slices.Clone(value)
+	delete(s.deletes, key)
+	return nil
+}
+
+func (s *txStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return contextual.ErrTxDone
+	}
+	delete(s.puts, key)
+	s.deletes[key] = true
+	return nil
+}
+
+func (s *txStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.base.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys = slices.DeleteFunc(keys, func(key string) bool {
+		_, put := s.puts[key]
+		return put || s.deletes[key]
+	})
+	for key := range s.puts {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+var _ contextual.TxFS = &filesystem{}
+var _ contextual.Tx = &tx{}
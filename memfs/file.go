@@ -0,0 +1,274 @@
+package memfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx"
+)
+
+// handle is an open file or directory. It reads and writes its node under
+// the lock of the tree, and keeps reading a node removed from the tree.
+type handle struct {
+	tree *tree
+	node *node
+	name string
+	flag int
+
+	// mu guards the fields below. It is taken before the lock of the tree.
+	mu      sync.Mutex
+	off     int64
+	entries []fs.DirEntry
+	listed  bool
+	closed  bool
+}
+
+func newHandle(t *tree, n *node, name string, flag int) *handle {
+	return &handle{tree: t, node: n, name: name, flag: flag}
+}
+
+// check returns the error of op on the handle if it is closed, or if it was
+// not opened for writing and write is set.
+func (h *handle) check(op string, write bool) error {
+	if h.closed {
+		return &fs.PathError{Op: op, Path: h.name, Err: fs.ErrClosed}
+	}
+	if write && h.flag&fsx.O_ACCMODE == os.O_RDONLY {
+		return &fs.PathError{Op: op, Path: h.name, Err: fsx.ErrBadFileDescriptor}
+	}
+	if !write && h.flag&fsx.O_ACCMODE == os.O_WRONLY {
+		return &fs.PathError{Op: op, Path: h.name, Err: fsx.ErrBadFileDescriptor}
+	}
+	return nil
+}
+
+// Read reads from the current offset.
+func (h *handle) Read(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n, err := h.readAt("read", p, h.off)
+	h.off += int64(n)
+	return n, err
+}
+
+// ReadAt reads from offset off, leaving the current offset unchanged.
+func (h *handle) ReadAt(p []byte, off int64) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: h.name, Err: fs.ErrInvalid}
+	}
+	n, err := h.readAt("read", p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (h *handle) readAt(op string, p []byte, off int64) (int, error) {
+	if err := h.check(op, false); err != nil {
+		return 0, err
+	}
+	h.tree.mu.RLock()
+	defer h.tree.mu.RUnlock()
+	if h.node.mode.IsDir() {
+		return 0, &fs.PathError{Op: op, Path: h.name, Err: fsx.ErrIsDir}
+	}
+	if off >= int64(len(h.node.data)) {
+		return 0, io.EOF
+	}
+	return copy(p, h.node.data[off:]), nil
+}
+
+// Write writes at the current offset, or at the end of the file if it was
+// opened with os.O_APPEND.
+func (h *handle) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.check("write", true); err != nil {
+		return 0, err
+	}
+	h.tree.mu.Lock()
+	defer h.tree.mu.Unlock()
+	h.tree.version++
+	n := h.node
+	if h.flag&os.O_APPEND != 0 {
+		h.off = int64(len(n.data))
+	}
+	n.own()
+	if end := h.off + int64(len(p)); end > int64(len(n.data)) {
+		n.data = resize(n.data, end)
+	}
+	copy(n.data[h.off:], p)
+	h.off += int64(len(p))
+	n.modTime = h.tree.now()
+	n.ctime = n.modTime
+	return len(p), nil
+}
+
+// Seek sets the offset for the next Read or Write.
+func (h *handle) Seek(offset int64, whence int) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return 0, &fs.PathError{Op: "seek", Path: h.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += h.off
+	case io.SeekEnd:
+		h.tree.mu.RLock()
+		offset += int64(len(h.node.data))
+		h.tree.mu.RUnlock()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: h.name, Err: fs.ErrInvalid}
+	}
+	h.off = offset
+	return offset, nil
+}
+
+// Truncate changes the size of the file.
+func (h *handle) Truncate(size int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: h.name, Err: fs.ErrInvalid}
+	}
+	h.tree.mu.Lock()
+	defer h.tree.mu.Unlock()
+	h.tree.version++
+	h.node.own()
+	h.node.data = resize(h.node.data, size)
+	h.node.modTime = h.tree.now()
+	h.node.ctime = h.node.modTime
+	return nil
+}
+
+// Stat returns the FileInfo of the file.
+func (h *handle) Stat() (fs.FileInfo, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, &fs.PathError{Op: "stat", Path: h.name, Err: fs.ErrClosed}
+	}
+	h.tree.mu.RLock()
+	defer h.tree.mu.RUnlock()
+	return newFileInfo(path.Base(h.name), h.node), nil
+}
+
+// ReadDir returns the next n entries of the directory, or all of the
+// remaining ones if n <= 0.
+func (h *handle) ReadDir(n int) ([]fs.DirEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: h.name, Err: fs.ErrClosed}
+	}
+	if !h.listed {
+		h.tree.mu.RLock()
+		isDir := h.node.mode.IsDir()
+		if isDir {
+			h.entries = entries(h.node)
+		}
+		h.tree.mu.RUnlock()
+		if !isDir {
+			return nil, &fs.PathError{Op: "readdir", Path: h.name, Err: fsx.ErrNotDir}
+		}
+		h.listed = true
+	}
+	if n <= 0 {
+		list := h.entries
+		h.entries = nil
+		return list, nil
+	}
+	if len(h.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(h.entries))
+	list := h.entries[:n]
+	h.entries = h.entries[n:]
+	return list, nil
+}
+
+// Clone returns a new handle of the same file, with the same access mode and
+// offset.
+func (h *handle) Clone() (fsx.File, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, &fs.PathError{Op: "clone", Path: h.name, Err: fs.ErrClosed}
+	}
+	c := newHandle(h.tree, h.node, h.name, h.flag&^(os.O_CREATE|os.O_EXCL|os.O_TRUNC))
+	c.off = h.off
+	return c, nil
+}
+
+// Close closes the handle.
+func (h *handle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return &fs.PathError{Op: "close", Path: h.name, Err: fs.ErrClosed}
+	}
+	h.closed = true
+	return nil
+}
+
+// entries returns the entries of the directory n sorted by name.
+func entries(n *node) []fs.DirEntry {
+	list := make([]fs.DirEntry, 0, len(n.children))
+	for name, child := range n.children {
+		list = append(list, fs.FileInfoToDirEntry(newFileInfo(name, child)))
+	}
+	slices.SortFunc(list, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return list
+}
+
+// fileInfo describes a node at the time it was looked up.
+type fileInfo struct {
+	name                  string
+	size                  int64
+	mode                  fs.FileMode
+	owner, group          string
+	modTime, atime, ctime time.Time
+}
+
+func newFileInfo(name string, n *node) *fileInfo {
+	size := int64(len(n.data))
+	if n.mode&fs.ModeSymlink != 0 {
+		size = int64(len(n.target))
+	}
+	return &fileInfo{
+		name: name, size: size, mode: n.mode,
+		owner: n.owner, group: n.group,
+		modTime: n.modTime, atime: n.atime, ctime: n.ctime,
+	}
+}
+
+func (fi *fileInfo) Name() string          { return fi.name }
+func (fi *fileInfo) Size() int64           { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode     { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time    { return fi.modTime }
+func (fi *fileInfo) IsDir() bool           { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() any              { return nil }
+func (fi *fileInfo) Owner() string         { return fi.owner }
+func (fi *fileInfo) Group() string         { return fi.group }
+func (fi *fileInfo) AccessTime() time.Time { return fi.atime }
+func (fi *fileInfo) ChangeTime() time.Time { return fi.ctime }
+
+var (
+	_ fsx.CloneFile  = &handle{}
+	_ fs.ReadDirFile = &handle{}
+	_ io.ReaderAt    = &handle{}
+	_ io.Seeker      = &handle{}
+)
@@ -0,0 +1,692 @@
+// Package memfs provides a contextual filesystem keeping its files in memory.
+//
+// It supports the whole contextual.FileSystem interface, including symbolic
+// links, ownership and times, which makes it suited to tests and to scratch
+// layers of unionfs. TakeSnapshot copies the state of a memfs into an
+// immutable Snapshot, itself a read-only filesystem usable as a layer of a
+// union, and Restore rolls a memfs back to a snapshot. Contents are shared
+// between a memfs and its snapshots until they are written, so taking a
+// snapshot costs the size of the tree, not of the contents. The same copies
+// back the transactions of a memfs, started with contextual.BeginTx.
+package memfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// maxLinks bounds the symbolic links followed while resolving a name, like
+// the limit of the kernel that makes it fail with ELOOP.
+const maxLinks = 40
+
+// Config specifies the configuration for memfs.
+type Config struct {
+	// Clock sets the times of the files. If nil, contextual.RealClock is
+	// used.
+	Clock contextual.Clock
+	// Owner and Group own the files created in the filesystem, until Chown
+	// changes them.
+	Owner, Group string
+}
+
+// node is a file, a directory or a symbolic link.
+type node struct {
+	mode     fs.FileMode
+	data     []byte
+	target   string
+	children map[string]*node
+
+	owner, group          string
+	modTime, atime, ctime time.Time
+	// shared tells that data is shared with a snapshot, and must be copied
+	// before it is modified.
+	shared bool
+	// origin is the node of the memfs a node of a transaction was copied
+	// from, which Commit updates in place.
+	origin *node
+}
+
+// clone returns a copy of the tree at n, sharing the contents of its files.
+func (n *node) clone() *node {
+	c := *n
+	n.shared, c.shared = true, true
+	if n.children != nil {
+		c.children = make(map[string]*node, len(n.children))
+		for name, child := range n.children {
+			c.children[name] = child.clone()
+		}
+	}
+	return &c
+}
+
+// own makes the contents of n its own, so that they can be modified.
+func (n *node) own() {
+	if n.shared {
+		n.data = append([]byte(nil), n.data...)
+		n.shared = false
+	}
+}
+
+// contains reports whether d is n or one of its descendants.
+func (n *node) contains(d *node) bool {
+	if n == d {
+		return true
+	}
+	for _, child := range n.children {
+		if child.contains(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// tree is a tree of nodes, and implements the read operations shared by a
+// memfs and its snapshots.
+type tree struct {
+	mu    sync.RWMutex
+	root  *node
+	clock contextual.Clock
+	// version counts the changes made to the tree, so that transactions
+	// can tell whether it changed since they began.
+	version uint64
+}
+
+// now returns the current time of the clock.
+func (t *tree) now() time.Time {
+	return contextual.ClockOr(t.clock).Now()
+}
+
+// walk returns the node named name, following the symbolic links met on the
+// way, and the last one too if followLast is set. Absolute link destinations
+// start at the root of the tree, and ".." stops there.
+func (t *tree) walk(name string, followLast bool) (*node, error) {
+	stack := []*node{t.root}
+	parts := split(name)
+	links := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		cur := stack[len(stack)-1]
+		switch part {
+		case ".":
+			continue
+		case "..":
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if !cur.mode.IsDir() {
+			return nil, fsx.ErrNotDir
+		}
+		child, ok := cur.children[part]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		if child.mode&fs.ModeSymlink != 0 && (len(parts) > 0 || followLast) {
+			if links++; links > maxLinks {
+				return nil, syscall.ELOOP
+			}
+			if path.IsAbs(child.target) {
+				stack = stack[:1]
+			}
+			parts = append(split(child.target), parts...)
+			continue
+		}
+		stack = append(stack, child)
+	}
+	return stack[len(stack)-1], nil
+}
+
+// split returns the elements of a name or link destination.
+func split(name string) []string {
+	var parts []string
+	for part := range strings.SplitSeq(name, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// Open opens the named file for reading.
+func (t *tree) Open(ctx context.Context, name string) (fs.File, error) {
+	if err := internal.CheckPath("open", name); err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n, err := t.walk(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return newHandle(t, n, name, os.O_RDONLY), nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (t *tree) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := internal.CheckPath("readfile", name); err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n, err := t.walk(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	if n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fsx.ErrIsDir}
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+// Stat returns a FileInfo describing the named file, following symbolic
+// links.
+func (t *tree) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	return t.stat("stat", name, true)
+}
+
+// Lstat returns a FileInfo describing the named file, or the symbolic link
+// itself.
+func (t *tree) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	return t.stat("lstat", name, false)
+}
+
+func (t *tree) stat(op, name string, follow bool) (fs.FileInfo, error) {
+	if err := internal.CheckPath(op, name); err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n, err := t.walk(name, follow)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return newFileInfo(path.Base(name), n), nil
+}
+
+// ReadLink returns the destination of the named symbolic link.
+func (t *tree) ReadLink(ctx context.Context, name string) (string, error) {
+	if err := internal.CheckPath("readlink", name); err != nil {
+		return "", err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n, err := t.walk(name, false)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.target, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (t *tree) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n, err := t.walk(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fsx.ErrNotDir}
+	}
+	return entries(n), nil
+}
+
+// ReadDirPage reads at most n entries of the named directory following the
+// cursor, which is the name of the last entry of the previous page. Only the
+// entries of the page are built.
+func (t *tree) ReadDirPage(ctx context.Context, name, cursor string, n int) ([]fs.DirEntry, string, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, "", err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	d, err := t.walk(name, true)
+	if err != nil {
+		return nil, "", &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if !d.mode.IsDir() {
+		return nil, "", &fs.PathError{Op: "readdir", Path: name, Err: fsx.ErrNotDir}
+	}
+	names := make([]string, 0, len(d.children))
+	for child := range d.children {
+		if child > cursor {
+			names = append(names, child)
+		}
+	}
+	slices.Sort(names)
+	next := ""
+	if n > 0 && n < len(names) {
+		names = names[:n]
+		next = names[n-1]
+	}
+	list := make([]fs.DirEntry, len(names))
+	for i, child := range names {
+		list[i] = fs.FileInfoToDirEntry(newFileInfo(child, d.children[child]))
+	}
+	return list, next, nil
+}
+
+// filesystem is a contextual filesystem keeping its files in memory.
+type filesystem struct {
+	tree
+	config Config
+}
+
+// New creates a new empty memfs.
+func New(config Config) contextual.FileSystem {
+	f := &filesystem{tree: tree{clock: config.Clock}, config: config}
+	f.root = f.newNode(fs.ModeDir | 0755)
+	return f
+}
+
+// newNode returns a node of the given mode, owned by the configured owner.
+func (f *filesystem) newNode(mode fs.FileMode) *node {
+	now := f.now()
+	n := &node{mode: mode, owner: f.config.Owner, group: f.config.Group, modTime: now, atime: now, ctime: now}
+	if mode.IsDir() {
+		n.children = make(map[string]*node)
+	}
+	return n
+}
+
+// parent returns the directory that holds, or would hold, name, and the base
+// name of name in it.
+func (f *filesystem) parent(name string) (*node, string, error) {
+	if name == "." {
+		return nil, "", fs.ErrInvalid
+	}
+	dir, err := f.walk(path.Dir(name), true)
+	if err != nil {
+		return nil, "", err
+	}
+	if !dir.mode.IsDir() {
+		return nil, "", fsx.ErrNotDir
+	}
+	return dir, path.Base(name), nil
+}
+
+// link adds n to dir as base and updates the times of dir.
+func (f *filesystem) link(dir *node, base string, n *node) {
+	dir.children[base] = n
+	dir.modTime = f.now()
+	dir.ctime = dir.modTime
+}
+
+// unlink removes base from dir and updates the times of dir.
+func (f *filesystem) unlink(dir *node, base string) {
+	delete(dir.children, base)
+	dir.modTime = f.now()
+	dir.ctime = dir.modTime
+}
+
+// Open opens the named file for reading.
+func (f *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
+	return f.OpenFile(ctx, name, os.O_RDONLY, 0)
+}
+
+// Create creates or truncates the named file.
+func (f *filesystem) Create(ctx context.Context, name string) (fsx.File, error) {
+	return f.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the named file. Files are created with the permission bits
+// of mode.
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	if err := internal.CheckOpen(name, flag); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		f.version++
+	}
+	n, err := f.open(name, flag, mode)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return newHandle(&f.tree, n, name, flag), nil
+}
+
+func (f *filesystem) open(name string, flag int, mode fs.FileMode) (*node, error) {
+	n, err := f.walk(name, true)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, fs.ErrExist
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		dir, base, err := f.parent(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := dir.children[base]; ok {
+			// A dangling symbolic link.
+			return nil, fs.ErrNotExist
+		}
+		n = f.newNode(mode.Perm())
+		f.link(dir, base, n)
+		return n, nil
+	case err != nil:
+		return nil, err
+	}
+	writing := flag&fsx.O_ACCMODE != os.O_RDONLY
+	if n.mode.IsDir() && writing {
+		return nil, fsx.ErrIsDir
+	}
+	if flag&os.O_TRUNC != 0 && n.mode.IsRegular() {
+		n.data, n.shared = nil, false
+		n.modTime = f.now()
+		n.ctime = n.modTime
+	}
+	return n, nil
+}
+
+// Remove removes the named file, symbolic link or empty directory.
+func (f *filesystem) Remove(ctx context.Context, name string) error {
+	if err := internal.CheckPath("remove", name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	dir, base, err := f.parent(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	n, ok := dir.children[base]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if len(n.children) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	f.unlink(dir, base)
+	return nil
+}
+
+// RemoveAll removes name and everything below it. It returns nil if name
+// does not exist.
+func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
+	if err := internal.CheckPath("removeall", name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	if name == "." {
+		clear(f.root.children)
+		return nil
+	}
+	dir, base, err := f.parent(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return &fs.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	if _, ok := dir.children[base]; ok {
+		f.unlink(dir, base)
+	}
+	return nil
+}
+
+// WriteFile writes data to the named file, creating it with perm if
+// necessary.
+func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	if err := internal.CheckPath("writefile", name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	n, err := f.open(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return &fs.PathError{Op: "writefile", Path: name, Err: err}
+	}
+	n.data = append([]byte(nil), data...)
+	return nil
+}
+
+// Mkdir creates a directory.
+func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := internal.CheckPath("mkdir", name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	return internal.Decorate("mkdir", name, f.mkdir(name, perm))
+}
+
+func (f *filesystem) mkdir(name string, perm fs.FileMode) error {
+	dir, base, err := f.parent(name)
+	if errors.Is(err, fs.ErrInvalid) {
+		return fs.ErrExist
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := dir.children[base]; ok {
+		return fs.ErrExist
+	}
+	f.link(dir, base, f.newNode(fs.ModeDir|perm.Perm()))
+	return nil
+}
+
+// MkdirAll creates a directory along with its missing parents.
+func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := internal.CheckPath("mkdir", name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	for p := range dirs(name) {
+		n, err := f.walk(p, true)
+		if err == nil && !n.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: fsx.ErrNotDir}
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			err = f.mkdir(p, perm)
+		}
+		if err != nil {
+			return internal.Decorate("mkdir", name, err)
+		}
+	}
+	return nil
+}
+
+// dirs yields name and its parents, starting with the topmost.
+func dirs(name string) func(yield func(string) bool) {
+	return func(yield func(string) bool) {
+		if name == "." {
+			return
+		}
+		for i := range name {
+			if name[i] == '/' && !yield(name[:i]) {
+				return
+			}
+		}
+		yield(name)
+	}
+}
+
+// Rename moves oldname to newname, replacing a file, or an empty directory,
+// at newname.
+func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("rename", oldname, newname); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	return internal.IntoLinkErr("rename", oldname, newname, f.rename(oldname, newname))
+}
+
+func (f *filesystem) rename(oldname, newname string) error {
+	oldDir, oldBase, err := f.parent(oldname)
+	if err != nil {
+		return err
+	}
+	n, ok := oldDir.children[oldBase]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	newDir, newBase, err := f.parent(newname)
+	if err != nil {
+		return err
+	}
+	if target, ok := newDir.children[newBase]; ok {
+		switch {
+		case target == n:
+			return nil
+		case target.mode.IsDir() && !n.mode.IsDir():
+			return fsx.ErrIsDir
+		case !target.mode.IsDir() && n.mode.IsDir():
+			return fsx.ErrNotDir
+		case len(target.children) > 0:
+			return syscall.ENOTEMPTY
+		}
+	}
+	if n.mode.IsDir() && n.contains(newDir) {
+		return fs.ErrInvalid
+	}
+	f.unlink(oldDir, oldBase)
+	f.link(newDir, newBase, n)
+	n.ctime = f.now()
+	return nil
+}
+
+// Symlink creates a symbolic link at newname pointing to oldname.
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("symlink", oldname, newname); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	dir, base, err := f.parent(newname)
+	if err == nil {
+		if _, ok := dir.children[base]; ok {
+			err = fs.ErrExist
+		}
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	n := f.newNode(fs.ModeSymlink | 0777)
+	n.target = oldname
+	f.link(dir, base, n)
+	return nil
+}
+
+// change applies fn to the named node, following the last symbolic link if
+// follow is set, and updates its change time.
+func (f *filesystem) change(op, name string, follow bool, fn func(n *node) error) error {
+	if err := internal.CheckPath(op, name); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+	n, err := f.walk(name, follow)
+	if err == nil {
+		err = fn(n)
+	}
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	n.ctime = f.now()
+	return nil
+}
+
+// Chown changes the owner and group of the named file. Empty names are left
+// unchanged.
+func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
+	return f.change("chown", name, true, chown(owner, group))
+}
+
+// Lchown is like Chown but changes a symbolic link itself.
+func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
+	return f.change("lchown", name, false, chown(owner, group))
+}
+
+func chown(owner, group string) func(n *node) error {
+	return func(n *node) error {
+		if owner != "" {
+			n.owner = owner
+		}
+		if group != "" {
+			n.group = group
+		}
+		return nil
+	}
+}
+
+// Chmod changes the permission bits of the named file, along with the
+// setuid, setgid and sticky bits kept by fsx.NormalizeMode for its type.
+func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	return f.change("chmod", name, true, func(n *node) error {
+		n.mode = fsx.NormalizeMode(n.mode.Type() | mode&^fs.ModeType)
+		return nil
+	})
+}
+
+// Chtimes changes the access and modification times of the named file. Zero
+// times are left unchanged.
+func (f *filesystem) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	return f.change("chtimes", name, true, func(n *node) error {
+		if !atime.IsZero() {
+			n.atime = atime
+		}
+		if !mtime.IsZero() {
+			n.modTime = mtime
+		}
+		return nil
+	})
+}
+
+// Truncate changes the size of the named file.
+func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
+	return f.change("truncate", name, true, func(n *node) error {
+		if n.mode.IsDir() {
+			return fsx.ErrIsDir
+		}
+		if size < 0 {
+			return fs.ErrInvalid
+		}
+		n.own()
+		n.data = resize(n.data, size)
+		n.modTime = f.now()
+		return nil
+	})
+}
+
+// resize returns data grown with zeros or shortened to size.
+func resize(data []byte, size int64) []byte {
+	if size <= int64(len(data)) {
+		return data[:size]
+	}
+	return append(data, make([]byte, size-int64(len(data)))...)
+}
+
+var _ contextual.FileSystem = &filesystem{}
+var _ contextual.ReadDirPageFS = &filesystem{}
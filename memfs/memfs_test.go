@@ -0,0 +1,355 @@
+package memfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
+	"github.com/gwangyi/fsx/unionfs"
+)
+
+func TestFS(t *testing.T) {
+	ctx := t.Context()
+	clock := fsxtest.NewClock(time.Unix(1000, 0))
+	fsys := memfs.New(memfs.Config{Clock: clock, Owner: "alice", Group: "staff"})
+	fsxtest.AssertImplements(t, fsys, fsxtest.FileSystem)
+
+	if err := contextual.MkdirAll(ctx, fsys, "dir/sub", 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := contextual.WriteFile(ctx, fsys, "dir/a", []byte("hello"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := contextual.Symlink(ctx, fsys, "../a", "dir/sub/link"); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if err := contextual.Symlink(ctx, fsys, "/dir", "abs"); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	if err := fstest.TestFS(contextual.FromContextual(fsys, ctx), "dir/a", "dir/sub/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("metadata", func(t *testing.T) {
+		info, err := contextual.Stat(ctx, fsys, "abs/sub/link")
+		if err != nil || info.Size() != 5 || info.Mode() != 0600 {
+			t.Fatalf("Stat through links = %v, %v; want the file a", info, err)
+		}
+		fi := contextual.ExtendFileInfo(info)
+		if fi.Owner() != "alice" || fi.Group() != "staff" || !fi.ModTime().Equal(time.Unix(1000, 0)) {
+			t.Errorf("Stat = %s:%s %v", fi.Owner(), fi.Group(), fi.ModTime())
+		}
+		if info, err := contextual.Lstat(ctx, fsys, "dir/sub/link"); err != nil || info.Mode().Type() != fs.ModeSymlink {
+			t.Errorf("Lstat = %v, %v; want a symbolic link", info, err)
+		}
+		if target, err := contextual.ReadLink(ctx, fsys, "dir/sub/link"); err != nil || target != "../a" {
+			t.Errorf("ReadLink = %q, %v", target, err)
+		}
+
+		clock.Advance(time.Hour)
+		if err := contextual.Chown(ctx, fsys, "dir/sub/link", "bob", ""); err != nil {
+			t.Fatalf("Chown: %v", err)
+		}
+		if err := contextual.Chmod(ctx, fsys, "dir/a", 0644); err != nil {
+			t.Fatalf("Chmod: %v", err)
+		}
+		fi = contextual.ExtendFileInfo(must(contextual.Stat(ctx, fsys, "dir/a")))
+		if fi.Owner() != "bob" || fi.Group() != "staff" || fi.Mode() != 0644 || !fi.ChangeTime().Equal(time.Unix(4600, 0)) {
+			t.Errorf("Stat after Chown and Chmod = %s:%s %v %v", fi.Owner(), fi.Group(), fi.Mode(), fi.ChangeTime())
+		}
+		mtime := time.Unix(2000, 0)
+		if err := contextual.Chtimes(ctx, fsys, "dir/a", time.Time{}, mtime); err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+		if info := must(contextual.Stat(ctx, fsys, "dir/a")); !info.ModTime().Equal(mtime) {
+			t.Errorf("ModTime = %v; want %v", info.ModTime(), mtime)
+		}
+	})
+
+	t.Run("files", func(t *testing.T) {
+		f, err := contextual.OpenFile(ctx, fsys, "dir/a", os.O_RDWR|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		defer func() { _ = f.Close() }()
+		if _, err := f.Write([]byte(" world")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		clone, err := fsx.Clone(f)
+		if err != nil {
+			t.Fatalf("Clone: %v", err)
+		}
+		if _, err := clone.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek: %v", err)
+		}
+		if data, err := io.ReadAll(clone); err != nil || string(data) != "hello world" {
+			t.Errorf("ReadAll(clone) = %q, %v", data, err)
+		}
+		_ = clone.Close()
+		if err := f.Truncate(5); err != nil {
+			t.Fatalf("Truncate: %v", err)
+		}
+		if data := must(contextual.ReadFile(ctx, fsys, "dir/a")); string(data) != "hello" {
+			t.Errorf("ReadFile = %q; want hello", data)
+		}
+		fsxtest.CheckCreateExclusive(t, contextual.FromContextual(fsys, ctx), "dir/new")
+		fsxtest.CheckReadOnlyHandle(t, contextual.FromContextual(fsys, ctx), "dir/a")
+		fsxtest.CheckAppend(t, contextual.FromContextual(fsys, ctx), "dir/appended")
+	})
+
+	t.Run("tree", func(t *testing.T) {
+		if err := contextual.Remove(ctx, fsys, "dir"); !errors.Is(err, syscall.ENOTEMPTY) {
+			t.Errorf("Remove of a non-empty directory error = %v; want ENOTEMPTY", err)
+		}
+		if err := contextual.Rename(ctx, fsys, "dir", "dir/sub/dir"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Rename beneath itself error = %v; want ErrInvalid", err)
+		}
+		if err := contextual.Rename(ctx, fsys, "dir/a", "dir/sub"); !errors.Is(err, fsx.ErrIsDir) {
+			t.Errorf("Rename over a directory error = %v; want ErrIsDir", err)
+		}
+		if err := contextual.Symlink(ctx, fsys, "loop", "loop"); err != nil {
+			t.Fatalf("Symlink: %v", err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, "loop"); !errors.Is(err, syscall.ELOOP) {
+			t.Errorf("Stat of a loop error = %v; want ELOOP", err)
+		}
+		if err := contextual.Rename(ctx, fsys, "dir", "moved"); err != nil {
+			t.Fatalf("Rename: %v", err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, "abs"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat of a dangling link error = %v; want ErrNotExist", err)
+		}
+		if err := contextual.RemoveAll(ctx, fsys, "moved"); err != nil {
+			t.Fatalf("RemoveAll: %v", err)
+		}
+		fsxtest.CheckNotExist(t, contextual.FromContextual(fsys, ctx), "moved")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		fsxtest.CheckErrorOps(t, fsys, "missing")
+		fsxtest.CheckInvalidPaths(t, fsys)
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := t.Context()
+	fsys := memfs.New(memfs.Config{})
+	if err := contextual.WriteFile(ctx, fsys, "a", []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snap := memfs.TakeSnapshot(fsys)
+
+	f, err := contextual.OpenFile(ctx, fsys, "a", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.WriteFile(ctx, fsys, "b", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data := must(contextual.ReadFile(ctx, snap, "a")); string(data) != "one" {
+		t.Errorf("snapshot a = %q; want one", data)
+	}
+	fsxtest.CheckNotExist(t, contextual.FromContextual(snap, ctx), "b")
+	fsxtest.AssertNotImplements(t, snap, fsxtest.Writer)
+
+	// A union over the snapshot forks it.
+	fork := unionfs.New(memfs.New(memfs.Config{}), snap)
+	if err := contextual.WriteFile(ctx, fork, "a", []byte("fork"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data := must(contextual.ReadFile(ctx, snap, "a")); string(data) != "one" {
+		t.Errorf("snapshot a after fork write = %q; want one", data)
+	}
+
+	memfs.Restore(fsys, snap)
+	if data := must(contextual.ReadFile(ctx, fsys, "a")); string(data) != "one" {
+		t.Errorf("restored a = %q; want one", data)
+	}
+	fsxtest.CheckNotExist(t, contextual.FromContextual(fsys, ctx), "b")
+
+	// The handle opened before Restore is detached.
+	if _, err := f.Write([]byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	if err := contextual.WriteFile(ctx, fsys, "a", []byte("three"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	memfs.Restore(fsys, snap)
+	if data := must(contextual.ReadFile(ctx, fsys, "a")); string(data) != "one" {
+		t.Errorf("a restored twice = %q; want one", data)
+	}
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestFS_ReadDirPage(t *testing.T) {
+	ctx := t.Context()
+	fsys := memfs.New(memfs.Config{})
+	if err := contextual.Mkdir(ctx, fsys, "dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"c", "a", "e", "d", "b"} {
+		if err := contextual.WriteFile(ctx, fsys, "dir/"+name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	cursor := ""
+	for {
+		entries, next, err := contextual.ReadDirPage(ctx, fsys, "dir", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) > 2 {
+			t.Fatalf("got a page of %d entries; want at most 2", len(entries))
+		}
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("pages = %v; want %v", got, want)
+	}
+
+	if _, _, err := contextual.ReadDirPage(ctx, fsys, "dir/a", "", 2); !errors.Is(err, fsx.ErrNotDir) {
+		t.Errorf("ReadDirPage on a file: got %v; want ErrNotDir", err)
+	}
+	if _, _, err := contextual.ReadDirPage(ctx, fsys, "missing", "", 2); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDirPage on a missing directory: got %v; want ErrNotExist", err)
+	}
+}
+
+func TestFS_BeginTx(t *testing.T) {
+	ctx := t.Context()
+	newFS := func(t *testing.T) contextual.FileSystem {
+		t.Helper()
+		fsys := memfs.New(memfs.Config{})
+		if err := contextual.WriteFile(ctx, fsys, "file", []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fsys
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		fsys := newFS(t)
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.MkdirAll(ctx, tx, "dir/sub", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Rename(ctx, tx, "file", "dir/sub/file"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, "file"); err != nil {
+			t.Errorf("expected the memfs to be unchanged, got %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "dir/sub/file"); err != nil || string(data) != "old" {
+			t.Errorf("ReadFile() = %q, %v; want old", data, err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, "file"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected file to be moved, got %v", err)
+		}
+		if err := tx.Rollback(); !errors.Is(err, contextual.ErrTxDone) {
+			t.Errorf("expected ErrTxDone, got %v", err)
+		}
+	})
+
+	t.Run("open files", func(t *testing.T) {
+		fsys := newFS(t)
+		f, err := contextual.OpenFile(ctx, fsys, "file", os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "file", []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		// Files opened on the memfs stay attached to it.
+		if data, err := io.ReadAll(f); err != nil || string(data) != "new" {
+			t.Errorf("Read() = %q, %v; want new", data, err)
+		}
+		if _, err := f.Write([]byte("er")); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "file"); err != nil || string(data) != "newer" {
+			t.Errorf("ReadFile() = %q, %v; want newer", data, err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		fsys := newFS(t)
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Remove(ctx, tx, "file"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, "file"); err != nil {
+			t.Errorf("expected file to stay, got %v", err)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		fsys := newFS(t)
+		tx, err := contextual.BeginTx(ctx, fsys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, tx, "file", []byte("tx"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Reads do not conflict, writes do.
+		if _, err := contextual.ReadFile(ctx, fsys, "file"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, fsys, "other", nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); !errors.Is(err, contextual.ErrTxConflict) {
+			t.Errorf("expected ErrTxConflict, got %v", err)
+		}
+		if data, err := contextual.ReadFile(ctx, fsys, "file"); err != nil || string(data) != "old" {
+			t.Errorf("ReadFile() = %q, %v; want old", data, err)
+		}
+	})
+}
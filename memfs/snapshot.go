@@ -0,0 +1,50 @@
+package memfs
+
+import (
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// Snapshot is an immutable copy of the files of a memfs, taken by
+// TakeSnapshot. It is a read-only contextual filesystem, which can serve as
+// a read-only layer of unionfs, and Restore rolls a memfs back to it.
+//
+// Together they support speculative changes: take a snapshot, change the
+// memfs, then either keep the changes or restore the snapshot to discard
+// them. Alternatively, a union of a fresh memfs over the snapshot collects
+// the changes apart.
+type Snapshot struct {
+	tree
+}
+
+// TakeSnapshot returns a snapshot of the files of the memfs fsys. Later
+// changes to fsys do not affect the snapshot.
+func TakeSnapshot(fsys contextual.FS) *Snapshot {
+	f := fsys.(*filesystem)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &Snapshot{tree: tree{root: f.root.clone()}}
+}
+
+// Restore replaces the files of the memfs fsys with those of s, which can be
+// restored again later. Files opened before keep their former contents,
+// detached from fsys.
+func Restore(fsys contextual.FS, s *Snapshot) {
+	f := fsys.(*filesystem)
+	s.mu.Lock()
+	root := s.root.clone()
+	s.mu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.root = root
+	f.version++
+}
+
+var (
+	_ contextual.ReadFileFS = &Snapshot{}
+	_ contextual.ReadDirFS  = &Snapshot{}
+	_ contextual.ReadLinkFS = &Snapshot{}
+	_ contextual.StatFS     = &Snapshot{}
+
+	_ contextual.ReadDirPageFS = &Snapshot{}
+)
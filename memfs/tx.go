@@ -0,0 +1,92 @@
+package memfs
+
+import (
+	"context"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// BeginTx starts a transaction over a copy of the files of the memfs, taken
+// like TakeSnapshot, so that the changes of the transaction are isolated
+// from the memfs, and those made to the memfs from the transaction. Commit
+// replaces the files of the memfs with those of the copy, unless the memfs
+// was changed since the transaction began, in which case nothing is applied
+// and it fails with contextual.ErrTxConflict. Files opened on the memfs see
+// the changes once committed, while those opened through the transaction
+// keep the contents of the copy, detached from the memfs.
+func (f *filesystem) BeginTx(ctx context.Context) (contextual.Tx, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	root := f.root.clone()
+	track(root, f.root)
+	work := &filesystem{tree: tree{root: root, clock: f.clock}, config: f.config}
+	return &tx{filesystem: work, base: f, version: f.version}, nil
+}
+
+// track records in c, a copy of the tree at n, the nodes its nodes were
+// copied from.
+func track(c, n *node) {
+	c.origin = n
+	for name, child := range c.children {
+		track(child, n.children[name])
+	}
+}
+
+// commit moves the nodes of c, a copy of the tree of a transaction, to the
+// memfs, and returns its root. The nodes of the memfs they were copied from
+// are updated in place, so that the files open on the memfs see the changes.
+func commit(c *node) *node {
+	for name, child := range c.children {
+		c.children[name] = commit(child)
+	}
+	o := c.origin
+	c.origin = nil
+	if o == nil {
+		return c
+	}
+	*o = *c
+	return o
+}
+
+// tx is a transaction of a memfs, made on the embedded copy.
+type tx struct {
+	*filesystem
+	base *filesystem
+	// version is the version of base the copy was taken from.
+	version uint64
+	// done is guarded by the lock of base.
+	done bool
+}
+
+// Commit replaces the files of the memfs with those of the transaction.
+func (t *tx) Commit() error {
+	t.base.mu.Lock()
+	defer t.base.mu.Unlock()
+	if t.done {
+		return contextual.ErrTxDone
+	}
+	t.done = true
+	if t.base.version != t.version {
+		return contextual.ErrTxConflict
+	}
+	t.mu.Lock()
+	root := t.root.clone()
+	t.mu.Unlock()
+	t.base.root = commit(root)
+	t.base.version++
+	return nil
+}
+
+// Rollback ends the transaction, leaving the memfs as it is.
+func (t *tx) Rollback() error {
+	t.base.mu.Lock()
+	defer t.base.mu.Unlock()
+	if t.done {
+		return contextual.ErrTxDone
+	}
+	t.done = true
+	return nil
+}
+
+var _ contextual.TxFS = &filesystem{}
+var _ contextual.Tx = &tx{}
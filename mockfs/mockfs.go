@@ -1,9 +1,9 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/gwangyi/fsx (interfaces: WriterFS,DirEntry,File,ReadDirFile,FileInfo,ChangeFS,DirFS,LchownFS,MkdirAllFS,RemoveAllFS,RenameFS,SymlinkFS,TruncateFS,WriteFileFS,FileSystem)
+// Source: github.com/gwangyi/fsx (interfaces: WriterFS,DirEntry,File,ReadDirFile,SeekableFile,ReaderAtFile,SyncFile,FileInfo,ChangeFS,DirFS,LchownFS,MkdirAllFS,RemoveAllFS,RenameFS,SymlinkFS,TruncateFS,WriteFileFS,FileSystem)
 //
 // Generated by this command:
 //
-//	mockgen -destination mockfs/mockfs.go -package mockfs . WriterFS,DirEntry,File,ReadDirFile,FileInfo,ChangeFS,DirFS,LchownFS,MkdirAllFS,RemoveAllFS,RenameFS,SymlinkFS,TruncateFS,WriteFileFS,FileSystem
+//	mockgen -destination mockfs/mockfs.go -package mockfs . WriterFS,DirEntry,File,ReadDirFile,SeekableFile,ReaderAtFile,SyncFile,FileInfo,ChangeFS,DirFS,LchownFS,MkdirAllFS,RemoveAllFS,RenameFS,SymlinkFS,TruncateFS,WriteFileFS,FileSystem
 //
 
 // Package mockfs is a generated GoMock package.
@@ -362,6 +362,341 @@ func (mr *MockReadDirFileMockRecorder) Stat() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockReadDirFile)(nil).Stat))
 }
 
+// MockSeekableFile is a mock of SeekableFile interface.
+type MockSeekableFile struct {
+	ctrl     *gomock.Controller
+	recorder *MockSeekableFileMockRecorder
+	isgomock struct{}
+}
+
+// MockSeekableFileMockRecorder is the mock recorder for MockSeekableFile.
+type MockSeekableFileMockRecorder struct {
+	mock *MockSeekableFile
+}
+
+// NewMockSeekableFile creates a new mock instance.
+func NewMockSeekableFile(ctrl *gomock.Controller) *MockSeekableFile {
+	mock := &MockSeekableFile{ctrl: ctrl}
+	mock.recorder = &MockSeekableFileMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSeekableFile) EXPECT() *MockSeekableFileMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockSeekableFile) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockSeekableFileMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockSeekableFile)(nil).Close))
+}
+
+// Read mocks base method.
+func (m *MockSeekableFile) Read(arg0 []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Read", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Read indicates an expected call of Read.
+func (mr *MockSeekableFileMockRecorder) Read(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockSeekableFile)(nil).Read), arg0)
+}
+
+// Seek mocks base method.
+func (m *MockSeekableFile) Seek(offset int64, whence int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Seek", offset, whence)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Seek indicates an expected call of Seek.
+func (mr *MockSeekableFileMockRecorder) Seek(offset, whence any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Seek", reflect.TypeOf((*MockSeekableFile)(nil).Seek), offset, whence)
+}
+
+// Stat mocks base method.
+func (m *MockSeekableFile) Stat() (fs.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stat")
+	ret0, _ := ret[0].(fs.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat.
+func (mr *MockSeekableFileMockRecorder) Stat() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockSeekableFile)(nil).Stat))
+}
+
+// Truncate mocks base method.
+func (m *MockSeekableFile) Truncate(size int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Truncate", size)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Truncate indicates an expected call of Truncate.
+func (mr *MockSeekableFileMockRecorder) Truncate(size any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Truncate", reflect.TypeOf((*MockSeekableFile)(nil).Truncate), size)
+}
+
+// Write mocks base method.
+func (m *MockSeekableFile) Write(p []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Write", p)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Write indicates an expected call of Write.
+func (mr *MockSeekableFileMockRecorder) Write(p any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockSeekableFile)(nil).Write), p)
+}
+
+// MockReaderAtFile is a mock of ReaderAtFile interface.
+type MockReaderAtFile struct {
+	ctrl     *gomock.Controller
+	recorder *MockReaderAtFileMockRecorder
+	isgomock struct{}
+}
+
+// MockReaderAtFileMockRecorder is the mock recorder for MockReaderAtFile.
+type MockReaderAtFileMockRecorder struct {
+	mock *MockReaderAtFile
+}
+
+// NewMockReaderAtFile creates a new mock instance.
+func NewMockReaderAtFile(ctrl *gomock.Controller) *MockReaderAtFile {
+	mock := &MockReaderAtFile{ctrl: ctrl}
+	mock.recorder = &MockReaderAtFileMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReaderAtFile) EXPECT() *MockReaderAtFileMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockReaderAtFile) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockReaderAtFileMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockReaderAtFile)(nil).Close))
+}
+
+// Read mocks base method.
+func (m *MockReaderAtFile) Read(arg0 []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Read", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Read indicates an expected call of Read.
+func (mr *MockReaderAtFileMockRecorder) Read(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockReaderAtFile)(nil).Read), arg0)
+}
+
+// ReadAt mocks base method.
+func (m *MockReaderAtFile) ReadAt(p []byte, off int64) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadAt", p, off)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadAt indicates an expected call of ReadAt.
+func (mr *MockReaderAtFileMockRecorder) ReadAt(p, off any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAt", reflect.TypeOf((*MockReaderAtFile)(nil).ReadAt), p, off)
+}
+
+// Stat mocks base method.
+func (m *MockReaderAtFile) Stat() (fs.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stat")
+	ret0, _ := ret[0].(fs.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat.
+func (mr *MockReaderAtFileMockRecorder) Stat() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockReaderAtFile)(nil).Stat))
+}
+
+// Truncate mocks base method.
+func (m *MockReaderAtFile) Truncate(size int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Truncate", size)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Truncate indicates an expected call of Truncate.
+func (mr *MockReaderAtFileMockRecorder) Truncate(size any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Truncate", reflect.TypeOf((*MockReaderAtFile)(nil).Truncate), size)
+}
+
+// Write mocks base method.
+func (m *MockReaderAtFile) Write(p []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Write", p)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Write indicates an expected call of Write.
+func (mr *MockReaderAtFileMockRecorder) Write(p any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockReaderAtFile)(nil).Write), p)
+}
+
+// MockSyncFile is a mock of SyncFile interface.
+type MockSyncFile struct {
+	ctrl     *gomock.Controller
+	recorder *MockSyncFileMockRecorder
+	isgomock struct{}
+}
+
+// MockSyncFileMockRecorder is the mock recorder for MockSyncFile.
+type MockSyncFileMockRecorder struct {
+	mock *MockSyncFile
+}
+
+// NewMockSyncFile creates a new mock instance.
+func NewMockSyncFile(ctrl *gomock.Controller) *MockSyncFile {
+	mock := &MockSyncFile{ctrl: ctrl}
+	mock.recorder = &MockSyncFileMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSyncFile) EXPECT() *MockSyncFileMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockSyncFile) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockSyncFileMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockSyncFile)(nil).Close))
+}
+
+// Read mocks base method.
+func (m *MockSyncFile) Read(arg0 []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Read", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Read indicates an expected call of Read.
+func (mr *MockSyncFileMockRecorder) Read(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockSyncFile)(nil).Read), arg0)
+}
+
+// Stat mocks base method.
+func (m *MockSyncFile) Stat() (fs.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stat")
+	ret0, _ := ret[0].(fs.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stat indicates an expected call of Stat.
+func (mr *MockSyncFileMockRecorder) Stat() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stat", reflect.TypeOf((*MockSyncFile)(nil).Stat))
+}
+
+// Sync mocks base method.
+func (m *MockSyncFile) Sync() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Sync")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Sync indicates an expected call of Sync.
+func (mr *MockSyncFileMockRecorder) Sync() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sync", reflect.TypeOf((*MockSyncFile)(nil).Sync))
+}
+
+// Truncate mocks base method.
+func (m *MockSyncFile) Truncate(size int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Truncate", size)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Truncate indicates an expected call of Truncate.
+func (mr *MockSyncFileMockRecorder) Truncate(size any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Truncate", reflect.TypeOf((*MockSyncFile)(nil).Truncate), size)
+}
+
+// Write mocks base method.
+func (m *MockSyncFile) Write(p []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Write", p)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Write indicates an expected call of Write.
+func (mr *MockSyncFileMockRecorder) Write(p any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Write", reflect.TypeOf((*MockSyncFile)(nil).Write), p)
+}
+
 // MockFileInfo is a mock of FileInfo interface.
 type MockFileInfo struct {
 	ctrl     *gomock.Controller
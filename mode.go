@@ -0,0 +1,53 @@
+package fsx
+
+import "io/fs"
+
+// NormalizeMode returns mode in the form every backend of this module
+// reports it, so that modes read from different backends, or from the same
+// tree copied between platforms, compare equal. It keeps the type and the
+// permission bits and drops the bits describing how a backend stores the
+// file, such as fs.ModeAppend, fs.ModeTemporary or fs.ModeIrregular.
+//
+// The setuid, setgid and sticky bits are kept where Unix systems honour
+// them: setuid on regular files, setgid on regular files and directories,
+// and sticky on directories. Character devices always carry fs.ModeDevice,
+// as reported by package os, and symbolic links have permission 0777, as
+// their permissions are ignored.
+func NormalizeMode(mode fs.FileMode) fs.FileMode {
+	typ := mode.Type() &^ fs.ModeIrregular
+	if typ&fs.ModeCharDevice != 0 {
+		typ |= fs.ModeDevice
+	}
+	if typ&fs.ModeSymlink != 0 {
+		return fs.ModeSymlink | 0777
+	}
+	n := typ | mode.Perm()
+	switch {
+	case typ == 0:
+		n |= mode & (fs.ModeSetuid | fs.ModeSetgid)
+	case typ == fs.ModeDir:
+		n |= mode & (fs.ModeSetgid | fs.ModeSticky)
+	}
+	return n
+}
+
+// PortableMode returns the part of mode that survives a copy to any
+// platform, including Windows, which only stores whether a file is
+// read-only: it is NormalizeMode(mode) without the setuid, setgid and
+// sticky bits, with the permission bits reduced to 0666 for writable files
+// and 0444 for the others, plus 0111 for directories.
+func PortableMode(mode fs.FileMode) fs.FileMode {
+	mode = NormalizeMode(mode)
+	typ := mode.Type()
+	if typ&fs.ModeSymlink != 0 {
+		return mode
+	}
+	perm := fs.FileMode(0444)
+	if mode&0200 != 0 {
+		perm = 0666
+	}
+	if typ == fs.ModeDir {
+		perm |= 0111
+	}
+	return typ | perm
+}
@@ -0,0 +1,35 @@
+package fsx_test
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+)
+
+func TestNormalizeMode(t *testing.T) {
+	tests := []struct {
+		mode, normal, portable fs.FileMode
+	}{
+		{0644, 0644, 0666},
+		{0444, 0444, 0444},
+		{0755 | fs.ModeSetuid | fs.ModeSticky, 0755 | fs.ModeSetuid, 0666},
+		{0600 | fs.ModeAppend | fs.ModeExclusive | fs.ModeTemporary, 0600, 0666},
+		{fs.ModeDir | 0755 | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky, fs.ModeDir | 0755 | fs.ModeSetgid | fs.ModeSticky, fs.ModeDir | 0777},
+		{fs.ModeDir | 0555, fs.ModeDir | 0555, fs.ModeDir | 0555},
+		{fs.ModeSymlink | 0755, fs.ModeSymlink | 0777, fs.ModeSymlink | 0777},
+		{fs.ModeCharDevice | 0620 | fs.ModeSetgid, fs.ModeDevice | fs.ModeCharDevice | 0620, fs.ModeDevice | fs.ModeCharDevice | 0666},
+		{fs.ModeIrregular | 0644, 0644, 0666},
+	}
+	for _, tt := range tests {
+		if got := fsx.NormalizeMode(tt.mode); got != tt.normal {
+			t.Errorf("NormalizeMode(%v) = %v; want %v", tt.mode, got, tt.normal)
+		}
+		if got := fsx.PortableMode(tt.mode); got != tt.portable {
+			t.Errorf("PortableMode(%v) = %v; want %v", tt.mode, got, tt.portable)
+		}
+		if got := fsx.NormalizeMode(tt.normal); got != tt.normal {
+			t.Errorf("NormalizeMode(%v) = %v; want it unchanged", tt.normal, got)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package osfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/gwangyi/fsx"
+)
+
+// file is a file opened within the filesystem's root. It embeds `*os.File`,
+// keeping all of its methods, including those used by `io.Copy` such as
+// `ReadFrom` and `WriteTo`, and adds `Clone`.
+type file struct {
+	*os.File
+	// flag is the flag the file was opened with.
+	flag int
+}
+
+// wrapFile returns `f`, opened with `flag`, as a `file`, or `err` if the
+// open failed.
+func wrapFile(f *os.File, flag int, err error) (fsx.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &file{File: f, flag: flag}, nil
+}
+
+// Clone returns a new handle of the same file, with its own offset starting
+// at the current offset of the file. It reopens the file itself rather than
+// its name, so that the clone refers to the same file even if it was renamed
+// or replaced since it was opened.
+//
+// Returns:
+//
+//	The new handle, which must be closed separately, or an error wrapping
+//	`errors.ErrUnsupported` if the platform cannot reopen open files.
+func (f *file) Clone() (fsx.File, error) {
+	flag := f.flag &^ (os.O_CREATE | os.O_EXCL | os.O_TRUNC)
+	clone, err := reopen(f.File, flag)
+	if err != nil {
+		return nil, &fs.PathError{Op: "clone", Path: f.Name(), Err: err}
+	}
+	if flag&os.O_APPEND == 0 {
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err == nil {
+			_, err = clone.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			_ = clone.Close()
+			return nil, &fs.PathError{Op: "clone", Path: f.Name(), Err: err}
+		}
+	}
+	return &file{File: clone, flag: flag}, nil
+}
+
+// Restat describes the file as it is now, with fstat on its descriptor
+// rather than a lookup of its name, so that it keeps describing the opened
+// file if it was renamed or replaced.
+func (f *file) Restat() (fs.FileInfo, error) {
+	return f.File.Stat()
+}
+
+var (
+	_ fsx.CloneFile  = &file{}
+	_ fsx.RestatFile = &file{}
+)
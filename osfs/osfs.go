@@ -12,6 +12,7 @@ import (
 	"os"
 
 	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/internal"
 )
 
 // filesystem is the main implementation of the `fsx.WriterFS` interface for the `osfs` package.
@@ -65,7 +66,8 @@ func New(name string) (fs.FS, error) {
 //	An `fsx.File` instance representing the newly created file, or an error if
 //	the file cannot be created (e.g., due to invalid path or permissions).
 func (fsys minimalFS) Create(name string) (fsx.File, error) {
-	return fsys.Root.Create(name)
+	f, err := fsys.Root.Create(name)
+	return wrapFile(f, os.O_RDWR|os.O_CREATE|os.O_TRUNC, err)
 }
 
 // Open opens the named file for reading within the filesystem's root.
@@ -79,7 +81,8 @@ func (fsys minimalFS) Create(name string) (fsx.File, error) {
 //	cannot be opened (e.g., file not found, permission denied, or `name`
 //	attempts to access a path outside the confined root).
 func (fsys minimalFS) Open(name string) (fs.File, error) {
-	return fsys.Root.Open(name)
+	f, err := fsys.Root.Open(name)
+	return wrapFile(f, os.O_RDONLY, err)
 }
 
 // OpenFile opens the named file within the filesystem's root with specified flags and mode.
@@ -98,9 +101,22 @@ func (fsys minimalFS) Open(name string) (fs.File, error) {
 //
 //	An `fsx.File` instance that satisfies the requested flags, or an error if the
 //	file cannot be opened (e.g., due to invalid path, permissions, or if `name`
-//	attempts to access a path outside the confined root).
+//	attempts to access a path outside the confined root). Combinations of flags
+//	rejected by `contextual.ValidateFlags`, such as `os.O_TRUNC` on a read-only
+//	file, fail before reaching the OS.
 func (fsys minimalFS) OpenFile(name string, flag int, mode fs.FileMode) (fsx.File, error) {
-	return fsys.Root.OpenFile(name, flag, mode)
+	if err := internal.ValidateFlags(flag); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	f, err := fsys.Root.OpenFile(name, flag, mode)
+	return wrapFile(f, flag, err)
+}
+
+// Close closes the underlying `os.Root`, releasing the handle of the root directory.
+// Operations on the filesystem fail once it is closed, and closing it again returns nil.
+// Filesystems derived with `Sub` hold their own handles and must be closed separately.
+func (fsys minimalFS) Close() error {
+	return fsys.Root.Close()
 }
 
 // ReadDir reads the named directory within the filesystem's root and returns a list of directory entries
@@ -143,6 +159,30 @@ func (fsys filesystem) ReadLink(name string) (string, error) {
 	return fsys.Readlink(name)
 }
 
+// Sub returns a new filesystem confined to the subdirectory `dir` of the filesystem's root.
+// The child root is opened with `os.Root.OpenRoot`, which resolves `dir` relative to the
+// already-open parent root instead of a recomputed host path. The returned filesystem keeps
+// the same no-escape guarantees, even if `dir` or one of its ancestors is renamed concurrently.
+//
+// Parameters:
+//
+//	dir: The path to the subdirectory, relative to the confined root.
+//
+// Returns:
+//
+//	A new `fs.FS` instance confined to `dir`, or an error if `dir` is not a valid path,
+//	does not exist, is not a directory, or points outside the confined root.
+func (fsys filesystem) Sub(dir string) (fs.FS, error) {
+	if !fs.ValidPath(dir) {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrInvalid}
+	}
+	r, err := fsys.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return filesystem{minimalFS: minimalFS{Root: r}}, nil
+}
+
 // Ensure that `filesystem` correctly implements all expected filesystem interfaces.
 // This compile-time check verifies that `filesystem` satisfies the contracts defined by:
 // - `fsx.WriterFS`: The primary filesystem interface.
@@ -154,12 +194,24 @@ func (fsys filesystem) ReadLink(name string) (string, error) {
 // - `fsx.SymlinkFS`: For symlinks.
 // - `fsx.ChangeFS`: For metadata operations.
 // - `fsx.LchownFS`: For symlink metadata operations.
+// - `fsx.AccessFS`: For permission checks.
+// - `fs.SubFS`: For deriving confined subdirectory filesystems.
+// - `fsx.SpecialFS`: For named pipes, sockets and device nodes.
+// - `fsx.RenameOptionsFS`: For renames that do not replace or that exchange files.
+// - `fsx.XattrFS`: For extended attributes, on Linux and macOS.
+// - `fsx.CloserFS`: For releasing the handle of the root directory.
 var _ fsx.WriterFS = filesystem{}
 var _ fs.ReadFileFS = filesystem{}
 var _ fsx.WriteFileFS = filesystem{}
 var _ fsx.RenameFS = filesystem{}
+var _ fsx.RenameOptionsFS = filesystem{}
 var _ fsx.DirFS = filesystem{}
 var _ fsx.RemoveAllFS = filesystem{}
 var _ fsx.SymlinkFS = filesystem{}
 var _ fsx.ChangeFS = filesystem{}
 var _ fsx.LchownFS = filesystem{}
+var _ fsx.AccessFS = filesystem{}
+var _ fs.SubFS = filesystem{}
+var _ fsx.SpecialFS = filesystem{}
+var _ fsx.XattrFS = filesystem{}
+var _ fsx.CloserFS = filesystem{}
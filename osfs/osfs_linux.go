@@ -0,0 +1,237 @@
+//go:build linux
+
+package osfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/gwangyi/fsx"
+)
+
+// Access checks whether the calling process can access the named file within
+// the filesystem's root with the given mode, using faccessat(2) relative to the
+// file's parent directory opened through `os.Root`.
+//
+// Symbolic links are not resolved by this method, since following them with
+// faccessat could escape the root. For them it returns `errors.ErrUnsupported`,
+// letting `fsx.Access` fall back to a `Stat`-based check confined to the root.
+//
+// Parameters:
+//
+//	name: The path to the file, relative to the confined root.
+//	mode: A combination of `fsx.R_OK`, `fsx.W_OK` and `fsx.X_OK`, or `fsx.F_OK`.
+//
+// Returns:
+//
+//	nil if access is granted, or an error wrapping `fs.ErrPermission` if it is
+//	denied (or another error if the file cannot be found).
+func (fsys filesystem) Access(name string, mode uint32) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "access", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := fsys.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return errors.ErrUnsupported
+	}
+
+	dir, err := fsys.Root.Open(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+
+	if err := syscall.Faccessat(int(dir.Fd()), path.Base(name), mode, 0); err != nil {
+		if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EROFS) {
+			err = fs.ErrPermission
+		}
+		return &fs.PathError{Op: "access", Path: name, Err: err}
+	}
+	return nil
+}
+
+// CreateSpecial creates a named pipe, socket or device node within the filesystem's
+// root using mknodat(2) relative to the parent directory opened through `os.Root`.
+// Creating device nodes usually requires elevated privileges.
+//
+// Parameters:
+//
+//	name: The path of the new file, relative to the confined root.
+//	mode: The type bits (`fs.ModeNamedPipe`, `fs.ModeSocket`, `fs.ModeDevice`,
+//	      `fs.ModeCharDevice`) and permissions of the new file.
+//	dev:  The device number for device nodes, as built by `fsx.Mkdev`.
+//
+// Returns:
+//
+//	An error if `mode` does not describe a special file, if the file already
+//	exists, or if it cannot be created.
+func (fsys filesystem) CreateSpecial(name string, mode fs.FileMode, dev uint64) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var typ uint32
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		typ = syscall.S_IFIFO
+	case mode&fs.ModeSocket != 0:
+		typ = syscall.S_IFSOCK
+	case mode&fs.ModeCharDevice != 0:
+		typ = syscall.S_IFCHR
+	case mode&fs.ModeDevice != 0:
+		typ = syscall.S_IFBLK
+	default:
+		return &fs.PathError{Op: "mknod", Path: name, Err: fs.ErrInvalid}
+	}
+
+	perm := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		perm |= syscall.S_ISUID
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perm |= syscall.S_ISGID
+	}
+	if mode&fs.ModeSticky != 0 {
+		perm |= syscall.S_ISVTX
+	}
+
+	dir, err := fsys.Root.Open(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dir.Close() }()
+
+	if err := syscall.Mknodat(int(dir.Fd()), path.Base(name), typ|perm, int(dev)); err != nil {
+		return &fs.PathError{Op: "mknod", Path: name, Err: err}
+	}
+	return nil
+}
+
+// sysRenameat2 is the number of the renameat2(2) system call, which the
+// syscall package only defines for some architectures. It is zero where it
+// is unknown.
+var sysRenameat2 = map[string]uintptr{
+	"386":      353,
+	"amd64":    316,
+	"arm":      382,
+	"arm64":    276,
+	"loong64":  276,
+	"mips":     4351,
+	"mipsle":   4351,
+	"mips64":   5311,
+	"mips64le": 5311,
+	"ppc64":    357,
+	"ppc64le":  357,
+	"riscv64":  276,
+	"s390x":    347,
+}[runtime.GOARCH]
+
+// Flags of renameat2(2).
+const (
+	renameNoReplace = 1 << 0
+	renameExchange  = 1 << 1
+)
+
+// RenameWithOptions renames oldname to newname within the filesystem's root
+// using renameat2(2) relative to their parent directories opened through
+// `os.Root`, so that `fsx.RenameNoReplace` and `fsx.RenameExchange` are
+// atomic.
+//
+// Parameters:
+//
+//	oldname: The path of the file to rename, relative to the confined root.
+//	newname: The new path of the file, relative to the confined root.
+//	flags:   Zero, `fsx.RenameNoReplace` or `fsx.RenameExchange`.
+//
+// Returns:
+//
+//	An error wrapping `fs.ErrExist` if `fsx.RenameNoReplace` is given and
+//	newname exists, or `errors.ErrUnsupported` if the kernel or the
+//	underlying filesystem does not support the flags, letting
+//	`fsx.RenameWithOptions` emulate them.
+func (fsys filesystem) RenameWithOptions(oldname, newname string, flags fsx.RenameFlags) error {
+	if !fs.ValidPath(oldname) || oldname == "." || !fs.ValidPath(newname) || newname == "." {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	var sysFlags uintptr
+	switch flags {
+	case 0:
+		return fsys.Rename(oldname, newname)
+	case fsx.RenameNoReplace:
+		sysFlags = renameNoReplace
+	case fsx.RenameExchange:
+		sysFlags = renameExchange
+	default:
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	if sysRenameat2 == 0 {
+		return errors.ErrUnsupported
+	}
+
+	oldDir, err := fsys.Root.Open(path.Dir(oldname))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = oldDir.Close() }()
+	newDir, err := fsys.Root.Open(path.Dir(newname))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = newDir.Close() }()
+
+	oldBase, err := syscall.BytePtrFromString(path.Base(oldname))
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	newBase, err := syscall.BytePtrFromString(path.Base(newname))
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	_, _, errno := syscall.Syscall6(sysRenameat2,
+		oldDir.Fd(), uintptr(unsafe.Pointer(oldBase)),
+		newDir.Fd(), uintptr(unsafe.Pointer(newBase)),
+		sysFlags, 0)
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ENOSYS, syscall.EINVAL:
+		// Old kernels lack renameat2, and some filesystems reject its flags.
+		return errors.ErrUnsupported
+	}
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errno}
+}
+
+// reopen opens the file open as `f` again, through its entry in
+// /proc/self/fd, with `flag`.
+func reopen(f *os.File, flag int) (*os.File, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	// Entries of /proc/self/fd are symbolic links that must be followed.
+	flag &^= syscall.O_NOFOLLOW
+	var fd int
+	var openErr error
+	if err := conn.Control(func(sysfd uintptr) {
+		fd, openErr = syscall.Open("/proc/self/fd/"+strconv.Itoa(int(sysfd)), flag|syscall.O_CLOEXEC, 0)
+	}); err != nil {
+		return nil, err
+	}
+	if errors.Is(openErr, syscall.ENOENT) {
+		// procfs is not mounted.
+		return nil, errors.ErrUnsupported
+	}
+	if openErr != nil {
+		return nil, openErr
+	}
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
@@ -0,0 +1,34 @@
+//go:build !linux
+
+package osfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/gwangyi/fsx"
+)
+
+// Access is only implemented natively on Linux; elsewhere `fsx.Access` falls
+// back to a `Stat`-based check.
+func (fsys filesystem) Access(name string, mode uint32) error {
+	return errors.ErrUnsupported
+}
+
+// CreateSpecial is only implemented natively on Linux.
+func (fsys filesystem) CreateSpecial(name string, mode fs.FileMode, dev uint64) error {
+	return errors.ErrUnsupported
+}
+
+// RenameWithOptions is only implemented natively on Linux; elsewhere
+// `fsx.RenameWithOptions` emulates the flags.
+func (fsys filesystem) RenameWithOptions(oldname, newname string, flags fsx.RenameFlags) error {
+	return errors.ErrUnsupported
+}
+
+// reopen is only implemented on Linux, where open files can be reopened
+// through /proc/self/fd.
+func reopen(f *os.File, flag int) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,87 @@
+package osfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestFilesystem_Sub(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "inner"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "top"), []byte("top"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{
+		"escape":      outside,
+		"inside":      "sub",
+		"sub/up":      "../top",
+		"sub/outside": filepath.Join(outside, "secret"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys, err := osfs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = fsys.(interface{ Close() error }).Close() }()
+
+	sub, err := fs.Sub(fsys, "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sub.(interface{ Close() error }).Close() }()
+	if data, err := fs.ReadFile(sub, "file"); err != nil || string(data) != "data" {
+		t.Errorf("ReadFile(file) = %q, %v; want data", data, err)
+	}
+	if _, err := fs.Stat(sub, "inner"); err != nil {
+		t.Errorf("Stat(inner) failed: %v", err)
+	}
+	// Links may not lead out of the subdirectory, even into its parent.
+	for _, name := range []string{"up", "outside"} {
+		if _, err := fs.ReadFile(sub, name); err == nil {
+			t.Errorf("ReadFile(%s) escaped the subdirectory", name)
+		}
+	}
+
+	// A link staying within the root leads to a subdirectory.
+	inside, err := fs.Sub(fsys, "inside")
+	if err != nil {
+		t.Fatalf("Sub(inside) failed: %v", err)
+	}
+	defer func() { _ = inside.(interface{ Close() error }).Close() }()
+	if data, err := fs.ReadFile(inside, "file"); err != nil || string(data) != "data" {
+		t.Errorf("ReadFile(file) = %q, %v; want data", data, err)
+	}
+
+	for _, name := range []string{"..", "../x", "/abs", "sub/../..", "escape"} {
+		if _, err := fsys.(fs.SubFS).Sub(name); err == nil {
+			t.Errorf("Sub(%s) escaped the root", name)
+		}
+	}
+	if _, err := fsys.(fs.SubFS).Sub("../x"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Sub(../x) error = %v; want ErrInvalid", err)
+	}
+	if _, err := fsys.(fs.SubFS).Sub("top"); err == nil {
+		t.Error("Sub(top) of a file succeeded")
+	}
+	if _, err := fsys.(fs.SubFS).Sub("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Sub(missing) error = %v; want ErrNotExist", err)
+	}
+}
@@ -4,8 +4,9 @@ package osfs
 
 import (
 	"io/fs"
-	"os/user"
 	"strconv"
+
+	"github.com/gwangyi/fsx/internal"
 )
 
 // lookupUid returns uid associated to given username.
@@ -15,18 +16,13 @@ func lookupUid(username string) (int, error) {
 	if username == "" {
 		return -1, nil
 	}
-	uid, err := strconv.Atoi(username)
-	if err == nil {
-		return uid, nil
-	}
-
-	u, err := user.Lookup(username)
+	uid, err := internal.UserID(username)
 	if err != nil {
 		return 0, err
 	}
 
 	// We don't expect an error here, because unix always has numeric UID.
-	return strconv.Atoi(u.Uid)
+	return strconv.Atoi(uid)
 }
 
 // lookupGid returns gid associated to given group.
@@ -36,18 +32,13 @@ func lookupGid(group string) (int, error) {
 	if group == "" {
 		return -1, nil
 	}
-	gid, err := strconv.Atoi(group)
-	if err == nil {
-		return gid, nil
-	}
-
-	g, err := user.LookupGroup(group)
+	gid, err := internal.GroupID(group)
 	if err != nil {
 		return 0, err
 	}
 
 	// We don't expect an error here, because unix always has numeric GID.
-	return strconv.Atoi(g.Gid)
+	return strconv.Atoi(gid)
 }
 
 // Chown changes the numeric uid and gid of the named file within the filesystem's root.
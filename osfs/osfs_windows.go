@@ -0,0 +1,84 @@
+//go:build windows
+
+package osfs
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ioReparseTagMountPoint is the reparse tag of junctions and volume mount
+// points (IO_REPARSE_TAG_MOUNT_POINT).
+const ioReparseTagMountPoint = 0xA0000003
+
+// Lstat returns a FileInfo describing the named file without following
+// symbolic links.
+//
+// Since Go 1.23, the os package reports junctions as irregular files rather
+// than as symbolic links. `os.Root.Readlink` still resolves them, so Lstat
+// reports them as symbolic links again: consumers such as unionfs then handle
+// junctions the way they handle symbolic links on every other system. The
+// Windows attributes of the file, including the reparse point flag, remain
+// available through `fsx.FileAttributes`.
+//
+// Paths longer than MAX_PATH need no special care: `os.Root` opens files
+// relative to the handle of the root directory, and the reparse tag lookup
+// below uses the `\\?\` form that lifts the limit.
+func (fsys filesystem) Lstat(name string) (fs.FileInfo, error) {
+	info, err := fsys.Root.Lstat(name)
+	if err != nil || info.Mode()&fs.ModeIrregular == 0 {
+		return info, err
+	}
+	tag, err := reparseTag(filepath.Join(fsys.Name(), filepath.FromSlash(name)))
+	if err != nil || tag != ioReparseTagMountPoint {
+		return info, nil
+	}
+	return junctionInfo{info}, nil
+}
+
+// junctionInfo describes a junction as a symbolic link.
+type junctionInfo struct {
+	fs.FileInfo
+}
+
+// Mode returns the mode of the junction with fs.ModeSymlink in place of
+// fs.ModeIrregular and fs.ModeDir.
+func (j junctionInfo) Mode() fs.FileMode {
+	return j.FileInfo.Mode()&^(fs.ModeIrregular|fs.ModeDir) | fs.ModeSymlink
+}
+
+// IsDir reports false, as for any symbolic link.
+func (j junctionInfo) IsDir() bool {
+	return false
+}
+
+// reparseTag returns the reparse tag of the reparse point at the host path
+// name.
+func reparseTag(name string) (uint32, error) {
+	p, err := syscall.UTF16PtrFromString(longPath(name))
+	if err != nil {
+		return 0, err
+	}
+	var data syscall.Win32finddata
+	h, err := syscall.FindFirstFile(p, &data)
+	if err != nil {
+		return 0, err
+	}
+	_ = syscall.FindClose(h)
+	return data.Reserved0, nil
+}
+
+// longPath returns the `\\?\` form of the host path name, which Win32 APIs
+// accept beyond MAX_PATH (260 characters).
+func longPath(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil || strings.HasPrefix(abs, `\\?\`) {
+		return name
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,155 @@
+//go:build linux || darwin
+
+package osfs
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// GetXattr returns the value of the extended attribute `attr` of the named
+// file within the filesystem's root. The file is opened through `os.Root`
+// and the attribute read from its descriptor, so symbolic links are
+// followed without escaping the root.
+//
+// Returns:
+//
+//	The value of the attribute, or an error wrapping `fsx.ErrNoXattr` if the
+//	file has no such attribute.
+func (fsys filesystem) GetXattr(name, attr string) ([]byte, error) {
+	if err := internal.CheckXattr("getxattr", name, attr); err != nil {
+		return nil, err
+	}
+	var value []byte
+	err := fsys.withFd("getxattr", name, func(fd int) error {
+		for {
+			n, err := fgetxattr(fd, toSysXattr(attr), nil)
+			if err != nil {
+				return err
+			}
+			value = make([]byte, n)
+			n, err = fgetxattr(fd, toSysXattr(attr), value)
+			if errors.Is(err, syscall.ERANGE) {
+				// The attribute grew since its size was read.
+				continue
+			}
+			value = value[:n]
+			return err
+		}
+	})
+	return value, err
+}
+
+// SetXattr sets the value of the extended attribute `attr` of the named file
+// within the filesystem's root.
+//
+// Parameters:
+//
+//	name:  The path to the file, relative to the confined root.
+//	attr:  The name of the attribute, such as "user.comment" or
+//	       "trusted.overlay.opaque".
+//	value: The new value of the attribute.
+//	flags: Zero, `fsx.XATTR_CREATE` or `fsx.XATTR_REPLACE`.
+//
+// Returns:
+//
+//	An error wrapping `fs.ErrExist` if `fsx.XATTR_CREATE` is given and the
+//	attribute exists, or `fsx.ErrNoXattr` if `fsx.XATTR_REPLACE` is given and
+//	it does not.
+func (fsys filesystem) SetXattr(name, attr string, value []byte, flags int) error {
+	if err := internal.CheckXattr("setxattr", name, attr); err != nil {
+		return err
+	}
+	return fsys.withFd("setxattr", name, func(fd int) error {
+		return fsetxattr(fd, toSysXattr(attr), value, flags)
+	})
+}
+
+// ListXattr returns the names of the extended attributes of the named file
+// within the filesystem's root.
+func (fsys filesystem) ListXattr(name string) ([]string, error) {
+	if err := internal.CheckPath("listxattr", name); err != nil {
+		return nil, err
+	}
+	var names []string
+	err := fsys.withFd("listxattr", name, func(fd int) error {
+		var buf []byte
+		for {
+			n, err := flistxattr(fd, nil)
+			if err != nil {
+				return err
+			}
+			buf = make([]byte, n)
+			n, err = flistxattr(fd, buf)
+			if errors.Is(err, syscall.ERANGE) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			buf = buf[:n]
+			break
+		}
+		for _, name := range strings.Split(string(buf), "\x00") {
+			if name != "" {
+				names = append(names, fromSysXattr(name))
+			}
+		}
+		return nil
+	})
+	return names, err
+}
+
+// RemoveXattr removes the extended attribute `attr` of the named file within
+// the filesystem's root.
+func (fsys filesystem) RemoveXattr(name, attr string) error {
+	if err := internal.CheckXattr("removexattr", name, attr); err != nil {
+		return err
+	}
+	return fsys.withFd("removexattr", name, func(fd int) error {
+		return fremovexattr(fd, toSysXattr(attr))
+	})
+}
+
+// withFd opens the named file through `os.Root` and calls fn with its
+// descriptor. Errors returned by fn are reported as an `*fs.PathError` for
+// op, with the errno reporting a missing attribute replaced by
+// `fsx.ErrNoXattr`.
+func (fsys filesystem) withFd(op, name string, fn func(fd int) error) error {
+	f, err := fsys.Root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := conn.Control(func(fd uintptr) {
+		fnErr = fn(int(fd))
+	}); err != nil {
+		return err
+	}
+	if errors.Is(fnErr, errNoAttr) {
+		fnErr = internal.ErrNoXattr
+	}
+	if fnErr != nil {
+		return &fs.PathError{Op: op, Path: name, Err: fnErr}
+	}
+	return nil
+}
+
+// xattrPtr returns a pointer to the first byte of buf, or nil if it is
+// empty, for the system calls probing the size of a value.
+func xattrPtr(buf []byte) *byte {
+	if len(buf) == 0 {
+		return nil
+	}
+	return &buf[0]
+}
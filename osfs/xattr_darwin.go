@@ -0,0 +1,92 @@
+//go:build darwin
+
+package osfs
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// errNoAttr is the errno reporting a missing extended attribute.
+const errNoAttr = syscall.ENOATTR
+
+// Flags of fsetxattr(2) on macOS.
+const (
+	darwinXattrCreate  = 0x2
+	darwinXattrReplace = 0x4
+)
+
+// toSysXattr returns the name under which the system stores attr. macOS has
+// no namespaces: the names of the "user." namespace are stored without it,
+// and those of the other namespaces, such as "trusted.overlay.opaque", as is.
+func toSysXattr(attr string) string {
+	if ns, rest, _ := internal.XattrNamespace(attr); ns == "user." {
+		return rest
+	}
+	return attr
+}
+
+// fromSysXattr returns the name of the attribute stored by the system under
+// name, reversing toSysXattr.
+func fromSysXattr(name string) string {
+	if ns, _, ok := internal.XattrNamespace(name); ok && ns != "user." {
+		return name
+	}
+	return "user." + name
+}
+
+func fgetxattr(fd int, attr string, buf []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return 0, err
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_FGETXATTR, uintptr(fd), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(xattrPtr(buf))), uintptr(len(buf)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func fsetxattr(fd int, attr string, value []byte, flags int) error {
+	p, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+	var sysFlags uintptr
+	if flags&internal.XATTR_CREATE != 0 {
+		sysFlags |= darwinXattrCreate
+	}
+	if flags&internal.XATTR_REPLACE != 0 {
+		sysFlags |= darwinXattrReplace
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_FSETXATTR, uintptr(fd), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(xattrPtr(value))), uintptr(len(value)), 0, sysFlags)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func flistxattr(fd int, buf []byte) (int, error) {
+	n, _, errno := syscall.Syscall6(syscall.SYS_FLISTXATTR, uintptr(fd),
+		uintptr(unsafe.Pointer(xattrPtr(buf))), uintptr(len(buf)), 0, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func fremovexattr(fd int, attr string) error {
+	p, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_FREMOVEXATTR, uintptr(fd), uintptr(unsafe.Pointer(p)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
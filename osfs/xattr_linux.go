@@ -0,0 +1,70 @@
+//go:build linux
+
+package osfs
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// errNoAttr is the errno reporting a missing extended attribute.
+const errNoAttr = syscall.ENODATA
+
+// toSysXattr returns the name under which the system stores attr. Linux
+// uses the namespaced names as is.
+func toSysXattr(attr string) string {
+	return attr
+}
+
+// fromSysXattr returns the name of the attribute stored by the system under
+// name.
+func fromSysXattr(name string) string {
+	return name
+}
+
+func fgetxattr(fd int, attr string, buf []byte) (int, error) {
+	p, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return 0, err
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_FGETXATTR, uintptr(fd), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(xattrPtr(buf))), uintptr(len(buf)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func fsetxattr(fd int, attr string, value []byte, flags int) error {
+	p, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_FSETXATTR, uintptr(fd), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(xattrPtr(value))), uintptr(len(value)), uintptr(flags), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func flistxattr(fd int, buf []byte) (int, error) {
+	n, _, errno := syscall.Syscall(syscall.SYS_FLISTXATTR, uintptr(fd),
+		uintptr(unsafe.Pointer(xattrPtr(buf))), uintptr(len(buf)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
+
+func fremovexattr(fd int, attr string) error {
+	p, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_FREMOVEXATTR, uintptr(fd), uintptr(unsafe.Pointer(p)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
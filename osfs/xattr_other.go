@@ -0,0 +1,25 @@
+//go:build !linux && !darwin
+
+package osfs
+
+import "errors"
+
+// GetXattr is only implemented on Linux and macOS.
+func (fsys filesystem) GetXattr(name, attr string) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// SetXattr is only implemented on Linux and macOS.
+func (fsys filesystem) SetXattr(name, attr string, value []byte, flags int) error {
+	return errors.ErrUnsupported
+}
+
+// ListXattr is only implemented on Linux and macOS.
+func (fsys filesystem) ListXattr(name string) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// RemoveXattr is only implemented on Linux and macOS.
+func (fsys filesystem) RemoveXattr(name, attr string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,598 @@
+// Package policyfs provides a contextual filesystem wrapper that checks every
+// operation against a list of rules before passing it on, in the manner of
+// seccomp, so that third-party code such as plugins can be given a shared
+// stack of layers with guardrails: for example, no symbolic links anywhere,
+// and writes only under "uploads".
+//
+// Each rule allows or denies a set of operations on the names matching its
+// pattern. The first rule matching an operation decides it, and operations
+// matching no rule are decided by Config.Default. Denied operations fail with
+// an *fs.PathError or *os.LinkError wrapping a *DeniedError, which matches
+// fs.ErrPermission and names the rule that denied it.
+//
+// Names are checked as given and as resolved through the symbolic links of
+// the wrapped filesystem, since a link under an allowed directory can lead
+// anywhere: operations following a link are checked against the name it
+// leads to as well, and those going through a link leading outside the
+// filesystem, with an absolute destination or one climbing above its root,
+// are denied. Links are followed when checking, and again by the wrapped
+// filesystem when operating, so a link replaced in between is not caught;
+// deny OpSymlink to code racing against the policy.
+//
+// Only the calls to the filesystem are checked. Reads and writes made through
+// an open file handle, including listing an opened directory, are governed by
+// the rule that allowed opening it.
+package policyfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Op is a set of kinds of operations.
+type Op uint32
+
+const (
+	// OpRead covers Open, ReadFile and OpenFile for reading only.
+	OpRead Op = 1 << iota
+	// OpStat covers Stat, Lstat, Access, GetXattr and ListXattr.
+	OpStat
+	// OpReadDir covers ReadDir and ReadDirInfos.
+	OpReadDir
+	// OpReadLink covers ReadLink.
+	OpReadLink
+	// OpWrite covers Create, WriteFile, Truncate and OpenFile for writing,
+	// creating, truncating or appending.
+	OpWrite
+	// OpMkdir covers Mkdir and MkdirAll.
+	OpMkdir
+	// OpRemove covers Remove and RemoveAll. RemoveAll is denied if any name
+	// beneath the one it is called with might be denied.
+	OpRemove
+	// OpRename covers Rename and RenameWithOptions, which are checked
+	// against both of their names. Like RemoveAll, they are denied if any
+	// name beneath the old name might be denied, since renaming a directory
+	// moves them.
+	OpRename
+	// OpSymlink covers Symlink, which is checked against the name of the
+	// link it creates.
+	OpSymlink
+	// OpChange covers Chmod, Chown, Lchown, Chtimes, SetXattr and
+	// RemoveXattr.
+	OpChange
+	// OpSpecial covers CreateSpecial.
+	OpSpecial
+
+	// OpReadOnly covers the operations that do not change the filesystem.
+	OpReadOnly = OpRead | OpStat | OpReadDir | OpReadLink
+	// OpModify covers the operations that change the filesystem.
+	OpModify = OpWrite | OpMkdir | OpRemove | OpRename | OpSymlink | OpChange | OpSpecial
+	// OpAll covers every operation.
+	OpAll = OpReadOnly | OpModify
+)
+
+// opNames are the names of the kinds of operations, by bit.
+var opNames = []string{"read", "stat", "readdir", "readlink", "write", "mkdir", "remove", "rename", "symlink", "change", "special"}
+
+// String returns the names of the kinds of operations in o, separated by
+// "|".
+func (o Op) String() string {
+	var names []string
+	for i, name := range opNames {
+		if o&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Action is what a rule does with the operations it matches.
+type Action int
+
+const (
+	// Deny fails the operations. It is the zero Action, so that a rule or a
+	// Config missing its Action denies.
+	Deny Action = iota
+	// Allow passes the operations on.
+	Allow
+)
+
+// Rule allows or denies operations on the names matching its pattern.
+type Rule struct {
+	// ID identifies the rule in the errors of the operations it denies.
+	ID string
+	// Pattern selects the names the rule applies to: those matching it as
+	// a glob in the syntax of path.Match, and those beneath it as a
+	// directory. "." matches every name.
+	Pattern string
+	// Ops are the kinds of operations the rule applies to.
+	Ops Op
+	// Action is what the rule does with the operations it matches.
+	Action Action
+}
+
+// matches reports whether the rule applies to op on name.
+func (r Rule) matches(op Op, name string) bool {
+	if r.Ops&op == 0 {
+		return false
+	}
+	if ok, _ := path.Match(r.Pattern, name); ok {
+		return true
+	}
+	return contextual.HasPathPrefix(name, r.Pattern)
+}
+
+// covers reports whether the rule applies to every name beneath dir, as a
+// directory holding it.
+func (r Rule) covers(dir string) bool {
+	return contextual.HasPathPrefix(dir, r.Pattern)
+}
+
+// beneath reports whether the rule might apply to names beneath dir.
+func (r Rule) beneath(dir string) bool {
+	if r.Pattern == "." {
+		return true
+	}
+	var dirs []string
+	if dir != "." {
+		dirs = strings.Split(dir, "/")
+	}
+	elems := strings.Split(r.Pattern, "/")
+	if len(elems) <= len(dirs) {
+		return false
+	}
+	for i, d := range dirs {
+		if ok, _ := path.Match(elems[i], d); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Config specifies the configuration for policyfs.
+type Config struct {
+	// Rules are evaluated in order, and the first rule matching an
+	// operation decides it.
+	Rules []Rule
+	// Default decides the operations no rule matches. Its zero value denies
+	// them.
+	Default Action
+}
+
+// DeniedError is the error wrapped in the *fs.PathError or *os.LinkError
+// returned for a denied operation. It matches fs.ErrPermission.
+type DeniedError struct {
+	// Rule is the ID of the rule that denied the operation, or empty if it
+	// was denied by Config.Default or because of Link.
+	Rule string
+	// Op is the kind of the denied operation.
+	Op Op
+	// Link is the symbolic link the operation went through that leads
+	// outside the filesystem, if that is why it was denied.
+	Link string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Link != "" {
+		return fmt.Sprintf("%s denied: symbolic link %q leads outside the filesystem", e.Op, e.Link)
+	}
+	if e.Rule == "" {
+		return fmt.Sprintf("%s denied by default policy", e.Op)
+	}
+	return fmt.Sprintf("%s denied by policy rule %q", e.Op, e.Rule)
+}
+
+// Is reports whether target is fs.ErrPermission.
+func (e *DeniedError) Is(target error) bool {
+	return target == fs.ErrPermission
+}
+
+// filesystem checks the calls it forwards to the embedded PassthroughFS,
+// overriding every method of it but Close. It has no Unwrap, so that the
+// wrapped filesystem is not handed out unchecked.
+type filesystem struct {
+	contextual.PassthroughFS
+	config Config
+}
+
+// New creates a new policyfs wrapping fsys. It returns an error if the
+// pattern of a rule is malformed, or is not a valid name of an fs.FS once
+// cleaned, such as "" or "/uploads", which would match no name.
+func New(fsys contextual.FS, config Config) (contextual.FileSystem, error) {
+	rules := make([]Rule, len(config.Rules))
+	for i, r := range config.Rules {
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("policyfs: rule %q: %w", r.ID, err)
+		}
+		if r.Pattern == "" || !fs.ValidPath(path.Clean(r.Pattern)) {
+			return nil, fmt.Errorf("policyfs: rule %q: invalid pattern %q", r.ID, r.Pattern)
+		}
+		r.Pattern = path.Clean(r.Pattern)
+		rules[i] = r
+	}
+	config.Rules = rules
+	return &filesystem{PassthroughFS: contextual.PassthroughFS{Inner: fsys}, config: config}, nil
+}
+
+// decide returns the *DeniedError of op on name, or nil if it is allowed.
+func (f *filesystem) decide(op Op, name string) error {
+	for _, r := range f.config.Rules {
+		if r.matches(op, name) {
+			if r.Action == Allow {
+				return nil
+			}
+			return &DeniedError{Rule: r.ID, Op: op}
+		}
+	}
+	if f.config.Default == Allow {
+		return nil
+	}
+	return &DeniedError{Op: op}
+}
+
+// maxLinks is the number of symbolic links followed when resolving a name
+// before giving up with syscall.ELOOP, as Linux does.
+const maxLinks = 40
+
+// resolve returns the name that an operation on name acts on once the
+// symbolic links among its directories, and name itself if follow is set,
+// are followed in the wrapped filesystem. The part of name beneath a missing
+// file is kept as is. If a link leads outside the filesystem, resolve
+// returns its name as link instead.
+func (f *filesystem) resolve(ctx context.Context, name string, follow bool) (resolved, link string, err error) {
+	var rest []string
+	if name != "." {
+		rest = strings.Split(name, "/")
+	}
+	dir, links := ".", 0
+	for len(rest) > 0 {
+		next := path.Join(dir, rest[0])
+		rest = rest[1:]
+		if len(rest) == 0 && !follow {
+			return next, "", nil
+		}
+		info, err := contextual.Lstat(ctx, f.Inner, next)
+		if errors.Is(err, fs.ErrNotExist) {
+			return path.Join(append([]string{next}, rest...)...), "", nil
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			dir = next
+			continue
+		}
+		if links++; links > maxLinks {
+			return "", "", syscall.ELOOP
+		}
+		target, err := contextual.ReadLink(ctx, f.Inner, next)
+		if err != nil {
+			return "", "", err
+		}
+		if path.IsAbs(target) {
+			return "", next, nil
+		}
+		if target = path.Join(dir, target); !fs.ValidPath(target) {
+			return "", next, nil
+		}
+		if target != "." {
+			rest = append(strings.Split(target, "/"), rest...)
+		}
+		dir = "."
+	}
+	return dir, "", nil
+}
+
+// decideTree returns the *DeniedError of op on the names beneath dir, which
+// op removes or moves along with dir, or nil if none of them might be
+// denied.
+func (f *filesystem) decideTree(op Op, dir string) error {
+	for _, r := range f.config.Rules {
+		switch {
+		case r.Ops&op == 0:
+		case r.covers(dir):
+			// The rule decides every name beneath dir.
+			if r.Action == Allow {
+				return nil
+			}
+			return &DeniedError{Rule: r.ID, Op: op}
+		case r.Action == Deny && r.beneath(dir):
+			return &DeniedError{Rule: r.ID, Op: op}
+		}
+	}
+	if f.config.Default == Allow {
+		return nil
+	}
+	return &DeniedError{Op: op}
+}
+
+// decideResolved returns the *DeniedError of op on name, which is checked as
+// given and as resolved, following its last element too if follow is set.
+// If tree is set, the names beneath name are checked too.
+func (f *filesystem) decideResolved(ctx context.Context, op Op, name string, follow, tree bool) error {
+	decide := func(name string) error {
+		if err := f.decide(op, name); err != nil || !tree {
+			return err
+		}
+		return f.decideTree(op, name)
+	}
+	if err := decide(name); err != nil {
+		return err
+	}
+	resolved, link, err := f.resolve(ctx, name, follow)
+	switch {
+	case err != nil:
+		return err
+	case link != "":
+		return &DeniedError{Op: op, Link: link}
+	case resolved != name:
+		return decide(resolved)
+	}
+	return nil
+}
+
+// check returns the error of the operation opName, of kind op, on name if
+// name is invalid or the operation is denied, or nil. The operation follows
+// name if it is a symbolic link.
+func (f *filesystem) check(ctx context.Context, op Op, opName, name string) error {
+	return f.checkName(ctx, op, opName, name, true, false)
+}
+
+// checkName is check, following name if it is a symbolic link only if
+// follow is set, as Lstat does not, and checking the names beneath it too if
+// tree is set, as for RemoveAll.
+func (f *filesystem) checkName(ctx context.Context, op Op, opName, name string, follow, tree bool) error {
+	if err := internal.CheckPath(opName, name); err != nil {
+		return err
+	}
+	if err := f.decideResolved(ctx, op, name, follow, tree); err != nil {
+		return &fs.PathError{Op: opName, Path: name, Err: err}
+	}
+	return nil
+}
+
+// checkLink is check for the operations on two names, of which those listed
+// in names are checked. None of them is followed if it is a symbolic link.
+func (f *filesystem) checkLink(ctx context.Context, op Op, opName, oldname, newname string, names ...string) error {
+	if err := internal.CheckLink(opName, oldname, newname); err != nil {
+		return err
+	}
+	for _, name := range names {
+		// Renaming a directory moves the names beneath it.
+		tree := op == OpRename && name == oldname
+		if err := f.decideResolved(ctx, op, name, false, tree); err != nil {
+			return &os.LinkError{Op: opName, Old: oldname, New: newname, Err: err}
+		}
+	}
+	return nil
+}
+
+func (f *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
+	if err := f.check(ctx, OpRead, "open", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.Open(ctx, name)
+}
+
+func (f *filesystem) Create(ctx context.Context, name string) (contextual.File, error) {
+	if err := f.check(ctx, OpWrite, "open", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.Create(ctx, name)
+}
+
+// OpenFile opens the named file, which is checked as OpRead if it is opened
+// for reading only, and as OpWrite otherwise.
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (contextual.File, error) {
+	op := OpWrite
+	if flag&fsx.O_ACCMODE == os.O_RDONLY && flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		op = OpRead
+	}
+	if err := f.check(ctx, op, "open", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.OpenFile(ctx, name, flag, mode)
+}
+
+func (f *filesystem) Remove(ctx context.Context, name string) error {
+	if err := f.checkName(ctx, OpRemove, "remove", name, false, false); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Remove(ctx, name)
+}
+
+func (f *filesystem) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := f.check(ctx, OpRead, "readfile", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.ReadFile(ctx, name)
+}
+
+func (f *filesystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := f.check(ctx, OpStat, "stat", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.Stat(ctx, name)
+}
+
+func (f *filesystem) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := f.checkName(ctx, OpStat, "lstat", name, false, false); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.Lstat(ctx, name)
+}
+
+func (f *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := f.check(ctx, OpReadDir, "readdir", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.ReadDir(ctx, name)
+}
+
+func (f *filesystem) ReadLink(ctx context.Context, name string) (string, error) {
+	if err := f.checkName(ctx, OpReadLink, "readlink", name, false, false); err != nil {
+		return "", err
+	}
+	return f.PassthroughFS.ReadLink(ctx, name)
+}
+
+func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := f.check(ctx, OpMkdir, "mkdir", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Mkdir(ctx, name, perm)
+}
+
+func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := f.check(ctx, OpMkdir, "mkdir", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.MkdirAll(ctx, name, perm)
+}
+
+func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
+	if err := f.checkName(ctx, OpRemove, "removeall", name, false, true); err != nil {
+		return err
+	}
+	return f.PassthroughFS.RemoveAll(ctx, name)
+}
+
+func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
+	if err := f.checkLink(ctx, OpRename, "rename", oldname, newname, oldname, newname); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Rename(ctx, oldname, newname)
+}
+
+func (f *filesystem) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	if err := f.checkLink(ctx, OpRename, "rename", oldname, newname, oldname, newname); err != nil {
+		return err
+	}
+	return f.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+}
+
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	if err := f.checkLink(ctx, OpSymlink, "symlink", oldname, newname, newname); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Symlink(ctx, oldname, newname)
+}
+
+func (f *filesystem) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	if err := f.check(ctx, OpSpecial, "mknod", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+}
+
+func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
+	if err := f.checkName(ctx, OpChange, "lchown", name, false, false); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Lchown(ctx, name, owner, group)
+}
+
+func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
+	if err := f.check(ctx, OpChange, "chown", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Chown(ctx, name, owner, group)
+}
+
+func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	if err := f.check(ctx, OpChange, "chmod", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Chmod(ctx, name, mode)
+}
+
+func (f *filesystem) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	if err := f.check(ctx, OpChange, "chtimes", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Chtimes(ctx, name, atime, mtime)
+}
+
+func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
+	if err := f.check(ctx, OpWrite, "truncate", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Truncate(ctx, name, size)
+}
+
+func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	if err := f.check(ctx, OpWrite, "writefile", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.WriteFile(ctx, name, data, perm)
+}
+
+func (f *filesystem) Access(ctx context.Context, name string, mode uint32) error {
+	if err := f.check(ctx, OpStat, "access", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.Access(ctx, name, mode)
+}
+
+func (f *filesystem) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	if err := f.check(ctx, OpReadDir, "readdir", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.ReadDirInfos(ctx, name)
+}
+
+// Glob fails with errors.ErrUnsupported, so that contextual.Glob falls back
+// to ReadDir, which is checked for every directory the pattern goes through.
+func (f *filesystem) Glob(ctx context.Context, pattern string) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (f *filesystem) GetXattr(ctx context.Context, name, attr string) ([]byte, error) {
+	if err := f.check(ctx, OpStat, "getxattr", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.GetXattr(ctx, name, attr)
+}
+
+func (f *filesystem) ListXattr(ctx context.Context, name string) ([]string, error) {
+	if err := f.check(ctx, OpStat, "listxattr", name); err != nil {
+		return nil, err
+	}
+	return f.PassthroughFS.ListXattr(ctx, name)
+}
+
+func (f *filesystem) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	if err := f.check(ctx, OpChange, "setxattr", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.SetXattr(ctx, name, attr, value, flags)
+}
+
+func (f *filesystem) RemoveXattr(ctx context.Context, name, attr string) error {
+	if err := f.check(ctx, OpChange, "removexattr", name); err != nil {
+		return err
+	}
+	return f.PassthroughFS.RemoveXattr(ctx, name, attr)
+}
+
+var _ contextual.FileSystem = &filesystem{}
+var _ contextual.SpecialFS = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
+var _ contextual.RenameOptionsFS = &filesystem{}
+var _ contextual.AccessFS = &filesystem{}
+var _ contextual.ReadDirInfoFS = &filesystem{}
+var _ contextual.XattrFS = &filesystem{}
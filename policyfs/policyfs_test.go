@@ -0,0 +1,237 @@
+package policyfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
+	"github.com/gwangyi/fsx/policyfs"
+)
+
+func TestPolicy(t *testing.T) {
+	ctx := t.Context()
+	base := memfs.New(memfs.Config{})
+	for _, dir := range []string{"uploads", "uploads2", "etc"} {
+		if err := base.Mkdir(ctx, dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := base.WriteFile(ctx, "etc/config", []byte("config"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := policyfs.New(base, policyfs.Config{
+		Rules: []policyfs.Rule{
+			{ID: "no-symlinks", Pattern: ".", Ops: policyfs.OpSymlink, Action: policyfs.Deny},
+			{ID: "no-secrets", Pattern: "etc/*.key", Ops: policyfs.OpAll, Action: policyfs.Deny},
+			{ID: "uploads", Pattern: "uploads", Ops: policyfs.OpModify, Action: policyfs.Allow},
+			{ID: "read-only", Pattern: ".", Ops: policyfs.OpModify, Action: policyfs.Deny},
+		},
+		Default: policyfs.Allow,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Allowed operations reach the base.
+	if err := contextual.WriteFile(ctx, fsys, "uploads/a", []byte("a"), 0644); err != nil {
+		t.Errorf("WriteFile(uploads/a) error = %v", err)
+	}
+	if err := contextual.Rename(ctx, fsys, "uploads/a", "uploads/b"); err != nil {
+		t.Errorf("Rename() within uploads error = %v", err)
+	}
+	if data, err := contextual.ReadFile(ctx, fsys, "etc/config"); err != nil || string(data) != "config" {
+		t.Errorf("ReadFile(etc/config) = %q, %v", data, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		fn   func() error
+		rule string
+	}{
+		{"symlink", func() error { return contextual.Symlink(ctx, fsys, "a", "uploads/link") }, "no-symlinks"},
+		{"secret", func() error { _, err := contextual.Stat(ctx, fsys, "etc/ssh.key"); return err }, "no-secrets"},
+		{"write outside", func() error { return contextual.WriteFile(ctx, fsys, "etc/config", nil, 0644) }, "read-only"},
+		{"prefix", func() error { return contextual.Mkdir(ctx, fsys, "uploads2/dir", 0755) }, "read-only"},
+		{"open for writing", func() error {
+			_, err := contextual.OpenFile(ctx, fsys, "etc/config", os.O_WRONLY, 0)
+			return err
+		}, "read-only"},
+		{"rename out", func() error { return contextual.Rename(ctx, fsys, "uploads/b", "etc/b") }, "read-only"},
+		{"remove root", func() error { return contextual.RemoveAll(ctx, fsys, ".") }, "read-only"},
+		{"rename with options out", func() error {
+			return contextual.RenameWithOptions(ctx, fsys, "uploads/b", "etc/b", fsx.RenameNoReplace)
+		}, "read-only"},
+		{"access secret", func() error { return contextual.Access(ctx, fsys, "etc/ssh.key", fsx.R_OK) }, "no-secrets"},
+		{"xattr secret", func() error { _, err := contextual.GetXattr(ctx, fsys, "etc/ssh.key", "user.a"); return err }, "no-secrets"},
+		{"set xattr outside", func() error { return contextual.SetXattr(ctx, fsys, "etc/config", "user.a", nil, 0) }, "read-only"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fn()
+			var denied *policyfs.DeniedError
+			if !errors.As(err, &denied) || denied.Rule != tt.rule || !errors.Is(err, fs.ErrPermission) {
+				t.Errorf("error = %v; want a denial by %q", err, tt.rule)
+			}
+		})
+	}
+	if _, err := base.Stat(ctx, "uploads/b"); err != nil {
+		t.Errorf("uploads/b was moved by a denied rename: %v", err)
+	}
+
+	// Invalid names are rejected as such.
+	if _, err := contextual.Stat(ctx, fsys, "../x"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Stat(../x) error = %v; want ErrInvalid", err)
+	}
+}
+
+func TestPolicy_Default(t *testing.T) {
+	ctx := t.Context()
+	fsys, err := policyfs.New(memfs.New(memfs.Config{}), policyfs.Config{
+		Rules: []policyfs.Rule{{ID: "stat", Pattern: ".", Ops: policyfs.OpStat, Action: policyfs.Allow}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := contextual.Stat(ctx, fsys, "."); err != nil {
+		t.Errorf("Stat() error = %v", err)
+	}
+	_, err = contextual.ReadDir(ctx, fsys, ".")
+	var denied *policyfs.DeniedError
+	if !errors.As(err, &denied) || denied.Rule != "" || denied.Op != policyfs.OpReadDir {
+		t.Errorf("ReadDir() error = %v; want a denial by default", err)
+	}
+	if want := "readdir denied by default policy"; denied != nil && denied.Error() != want {
+		t.Errorf("Error() = %q; want %q", denied.Error(), want)
+	}
+
+	for _, pattern := range []string{"[", "", "/etc", "../etc"} {
+		if _, err := policyfs.New(memfs.New(memfs.Config{}), policyfs.Config{
+			Rules: []policyfs.Rule{{ID: "bad", Pattern: pattern, Ops: policyfs.OpAll}},
+		}); err == nil {
+			t.Errorf("New() accepted the pattern %q", pattern)
+		}
+	}
+}
+
+func TestPolicy_Tree(t *testing.T) {
+	ctx := t.Context()
+	base := memfs.New(memfs.Config{})
+	for _, name := range []string{"uploads/keep/a", "uploads/tmp/a", "data/logs/a"} {
+		if err := base.MkdirAll(ctx, path.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := base.WriteFile(ctx, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys, err := policyfs.New(base, policyfs.Config{
+		Rules: []policyfs.Rule{
+			{ID: "keep", Pattern: "uploads/keep", Ops: policyfs.OpRemove | policyfs.OpRename, Action: policyfs.Deny},
+			{ID: "logs", Pattern: "*/logs", Ops: policyfs.OpRemove, Action: policyfs.Deny},
+			{ID: "all", Pattern: ".", Ops: policyfs.OpAll, Action: policyfs.Allow},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		fn   func() error
+		rule string
+	}{
+		{"removeall", func() error { return contextual.RemoveAll(ctx, fsys, "uploads") }, "keep"},
+		{"removeall root", func() error { return contextual.RemoveAll(ctx, fsys, ".") }, "keep"},
+		{"removeall glob", func() error { return contextual.RemoveAll(ctx, fsys, "data") }, "logs"},
+		{"rename", func() error { return contextual.Rename(ctx, fsys, "uploads", "moved") }, "keep"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fn()
+			var denied *policyfs.DeniedError
+			if !errors.As(err, &denied) || denied.Rule != tt.rule {
+				t.Errorf("error = %v; want a denial by %q", err, tt.rule)
+			}
+		})
+	}
+	if _, err := base.Stat(ctx, "uploads/keep/a"); err != nil {
+		t.Errorf("uploads/keep/a is gone: %v", err)
+	}
+
+	// Trees holding no protected name can be removed.
+	if err := contextual.RemoveAll(ctx, fsys, "uploads/tmp"); err != nil {
+		t.Errorf("RemoveAll(uploads/tmp) error = %v", err)
+	}
+}
+
+func TestPolicy_Symlinks(t *testing.T) {
+	ctx := t.Context()
+	base := memfs.New(memfs.Config{})
+	for _, dir := range []string{"uploads", "etc"} {
+		if err := base.Mkdir(ctx, dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := base.WriteFile(ctx, "etc/secret", []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Links already in the stack, to a protected directory and outside.
+	if err := base.Symlink(ctx, "../etc", "uploads/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := base.Symlink(ctx, "/etc/passwd", "uploads/abs"); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := policyfs.New(base, policyfs.Config{
+		Rules: []policyfs.Rule{
+			{ID: "etc", Pattern: "etc", Ops: policyfs.OpAll, Action: policyfs.Deny},
+			{ID: "uploads", Pattern: "uploads", Ops: policyfs.OpModify, Action: policyfs.Allow},
+		},
+		Default: policyfs.Allow,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Creating a link is allowed, but using it is checked where it leads.
+	if err := contextual.Symlink(ctx, fsys, "../etc/secret", "uploads/l"); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		fn   func() error
+		rule string
+		link string
+	}{
+		{"read through link", func() error { _, err := contextual.ReadFile(ctx, fsys, "uploads/l"); return err }, "etc", ""},
+		{"write through link", func() error { return contextual.WriteFile(ctx, fsys, "uploads/l", []byte("x"), 0644) }, "etc", ""},
+		{"linked directory", func() error { _, err := contextual.Stat(ctx, fsys, "uploads/dir/secret"); return err }, "etc", ""},
+		{"list linked directory", func() error { _, err := contextual.ReadDir(ctx, fsys, "uploads/dir"); return err }, "etc", ""},
+		{"rename through linked directory", func() error {
+			return contextual.Rename(ctx, fsys, "uploads/dir/secret", "uploads/stolen")
+		}, "etc", ""},
+		{"absolute link", func() error { _, err := contextual.ReadFile(ctx, fsys, "uploads/abs"); return err }, "", "uploads/abs"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.fn()
+			var denied *policyfs.DeniedError
+			if !errors.As(err, &denied) || denied.Rule != tt.rule || denied.Link != tt.link || !errors.Is(err, fs.ErrPermission) {
+				t.Errorf("error = %v; want a denial by %q through %q", err, tt.rule, tt.link)
+			}
+		})
+	}
+	if data, err := base.ReadFile(ctx, "etc/secret"); err != nil || string(data) != "secret" {
+		t.Errorf("etc/secret = %q, %v; want it untouched", data, err)
+	}
+
+	// The links themselves can be inspected and removed where allowed.
+	if _, err := contextual.Lstat(ctx, fsys, "uploads/l"); err != nil {
+		t.Errorf("Lstat() error = %v", err)
+	}
+	if err := contextual.Remove(ctx, fsys, "uploads/l"); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+}
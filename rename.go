@@ -1,9 +1,12 @@
 package fsx
 
 import (
-	"io"
+	"errors"
 	"io/fs"
+	"math/rand/v2"
 	"os"
+	"path"
+	"strconv"
 
 	"github.com/gwangyi/fsx/internal"
 )
@@ -51,7 +54,7 @@ func Rename(fsys fs.FS, oldname, newname string) error {
 		return internal.IntoLinkErr("rename", oldname, newname, err)
 	}
 
-	if _, err := io.Copy(dst, src); err != nil {
+	if _, err := internal.Copy(dst, src); err != nil {
 		_ = dst.Close()
 		return internal.IntoLinkErr("rename", oldname, newname, err)
 	}
@@ -69,3 +72,85 @@ func Rename(fsys fs.FS, oldname, newname string) error {
 
 	return nil
 }
+
+// RenameFlags modify the behavior of RenameWithOptions.
+type RenameFlags uint
+
+const (
+	// RenameNoReplace makes the rename fail with an error wrapping
+	// fs.ErrExist if newname already exists, instead of replacing it.
+	RenameNoReplace RenameFlags = 1 << iota
+	// RenameExchange atomically swaps oldname and newname, which must both
+	// exist. It cannot be combined with RenameNoReplace.
+	RenameExchange
+)
+
+// RenameOptionsFS is the interface implemented by a file system that can
+// rename files with RenameFlags, such as renameat2(2) on Linux.
+type RenameOptionsFS interface {
+	RenameFS
+
+	// RenameWithOptions moves oldname to newname as modified by flags. It
+	// returns an error wrapping errors.ErrUnsupported, having changed
+	// nothing, if it cannot honor flags for these files.
+	RenameWithOptions(oldname, newname string, flags RenameFlags) error
+}
+
+// RenameWithOptions moves oldname to newname as modified by flags, which
+// are either zero, RenameNoReplace or RenameExchange.
+//
+// If fsys implements RenameOptionsFS and supports the request, it calls
+// fsys.RenameWithOptions. Otherwise the flags are emulated with Lstat and
+// Rename, which is NOT atomic: RenameNoReplace checks that newname does not
+// exist before renaming, so a file created in between is replaced, and
+// RenameExchange moves the files through a temporary name in the directory
+// of newname, so that another process may observe either name missing.
+// Callers that rely on the atomicity must check that fsys implements
+// RenameOptionsFS.
+func RenameWithOptions(fsys fs.FS, oldname, newname string, flags RenameFlags) error {
+	if flags&^(RenameNoReplace|RenameExchange) != 0 || flags == RenameNoReplace|RenameExchange {
+		return internal.IntoLinkErr("rename", oldname, newname, fs.ErrInvalid)
+	}
+	if rfs, ok := fsys.(RenameOptionsFS); ok {
+		if err := rfs.RenameWithOptions(oldname, newname, flags); !errors.Is(err, errors.ErrUnsupported) {
+			return internal.IntoLinkErr("rename", oldname, newname, err)
+		}
+	}
+
+	switch flags {
+	case RenameNoReplace:
+		if _, err := fs.Lstat(fsys, newname); err == nil {
+			return internal.IntoLinkErr("rename", oldname, newname, fs.ErrExist)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return internal.IntoLinkErr("rename", oldname, newname, err)
+		}
+	case RenameExchange:
+		return exchange(fsys, oldname, newname)
+	}
+	return Rename(fsys, oldname, newname)
+}
+
+// exchange emulates RenameExchange with three renames, undoing the first
+// ones if a later one fails.
+func exchange(fsys fs.FS, oldname, newname string) error {
+	for _, name := range []string{oldname, newname} {
+		if _, err := fs.Lstat(fsys, name); err != nil {
+			return internal.IntoLinkErr("rename", oldname, newname, err)
+		}
+	}
+	dir, base := internal.SplitDirFile(newname)
+	tmp := path.Join(dir, "."+base+".xchg"+strconv.FormatUint(rand.Uint64(), 36))
+	if err := Rename(fsys, newname, tmp); err != nil {
+		return internal.IntoLinkErr("rename", oldname, newname, err)
+	}
+	if err := Rename(fsys, oldname, newname); err != nil {
+		_ = Rename(fsys, tmp, newname)
+		return internal.IntoLinkErr("rename", oldname, newname, err)
+	}
+	if err := Rename(fsys, tmp, oldname); err != nil {
+		_ = Rename(fsys, newname, oldname)
+		_ = Rename(fsys, tmp, newname)
+		return internal.IntoLinkErr("rename", oldname, newname, err)
+	}
+	return nil
+}
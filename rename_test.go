@@ -2,6 +2,7 @@ package fsx_test
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/mockfs"
+	"github.com/gwangyi/fsx/osfs"
 	"go.uber.org/mock/gomock"
 )
 
@@ -232,3 +234,83 @@ func TestRename(t *testing.T) {
 		}
 	})
 }
+
+func TestRenameWithOptions(t *testing.T) {
+	newFS := func(t *testing.T) fsx.RenameFS {
+		fsys, err := osfs.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range map[string]string{"a": "a", "b": "b"} {
+			if err := fsx.WriteFile(fsys, name, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return fsys.(fsx.RenameFS)
+	}
+	check := func(t *testing.T, fsys fs.FS, want map[string]string) {
+		t.Helper()
+		for name, data := range want {
+			got, err := fs.ReadFile(fsys, name)
+			if data == "" {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("ReadFile(%s) = %q, %v, want ErrNotExist", name, got, err)
+				}
+			} else if err != nil || string(got) != data {
+				t.Errorf("ReadFile(%s) = %q, %v, want %q", name, got, err, data)
+			}
+		}
+	}
+
+	for _, native := range []bool{true, false} {
+		t.Run(fmt.Sprintf("native=%v", native), func(t *testing.T) {
+			wrap := func(fsys fsx.RenameFS) fs.FS {
+				if native {
+					return fsys
+				}
+				// Hides RenameOptionsFS so that the flags are emulated.
+				return struct{ fsx.RenameFS }{fsys}
+			}
+
+			t.Run("no replace", func(t *testing.T) {
+				fsys := wrap(newFS(t))
+				if err := fsx.RenameWithOptions(fsys, "a", "b", fsx.RenameNoReplace); !errors.Is(err, fs.ErrExist) {
+					t.Errorf("RenameWithOptions() = %v, want ErrExist", err)
+				}
+				check(t, fsys, map[string]string{"a": "a", "b": "b"})
+				if err := fsx.RenameWithOptions(fsys, "a", "c", fsx.RenameNoReplace); err != nil {
+					t.Errorf("RenameWithOptions() = %v", err)
+				}
+				check(t, fsys, map[string]string{"a": "", "b": "b", "c": "a"})
+			})
+
+			t.Run("exchange", func(t *testing.T) {
+				fsys := wrap(newFS(t))
+				if err := fsx.RenameWithOptions(fsys, "a", "b", fsx.RenameExchange); err != nil {
+					t.Errorf("RenameWithOptions() = %v", err)
+				}
+				check(t, fsys, map[string]string{"a": "b", "b": "a"})
+				if err := fsx.RenameWithOptions(fsys, "a", "missing", fsx.RenameExchange); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("RenameWithOptions() = %v, want ErrNotExist", err)
+				}
+				check(t, fsys, map[string]string{"a": "b", "b": "a"})
+			})
+
+			t.Run("plain", func(t *testing.T) {
+				fsys := wrap(newFS(t))
+				if err := fsx.RenameWithOptions(fsys, "a", "b", 0); err != nil {
+					t.Errorf("RenameWithOptions() = %v", err)
+				}
+				check(t, fsys, map[string]string{"a": "", "b": "a"})
+			})
+		})
+	}
+
+	t.Run("invalid flags", func(t *testing.T) {
+		fsys := newFS(t)
+		if err := fsx.RenameWithOptions(fsys, "a", "b", fsx.RenameNoReplace|fsx.RenameExchange); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("RenameWithOptions() = %v, want ErrInvalid", err)
+		}
+		check(t, fsys, map[string]string{"a": "a", "b": "b"})
+	})
+}
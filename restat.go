@@ -0,0 +1,31 @@
+package fsx
+
+import (
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// RestatFile is the interface implemented by an open file that can describe
+// the file as it is now, rather than as it was when it was opened.
+//
+// Long-lived handles, such as those held by caches or servers, can check the
+// size and modification time of their file with it, without opening the file
+// again by name, which would describe another file if it was renamed or
+// replaced in the meantime.
+type RestatFile interface {
+	fs.File
+
+	// Restat returns a FileInfo describing the file as it is now, bypassing
+	// any FileInfo the handle or a layer in front of it cached.
+	Restat() (fs.FileInfo, error)
+}
+
+// Restat returns a FileInfo describing the file opened as f as it is now.
+// If f implements RestatFile, it calls f.Restat. Otherwise, it calls f.Stat,
+// which describes the file as it is now for most files. Files wrapped with
+// WrapFile implement RestatFile, forwarding to the Restat of the wrapper if
+// it defines one.
+func Restat(f fs.File) (fs.FileInfo, error) {
+	return internal.Restat(f)
+}
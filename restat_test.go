@@ -0,0 +1,74 @@
+package fsx_test
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+// staleFile is a file whose Stat describes it as it was when it was opened,
+// while its Restat looks again.
+type staleFile struct {
+	fsx.File
+	info fs.FileInfo
+}
+
+func (s *staleFile) Stat() (fs.FileInfo, error)   { return s.info, nil }
+func (s *staleFile) Restat() (fs.FileInfo, error) { return s.File.Stat() }
+
+// statWrapper wraps a file without defining Restat.
+type statWrapper struct {
+	fsx.File
+}
+
+func TestRestat(t *testing.T) {
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsx.WriteFile(fsys, "file", []byte("0123"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsx.OpenFile(fsys, "file", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.(fsx.RestatFile); !ok {
+		t.Error("osfs files do not implement RestatFile")
+	}
+
+	// The file grows through another handle.
+	if err := fsx.WriteFile(fsys, "file", []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stale := fsx.WrapFile(&staleFile{File: f, info: info}, f)
+	if info, err := stale.Stat(); err != nil || info.Size() != 4 {
+		t.Errorf("Stat() = %v, %v, want the size at open", info, err)
+	}
+	for name, file := range map[string]fs.File{
+		"osfs":     f,
+		"wrapper":  stale,
+		"fallback": fsx.WrapFile(&statWrapper{File: f}, f),
+	} {
+		if info, err := fsx.Restat(file); err != nil || info.Size() != 10 {
+			t.Errorf("Restat() of %s = %v, %v, want the current size", name, info, err)
+		}
+	}
+
+	// Files without Restat are described by their Stat.
+	m, err := fstest.MapFS{"file": {Data: []byte("map")}}.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fsx.Restat(m); err != nil || info.Size() != 3 {
+		t.Errorf("Restat() of a MapFS file = %v, %v, want its Stat", info, err)
+	}
+}
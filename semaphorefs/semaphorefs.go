@@ -0,0 +1,371 @@
+// Package semaphorefs provides a contextual filesystem wrapper that bounds the
+// number of operations in flight against the filesystem it wraps.
+//
+// Reads and writes are limited separately, so that a burst of writes cannot
+// starve lookups and vice versa. When a limit is reached, callers either wait
+// for a slot, honoring the cancellation of their context, or fail immediately
+// with ErrBusy. This protects fragile backends, such as remote servers with a
+// low number of channels, from the fan-out of layers like unionfs.
+//
+// A limit applies to the duration of a filesystem call. Reads and writes made
+// through an open file handle are not limited.
+package semaphorefs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// ErrBusy is returned, wrapped in an *fs.PathError or *os.LinkError, when
+// Config.FailFast is set and no slot is available for an operation.
+var ErrBusy = errors.New("too many operations in flight")
+
+// Config specifies the configuration for semaphorefs.
+type Config struct {
+	// MaxReads is the maximum number of read operations (Open, ReadFile,
+	// Stat, Lstat, ReadDir, ReadLink and read-only OpenFile) in flight.
+	// If 0, reads are not limited.
+	MaxReads int
+	// MaxWrites is the maximum number of other operations in flight.
+	// If 0, writes are not limited.
+	MaxWrites int
+	// FailFast makes operations fail with ErrBusy instead of waiting when no
+	// slot is available.
+	FailFast bool
+}
+
+// filesystem bounds the calls forwarded to the embedded PassthroughFS. Close
+// is forwarded as is, without waiting for a slot.
+type filesystem struct {
+	contextual.PassthroughFS
+	config Config
+
+	// reads and writes hold a token for every operation in flight.
+	// A nil channel means that the kind of operation is not limited.
+	reads, writes chan struct{}
+}
+
+// New creates a new semaphorefs wrapping fsys.
+func New(fsys contextual.FS, config Config) contextual.FileSystem {
+	f := &filesystem{PassthroughFS: contextual.PassthroughFS{Inner: fsys}, config: config}
+	if config.MaxReads > 0 {
+		f.reads = make(chan struct{}, config.MaxReads)
+	}
+	if config.MaxWrites > 0 {
+		f.writes = make(chan struct{}, config.MaxWrites)
+	}
+	return f
+}
+
+// Unwrap returns the filesystem the semaphorefs is layered on.
+func (f *filesystem) Unwrap() contextual.FS {
+	return f.Inner
+}
+
+// acquire takes a slot from sem. It returns ErrBusy if no slot is available
+// and FailFast is set, or the context's error if ctx is done while waiting.
+// The returned function releases the slot.
+func (f *filesystem) acquire(ctx context.Context, sem chan struct{}) (func(), error) {
+	if sem == nil {
+		return func() {}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	default:
+	}
+	if f.config.FailFast {
+		return nil, ErrBusy
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// read runs fn holding a read slot.
+func (f *filesystem) read(ctx context.Context, op, name string, fn func() error) error {
+	release, err := f.acquire(ctx, f.reads)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	defer release()
+	return fn()
+}
+
+// write runs fn holding a write slot.
+func (f *filesystem) write(ctx context.Context, op, name string, fn func() error) error {
+	release, err := f.acquire(ctx, f.writes)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	defer release()
+	return fn()
+}
+
+// link runs fn, an operation on two names, holding a write slot.
+func (f *filesystem) link(ctx context.Context, op, oldname, newname string, fn func() error) error {
+	release, err := f.acquire(ctx, f.writes)
+	if err != nil {
+		return &os.LinkError{Op: op, Old: oldname, New: newname, Err: err}
+	}
+	defer release()
+	return fn()
+}
+
+// Open opens the named file for reading.
+func (f *filesystem) Open(ctx context.Context, name string) (file fs.File, err error) {
+	err = f.read(ctx, "open", name, func() error {
+		file, err = f.PassthroughFS.Open(ctx, name)
+		return err
+	})
+	return file, err
+}
+
+// Create creates or truncates the named file.
+func (f *filesystem) Create(ctx context.Context, name string) (file fsx.File, err error) {
+	err = f.write(ctx, "open", name, func() error {
+		file, err = f.PassthroughFS.Create(ctx, name)
+		return err
+	})
+	return file, err
+}
+
+// OpenFile opens the named file. It takes a read slot if the file is opened
+// read-only and a write slot otherwise.
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (file fsx.File, err error) {
+	run := f.write
+	if flag&fsx.O_ACCMODE == os.O_RDONLY && flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		run = f.read
+	}
+	err = run(ctx, "open", name, func() error {
+		file, err = f.PassthroughFS.OpenFile(ctx, name, flag, mode)
+		return err
+	})
+	return file, err
+}
+
+// Remove removes the named file or empty directory.
+func (f *filesystem) Remove(ctx context.Context, name string) error {
+	return f.write(ctx, "remove", name, func() error {
+		return f.PassthroughFS.Remove(ctx, name)
+	})
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f *filesystem) ReadFile(ctx context.Context, name string) (data []byte, err error) {
+	err = f.read(ctx, "readfile", name, func() error {
+		data, err = f.PassthroughFS.ReadFile(ctx, name)
+		return err
+	})
+	return data, err
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f *filesystem) Stat(ctx context.Context, name string) (info fs.FileInfo, err error) {
+	err = f.read(ctx, "stat", name, func() error {
+		info, err = f.PassthroughFS.Stat(ctx, name)
+		return err
+	})
+	return info, err
+}
+
+// Lstat returns a FileInfo describing the named file without following
+// symbolic links.
+func (f *filesystem) Lstat(ctx context.Context, name string) (info fs.FileInfo, err error) {
+	err = f.read(ctx, "lstat", name, func() error {
+		info, err = f.PassthroughFS.Lstat(ctx, name)
+		return err
+	})
+	return info, err
+}
+
+// ReadDir reads the named directory.
+func (f *filesystem) ReadDir(ctx context.Context, name string) (entries []fs.DirEntry, err error) {
+	err = f.read(ctx, "readdir", name, func() error {
+		entries, err = f.PassthroughFS.ReadDir(ctx, name)
+		return err
+	})
+	return entries, err
+}
+
+// ReadLink returns the destination of the named symbolic link.
+func (f *filesystem) ReadLink(ctx context.Context, name string) (target string, err error) {
+	err = f.read(ctx, "readlink", name, func() error {
+		target, err = f.PassthroughFS.ReadLink(ctx, name)
+		return err
+	})
+	return target, err
+}
+
+// Mkdir creates a new directory.
+func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	return f.write(ctx, "mkdir", name, func() error {
+		return f.PassthroughFS.Mkdir(ctx, name, perm)
+	})
+}
+
+// MkdirAll creates a directory named name, along with any necessary parents.
+func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	return f.write(ctx, "mkdir", name, func() error {
+		return f.PassthroughFS.MkdirAll(ctx, name, perm)
+	})
+}
+
+// RemoveAll removes name and any children it contains.
+func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
+	return f.write(ctx, "removeall", name, func() error {
+		return f.PassthroughFS.RemoveAll(ctx, name)
+	})
+}
+
+// Rename renames oldname to newname.
+func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
+	return f.link(ctx, "rename", oldname, newname, func() error {
+		return f.PassthroughFS.Rename(ctx, oldname, newname)
+	})
+}
+
+// RenameWithOptions renames oldname to newname as directed by flags.
+func (f *filesystem) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	return f.link(ctx, "rename", oldname, newname, func() error {
+		return f.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+	})
+}
+
+// Symlink creates newname as a symbolic link to oldname.
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	return f.link(ctx, "symlink", oldname, newname, func() error {
+		return f.PassthroughFS.Symlink(ctx, oldname, newname)
+	})
+}
+
+// CreateSpecial creates a special file.
+func (f *filesystem) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	return f.write(ctx, "mknod", name, func() error {
+		return f.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+	})
+}
+
+// Lchown changes the ownership of the named file without following links.
+func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
+	return f.write(ctx, "lchown", name, func() error {
+		return f.PassthroughFS.Lchown(ctx, name, owner, group)
+	})
+}
+
+// Truncate changes the size of the named file.
+func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
+	return f.write(ctx, "truncate", name, func() error {
+		return f.PassthroughFS.Truncate(ctx, name, size)
+	})
+}
+
+// WriteFile writes data to the named file, creating it if necessary.
+func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	return f.write(ctx, "writefile", name, func() error {
+		return f.PassthroughFS.WriteFile(ctx, name, data, perm)
+	})
+}
+
+// Chown changes the ownership of the named file.
+func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
+	return f.write(ctx, "chown", name, func() error {
+		return f.PassthroughFS.Chown(ctx, name, owner, group)
+	})
+}
+
+// Chmod changes the mode of the named file.
+func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	return f.write(ctx, "chmod", name, func() error {
+		return f.PassthroughFS.Chmod(ctx, name, mode)
+	})
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (f *filesystem) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	return f.write(ctx, "chtimes", name, func() error {
+		return f.PassthroughFS.Chtimes(ctx, name, atime, mtime)
+	})
+}
+
+// Access checks whether the named file can be accessed with mode.
+func (f *filesystem) Access(ctx context.Context, name string, mode uint32) error {
+	return f.read(ctx, "access", name, func() error {
+		return f.PassthroughFS.Access(ctx, name, mode)
+	})
+}
+
+// ReadDirInfos reads the named directory along with the FileInfo of its
+// entries.
+func (f *filesystem) ReadDirInfos(ctx context.Context, name string) (list []contextual.FileInfoEntry, err error) {
+	err = f.read(ctx, "readdir", name, func() error {
+		list, err = f.PassthroughFS.ReadDirInfos(ctx, name)
+		return err
+	})
+	return list, err
+}
+
+// Glob returns the names of all files matching pattern.
+func (f *filesystem) Glob(ctx context.Context, pattern string) (names []string, err error) {
+	err = f.read(ctx, "glob", pattern, func() error {
+		names, err = f.PassthroughFS.Glob(ctx, pattern)
+		return err
+	})
+	return names, err
+}
+
+// GetXattr returns the value of the extended attribute attr of the named
+// file.
+func (f *filesystem) GetXattr(ctx context.Context, name, attr string) (value []byte, err error) {
+	err = f.read(ctx, "getxattr", name, func() error {
+		value, err = f.PassthroughFS.GetXattr(ctx, name, attr)
+		return err
+	})
+	return value, err
+}
+
+// ListXattr lists the extended attributes of the named file.
+func (f *filesystem) ListXattr(ctx context.Context, name string) (attrs []string, err error) {
+	err = f.read(ctx, "listxattr", name, func() error {
+		attrs, err = f.PassthroughFS.ListXattr(ctx, name)
+		return err
+	})
+	return attrs, err
+}
+
+// SetXattr sets the extended attribute attr of the named file.
+func (f *filesystem) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	return f.write(ctx, "setxattr", name, func() error {
+		return f.PassthroughFS.SetXattr(ctx, name, attr, value, flags)
+	})
+}
+
+// RemoveXattr removes the extended attribute attr of the named file.
+func (f *filesystem) RemoveXattr(ctx context.Context, name, attr string) error {
+	return f.write(ctx, "removexattr", name, func() error {
+		return f.PassthroughFS.RemoveXattr(ctx, name, attr)
+	})
+}
+
+var _ contextual.FileSystem = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
+var _ contextual.SpecialFS = &filesystem{}
+var _ contextual.RenameOptionsFS = &filesystem{}
+var _ contextual.AccessFS = &filesystem{}
+var _ contextual.ReadDirInfoFS = &filesystem{}
+var _ contextual.GlobFS = &filesystem{}
+var _ contextual.XattrFS = &filesystem{}
@@ -0,0 +1,174 @@
+package semaphorefs_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/mockfs"
+	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"github.com/gwangyi/fsx/semaphorefs"
+	"go.uber.org/mock/gomock"
+)
+
+// blockStat makes the next Stat of name on m block until release is closed.
+// It closes started once the call is in flight.
+func blockStat(m *cmockfs.MockFileSystem, name string, info fs.FileInfo) (started, release chan struct{}) {
+	started, release = make(chan struct{}), make(chan struct{})
+	m.EXPECT().Stat(gomock.Any(), name).DoAndReturn(func(context.Context, string) (fs.FileInfo, error) {
+		close(started)
+		<-release
+		return info, nil
+	})
+	return started, release
+}
+
+func TestSemaphoreFS(t *testing.T) {
+	t.Run("fail fast", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		ctx := t.Context()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		info := mockfs.NewMockFileInfo(ctrl)
+		fsys := semaphorefs.New(m, semaphorefs.Config{MaxReads: 1, MaxWrites: 1, FailFast: true})
+
+		started, release := blockStat(m, "slow", info)
+		done := make(chan error)
+		go func() {
+			_, err := fsys.Stat(ctx, "slow")
+			done <- err
+		}()
+		<-started
+
+		_, err := fsys.Stat(ctx, "other")
+		var pErr *fs.PathError
+		if !errors.As(err, &pErr) || pErr.Op != "stat" || !errors.Is(err, semaphorefs.ErrBusy) {
+			t.Errorf("expected stat ErrBusy, got %v", err)
+		}
+		if _, err := fsys.OpenFile(ctx, "other", os.O_RDONLY, 0); !errors.Is(err, semaphorefs.ErrBusy) {
+			t.Errorf("expected ErrBusy for read-only OpenFile, got %v", err)
+		}
+		if err := contextual.Access(ctx, fsys, "other", fsx.R_OK); !errors.Is(err, semaphorefs.ErrBusy) {
+			t.Errorf("expected ErrBusy for Access, got %v", err)
+		}
+		if _, err := contextual.GetXattr(ctx, fsys, "other", "user.test"); !errors.Is(err, semaphorefs.ErrBusy) {
+			t.Errorf("expected ErrBusy for GetXattr, got %v", err)
+		}
+
+		// Writes have their own limit.
+		m.EXPECT().WriteFile(ctx, "file", []byte("data"), fs.FileMode(0644)).Return(nil)
+		if err := fsys.WriteFile(ctx, "file", []byte("data"), 0644); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		close(release)
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		// The slot is free again.
+		m.EXPECT().Stat(ctx, "other").Return(info, nil)
+		if _, err := fsys.Stat(ctx, "other"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("wait honors context", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		info := mockfs.NewMockFileInfo(ctrl)
+		fsys := semaphorefs.New(m, semaphorefs.Config{MaxReads: 1})
+
+		started, release := blockStat(m, "slow", info)
+		done := make(chan error)
+		go func() {
+			_, err := fsys.Stat(t.Context(), "slow")
+			done <- err
+		}()
+		<-started
+
+		ctx, cancel := context.WithCancel(t.Context())
+		waiting := make(chan error)
+		go func() {
+			_, err := fsys.ReadDir(ctx, "dir")
+			waiting <- err
+		}()
+		cancel()
+		if err := <-waiting; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+
+		// A waiting operation proceeds once the slot is released.
+		m.EXPECT().ReadDir(gomock.Any(), "dir").Return(nil, nil)
+		go func() {
+			_, err := fsys.ReadDir(t.Context(), "dir")
+			waiting <- err
+		}()
+		close(release)
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := <-waiting; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("rename busy", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		ctx := t.Context()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		fsys := semaphorefs.New(m, semaphorefs.Config{MaxWrites: 1, FailFast: true})
+
+		started, release := make(chan struct{}), make(chan struct{})
+		m.EXPECT().Remove(gomock.Any(), "slow").DoAndReturn(func(context.Context, string) error {
+			close(started)
+			<-release
+			return nil
+		})
+		done := make(chan error)
+		go func() { done <- fsys.Remove(ctx, "slow") }()
+		<-started
+
+		err := fsys.Rename(ctx, "a", "b")
+		var lErr *os.LinkError
+		if !errors.As(err, &lErr) || !errors.Is(err, semaphorefs.ErrBusy) {
+			t.Errorf("expected rename ErrBusy, got %v", err)
+		}
+		if err := contextual.RenameWithOptions(ctx, fsys, "a", "b", fsx.RenameNoReplace); !errors.Is(err, semaphorefs.ErrBusy) {
+			t.Errorf("expected RenameWithOptions ErrBusy, got %v", err)
+		}
+		if err := contextual.SetXattr(ctx, fsys, "a", "user.test", nil, 0); !errors.Is(err, semaphorefs.ErrBusy) {
+			t.Errorf("expected SetXattr ErrBusy, got %v", err)
+		}
+		close(release)
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		ctx := t.Context()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		fsys := semaphorefs.New(m, semaphorefs.Config{})
+
+		m.EXPECT().ReadFile(ctx, "file").Return([]byte("data"), nil)
+		if data, err := fsys.ReadFile(ctx, "file"); err != nil || string(data) != "data" {
+			t.Errorf("ReadFile() = %q, %v", data, err)
+		}
+	})
+}
+
+func TestFilesystem_InvalidPaths(t *testing.T) {
+	// Invalid names never reach the backend.
+	ctrl := gomock.NewController(t)
+	fsxtest.CheckInvalidPaths(t, semaphorefs.New(cmockfs.NewMockFileSystem(ctrl), semaphorefs.Config{}))
+}
@@ -0,0 +1,67 @@
+package fsx
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// SpecialFS is the interface implemented by a file system that can create
+// special files: named pipes, sockets, and block or character devices.
+type SpecialFS interface {
+	WriterFS
+
+	// CreateSpecial creates a special file at name. The type of the file is
+	// given by the fs.ModeNamedPipe, fs.ModeSocket, fs.ModeDevice and
+	// fs.ModeCharDevice bits of mode, and its permissions by mode.Perm().
+	// For devices, dev is the device number as built by Mkdev; it is
+	// ignored for other types.
+	// If name already exists, CreateSpecial should return an error.
+	CreateSpecial(name string, mode fs.FileMode, dev uint64) error
+}
+
+// IsSpecial reports whether mode describes a special file (a named pipe, a
+// socket, or a device). Special files have no content of their own, so layers
+// that copy files around must recreate them with CreateSpecial instead of
+// copying their bytes.
+func IsSpecial(mode fs.FileMode) bool {
+	return internal.IsSpecial(mode)
+}
+
+// Mkdev returns a device number composed of the given major and minor numbers.
+func Mkdev(major, minor uint32) uint64 {
+	return internal.Mkdev(major, minor)
+}
+
+// Major returns the major component of a device number.
+func Major(dev uint64) uint32 {
+	return internal.Major(dev)
+}
+
+// Minor returns the minor component of a device number.
+func Minor(dev uint64) uint32 {
+	return internal.Minor(dev)
+}
+
+// DeviceNumber returns the device number of the device file described by info.
+// It returns zero if info does not describe a device or if the underlying
+// system does not report device numbers.
+func DeviceNumber(info fs.FileInfo) uint64 {
+	return internal.DeviceNumber(info)
+}
+
+// CreateSpecial creates a special file at name in the provided filesystem.
+// If mode does not describe a special file, it returns an error wrapping
+// fs.ErrInvalid.
+// If fsys implements SpecialFS, it calls fsys.CreateSpecial.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func CreateSpecial(fsys fs.FS, name string, mode fs.FileMode, dev uint64) error {
+	if !IsSpecial(mode) {
+		return internal.IntoPathErr("mknod", name, fs.ErrInvalid)
+	}
+	if sfs, ok := fsys.(SpecialFS); ok {
+		return internal.IntoPathErr("mknod", name, sfs.CreateSpecial(name, mode, dev))
+	}
+	return internal.IntoPathErr("mknod", name, errors.ErrUnsupported)
+}
@@ -0,0 +1,48 @@
+package fsx_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestCreateSpecial_Linux(t *testing.T) {
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fsx.CreateSpecial(fsys, "fifo", fs.ModeNamedPipe|0640, 0); err != nil {
+		t.Fatalf("CreateSpecial failed: %v", err)
+	}
+	info, err := fs.Stat(fsys, "fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Type() != fs.ModeNamedPipe || info.Mode().Perm()&0600 != 0600 {
+		t.Errorf("unexpected mode %v", info.Mode())
+	}
+
+	if err := fsx.CreateSpecial(fsys, "fifo", fs.ModeNamedPipe|0640, 0); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected ErrExist, got %v", err)
+	}
+
+	if err := fsx.CreateSpecial(fsys, "../escape", fs.ModeNamedPipe|0640, 0); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+}
+
+func TestDeviceNumber(t *testing.T) {
+	info, err := os.Stat("/dev/null")
+	if err != nil || info.Mode()&fs.ModeCharDevice == 0 {
+		t.Skip("/dev/null is not available")
+	}
+	dev := fsx.DeviceNumber(info)
+	if fsx.Major(dev) != 1 || fsx.Minor(dev) != 3 {
+		t.Errorf("expected 1:3, got %d:%d", fsx.Major(dev), fsx.Minor(dev))
+	}
+}
@@ -0,0 +1,34 @@
+package fsx_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gwangyi/fsx"
+)
+
+func TestCreateSpecial(t *testing.T) {
+	t.Run("InvalidMode", func(t *testing.T) {
+		err := fsx.CreateSpecial(fstest.MapFS{}, "file", 0644, 0)
+		var pErr *fs.PathError
+		if !errors.As(err, &pErr) || pErr.Op != "mknod" || !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected mknod ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		err := fsx.CreateSpecial(fstest.MapFS{}, "fifo", fs.ModeNamedPipe|0644, 0)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("expected ErrUnsupported, got %v", err)
+		}
+	})
+}
+
+func TestMkdev(t *testing.T) {
+	dev := fsx.Mkdev(8, 17)
+	if fsx.Major(dev) != 8 || fsx.Minor(dev) != 17 {
+		t.Errorf("unexpected device %d:%d", fsx.Major(dev), fsx.Minor(dev))
+	}
+}
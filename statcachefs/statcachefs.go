@@ -0,0 +1,376 @@
+// Package statcachefs provides a contextual filesystem wrapper that caches
+// metadata lookups (Stat, Lstat and ReadDir) of the filesystem it wraps.
+//
+// Cached results, including "not exist" answers, are kept for a configurable
+// time-to-live and the cache is bounded in size. Any mutating operation that
+// passes through the wrapper invalidates the affected entries, so callers
+// always observe their own writes. Changes made to the underlying filesystem
+// behind the wrapper's back become visible once the cached entries expire.
+package statcachefs
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Config specifies the configuration for statcachefs.
+type Config struct {
+	// TTL is how long a cached result stays valid.
+	// If 0, cached results never expire and are only dropped on invalidation
+	// or when the cache is full.
+	TTL time.Duration
+	// MaxEntries is the maximum number of cached results.
+	// When the limit is reached, the least recently used result is dropped.
+	// If 0, the cache is unbounded.
+	MaxEntries int
+	// Clock is the source of time used for TTL.
+	// If nil, contextual.RealClock is used.
+	Clock contextual.Clock
+}
+
+// op identifies the cached operation.
+type op uint8
+
+const (
+	opStat op = iota
+	opLstat
+	opReadDir
+)
+
+// key identifies a cached result.
+type key struct {
+	op   op
+	name string
+}
+
+// entry is a cached result of a metadata operation.
+type entry struct {
+	key     key
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	err     error
+	expires time.Time
+	// gen is the generation of the cache when the result was looked up.
+	gen uint64
+}
+
+// filesystem caches the metadata looked up through the embedded
+// PassthroughFS, overriding the lookups and the methods changing the files.
+type filesystem struct {
+	contextual.PassthroughFS
+	config Config
+
+	mu sync.Mutex
+	// cache maps keys to their elements in lru. The front of lru holds the
+	// most recently used entry.
+	cache map[key]*list.Element
+	lru   *list.List
+	// gen counts the invalidations, so that results looked up before one
+	// are not cached after it.
+	gen uint64
+}
+
+// New creates a new statcachefs wrapping fsys.
+func New(fsys contextual.FS, config Config) contextual.FileSystem {
+	return &filesystem{
+		PassthroughFS: contextual.PassthroughFS{Inner: fsys},
+		config:        config,
+		cache:         make(map[key]*list.Element),
+		lru:           list.New(),
+	}
+}
+
+// Unwrap returns the filesystem the statcachefs is layered on.
+func (f *filesystem) Unwrap() contextual.FS {
+	return f.Inner
+}
+
+// lookup returns the cached entry for k, if there is a valid one.
+// Otherwise, it returns the current generation of the cache, to store the
+// result looked up with.
+func (f *filesystem) lookup(k key) (*entry, uint64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	el, ok := f.cache[k]
+	if !ok {
+		return nil, f.gen, false
+	}
+	e := el.Value.(*entry)
+	if f.config.TTL > 0 && contextual.ClockOr(f.config.Clock).Now().After(e.expires) {
+		f.lru.Remove(el)
+		delete(f.cache, k)
+		return nil, f.gen, false
+	}
+	f.lru.MoveToFront(el)
+	return e, f.gen, true
+}
+
+// store caches e unless its error is something other than fs.ErrNotExist,
+// or the cache was invalidated since e was looked up, in which case e may
+// predate the change.
+func (f *filesystem) store(e *entry) {
+	if e.err != nil && !errors.Is(e.err, fs.ErrNotExist) {
+		return
+	}
+	e.expires = contextual.ClockOr(f.config.Clock).Now().Add(f.config.TTL)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if e.gen != f.gen {
+		return
+	}
+
+	if el, ok := f.cache[e.key]; ok {
+		el.Value = e
+		f.lru.MoveToFront(el)
+		return
+	}
+	f.cache[e.key] = f.lru.PushFront(e)
+	if f.config.MaxEntries > 0 && f.lru.Len() > f.config.MaxEntries {
+		oldest := f.lru.Back()
+		f.lru.Remove(oldest)
+		delete(f.cache, oldest.Value.(*entry).key)
+	}
+}
+
+// invalidate drops every cached result about the given names and the
+// listings of their parent directories. If recursive is true, results about
+// anything beneath the names are dropped as well.
+func (f *filesystem) invalidate(recursive bool, names ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.gen++
+
+	for _, name := range names {
+		name = path.Clean(name)
+		f.dropLocked(key{opReadDir, path.Dir(name)})
+		for _, op := range []op{opStat, opLstat, opReadDir} {
+			f.dropLocked(key{op, name})
+		}
+		if !recursive {
+			continue
+		}
+		for k := range f.cache {
+			if contextual.HasPathPrefix(k.name, name) {
+				f.dropLocked(k)
+			}
+		}
+	}
+}
+
+// dropLocked removes k from the cache. It must be called with f.mu held.
+func (f *filesystem) dropLocked(k key) {
+	if el, ok := f.cache[k]; ok {
+		f.lru.Remove(el)
+		delete(f.cache, k)
+	}
+}
+
+// ancestors returns name and all of its parent directories.
+func ancestors(name string) []string {
+	var names []string
+	for name = path.Clean(name); name != "." && name != "/"; name = path.Dir(name) {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Create creates or truncates the named file.
+func (f *filesystem) Create(ctx context.Context, name string) (contextual.File, error) {
+	file, err := f.PassthroughFS.Create(ctx, name)
+	f.invalidate(false, name)
+	if err != nil {
+		return nil, err
+	}
+	return internal.WrapFile(&writeFile{File: file, fs: f, name: name}, file), nil
+}
+
+// OpenFile is the generalized open call. Files opened for writing invalidate
+// the cached metadata of name whenever they are modified or closed.
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (contextual.File, error) {
+	file, err := f.PassthroughFS.OpenFile(ctx, name, flag, mode)
+	if flag&fsx.O_ACCMODE == os.O_RDONLY && flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return file, err
+	}
+	f.invalidate(false, name)
+	if err != nil {
+		return nil, err
+	}
+	return internal.WrapFile(&writeFile{File: file, fs: f, name: name}, file), nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (f *filesystem) Remove(ctx context.Context, name string) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.Remove(ctx, name)
+}
+
+// Stat returns a FileInfo describing the named file, from the cache if possible.
+func (f *filesystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("stat", name); err != nil {
+		return nil, err
+	}
+	k := key{opStat, name}
+	e, gen, ok := f.lookup(k)
+	if ok {
+		return e.info, e.err
+	}
+	info, err := f.PassthroughFS.Stat(ctx, name)
+	f.store(&entry{key: k, info: info, err: err, gen: gen})
+	return info, err
+}
+
+// Lstat returns a FileInfo describing the named file without following links,
+// from the cache if possible.
+func (f *filesystem) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("lstat", name); err != nil {
+		return nil, err
+	}
+	k := key{opLstat, name}
+	e, gen, ok := f.lookup(k)
+	if ok {
+		return e.info, e.err
+	}
+	info, err := f.PassthroughFS.Lstat(ctx, name)
+	f.store(&entry{key: k, info: info, err: err, gen: gen})
+	return info, err
+}
+
+// ReadDir reads the named directory, from the cache if possible.
+// The returned slice is a copy and may be modified by the caller.
+func (f *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	k := key{opReadDir, name}
+	e, gen, ok := f.lookup(k)
+	if ok {
+		return append([]fs.DirEntry(nil), e.entries...), e.err
+	}
+	entries, err := f.PassthroughFS.ReadDir(ctx, name)
+	f.store(&entry{key: k, entries: entries, err: err, gen: gen})
+	return append([]fs.DirEntry(nil), entries...), err
+}
+
+// Mkdir creates a new directory.
+func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.Mkdir(ctx, name, perm)
+}
+
+// MkdirAll creates a directory and all necessary parents.
+func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	defer f.invalidate(false, ancestors(name)...)
+	return f.PassthroughFS.MkdirAll(ctx, name, perm)
+}
+
+// RemoveAll removes path and any children it contains.
+func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
+	defer f.invalidate(true, name)
+	return f.PassthroughFS.RemoveAll(ctx, name)
+}
+
+// Rename renames a file.
+func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
+	defer f.invalidate(true, oldname, newname)
+	return f.PassthroughFS.Rename(ctx, oldname, newname)
+}
+
+// RenameWithOptions renames a file as directed by flags.
+func (f *filesystem) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	defer f.invalidate(true, oldname, newname)
+	return f.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+}
+
+// Symlink creates a symbolic link.
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	defer f.invalidate(false, newname)
+	return f.PassthroughFS.Symlink(ctx, oldname, newname)
+}
+
+// CreateSpecial creates a special file and drops the cached metadata for it.
+func (f *filesystem) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+}
+
+// Lchown changes the owner and group of the named file, without following links.
+func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.Lchown(ctx, name, owner, group)
+}
+
+// Truncate changes the size of the named file.
+func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.Truncate(ctx, name, size)
+}
+
+// WriteFile writes data to the named file.
+func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.WriteFile(ctx, name, data, perm)
+}
+
+// Chown changes the owner and group of the named file.
+func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.Chown(ctx, name, owner, group)
+}
+
+// Chmod changes the mode of the named file.
+func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.Chmod(ctx, name, mode)
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (f *filesystem) Chtimes(ctx context.Context, name string, atime, ctime time.Time) error {
+	defer f.invalidate(false, name)
+	return f.PassthroughFS.Chtimes(ctx, name, atime, ctime)
+}
+
+// writeFile wraps a file opened for writing and invalidates its cached
+// metadata whenever it is modified or closed. It is exposed through
+// internal.WrapFile, keeping the optional interfaces of the file.
+type writeFile struct {
+	contextual.File
+	fs   *filesystem
+	name string
+}
+
+// Write writes p to the file and invalidates its cached metadata.
+func (w *writeFile) Write(p []byte) (int, error) {
+	defer w.fs.invalidate(false, w.name)
+	return w.File.Write(p)
+}
+
+// Truncate changes the size of the file and invalidates its cached metadata.
+func (w *writeFile) Truncate(size int64) error {
+	defer w.fs.invalidate(false, w.name)
+	return w.File.Truncate(size)
+}
+
+// Close closes the file and invalidates its cached metadata.
+func (w *writeFile) Close() error {
+	defer w.fs.invalidate(false, w.name)
+	return w.File.Close()
+}
+
+var _ contextual.FileSystem = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
+var _ contextual.SpecialFS = &filesystem{}
+var _ contextual.RenameOptionsFS = &filesystem{}
@@ -0,0 +1,274 @@
+package statcachefs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
+	"github.com/gwangyi/fsx/mockfs"
+	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"github.com/gwangyi/fsx/osfs"
+	"github.com/gwangyi/fsx/statcachefs"
+	"go.uber.org/mock/gomock"
+)
+
+func TestFilesystem_Stat(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("cached until write", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		fsys := statcachefs.New(m, statcachefs.Config{})
+
+		info := mockfs.NewMockFileInfo(ctrl)
+		m.EXPECT().Stat(ctx, "dir/file").Return(info, nil).Times(2)
+
+		for range 3 {
+			got, err := contextual.Stat(ctx, fsys, "dir/file")
+			if err != nil {
+				t.Fatalf("Stat failed: %v", err)
+			}
+			if got != info {
+				t.Errorf("expected cached info, got %v", got)
+			}
+		}
+
+		m.EXPECT().WriteFile(ctx, "dir/file", []byte("data"), fs.FileMode(0644)).Return(nil)
+		if err := contextual.WriteFile(ctx, fsys, "dir/file", []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, "dir/file"); err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+	})
+
+	t.Run("racing write", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		fsys := statcachefs.New(m, statcachefs.Config{})
+
+		// The file is removed while its Stat is on the way: the result
+		// predates the removal and is not cached.
+		stale := mockfs.NewMockFileInfo(ctrl)
+		m.EXPECT().Stat(ctx, "file").DoAndReturn(func(ctx context.Context, name string) (fs.FileInfo, error) {
+			if err := contextual.Remove(ctx, fsys, name); err != nil {
+				t.Fatal(err)
+			}
+			return stale, nil
+		})
+		m.EXPECT().Remove(ctx, "file").Return(nil)
+		if got, err := contextual.Stat(ctx, fsys, "file"); err != nil || got != stale {
+			t.Fatalf("Stat() = %v, %v; want the stale info", got, err)
+		}
+		m.EXPECT().Stat(ctx, "file").Return(nil, fs.ErrNotExist)
+		if _, err := contextual.Stat(ctx, fsys, "file"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the removal to be seen, got %v", err)
+		}
+	})
+
+	t.Run("caches not exist", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		fsys := statcachefs.New(m, statcachefs.Config{})
+
+		m.EXPECT().Stat(ctx, ".wh.file").Return(nil, fs.ErrNotExist)
+		for range 2 {
+			if _, err := contextual.Stat(ctx, fsys, ".wh.file"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected ErrNotExist, got %v", err)
+			}
+		}
+	})
+
+	t.Run("does not cache other errors", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		fsys := statcachefs.New(m, statcachefs.Config{})
+
+		expectedErr := errors.New("transient")
+		m.EXPECT().Stat(ctx, "file").Return(nil, expectedErr).Times(2)
+		for range 2 {
+			if _, err := contextual.Stat(ctx, fsys, "file"); !errors.Is(err, expectedErr) {
+				t.Errorf("expected %v, got %v", expectedErr, err)
+			}
+		}
+	})
+
+	t.Run("expires after TTL", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		clock := fsxtest.NewClock(time.Now())
+		fsys := statcachefs.New(m, statcachefs.Config{TTL: time.Minute, Clock: clock})
+
+		m.EXPECT().Lstat(ctx, "file").Return(mockfs.NewMockFileInfo(ctrl), nil).Times(2)
+		if _, err := contextual.Lstat(ctx, fsys, "file"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+		if _, err := contextual.Lstat(ctx, fsys, "file"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+		if _, err := contextual.Lstat(ctx, fsys, "file"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("bounded size", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		m := cmockfs.NewMockFileSystem(ctrl)
+		fsys := statcachefs.New(m, statcachefs.Config{MaxEntries: 1})
+
+		m.EXPECT().Stat(ctx, "a").Return(mockfs.NewMockFileInfo(ctrl), nil).Times(2)
+		m.EXPECT().Stat(ctx, "b").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		for _, name := range []string{"a", "b", "a"} {
+			if _, err := contextual.Stat(ctx, fsys, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestFilesystem_ReadDir(t *testing.T) {
+	ctx := t.Context()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := cmockfs.NewMockFileSystem(ctrl)
+	fsys := statcachefs.New(m, statcachefs.Config{})
+
+	entry := mockfs.NewMockDirEntry(ctrl)
+	m.EXPECT().ReadDir(ctx, "dir").Return([]fs.DirEntry{entry}, nil).Times(2)
+
+	for range 2 {
+		entries, err := contextual.ReadDir(ctx, fsys, "dir")
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("ReadDir = %v, %v", entries, err)
+		}
+		entries[0] = nil // must not corrupt the cache
+	}
+
+	// Writing a file through a handle invalidates the parent listing.
+	file := mockfs.NewMockFile(ctrl)
+	m.EXPECT().OpenFile(ctx, "dir/new", os.O_WRONLY|os.O_CREATE, fs.FileMode(0644)).Return(file, nil)
+	f, err := contextual.OpenFile(ctx, fsys, "dir/new", os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.EXPECT().Close().Return(nil)
+	_ = f.Close()
+
+	entries, err := contextual.ReadDir(ctx, fsys, "dir")
+	if err != nil || entries[0] != entry {
+		t.Fatalf("ReadDir = %v, %v", entries, err)
+	}
+}
+
+func TestFilesystem_WriteFile(t *testing.T) {
+	ctx := t.Context()
+	fsys := statcachefs.New(memfs.New(memfs.Config{}), statcachefs.Config{})
+
+	f, err := contextual.Create(ctx, fsys, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := contextual.Stat(ctx, fsys, "file"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := contextual.Stat(ctx, fsys, "file"); err != nil || info.Size() != 4 {
+		t.Errorf("Stat() = %v, %v; want size 4", info, err)
+	}
+
+	// The optional interfaces of the file are kept.
+	if _, ok := f.(io.Seeker); !ok {
+		t.Error("file does not implement io.Seeker")
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		t.Fatal("file does not implement io.ReaderAt")
+	}
+	buf := make([]byte, 2)
+	if n, err := ra.ReadAt(buf, 2); err != nil || string(buf[:n]) != "ta" {
+		t.Errorf("ReadAt() = %q, %v; want ta", buf[:n], err)
+	}
+}
+
+func TestFilesystem_RecursiveInvalidation(t *testing.T) {
+	ctx := t.Context()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := cmockfs.NewMockFileSystem(ctrl)
+	fsys := statcachefs.New(m, statcachefs.Config{})
+
+	m.EXPECT().Stat(ctx, "dir/sub/file").Return(mockfs.NewMockFileInfo(ctrl), nil)
+	m.EXPECT().Stat(ctx, "dir2/file").Return(mockfs.NewMockFileInfo(ctrl), nil)
+	_, _ = contextual.Stat(ctx, fsys, "dir/sub/file")
+	_, _ = contextual.Stat(ctx, fsys, "dir2/file")
+
+	m.EXPECT().RemoveAll(ctx, "dir").Return(nil)
+	if err := contextual.RemoveAll(ctx, fsys, "dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only entries beneath "dir" are dropped.
+	m.EXPECT().Stat(ctx, "dir/sub/file").Return(nil, fs.ErrNotExist)
+	if _, err := contextual.Stat(ctx, fsys, "dir/sub/file"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if _, err := contextual.Stat(ctx, fsys, "dir2/file"); err != nil {
+		t.Errorf("expected cached result, got %v", err)
+	}
+}
+
+func TestFilesystem_InvalidPaths(t *testing.T) {
+	// Invalid names never reach the backend.
+	ctrl := gomock.NewController(t)
+	fsxtest.CheckInvalidPaths(t, statcachefs.New(cmockfs.NewMockFileSystem(ctrl), statcachefs.Config{}))
+}
+
+func TestFilesystem_RenameWithOptions(t *testing.T) {
+	ctx := t.Context()
+	root, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := statcachefs.New(contextual.ToContextual(root), statcachefs.Config{})
+	for name, data := range map[string]string{"a": "a", "b": "bbb"} {
+		if err := contextual.WriteFile(ctx, fsys, name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, fsys, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = contextual.RenameWithOptions(ctx, fsys, "a", "b", fsx.RenameExchange)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("RenameExchange is not supported here")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The exchange drops the cached metadata of both names.
+	for name, size := range map[string]int64{"a": 3, "b": 1} {
+		if info, err := contextual.Stat(ctx, fsys, name); err != nil || info.Size() != size {
+			t.Errorf("Stat(%s) = %v, %v; want size %d", name, info, err, size)
+		}
+	}
+}
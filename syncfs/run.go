@@ -0,0 +1,72 @@
+package syncfs
+
+import (
+	"context"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// DefaultInterval is how often Run checks for changes unless
+// Config.Interval is set.
+const DefaultInterval = time.Second
+
+// journal follows the change journal of a filesystem.
+type journal struct {
+	fsys   contextual.FS
+	cursor contextual.Cursor
+}
+
+// changed reports whether fsys may have changed since the last call. It
+// always reports true for filesystems without a change journal.
+func (j *journal) changed(ctx context.Context) bool {
+	changes, cursor, err := contextual.Changes(ctx, j.fsys, j.cursor)
+	j.cursor = cursor
+	return err != nil || len(changes) > 0
+}
+
+// Run keeps a and b in sync until ctx is done or a sync fails. It syncs once
+// right away and again Config.Interval after each check.
+//
+// Filesystems implementing contextual.ChangeJournalFS are only rescanned
+// when their journals report changes, so that idle trees cost a journal
+// lookup per interval instead of a full comparison. If neither side has a
+// journal, every interval runs a full Sync.
+//
+// Run returns the error of the failed sync, or the context's error.
+func Run(ctx context.Context, a, b contextual.FS, config Config) error {
+	interval := config.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	// The changes made by a sync show up in the journals and trigger one more
+	// comparison, which finds nothing to do.
+	journals := []*journal{{fsys: a}, {fsys: b}}
+	first := true
+	clock := contextual.ClockOr(config.Clock)
+	for {
+		changed := first
+		for _, j := range journals {
+			// Every journal is polled to advance its cursor.
+			if j.changed(ctx) {
+				changed = true
+			}
+		}
+		first = false
+
+		if changed {
+			if _, err := Sync(ctx, a, b, config); err != nil {
+				return err
+			}
+		}
+
+		timer := clock.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
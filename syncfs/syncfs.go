@@ -0,0 +1,478 @@
+// Package syncfs synchronizes two contextual filesystems.
+//
+// Sync compares the trees of two filesystems and copies what differs, in
+// either or both directions. Files are compared by size, modification time or
+// contents, and differences that cannot be settled in a bidirectional sync are
+// resolved by a conflict policy. Every action is reported to an optional
+// progress callback before it is applied, and a dry run reports the actions
+// without applying any of them. Run keeps two filesystems in sync
+// continuously.
+//
+// Directories, regular files and symbolic links are synchronized. Other kinds
+// of files are ignored, and never replace a file of the other side, nor do
+// symbolic links on a side without them: such paths are reported as
+// ActionSkip.
+package syncfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Strategy selects how regular files present on both sides are compared.
+type Strategy int
+
+const (
+	// CompareModTime considers files different if their sizes or
+	// modification times differ. It is the default.
+	CompareModTime Strategy = iota
+	// CompareSize considers files different only if their sizes differ.
+	CompareSize
+	// CompareHash considers files different if their sizes or SHA-256
+	// digests differ, which contextual.Hash computes by reading the files in
+	// full unless their filesystems implement contextual.HashFS.
+	CompareHash
+)
+
+// Direction is the direction in which changes are applied.
+type Direction int
+
+const (
+	// AToB makes B a copy of A. It is the default.
+	AToB Direction = iota
+	// BToA makes A a copy of B.
+	BToA
+	// Both copies files missing on either side to the other side and
+	// resolves differences with the conflict policy.
+	Both
+)
+
+// String returns the name of the direction.
+func (d Direction) String() string {
+	switch d {
+	case AToB:
+		return "a->b"
+	case BToA:
+		return "b->a"
+	case Both:
+		return "both"
+	default:
+		return "unknown"
+	}
+}
+
+// ConflictPolicy decides which side wins when a path differs between the two
+// sides of a bidirectional sync.
+type ConflictPolicy int
+
+const (
+	// ConflictSkip leaves both sides untouched and reports an ActionConflict.
+	// It is the default.
+	ConflictSkip ConflictPolicy = iota
+	// ConflictPreferA copies A over B.
+	ConflictPreferA
+	// ConflictPreferB copies B over A.
+	ConflictPreferB
+	// ConflictPreferNewer copies the side with the later modification time.
+	// Ties are skipped like ConflictSkip.
+	ConflictPreferNewer
+)
+
+// ActionKind is the kind of an Action.
+type ActionKind int
+
+const (
+	// ActionMkdir creates a directory.
+	ActionMkdir ActionKind = iota + 1
+	// ActionCopy copies the contents of a regular file.
+	ActionCopy
+	// ActionSymlink creates a symbolic link.
+	ActionSymlink
+	// ActionRemove removes a file or directory tree.
+	ActionRemove
+	// ActionConflict reports a conflict that was left unresolved.
+	ActionConflict
+	// ActionSkip reports a file left in place on the destination side
+	// because the file replacing it cannot be created there.
+	ActionSkip
+)
+
+// String returns the name of the action kind.
+func (k ActionKind) String() string {
+	switch k {
+	case ActionMkdir:
+		return "mkdir"
+	case ActionCopy:
+		return "copy"
+	case ActionSymlink:
+		return "symlink"
+	case ActionRemove:
+		return "remove"
+	case ActionConflict:
+		return "conflict"
+	case ActionSkip:
+		return "skip"
+	default:
+		return "unknown"
+	}
+}
+
+// Action is a change made, or to be made in a dry run, by Sync.
+type Action struct {
+	// Kind is the kind of the action.
+	Kind ActionKind
+	// Name is the path the action applies to.
+	Name string
+	// Direction is AToB if the action changes B and BToA if it changes A.
+	// It is Both for ActionConflict.
+	Direction Direction
+}
+
+// Config specifies the configuration for Sync and Run.
+type Config struct {
+	// Strategy selects how regular files are compared.
+	Strategy Strategy
+	// ModTimeWindow is the largest difference between modification times
+	// that CompareModTime still considers equal. It accommodates backends
+	// that store times with a coarse precision.
+	ModTimeWindow time.Duration
+	// Direction is the direction in which changes are applied.
+	Direction Direction
+	// Conflict resolves differences in a bidirectional sync.
+	Conflict ConflictPolicy
+	// Delete removes files missing on the source side from the destination
+	// side of a one-way sync. It has no effect for Both, where a missing file
+	// cannot be told apart from a new one.
+	Delete bool
+	// DryRun reports actions without applying them.
+	DryRun bool
+	// Progress, if set, is called with every action before it is applied.
+	Progress func(Action)
+	// Interval is how often Run checks for changes.
+	// If 0, DefaultInterval is used.
+	Interval time.Duration
+	// Clock schedules the checks of Run.
+	// If nil, contextual.RealClock is used.
+	Clock contextual.Clock
+}
+
+// syncer holds the state of a single Sync.
+type syncer struct {
+	a, b    contextual.FS
+	config  Config
+	actions []Action
+}
+
+// Sync synchronizes the trees of a and b according to config and returns the
+// actions taken. It stops at the first error, returning the actions taken so
+// far along with it.
+func Sync(ctx context.Context, a, b contextual.FS, config Config) ([]Action, error) {
+	s := &syncer{a: a, b: b, config: config}
+	err := s.dir(ctx, ".", true, true)
+	return s.actions, err
+}
+
+// sides returns the source and destination filesystems of dir.
+func (s *syncer) sides(dir Direction) (src, dst contextual.FS) {
+	if dir == BToA {
+		return s.b, s.a
+	}
+	return s.a, s.b
+}
+
+// report records an action and reports whether it should be applied.
+func (s *syncer) report(kind ActionKind, name string, dir Direction) bool {
+	action := Action{Kind: kind, Name: name, Direction: dir}
+	s.actions = append(s.actions, action)
+	if s.config.Progress != nil {
+		s.config.Progress(action)
+	}
+	return !s.config.DryRun && kind != ActionConflict && kind != ActionSkip
+}
+
+// readDir returns the entries of the named directory of fsys by name, or nil
+// if exists is false.
+func readDir(ctx context.Context, fsys contextual.FS, name string, exists bool) (map[string]fs.DirEntry, error) {
+	if !exists {
+		return nil, nil
+	}
+	entries, err := contextual.ReadDir(ctx, fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]fs.DirEntry, len(entries))
+	for _, e := range entries {
+		m[e.Name()] = e
+	}
+	return m, nil
+}
+
+// dir synchronizes the named directory. inA and inB tell whether it exists on
+// either side; a missing side is treated as empty.
+func (s *syncer) dir(ctx context.Context, name string, inA, inB bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ea, err := readDir(ctx, s.a, name, inA)
+	if err != nil {
+		return err
+	}
+	eb, err := readDir(ctx, s.b, name, inB)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(ea)+len(eb))
+	for n := range ea {
+		names = append(names, n)
+	}
+	for n := range eb {
+		if _, ok := ea[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	slices.Sort(names)
+
+	for _, n := range names {
+		p := path.Join(name, n)
+		da, okA := ea[n]
+		db, okB := eb[n]
+		switch {
+		case okA && okB:
+			err = s.both(ctx, p, da, db)
+		case okA:
+			err = s.one(ctx, p, da, AToB)
+		default:
+			err = s.one(ctx, p, db, BToA)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// one handles the path name that only exists on the source side of dir.
+func (s *syncer) one(ctx context.Context, name string, entry fs.DirEntry, dir Direction) error {
+	switch s.config.Direction {
+	case dir, Both:
+		return s.create(ctx, name, entry, dir)
+	}
+	if !s.config.Delete {
+		return nil
+	}
+	// The path only exists on the destination side of the sync.
+	dir = s.config.Direction
+	if s.report(ActionRemove, name, dir) {
+		_, dst := s.sides(dir)
+		return contextual.RemoveAll(ctx, dst, name)
+	}
+	return nil
+}
+
+// create copies the file described by entry from the source to the
+// destination side of dir.
+func (s *syncer) create(ctx context.Context, name string, entry fs.DirEntry, dir Direction) error {
+	src, dst := s.sides(dir)
+	switch entry.Type() {
+	case fs.ModeDir:
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if s.report(ActionMkdir, name, dir) {
+			if err := contextual.Mkdir(ctx, dst, name, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		return s.dir(ctx, name, dir == AToB, dir == BToA)
+	case fs.ModeSymlink:
+		if !s.report(ActionSymlink, name, dir) {
+			return nil
+		}
+		target, err := contextual.ReadLink(ctx, src, name)
+		if err != nil {
+			return err
+		}
+		return contextual.Symlink(ctx, dst, target, name)
+	case 0:
+		if s.report(ActionCopy, name, dir) {
+			return copyFile(ctx, src, dst, name)
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// both handles the path name that exists on both sides.
+func (s *syncer) both(ctx context.Context, name string, da, db fs.DirEntry) error {
+	ta, tb := da.Type(), db.Type()
+	if ta == fs.ModeDir && tb == fs.ModeDir {
+		return s.dir(ctx, name, true, true)
+	}
+	if ta == tb {
+		same, err := s.same(ctx, name, da)
+		if same || err != nil {
+			return err
+		}
+	}
+
+	dir := s.config.Direction
+	if dir == Both {
+		var ok bool
+		dir, ok = s.resolve(da, db)
+		if !ok {
+			s.report(ActionConflict, name, Both)
+			return nil
+		}
+	}
+
+	src := da
+	if dir == BToA {
+		src = db
+	}
+	_, dst := s.sides(dir)
+	if !creatable(dst, src) {
+		// Removing the destination would lose it for nothing.
+		s.report(ActionSkip, name, dir)
+		return nil
+	}
+	// Regular files are overwritten in place; anything else is replaced.
+	if ta != 0 || tb != 0 {
+		if s.report(ActionRemove, name, dir) {
+			if err := contextual.RemoveAll(ctx, dst, name); err != nil {
+				return err
+			}
+		}
+	}
+	return s.create(ctx, name, src, dir)
+}
+
+// creatable reports whether create can make a copy of the file described
+// by entry in dst.
+func creatable(dst contextual.FS, entry fs.DirEntry) bool {
+	switch entry.Type() {
+	case fs.ModeDir, 0:
+		return true
+	case fs.ModeSymlink:
+		_, ok := dst.(contextual.SymlinkFS)
+		return ok
+	default:
+		return false
+	}
+}
+
+// same reports whether the file name, of the type of entry, is the same on
+// both sides.
+func (s *syncer) same(ctx context.Context, name string, entry fs.DirEntry) (bool, error) {
+	switch entry.Type() {
+	case fs.ModeSymlink:
+		la, err := contextual.ReadLink(ctx, s.a, name)
+		if err != nil {
+			return false, err
+		}
+		lb, err := contextual.ReadLink(ctx, s.b, name)
+		return la == lb, err
+	case 0:
+	default:
+		// Unsupported kinds of files are left alone.
+		return true, nil
+	}
+
+	ia, err := contextual.Lstat(ctx, s.a, name)
+	if err != nil {
+		return false, err
+	}
+	ib, err := contextual.Lstat(ctx, s.b, name)
+	if err != nil {
+		return false, err
+	}
+	if ia.Size() != ib.Size() {
+		return false, nil
+	}
+
+	switch s.config.Strategy {
+	case CompareSize:
+		return true, nil
+	case CompareHash:
+		ha, err := contextual.Hash(ctx, s.a, name)
+		if err != nil {
+			return false, err
+		}
+		hb, err := contextual.Hash(ctx, s.b, name)
+		return bytes.Equal(ha, hb), err
+	default:
+		d := ia.ModTime().Sub(ib.ModTime()).Abs()
+		return d <= s.config.ModTimeWindow, nil
+	}
+}
+
+// resolve applies the conflict policy to a path whose entries differ. It
+// returns the direction to copy in, or false if the conflict is skipped.
+func (s *syncer) resolve(da, db fs.DirEntry) (Direction, bool) {
+	switch s.config.Conflict {
+	case ConflictPreferA:
+		return AToB, true
+	case ConflictPreferB:
+		return BToA, true
+	case ConflictPreferNewer:
+		ia, err := da.Info()
+		if err != nil {
+			return 0, false
+		}
+		ib, err := db.Info()
+		if err != nil {
+			return 0, false
+		}
+		switch ia.ModTime().Compare(ib.ModTime()) {
+		case 1:
+			return AToB, true
+		case -1:
+			return BToA, true
+		}
+	}
+	return 0, false
+}
+
+// copyFile copies the contents, permissions and modification time of the
+// regular file name from src to dst.
+func copyFile(ctx context.Context, src, dst contextual.FS, name string) (err error) {
+	in, err := src.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := contextual.OpenFile(ctx, dst, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := internal.Copy(out, in); err != nil {
+		_ = out.Close()
+		return &fs.PathError{Op: "copy", Path: name, Err: err}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	mtime := info.ModTime()
+	if err := contextual.Chtimes(ctx, dst, name, mtime, mtime); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package syncfs_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/journalfs"
+	"github.com/gwangyi/fsx/osfs"
+	"github.com/gwangyi/fsx/syncfs"
+)
+
+func newTree(t *testing.T, files map[string]string) contextual.FS {
+	t.Helper()
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfs := contextual.ToContextual(fsys)
+	for name, data := range files {
+		writeFile(t, cfs, name, data)
+	}
+	return cfs
+}
+
+func writeFile(t *testing.T, fsys contextual.FS, name, data string) {
+	t.Helper()
+	if dir := path.Dir(name); dir != "." {
+		if err := contextual.MkdirAll(t.Context(), fsys, dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := contextual.WriteFile(t.Context(), fsys, name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setModTime(t *testing.T, fsys contextual.FS, name string, mtime time.Time) {
+	t.Helper()
+	if err := contextual.Chtimes(t.Context(), fsys, name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkFile(t *testing.T, fsys contextual.FS, name, want string) {
+	t.Helper()
+	data, err := contextual.ReadFile(t.Context(), fsys, name)
+	if err != nil {
+		t.Errorf("ReadFile(%q): %v", name, err)
+	} else if string(data) != want {
+		t.Errorf("ReadFile(%q) = %q, want %q", name, data, want)
+	}
+}
+
+func describe(actions []syncfs.Action) []string {
+	var list []string
+	for _, a := range actions {
+		list = append(list, a.Kind.String()+" "+a.Name+" "+a.Direction.String())
+	}
+	return list
+}
+
+func checkActions(t *testing.T, actions []syncfs.Action, want ...string) {
+	t.Helper()
+	got := describe(actions)
+	if len(got) != len(want) {
+		t.Errorf("actions = %v, want %v", got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("actions = %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	t.Run("one way", func(t *testing.T) {
+		ctx := t.Context()
+		a := newTree(t, map[string]string{"dir/file": "new", "same": "same", "top": "top"})
+		b := newTree(t, map[string]string{"dir/file": "old", "same": "same", "stale": "stale"})
+		mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		setModTime(t, a, "same", mtime)
+		setModTime(t, b, "same", mtime)
+		if err := contextual.Symlink(ctx, a, "top", "link"); err != nil {
+			t.Fatal(err)
+		}
+
+		var progress []syncfs.Action
+		actions, err := syncfs.Sync(ctx, a, b, syncfs.Config{
+			Delete:   true,
+			Progress: func(a syncfs.Action) { progress = append(progress, a) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions,
+			"copy dir/file a->b", "symlink link a->b", "remove stale a->b", "copy top a->b")
+		checkActions(t, progress, describe(actions)...)
+
+		checkFile(t, b, "dir/file", "new")
+		checkFile(t, b, "top", "top")
+		if target, err := contextual.ReadLink(ctx, b, "link"); err != nil || target != "top" {
+			t.Errorf("ReadLink() = %q, %v", target, err)
+		}
+		if _, err := contextual.Stat(ctx, b, "stale"); err == nil {
+			t.Error("expected stale to be removed")
+		}
+
+		// Copies keep their modification times, so a second pass is a no-op.
+		actions, err = syncfs.Sync(ctx, a, b, syncfs.Config{Delete: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		ctx := t.Context()
+		a := newTree(t, map[string]string{"dir/sub/file": "data"})
+		b := newTree(t, map[string]string{"stale": "stale"})
+
+		actions, err := syncfs.Sync(ctx, a, b, syncfs.Config{Delete: true, DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions,
+			"mkdir dir a->b", "mkdir dir/sub a->b", "copy dir/sub/file a->b", "remove stale a->b")
+		if _, err := contextual.Stat(ctx, b, "dir"); err == nil {
+			t.Error("dry run created dir")
+		}
+		checkFile(t, b, "stale", "stale")
+	})
+
+	t.Run("strategies", func(t *testing.T) {
+		ctx := t.Context()
+		a := newTree(t, map[string]string{"file": "aaaa"})
+		b := newTree(t, map[string]string{"file": "bbbb"})
+		mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		setModTime(t, a, "file", mtime)
+		setModTime(t, b, "file", mtime.Add(time.Second))
+
+		actions, err := syncfs.Sync(ctx, a, b, syncfs.Config{Strategy: syncfs.CompareSize, DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions)
+
+		actions, err = syncfs.Sync(ctx, a, b, syncfs.Config{ModTimeWindow: 2 * time.Second, DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions)
+
+		actions, err = syncfs.Sync(ctx, a, b, syncfs.Config{DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions, "copy file a->b")
+
+		setModTime(t, b, "file", mtime)
+		actions, err = syncfs.Sync(ctx, a, b, syncfs.Config{Strategy: syncfs.CompareHash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions, "copy file a->b")
+		checkFile(t, b, "file", "aaaa")
+	})
+
+	t.Run("both", func(t *testing.T) {
+		ctx := t.Context()
+		a := newTree(t, map[string]string{"only-a": "a", "conflict": "from a", "newer": "from a"})
+		b := newTree(t, map[string]string{"only-b": "b", "conflict": "from b", "newer": "from b"})
+		mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+		setModTime(t, a, "conflict", mtime)
+		setModTime(t, b, "conflict", mtime)
+		setModTime(t, a, "newer", mtime)
+		setModTime(t, b, "newer", mtime.Add(time.Minute))
+
+		actions, err := syncfs.Sync(ctx, a, b, syncfs.Config{
+			Direction: syncfs.Both,
+			Strategy:  syncfs.CompareHash,
+			Conflict:  syncfs.ConflictPreferNewer,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions,
+			"conflict conflict both", "copy newer b->a", "copy only-a a->b", "copy only-b b->a")
+		checkFile(t, a, "newer", "from b")
+		checkFile(t, a, "only-b", "b")
+		checkFile(t, b, "only-a", "a")
+		checkFile(t, a, "conflict", "from a")
+		checkFile(t, b, "conflict", "from b")
+
+		actions, err = syncfs.Sync(ctx, a, b, syncfs.Config{Direction: syncfs.Both, Conflict: syncfs.ConflictPreferB, Strategy: syncfs.CompareHash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions, "copy conflict b->a")
+		checkFile(t, a, "conflict", "from b")
+	})
+
+	t.Run("type change", func(t *testing.T) {
+		ctx := t.Context()
+		a := newTree(t, map[string]string{"name": "file"})
+		b := newTree(t, map[string]string{"name/inner": "dir"})
+
+		actions, err := syncfs.Sync(ctx, a, b, syncfs.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions, "remove name a->b", "copy name a->b")
+		checkFile(t, b, "name", "file")
+	})
+
+	t.Run("unsupported type change", func(t *testing.T) {
+		ctx := t.Context()
+		a := newTree(t, nil)
+		b := newTree(t, map[string]string{"name": "file"})
+		if err := contextual.CreateSpecial(ctx, a, "name", fs.ModeNamedPipe|0644, 0); errors.Is(err, errors.ErrUnsupported) {
+			t.Skip("named pipes are not supported")
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		actions, err := syncfs.Sync(ctx, a, b, syncfs.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkActions(t, actions, "skip name a->b")
+		checkFile(t, b, "name", "file")
+	})
+}
+
+func TestRun(t *testing.T) {
+	a := journalfs.New(newTree(t, nil), journalfs.Config{})
+	b := journalfs.New(newTree(t, nil), journalfs.Config{})
+	clock := fsxtest.NewClock(time.Now())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() {
+		done <- syncfs.Run(ctx, a, b, syncfs.Config{Interval: time.Minute, Clock: clock})
+	}()
+
+	// Run schedules its next check once it is done with the previous one.
+	idle := func() {
+		t.Helper()
+		for clock.Timers() == 0 {
+			runtime.Gosched()
+		}
+	}
+	idle()
+	writeFile(t, a, "file", "data")
+	if _, err := contextual.Stat(t.Context(), b, "file"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("file was synchronized before the interval elapsed: %v", err)
+	}
+	clock.Advance(time.Minute)
+	idle()
+	checkFile(t, b, "file", "data")
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
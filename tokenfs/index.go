@@ -0,0 +1,203 @@
+package tokenfs
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// indexVersion is the version of the format of the index.
+const indexVersion = 1
+
+// ErrCorruptIndex is returned, wrapped in an *fs.PathError, when the index
+// cannot be decrypted with the key of the Config, or does not decode.
+var ErrCorruptIndex = errors.New("tokenfs: index is corrupt or encrypted with another key")
+
+// indexData is the plaintext of the index.
+type indexData struct {
+	Version int               `json:"version"`
+	Names   map[string]string `json:"names"`
+}
+
+// load reads and decrypts the index. A missing index is empty.
+func (f *filesystem) load(ctx context.Context) error {
+	data, err := contextual.ReadFile(ctx, f.fsys, f.config.IndexFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	corrupt := &fs.PathError{Op: "open", Path: f.config.IndexFile, Err: ErrCorruptIndex}
+	size := f.aead.NonceSize()
+	if len(data) < size {
+		return corrupt
+	}
+	plain, err := f.aead.Open(nil, data[:size], data[size:], []byte(f.config.IndexFile))
+	if err != nil {
+		return corrupt
+	}
+	var index indexData
+	if err := json.Unmarshal(plain, &index); err != nil {
+		return corrupt
+	}
+	if index.Version != indexVersion {
+		return &fs.PathError{Op: "open", Path: f.config.IndexFile, Err: fmt.Errorf("unsupported index version %d: %w", index.Version, errors.ErrUnsupported)}
+	}
+	if index.Names != nil {
+		f.names = index.Names
+	}
+	return nil
+}
+
+// saveLocked encrypts and atomically writes the index. f.mu must be held.
+func (f *filesystem) saveLocked(ctx context.Context) error {
+	plain, err := json.Marshal(indexData{Version: indexVersion, Names: f.names})
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, f.aead.NonceSize(), f.aead.NonceSize()+len(plain)+f.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := f.aead.Seal(nonce, nonce, plain, []byte(f.config.IndexFile))
+	return contextual.WriteFileAtomic(ctx, f.fsys, f.config.IndexFile, data, 0600)
+}
+
+// newAEAD returns the cipher encrypting the index with key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// record adds the elements of the given names to the index, saving it if
+// any of them is new. It is called before the names are created in the
+// underlying filesystem, so that every stored token can be resolved.
+func (f *filesystem) record(ctx context.Context, names ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var added []string
+	for _, name := range names {
+		for elem := range strings.SplitSeq(name, "/") {
+			if elem == "" || elem == "." || elem == ".." {
+				continue
+			}
+			if token := f.token(elem); f.names[token] != elem {
+				f.names[token] = elem
+				added = append(added, token)
+			}
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if err := f.saveLocked(ctx); err != nil {
+		for _, token := range added {
+			delete(f.names, token)
+		}
+		return err
+	}
+	return nil
+}
+
+// tokens calls fn with the path, relative to the root of the underlying
+// filesystem, of every stored file but the index, and with the tokens used
+// by the relative targets of stored symbolic links.
+func (f *filesystem) tokens(ctx context.Context, fn func(name string, token string)) error {
+	under := contextual.FromContextual(f.fsys, ctx)
+	return fs.WalkDir(under, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." || name == f.config.IndexFile {
+			return nil
+		}
+		fn(name, path.Base(name))
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := contextual.ReadLink(ctx, f.fsys, name)
+		if err != nil || path.IsAbs(target) {
+			return err
+		}
+		for elem := range strings.SplitSeq(target, "/") {
+			if elem != "" && elem != "." && elem != ".." {
+				fn(name, elem)
+			}
+		}
+		return nil
+	})
+}
+
+// Rebuild scans the underlying filesystem of fsys, which must have been
+// created by New, and drops the names of the index that are no longer used
+// by any stored file or symbolic link, so that the index does not keep the
+// names of removed files.
+//
+// Rebuild must not run concurrently with writes through the filesystem:
+// a name recorded by a write whose file is not yet in place would be
+// considered unused and dropped.
+func Rebuild(ctx context.Context, fsys contextual.FS) error {
+	f := fsys.(*filesystem)
+	used := make(map[string]bool)
+	if err := f.tokens(ctx, func(_, token string) { used[token] = true }); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make(map[string]string, len(used))
+	for token, name := range f.names {
+		if used[token] {
+			names[token] = name
+		}
+	}
+	if len(names) == len(f.names) {
+		return nil
+	}
+	old := f.names
+	f.names = names
+	if err := f.saveLocked(ctx); err != nil {
+		f.names = old
+		return err
+	}
+	return nil
+}
+
+// Verify checks that every file stored in the underlying filesystem of fsys,
+// which must have been created by New, and every relative symbolic link
+// target, can be resolved to a name with the index. It returns the sorted
+// paths in the underlying filesystem of the files whose names or targets are
+// missing from the index; such files are hidden from directory listings.
+func Verify(ctx context.Context, fsys contextual.FS) ([]string, error) {
+	f := fsys.(*filesystem)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var unresolved []string
+	err := f.tokens(ctx, func(name, token string) {
+		if _, ok := f.names[token]; !ok {
+			unresolved = append(unresolved, name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(unresolved)
+	return slices.Compact(unresolved), nil
+}
@@ -0,0 +1,496 @@
+// Package tokenfs provides a contextual filesystem wrapper that stores every
+// file of its namespace under opaque tokens instead of its name, for
+// underlying filesystems, such as object stores, whose names are visible to
+// whoever operates them.
+//
+// Each element of a path is replaced by a keyed hash of itself. Tokens are
+// deterministic: an element has the same token wherever it appears, which
+// reveals that two elements share a name but not the name itself. Since
+// tokens cannot be reversed, the names are kept in an index stored in the
+// underlying filesystem, encrypted and authenticated with AES-GCM. The index
+// is only needed to list directories and to read symbolic links.
+//
+// The index only grows as files are created; Rebuild drops the names no
+// longer in use and Verify reports the stored files it cannot resolve.
+package tokenfs
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// DefaultIndexFile is the name of the index in the root of the underlying
+// filesystem unless Config.IndexFile is set.
+const DefaultIndexFile = ".tokenfs-index"
+
+// Config specifies the configuration for tokenfs.
+type Config struct {
+	// Key is the secret from which the tokens and the key encrypting the
+	// index are derived. It must be 16, 24 or 32 bytes long. Files stored
+	// with a key cannot be reached with another one.
+	Key []byte
+	// IndexFile is the name of the encrypted index in the root of the
+	// underlying filesystem. If empty, DefaultIndexFile is used.
+	IndexFile string
+}
+
+// filesystem is a contextual filesystem that tokenizes names.
+type filesystem struct {
+	fsys     contextual.FS
+	config   Config
+	tokenKey []byte
+	aead     cipher.AEAD
+
+	mu sync.Mutex
+	// names maps tokens to the path elements they stand for.
+	names map[string]string
+}
+
+// New creates a new tokenfs storing its files in fsys, and loads the index
+// of fsys if there is one. It fails if the index cannot be decrypted with
+// config.Key.
+func New(ctx context.Context, fsys contextual.FS, config Config) (contextual.FileSystem, error) {
+	if config.IndexFile == "" {
+		config.IndexFile = DefaultIndexFile
+	}
+	switch len(config.Key) {
+	case 16, 24, 32:
+	default:
+		return nil, errors.New("tokenfs: key must be 16, 24 or 32 bytes long")
+	}
+
+	// The tokens and the index use distinct keys derived from config.Key.
+	aead, err := newAEAD(derive(config.Key, "index")[:len(config.Key)])
+	if err != nil {
+		return nil, err
+	}
+	f := &filesystem{
+		fsys:     fsys,
+		config:   config,
+		tokenKey: derive(config.Key, "token"),
+		aead:     aead,
+		names:    make(map[string]string),
+	}
+	if err := f.load(ctx); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Unwrap returns the filesystem the tokenfs is layered on.
+func (f *filesystem) Unwrap() contextual.FS {
+	return f.fsys
+}
+
+// derive returns the key for purpose derived from key.
+func derive(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("tokenfs " + purpose))
+	return mac.Sum(nil)
+}
+
+// token returns the token standing for the path element elem.
+func (f *filesystem) token(elem string) string {
+	mac := hmac.New(sha256.New, f.tokenKey)
+	mac.Write([]byte(elem))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// path returns the path in the underlying filesystem of name, or of a
+// relative symbolic link target. Empty, "." and ".." elements are kept.
+func (f *filesystem) path(name string) string {
+	if name == "." {
+		return name
+	}
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		if elem != "" && elem != "." && elem != ".." {
+			elems[i] = f.token(elem)
+		}
+	}
+	return strings.Join(elems, "/")
+}
+
+// name returns the path element standing for token, reporting false if the
+// index does not know it.
+func (f *filesystem) name(token string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name, ok := f.names[token]
+	return name, ok
+}
+
+// fileInfo reports the name of a file instead of its token.
+type fileInfo struct {
+	contextual.FileInfo
+	name string
+}
+
+// Name returns the base name of the file.
+func (fi *fileInfo) Name() string { return fi.name }
+
+// wrapInfo returns info under the base name of name.
+func wrapInfo(name string, info fs.FileInfo) fs.FileInfo {
+	if name == "." {
+		return info
+	}
+	return &fileInfo{FileInfo: contextual.ExtendFileInfo(info), name: path.Base(name)}
+}
+
+// dirEntry reports the name of an entry instead of its token.
+type dirEntry struct {
+	fs.DirEntry
+	name string
+}
+
+// Name returns the name of the entry.
+func (d *dirEntry) Name() string { return d.name }
+
+// Info returns the FileInfo of the entry under its name.
+func (d *dirEntry) Info() (fs.FileInfo, error) {
+	info, err := d.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return wrapInfo(d.name, info), nil
+}
+
+// wrapEntries resolves the names of the entries of the directory dir,
+// dropping the index and the entries whose tokens are unknown.
+func (f *filesystem) wrapEntries(dir string, entries []fs.DirEntry) []fs.DirEntry {
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		if dir == "." && e.Name() == f.config.IndexFile {
+			continue
+		}
+		if name, ok := f.name(e.Name()); ok {
+			list = append(list, &dirEntry{DirEntry: e, name: name})
+		}
+	}
+	return list
+}
+
+// file presents an open file under its name.
+type file struct {
+	fsx.File
+	fs   *filesystem
+	name string
+}
+
+// Stat returns the FileInfo of the file under its name.
+func (f *file) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return wrapInfo(f.name, info), nil
+}
+
+// Restat describes the file as it is now, under its name.
+func (f *file) Restat() (fs.FileInfo, error) {
+	info, err := fsx.Restat(f.File)
+	if err != nil {
+		return nil, err
+	}
+	return wrapInfo(f.name, info), nil
+}
+
+// WriteTo and ReadFrom let the file serve or fill itself without buffering,
+// as only its name differs from the stored file.
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	return fsx.WriteTo(w, f.File)
+}
+
+func (f *file) ReadFrom(r io.Reader) (int64, error) {
+	return fsx.ReadFrom(f.File, r)
+}
+
+// ReadDir reads the entries of a directory under their names. It is only
+// exposed, through fsx.WrapFile, if the file implements fs.ReadDirFile.
+func (f *file) ReadDir(n int) ([]fs.DirEntry, error) {
+	dir := f.File.(fs.ReadDirFile)
+	for {
+		entries, err := dir.ReadDir(n)
+		list := f.fs.wrapEntries(f.name, entries)
+		// Keep reading when all the entries were dropped, since an empty
+		// result with a nil error is only allowed for n <= 0.
+		if len(list) > 0 || err != nil || n <= 0 || len(entries) == 0 {
+			return list, err
+		}
+	}
+}
+
+// Open opens the named file for reading.
+func (f *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
+	return f.OpenFile(ctx, name, os.O_RDONLY, 0)
+}
+
+// Create creates or truncates the named file.
+func (f *filesystem) Create(ctx context.Context, name string) (fsx.File, error) {
+	return f.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// OpenFile opens the named file.
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	if err := internal.CheckOpen(name, flag); err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 {
+		if err := f.record(ctx, name); err != nil {
+			return nil, internal.Decorate("open", name, err)
+		}
+	}
+	inner, err := contextual.OpenFile(ctx, f.fsys, f.path(name), flag, mode)
+	if err != nil {
+		return nil, internal.Decorate("open", name, err)
+	}
+	return fsx.WrapFile(&file{File: inner, fs: f, name: name}, inner), nil
+}
+
+// Remove removes the named file or empty directory.
+func (f *filesystem) Remove(ctx context.Context, name string) error {
+	if err := internal.CheckPath("remove", name); err != nil {
+		return err
+	}
+	return internal.Decorate("remove", name, contextual.Remove(ctx, f.fsys, f.path(name)))
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f *filesystem) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := internal.CheckPath("readfile", name); err != nil {
+		return nil, err
+	}
+	data, err := contextual.ReadFile(ctx, f.fsys, f.path(name))
+	return data, internal.Decorate("readfile", name, err)
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f *filesystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("stat", name); err != nil {
+		return nil, err
+	}
+	info, err := contextual.Stat(ctx, f.fsys, f.path(name))
+	if err != nil {
+		return nil, internal.Decorate("stat", name, err)
+	}
+	return wrapInfo(name, info), nil
+}
+
+// Lstat returns a FileInfo describing the named file without following
+// symbolic links.
+func (f *filesystem) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := internal.CheckPath("lstat", name); err != nil {
+		return nil, err
+	}
+	info, err := contextual.Lstat(ctx, f.fsys, f.path(name))
+	if err != nil {
+		return nil, internal.Decorate("lstat", name, err)
+	}
+	return wrapInfo(name, info), nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+// Entries whose tokens are missing from the index are omitted.
+func (f *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	if err := internal.CheckPath("readdir", name); err != nil {
+		return nil, err
+	}
+	entries, err := contextual.ReadDir(ctx, f.fsys, f.path(name))
+	if err != nil {
+		return nil, internal.Decorate("readdir", name, err)
+	}
+	list := f.wrapEntries(name, entries)
+	fsx.SortDirEntries(list, fsx.ByName)
+	return list, nil
+}
+
+// Mkdir creates a new directory.
+func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := internal.CheckPath("mkdir", name); err != nil {
+		return err
+	}
+	if err := f.record(ctx, name); err != nil {
+		return internal.Decorate("mkdir", name, err)
+	}
+	return internal.Decorate("mkdir", name, contextual.Mkdir(ctx, f.fsys, f.path(name), perm))
+}
+
+// MkdirAll creates a directory named name, along with any necessary parents.
+func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	if err := internal.CheckPath("mkdir", name); err != nil {
+		return err
+	}
+	if err := f.record(ctx, name); err != nil {
+		return internal.Decorate("mkdir", name, err)
+	}
+	return internal.Decorate("mkdir", name, contextual.MkdirAll(ctx, f.fsys, f.path(name), perm))
+}
+
+// RemoveAll removes name and any children it contains. Removing the root
+// keeps the index.
+func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
+	if err := internal.CheckPath("removeall", name); err != nil {
+		return err
+	}
+	if name != "." {
+		return internal.Decorate("removeall", name, contextual.RemoveAll(ctx, f.fsys, f.path(name)))
+	}
+	entries, err := contextual.ReadDir(ctx, f.fsys, ".")
+	if err != nil {
+		return internal.Decorate("removeall", name, err)
+	}
+	for _, e := range entries {
+		if e.Name() == f.config.IndexFile {
+			continue
+		}
+		if err := contextual.RemoveAll(ctx, f.fsys, e.Name()); err != nil {
+			return internal.Decorate("removeall", name, err)
+		}
+	}
+	return nil
+}
+
+// Rename renames oldname to newname.
+func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("rename", oldname, newname); err != nil {
+		return err
+	}
+	if err := f.record(ctx, newname); err != nil {
+		return internal.DecorateLink("rename", oldname, newname, err)
+	}
+	err := contextual.Rename(ctx, f.fsys, f.path(oldname), f.path(newname))
+	return internal.DecorateLink("rename", oldname, newname, err)
+}
+
+// Symlink creates newname as a symbolic link to oldname. Relative targets
+// are tokenized like names, so that they resolve in the underlying
+// filesystem; absolute targets are stored as is.
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	if err := internal.CheckLink("symlink", oldname, newname); err != nil {
+		return err
+	}
+	target := oldname
+	if !path.IsAbs(oldname) {
+		if err := f.record(ctx, oldname); err != nil {
+			return internal.DecorateLink("symlink", oldname, newname, err)
+		}
+		target = f.path(oldname)
+	}
+	if err := f.record(ctx, newname); err != nil {
+		return internal.DecorateLink("symlink", oldname, newname, err)
+	}
+	err := contextual.Symlink(ctx, f.fsys, target, f.path(newname))
+	return internal.DecorateLink("symlink", oldname, newname, err)
+}
+
+// ReadLink returns the destination of the named symbolic link.
+func (f *filesystem) ReadLink(ctx context.Context, name string) (string, error) {
+	if err := internal.CheckPath("readlink", name); err != nil {
+		return "", err
+	}
+	target, err := contextual.ReadLink(ctx, f.fsys, f.path(name))
+	if err != nil {
+		return "", internal.Decorate("readlink", name, err)
+	}
+	if path.IsAbs(target) {
+		return target, nil
+	}
+	elems := strings.Split(target, "/")
+	for i, elem := range elems {
+		if elem == "" || elem == "." || elem == ".." {
+			continue
+		}
+		resolved, ok := f.name(elem)
+		if !ok {
+			return "", &fs.PathError{Op: "readlink", Path: name, Err: ErrCorruptIndex}
+		}
+		elems[i] = resolved
+	}
+	return strings.Join(elems, "/"), nil
+}
+
+// CreateSpecial creates a special file.
+func (f *filesystem) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	if err := internal.CheckPath("mknod", name); err != nil {
+		return err
+	}
+	if err := f.record(ctx, name); err != nil {
+		return internal.Decorate("mknod", name, err)
+	}
+	return internal.Decorate("mknod", name, contextual.CreateSpecial(ctx, f.fsys, f.path(name), mode, dev))
+}
+
+// Lchown changes the ownership of the named file without following links.
+func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
+	if err := internal.CheckPath("lchown", name); err != nil {
+		return err
+	}
+	return internal.Decorate("lchown", name, contextual.Lchown(ctx, f.fsys, f.path(name), owner, group))
+}
+
+// Truncate changes the size of the named file.
+func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
+	if err := internal.CheckPath("truncate", name); err != nil {
+		return err
+	}
+	return internal.Decorate("truncate", name, contextual.Truncate(ctx, f.fsys, f.path(name), size))
+}
+
+// WriteFile writes data to the named file, creating it if necessary.
+func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	if err := internal.CheckPath("writefile", name); err != nil {
+		return err
+	}
+	if err := f.record(ctx, name); err != nil {
+		return internal.Decorate("writefile", name, err)
+	}
+	return internal.Decorate("writefile", name, contextual.WriteFile(ctx, f.fsys, f.path(name), data, perm))
+}
+
+// Chown changes the ownership of the named file.
+func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
+	if err := internal.CheckPath("chown", name); err != nil {
+		return err
+	}
+	return internal.Decorate("chown", name, contextual.Chown(ctx, f.fsys, f.path(name), owner, group))
+}
+
+// Chmod changes the mode of the named file.
+func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	if err := internal.CheckPath("chmod", name); err != nil {
+		return err
+	}
+	return internal.Decorate("chmod", name, contextual.Chmod(ctx, f.fsys, f.path(name), mode))
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (f *filesystem) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	if err := internal.CheckPath("chtimes", name); err != nil {
+		return err
+	}
+	return internal.Decorate("chtimes", name, contextual.Chtimes(ctx, f.fsys, f.path(name), atime, mtime))
+}
+
+// Close closes the underlying filesystem.
+func (f *filesystem) Close() error {
+	return contextual.Close(f.fsys)
+}
+
+var _ contextual.FileSystem = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
+var _ contextual.SpecialFS = &filesystem{}
@@ -0,0 +1,165 @@
+package tokenfs_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/osfs"
+	"github.com/gwangyi/fsx/tokenfs"
+)
+
+var key = bytes.Repeat([]byte{0x42}, 32)
+
+func newBacking(t *testing.T) contextual.FS {
+	t.Helper()
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contextual.ToContextual(fsys)
+}
+
+func newTokenFS(t *testing.T, backing contextual.FS) contextual.FileSystem {
+	t.Helper()
+	f, err := tokenfs.New(t.Context(), backing, tokenfs.Config{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func names(entries []fs.DirEntry) []string {
+	var list []string
+	for _, e := range entries {
+		list = append(list, e.Name())
+	}
+	return list
+}
+
+func TestTokenFS(t *testing.T) {
+	ctx := t.Context()
+	backing := newBacking(t)
+	f := newTokenFS(t, backing)
+
+	if err := contextual.MkdirAll(ctx, f, "secret/plans", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"secret/plans/b.txt", "secret/plans/a.txt"} {
+		if err := contextual.WriteFile(ctx, f, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := contextual.Symlink(ctx, f, "plans/a.txt", "secret/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	// No name reaches the underlying filesystem.
+	err := fs.WalkDir(contextual.FromContextual(backing, ctx), ".", func(name string, d fs.DirEntry, err error) error {
+		for _, secret := range []string{"secret", "plans", ".txt", "link"} {
+			if strings.Contains(name, secret) {
+				t.Errorf("underlying filesystem holds %q", name)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, f contextual.FS) {
+		t.Helper()
+		entries, err := contextual.ReadDir(ctx, f, "secret/plans")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := names(entries), []string{"a.txt", "b.txt"}; !slices.Equal(got, want) {
+			t.Errorf("ReadDir() = %v, want %v", got, want)
+		}
+		if data, err := contextual.ReadFile(ctx, f, "secret/link"); err != nil || string(data) != "secret/plans/a.txt" {
+			t.Errorf("ReadFile(link) = %q, %v", data, err)
+		}
+		if target, err := contextual.ReadLink(ctx, f, "secret/link"); err != nil || target != "plans/a.txt" {
+			t.Errorf("ReadLink() = %q, %v", target, err)
+		}
+		if info, err := contextual.Stat(ctx, f, "secret/plans/b.txt"); err != nil || info.Name() != "b.txt" {
+			t.Errorf("Stat() = %v, %v", info, err)
+		}
+	}
+	check(t, f)
+
+	// Directory handles list names too.
+	dir, err := f.Open(ctx, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := dir.(fs.ReadDirFile).ReadDir(-1)
+	_ = dir.Close()
+	if got := names(entries); err != nil || len(got) != 2 || !slices.Contains(got, "plans") || !slices.Contains(got, "link") {
+		t.Errorf("ReadDir() on handle = %v, %v", got, err)
+	}
+
+	// The index persists.
+	check(t, newTokenFS(t, backing))
+
+	if _, err := tokenfs.New(ctx, backing, tokenfs.Config{Key: bytes.Repeat([]byte{1}, 32)}); !errors.Is(err, tokenfs.ErrCorruptIndex) {
+		t.Errorf("expected ErrCorruptIndex with another key, got %v", err)
+	}
+	if _, err := tokenfs.New(ctx, backing, tokenfs.Config{Key: []byte("short")}); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+
+	var pathErr *fs.PathError
+	if _, err := contextual.Stat(ctx, f, "secret/missing"); !errors.As(err, &pathErr) || pathErr.Path != "secret/missing" {
+		t.Errorf("expected an error on the name, got %v", err)
+	}
+}
+
+func TestRebuildVerify(t *testing.T) {
+	ctx := t.Context()
+	backing := newBacking(t)
+	f := newTokenFS(t, backing)
+
+	for _, name := range []string{"keep", "drop"} {
+		if err := contextual.WriteFile(ctx, f, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := contextual.Remove(ctx, f, "drop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokenfs.Rebuild(ctx, f); err != nil {
+		t.Fatal(err)
+	}
+	if unresolved, err := tokenfs.Verify(ctx, f); err != nil || len(unresolved) != 0 {
+		t.Errorf("Verify() = %v, %v", unresolved, err)
+	}
+
+	// A file stored without going through the index cannot be resolved.
+	if err := contextual.WriteFile(ctx, backing, "stray", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if unresolved, err := tokenfs.Verify(ctx, f); err != nil || !slices.Equal(unresolved, []string{"stray"}) {
+		t.Errorf("Verify() = %v, %v, want [stray]", unresolved, err)
+	}
+	entries, err := contextual.ReadDir(ctx, f, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(entries); !slices.Equal(got, []string{"keep"}) {
+		t.Errorf("ReadDir() = %v, want [keep]", got)
+	}
+}
+
+func TestTokenFS_ErrorOps(t *testing.T) {
+	fsxtest.CheckErrorOps(t, newTokenFS(t, newBacking(t)), "missing")
+}
+
+func TestTokenFS_InvalidPaths(t *testing.T) {
+	fsxtest.CheckInvalidPaths(t, newTokenFS(t, newBacking(t)))
+}
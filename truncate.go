@@ -21,7 +21,8 @@ type TruncateFS interface {
 //
 // If fsys implements TruncateFS, it calls fsys.Truncate.
 // Otherwise, it attempts to open the file with write permissions and call
-// the Truncate method on the returned File object.
+// the Truncate method on the returned File object. An error from closing
+// the file is reported, since it may mean the new size was not persisted.
 func Truncate(fsys fs.FS, name string, size int64) error {
 	// Try the optimized/direct TruncateFS implementation first.
 	if fsys, ok := fsys.(TruncateFS); ok {
@@ -36,6 +37,9 @@ func Truncate(fsys fs.FS, name string, size int64) error {
 	if err != nil {
 		return internal.IntoPathErr("truncate", name, err)
 	}
-	defer func() { _ = f.Close() }()
-	return internal.IntoPathErr("truncate", name, f.Truncate(size))
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return internal.IntoPathErr("truncate", name, err)
+	}
+	return internal.IntoPathErr("truncate", name, f.Close())
 }
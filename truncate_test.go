@@ -43,6 +43,23 @@ func TestTruncate(t *testing.T) {
 		}
 	})
 
+	t.Run("Fallback Close error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockFS := mockfs.NewMockWriterFS(ctrl)
+		mockFile := mockfs.NewMockFile(ctrl)
+		closeErr := errors.New("close failed")
+
+		mockFS.EXPECT().OpenFile("foo", os.O_WRONLY, fs.FileMode(0)).Return(mockFile, nil)
+		mockFile.EXPECT().Truncate(int64(100)).Return(nil)
+		mockFile.EXPECT().Close().Return(closeErr)
+
+		if err := fsx.Truncate(mockFS, "foo", 100); !errors.Is(err, closeErr) {
+			t.Errorf("expected close error, got %v", err)
+		}
+	})
+
 	t.Run("Unsupported", func(t *testing.T) {
 		m := fstest.MapFS{}
 		err := fsx.Truncate(m, "foo", 100)
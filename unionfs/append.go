@@ -0,0 +1,425 @@
+package unionfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// appendPrefix starts the name of the tail of an append overlay.
+const appendPrefix = ".ap."
+
+// tailMagic starts the header of a tail, followed by the size of the base
+// file in decimal and a newline.
+const tailMagic = "unionfs-append "
+
+// maxTailHeader bounds the length of the header of a tail.
+const maxTailHeader = 64
+
+// errMalformedTail is returned when the header of a tail cannot be parsed.
+var errMalformedTail = errors.New("unionfs: malformed append tail")
+
+// SetAppendOverlay enables or disables append overlays. When enabled, a
+// regular file of a read-only layer opened for appending, with O_WRONLY and
+// O_APPEND but neither O_TRUNC nor O_EXCL, is not copied to the read-write
+// layer: only the appended bytes are stored there, in a tail kept with the
+// whiteouts, along with the size of the file they follow. Reads stitch the
+// file of the read-only layer, up to that size, and the tail together.
+//
+// Any other modification of the file, such as opening it for reading and
+// writing, truncating it or changing its mode, copies the stitched contents
+// to the read-write layer and drops the tail, as would have happened without
+// append overlays. Removing or renaming the file drops the tail too.
+//
+// Tails are only honoured while append overlays are enabled, so it must be
+// set before the union is used and kept for its lifetime. The tails of a
+// nested union flattened by New are not stitched.
+func SetAppendOverlay(fs contextual.FS, enabled bool) {
+	fs.(*filesystem).appendOverlay = enabled
+}
+
+// tail describes the bytes appended to a file of a read-only layer.
+type tail struct {
+	// offset is the size of the file of the read-only layer when the tail
+	// was created. The appended bytes follow the first offset bytes of it.
+	offset int64
+	// header is the length of the header preceding the appended bytes.
+	header int64
+	// size is the number of bytes appended.
+	size int64
+	// modTime is the time of the last append.
+	modTime time.Time
+}
+
+// readTail returns the tail of name, or nil if append overlays are disabled
+// or name has no tail.
+func (f *filesystem) readTail(ctx context.Context, name string) (*tail, error) {
+	if !f.appendOverlay {
+		return nil, nil
+	}
+	file, err := contextual.OpenFile(ctx, f.meta.store(f.rw), f.meta.control(name, appendPrefix), os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	buf := make([]byte, maxTailHeader)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	header, ok := bytes.CutPrefix(buf[:n], []byte(tailMagic))
+	end := bytes.IndexByte(header, '\n')
+	if !ok || end < 0 {
+		return nil, errMalformedTail
+	}
+	offset, err := strconv.ParseInt(string(header[:end]), 10, 64)
+	if err != nil || offset < 0 {
+		return nil, errMalformedTail
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	t := &tail{
+		offset:  offset,
+		header:  int64(len(tailMagic) + end + 1),
+		modTime: info.ModTime(),
+	}
+	t.size = max(info.Size()-t.header, 0)
+	return t, nil
+}
+
+// removeTail drops the tail of name, if any.
+func (f *filesystem) removeTail(ctx context.Context, name string) {
+	if f.appendOverlay {
+		_ = contextual.Remove(ctx, f.meta.store(f.rw), f.meta.control(name, appendPrefix))
+	}
+}
+
+// appendable reports whether opening name with flag appends to a regular
+// file of a read-only layer that can get an append overlay, and returns the
+// FileInfo of that file.
+func (f *filesystem) appendable(ctx context.Context, name string, flag int) (fs.FileInfo, bool) {
+	if !f.appendOverlay || flag&fsx.O_ACCMODE != os.O_WRONLY || flag&os.O_APPEND == 0 || flag&(os.O_TRUNC|os.O_EXCL) != 0 {
+		return nil, false
+	}
+	if _, err := contextual.Lstat(ctx, f.rw, name); !errors.Is(err, fs.ErrNotExist) {
+		return nil, false
+	}
+	_, info := f.findRO(ctx, name)
+	if info == nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	return info, true
+}
+
+// openTail opens the tail of name, a regular file of a read-only layer
+// described by base, for appending, creating it if needed.
+func (f *filesystem) openTail(ctx context.Context, name string, base fs.FileInfo) (fsx.File, error) {
+	store := f.meta.store(f.rw)
+	p := f.meta.control(name, appendPrefix)
+	file, err := contextual.OpenFile(ctx, store, p, os.O_WRONLY|os.O_APPEND, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		if parent := path.Dir(p); parent != "." {
+			if err := contextual.MkdirAll(ctx, store, parent, 0755); err != nil {
+				return nil, err
+			}
+		}
+		file, err = contextual.OpenFile(ctx, store, p, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, base.Mode().Perm())
+		if err == nil {
+			if _, err = fmt.Fprintf(file, "%s%d\n", tailMagic, base.Size()); err != nil {
+				_ = file.Close()
+				_ = contextual.Remove(ctx, store, p)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := f.readTail(ctx, name)
+	if err == nil && t == nil {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &appendFile{File: file, base: base, tail: t}, nil
+}
+
+// appendFile is a handle appending to the tail of a file.
+type appendFile struct {
+	fsx.File
+	base fs.FileInfo
+	tail *tail
+}
+
+// Stat describes the stitched file.
+func (a *appendFile) Stat() (fs.FileInfo, error) {
+	return a.describe(a.File.Stat())
+}
+
+// Restat describes the stitched file as it is now.
+func (a *appendFile) Restat() (fs.FileInfo, error) {
+	return a.describe(fsx.Restat(a.File))
+}
+
+// describe returns the FileInfo of the stitched file given info, that of
+// the tail file.
+func (a *appendFile) describe(info fs.FileInfo, err error) (fs.FileInfo, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &appendedInfo{
+		FileInfo: contextual.ExtendFileInfo(a.base),
+		size:     a.tail.offset + max(info.Size()-a.tail.header, 0),
+		modTime:  info.ModTime(),
+	}, nil
+}
+
+// appendedInfo describes a file of a read-only layer with its tail.
+type appendedInfo struct {
+	contextual.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (i *appendedInfo) Size() int64        { return i.size }
+func (i *appendedInfo) ModTime() time.Time { return i.modTime }
+
+// withTail returns info, the FileInfo of name in a read-only layer, adjusted
+// for the tail of name if it has one.
+func (f *filesystem) withTail(ctx context.Context, name string, info contextual.FileInfo) (contextual.FileInfo, error) {
+	if !f.appendOverlay || !info.Mode().IsRegular() {
+		return info, nil
+	}
+	t, err := f.readTail(ctx, name)
+	if err != nil || t == nil {
+		return info, err
+	}
+	return &appendedInfo{
+		FileInfo: info,
+		size:     t.offset + t.size,
+		modTime:  t.modTime,
+	}, nil
+}
+
+// appendTails adjusts the entries of dir listed by the read-only layers for
+// their tails. tails holds the names of the files of dir having one.
+func (f *filesystem) appendTails(ctx context.Context, dir string, entries []fs.DirEntry, tails map[string]bool) []fs.DirEntry {
+	for i, e := range entries {
+		if !tails[e.Name()] || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if info, err := f.withTail(ctx, path.Join(dir, e.Name()), contextual.ExtendFileInfo(info)); err == nil {
+			entries[i] = contextual.FileInfoToDirEntry(info)
+		}
+	}
+	return entries
+}
+
+// stitchData returns data, the contents of name read from a read-only
+// layer, followed by its tail if it has one.
+func (f *filesystem) stitchData(ctx context.Context, name string, data []byte) ([]byte, error) {
+	t, err := f.readTail(ctx, name)
+	if err != nil || t == nil {
+		return data, err
+	}
+	appended, err := contextual.ReadFile(ctx, f.meta.store(f.rw), f.meta.control(name, appendPrefix))
+	if err != nil {
+		return nil, err
+	}
+	return append(data[:min(t.offset, int64(len(data)))], appended[min(t.header, int64(len(appended))):]...), nil
+}
+
+// copyContents copies the contents of name from src, a read-only layer, to
+// out, followed by its tail if it has one, and returns the number of bytes
+// copied.
+func (f *filesystem) copyContents(ctx context.Context, src contextual.FS, name string, out io.Writer) (int64, error) {
+	t, err := f.readTail(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	if t == nil {
+		return contextual.ReadFileInto(ctx, src, name, out)
+	}
+	w := &limitedWriter{w: out, n: t.offset}
+	n, err := contextual.ReadFileInto(ctx, src, name, w)
+	if err != nil {
+		return 0, err
+	}
+	n = min(n, t.offset)
+	file, err := contextual.Open(ctx, f.meta.store(f.rw), f.meta.control(name, appendPrefix))
+	if err != nil {
+		return n, err
+	}
+	defer func() { _ = file.Close() }()
+	if _, err := io.CopyN(io.Discard, file, t.header); err != nil {
+		return n, err
+	}
+	m, err := internal.Copy(out, file)
+	return n + m, err
+}
+
+// limitedWriter writes the first n bytes written to it to w and silently
+// drops the rest.
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n <= 0 {
+		return len(p), nil
+	}
+	q := p[:min(int64(len(p)), l.n)]
+	n, err := l.w.Write(q)
+	l.n -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
+
+// stitch returns file, name opened for reading from a read-only layer,
+// followed by the tail of name if it has one.
+func (f *filesystem) stitch(ctx context.Context, name string, file fsx.File) (fsx.File, error) {
+	if !f.appendOverlay {
+		return file, nil
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return file, nil
+	}
+	t, err := f.readTail(ctx, name)
+	if err != nil || t == nil {
+		return file, err
+	}
+	appended, err := contextual.Open(ctx, f.meta.store(f.rw), f.meta.control(name, appendPrefix))
+	if err != nil {
+		return nil, err
+	}
+	base, err := readerAt(file)
+	if err == nil {
+		var r io.ReaderAt
+		if r, err = readerAt(appended); err == nil {
+			return &stitchedFile{
+				File:   file,
+				base:   base,
+				tail:   r,
+				closer: appended,
+				info: &appendedInfo{
+					FileInfo: contextual.ExtendFileInfo(info),
+					size:     t.offset + t.size,
+					modTime:  t.modTime,
+				},
+				t: t,
+			}, nil
+		}
+	}
+	_ = appended.Close()
+	return nil, err
+}
+
+// readerAt returns file as an io.ReaderAt, reading it in memory if it does
+// not implement it.
+func readerAt(file fs.File) (io.ReaderAt, error) {
+	if r, ok := file.(io.ReaderAt); ok {
+		return r, nil
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// stitchedFile reads a file of a read-only layer, up to the offset of its
+// tail, followed by the tail.
+type stitchedFile struct {
+	fsx.File
+	base   io.ReaderAt
+	tail   io.ReaderAt
+	closer io.Closer
+	info   fs.FileInfo
+	t      *tail
+
+	mu  sync.Mutex
+	off int64
+}
+
+func (s *stitchedFile) Stat() (fs.FileInfo, error) {
+	return s.info, nil
+}
+
+func (s *stitchedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: s.info.Name(), Err: fs.ErrInvalid}
+	}
+	n := 0
+	if off < s.t.offset {
+		head := p[:min(int64(len(p)), s.t.offset-off)]
+		m, err := s.base.ReadAt(head, off)
+		n += m
+		// The base may report io.EOF along with the whole head.
+		if m < len(head) && err != nil {
+			return n, err
+		}
+	}
+	if n == len(p) {
+		return n, nil
+	}
+	m, err := s.tail.ReadAt(p[n:], s.t.header+off+int64(n)-s.t.offset)
+	return n + m, err
+}
+
+func (s *stitchedFile) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.ReadAt(p, s.off)
+	s.off += int64(n)
+	if n > 0 && errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+func (s *stitchedFile) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.off
+	case io.SeekEnd:
+		offset += s.info.Size()
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: s.info.Name(), Err: fs.ErrInvalid}
+	}
+	s.off = offset
+	return offset, nil
+}
+
+func (s *stitchedFile) Close() error {
+	return errors.Join(s.File.Close(), s.closer.Close())
+}
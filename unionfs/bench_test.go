@@ -0,0 +1,211 @@
+package unionfs_test
+
+// The benchmarks of this file cover the hot paths of the union on every
+// backend of benchBackends, so that changes made for performance can be
+// measured and regressions caught by comparing runs, for example with
+//
+//	go test -run '^$' -bench . -count 10 ./unionfs > new.txt
+//	benchstat old.txt new.txt
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
+	"github.com/gwangyi/fsx/osfs"
+	"github.com/gwangyi/fsx/unionfs"
+)
+
+// benchBackends are the layers the benchmarks run against.
+var benchBackends = []struct {
+	name  string
+	layer func(b *testing.B) contextual.FS
+}{
+	{"memfs", func(*testing.B) contextual.FS { return memfs.New(memfs.Config{}) }},
+	{"osfs", func(b *testing.B) contextual.FS {
+		fsys, err := osfs.New(b.TempDir())
+		if err != nil {
+			b.Fatal(err)
+		}
+		return contextual.ToContextual(fsys)
+	}},
+}
+
+// benchLayer returns a new layer of backend holding a file for each name,
+// with data as its contents.
+func benchLayer(b *testing.B, layer func(*testing.B) contextual.FS, data []byte, names ...string) contextual.FS {
+	b.Helper()
+	ctx := b.Context()
+	fsys := layer(b)
+	if err := contextual.MkdirAll(ctx, fsys, "dir", 0755); err != nil {
+		b.Fatal(err)
+	}
+	for _, name := range names {
+		if err := contextual.WriteFile(ctx, fsys, name, data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return fsys
+}
+
+func BenchmarkStat_Miss(b *testing.B) {
+	ctx := b.Context()
+	for _, backend := range benchBackends {
+		for _, n := range []int{1, 8, 32} {
+			b.Run(fmt.Sprintf("%s/layers=%d", backend.name, n), func(b *testing.B) {
+				ro := make([]contextual.FS, n)
+				for i := range ro {
+					ro[i] = benchLayer(b, backend.layer, nil, fmt.Sprintf("dir/%d", i))
+				}
+				f := unionfs.New(benchLayer(b, backend.layer, nil), ro...)
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := f.Stat(ctx, "dir/missing"); err == nil {
+						b.Fatal("Stat() of a missing file succeeded")
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkReadDir_Merged(b *testing.B) {
+	ctx := b.Context()
+	const layers = 4
+	for _, backend := range benchBackends {
+		for _, n := range []int{100, 1000} {
+			b.Run(fmt.Sprintf("%s/entries=%d", backend.name, n), func(b *testing.B) {
+				// Each entry is in two adjacent layers, so that the listings
+				// of the layers overlap.
+				all := make([][]string, layers)
+				for i := range n {
+					layer := i * (layers - 1) / n
+					name := fmt.Sprintf("dir/%05d", i)
+					all[layer] = append(all[layer], name)
+					all[layer+1] = append(all[layer+1], name)
+				}
+				f := unionfs.New(benchLayer(b, backend.layer, nil, all[0]...),
+					benchLayer(b, backend.layer, nil, all[1]...),
+					benchLayer(b, backend.layer, nil, all[2]...),
+					benchLayer(b, backend.layer, nil, all[3]...))
+				b.ReportAllocs()
+				for b.Loop() {
+					entries, err := f.ReadDir(ctx, "dir")
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(entries) != n {
+						b.Fatalf("ReadDir() returned %d entries; want %d", len(entries), n)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCopyUp(b *testing.B) {
+	ctx := b.Context()
+	ro := memfs.New(memfs.Config{})
+	if err := ro.WriteFile(ctx, "file", bytes.Repeat([]byte("data"), 256<<10), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{4 << 10, contextual.DefaultBufferSize, 256 << 10} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			contextual.SetBufferSize(size)
+			b.Cleanup(func() { contextual.SetBufferSize(0) })
+			rw := memfs.New(memfs.Config{})
+			f := unionfs.New(rw, ro)
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := f.Chmod(ctx, "file", 0600); err != nil {
+					b.Fatal(err)
+				}
+				if err := rw.Remove(ctx, "file"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCopyUp_Size(b *testing.B) {
+	ctx := b.Context()
+	for _, backend := range benchBackends {
+		for _, size := range []int{0, 4 << 10, 1 << 20, 16 << 20} {
+			b.Run(fmt.Sprintf("%s/size=%d", backend.name, size), func(b *testing.B) {
+				ro := benchLayer(b, backend.layer, bytes.Repeat([]byte{'x'}, size), "dir/file")
+				rw := benchLayer(b, backend.layer, nil)
+				f := unionfs.New(rw, ro)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for b.Loop() {
+					if err := f.Chmod(ctx, "dir/file", 0600); err != nil {
+						b.Fatal(err)
+					}
+					if err := contextual.Remove(ctx, rw, "dir/file"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkWhiteouts(b *testing.B) {
+	ctx := b.Context()
+	const n = 1000
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("dir/%05d", i)
+	}
+	for _, backend := range benchBackends {
+		// Every other file of the read-only layer is hidden by a whiteout.
+		newUnion := func(b *testing.B) contextual.FileSystem {
+			f := unionfs.New(benchLayer(b, backend.layer, nil), benchLayer(b, backend.layer, nil, names...))
+			for i := 0; i < n; i += 2 {
+				if err := f.Remove(ctx, names[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			return f
+		}
+		b.Run(backend.name+"/ReadDir", func(b *testing.B) {
+			f := newUnion(b)
+			b.ReportAllocs()
+			for b.Loop() {
+				entries, err := f.ReadDir(ctx, "dir")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(entries) != n/2 {
+					b.Fatalf("ReadDir() returned %d entries; want %d", len(entries), n/2)
+				}
+			}
+		})
+		b.Run(backend.name+"/Stat", func(b *testing.B) {
+			f := newUnion(b)
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := f.Stat(ctx, names[0]); err == nil {
+					b.Fatal("Stat() of a removed file succeeded")
+				}
+			}
+		})
+		b.Run(backend.name+"/Remove", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				b.StopTimer()
+				f := unionfs.New(benchLayer(b, backend.layer, nil), benchLayer(b, backend.layer, nil, names[:100]...))
+				b.StartTimer()
+				for _, name := range names[:100] {
+					if err := f.Remove(ctx, name); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
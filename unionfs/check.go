@@ -0,0 +1,284 @@
+package unionfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// IssueKind is the kind of an anomaly found by Check.
+type IssueKind int
+
+const (
+	// IssueStaleWhiteout is a whiteout hiding a name that no read-only
+	// layer holds. Repairing it removes the whiteout.
+	IssueStaleWhiteout IssueKind = iota
+	// IssueRedundantCopy is a regular file of the read-write layer with the
+	// same mode and contents as the file of the read-only layers it
+	// shadows, wasting the space it takes. Repairing it removes the copy,
+	// which exposes the identical file again.
+	IssueRedundantCopy
+	// IssueControlMode is a whiteout or the tail of an append overlay that
+	// is not a regular file. It is never repaired, since removing it could
+	// lose whatever it holds.
+	IssueControlMode
+	// IssueTypeConflict is a name that is a directory in one layer and
+	// another kind of file in another one, so that the upper file hides the
+	// lower directory without a whiteout. It is never repaired.
+	IssueTypeConflict
+)
+
+// String returns the name of the kind, such as "stale-whiteout".
+func (k IssueKind) String() string {
+	switch k {
+	case IssueStaleWhiteout:
+		return "stale-whiteout"
+	case IssueRedundantCopy:
+		return "redundant-copy"
+	case IssueControlMode:
+		return "control-mode"
+	case IssueTypeConflict:
+		return "type-conflict"
+	default:
+		return "IssueKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Issue is an anomaly of a union found by Check.
+type Issue struct {
+	Kind IssueKind
+	// Name is the name of the file in the union. For whiteouts and tails,
+	// it is the name of the file they apply to.
+	Name string
+	// Fixed reports whether Check repaired the issue, which only happens
+	// with the Repair option.
+	Fixed bool
+}
+
+// String describes the issue, such as "stale-whiteout a (fixed)".
+func (i Issue) String() string {
+	s := i.Kind.String() + " " + i.Name
+	if i.Fixed {
+		s += " (fixed)"
+	}
+	return s
+}
+
+// CheckOption configures Check.
+type CheckOption func(*checkOptions)
+
+// checkOptions holds the settings applied by CheckOption.
+type checkOptions struct {
+	repair bool
+}
+
+// Repair makes Check repair the issues of the kinds it can safely repair,
+// IssueStaleWhiteout and IssueRedundantCopy, which leaves the files seen
+// through the union unchanged.
+func Repair() CheckOption {
+	return func(o *checkOptions) { o.repair = true }
+}
+
+// Check walks the union and returns the anomalies found in it, grouped by
+// kind in this order: stale whiteouts, read-write copies identical to the
+// files they shadow, control files of the wrong type and names whose type
+// differs between layers. Comparing copies reads them whole, so checking a
+// large union takes a while. It should not run concurrently with writes to
+// the union, especially with Repair.
+func Check(ctx context.Context, union contextual.FS, opts ...CheckOption) ([]Issue, error) {
+	f := union.(*filesystem)
+	var o checkOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.repair && f.sealed.Load() {
+		return nil, &fs.PathError{Op: "check", Path: ".", Err: ErrSealed}
+	}
+
+	var issues []Issue
+	var modes []Issue
+	controls := func(prefix string, stale bool) error {
+		return f.walkControls(ctx, ".", prefix, func(name string, e fs.DirEntry) error {
+			if !e.Type().IsRegular() {
+				modes = append(modes, Issue{Kind: IssueControlMode, Name: name})
+				return nil
+			}
+			if !stale || f.inRO(ctx, name) {
+				return nil
+			}
+			issue := Issue{Kind: IssueStaleWhiteout, Name: name}
+			if o.repair {
+				if err := contextual.Remove(ctx, f.meta.store(f.rw), f.meta.whiteout(name)); err != nil {
+					return err
+				}
+				issue.Fixed = true
+			}
+			issues = append(issues, issue)
+			return nil
+		})
+	}
+	if err := controls(whiteoutPrefix, true); err != nil {
+		return nil, internal.Decorate("check", ".", err)
+	}
+	if f.appendOverlay {
+		if err := controls(appendPrefix, false); err != nil {
+			return nil, internal.Decorate("check", ".", err)
+		}
+	}
+
+	var copies, conflicts []Issue
+	if err := f.checkTree(ctx, ".", o, &copies, &conflicts); err != nil {
+		return nil, err
+	}
+	issues = append(issues, copies...)
+	issues = append(issues, modes...)
+	return append(issues, conflicts...), nil
+}
+
+// checkTree looks for redundant copies and type conflicts among the entries
+// of dir in the union and its subdirectories. Names in conflict are not
+// descended into, since their layers cannot be merged.
+func (f *filesystem) checkTree(ctx context.Context, dir string, o checkOptions, copies, conflicts *[]Issue) error {
+	entries, err := f.ReadDir(ctx, dir)
+	if err != nil {
+		return internal.Decorate("check", dir, err)
+	}
+	for _, e := range entries {
+		name := path.Join(dir, e.Name())
+		conflict, err := f.typeConflict(ctx, name)
+		if err != nil {
+			return internal.Decorate("check", name, err)
+		}
+		if conflict {
+			*conflicts = append(*conflicts, Issue{Kind: IssueTypeConflict, Name: name})
+		} else if e.Type().IsRegular() {
+			redundant, err := f.redundantCopy(ctx, name)
+			if err != nil {
+				return internal.Decorate("check", name, err)
+			}
+			if redundant {
+				issue := Issue{Kind: IssueRedundantCopy, Name: name}
+				if o.repair {
+					if err := contextual.Remove(ctx, f.rw, name); err != nil {
+						return internal.Decorate("check", name, err)
+					}
+					issue.Fixed = true
+				}
+				*copies = append(*copies, issue)
+			}
+		}
+		if e.IsDir() && !conflict {
+			if err := f.checkTree(ctx, name, o, copies, conflicts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// typeConflict reports whether name is a directory in one of the layers it
+// is visible in, ignoring the union's own shadowing, and not in another one.
+func (f *filesystem) typeConflict(ctx context.Context, name string) (bool, error) {
+	var dirs, others int
+	count := func(layer contextual.FS) error {
+		info, err := contextual.Lstat(ctx, layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs++
+		} else {
+			others++
+		}
+		return nil
+	}
+	if err := count(f.rw); err != nil {
+		return false, err
+	}
+	if !f.hidden(ctx, name) {
+		v := f.layers()
+		for i, ro := range v.ro {
+			if err := count(ro); err != nil {
+				return false, err
+			}
+			if v.hiddenBelow(ctx, i, name) {
+				break
+			}
+		}
+	}
+	return dirs > 0 && others > 0, nil
+}
+
+// redundantCopy reports whether name is a regular file of the read-write
+// layer with the same mode, contents and, if SetOwnerMapping set a mapping,
+// ownership as the file it shadows.
+func (f *filesystem) redundantCopy(ctx context.Context, name string) (bool, error) {
+	info, err := contextual.Lstat(ctx, f.rw, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return false, err
+	}
+	src, base := f.findRO(ctx, name)
+	if src == nil || base.Mode() != info.Mode() || base.Size() != info.Size() {
+		return false, nil
+	}
+	if t, err := f.readTail(ctx, name); err != nil || t != nil {
+		return false, err
+	}
+	if f.owners != nil {
+		// The copy may have been given the translated ownership.
+		xbase, xinfo := contextual.ExtendFileInfo(base), contextual.ExtendFileInfo(info)
+		owner, group, err := contextual.TranslateOwner(ctx, f.owners.from, f.owners.to, xbase.Owner(), xbase.Group())
+		if err != nil || owner != xinfo.Owner() || group != xinfo.Group() {
+			return false, nil
+		}
+	}
+	return sameContents(ctx, f.rw, src, name)
+}
+
+// sameContents reports whether name holds the same bytes in a and b.
+func sameContents(ctx context.Context, a, b contextual.FS, name string) (bool, error) {
+	fa, err := contextual.Open(ctx, a, name)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = fa.Close() }()
+	fb, err := contextual.Open(ctx, b, name)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = fb.Close() }()
+
+	ba, bb := internal.GetBuffer(), internal.GetBuffer()
+	defer internal.PutBuffer(ba)
+	defer internal.PutBuffer(bb)
+	for {
+		na, errA := io.ReadFull(fa, *ba)
+		nb, errB := io.ReadFull(fb, *bb)
+		if !bytes.Equal((*ba)[:na], (*bb)[:nb]) {
+			return false, nil
+		}
+		doneA := errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF)
+		doneB := errors.Is(errB, io.EOF) || errors.Is(errB, io.ErrUnexpectedEOF)
+		switch {
+		case errA != nil && !doneA:
+			return false, errA
+		case errB != nil && !doneB:
+			return false, errB
+		case doneA || doneB:
+			return doneA == doneB, nil
+		}
+	}
+}
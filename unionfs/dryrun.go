@@ -0,0 +1,581 @@
+package unionfs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+	"github.com/gwangyi/fsx/memfs"
+)
+
+// ChangeKind is the kind of a change planned for the read-write layer.
+type ChangeKind int
+
+const (
+	// ChangeCopyUp copies a file of a read-only layer to the read-write
+	// layer.
+	ChangeCopyUp ChangeKind = iota
+	// ChangeCreate creates a file, directory, symbolic link or special file.
+	ChangeCreate
+	// ChangeWrite writes bytes to a file.
+	ChangeWrite
+	// ChangeModify changes the size, mode, owner or times of a file.
+	ChangeModify
+	// ChangeRemove removes a file or directory.
+	ChangeRemove
+	// ChangeRename renames a file or directory.
+	ChangeRename
+	// ChangeWhiteout creates a whiteout hiding a file of the read-only
+	// layers.
+	ChangeWhiteout
+	// ChangeUnwhiteout removes a whiteout.
+	ChangeUnwhiteout
+)
+
+// String returns the name of the kind, such as "copy-up" or "whiteout".
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeCopyUp:
+		return "copy-up"
+	case ChangeCreate:
+		return "create"
+	case ChangeWrite:
+		return "write"
+	case ChangeModify:
+		return "modify"
+	case ChangeRemove:
+		return "remove"
+	case ChangeRename:
+		return "rename"
+	case ChangeWhiteout:
+		return "whiteout"
+	case ChangeUnwhiteout:
+		return "unwhiteout"
+	default:
+		return "ChangeKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Change is a change planned for the read-write layer.
+type Change struct {
+	Kind ChangeKind
+	// Name is the name of the file in the union. For whiteouts, it is the
+	// name of the hidden file.
+	Name string
+	// NewName is the new name of the file renamed by ChangeRename.
+	NewName string
+	// Bytes is the number of bytes copied by ChangeCopyUp or written by
+	// ChangeWrite.
+	Bytes int64
+}
+
+// String describes the change, such as "copy-up a (5 bytes)".
+func (c Change) String() string {
+	s := c.Kind.String() + " " + c.Name
+	if c.Kind == ChangeRename {
+		s += " -> " + c.NewName
+	}
+	if c.Kind == ChangeCopyUp || c.Kind == ChangeWrite {
+		s += fmt.Sprintf(" (%d bytes)", c.Bytes)
+	}
+	return s
+}
+
+// Plan is the list of changes a mutating call of a dry-run union would make
+// to the read-write layer.
+type Plan struct {
+	// Op is the operation of the call, such as "rename", or "write" for
+	// the bytes written through a file handle, reported when it is closed.
+	Op      string
+	Name    string
+	NewName string
+	Changes []Change
+}
+
+// Bytes returns the number of bytes the changes would add to the read-write
+// layer: the bytes copied up and written.
+func (p Plan) Bytes() int64 {
+	var n int64
+	for _, c := range p.Changes {
+		if c.Kind == ChangeCopyUp || c.Kind == ChangeWrite {
+			n += c.Bytes
+		}
+	}
+	return n
+}
+
+// String describes the plan, such as "rename a -> b: copy-up a (5 bytes),
+// rename a -> b, whiteout a".
+func (p Plan) String() string {
+	s := p.Op + " " + p.Name
+	if p.NewName != "" {
+		s += " -> " + p.NewName
+	}
+	if len(p.Changes) == 0 {
+		return s + ": no changes"
+	}
+	changes := make([]string, len(p.Changes))
+	for i, c := range p.Changes {
+		changes[i] = c.String()
+	}
+	return s + ": " + strings.Join(changes, ", ")
+}
+
+// NewDryRun returns a view of union, a union created by New or any other
+// filesystem, whose mutating calls leave it untouched and report to report
+// the changes they would make to its read-write layer instead. It lets a CI
+// job check that a workload stays read-only, or estimate the space it needs
+// in the read-write layer before enabling writes.
+//
+// The changes are applied to an in-memory scratch layer stacked on top of
+// union, so that later calls observe them like they would without a dry
+// run, but the data written is held in memory. A plan is reported for every
+// successful mutating call, even if it changes nothing, and the bytes
+// written through a file handle are reported in a plan of op "write" when
+// it is closed. Options set on union, such as SetAppendOverlay, do not
+// apply to the view. Closing the view leaves union open.
+func NewDryRun(union contextual.FS, report func(ctx context.Context, p Plan)) contextual.FileSystem {
+	rec := &recorder{
+		PassthroughFS: contextual.PassthroughFS{Inner: memfs.New(memfs.Config{})},
+		report:        report,
+	}
+	return &dryRun{PassthroughFS: contextual.PassthroughFS{Inner: New(rec, union)}, report: report}
+}
+
+// planKey is the context key under which dryRun stores the plan of the
+// running call.
+type planKey struct{}
+
+// pendingPlan is the plan of a running call of a dryRun.
+type pendingPlan struct {
+	mu   sync.Mutex
+	plan Plan
+	done bool
+}
+
+// dryRun runs the mutating calls against a union whose read-write layer is
+// a recorder, and reports their plans.
+type dryRun struct {
+	contextual.PassthroughFS
+	report func(ctx context.Context, p Plan)
+}
+
+// run runs fn, the call op of the union, and reports its plan if it
+// succeeds.
+func (d *dryRun) run(ctx context.Context, op, name, newname string, fn func(ctx context.Context) error) error {
+	p := &pendingPlan{plan: Plan{Op: op, Name: name, NewName: newname}}
+	err := fn(context.WithValue(ctx, planKey{}, p))
+	p.mu.Lock()
+	p.done = true
+	plan := p.plan
+	p.mu.Unlock()
+	if err == nil && d.report != nil {
+		d.report(ctx, plan)
+	}
+	return err
+}
+
+func (d *dryRun) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	if flag&fsx.O_ACCMODE == os.O_RDONLY && flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		return d.PassthroughFS.OpenFile(ctx, name, flag, mode)
+	}
+	var file fsx.File
+	err := d.run(ctx, "open", name, "", func(ctx context.Context) (err error) {
+		file, err = d.PassthroughFS.OpenFile(ctx, name, flag, mode)
+		return err
+	})
+	return file, err
+}
+
+func (d *dryRun) Create(ctx context.Context, name string) (fsx.File, error) {
+	return d.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (d *dryRun) Remove(ctx context.Context, name string) error {
+	return d.run(ctx, "remove", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.Remove(ctx, name)
+	})
+}
+
+func (d *dryRun) RemoveAll(ctx context.Context, name string) error {
+	return d.run(ctx, "removeall", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.RemoveAll(ctx, name)
+	})
+}
+
+func (d *dryRun) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	return d.run(ctx, "mkdir", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.Mkdir(ctx, name, perm)
+	})
+}
+
+func (d *dryRun) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	return d.run(ctx, "mkdir", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.MkdirAll(ctx, name, perm)
+	})
+}
+
+func (d *dryRun) Rename(ctx context.Context, oldname, newname string) error {
+	return d.run(ctx, "rename", oldname, newname, func(ctx context.Context) error {
+		return d.PassthroughFS.Rename(ctx, oldname, newname)
+	})
+}
+
+func (d *dryRun) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	return d.run(ctx, "rename", oldname, newname, func(ctx context.Context) error {
+		return d.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+	})
+}
+
+func (d *dryRun) Symlink(ctx context.Context, oldname, newname string) error {
+	return d.run(ctx, "symlink", newname, "", func(ctx context.Context) error {
+		return d.PassthroughFS.Symlink(ctx, oldname, newname)
+	})
+}
+
+func (d *dryRun) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	return d.run(ctx, "mknod", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+	})
+}
+
+func (d *dryRun) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	return d.run(ctx, "writefile", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.WriteFile(ctx, name, data, perm)
+	})
+}
+
+func (d *dryRun) Truncate(ctx context.Context, name string, size int64) error {
+	return d.run(ctx, "truncate", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.Truncate(ctx, name, size)
+	})
+}
+
+func (d *dryRun) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	return d.run(ctx, "chmod", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.Chmod(ctx, name, mode)
+	})
+}
+
+func (d *dryRun) Chown(ctx context.Context, name, owner, group string) error {
+	return d.run(ctx, "chown", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.Chown(ctx, name, owner, group)
+	})
+}
+
+func (d *dryRun) Lchown(ctx context.Context, name, owner, group string) error {
+	return d.run(ctx, "lchown", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.Lchown(ctx, name, owner, group)
+	})
+}
+
+func (d *dryRun) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	return d.run(ctx, "chtimes", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.Chtimes(ctx, name, atime, mtime)
+	})
+}
+
+func (d *dryRun) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	return d.run(ctx, "setxattr", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.SetXattr(ctx, name, attr, value, flags)
+	})
+}
+
+func (d *dryRun) RemoveXattr(ctx context.Context, name, attr string) error {
+	return d.run(ctx, "removexattr", name, "", func(ctx context.Context) error {
+		return d.PassthroughFS.RemoveXattr(ctx, name, attr)
+	})
+}
+
+// Close does nothing: the scratch layer only holds memory, and the union
+// the view was created for is left open.
+func (d *dryRun) Close() error {
+	return nil
+}
+
+// copyUpKey is the context key under which copyToRW stores the name of the
+// file it copies, so that a recorder tells copy-ups from other writes.
+type copyUpKey struct{}
+
+// recorder is the scratch read-write layer of a dryRun. It records the
+// changes made to it in the plan of the running call.
+type recorder struct {
+	contextual.PassthroughFS
+	report func(ctx context.Context, p Plan)
+}
+
+// record adds c to the plan carried by ctx.
+func (r *recorder) record(ctx context.Context, c Change) {
+	p, _ := ctx.Value(planKey{}).(*pendingPlan)
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.plan.Changes = append(p.plan.Changes, c)
+}
+
+// copyingUp reports whether ctx is the context of the copy-up of name, or
+// of the file name is the scratch file of.
+func copyingUp(ctx context.Context, name string) bool {
+	n, ok := ctx.Value(copyUpKey{}).(string)
+	return ok && (n == name || isScratch(name))
+}
+
+// exists reports whether name exists in the scratch layer.
+func (r *recorder) exists(ctx context.Context, name string) bool {
+	_, err := contextual.Lstat(ctx, r.Inner, name)
+	return err == nil
+}
+
+// control returns the name hidden by name if it is a whiteout.
+func control(name string) (string, bool) {
+	dir, file := contextual.SplitDirFile(name)
+	hidden, ok := strings.CutPrefix(file, whiteoutPrefix)
+	return path.Join(dir, hidden), ok
+}
+
+// create records the creation of name, unless it existed, as a copy-up if
+// ctx is the context of its copy-up.
+func (r *recorder) create(ctx context.Context, name string, existed bool, err error) {
+	switch {
+	case err != nil || existed:
+	case copyingUp(ctx, name):
+		r.record(ctx, Change{Kind: ChangeCopyUp, Name: name})
+	default:
+		r.record(ctx, Change{Kind: ChangeCreate, Name: name})
+	}
+}
+
+// modify records a change of name, unless it belongs to a copy-up.
+func (r *recorder) modify(ctx context.Context, kind ChangeKind, name string, err error) {
+	if err == nil && ctx.Value(copyUpKey{}) == nil {
+		r.record(ctx, Change{Kind: kind, Name: name})
+	}
+}
+
+func (r *recorder) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	write := flag&fsx.O_ACCMODE != os.O_RDONLY || flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	if !write {
+		return r.PassthroughFS.OpenFile(ctx, name, flag, mode)
+	}
+	existed := r.exists(ctx, name)
+	file, err := r.PassthroughFS.OpenFile(ctx, name, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	kind, recorded := ChangeWrite, name
+	switch {
+	case copyingUp(ctx, name):
+		// The copy-up is recorded with its size once the copy is closed,
+		// under the name of the file rather than of its scratch file.
+		kind, recorded = ChangeCopyUp, ctx.Value(copyUpKey{}).(string)
+	case !existed:
+		r.record(ctx, Change{Kind: ChangeCreate, Name: name})
+	case flag&os.O_TRUNC != 0:
+		r.record(ctx, Change{Kind: ChangeModify, Name: name})
+	}
+	return internal.WrapFile(&recordedFile{File: file, r: r, ctx: contextual.IOContext(ctx), name: recorded, kind: kind}, file), nil
+}
+
+func (r *recorder) Create(ctx context.Context, name string) (fsx.File, error) {
+	return r.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (r *recorder) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	existed := r.exists(ctx, name)
+	err := r.PassthroughFS.WriteFile(ctx, name, data, perm)
+	if err != nil {
+		return err
+	}
+	if hidden, ok := control(name); ok {
+		r.record(ctx, Change{Kind: ChangeWhiteout, Name: hidden})
+		return nil
+	}
+	if !existed {
+		r.record(ctx, Change{Kind: ChangeCreate, Name: name})
+	}
+	r.record(ctx, Change{Kind: ChangeWrite, Name: name, Bytes: int64(len(data))})
+	return nil
+}
+
+func (r *recorder) Remove(ctx context.Context, name string) error {
+	err := r.PassthroughFS.Remove(ctx, name)
+	if hidden, ok := control(name); ok {
+		if err == nil {
+			r.record(ctx, Change{Kind: ChangeUnwhiteout, Name: hidden})
+		}
+		return err
+	}
+	r.modify(ctx, ChangeRemove, name, err)
+	return err
+}
+
+func (r *recorder) RemoveAll(ctx context.Context, name string) error {
+	existed := r.exists(ctx, name)
+	err := r.PassthroughFS.RemoveAll(ctx, name)
+	if existed {
+		r.modify(ctx, ChangeRemove, name, err)
+	}
+	return err
+}
+
+func (r *recorder) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	err := r.PassthroughFS.Mkdir(ctx, name, perm)
+	r.create(ctx, name, false, err)
+	return err
+}
+
+func (r *recorder) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	// createWhiteout passes the parent of a whiteout with a trailing slash.
+	name = path.Clean(name)
+	// Record the directories missing from the scratch layer, from the top.
+	var missing []string
+	for dir := name; dir != "." && !r.exists(ctx, dir); dir = path.Dir(dir) {
+		missing = append(missing, dir)
+	}
+	err := r.PassthroughFS.MkdirAll(ctx, name, perm)
+	for i := len(missing) - 1; i >= 0; i-- {
+		r.create(ctx, missing[i], false, err)
+	}
+	return err
+}
+
+func (r *recorder) Rename(ctx context.Context, oldname, newname string) error {
+	err := r.PassthroughFS.Rename(ctx, oldname, newname)
+	// Moving the scratch file of a copy-up into place is part of it.
+	if err == nil && !copyingUp(ctx, oldname) {
+		r.record(ctx, Change{Kind: ChangeRename, Name: oldname, NewName: newname})
+	}
+	return err
+}
+
+func (r *recorder) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	err := r.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+	if err == nil && !copyingUp(ctx, oldname) {
+		r.record(ctx, Change{Kind: ChangeRename, Name: oldname, NewName: newname})
+	}
+	return err
+}
+
+func (r *recorder) Symlink(ctx context.Context, oldname, newname string) error {
+	err := r.PassthroughFS.Symlink(ctx, oldname, newname)
+	r.create(ctx, newname, false, err)
+	return err
+}
+
+func (r *recorder) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	err := r.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+	r.create(ctx, name, false, err)
+	return err
+}
+
+func (r *recorder) Truncate(ctx context.Context, name string, size int64) error {
+	err := r.PassthroughFS.Truncate(ctx, name, size)
+	r.modify(ctx, ChangeModify, name, err)
+	return err
+}
+
+func (r *recorder) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	err := r.PassthroughFS.Chmod(ctx, name, mode)
+	r.modify(ctx, ChangeModify, name, err)
+	return err
+}
+
+func (r *recorder) Chown(ctx context.Context, name, owner, group string) error {
+	err := r.PassthroughFS.Chown(ctx, name, owner, group)
+	r.modify(ctx, ChangeModify, name, err)
+	return err
+}
+
+func (r *recorder) Lchown(ctx context.Context, name, owner, group string) error {
+	err := r.PassthroughFS.Lchown(ctx, name, owner, group)
+	r.modify(ctx, ChangeModify, name, err)
+	return err
+}
+
+func (r *recorder) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	err := r.PassthroughFS.Chtimes(ctx, name, atime, mtime)
+	r.modify(ctx, ChangeModify, name, err)
+	return err
+}
+
+func (r *recorder) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	err := r.PassthroughFS.SetXattr(ctx, name, attr, value, flags)
+	r.modify(ctx, ChangeModify, name, err)
+	return err
+}
+
+func (r *recorder) RemoveXattr(ctx context.Context, name, attr string) error {
+	err := r.PassthroughFS.RemoveXattr(ctx, name, attr)
+	r.modify(ctx, ChangeModify, name, err)
+	return err
+}
+
+// recordedFile counts the bytes written to a file of the scratch layer. On
+// Close, they are added to the plan of the call that opened it if it is
+// still running, as for copy-ups, or reported in a plan of their own.
+type recordedFile struct {
+	fsx.File
+	r       *recorder
+	ctx     context.Context
+	name    string
+	kind    ChangeKind
+	written atomic.Int64
+}
+
+func (f *recordedFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.written.Add(int64(n))
+	return n, err
+}
+
+func (f *recordedFile) Truncate(size int64) error {
+	err := f.File.Truncate(size)
+	if err == nil && f.kind == ChangeWrite {
+		f.report(Change{Kind: ChangeModify, Name: f.name})
+	}
+	return err
+}
+
+func (f *recordedFile) Restat() (fs.FileInfo, error) {
+	return internal.Restat(f.File)
+}
+
+func (f *recordedFile) Close() error {
+	err := f.File.Close()
+	if n := f.written.Swap(0); n > 0 || f.kind == ChangeCopyUp {
+		f.report(Change{Kind: f.kind, Name: f.name, Bytes: n})
+	}
+	return err
+}
+
+// report adds c to the plan of the call that opened the file if it is still
+// running, or reports it in a plan of op "write" otherwise.
+func (f *recordedFile) report(c Change) {
+	if p, _ := f.ctx.Value(planKey{}).(*pendingPlan); p != nil {
+		p.mu.Lock()
+		if !p.done {
+			p.plan.Changes = append(p.plan.Changes, c)
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+	}
+	if f.r.report != nil {
+		f.r.report(contextual.IOContext(f.ctx), Plan{Op: "write", Name: f.name, Changes: []Change{c}})
+	}
+}
+
+var _ contextual.FileSystem = &dryRun{}
+var _ contextual.SpecialFS = &dryRun{}
@@ -0,0 +1,205 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// ErrReadOnlyDegraded is returned, wrapped in an *fs.PathError or
+// *os.LinkError, by write operations of a union that switched to read-only
+// semantics because its read-write layer ran out of space. It is reported as
+// fsx.ErrReadOnly too.
+var ErrReadOnlyDegraded = contextual.TranslateError(errors.New("read-write layer is full; union degraded to read-only"), fsx.ErrReadOnly)
+
+// FullPolicy decides how the union reacts when its read-write layer reports
+// that it is out of space (syscall.ENOSPC) while copying up or creating a
+// file.
+type FullPolicy int
+
+const (
+	// FullFail returns the error of the read-write layer. It is the default.
+	FullFail FullPolicy = iota
+	// FullReadOnly fails the operation with ErrReadOnlyDegraded and makes
+	// every later write fail the same way, without touching the read-write
+	// layer, until SetFullPolicy is called again. Reads keep working, but
+	// copy-on-read is skipped.
+	FullReadOnly
+	// FullSpill switches writes to the layer set with SetSpillLayer and
+	// retries the operation there. The full read-write layer stays visible
+	// beneath the spill layer. It behaves like FullFail if no spill layer
+	// is set.
+	FullSpill
+)
+
+// SetFullPolicy sets how the union reacts when its read-write layer is full,
+// and clears a previous degradation to read-only.
+func SetFullPolicy(fs contextual.FS, policy FullPolicy) {
+	f := fs.(*filesystem)
+	f.fullPolicy = policy
+	f.degraded.Store(false)
+}
+
+// SetSpillLayer sets the layer writes are routed to once the read-write layer
+// is full, and selects FullSpill. It must be called before the union is used
+// and at most once.
+//
+// Until the read-write layer is full, the spill layer is not used. From then
+// on, it takes the place of the read-write layer, and the full layer is
+// searched right beneath it, with its whiteouts still hiding the files of the
+// read-only layers.
+func SetSpillLayer(fs contextual.FS, spill contextual.FS) {
+	f := fs.(*filesystem)
+	f.spilled = new(atomic.Bool)
+	primary := f.rw
+	f.rw = &spillover{primary: primary, spill: spill, spilled: f.spilled}
+	f.ro = append([]contextual.FS{&spillover{primary: primary, spilled: f.spilled, lower: true}}, f.ro...)
+	// Whiteouts kept in the read-write layer move to the spill layer with it,
+	// while those of a dedicated store stay there.
+	var meta *metadata
+	if f.meta.fsys == nil {
+		meta = &metadata{dir: f.meta.dir}
+	}
+	f.whiteouts = append([]*metadata{meta}, f.whiteouts...)
+	f.pinned++
+	f.fullPolicy = FullSpill
+}
+
+// Degraded reports whether the union switched to read-only semantics because
+// its read-write layer is full.
+func Degraded(fs contextual.FS) bool {
+	return fs.(*filesystem).degraded.Load()
+}
+
+// isNoSpace reports whether err tells that a layer is out of space.
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// pathErr returns a function decorating an error as an *fs.PathError.
+func pathErr(op, name string) func(error) error {
+	return func(err error) error {
+		return internal.Decorate(op, name, err)
+	}
+}
+
+// linkErr returns a function decorating an error as an *os.LinkError.
+func linkErr(op, oldname, newname string) func(error) error {
+	return func(err error) error {
+		return internal.DecorateLink(op, oldname, newname, err)
+	}
+}
+
+// write runs fn, an operation writing to the read-write layer, applying the
+// full policy if it runs out of space. wrap decorates the errors returned.
+func (f *filesystem) write(wrap func(error) error, fn func() error) error {
+	if f.sealed.Load() {
+		return wrap(ErrSealed)
+	}
+	if f.degraded.Load() {
+		return wrap(ErrReadOnlyDegraded)
+	}
+	spilled := f.spilled != nil && f.spilled.Load()
+	err := fn()
+	if !isNoSpace(err) {
+		return wrap(err)
+	}
+
+	switch f.fullPolicy {
+	case FullReadOnly:
+		f.degraded.Store(true)
+		return wrap(ErrReadOnlyDegraded)
+	case FullSpill:
+		// Retry once if the failure came from the primary read-write layer.
+		if f.spilled != nil && !spilled {
+			f.spilled.Store(true)
+			return wrap(fn())
+		}
+	}
+	return wrap(err)
+}
+
+// tryCopyOnRead runs copyUp, which copies name to the read-write layer for
+// copy-on-read. It reports whether the file was copied; running out of space
+// is not an error, since the file can still be served from its read-only
+// layer.
+func (f *filesystem) tryCopyOnRead(name string, copyUp func() error) (bool, error) {
+	err := f.write(pathErr("open", name), copyUp)
+	if isNoSpace(err) || errors.Is(err, ErrReadOnlyDegraded) || errors.Is(err, ErrSealed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//go:generate go run ../internal/gendelegate -spec ../contextual/delegate.go -type "s *spillover" -layer s.target() -out spillover_gen.go
+
+// spillover stands for a read-write layer backed by a spill layer. The upper
+// side takes the place of the read-write layer of the union and forwards to
+// the primary layer until it is full, then to the spill layer. The lower side
+// is the first read-only layer of the union: it is empty until the primary
+// layer is full, and then forwards to it. The methods forwarding to target
+// are generated into spillover_gen.go.
+type spillover struct {
+	primary, spill contextual.FS
+	spilled        *atomic.Bool
+	lower          bool
+}
+
+// target returns the layer to forward to.
+func (s *spillover) target() contextual.FS {
+	spilled := s.spilled.Load()
+	switch {
+	case s.lower && spilled:
+		return s.primary
+	case s.lower:
+		return empty{}
+	case spilled:
+		return s.spill
+	default:
+		return s.primary
+	}
+}
+
+// empty is a layer holding no file. The helpers of the contextual package
+// fall back to Open for the lookups, and fail for the changes.
+type empty struct{}
+
+// Open fails with fs.ErrNotExist.
+func (empty) Open(ctx context.Context, name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Open opens the named file for reading.
+func (s *spillover) Open(ctx context.Context, name string) (fs.File, error) {
+	return s.target().Open(ctx, name)
+}
+
+// ReadDirInfos reads the named directory along with the FileInfo of its
+// entries.
+func (s *spillover) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	return contextual.ReadDirInfos(ctx, s.target(), name)
+}
+
+// Close closes the primary and spill layers. The lower side closes nothing,
+// since the layers are owned by the upper side.
+func (s *spillover) Close() error {
+	if s.lower {
+		return nil
+	}
+	return errors.Join(contextual.Close(s.primary), contextual.Close(s.spill))
+}
+
+var _ contextual.FileSystem = &spillover{}
+var _ contextual.CloserFS = &spillover{}
+var _ contextual.ReadDirInfoFS = &spillover{}
+var _ contextual.SpecialFS = &spillover{}
+var _ contextual.RenameOptionsFS = &spillover{}
+var _ contextual.AccessFS = &spillover{}
+var _ contextual.XattrFS = &spillover{}
+var _ contextual.GlobFS = &spillover{}
@@ -0,0 +1,193 @@
+package unionfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// SetHandleContinuity enables or disables handle continuity.
+//
+// Without it, a file opened for reading from a read-only layer keeps reading
+// that layer after the file is copied up to the read-write layer, while new
+// readers see the copy and the changes made to it. When enabled, such handles
+// follow the copy-up: the next read after it reopens the file in the
+// read-write layer and resumes at the same offset, so that every handle sees
+// the same file.
+//
+// Only regular files whose handles can seek are followed. If the copy cannot
+// be opened, for instance because it was renamed or removed in the meantime,
+// the handle keeps reading the read-only layer. Handles opened before the
+// option is enabled are not followed.
+func SetHandleContinuity(fs contextual.FS, enabled bool) {
+	f := fs.(*filesystem)
+	if enabled {
+		f.handles = &handleSet{open: make(map[string]map[*continuousFile]struct{})}
+	} else {
+		f.handles = nil
+	}
+}
+
+// handleSet tracks the open handles of files of read-only layers.
+type handleSet struct {
+	mu   sync.Mutex
+	open map[string]map[*continuousFile]struct{}
+}
+
+func (h *handleSet) add(c *continuousFile) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	set, ok := h.open[c.name]
+	if !ok {
+		set = make(map[*continuousFile]struct{})
+		h.open[c.name] = set
+	}
+	set[c] = struct{}{}
+}
+
+func (h *handleSet) remove(c *continuousFile) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.open[c.name], c)
+	if len(h.open[c.name]) == 0 {
+		delete(h.open, c.name)
+	}
+}
+
+// copiedUp tells the handles of name that it was copied to the read-write
+// layer.
+func (h *handleSet) copiedUp(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.open[name] {
+		c.moved.Store(true)
+	}
+}
+
+// copiedUp records that name was copied to the read-write layer, so that the
+// handles following it switch to the copy.
+func (f *filesystem) copiedUp(name string) {
+	if h := f.handles; h != nil {
+		h.copiedUp(name)
+	}
+}
+
+// follow returns file, opened with flag from a read-only layer, as a handle
+// following copy-ups of name if handle continuity is enabled and file is a
+// regular file that can seek.
+func (f *filesystem) follow(ctx context.Context, name string, flag int, file fsx.File) fsx.File {
+	h := f.handles
+	if h == nil {
+		return file
+	}
+	if _, ok := file.(io.Seeker); !ok {
+		return file
+	}
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		return file
+	}
+	c := &continuousFile{rw: f.rw, handles: h, ctx: contextual.IOContext(f.unlocked(ctx)), name: name, flag: flag, file: file}
+	h.add(c)
+	return c
+}
+
+// continuousFile is a handle of a regular file opened from a read-only layer
+// that switches to the copy of the file in the read-write layer once it is
+// copied up.
+type continuousFile struct {
+	rw      contextual.FS
+	handles *handleSet
+	// ctx is the context of the Open call. fs.File has no context
+	// parameter, so it is used when the copy is opened.
+	ctx  context.Context
+	name string
+	flag int
+	// moved is set by copy-ups of name.
+	moved atomic.Bool
+
+	mu   sync.Mutex
+	file fsx.File
+}
+
+// current returns the file to use, switching to the copy in the read-write
+// layer if name was copied up. It must be called with c.mu held.
+func (c *continuousFile) current() fsx.File {
+	if !c.moved.Swap(false) {
+		return c.file
+	}
+	offset, err := c.file.(io.Seeker).Seek(0, io.SeekCurrent)
+	if err != nil {
+		return c.file
+	}
+	file, err := contextual.OpenFile(c.ctx, c.rw, c.name, reopenFlag(c.flag), 0)
+	if err != nil {
+		return c.file
+	}
+	if s, ok := file.(io.Seeker); !ok {
+		_ = file.Close()
+		return c.file
+	} else if _, err := s.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return c.file
+	}
+	_ = c.file.Close()
+	c.file = file
+	return file
+}
+
+func (c *continuousFile) Stat() (fs.FileInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current().Stat()
+}
+
+func (c *continuousFile) Restat() (fs.FileInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fsx.Restat(c.current())
+}
+
+func (c *continuousFile) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current().Read(p)
+}
+
+func (c *continuousFile) ReadAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.current().(io.ReaderAt); ok {
+		return r.ReadAt(p, off)
+	}
+	return 0, &fs.PathError{Op: "read", Path: c.name, Err: fsx.ErrBadFileDescriptor}
+}
+
+func (c *continuousFile) Seek(offset int64, whence int) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current().(io.Seeker).Seek(offset, whence)
+}
+
+func (c *continuousFile) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current().Write(p)
+}
+
+func (c *continuousFile) Truncate(size int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current().Truncate(size)
+}
+
+func (c *continuousFile) Close() error {
+	c.handles.remove(c)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
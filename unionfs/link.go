@@ -0,0 +1,167 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// ErrUnsafeLink is returned, wrapped in an *fs.PathError, by ReadLink under
+// LinkReject for a symbolic link whose destination lies outside the union or
+// is hidden by a whiteout.
+var ErrUnsafeLink = errors.New("link destination escapes the union or is hidden")
+
+// LinkPolicy decides what ReadLink returns for symbolic links whose
+// destination does not stay within the view of the union. Links stored in a
+// read-only layer were made for the tree they came from: an absolute
+// destination, or one climbing above the root with "..", refers to files
+// outside the union, and a destination may name a file deleted from the
+// union while still present in a lower layer.
+//
+// Destinations are resolved lexically, from the directory of the link,
+// without following the links they pass through. The policy applies to the
+// results of ReadLink, which callers resolving links through the union rely
+// on. Stat and Open follow links within the layer that holds them.
+type LinkPolicy int
+
+const (
+	// LinkPassthrough returns destinations as they are stored. It is the
+	// default.
+	LinkPassthrough LinkPolicy = iota
+	// LinkRebase rewrites destinations escaping the union as if the union
+	// were the root of the filesystem: absolute destinations start at the
+	// root of the union, and ".." stops there. The rewritten destination is
+	// relative to the directory of the link. Destinations hidden by a
+	// whiteout are returned as they are stored, as they resolve to nothing.
+	LinkRebase
+	// LinkReject fails ReadLink with ErrUnsafeLink for destinations escaping
+	// the union or hidden by a whiteout of the read-write layer.
+	LinkReject
+)
+
+// maxLinks bounds the symbolic links followed while resolving a name through
+// the union, like the limit of the kernel that makes it fail with ELOOP.
+const maxLinks = 40
+
+// SetLinkPolicy sets how ReadLink treats destinations of symbolic links that
+// escape the union or are hidden by a whiteout.
+func SetLinkPolicy(fs contextual.FS, policy LinkPolicy) {
+	fs.(*filesystem).linkPolicy = policy
+}
+
+// checkLink applies the link policy to target, the destination of the link
+// name, and returns the destination ReadLink reports.
+func (f *filesystem) checkLink(ctx context.Context, name, target string) (string, error) {
+	if f.linkPolicy == LinkPassthrough {
+		return target, nil
+	}
+	resolved, escapes := resolveLink(name, target)
+	switch {
+	case f.linkPolicy == LinkRebase && escapes:
+		return relativeLink(path.Dir(name), resolved), nil
+	case f.linkPolicy == LinkReject && (escapes || f.hidden(ctx, resolved)):
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: ErrUnsafeLink}
+	}
+	return target, nil
+}
+
+// resolveLink returns the name in the union that target, the destination of
+// the link name, refers to, with ".." stopping at the root, and whether
+// target escapes the union.
+func resolveLink(name, target string) (string, bool) {
+	var resolved string
+	escapes := path.IsAbs(target)
+	if escapes {
+		resolved = strings.TrimPrefix(path.Clean(target), "/")
+	} else {
+		resolved = path.Join(path.Dir(name), target)
+	}
+	for resolved == ".." || strings.HasPrefix(resolved, "../") {
+		escapes = true
+		resolved = strings.TrimPrefix(strings.TrimPrefix(resolved, ".."), "/")
+	}
+	if resolved == "" {
+		resolved = "."
+	}
+	return resolved, escapes
+}
+
+// relativeLink returns the destination of a link in dir referring to name.
+func relativeLink(dir, name string) string {
+	from := splitName(dir)
+	to := splitName(name)
+	for len(from) > 0 && len(to) > 0 && from[0] == to[0] {
+		from, to = from[1:], to[1:]
+	}
+	parts := make([]string, 0, len(from)+len(to))
+	for range from {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, to...)
+	if len(parts) == 0 {
+		return "."
+	}
+	return path.Join(parts...)
+}
+
+// splitName returns the elements of a valid name, none for ".".
+func splitName(name string) []string {
+	if name == "." {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// linkChain returns the names of the files met following the symbolic
+// links from name through the union, name excluded: the links, then the
+// file they lead to. It stops at the first name that cannot be read.
+func (f *filesystem) linkChain(ctx context.Context, name string) []string {
+	var chain []string
+	for range maxLinks {
+		info, err := f.Lstat(ctx, name)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			break
+		}
+		target, err := f.ReadLink(ctx, name)
+		if err != nil {
+			break
+		}
+		name, _ = resolveLink(name, target)
+		chain = append(chain, name)
+	}
+	return chain
+}
+
+// followLink returns the name in the union of the destination target of the
+// link name, that operations following links change. It fails with
+// ErrUnsafeLink if the destination escapes the union, unless the link policy
+// rebases it, or if it is hidden by a whiteout under LinkReject. Under the
+// other policies, a hidden destination does not exist.
+func (f *filesystem) followLink(ctx context.Context, name, target string) (string, error) {
+	resolved, escapes := resolveLink(name, target)
+	if escapes && f.linkPolicy != LinkRebase {
+		return "", ErrUnsafeLink
+	}
+	if f.hidden(ctx, resolved) {
+		if f.linkPolicy == LinkReject {
+			return "", ErrUnsafeLink
+		}
+		return resolved, fs.ErrNotExist
+	}
+	return resolved, nil
+}
+
+// hidden reports whether name or one of its parents is hidden by a whiteout
+// of the read-write layer.
+func (f *filesystem) hidden(ctx context.Context, name string) bool {
+	for p := name; p != "."; p = path.Dir(p) {
+		if f.isWhiteout(ctx, p) {
+			return true
+		}
+	}
+	return false
+}
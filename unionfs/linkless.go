@@ -0,0 +1,173 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// ErrNoLinks is returned, wrapped in an *fs.PathError or *os.LinkError, by
+// the operations that would create a symbolic link in a read-write layer
+// that cannot hold them, and by SetLinklessPolicy. It is not reported as
+// errors.ErrUnsupported, which would make the helpers of contextual fall
+// back to other operations.
+var ErrNoLinks = errors.New("read-write layer does not support symbolic links")
+
+// LinklessPolicy decides how a union deals with symbolic links when its
+// read-write layer cannot hold them. New detects a layer that does not
+// implement contextual.SymlinkFS, such as a minimal contextual.WriterFS, so
+// that such a union does not fail halfway through an operation, for
+// instance after creating the parents of a link it copies up. A layer
+// forwarding to one that cannot hold links, which implements SymlinkFS, is
+// found out by the first link it fails to create with
+// errors.ErrUnsupported; that link is then dealt with by the policy, like
+// all the following ones. The links of the read-only layers can be read
+// through the union under either policy.
+type LinklessPolicy int
+
+const (
+	// LinklessReject fails Symlink, and the copy-ups of links of the
+	// read-only layers, with ErrNoLinks before anything is changed in the
+	// read-write layer. It is the default.
+	LinklessReject LinklessPolicy = iota
+	// LinklessCopy stores a copy of the file a link refers to in place of
+	// the link: a copy-up of a link copies the file its destination resolves
+	// to in the union, and Symlink copies the file oldname resolves to as
+	// newname. Destinations outside the union, and for Symlink directories,
+	// which would have to be copied whole, still fail with ErrNoLinks. The
+	// copies do not follow later changes to the files they were made from.
+	LinklessCopy
+)
+
+// SetLinklessPolicy sets how the union deals with symbolic links if its
+// read-write layer cannot hold them. Selecting LinklessReject for such a
+// union returns ErrNoLinks, so that callers requiring links can refuse it
+// right after New:
+//
+//	union := unionfs.New(rw, ro)
+//	if err := unionfs.SetLinklessPolicy(union, unionfs.LinklessReject); err != nil {
+//		return err
+//	}
+func SetLinklessPolicy(fs contextual.FS, policy LinklessPolicy) error {
+	f := fs.(*filesystem)
+	f.linklessPolicy = policy
+	if policy == LinklessReject && f.noLinks.Load() {
+		return ErrNoLinks
+	}
+	return nil
+}
+
+// holdsLinks reports whether fsys may hold symbolic links, as far as its
+// type tells: a layer forwarding to one that cannot still implements
+// contextual.SymlinkFS, which linksUnsupported finds out.
+func holdsLinks(fsys contextual.FS) bool {
+	_, ok := fsys.(contextual.SymlinkFS)
+	return ok
+}
+
+// linksUnsupported reports whether err, returned by a Symlink of the
+// read-write layer, tells that the layer cannot hold symbolic links after
+// all. The union then deals with links as set with SetLinklessPolicy.
+func (f *filesystem) linksUnsupported(err error) bool {
+	if !errors.Is(err, errors.ErrUnsupported) {
+		return false
+	}
+	f.noLinks.Store(true)
+	return true
+}
+
+// copyLinkUp copies name, a symbolic link in the layer src, to the
+// read-write layer as a copy of the file the link refers to, following the
+// linkless policy.
+func (f *filesystem) copyLinkUp(ctx context.Context, src contextual.FS, name string) error {
+	if f.linklessPolicy != LinklessCopy {
+		return ErrNoLinks
+	}
+	target, err := contextual.ReadLink(ctx, src, name)
+	if err != nil {
+		return err
+	}
+	resolved, escapes := resolveLink(name, target)
+	if escapes {
+		return ErrNoLinks
+	}
+	if parent := path.Dir(name); parent != "." {
+		if err := contextual.MkdirAll(ctx, f.rw, parent, 0755); err != nil {
+			return err
+		}
+	}
+	if err := f.copyLinked(ctx, resolved, name, true); err != nil {
+		return err
+	}
+	f.removeWhiteout(ctx, "copyup", name)
+	f.copiedUp(name)
+	return nil
+}
+
+// symlinkLinkless is Symlink for a read-write layer that cannot hold links.
+func (f *filesystem) symlinkLinkless(ctx context.Context, oldname, newname string) error {
+	wrap := linkErr("symlink", oldname, newname)
+	if f.linklessPolicy != LinklessCopy {
+		return wrap(ErrNoLinks)
+	}
+	resolved, escapes := resolveLink(newname, oldname)
+	if escapes {
+		return wrap(ErrNoLinks)
+	}
+	ctx, unlock := f.lock(ctx, true, newname, resolved)
+	defer unlock()
+	defer f.changes.changed(newname)
+
+	if err := f.write(wrap, func() error {
+		return f.copyLinked(ctx, resolved, newname, false)
+	}); err != nil {
+		return err
+	}
+	f.removeWhiteout(ctx, "symlink", newname)
+	return nil
+}
+
+// copyLinked creates name in the read-write layer as a copy of target, the
+// file a link would refer to, read through the union. Directories are only
+// created, and only if dirs is set, as their entries are still found below
+// the link in the read-only layers.
+func (f *filesystem) copyLinked(ctx context.Context, target, name string, dirs bool) error {
+	info, err := f.Stat(ctx, target)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if !dirs {
+			return ErrNoLinks
+		}
+		return contextual.Mkdir(ctx, f.rw, name, info.Mode().Perm())
+	}
+	out, err := contextual.OpenFile(ctx, f.rw, name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	n, err := contextual.ReadFileInto(ctx, f, target, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = contextual.Remove(ctx, f.rw, name)
+		return err
+	}
+	f.counters.copiedBytes(n)
+	return nil
+}
+
+// readLinkless is ReadLink of the read-write layer for a layer that cannot
+// hold links: a file it holds is not a link, and others are looked up in
+// the read-only layers.
+func (f *filesystem) readLinkless(ctx context.Context, name string) error {
+	if _, err := contextual.Lstat(ctx, f.rw, name); err != nil {
+		return err
+	}
+	return syscall.EINVAL
+}
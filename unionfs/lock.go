@@ -0,0 +1,134 @@
+package unionfs
+
+import (
+	"context"
+	"hash/fnv"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// lockStripes is the number of locks the paths are spread over in strict
+// consistency mode. Paths sharing a lock are serialized together, which is
+// harmless.
+const lockStripes = 64
+
+// pathLocks serializes the operations on the same path.
+type pathLocks [lockStripes]sync.RWMutex
+
+// lockedKey is the context key marking the calls made while an operation of
+// the union f holds its locks, so that the methods it calls internally do not
+// lock again. Other unions, such as those it is layered on, still lock.
+type lockedKey struct{ f *filesystem }
+
+// SetStrictConsistency enables or disables strict consistency mode.
+//
+// Without it, concurrent operations on the same path, such as an OpenFile for
+// writing and a Remove, may interleave their copy-up, whiteout creation and
+// writes to the read-write layer, and leave the union inconsistent. When
+// enabled, operations are serialized per path: operations that change a path,
+// and opens that may copy it up, are exclusive, while other reads of the
+// path run concurrently with each other. Rename locks both of its names.
+//
+// Locks are held for the duration of a call only, not for the lifetime of
+// the returned files, and a path is not locked by operations on its parent
+// or children. Operations on unrelated paths may occasionally wait for each
+// other, since paths are spread over a fixed number of locks.
+func SetStrictConsistency(fs contextual.FS, enabled bool) {
+	f := fs.(*filesystem)
+	if enabled {
+		f.locks = new(pathLocks)
+	} else {
+		f.locks = nil
+	}
+}
+
+// lock locks the given names, exclusively if exclusive is set, in strict
+// consistency mode. It returns the context to use for the operation and the
+// function releasing the locks. It does nothing if ctx comes from an
+// operation already holding its locks, or once the union is sealed.
+func (f *filesystem) lock(ctx context.Context, exclusive bool, names ...string) (context.Context, func()) {
+	locks := f.locks
+	if locks == nil || f.sealed.Load() || f.locked(ctx) {
+		return ctx, func() {}
+	}
+
+	// Locks are always taken in the same order so that operations locking
+	// several names cannot deadlock.
+	stripes := make([]int, len(names))
+	for i, name := range names {
+		stripes[i] = stripe(name)
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+
+	for _, i := range stripes {
+		if exclusive {
+			locks[i].Lock()
+		} else {
+			locks[i].RLock()
+		}
+	}
+	return context.WithValue(ctx, lockedKey{f}, true), func() {
+		for _, i := range slices.Backward(stripes) {
+			if exclusive {
+				locks[i].Unlock()
+			} else {
+				locks[i].RUnlock()
+			}
+		}
+	}
+}
+
+// stripe returns the index of the lock of name.
+func stripe(name string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path.Clean(name)))
+	return int(h.Sum32() % lockStripes)
+}
+
+// lockTarget locks name exclusively like lock, along with the symbolic
+// links met from name and the file they lead to, which operations following
+// links copy up and change.
+func (f *filesystem) lockTarget(ctx context.Context, name string) (context.Context, func()) {
+	if f.locks == nil || f.sealed.Load() || f.locked(ctx) {
+		return ctx, func() {}
+	}
+	names := []string{name}
+	for range maxLinks {
+		locked, unlock := f.lock(ctx, true, names...)
+		// The links are followed again under the locks, in case they
+		// changed since.
+		more := false
+		for _, n := range f.linkChain(locked, name) {
+			if !slices.Contains(names, n) {
+				names = append(names, n)
+				more = true
+			}
+		}
+		if !more {
+			return locked, unlock
+		}
+		unlock()
+	}
+	return f.lock(ctx, true, names...)
+}
+
+// locked reports whether ctx comes from an operation of the union holding
+// its locks.
+func (f *filesystem) locked(ctx context.Context) bool {
+	held, _ := ctx.Value(lockedKey{f}).(bool)
+	return held
+}
+
+// unlocked returns ctx without the mark of the locks of the operation it
+// comes from, for the files that outlive the operation, whose calls must
+// lock again.
+func (f *filesystem) unlocked(ctx context.Context) context.Context {
+	if !f.locked(ctx) {
+		return ctx
+	}
+	return context.WithValue(ctx, lockedKey{f}, false)
+}
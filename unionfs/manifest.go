@@ -0,0 +1,107 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// ManifestVersion is the version of the Manifest format written by
+// ExportManifest.
+const ManifestVersion = 1
+
+// Manifest records the deletion state of a union: the files of its read-only
+// layers hidden by whiteouts. It encodes to JSON, for instance with
+// contextual.WriteJSON, so that the state can be replicated to another union
+// over the same read-only layers without copying the read-write layer.
+type Manifest struct {
+	// Version is the version of the format, ManifestVersion.
+	Version int `json:"version"`
+	// Whiteouts lists the names hidden by whiteouts, in lexical order.
+	Whiteouts []string `json:"whiteouts"`
+}
+
+// ExportManifest returns the whiteouts of the read-write layer of the union,
+// wherever SetMetadataStore or SetMetadataDir keeps them. Whiteouts of a full
+// read-write layer that spilled over are not included.
+func ExportManifest(ctx context.Context, union contextual.FS) (*Manifest, error) {
+	f := union.(*filesystem)
+	m := &Manifest{Version: ManifestVersion, Whiteouts: []string{}}
+	if err := f.collectWhiteouts(ctx, ".", &m.Whiteouts); err != nil {
+		return nil, internal.Decorate("export", ".", err)
+	}
+	slices.Sort(m.Whiteouts)
+	return m, nil
+}
+
+// collectWhiteouts appends to names the whiteouts of the read-write layer in
+// dir and its subdirectories.
+func (f *filesystem) collectWhiteouts(ctx context.Context, dir string, names *[]string) error {
+	return f.walkWhiteouts(ctx, dir, func(name string, _ fs.DirEntry) error {
+		*names = append(*names, name)
+		return nil
+	})
+}
+
+// walkWhiteouts calls fn for every whiteout of the read-write layer in dir
+// and its subdirectories, with the name it hides and its entry in the store.
+func (f *filesystem) walkWhiteouts(ctx context.Context, dir string, fn func(name string, e fs.DirEntry) error) error {
+	return f.walkControls(ctx, dir, whiteoutPrefix, fn)
+}
+
+// walkControls calls fn for every control file of the read-write layer whose
+// kind is given by prefix in dir and its subdirectories, like walkWhiteouts.
+func (f *filesystem) walkControls(ctx context.Context, dir, prefix string, fn func(name string, e fs.DirEntry) error) error {
+	entries, err := contextual.ReadDir(ctx, f.meta.store(f.rw), f.meta.path(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if after, found := strings.CutPrefix(e.Name(), prefix); found {
+			if err := fn(path.Join(dir, after), e); err != nil {
+				return err
+			}
+			continue
+		}
+		if e.IsDir() {
+			if err := f.walkControls(ctx, path.Join(dir, e.Name()), prefix, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ImportManifest creates the whiteouts listed in m in the read-write layer
+// of the union, usually a fresh one, hiding the same files as the union m was
+// exported from. Files of the read-write layer are left in place, so a
+// whiteout does not hide a file that was created again.
+func ImportManifest(ctx context.Context, union contextual.FS, m *Manifest) error {
+	f := union.(*filesystem)
+	if m.Version != ManifestVersion {
+		return &fs.PathError{Op: "import", Path: ".", Err: fmt.Errorf("unsupported manifest version %d: %w", m.Version, errors.ErrUnsupported)}
+	}
+	for _, name := range m.Whiteouts {
+		if !fs.ValidPath(name) || name == "." {
+			return &fs.PathError{Op: "import", Path: name, Err: fs.ErrInvalid}
+		}
+	}
+	for _, name := range m.Whiteouts {
+		if err := f.write(pathErr("import", name), func() error {
+			return f.createWhiteout(ctx, "import", name)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
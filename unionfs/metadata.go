@@ -0,0 +1,202 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// MetadataDir is the conventional name of the reserved directory passed to
+// SetMetadataDir.
+const MetadataDir = ".unionfs"
+
+// whiteoutPrefix starts the name of a whiteout file.
+const whiteoutPrefix = ".wh."
+
+// metadata locates the control files of a layer: the whiteouts hiding the
+// files of the layers below it, the tails of append overlays, and PolicyFile.
+// The zero value interleaves them with the files of the layer, which is the
+// default.
+type metadata struct {
+	// fsys is the dedicated store holding the control files, or nil if they
+	// are kept in the layer itself.
+	fsys contextual.FS
+	// dir is the directory of the store under which the control files are
+	// kept, mirroring the layout of the layer. It is empty if they are
+	// interleaved with the files of the layer.
+	dir string
+}
+
+// SetMetadataStore keeps the control files of the union, its whiteouts and
+// PolicyFile, in meta instead of the read-write layer, so that the read-write
+// layer only holds the files of the union and can be exported or backed up
+// as is. Whiteouts are stored in meta at the path of the file they hide.
+//
+// It must be called before the union is used and before SetSpillLayer.
+// Closing the union closes meta too.
+func SetMetadataStore(fs contextual.FS, meta contextual.FS) {
+	fs.(*filesystem).meta = metadata{fsys: meta, dir: "."}
+}
+
+// SetMetadataDir keeps the control files of the union under dir, usually
+// MetadataDir, in the read-write layer instead of interleaving them with its
+// files. The directory is never listed by the union, and its name is
+// reserved: the union does not accept it.
+//
+// It must be called before the union is used and before SetSpillLayer.
+func SetMetadataDir(fs contextual.FS, dir string) {
+	fs.(*filesystem).meta = metadata{dir: path.Clean(dir)}
+}
+
+// store returns the filesystem holding the control files of layer.
+func (m metadata) store(layer contextual.FS) contextual.FS {
+	if m.fsys != nil {
+		return m.fsys
+	}
+	return layer
+}
+
+// inline reports whether the control files are interleaved with the files
+// of the layer.
+func (m metadata) inline() bool {
+	return m.fsys == nil && m.dir == ""
+}
+
+// path returns the path in the store of the control file name.
+func (m metadata) path(name string) string {
+	if m.dir == "" {
+		return name
+	}
+	return path.Join(m.dir, name)
+}
+
+// whiteout returns the path in the store of the whiteout hiding name.
+func (m metadata) whiteout(name string) string {
+	return m.control(name, whiteoutPrefix)
+}
+
+// control returns the path in the store of the control file of name whose
+// kind is given by prefix, such as whiteoutPrefix.
+func (m metadata) control(name, prefix string) string {
+	dir, file := contextual.SplitDirFile(name)
+	return m.path(path.Join(dir, prefix+file))
+}
+
+// isControl reports whether the entry named entry, listed by the layer in
+// dir, is a control file that must not be exposed by the union.
+func (m metadata) isControl(dir, entry string) bool {
+	switch {
+	case m.fsys != nil:
+		return isScratch(entry)
+	case m.dir == "":
+		return strings.HasPrefix(entry, whiteoutPrefix) || strings.HasPrefix(entry, appendPrefix) ||
+			isScratch(entry) || dir == "." && entry == PolicyFile
+	default:
+		return path.Join(dir, entry) == m.dir
+	}
+}
+
+// hasWhiteout reports whether a whiteout for name exists for layer.
+func (m metadata) hasWhiteout(ctx context.Context, layer contextual.FS, name string) bool {
+	_, err := contextual.Stat(ctx, m.store(layer), m.whiteout(name))
+	return err == nil
+}
+
+// whiteouts returns the names hidden by whiteouts in dir of layer. entries
+// is the listing of dir in layer, used when the control files are
+// interleaved with the files of the layer.
+func (m metadata) whiteouts(ctx context.Context, layer contextual.FS, dir string, entries []fs.DirEntry) ([]string, error) {
+	return m.controls(ctx, layer, dir, entries, whiteoutPrefix)
+}
+
+// controls returns the names of the files of dir that have a control file
+// of the kind given by prefix in layer, like whiteouts.
+func (m metadata) controls(ctx context.Context, layer contextual.FS, dir string, entries []fs.DirEntry, prefix string) ([]string, error) {
+	if !m.inline() {
+		var err error
+		entries, err = contextual.ReadDir(ctx, m.store(layer), m.path(dir))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	var names []string
+	for _, e := range entries {
+		if after, found := strings.CutPrefix(e.Name(), prefix); found {
+			names = append(names, after)
+		}
+	}
+	return names, nil
+}
+
+// createWhiteout creates a whiteout hiding name in the store of the
+// read-write layer, creating its parent directories if needed. op is the
+// operation of the union creating it, reported to the whiteout observer.
+func (f *filesystem) createWhiteout(ctx context.Context, op, name string) error {
+	store := f.meta.store(f.rw)
+	dir, _ := contextual.SplitDirFile(name)
+	if parent := f.meta.path(dir); parent != "" && parent != "." {
+		if err := contextual.MkdirAll(ctx, store, parent, 0755); err != nil {
+			return err
+		}
+	}
+	if err := contextual.WriteFile(ctx, store, f.meta.whiteout(name), nil, 0644); err != nil {
+		return err
+	}
+	// The bytes appended to the hidden file go with it.
+	f.removeTail(ctx, name)
+	f.observeWhiteout(ctx, WhiteoutCreated, op, name)
+	return nil
+}
+
+// removeWhiteout removes the whiteout hiding name, if any, once a file of
+// that name was created in the read-write layer by op.
+func (f *filesystem) removeWhiteout(ctx context.Context, op, name string) {
+	if contextual.Remove(ctx, f.meta.store(f.rw), f.meta.whiteout(name)) == nil {
+		f.observeWhiteout(ctx, WhiteoutRemoved, op, name)
+	}
+}
+
+// moveControls moves the control files of the children of the directory
+// oldname to newname, replacing those of newname, once the directory was
+// renamed in the read-write layer. Control files interleaved with the files
+// of the layer are moved by the rename itself.
+func (f *filesystem) moveControls(ctx context.Context, oldname, newname string) error {
+	if err := f.removeControls(ctx, newname); err != nil {
+		return err
+	}
+	store, src, dst := f.meta.store(f.rw), f.meta.path(oldname), f.meta.path(newname)
+	if _, err := contextual.Lstat(ctx, store, src); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if parent := path.Dir(dst); parent != "." {
+		if err := contextual.MkdirAll(ctx, store, parent, 0755); err != nil {
+			return err
+		}
+	}
+	return contextual.Rename(ctx, store, src, dst)
+}
+
+// removeControls removes the control files of the children of the
+// directory name once it was removed from the read-write layer, like
+// removing the directory removes those interleaved with its files.
+func (f *filesystem) removeControls(ctx context.Context, name string) error {
+	if f.meta.inline() {
+		return nil
+	}
+	return contextual.RemoveAll(ctx, f.meta.store(f.rw), f.meta.path(name))
+}
+
+// close closes the dedicated store, if any.
+func (m metadata) close() error {
+	if m.fsys == nil {
+		return nil
+	}
+	return contextual.Close(m.fsys)
+}
@@ -0,0 +1,65 @@
+package unionfs
+
+import (
+	"context"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// WhiteoutAction is what happened to a whiteout.
+type WhiteoutAction int
+
+const (
+	// WhiteoutCreated reports a whiteout created to hide a file of the
+	// read-only layers.
+	WhiteoutCreated WhiteoutAction = iota
+	// WhiteoutRemoved reports a whiteout removed because a file of the same
+	// name was created in the read-write layer.
+	WhiteoutRemoved
+	// WhiteoutStale reports a whiteout hiding a name that no read-only layer
+	// holds anymore, found while Stats counted the whiteouts.
+	WhiteoutStale
+)
+
+// String returns the name of the action.
+func (a WhiteoutAction) String() string {
+	switch a {
+	case WhiteoutCreated:
+		return "created"
+	case WhiteoutRemoved:
+		return "removed"
+	case WhiteoutStale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+// WhiteoutEvent describes a change to the whiteouts of a union.
+type WhiteoutEvent struct {
+	Action WhiteoutAction
+	// Name is the name hidden by the whiteout.
+	Name string
+	// Op is the operation of the union that triggered the event, such as
+	// "remove", "rename", "mkdir" or "copyup" for a file copied to the
+	// read-write layer, and "import" or "stats" for ImportManifest and Stats.
+	Op string
+}
+
+// SetWhiteoutObserver sets a function called with every whiteout created or
+// removed by the union, and with the stale whiteouts found by Stats, or nil
+// to remove it. It makes the deletions of a union traceable, such as the
+// layers of an image built through it.
+//
+// It is called synchronously with the context of the operation, after the
+// whiteout changed, while the union may hold the locks of the name in strict
+// consistency mode, so it must not use the union itself.
+func SetWhiteoutObserver(fs contextual.FS, observe func(ctx context.Context, e WhiteoutEvent)) {
+	fs.(*filesystem).whiteoutObserver = observe
+}
+
+// observeWhiteout reports an event to the whiteout observer, if any.
+func (f *filesystem) observeWhiteout(ctx context.Context, action WhiteoutAction, op, name string) {
+	if f.whiteoutObserver != nil {
+		f.whiteoutObserver(ctx, WhiteoutEvent{Action: action, Name: name, Op: op})
+	}
+}
@@ -0,0 +1,44 @@
+package unionfs
+
+import (
+	"context"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// ownerMapping translates the ownership of the files of the read-only
+// layers to the naming scheme of the read-write layer.
+type ownerMapping struct {
+	from, to contextual.Resolver
+}
+
+// SetOwnerMapping makes copy-up preserve the ownership of files: the owner
+// and group reported by the read-only layer are translated from the names of
+// from to those of to with contextual.TranslateOwner, and set on the copy
+// with Lchown. A copied file whose ownership cannot be translated or set is
+// removed and the copy-up fails. Passing nil resolvers disables the mapping,
+// which is the default: copies are owned by whoever the read-write layer
+// makes them owned by.
+func SetOwnerMapping(fs contextual.FS, from, to contextual.Resolver) {
+	f := fs.(*filesystem)
+	if from == nil || to == nil {
+		f.owners = nil
+		return
+	}
+	f.owners = &ownerMapping{from: from, to: to}
+}
+
+// copyOwner sets the ownership described by info, the FileInfo of name in a
+// read-only layer, on its copy in the read-write layer.
+func (f *filesystem) copyOwner(ctx context.Context, name string, info fs.FileInfo) error {
+	if f.owners == nil {
+		return nil
+	}
+	xinfo := contextual.ExtendFileInfo(info)
+	owner, group, err := contextual.TranslateOwner(ctx, f.owners.from, f.owners.to, xinfo.Owner(), xinfo.Group())
+	if err != nil {
+		return &fs.PathError{Op: "lchown", Path: name, Err: err}
+	}
+	return contextual.Lchown(ctx, f.rw, name, owner, group)
+}
@@ -0,0 +1,193 @@
+package unionfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// PolicyFile is the name of the control file in the root of the read-write
+// layer, or of the metadata store, that persists the rules set with
+// SetCopyOnReadRule. It is hidden from directory listings of the union.
+const PolicyFile = ".unionfs-policy"
+
+// CopyOnReadPolicy decides whether reading a file from a read-only layer
+// copies it to the read-write layer.
+type CopyOnReadPolicy int
+
+const (
+	// CopyOnReadDefault follows the flag set with SetCopyOnRead.
+	CopyOnReadDefault CopyOnReadPolicy = iota
+	// CopyOnReadAlways copies matching files on read.
+	CopyOnReadAlways
+	// CopyOnReadNever never copies matching files on read.
+	CopyOnReadNever
+)
+
+// String returns the name of the policy as stored in the PolicyFile.
+func (p CopyOnReadPolicy) String() string {
+	switch p {
+	case CopyOnReadAlways:
+		return "always"
+	case CopyOnReadNever:
+		return "never"
+	default:
+		return "default"
+	}
+}
+
+// copyOnReadRule overrides the copy-on-read flag for names matching pattern.
+type copyOnReadRule struct {
+	pattern string
+	policy  CopyOnReadPolicy
+}
+
+// matches reports whether name matches the rule's pattern, either as a glob
+// in the syntax of path.Match or as a directory that contains name.
+func (r copyOnReadRule) matches(name string) bool {
+	if ok, _ := path.Match(r.pattern, name); ok {
+		return true
+	}
+	return contextual.HasPathPrefix(name, r.pattern)
+}
+
+// SetCopyOnReadRule overrides the copy-on-read flag for the names matching
+// pattern, a path.Match glob or a directory whose descendants all match.
+// When several rules match a name, the one set last wins; setting a pattern
+// again replaces its rule in place, and CopyOnReadDefault removes it.
+//
+// The rules are persisted to PolicyFile among the control files and can be
+// restored with LoadCopyOnReadRules.
+func SetCopyOnReadRule(ctx context.Context, fs contextual.FS, pattern string, policy CopyOnReadPolicy) error {
+	f := fs.(*filesystem)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	pattern = path.Clean(pattern)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rules := make([]copyOnReadRule, 0, len(f.rules)+1)
+	found := false
+	for _, r := range f.rules {
+		if r.pattern == pattern {
+			found = true
+			if policy == CopyOnReadDefault {
+				continue
+			}
+			r.policy = policy
+		}
+		rules = append(rules, r)
+	}
+	if !found && policy != CopyOnReadDefault {
+		rules = append(rules, copyOnReadRule{pattern: pattern, policy: policy})
+	}
+
+	if err := f.saveRules(ctx, rules); err != nil {
+		return err
+	}
+	f.rules = rules
+	return nil
+}
+
+// LoadCopyOnReadRules replaces the rules of the union with those persisted in
+// PolicyFile by SetCopyOnReadRule. A missing file means there are no rules.
+func LoadCopyOnReadRules(ctx context.Context, fs contextual.FS) error {
+	f := fs.(*filesystem)
+	rules, err := f.loadRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+	return nil
+}
+
+// shouldCopyOnRead reports whether reading name from a read-only layer
+// copies it to the read-write layer.
+func (f *filesystem) shouldCopyOnRead(name string) bool {
+	if f.sealed.Load() {
+		return false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		if f.rules[i].matches(name) {
+			return f.rules[i].policy == CopyOnReadAlways
+		}
+	}
+	return f.copyOnRead
+}
+
+// saveRules writes rules to PolicyFile, one "<policy> <pattern>" per line.
+// An empty rule set removes the file.
+func (f *filesystem) saveRules(ctx context.Context, rules []copyOnReadRule) error {
+	store, name := f.meta.store(f.rw), f.meta.path(PolicyFile)
+	if f.sealed.Load() {
+		return &fs.PathError{Op: "writefile", Path: name, Err: ErrSealed}
+	}
+	if len(rules) == 0 {
+		if err := contextual.Remove(ctx, store, name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, r := range rules {
+		fmt.Fprintf(&buf, "%s %s\n", r.policy, r.pattern)
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := contextual.MkdirAll(ctx, store, dir, 0755); err != nil {
+			return err
+		}
+	}
+	return contextual.WriteFile(ctx, store, name, buf.Bytes(), 0644)
+}
+
+// loadRules parses PolicyFile from the store of the control files.
+func (f *filesystem) loadRules(ctx context.Context) ([]copyOnReadRule, error) {
+	data, err := contextual.ReadFile(ctx, f.meta.store(f.rw), f.meta.path(PolicyFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []copyOnReadRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy, pattern, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, &fs.PathError{Op: "load", Path: PolicyFile, Err: fmt.Errorf("malformed rule %q", line)}
+		}
+		var r copyOnReadRule
+		switch policy {
+		case "always":
+			r.policy = CopyOnReadAlways
+		case "never":
+			r.policy = CopyOnReadNever
+		default:
+			return nil, &fs.PathError{Op: "load", Path: PolicyFile, Err: fmt.Errorf("unknown policy %q", policy)}
+		}
+		r.pattern = pattern
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
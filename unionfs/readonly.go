@@ -0,0 +1,147 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+//go:generate go run ../internal/gendelegate -spec ../contextual/delegate.go -type "r readOnly" -layer r.layer -methods Stat,ReadDir,ReadLink,Lstat,GetXattr,ListXattr -out readonly_gen.go
+
+// readOnly is a read-only layer of a union. It forwards the calls reading
+// the layer and refuses those that would change it with fsx.ErrReadOnly,
+// also reported as fs.ErrPermission, so that no code path of the union can
+// write to a read-only layer that happens to be writable, such as an osfs
+// passed by mistake. The lookups forwarded as they are, through the helpers
+// of the contextual package, are generated into readonly_gen.go.
+type readOnly struct {
+	layer contextual.FS
+}
+
+// readOnlyFile is a readOnly layer implementing contextual.ReadFileFS. It
+// is only used for layers implementing it, since helpers such as
+// contextual.ReadFileInto read differently from those that do not.
+type readOnlyFile struct {
+	readOnly
+}
+
+// readOnlyLayer returns layer wrapped in readOnly, or readOnlyFile, unless it
+// already is.
+func readOnlyLayer(layer contextual.FS) contextual.FS {
+	switch layer.(type) {
+	case readOnly, readOnlyFile:
+		return layer
+	case contextual.ReadFileFS:
+		return readOnlyFile{readOnly{layer: layer}}
+	}
+	return readOnly{layer: layer}
+}
+
+// unwrapReadOnly returns the layer wrapped by layer if it is a readOnly.
+func unwrapReadOnly(layer contextual.FS) contextual.FS {
+	switch r := layer.(type) {
+	case readOnly:
+		return r.layer
+	case readOnlyFile:
+		return r.layer
+	}
+	return layer
+}
+
+// refuse returns the error of the write operation op on name.
+func refuse(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: contextual.TranslateError(fsx.ErrReadOnly, fs.ErrPermission)}
+}
+
+func (r readOnly) Open(ctx context.Context, name string) (fs.File, error) {
+	return r.layer.Open(ctx, name)
+}
+
+// OpenFile opens the named file of the layer for reading. Opening it for
+// writing, creating or truncating it, is refused.
+func (r readOnly) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (contextual.File, error) {
+	if flag&internal.O_ACCMODE != os.O_RDONLY || flag&(os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, refuse("open", name)
+	}
+	if _, ok := r.layer.(contextual.WriterFS); !ok {
+		// Let contextual.OpenFile fall back to Open.
+		return nil, errors.ErrUnsupported
+	}
+	return contextual.OpenFile(ctx, r.layer, name, flag, mode)
+}
+
+func (r readOnly) Create(ctx context.Context, name string) (contextual.File, error) {
+	return nil, refuse("open", name)
+}
+
+func (r readOnly) Remove(ctx context.Context, name string) error {
+	return refuse("remove", name)
+}
+
+func (r readOnlyFile) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	return contextual.ReadFile(ctx, r.layer, name)
+}
+
+func (r readOnly) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	return contextual.ReadDirInfos(ctx, r.layer, name)
+}
+
+func (r readOnly) ReadDirPage(ctx context.Context, name, cursor string, n int) ([]fs.DirEntry, string, error) {
+	return contextual.ReadDirPage(ctx, r.layer, name, cursor, n)
+}
+
+func (r readOnly) ReadDirSeq(ctx context.Context, name string, opts ...contextual.SeqOption) *contextual.Result[fs.DirEntry] {
+	return contextual.ReadDirSeq(ctx, r.layer, name, opts...)
+}
+
+// Access checks the access to the named file of the layer. Writing is
+// refused.
+func (r readOnly) Access(ctx context.Context, name string, mode uint32) error {
+	if mode&internal.W_OK != 0 {
+		return refuse("access", name)
+	}
+	return contextual.Access(ctx, r.layer, name, mode)
+}
+
+func (r readOnly) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	return refuse("setxattr", name)
+}
+
+func (r readOnly) RemoveXattr(ctx context.Context, name, attr string) error {
+	return refuse("removexattr", name)
+}
+
+// ETag returns the validator of the named file of the layer, or an error
+// wrapping errors.ErrUnsupported if the layer does not implement
+// contextual.ETagFS.
+func (r readOnly) ETag(ctx context.Context, name string) (string, error) {
+	if efs, ok := r.layer.(contextual.ETagFS); ok {
+		return efs.ETag(ctx, name)
+	}
+	return "", &fs.PathError{Op: "etag", Path: name, Err: errors.ErrUnsupported}
+}
+
+// Close closes the layer, which the union owns.
+func (r readOnly) Close() error {
+	return contextual.Close(r.layer)
+}
+
+var (
+	_ contextual.WriterFS      = readOnly{}
+	_ contextual.ReadFileFS    = readOnlyFile{}
+	_ contextual.ReadDirFS     = readOnly{}
+	_ contextual.ReadDirInfoFS = readOnly{}
+	_ contextual.StatFS        = readOnly{}
+	_ contextual.ReadLinkFS    = readOnly{}
+	_ contextual.CloserFS      = readOnly{}
+	_ contextual.ReadDirPageFS = readOnly{}
+	_ contextual.ReadDirSeqFS  = readOnly{}
+	_ contextual.AccessFS      = readOnly{}
+	_ contextual.XattrFS       = readOnly{}
+	_ contextual.ETagFS        = readOnly{}
+)
@@ -0,0 +1,56 @@
+package unionfs
+
+import (
+	"context"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// SetRefetch sets a function called when a file cannot be found in any
+// layer of the union, or nil to remove it. If it returns nil, the lookup is
+// tried once more, so that the file can be repopulated instead of
+// surfacing fs.ErrNotExist.
+//
+// It is meant for read-only layers whose files may disappear during a
+// session, such as an evictfs cache of a slower filesystem: a file evicted
+// after it was listed can be fetched back into the cache by refetch. It is
+// called with the context of the operation, while the union may hold the
+// locks of name in strict consistency mode, so it must not use the union
+// itself.
+//
+// Independently of refetch, a file vanishing from its read-only layer while
+// it is being copied to the read-write layer is looked up again through the
+// layers, so that the copy is taken from a lower layer still holding it.
+func SetRefetch(fs contextual.FS, refetch func(ctx context.Context, name string) error) {
+	fs.(*filesystem).refetch = refetch
+}
+
+// retriedKey marks the context of a lookup of the union f that is being
+// retried. The unions f is layered on still retry theirs.
+type retriedKey struct{ f *filesystem }
+
+// noRefetch returns ctx for the lookups of a name about to be created, for
+// which a missing file is the expected answer rather than one to refetch.
+func (f *filesystem) noRefetch(ctx context.Context) context.Context {
+	if f.refetch == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, retriedKey{f}, true)
+}
+
+// retry reports whether a lookup of name that did not find it should be
+// tried again, and returns the context to use for it, which prevents further
+// retries. The lookup is retried after refetch repopulated name, or in any
+// case if always is set and refetch is not set.
+func (f *filesystem) retry(ctx context.Context, name string, always bool) (context.Context, bool) {
+	if ctx.Value(retriedKey{f}) != nil {
+		return ctx, false
+	}
+	if f.refetch != nil {
+		if err := f.refetch(ctx, name); err != nil {
+			return ctx, false
+		}
+		always = true
+	}
+	return context.WithValue(ctx, retriedKey{f}, true), always
+}
@@ -0,0 +1,133 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"syscall"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// SetStrictRename enables or disables strict POSIX semantics for Rename.
+//
+// When enabled, Rename checks the target like rename(2) does: a directory
+// cannot replace a file (syscall.ENOTDIR), a file cannot replace a directory
+// (syscall.EISDIR), a directory can only replace a directory that is empty in
+// the union (syscall.ENOTEMPTY), and a directory cannot move beneath itself
+// (fs.ErrInvalid). The parent of the target is created in the read-write
+// layer if it only exists in a read-only layer.
+//
+// A replaced target that also exists in a read-only layer is whited out once
+// the new file is in place, so that the old file cannot reappear when the new
+// one is removed, and the contents of a replaced directory stay hidden. The
+// target is never missing from the union while it is replaced, and a
+// directory given files once checked is not replaced: the rename is undone
+// and fails with syscall.ENOTEMPTY.
+func SetStrictRename(fs contextual.FS, enabled bool) {
+	fs.(*filesystem).strictRename = enabled
+}
+
+// checkRenameTarget checks that oldname, described by info, may replace
+// newname under strict rename semantics, and returns the FileInfo of the
+// target, or nil if it does not exist.
+func (f *filesystem) checkRenameTarget(ctx context.Context, info fs.FileInfo, oldname, newname string) (fs.FileInfo, error) {
+	if info.IsDir() && newname != oldname && contextual.HasPathPrefix(newname, oldname) {
+		return nil, fs.ErrInvalid
+	}
+	target, err := f.Lstat(ctx, newname)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case info.IsDir() && !target.IsDir():
+		return nil, syscall.ENOTDIR
+	case !info.IsDir() && target.IsDir():
+		return nil, syscall.EISDIR
+	case target.IsDir():
+		entries, err := f.ReadDir(ctx, newname)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			return nil, syscall.ENOTEMPTY
+		}
+	}
+	return target, nil
+}
+
+// replaceDir renames oldname over the directory newname, found empty in the
+// union, in the read-write layer, where it may still hold control files. The
+// copy of newname in the layer, if any, is moved aside first, and only
+// removed once oldname is in place. If it was given files meanwhile, the
+// rename is undone and fails with syscall.ENOTEMPTY.
+func (f *filesystem) replaceDir(ctx context.Context, oldname, newname string) error {
+	aside := f.scratch(newname)
+	if dir := path.Dir(aside); dir != path.Dir(newname) {
+		if err := contextual.MkdirAll(ctx, f.rw, dir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := contextual.Rename(ctx, f.rw, newname, aside); errors.Is(err, fs.ErrNotExist) {
+		return contextual.Rename(ctx, f.rw, oldname, newname)
+	} else if err != nil {
+		return err
+	}
+	if err := contextual.Rename(ctx, f.rw, oldname, newname); err != nil {
+		_ = contextual.Rename(ctx, f.rw, aside, newname)
+		return err
+	}
+	entries, err := contextual.ReadDir(ctx, f.rw, aside)
+	if err == nil && slices.ContainsFunc(entries, func(e fs.DirEntry) bool { return !f.meta.isControl(newname, e.Name()) }) {
+		err = syscall.ENOTEMPTY
+	}
+	if err != nil {
+		_ = contextual.Rename(ctx, f.rw, newname, oldname)
+		_ = contextual.Rename(ctx, f.rw, aside, newname)
+		return err
+	}
+	return f.removeAllRW(ctx, aside)
+}
+
+// hideLowerChildren whites out the entries of the read-only layers in the
+// directory name that the read-write layer does not provide, so that the
+// contents of a replaced directory do not show through. op is the operation
+// of the union replacing it, reported to the whiteout observer.
+func (f *filesystem) hideLowerChildren(ctx context.Context, op, name string) error {
+	upper := make(map[string]bool)
+	if entries, err := contextual.ReadDir(ctx, f.rw, name); err == nil {
+		for _, e := range entries {
+			upper[e.Name()] = true
+		}
+	}
+	hidden := make(map[string]bool)
+	v := f.layers()
+	for i, ro := range v.ro {
+		entries, err := contextual.ReadDir(ctx, ro, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// A file of that name has no entries to hide.
+			if info, statErr := contextual.Stat(ctx, ro, name); statErr != nil || info.IsDir() {
+				return err
+			}
+		}
+		m := v.whiteouts[i]
+		for _, e := range entries {
+			if upper[e.Name()] || hidden[e.Name()] || m != nil && m.isControl(name, e.Name()) {
+				continue
+			}
+			hidden[e.Name()] = true
+			if err := f.createWhiteout(ctx, op, path.Join(name, e.Name())); err != nil {
+				return err
+			}
+		}
+		if v.hiddenBelow(ctx, i, name) {
+			break
+		}
+	}
+	return nil
+}
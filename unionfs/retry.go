@@ -0,0 +1,182 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// copyUpPrefix starts the name of the scratch file a regular file is copied
+// to before being renamed into place in the read-write layer. It is followed
+// by scratchIDLen hexadecimal digits, a dot and the name of the file.
+const copyUpPrefix = ".cu."
+
+// scratchIDLen is the length of the random part of the name of a scratch
+// file.
+const scratchIDLen = 16
+
+// CopyUpRetry configures how copy-ups failing with a transient error of the
+// read-write layer, such as a network blip, are retried.
+type CopyUpRetry struct {
+	// Attempts is the number of times a file is copied before its copy-up
+	// fails. Retries are disabled below 2, which is the default.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled before each
+	// following one.
+	Backoff time.Duration
+	// Transient reports whether a copy-up failing with err is worth
+	// retrying. If nil, IsTransient is used.
+	Transient func(err error) bool
+	// Clock times the delays. If nil, contextual.RealClock is used.
+	Clock contextual.Clock
+}
+
+// SetCopyUpRetry sets how copy-ups failing with a transient error are
+// retried. Every attempt starts over from an empty scratch file, so that a
+// failed one leaves nothing behind.
+func SetCopyUpRetry(fs contextual.FS, retry CopyUpRetry) {
+	fs.(*filesystem).copyUpRetry = retry
+}
+
+// IsTransient reports whether err is a failure that may go away when the
+// operation is tried again: an I/O error, a timeout, an interrupted call or
+// a connection reset. A full layer, a missing file, a refused permission or
+// a done context are not.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, errno := range []syscall.Errno{syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// scratch returns a new name in the read-write layer for a scratch file the
+// regular file name is copied to. It is a control file kept with the control
+// files of name when those are in the read-write layer, and a file next to
+// name otherwise. Its random part lets concurrent copies of a file, each
+// creating its scratch file exclusively, never share one.
+func (f *filesystem) scratch(name string) string {
+	dir, file := contextual.SplitDirFile(name)
+	prefix := fmt.Sprintf("%s%0*x.", copyUpPrefix, scratchIDLen, rand.Uint64())
+	if f.meta.fsys != nil {
+		return path.Join(dir, prefix+file)
+	}
+	return f.meta.control(name, prefix)
+}
+
+// scratchVisible reports whether the scratch files of the union are kept
+// among the files of its read-write layer, where names of the form of a
+// scratch file are reserved: the union neither lists nor accepts them.
+func (f *filesystem) scratchVisible() bool {
+	return f.meta.fsys != nil || f.meta.dir == ""
+}
+
+// isScratch reports whether name has the form of the name of a scratch file.
+func isScratch(name string) bool {
+	_, file := contextual.SplitDirFile(name)
+	id, ok := strings.CutPrefix(file, copyUpPrefix)
+	if !ok || len(id) <= scratchIDLen || id[scratchIDLen] != '.' {
+		return false
+	}
+	for _, c := range id[:scratchIDLen] {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// reserved reports whether name has an element reserved for the scratch
+// files of the union, or lies in the directory set with SetMetadataDir.
+func (f *filesystem) reserved(name string) bool {
+	if f.meta.fsys == nil && f.meta.dir != "" && contextual.HasPathPrefix(name, f.meta.dir) {
+		return true
+	}
+	if !f.scratchVisible() {
+		return false
+	}
+	for elem := range strings.SplitSeq(name, "/") {
+		if isScratch(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPath is internal.CheckPath, also refusing names reserved for the
+// scratch files of the union.
+func (f *filesystem) checkPath(op, name string) error {
+	if err := internal.CheckPath(op, name); err != nil {
+		return err
+	}
+	if f.reserved(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+// checkOpen is internal.CheckOpen, also refusing names reserved for the
+// scratch files of the union.
+func (f *filesystem) checkOpen(name string, flag int) error {
+	if err := internal.CheckOpen(name, flag); err != nil {
+		return err
+	}
+	return f.checkPath("open", name)
+}
+
+// checkNames is internal.CheckLink, also refusing names reserved for the
+// scratch files of the union. The old name of a symlink is its target, which
+// is not checked.
+func (f *filesystem) checkNames(op, oldname, newname string) error {
+	if err := internal.CheckLink(op, oldname, newname); err != nil {
+		return err
+	}
+	if op != "symlink" && f.reserved(oldname) || f.reserved(newname) {
+		return &os.LinkError{Op: op, Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+// backoff reports whether the copy-up that failed with err on its attempt-th
+// try is tried again, after waiting for the delay set with SetCopyUpRetry. A
+// mismatching copy is always copied again once, without waiting. It reports
+// false if ctx is done meanwhile.
+func (f *filesystem) backoff(ctx context.Context, attempt int, err error) bool {
+	if errors.Is(err, ErrCopyMismatch) && attempt < copyUpAttempts {
+		return true
+	}
+	r := f.copyUpRetry
+	transient := r.Transient
+	if transient == nil {
+		transient = IsTransient
+	}
+	if attempt >= r.Attempts || !transient(err) {
+		return false
+	}
+	wait := r.Backoff << (attempt - 1)
+	if wait <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := contextual.ClockOr(r.Clock).NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
@@ -0,0 +1,33 @@
+package unionfs
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// ErrSealed is returned, wrapped in an *fs.PathError or *os.LinkError, by
+// write operations of a union sealed with Seal. It is reported as
+// fsx.ErrReadOnly and fs.ErrPermission too.
+var ErrSealed = contextual.TranslateError(contextual.TranslateError(errors.New("union is sealed"), fs.ErrPermission), fsx.ErrReadOnly)
+
+// Seal switches the union to read-only mode for good, once it has been
+// populated, so that it can be served to many goroutines as is. Every later
+// call that would change the union, including SetCopyOnReadRule and Check
+// with Repair, fails with ErrSealed without touching its layers, while reads keep working.
+// Copy-on-read is skipped, and strict consistency mode no longer locks, since
+// nothing changes any more.
+//
+// Seal may be called while other operations are running; those already
+// writing finish as they would have. Files opened for writing before Seal
+// stay writable.
+func Seal(fs contextual.FS) {
+	fs.(*filesystem).sealed.Store(true)
+}
+
+// Sealed reports whether the union was sealed with Seal.
+func Sealed(fs contextual.FS) bool {
+	return fs.(*filesystem).sealed.Load()
+}
@@ -0,0 +1,264 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"iter"
+	"strings"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// ReadDirSeq returns the entries of the named directory, sorted by name, as
+// a sequence. It merges the layers like ReadDir, but rather than collecting
+// the listings of the layers and the names hidden by their whiteouts, it
+// walks the sorted listings of the layers and of their control files side
+// by side, read with contextual.ReadDirSeq, so that directories holding
+// millions of entries or whiteouts are listed in bounded memory when the
+// layers implement contextual.ReadDirPageFS or contextual.ReadDirSeqFS.
+//
+// Unlike ReadDir, it does not lock name in strict consistency mode, as the
+// lock would be held by the loop over the sequence, which may well change
+// the directory: like reading a directory of the os package, the changes
+// made while the sequence is iterated may or may not be seen.
+func (f *filesystem) ReadDirSeq(ctx context.Context, name string, opts ...contextual.SeqOption) *contextual.Result[fs.DirEntry] {
+	return contextual.NewResult(func(yield func(fs.DirEntry) bool) error {
+		if err := f.checkPath("readdir", name); err != nil {
+			return err
+		}
+		if err := f.mergeDirSeq(ctx, name, opts, yield); err != nil {
+			return internal.Decorate("readdir", name, err)
+		}
+		return nil
+	})
+}
+
+// mergeDirSeq yields the merged listing of dir. The layers are indexed from
+// the read-write layer, 0, down to the last read-only layer. An entry is
+// taken from the topmost layer listing its name, and is hidden if a layer
+// above that one has a whiteout for it.
+func (f *filesystem) mergeDirSeq(ctx context.Context, dir string, opts []contextual.SeqOption, yield func(fs.DirEntry) bool) error {
+	v := f.view(ctx)
+	if f.hidden(ctx, dir) {
+		// The directory of the read-write layer, if any, replaces the
+		// whited out one.
+		v = view{}
+	}
+	layers := append([]contextual.FS{f.rw}, v.ro...)
+	metas := append([]*metadata{&f.meta}, v.whiteouts...)
+
+	var streams []*dirStream
+	defer func() {
+		for _, s := range streams {
+			s.stop()
+		}
+	}()
+	open := func(layer contextual.FS, name string) *dirStream {
+		s := newDirStream(ctx, layer, name, opts)
+		streams = append(streams, s)
+		return s
+	}
+
+	entries := make([]*dirStream, len(layers))
+	whiteouts := make([]*controlStream, len(layers))
+	for i, layer := range layers {
+		entries[i] = open(layer, dir)
+		entries[i].skip = func(e fs.DirEntry) bool { return f.reserved(e.Name()) }
+		if m := metas[i]; m != nil {
+			entries[i].skip = func(e fs.DirEntry) bool { return m.isControl(dir, e.Name()) || f.reserved(e.Name()) }
+			whiteouts[i] = &controlStream{dirStream: open(m.store(layer), m.path(dir)), prefix: whiteoutPrefix}
+		}
+	}
+	var tails *controlStream
+	if f.appendOverlay {
+		tails = &controlStream{dirStream: open(f.meta.store(f.rw), f.meta.path(dir)), prefix: appendPrefix}
+	}
+	for _, s := range entries {
+		if err := s.advance(); err != nil {
+			return err
+		}
+	}
+
+	found := false
+	for {
+		// The smallest name listed by any layer is the next to merge.
+		var name string
+		top := -1
+		for i, s := range entries {
+			if s.head != nil && (top < 0 || s.head.Name() < name) {
+				name, top = s.head.Name(), i
+			}
+		}
+		if top < 0 {
+			break
+		}
+		entry := entries[top].head
+		for _, s := range entries[top:] {
+			if s.head != nil && s.head.Name() == name {
+				if err := s.advance(); err != nil {
+					return err
+				}
+			}
+		}
+
+		hidden := false
+		for _, w := range whiteouts[:top] {
+			if w == nil {
+				continue
+			}
+			has, err := w.has(name)
+			if err != nil {
+				return err
+			}
+			if has {
+				hidden = true
+				break
+			}
+		}
+		if hidden {
+			found = true
+			continue
+		}
+		if tails != nil && top > 0 {
+			has, err := tails.has(name)
+			if err != nil {
+				return err
+			}
+			if has {
+				entry = f.appendTails(ctx, dir, []fs.DirEntry{entry}, map[string]bool{name: true})[0]
+			}
+		}
+		found = true
+		if !yield(entry) {
+			return nil
+		}
+	}
+
+	if found {
+		return nil
+	}
+	for _, s := range entries {
+		if !s.missing {
+			return nil
+		}
+	}
+	// A dedicated store may hold whiteouts for a directory that is missing
+	// from every layer.
+	if w := whiteouts[0]; w != nil {
+		if has, err := w.any(); err != nil || has {
+			return err
+		}
+	}
+	return fs.ErrNotExist
+}
+
+// dirStream is a listing being merged by mergeDirSeq, and its next entry.
+type dirStream struct {
+	res  *contextual.Result[fs.DirEntry]
+	next func() (fs.DirEntry, bool)
+	stop func()
+	// skip, if set, reports the entries to leave out.
+	skip func(fs.DirEntry) bool
+
+	// head is the next entry, or nil once the listing is over. missing
+	// reports that the directory does not exist.
+	head    fs.DirEntry
+	missing bool
+}
+
+func newDirStream(ctx context.Context, layer contextual.FS, name string, opts []contextual.SeqOption) *dirStream {
+	res := contextual.ReadDirSeq(ctx, layer, name, opts...)
+	next, stop := iter.Pull(res.All())
+	return &dirStream{res: res, next: next, stop: stop}
+}
+
+// advance moves head to the next entry. A missing directory is listed as
+// empty.
+func (s *dirStream) advance() error {
+	for {
+		e, ok := s.next()
+		if !ok {
+			break
+		}
+		if s.skip == nil || !s.skip(e) {
+			s.head = e
+			return nil
+		}
+	}
+	s.head = nil
+	err := s.res.Err()
+	if errors.Is(err, fs.ErrNotExist) {
+		s.missing = true
+		return nil
+	}
+	return err
+}
+
+// controlStream is the listing of the names of the files of a directory
+// that have a control file of the kind given by prefix, like the names
+// returned by metadata.controls. The control files of a kind sort together
+// and in the order of the names they control, so only the entry at hand is
+// held.
+type controlStream struct {
+	*dirStream
+	prefix string
+
+	started, done bool
+}
+
+// peek returns the name controlled by the entry at hand.
+func (c *controlStream) peek() (string, bool, error) {
+	if !c.started {
+		c.started = true
+		if err := c.advance(); err != nil {
+			return "", false, err
+		}
+	}
+	for !c.done {
+		if c.head == nil {
+			c.done = true
+			break
+		}
+		name := c.head.Name()
+		if after, found := strings.CutPrefix(name, c.prefix); found {
+			return after, true, nil
+		}
+		if name > c.prefix {
+			// Past the control files: the rest need not be read.
+			c.done = true
+			c.stop()
+			break
+		}
+		if err := c.advance(); err != nil {
+			return "", false, err
+		}
+	}
+	return "", false, nil
+}
+
+// has reports whether name has a control file. It must be called with
+// names in increasing order, as the entries before name are dropped.
+func (c *controlStream) has(name string) (bool, error) {
+	for {
+		controlled, ok, err := c.peek()
+		if err != nil || !ok || controlled > name {
+			return false, err
+		}
+		if controlled == name {
+			return true, nil
+		}
+		if err := c.advance(); err != nil {
+			return false, err
+		}
+	}
+}
+
+// any reports whether there is any control file left.
+func (c *controlStream) any() (bool, error) {
+	_, ok, err := c.peek()
+	return ok, err
+}
+
+var _ contextual.ReadDirSeqFS = &filesystem{}
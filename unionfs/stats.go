@@ -0,0 +1,121 @@
+package unionfs
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Usage reports how the read-write layer of a union is used, so that it can
+// be sized and copy-on-read can be weighed against the space it takes.
+type Usage struct {
+	// CopyUps is the number of files copied to the read-write layer, by
+	// copy-on-write or copy-on-read, since the union was created.
+	CopyUps int64
+	// CopyUpBytes is the number of bytes written to the read-write layer by
+	// those copies.
+	CopyUpBytes int64
+	// WrittenBytes is the number of bytes written to the read-write layer by
+	// the users of the union, through WriteFile or files opened for writing,
+	// since the union was created. Bytes written by copies are not included.
+	WrittenBytes int64
+	// Whiteouts is the number of whiteouts currently held for the read-write
+	// layer, wherever SetMetadataStore or SetMetadataDir keeps them.
+	Whiteouts int
+	// WhiteoutBytes is the total size of those whiteouts as reported by
+	// their store.
+	WhiteoutBytes int64
+}
+
+// counters accumulates the bytes written to the read-write layer.
+type counters struct {
+	copyUps      atomic.Int64
+	copyUpBytes  atomic.Int64
+	writtenBytes atomic.Int64
+}
+
+// copiedBytes records a copy of n bytes to the read-write layer.
+func (c *counters) copiedBytes(n int64) {
+	c.copyUps.Add(1)
+	c.copyUpBytes.Add(n)
+}
+
+// Stats returns the usage of the read-write layer of the union. The counts
+// of copies and written bytes are kept in memory since the union was
+// created, while the whiteouts are counted by listing their store, which
+// takes a walk of the read-write layer unless SetMetadataStore or
+// SetMetadataDir keeps them apart. If SetWhiteoutObserver set an observer,
+// each whiteout is also checked against the read-only layers to report the
+// stale ones.
+func Stats(ctx context.Context, union contextual.FS) (Usage, error) {
+	f := union.(*filesystem)
+	u := Usage{
+		CopyUps:      f.counters.copyUps.Load(),
+		CopyUpBytes:  f.counters.copyUpBytes.Load(),
+		WrittenBytes: f.counters.writtenBytes.Load(),
+	}
+	err := f.walkWhiteouts(ctx, ".", func(name string, e fs.DirEntry) error {
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if f.whiteoutObserver != nil && !f.inRO(ctx, name) {
+			f.observeWhiteout(ctx, WhiteoutStale, "stats", name)
+		}
+		u.Whiteouts++
+		u.WhiteoutBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return Usage{}, internal.Decorate("stats", ".", err)
+	}
+	return u, nil
+}
+
+// countWrites returns file, opened for writing in the read-write layer as
+// names, so that the bytes written through it are counted as written by
+// users, and the changes made through it move the tokens of names.
+func (f *filesystem) countWrites(file fsx.File, names ...string) fsx.File {
+	return internal.WrapFile(&countingFile{File: file, counters: &f.counters, changes: &f.changes, names: names}, file)
+}
+
+// countingFile counts the bytes written through a file of the read-write
+// layer.
+type countingFile struct {
+	fsx.File
+	counters *counters
+	changes  *changeTokens
+	names    []string
+}
+
+func (c *countingFile) Write(p []byte) (int, error) {
+	n, err := c.File.Write(p)
+	c.counters.writtenBytes.Add(int64(n))
+	c.changes.changed(c.names...)
+	return n, err
+}
+
+func (c *countingFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := internal.ReadFrom(c.File, r)
+	c.counters.writtenBytes.Add(n)
+	c.changes.changed(c.names...)
+	return n, err
+}
+
+func (c *countingFile) WriteTo(w io.Writer) (int64, error) {
+	return internal.WriteTo(w, c.File)
+}
+
+func (c *countingFile) Restat() (fs.FileInfo, error) {
+	return internal.Restat(c.File)
+}
+
+func (c *countingFile) Truncate(size int64) error {
+	defer c.changes.changed(c.names...)
+	return c.File.Truncate(size)
+}
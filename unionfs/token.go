@@ -0,0 +1,98 @@
+package unionfs
+
+import (
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// maxChangeStamps is the number of names whose changes are tracked apart.
+// Beyond it, the stamps are dropped and every token moves to the latest
+// change.
+const maxChangeStamps = 1 << 16
+
+// changeTokens tracks the changes made through the union, for ChangeToken.
+type changeTokens struct {
+	mu sync.Mutex
+	// gen is the generation of the last change. floor is the generation
+	// reported for the names without a stamp.
+	gen   uint64
+	floor uint64
+	// stamps maps names to the generations of their last changes.
+	stamps map[string]changeStamp
+}
+
+// changeStamp holds the generations of the last change to a name itself,
+// and of the last change to it or to a name under it.
+type changeStamp struct {
+	self, tree uint64
+}
+
+// start makes the generations start from now.
+func (c *changeTokens) start() {
+	c.gen = uint64(time.Now().UnixNano())
+	c.floor = c.gen
+}
+
+// changed records a change to each of names, after it took effect.
+func (c *changeTokens) changed(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if c.stamps == nil {
+		c.stamps = make(map[string]changeStamp)
+	} else if len(c.stamps) >= maxChangeStamps {
+		clear(c.stamps)
+		c.floor = c.gen
+	}
+	for _, name := range names {
+		c.stamps[name] = changeStamp{self: c.gen, tree: c.gen}
+		for name != "." {
+			name = path.Dir(name)
+			s := c.stamps[name]
+			s.tree = c.gen
+			c.stamps[name] = s
+		}
+	}
+}
+
+// token returns the generation of the last change to prefix, to the names
+// under it and to the directories above it.
+func (c *changeTokens) token(prefix string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	token := max(c.floor, c.stamps[prefix].tree)
+	for prefix != "." {
+		prefix = path.Dir(prefix)
+		token = max(token, c.stamps[prefix].self)
+	}
+	return token
+}
+
+// ChangeToken returns a token of the changes made through the union to the
+// file or directory named prefix: the files under it, and the directories
+// above it, as renaming or removing them changes it too. Use "." for the
+// whole union. The token grows with every such change, so that a cache of
+// something read from the union, such as a rendered template or the ETag of
+// a response, is still valid as long as the token it was stored with is
+// the current one, which takes no walk.
+//
+// A change increments the token once it took effect, including writes
+// through open files, and failed operations, which may have changed part of
+// what they were given. Changes made to the layers behind the back of the
+// union are not seen. Tokens start from the time the union was created, so
+// that they do not repeat those of a previous union over the same layers,
+// and may grow for unrelated changes, but never miss one. A prefix that is
+// not a valid path as of fs.ValidPath reports the token of the whole union.
+func ChangeToken(union contextual.FS, prefix string) uint64 {
+	f := union.(*filesystem)
+	if !fs.ValidPath(prefix) {
+		prefix = "."
+	}
+	return f.changes.token(prefix)
+}
@@ -5,6 +5,29 @@
 // When a file is modified, it is copied from a read-only layer to the read-write
 // layer (Copy-on-Write). Deletions are handled using "whiteout" files (e.g., .wh.<filename>)
 // created in the read-write layer to hide files present in the read-only layers.
+// SetMetadataStore and SetMetadataDir keep those control files out of the
+// namespace of the read-write layer, and ExportManifest and ImportManifest
+// replicate the whiteouts of a union to another one. Stats reports the space
+// taken in the read-write layer by copy-ups, writes and whiteouts, and
+// SetLinkPolicy keeps symbolic links from pointing outside the union, while
+// SetLinklessPolicy decides how a read-write layer that cannot hold them
+// deals with them.
+// SetWhiteoutObserver reports the whiteouts created and removed, and
+// SetAppendOverlay keeps appends to files of read-only layers from copying
+// them whole. NewDryRun reports the changes a workload would make to the
+// read-write layer without making them, and Check finds and repairs the
+// anomalies of a union, such as stale whiteouts. ChangeToken lets caches of
+// what was read from a union tell whether anything changed under a name.
+// ReadDirSeq, also used by open directories, merges listings in bounded
+// memory, however many entries and whiteouts the layers hold. Copies to the
+// read-write layer are written to a scratch file and checked against their
+// source before being renamed into place, and SetCopyUpRetry retries those
+// failing with a transient error. Unless SetMetadataDir keeps the scratch
+// files out of sight, names of the form .cu.<16 hex digits>.<name> are
+// reserved for them.
+// WithLayers lets one union serve several views, each searching its own
+// selection of the read-only layers, and Seal turns a populated union
+// read-only for good.
 package unionfs
 
 import (
@@ -12,32 +35,130 @@ import (
 	"errors"
 	"io"
 	"io/fs"
+	"iter"
 	"os"
 	"path"
-	"sort"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/internal"
 )
 
 // filesystem is a union filesystem that has one read-write layer and multiple
 // read-only layers. It implements the contextual.FileSystem interface.
 type filesystem struct {
-	rw         contextual.FS
-	ro         []contextual.FS
-	copyOnRead bool
+	rw contextual.FS
+	ro []contextual.FS
+	// meta locates the control files of rw.
+	meta metadata
+	// whiteouts holds, for each layer in ro, where to find the whiteouts
+	// hiding files of the layers below it, or nil if it has none. Only the
+	// read-write layers of nested unions that were flattened by New have
+	// whiteouts.
+	whiteouts []*metadata
+	// pinned is the number of leading layers of ro searched by every view
+	// selected with WithLayers.
+	pinned       int
+	copyOnRead   bool
+	concurrency  int
+	strictRename bool
+	owners       *ownerMapping
+	// locks serializes the operations per path in strict consistency mode,
+	// or is nil.
+	locks *pathLocks
+	// handles tracks the handles following copy-ups, or is nil.
+	handles *handleSet
+	// counters accumulates the bytes written to rw, reported by Stats.
+	counters counters
+	// refetch repopulates files missing from every layer, or is nil.
+	refetch func(ctx context.Context, name string) error
+	// linkPolicy decides what ReadLink returns for unsafe destinations.
+	linkPolicy LinkPolicy
+	// noLinks reports that rw cannot hold symbolic links, which
+	// linklessPolicy then deals with.
+	noLinks        atomic.Bool
+	linklessPolicy LinklessPolicy
+	// whiteoutObserver is told about the changes to whiteouts, or is nil.
+	whiteoutObserver func(ctx context.Context, e WhiteoutEvent)
+	// appendOverlay stores the bytes appended to files of the read-only
+	// layers in tails instead of copying the files.
+	appendOverlay bool
+	// changes tracks the changes made through the union, for ChangeToken.
+	changes changeTokens
+
+	// mu guards rules, the per-path overrides of copyOnRead.
+	mu    sync.RWMutex
+	rules []copyOnReadRule
+
+	// fullPolicy decides what happens when rw is out of space. degraded is
+	// set once FullReadOnly took effect, and spilled, shared with the
+	// spillover layers installed by SetSpillLayer, once writes moved to the
+	// spill layer.
+	fullPolicy FullPolicy
+	degraded   atomic.Bool
+	spilled    *atomic.Bool
+
+	// sealed is set by Seal.
+	sealed atomic.Bool
+
+	// copyUpRetry decides which failed copy-ups are tried again.
+	copyUpRetry CopyUpRetry
 }
 
+// DefaultConcurrency is the number of workers used for per-entry work of
+// recursive operations unless overridden with SetConcurrency.
+const DefaultConcurrency = 8
+
 // New creates a new union filesystem with a mandatory read-write layer (rw)
 // and optional read-only layers (ro). The layers are searched in order:
 // rw is searched first, then ro layers in the order they were provided.
+//
+// A read-only layer that is itself a union filesystem is flattened into its
+// layers, which are searched in its place. Whiteouts in its read-write layer
+// keep hiding the files of its lower layers, while its control files are
+// never exposed. Options set on the nested union, such as copy-on-read, do
+// not apply to the new one.
+//
+// The read-only layers are only ever read, even if they implement
+// contextual.WriterFS: the union accesses them through a wrapper refusing
+// every change with fsx.ErrReadOnly, which is also reported as
+// fs.ErrPermission. A read-write layer that cannot hold symbolic links is
+// dealt with as set with SetLinklessPolicy.
 func New(rw contextual.FS, ro ...contextual.FS) *filesystem {
-	return &filesystem{
-		rw: rw,
-		ro: ro,
+	f := &filesystem{
+		rw:          rw,
+		concurrency: DefaultConcurrency,
 	}
+	f.noLinks.Store(!holdsLinks(rw))
+	f.changes.start()
+	for _, layer := range ro {
+		if u, ok := layer.(*filesystem); ok {
+			f.ro = append(f.ro, readOnlyLayer(u.rw))
+			meta := u.meta
+			f.whiteouts = append(f.whiteouts, &meta)
+			f.ro = append(f.ro, u.ro...)
+			f.whiteouts = append(f.whiteouts, u.whiteouts...)
+			continue
+		}
+		f.ro = append(f.ro, readOnlyLayer(layer))
+		f.whiteouts = append(f.whiteouts, nil)
+	}
+	return f
+}
+
+// Unwrap returns the layers of the union, starting with the read-write layer
+// and followed by the read-only layers in search order. Nested unions appear
+// flattened.
+func (f *filesystem) Unwrap() []contextual.FS {
+	layers := []contextual.FS{f.rw}
+	for _, ro := range f.ro {
+		layers = append(layers, unwrapReadOnly(ro))
+	}
+	return layers
 }
 
 // SetCopyOnRead enables or disables copy-on-read behavior for the given filesystem.
@@ -47,28 +168,61 @@ func SetCopyOnRead(fs contextual.FS, enabled bool) {
 	fs.(*filesystem).copyOnRead = enabled
 }
 
+// SetConcurrency sets the maximum number of workers used when a directory
+// operation has to touch many entries, such as the recursive copy-up performed
+// when renaming a directory that lives in a read-only layer.
+// Values less than 1 make those operations sequential.
+func SetConcurrency(fs contextual.FS, n int) {
+	fs.(*filesystem).concurrency = n
+}
+
+// forEach calls fn for every name using a bounded pool of workers.
+// It waits for all started calls to finish and returns their errors joined
+// together with errors.Join. No new work is started once ctx is done.
+func (f *filesystem) forEach(ctx context.Context, names []string, fn func(ctx context.Context, name string) error) error {
+	sem := make(chan struct{}, max(f.concurrency, 1))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			break
+		}
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			errs[i] = fn(ctx, name)
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 // isWhiteout checks if a whiteout file exists in the read-write layer for the given name.
 // A whiteout file is named ".wh.<original_filename>" and indicates that the
 // file should be treated as non-existent, even if it exists in a read-only layer.
+// The whiteout of a directory hides its contents too, which hidden reports.
 func (f *filesystem) isWhiteout(ctx context.Context, name string) bool {
-	dir, file := path.Split(name)
-	wh := path.Join(dir, ".wh."+file)
-	_, err := contextual.Stat(ctx, f.rw, wh)
-	return err == nil
-}
-
-// createWhiteout creates a whiteout file in the read-write layer for the given name.
-// This is used to "delete" a file that exists in a read-only layer.
-func (f *filesystem) createWhiteout(ctx context.Context, name string) error {
-	dir, file := path.Split(name)
-	wh := path.Join(dir, ".wh."+file)
-	// Ensure parent exists in RW
-	if dir != "" && dir != "." {
-		if err := contextual.MkdirAll(ctx, f.rw, dir, 0755); err != nil {
-			return err
+	return f.meta.hasWhiteout(ctx, f.rw, name)
+}
+
+// inRO reports whether name is visible in one of the read-only layers,
+// ignoring a whiteout of name in the read-write layer. Files beneath a
+// directory whited out there are not visible.
+func (f *filesystem) inRO(ctx context.Context, name string) bool {
+	if dir := path.Dir(name); dir != "." && f.hidden(ctx, dir) {
+		return false
+	}
+	v := f.view(ctx)
+	for i, ro := range v.ro {
+		if _, err := contextual.Stat(ctx, ro, name); err == nil {
+			return true
+		}
+		if v.hiddenBelow(ctx, i, name) {
+			break
 		}
 	}
-	return contextual.WriteFile(ctx, f.rw, wh, nil, 0644)
+	return false
 }
 
 // Open opens the named file for reading. It satisfies the contextual.FS interface.
@@ -78,30 +232,95 @@ func (f *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
 
 // copyToRW copies a file or directory from one of the read-only layers to
 // the read-write layer. If the file already exists in the read-write layer,
-// it does nothing and returns nil.
+// it does nothing and returns nil. A symbolic link is copied as a link to
+// the same destination, which is not copied.
 func (f *filesystem) copyToRW(ctx context.Context, name string) error {
+	_, err := f.copyUp(ctx, name)
+	return err
+}
+
+// copyUp is copyToRW, returning the FileInfo of name in the read-write
+// layer, or in the read-only layer it was copied from.
+func (f *filesystem) copyUp(ctx context.Context, name string) (fs.FileInfo, error) {
 	// Check if already in RW
-	if _, err := contextual.Stat(ctx, f.rw, name); !os.IsNotExist(err) {
+	if info, err := contextual.Lstat(ctx, f.rw, name); !os.IsNotExist(err) {
+		return info, err
+	}
+
+	src, info := f.findRO(ctx, name)
+	if src == nil {
+		return nil, fs.ErrNotExist
+	}
+	return info, f.copyFrom(ctx, src, name, info)
+}
+
+// copyParentToRW copies to the read-write layer the directory holding name,
+// so that name can be created there. A missing directory is left to the
+// creation to report.
+func (f *filesystem) copyParentToRW(ctx context.Context, name string) error {
+	dir := path.Dir(name)
+	if dir == "." {
+		return nil
+	}
+	if err := f.copyToRW(ctx, dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
+	return nil
+}
 
-	// Find in RO
-	var src contextual.FS
-	var info fs.FileInfo
-	for _, ro := range f.ro {
-		if i, err := contextual.Stat(ctx, ro, name); err == nil {
-			src = ro
-			info = i
-			break
+// copyTargetToRW copies to the read-write layer the file that an operation
+// following symbolic links, such as Chmod, applies to, and returns its name.
+// If name is a symbolic link, the link is copied, its destination is
+// resolved in the union like resolveLink does, and the file it refers to is
+// copied in turn. A destination escaping the union is only followed if the
+// link policy is LinkRebase, and one hidden by a whiteout is not followed
+// under LinkReject: both fail with ErrUnsafeLink. The name of a missing file
+// is returned with fs.ErrNotExist. The caller locks the names with
+// lockTarget.
+func (f *filesystem) copyTargetToRW(ctx context.Context, name string) (string, error) {
+	for range maxLinks {
+		info, err := f.copyUp(ctx, name)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 || f.noLinks.Load() {
+			// A read-write layer without links holds a copy of the file
+			// the link referred to.
+			return name, err
+		}
+		target, err := contextual.ReadLink(ctx, f.rw, name)
+		if err != nil {
+			return name, err
+		}
+		if name, err = f.followLink(ctx, name, target); err != nil {
+			return name, err
 		}
 	}
+	return name, syscall.ELOOP
+}
 
-	if src == nil {
-		return fs.ErrNotExist
+// copyFrom copies name, described by info, from the read-only layer src to
+// the read-write layer.
+func (f *filesystem) copyFrom(ctx context.Context, src contextual.FS, name string, info fs.FileInfo) error {
+	// The copy takes the mode of the original, whatever umask ctx carries.
+	if _, ok := contextual.Umask(ctx); ok {
+		ctx = contextual.WithUmask(ctx, 0)
+	}
+	if ctx.Value(planKey{}) != nil {
+		// Tells the changes made to rw below apart from other writes.
+		ctx = context.WithValue(ctx, copyUpKey{}, name)
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 && f.noLinks.Load() {
+		return f.copyLinkUp(ctx, src, name)
 	}
 
 	if info.IsDir() {
-		return contextual.MkdirAll(ctx, f.rw, name, info.Mode().Perm())
+		if err := contextual.MkdirAll(ctx, f.rw, name, info.Mode().Perm()); err != nil {
+			return err
+		}
+		// Changing the owner may clear the setgid bit, which is set after.
+		if err := f.copyOwner(ctx, name, info); err != nil {
+			return err
+		}
+		return f.copyMode(ctx, name, info)
 	}
 
 	// Copy file
@@ -113,79 +332,400 @@ func (f *filesystem) copyToRW(ctx context.Context, name string) error {
 		}
 	}
 
-	in, err := src.Open(ctx, name)
-	if err != nil {
-		return err
+	// Links are recreated with the same destination, whatever it refers to.
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := contextual.ReadLink(ctx, src, name)
+		if err != nil {
+			return err
+		}
+		if err := contextual.Symlink(ctx, f.rw, target, name); f.linksUnsupported(err) {
+			return f.copyLinkUp(ctx, src, name)
+		} else if err != nil {
+			return err
+		}
+		if err := f.copyOwner(ctx, name, info); err != nil {
+			_ = contextual.Remove(ctx, f.rw, name)
+			return err
+		}
+		f.removeWhiteout(ctx, "copyup", name)
+		return nil
 	}
-	defer func() { _ = in.Close() }()
 
-	out, err := contextual.OpenFile(ctx, f.rw, name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
-	if err != nil {
-		return err
+	// Special files have no content to copy, and opening a named pipe for
+	// reading would block. Recreate them with the same type and device.
+	if fsx.IsSpecial(info.Mode()) {
+		if err := contextual.CreateSpecial(ctx, f.rw, name, fsx.NormalizeMode(info.Mode()), fsx.DeviceNumber(info)); err != nil {
+			return err
+		}
+		if err := f.copyOwner(ctx, name, info); err != nil {
+			_ = contextual.Remove(ctx, f.rw, name)
+			return err
+		}
+		f.removeWhiteout(ctx, "copyup", name)
+		return nil
 	}
-	defer func() { _ = out.Close() }()
 
-	if _, err := io.Copy(out, in); err != nil {
+	n, err := f.copyRegular(ctx, src, name, info)
+	for attempt := 1; err != nil && f.backoff(ctx, attempt, err); attempt++ {
+		// The file may have changed since it was found, which a copy not
+		// matching it tells: copy it as it is now.
+		if info, err = contextual.Lstat(ctx, src, name); err != nil {
+			break
+		}
+		n, err = f.copyRegular(ctx, src, name, info)
+	}
+	if err != nil {
+		// The file may have left its layer since it was found there, for
+		// instance evicted from a cache: look it up again.
+		if errors.Is(err, fs.ErrNotExist) {
+			if ctx, ok := f.retry(ctx, name, true); ok {
+				return f.copyToRW(ctx, name)
+			}
+		}
 		return err
 	}
 
 	// If there was a whiteout, remove it since we now have the real file in RW
-	dir, file := path.Split(name)
-	wh := path.Join(dir, ".wh."+file)
-	_ = contextual.Remove(ctx, f.rw, wh)
+	f.removeWhiteout(ctx, "copyup", name)
+	f.removeTail(ctx, name)
+	f.copiedUp(name)
+	f.counters.copiedBytes(n)
 
 	return nil
 }
 
+// copyRegular copies the regular file name, described by info, from the
+// read-only layer src to the read-write layer, and verifies the copy. It
+// returns the number of bytes copied. The file is copied to a scratch file
+// renamed into place once complete, so that a failed copy, removed, never
+// shadows the original.
+func (f *filesystem) copyRegular(ctx context.Context, src contextual.FS, name string, info fs.FileInfo) (int64, error) {
+	scratch := f.scratch(name)
+	if dir := path.Dir(scratch); dir != path.Dir(name) {
+		if err := contextual.MkdirAll(ctx, f.rw, dir, 0755); err != nil {
+			return 0, err
+		}
+	}
+	out, err := contextual.OpenFile(ctx, f.rw, scratch, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	// The contents are streamed, and only small files are read at once.
+	n, err := f.copyContents(ctx, src, name, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = f.verifyCopy(ctx, src, name, scratch, info, n)
+	}
+	// Changing the owner clears the setuid and setgid bits, which are set
+	// after.
+	if err == nil {
+		err = f.copyOwner(ctx, scratch, info)
+	}
+	if err == nil {
+		err = f.copyMode(ctx, scratch, info)
+	}
+	if err == nil {
+		err = contextual.Rename(ctx, f.rw, scratch, name)
+	}
+	if err != nil {
+		// The scratch file is removed even if ctx is what failed the copy.
+		_ = contextual.Remove(context.WithoutCancel(ctx), f.rw, scratch)
+		return 0, err
+	}
+	return n, nil
+}
+
+// copyMode sets the setuid, setgid and sticky bits described by info, the
+// FileInfo of name in a read-only layer, on its copy in the read-write layer,
+// which was created with the permission bits only. The bits are taken from
+// fsx.NormalizeMode, so that only those honoured for the type of the file
+// are copied.
+func (f *filesystem) copyMode(ctx context.Context, name string, info fs.FileInfo) error {
+	mode := fsx.NormalizeMode(info.Mode())
+	if mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky) == 0 {
+		return nil
+	}
+	return contextual.Chmod(ctx, f.rw, name, mode)
+}
+
+// findRO returns the first read-only layer holding name, along with the
+// FileInfo of name in it, not following symbolic links, or nil if none does
+// or name is hidden by a whiteout of the read-write layer.
+func (f *filesystem) findRO(ctx context.Context, name string) (contextual.FS, fs.FileInfo) {
+	if f.hidden(ctx, name) {
+		return nil, nil
+	}
+	v := f.view(ctx)
+	for i, ro := range v.ro {
+		if info, err := contextual.Lstat(ctx, ro, name); err == nil {
+			return ro, info
+		}
+		if v.hiddenBelow(ctx, i, name) {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// copyTreeToRW copies the directory name and everything visible beneath it
+// in the union to the read-write layer. Directories are created first, level
+// by level, and the files are then copied by a pool of workers.
+func (f *filesystem) copyTreeToRW(ctx context.Context, name string) error {
+	var files []string
+	dirs := []string{name}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		if err := f.copyToRW(ctx, dir); err != nil {
+			return err
+		}
+		entries, err := f.ReadDir(ctx, dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			p := path.Join(dir, e.Name())
+			if e.IsDir() {
+				dirs = append(dirs, p)
+			} else {
+				files = append(files, p)
+			}
+		}
+	}
+	return f.forEach(ctx, files, f.copyToRW)
+}
+
 // OpenFile is the generalized open call. It implements Copy-on-Write: if the
 // file is opened for writing and only exists in a read-only layer, it is
-// first copied to the read-write layer.
+// first copied to the read-write layer. An exclusive creation (O_CREATE with
+// O_EXCL) fails with fs.ErrExist if the file exists in any layer, and copies
+// nothing. A file created by O_CREATE gets the directories above it copied
+// to the read-write layer, and the whiteout hiding its name, if any, is
+// removed. Files copied by copy-on-read are reopened with the flags returned
+// by reopenFlag.
 func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
-	if flag&fsx.O_ACCMODE != os.O_RDONLY || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_APPEND != 0 {
-		// Write operation
-		if err := f.copyToRW(ctx, name); err != nil && !errors.Is(err, fs.ErrNotExist) {
-			return nil, err
+	if err := f.checkOpen(name, flag); err != nil {
+		return nil, err
+	}
+	write := flag&fsx.O_ACCMODE != os.O_RDONLY || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_APPEND != 0
+	var unlock func()
+	if write {
+		ctx, unlock = f.lockTarget(ctx, name)
+	} else {
+		ctx, unlock = f.lock(ctx, f.shouldCopyOnRead(name), name)
+	}
+	defer unlock()
+
+	if write {
+		if f.sealed.Load() {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: ErrSealed}
+		}
+		defer f.changes.changed(name)
+		exclusive := flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0
+		if exclusive {
+			// The file must not exist anywhere in the union. Checking before
+			// the copy-up keeps a file of a read-only layer from being copied
+			// only to make the exclusive creation fail against the copy.
+			if _, err := f.Lstat(f.noRefetch(ctx), name); err == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, internal.Decorate("open", name, err)
+			}
+		}
+		var file fsx.File
+		if base, ok := f.appendable(ctx, name, flag); ok {
+			err := f.write(pathErr("open", name), func() (err error) {
+				file, err = f.openTail(ctx, name, base)
+				return err
+			})
+			if err != nil {
+				return nil, internal.Decorate("open", name, err)
+			}
+			return f.countWrites(file, name), nil
 		}
-		// If copyToRW returned ErrNotExist, it means it's a new file to be created in RW.
-		// If there was a whiteout, copyToRW (via Stat/isWhiteout) would have found it if we implemented it there.
-		// Actually copyToRW doesn't check whiteouts yet.
-		return contextual.OpenFile(ctx, f.rw, name, flag, mode)
+		target := name
+		created := exclusive
+		err := f.write(pathErr("open", name), func() error {
+			if !exclusive {
+				lookup := ctx
+				if flag&(os.O_CREATE|os.O_TRUNC) == os.O_CREATE|os.O_TRUNC {
+					// The contents are replaced, whatever they are.
+					lookup = f.noRefetch(ctx)
+				}
+				var err error
+				if target, err = f.copyTargetToRW(lookup, name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+				// If the copy returned ErrNotExist, it means it's a new file
+				// to be created in RW.
+				created = err != nil && flag&os.O_CREATE != 0
+			}
+			if created {
+				if err := f.copyParentToRW(ctx, target); err != nil {
+					return err
+				}
+			}
+			var err error
+			file, err = contextual.OpenFile(ctx, f.rw, target, flag, mode)
+			return err
+		})
+		if err != nil {
+			return nil, internal.Decorate("open", name, err)
+		}
+		if created {
+			// The new file replaces the one a whiteout was hiding, whose
+			// whiteout goes while name is still locked.
+			f.removeWhiteout(ctx, "create", target)
+		}
+		if target != name {
+			defer f.changes.changed(target)
+		}
+		return f.countWrites(file, name, target), nil
 	}
 
 	// Read-only open
 	file, err := contextual.OpenFile(ctx, f.rw, name, flag, mode)
 	if err == nil {
-		return file, nil
+		return f.mergeDir(ctx, name, file), nil
 	}
 	if !errors.Is(err, fs.ErrNotExist) {
-		return nil, err
+		return nil, internal.Decorate("open", name, err)
 	}
 
-	if f.isWhiteout(ctx, name) {
+	if f.hidden(ctx, name) {
 		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 	}
 
-	for _, ro := range f.ro {
+	v := f.view(ctx)
+	for i, ro := range v.ro {
 		file, err := contextual.OpenFile(ctx, ro, name, flag, mode)
 		if err == nil {
-			if f.copyOnRead {
-				_ = file.Close()
-				if err := f.copyToRW(ctx, name); err != nil {
-					return nil, err
+			if f.shouldCopyOnRead(name) {
+				copied, err := f.tryCopyOnRead(name, func() error { return f.copyToRW(ctx, name) })
+				if err != nil {
+					_ = file.Close()
+					return nil, internal.Decorate("open", name, err)
+				}
+				if copied {
+					_ = file.Close()
+					file, err := contextual.OpenFile(ctx, f.rw, name, reopenFlag(flag), mode)
+					if err != nil {
+						return nil, internal.Decorate("open", name, err)
+					}
+					return f.mergeDir(ctx, name, file), nil
 				}
-				return contextual.OpenFile(ctx, f.rw, name, flag, mode)
 			}
-			return file, nil
+			if file, err = f.stitch(ctx, name, file); err != nil {
+				return nil, internal.Decorate("open", name, err)
+			}
+			return f.follow(ctx, name, flag, f.mergeDir(ctx, name, file)), nil
 		}
 		if !errors.Is(err, fs.ErrNotExist) {
-			return nil, err
+			return nil, internal.Decorate("open", name, err)
+		}
+		if v.hiddenBelow(ctx, i, name) {
+			break
 		}
 	}
 
+	if ctx, ok := f.retry(ctx, name, false); ok {
+		return f.OpenFile(ctx, name, flag, mode)
+	}
 	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 }
 
-// Create creates the named file in the read-write layer.
+// reopenFlag returns the flag used to open a file in the read-write layer
+// after copy-on-read copied it there, given the flag of the original open.
+// The copy already exists and holds the data just read, so the flags about
+// creating the file are dropped: O_EXCL would fail against the copy, and
+// O_CREATE and O_TRUNC have nothing to do. The access mode and the other
+// flags, such as O_SYNC or O_NOFOLLOW, keep their meaning for the copy and
+// are passed as is.
+func reopenFlag(flag int) int {
+	return flag &^ (os.O_CREATE | os.O_EXCL | os.O_TRUNC)
+}
+
+// mergeDir wraps file in a mergedDir if it is a directory handle, so that
+// reading it through fs.ReadDirFile yields the merged view of all layers.
+// Other files are returned as is.
+func (f *filesystem) mergeDir(ctx context.Context, name string, file fsx.File) fsx.File {
+	if _, ok := file.(fs.ReadDirFile); !ok {
+		return file
+	}
+	info, err := file.Stat()
+	if err != nil || !info.IsDir() {
+		return file
+	}
+	return &mergedDir{File: file, fs: f, ctx: contextual.IOContext(f.unlocked(ctx)), name: name}
+}
+
+// mergedDir is a directory handle opened from one of the layers. Its ReadDir
+// method pages through the merged, whiteout-filtered listing streamed by
+// filesystem.ReadDirSeq instead of the listing of the single underlying
+// layer.
+type mergedDir struct {
+	fsx.File
+	fs *filesystem
+	// ctx is the context of the Open call. fs.ReadDirFile has no context
+	// parameter, so it is used when the listing is read.
+	ctx  context.Context
+	name string
+
+	mu sync.Mutex
+	// res is the merged listing, started on the first call to ReadDir, and
+	// next and stop pull its entries.
+	res  *contextual.Result[fs.DirEntry]
+	next func() (fs.DirEntry, bool)
+	stop func()
+}
+
+// ReadDir reads the merged contents of the directory and returns a slice of
+// up to n DirEntry values in directory order, following the fs.ReadDirFile
+// contract. The merged listing is streamed from the first call on, so only
+// the entries returned are held.
+func (d *mergedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.res == nil {
+		d.res = d.fs.ReadDirSeq(d.ctx, d.name)
+		d.next, d.stop = iter.Pull(d.res.All())
+	}
+
+	var entries []fs.DirEntry
+	for n <= 0 || len(entries) < n {
+		e, ok := d.next()
+		if !ok {
+			if err := d.res.Err(); err != nil {
+				return entries, err
+			}
+			break
+		}
+		entries = append(entries, e)
+	}
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
+
+// Close stops the listing, if any, and closes the handle.
+func (d *mergedDir) Close() error {
+	d.mu.Lock()
+	if d.stop != nil {
+		d.stop()
+	}
+	d.mu.Unlock()
+	return d.File.Close()
+}
+
+// Create creates the named file in the read-write layer, or truncates it,
+// like OpenFile with os.O_RDWR|os.O_CREATE|os.O_TRUNC. A new file gets mode
+// 0666 less the umask carried by ctx, if any, which contextual.OpenFile
+// applies, and replaces the file a whiteout was hiding, removing the
+// whiteout.
 func (f *filesystem) Create(ctx context.Context, name string) (fsx.File, error) {
 	return f.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 }
@@ -194,324 +734,731 @@ func (f *filesystem) Create(ctx context.Context, name string) (fsx.File, error)
 // read-write layer, it is removed. If it also exists in a read-only layer,
 // a whiteout file is created in the read-write layer to hide it.
 func (f *filesystem) Remove(ctx context.Context, name string) error {
-	// If it exists in RW, remove it.
-	err := contextual.Remove(ctx, f.rw, name)
-	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+	if err := f.checkPath("remove", name); err != nil {
 		return err
 	}
-
-	// Check if it exists in RO
-	inRO := false
-	for _, ro := range f.ro {
-		if _, err := contextual.Stat(ctx, ro, name); err == nil {
-			inRO = true
-			break
+	ctx, unlock := f.lock(ctx, true, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	return f.write(pathErr("remove", name), func() error {
+		// If it exists in RW, remove it.
+		err := contextual.Remove(ctx, f.rw, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			if err := f.removeControls(ctx, name); err != nil {
+				return err
+			}
 		}
-	}
 
-	if inRO {
-		return f.createWhiteout(ctx, name)
-	}
+		// Check if it exists in RO
+		if f.inRO(ctx, name) {
+			return f.createWhiteout(ctx, "remove", name)
+		}
 
-	return err // Return original Remove error if not in RO
+		return err // Return original Remove error if not in RO
+	})
 }
 
 // Stat returns FileInfo describing the named file. It checks the read-write
 // layer first, then considers whiteouts, and finally checks read-only layers.
 func (f *filesystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := f.checkPath("stat", name); err != nil {
+		return nil, err
+	}
+	ctx, unlock := f.lock(ctx, false, name)
+	defer unlock()
+
 	info, err := contextual.Stat(ctx, f.rw, name)
 	if err == nil {
 		return info, nil
 	}
 	if !errors.Is(err, fs.ErrNotExist) {
-		return nil, err
+		return nil, internal.Decorate("stat", name, err)
 	}
 
-	if f.isWhiteout(ctx, name) {
+	if f.hidden(ctx, name) {
 		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
 	}
 
-	for _, ro := range f.ro {
+	v := f.view(ctx)
+	for i, ro := range v.ro {
 		info, err := contextual.Stat(ctx, ro, name)
 		if err == nil {
-			return info, nil
+			info, err = f.withTail(ctx, name, info)
+			return info, internal.Decorate("stat", name, err)
 		}
 		if !errors.Is(err, fs.ErrNotExist) {
-			return nil, err
+			return nil, internal.Decorate("stat", name, err)
+		}
+		if v.hiddenBelow(ctx, i, name) {
+			break
 		}
 	}
 
+	if ctx, ok := f.retry(ctx, name, false); ok {
+		return f.Stat(ctx, name)
+	}
 	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
 }
 
 // ReadDir reads the named directory and returns a list of directory entries
 // sorted by name. It merges entries from all layers and filters out whiteouts.
+// When a name exists in several layers, the entry of the upper layer wins.
 func (f *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
-	entries := make(map[string]fs.DirEntry)
-	whiteouts := make(map[string]bool)
+	if err := f.checkPath("readdir", name); err != nil {
+		return nil, err
+	}
+	ctx, unlock := f.lock(ctx, false, name)
+	defer unlock()
 
-	rwEntries, err := contextual.ReadDir(ctx, f.rw, name)
-	if err == nil {
-		for _, e := range rwEntries {
-			if after, found := strings.CutPrefix(e.Name(), ".wh."); found {
-				whiteouts[after] = true
-				continue
-			}
-			entries[e.Name()] = e
+	return f.readDir(ctx, name, func(layer contextual.FS) ([]fs.DirEntry, error) {
+		return contextual.ReadDir(ctx, layer, name)
+	})
+}
+
+// ReadDirInfos reads the named directory and returns its entries with their
+// FileInfo, sorted by name. It merges the layers like ReadDir, listing each
+// of them with contextual.ReadDirInfos, so that layers implementing
+// contextual.ReadDirInfoFS are not stat'ed entry by entry.
+func (f *filesystem) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	if err := f.checkPath("readdir", name); err != nil {
+		return nil, err
+	}
+	ctx, unlock := f.lock(ctx, false, name)
+	defer unlock()
+
+	entries, err := f.readDir(ctx, name, func(layer contextual.FS) ([]fs.DirEntry, error) {
+		infos, err := contextual.ReadDirInfos(ctx, layer, name)
+		entries := make([]fs.DirEntry, len(infos))
+		for i, info := range infos {
+			entries[i] = info
 		}
-	} else if !errors.Is(err, fs.ErrNotExist) {
+		return entries, err
+	})
+	if err != nil {
 		return nil, err
 	}
+	infos := make([]contextual.FileInfoEntry, 0, len(entries))
+	for _, e := range entries {
+		if info, ok := e.(contextual.FileInfoEntry); ok {
+			infos = append(infos, info)
+			continue
+		}
+		// An entry rebuilt while merging the layers is described anew.
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, internal.Decorate("readdir", name, err)
+		}
+		infos = append(infos, contextual.FileInfoEntry{FileInfo: info})
+	}
+	return infos, nil
+}
 
-	for _, ro := range f.ro {
-		roEntries, err := contextual.ReadDir(ctx, ro, name)
-		if err == nil {
-			for _, e := range roEntries {
-				if whiteouts[e.Name()] {
-					continue
-				}
-				if _, ok := entries[e.Name()]; !ok {
-					entries[e.Name()] = e
-				}
+// readDir merges the listings of name in every layer, returned by list,
+// filtering out whiteouts and control files.
+func (f *filesystem) readDir(ctx context.Context, name string, list func(layer contextual.FS) ([]fs.DirEntry, error)) ([]fs.DirEntry, error) {
+	var merged []fs.DirEntry
+	whiteouts := make(map[string]bool)
+
+	rwEntries, err := list(f.rw)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, internal.Decorate("readdir", name, err)
+	}
+	// A dedicated store may hold whiteouts for a directory that is missing
+	// from the read-write layer.
+	hidden, hiddenErr := f.meta.whiteouts(ctx, f.rw, name, rwEntries)
+	if hiddenErr != nil {
+		return nil, internal.Decorate("readdir", name, hiddenErr)
+	}
+	for _, h := range hidden {
+		whiteouts[h] = true
+	}
+	tails := make(map[string]bool)
+	if f.appendOverlay {
+		names, err := f.meta.controls(ctx, f.rw, name, rwEntries, appendPrefix)
+		if err != nil {
+			return nil, internal.Decorate("readdir", name, err)
+		}
+		for _, n := range names {
+			tails[n] = true
+		}
+	}
+	merged = fsx.FilterDirEntries(rwEntries, func(e fs.DirEntry) bool {
+		return !f.meta.isControl(name, e.Name())
+	})
+
+	v := f.view(ctx)
+	if f.hidden(ctx, name) {
+		// The directory of the read-write layer, if any, replaces the
+		// whited out one.
+		v = view{}
+	}
+	for i, ro := range v.ro {
+		roEntries, err := list(ro)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, internal.Decorate("readdir", name, err)
+		}
+		// Control files of a flattened union: whiteouts hide entries of the
+		// layers below, and are never listed.
+		var hidden []string
+		m := v.whiteouts[i]
+		if m != nil {
+			if hidden, err = m.whiteouts(ctx, ro, name, roEntries); err != nil {
+				return nil, internal.Decorate("readdir", name, err)
 			}
-		} else if !errors.Is(err, fs.ErrNotExist) {
-			return nil, err
+		}
+		roEntries = fsx.FilterDirEntries(roEntries, func(e fs.DirEntry) bool {
+			return !whiteouts[e.Name()] && (m == nil || !m.isControl(name, e.Name())) &&
+				!f.reserved(e.Name())
+		})
+		merged = append(merged, f.appendTails(ctx, name, roEntries, tails)...)
+		for _, h := range hidden {
+			whiteouts[h] = true
 		}
 	}
 
-	if len(entries) == 0 && len(whiteouts) == 0 && err != nil {
+	if len(merged) == 0 && len(whiteouts) == 0 && err != nil {
 		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
 	}
 
-	var list []fs.DirEntry
-	for _, e := range entries {
-		list = append(list, e)
-	}
-	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
-	return list, nil
+	return contextual.NormalizeDirEntries(merged, contextual.Deduplicate()), nil
 }
 
 // Mkdir creates a new directory in the read-write layer.
 func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
-	if err := contextual.Mkdir(ctx, f.rw, name, perm); err != nil {
+	if err := f.checkPath("mkdir", name); err != nil {
+		return err
+	}
+	ctx, unlock := f.lock(ctx, true, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	if err := f.write(pathErr("mkdir", name), func() error {
+		if err := contextual.Mkdir(ctx, f.rw, name, perm); err != nil {
+			return err
+		}
+		return f.replaceWhiteout(ctx, name)
+	}); err != nil {
 		return err
 	}
 	// Remove whiteout if any, since we've just created the directory
-	dir, file := path.Split(name)
-	wh := path.Join(dir, ".wh."+file)
-	_ = contextual.Remove(ctx, f.rw, wh)
+	f.removeWhiteout(ctx, "mkdir", name)
 	return nil
 }
 
 // MkdirAll creates a directory and all necessary parents in the read-write layer.
 func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
-	if err := contextual.MkdirAll(ctx, f.rw, name, perm); err != nil {
+	if err := f.checkPath("mkdir", name); err != nil {
+		return err
+	}
+	ctx, unlock := f.lock(ctx, true, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	if err := f.write(pathErr("mkdir", name), func() error {
+		if err := contextual.MkdirAll(ctx, f.rw, name, perm); err != nil {
+			return err
+		}
+		return f.replaceWhiteout(ctx, name)
+	}); err != nil {
 		return err
 	}
 	// Remove whiteout if any
-	dir, file := path.Split(name)
-	wh := path.Join(dir, ".wh."+file)
-	_ = contextual.Remove(ctx, f.rw, wh)
+	f.removeWhiteout(ctx, "mkdir", name)
 	return nil
 }
 
+// replaceWhiteout whites out the files of the read-only layers in the
+// directory name, just created in the read-write layer where a whiteout hid
+// a directory, so that they do not show through once the whiteout is
+// removed. Directories created beneath a whited out one keep its whiteout,
+// which hides the read-only layers from them.
+func (f *filesystem) replaceWhiteout(ctx context.Context, name string) error {
+	if !f.isWhiteout(ctx, name) {
+		return nil
+	}
+	return f.hideLowerChildren(ctx, "mkdir", name)
+}
+
 // RemoveAll removes path and any children it contains from the read-write layer.
-// If the path exists in a read-only layer, a whiteout is created.
+// If the path exists in a read-only layer, a whiteout is created, which hides
+// the children of a directory too.
 func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
-	// This is tricky for unionfs. For now, just remove from RW and whiteout if needed.
-	// Properly removing all in unionfs usually requires whiteouting the directory itself.
-	if err := contextual.RemoveAll(ctx, f.rw, name); err != nil {
+	if err := f.checkPath("removeall", name); err != nil {
 		return err
 	}
+	ctx, unlock := f.lock(ctx, true, name)
+	defer unlock()
+	defer f.changes.changed(name)
 
-	inRO := false
-	for _, ro := range f.ro {
-		if _, err := contextual.Stat(ctx, ro, name); err == nil {
-			inRO = true
-			break
+	return f.write(pathErr("removeall", name), func() error {
+		if err := f.removeAllRW(ctx, name); err != nil {
+			return err
 		}
+		if err := f.removeControls(ctx, name); err != nil {
+			return err
+		}
+
+		if f.inRO(ctx, name) {
+			return f.createWhiteout(ctx, "removeall", name)
+		}
+		return nil
+	})
+}
+
+// removeAllRW removes name and any children it contains from the read-write
+// layer. The layer's own RemoveAll is preferred; otherwise the children of
+// name are removed by a pool of workers.
+func (f *filesystem) removeAllRW(ctx context.Context, name string) error {
+	if rfs, ok := f.rw.(contextual.RemoveAllFS); ok {
+		return rfs.RemoveAll(ctx, name)
+	}
+
+	err := contextual.Remove(ctx, f.rw, name)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return nil
 	}
-	if inRO {
-		return f.createWhiteout(ctx, name)
+
+	entries, readErr := contextual.ReadDir(ctx, f.rw, name)
+	if readErr != nil {
+		return err
 	}
-	return nil
+	children := make([]string, len(entries))
+	for i, e := range entries {
+		children[i] = path.Join(name, e.Name())
+	}
+	if err := f.forEach(ctx, children, func(ctx context.Context, name string) error {
+		return contextual.RemoveAll(ctx, f.rw, name)
+	}); err != nil {
+		return err
+	}
+
+	return contextual.Remove(ctx, f.rw, name)
 }
 
 // Rename renames a file. If the file exists in a read-only layer, it is first
 // copied to the read-write layer, then renamed there, and a whiteout is
-// created for the old name.
+// created for the old name. Directories from read-only layers are copied
+// recursively. See SetStrictRename for the handling of an existing target.
 func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
-	// Check if oldname exists in union
-	if _, err := f.Stat(ctx, oldname); err != nil {
+	if err := f.checkNames("rename", oldname, newname); err != nil {
 		return err
 	}
+	ctx, unlock := f.lock(ctx, true, oldname, newname)
+	defer unlock()
+	defer f.changes.changed(oldname, newname)
 
-	// If oldname is in RO, we need a whiteout after rename
-	inRO := false
-	for _, ro := range f.ro {
-		if _, err := contextual.Stat(ctx, ro, oldname); err == nil {
-			inRO = true
-			break
+	// Check if oldname exists in union
+	info, err := f.Stat(ctx, oldname)
+	if err != nil {
+		return internal.DecorateLink("rename", oldname, newname, err)
+	}
+
+	var target fs.FileInfo
+	if f.strictRename {
+		if oldname == newname {
+			return nil
+		}
+		if target, err = f.checkRenameTarget(ctx, info, oldname, newname); err != nil {
+			return internal.DecorateLink("rename", oldname, newname, err)
 		}
 	}
 
-	if err := f.copyToRW(ctx, oldname); err != nil {
+	return f.write(linkErr("rename", oldname, newname), func() error {
+		// If oldname is in RO, we need a whiteout after rename
+		inRO := f.inRO(ctx, oldname)
+
+		copyUp := f.copyToRW
+		if inRO && info.IsDir() {
+			copyUp = f.copyTreeToRW
+		}
+		if err := copyUp(ctx, oldname); err != nil {
+			return err
+		}
+		if f.strictRename {
+			if parent := path.Dir(newname); parent != "." {
+				if err := f.copyToRW(ctx, parent); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+		}
+		targetInRO := target != nil && f.inRO(ctx, newname)
+		rename := func() error { return contextual.Rename(ctx, f.rw, oldname, newname) }
+		if target != nil && target.IsDir() {
+			rename = func() error { return f.replaceDir(ctx, oldname, newname) }
+		}
+		if err := rename(); err != nil {
+			return err
+		}
+		if !f.meta.inline() && info.IsDir() {
+			if err := f.moveControls(ctx, oldname, newname); err != nil {
+				return err
+			}
+		}
+
+		if inRO {
+			if err := f.createWhiteout(ctx, "rename", oldname); err != nil {
+				return err
+			}
+		}
+		if targetInRO {
+			// The new file is in place, so the whiteout is never observed
+			// without it.
+			if err := f.createWhiteout(ctx, "rename", newname); err != nil {
+				return err
+			}
+			if target.IsDir() {
+				return f.hideLowerChildren(ctx, "rename", newname)
+			}
+		}
+		return nil
+	})
+}
+
+// Symlink creates newname as a symbolic link to oldname in the read-write layer.
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	if err := f.checkNames("symlink", oldname, newname); err != nil {
 		return err
 	}
-	if err := contextual.Rename(ctx, f.rw, oldname, newname); err != nil {
-		return err
+	if !f.noLinks.Load() {
+		if err := f.symlink(ctx, oldname, newname); !f.linksUnsupported(err) {
+			return err
+		}
 	}
+	return f.symlinkLinkless(ctx, oldname, newname)
+}
+
+// symlink is Symlink for a read-write layer that holds links.
+func (f *filesystem) symlink(ctx context.Context, oldname, newname string) error {
+	ctx, unlock := f.lock(ctx, true, newname)
+	defer unlock()
+	defer f.changes.changed(newname)
 
-	if inRO {
-		return f.createWhiteout(ctx, oldname)
+	if err := f.write(linkErr("symlink", oldname, newname), func() error {
+		return contextual.Symlink(ctx, f.rw, oldname, newname)
+	}); err != nil {
+		return err
 	}
+	f.removeWhiteout(ctx, "symlink", newname)
 	return nil
 }
 
-// Symlink creates newname as a symbolic link to oldname in the read-write layer.
-func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
-	if err := contextual.Symlink(ctx, f.rw, oldname, newname); err != nil {
+// CreateSpecial creates a special file in the read-write layer, removing any
+// whiteout that hid a file of the same name.
+func (f *filesystem) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	if err := f.checkPath("mknod", name); err != nil {
 		return err
 	}
-	dir, file := path.Split(newname)
-	wh := path.Join(dir, ".wh."+file)
-	_ = contextual.Remove(ctx, f.rw, wh)
+	ctx, unlock := f.lock(ctx, true, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	if err := f.write(pathErr("mknod", name), func() error {
+		return contextual.CreateSpecial(ctx, f.rw, name, mode, dev)
+	}); err != nil {
+		return err
+	}
+	f.removeWhiteout(ctx, "mknod", name)
 	return nil
 }
 
 // ReadLink returns the destination of the named symbolic link.
 func (f *filesystem) ReadLink(ctx context.Context, name string) (string, error) {
-	l, err := contextual.ReadLink(ctx, f.rw, name)
+	if err := f.checkPath("readlink", name); err != nil {
+		return "", err
+	}
+	ctx, unlock := f.lock(ctx, false, name)
+	defer unlock()
+
+	var l string
+	var err error
+	if f.noLinks.Load() {
+		err = f.readLinkless(ctx, name)
+	} else {
+		l, err = contextual.ReadLink(ctx, f.rw, name)
+	}
 	if err == nil {
-		return l, nil
+		return f.checkLink(ctx, name, l)
 	}
 	if !errors.Is(err, fs.ErrNotExist) {
-		return "", err
+		return "", internal.Decorate("readlink", name, err)
 	}
 
-	if f.isWhiteout(ctx, name) {
+	if f.hidden(ctx, name) {
 		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
 	}
 
-	for _, ro := range f.ro {
+	v := f.view(ctx)
+	for i, ro := range v.ro {
 		l, err := contextual.ReadLink(ctx, ro, name)
 		if err == nil {
-			return l, nil
+			return f.checkLink(ctx, name, l)
 		}
 		if !errors.Is(err, fs.ErrNotExist) {
-			return "", err
+			return "", internal.Decorate("readlink", name, err)
+		}
+		if v.hiddenBelow(ctx, i, name) {
+			break
 		}
 	}
 
+	if ctx, ok := f.retry(ctx, name, false); ok {
+		return f.ReadLink(ctx, name)
+	}
 	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
 }
 
 // Lstat returns FileInfo describing the named file. If the file is a
 // symbolic link, the returned FileInfo describes the symbolic link.
 func (f *filesystem) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if err := f.checkPath("lstat", name); err != nil {
+		return nil, err
+	}
+	ctx, unlock := f.lock(ctx, false, name)
+	defer unlock()
+
 	info, err := contextual.Lstat(ctx, f.rw, name)
 	if err == nil {
 		return info, nil
 	}
 	if !errors.Is(err, fs.ErrNotExist) {
-		return nil, err
+		return nil, internal.Decorate("lstat", name, err)
 	}
 
-	if f.isWhiteout(ctx, name) {
+	if f.hidden(ctx, name) {
 		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
 	}
 
-	for _, ro := range f.ro {
+	v := f.view(ctx)
+	for i, ro := range v.ro {
 		info, err := contextual.Lstat(ctx, ro, name)
 		if err == nil {
-			return info, nil
+			info, err = f.withTail(ctx, name, info)
+			return info, internal.Decorate("lstat", name, err)
 		}
 		if !errors.Is(err, fs.ErrNotExist) {
-			return nil, err
+			return nil, internal.Decorate("lstat", name, err)
+		}
+		if v.hiddenBelow(ctx, i, name) {
+			break
 		}
 	}
 
+	if ctx, ok := f.retry(ctx, name, false); ok {
+		return f.Lstat(ctx, name)
+	}
 	return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
 }
 
 // Lchown changes the numeric uid and gid of the named file. If the file is
-// in a read-only layer, it is first copied to the read-write layer.
+// in a read-only layer, it is first copied to the read-write layer; a
+// symbolic link is copied as a link, leaving its destination alone.
 func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
-	if err := f.copyToRW(ctx, name); err != nil {
+	if err := f.checkPath("lchown", name); err != nil {
 		return err
 	}
-	return contextual.Lchown(ctx, f.rw, name, owner, group)
+	ctx, unlock := f.lock(ctx, true, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	return f.write(pathErr("lchown", name), func() error {
+		if err := f.copyToRW(ctx, name); err != nil {
+			return err
+		}
+		return contextual.Lchown(ctx, f.rw, name, owner, group)
+	})
 }
 
 // Truncate changes the size of the named file. If the file is in a
-// read-only layer, it is first copied to the read-write layer.
+// read-only layer, it is first copied to the read-write layer. Symbolic
+// links are followed through the union, and only the file they refer to is
+// truncated; see copyTargetToRW.
 func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
-	if err := f.copyToRW(ctx, name); err != nil {
+	if err := f.checkPath("truncate", name); err != nil {
 		return err
 	}
-	return contextual.Truncate(ctx, f.rw, name, size)
+	ctx, unlock := f.lockTarget(ctx, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	return f.write(pathErr("truncate", name), func() error {
+		target, err := f.copyTargetToRW(ctx, name)
+		if err != nil {
+			return err
+		}
+		if target != name {
+			defer f.changes.changed(target)
+		}
+		return contextual.Truncate(ctx, f.rw, target, size)
+	})
 }
 
 // WriteFile writes data to a file in the read-write layer.
 func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
-	return contextual.WriteFile(ctx, f.rw, name, data, perm)
+	if err := f.checkPath("writefile", name); err != nil {
+		return err
+	}
+	ctx, unlock := f.lock(ctx, true, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	return f.write(pathErr("writefile", name), func() error {
+		if err := contextual.WriteFile(ctx, f.rw, name, data, perm); err != nil {
+			return err
+		}
+		f.counters.writtenBytes.Add(int64(len(data)))
+		return nil
+	})
 }
 
 // Chown changes the numeric uid and gid of the named file. If the file is
-// in a read-only layer, it is first copied to the read-write layer.
+// in a read-only layer, it is first copied to the read-write layer. Symbolic
+// links are followed like Truncate does.
 func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
-	if err := f.copyToRW(ctx, name); err != nil {
+	if err := f.checkPath("chown", name); err != nil {
 		return err
 	}
-	return contextual.Chown(ctx, f.rw, name, owner, group)
+	ctx, unlock := f.lockTarget(ctx, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	return f.write(pathErr("chown", name), func() error {
+		target, err := f.copyTargetToRW(ctx, name)
+		if err != nil {
+			return err
+		}
+		if target != name {
+			defer f.changes.changed(target)
+		}
+		return contextual.Chown(ctx, f.rw, target, owner, group)
+	})
 }
 
 // Chmod changes the mode of the named file. If the file is in a
-// read-only layer, it is first copied to the read-write layer.
+// read-only layer, it is first copied to the read-write layer. Symbolic
+// links are followed like Truncate does.
 func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
-	if err := f.copyToRW(ctx, name); err != nil {
+	if err := f.checkPath("chmod", name); err != nil {
 		return err
 	}
-	return contextual.Chmod(ctx, f.rw, name, mode)
+	ctx, unlock := f.lockTarget(ctx, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	return f.write(pathErr("chmod", name), func() error {
+		target, err := f.copyTargetToRW(ctx, name)
+		if err != nil {
+			return err
+		}
+		if target != name {
+			defer f.changes.changed(target)
+		}
+		return contextual.Chmod(ctx, f.rw, target, mode)
+	})
 }
 
 // Chtimes changes the access and modification times of the named file.
 // If the file is in a read-only layer, it is first copied to the read-write layer.
+// Symbolic links are followed like Truncate does.
 func (f *filesystem) Chtimes(ctx context.Context, name string, atime, ctime time.Time) error {
-	if err := f.copyToRW(ctx, name); err != nil {
+	if err := f.checkPath("chtimes", name); err != nil {
 		return err
 	}
-	return contextual.Chtimes(ctx, f.rw, name, atime, ctime)
+	ctx, unlock := f.lockTarget(ctx, name)
+	defer unlock()
+	defer f.changes.changed(name)
+
+	return f.write(pathErr("chtimes", name), func() error {
+		target, err := f.copyTargetToRW(ctx, name)
+		if err != nil {
+			return err
+		}
+		if target != name {
+			defer f.changes.changed(target)
+		}
+		return contextual.Chtimes(ctx, f.rw, target, atime, ctime)
+	})
 }
 
 // ReadFile reads the named file and returns its contents. It checks the
 // read-write layer first, then the read-only layers.
 func (f *filesystem) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if err := f.checkPath("readfile", name); err != nil {
+		return nil, err
+	}
+	ctx, unlock := f.lock(ctx, f.shouldCopyOnRead(name), name)
+	defer unlock()
+
 	data, err := contextual.ReadFile(ctx, f.rw, name)
 	if err == nil {
 		return data, nil
 	}
 	if !errors.Is(err, fs.ErrNotExist) {
-		return nil, err
+		return nil, internal.Decorate("readfile", name, err)
 	}
 
-	for _, ro := range f.ro {
+	if f.hidden(ctx, name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if f.shouldCopyOnRead(name) {
+		// Stream regular files to the read-write layer and read the copy,
+		// rather than writing back the contents read from their layer.
+		if info, err := f.Stat(ctx, name); err == nil && info.Mode().IsRegular() {
+			copied, err := f.tryCopyOnRead(name, func() error { return f.copyToRW(ctx, name) })
+			if err != nil {
+				return nil, internal.Decorate("readfile", name, err)
+			}
+			if copied {
+				data, err := contextual.ReadFile(ctx, f.rw, name)
+				return data, internal.Decorate("readfile", name, err)
+			}
+		}
+	}
+
+	v := f.view(ctx)
+	for i, ro := range v.ro {
 		data, err := contextual.ReadFile(ctx, ro, name)
 		if err == nil {
-			if f.copyOnRead {
-				if err := f.WriteFile(ctx, name, data, 0666); err != nil {
-					return nil, err
-				}
-			}
-			return data, nil
+			data, err = f.stitchData(ctx, name, data)
+			return data, internal.Decorate("readfile", name, err)
 		}
 		if !errors.Is(err, fs.ErrNotExist) {
-			return nil, err
+			return nil, internal.Decorate("readfile", name, err)
+		}
+		if v.hiddenBelow(ctx, i, name) {
+			break
 		}
 	}
 
+	if ctx, ok := f.retry(ctx, name, false); ok {
+		return f.ReadFile(ctx, name)
+	}
 	return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
 }
 
+// Close closes every layer of the union, and the stores set with
+// SetMetadataStore, and returns their errors joined together with
+// errors.Join. Layers shared with other unions are closed too.
+func (f *filesystem) Close() error {
+	errs := []error{contextual.Close(f.rw), f.meta.close()}
+	for i, ro := range f.ro {
+		errs = append(errs, contextual.Close(ro))
+		if m := f.whiteouts[i]; m != nil {
+			errs = append(errs, m.close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // Compile-time interface checks
 var _ contextual.FileSystem = &filesystem{}
+var _ contextual.ReadDirInfoFS = &filesystem{}
+var _ contextual.SpecialFS = &filesystem{}
+var _ contextual.CloserFS = &filesystem{}
@@ -4,11 +4,18 @@
 package unionfs
 
 import (
+	"context"
 	"errors"
 	"io/fs"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/memfs"
 	"github.com/gwangyi/fsx/mockfs"
 	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
 	"go.uber.org/mock/gomock"
@@ -24,7 +31,8 @@ func TestFS_copyToRW(t *testing.T) {
 		// Open() with CopyOnRead calls copyToRW
 		SetCopyOnRead(f, true)
 
-		rw.EXPECT().Stat(t.Context(), "dir").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Lstat(t.Context(), "dir").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.dir").Return(nil, fs.ErrNotExist)
 
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
 		mockInfo.EXPECT().IsDir().Return(true).AnyTimes()
@@ -48,7 +56,9 @@ func TestFS_copyToRW(t *testing.T) {
 		// Open() with CopyOnRead calls copyToRW
 		SetCopyOnRead(f, true)
 
-		rw.EXPECT().Stat(t.Context(), "dir/test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Lstat(t.Context(), "dir/test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), "dir/.wh.test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.dir").Return(nil, fs.ErrNotExist)
 
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
 		mockInfo.EXPECT().IsDir().Return(false).AnyTimes()
@@ -73,15 +83,21 @@ func TestFS_copyToRW(t *testing.T) {
 		// Open() with CopyOnRead calls copyToRW
 		SetCopyOnRead(f, true)
 
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
 		mockInfo.EXPECT().IsDir().Return(false).AnyTimes()
 		mockInfo.EXPECT().Mode().Return(fs.FileMode(0644)).AnyTimes()
 		ro.EXPECT().Stat(t.Context(), "test.txt").Return(mockInfo, nil)
 
+		// The scratch copy is created first, and removed once the source fails.
+		rwFile := mockfs.NewMockFile(ctrl)
+		rw.EXPECT().OpenFile(t.Context(), scratchOf("test.txt"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(0644)).Return(rwFile, nil)
+		rwFile.EXPECT().Close().Return(nil)
 		expectedErr := errors.New("open failed")
 		ro.EXPECT().Open(t.Context(), "test.txt").Return(nil, expectedErr)
+		rw.EXPECT().Remove(gomock.Any(), scratchOf("test.txt")).Return(nil)
 
 		err := f.copyToRW(t.Context(), "test.txt")
 		if !errors.Is(err, expectedErr) {
@@ -98,19 +114,16 @@ func TestFS_copyToRW(t *testing.T) {
 		// Open() with CopyOnRead calls copyToRW
 		SetCopyOnRead(f, true)
 
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
 		mockInfo.EXPECT().IsDir().Return(false).AnyTimes()
 		mockInfo.EXPECT().Mode().Return(fs.FileMode(0644)).AnyTimes()
 		ro.EXPECT().Stat(t.Context(), "test.txt").Return(mockInfo, nil)
 
-		roFile := mockfs.NewMockFile(ctrl)
-		ro.EXPECT().Open(t.Context(), "test.txt").Return(roFile, nil)
-		roFile.EXPECT().Close().Return(nil)
-
 		expectedErr := errors.New("openfile failed")
-		rw.EXPECT().OpenFile(t.Context(), "test.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fs.FileMode(0644)).Return(nil, expectedErr)
+		rw.EXPECT().OpenFile(t.Context(), scratchOf("test.txt"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(0644)).Return(nil, expectedErr)
 
 		err := f.copyToRW(t.Context(), "test.txt")
 		if !errors.Is(err, expectedErr) {
@@ -118,3 +131,245 @@ func TestFS_copyToRW(t *testing.T) {
 		}
 	})
 }
+
+func TestFS_forEach(t *testing.T) {
+	t.Run("bounded and joins errors", func(t *testing.T) {
+		f := New(nil)
+		SetConcurrency(f, 2)
+
+		var mu sync.Mutex
+		running, peak := 0, 0
+		errA := errors.New("a failed")
+		errC := errors.New("c failed")
+		err := f.forEach(t.Context(), []string{"a", "b", "c", "d"}, func(ctx context.Context, name string) error {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			switch name {
+			case "a":
+				return errA
+			case "c":
+				return errC
+			}
+			return nil
+		})
+		if !errors.Is(err, errA) || !errors.Is(err, errC) {
+			t.Errorf("expected joined errors, got %v", err)
+		}
+		if peak > 2 {
+			t.Errorf("expected at most 2 concurrent calls, got %d", peak)
+		}
+	})
+
+	t.Run("stops on canceled context", func(t *testing.T) {
+		f := New(nil)
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		called := false
+		err := f.forEach(ctx, []string{"a"}, func(ctx context.Context, name string) error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		if called {
+			t.Error("expected no work to be started")
+		}
+	})
+}
+
+func TestFS_readOnlyLayers(t *testing.T) {
+	ctx := t.Context()
+	ctrl := gomock.NewController(t)
+	rw := cmockfs.NewMockFileSystem(ctrl)
+	// The read-only layer is writable, as an osfs passed by mistake would be.
+	ro := cmockfs.NewMockFileSystem(ctrl)
+	f := New(rw, New(rw, ro))
+
+	if layers := f.Unwrap(); len(layers) != 3 || layers[1] != rw || layers[2] != ro {
+		t.Errorf("Unwrap() = %v; want the layers as given", layers)
+	}
+	if _, ok := f.ro[1].(readOnlyFile); !ok {
+		t.Errorf("expected a ReadFileFS layer to stay one, got %T", f.ro[1])
+	}
+	for i, layer := range f.ro {
+		for _, err := range []error{
+			contextual.WriteFile(ctx, layer, "file", nil, 0644),
+			contextual.Remove(ctx, layer, "file"),
+			func() error { _, err := contextual.Create(ctx, layer, "file"); return err }(),
+			func() error { _, err := contextual.OpenFile(ctx, layer, "file", os.O_WRONLY, 0); return err }(),
+			func() error {
+				_, err := contextual.OpenFile(ctx, layer, "file", os.O_RDONLY|os.O_CREATE, 0644)
+				return err
+			}(),
+			contextual.Mkdir(ctx, layer, "dir", 0755),
+		} {
+			if !errors.Is(err, fs.ErrPermission) && !errors.Is(err, errors.ErrUnsupported) {
+				t.Errorf("layer %d: expected writes to be refused, got %v", i, err)
+			}
+			if errors.Is(err, fs.ErrPermission) && !fsx.IsReadOnly(err) {
+				t.Errorf("layer %d: expected fsx.ErrReadOnly, got %v", i, err)
+			}
+		}
+	}
+
+	// Reads go through.
+	file := mockfs.NewMockFile(ctrl)
+	ro.EXPECT().OpenFile(ctx, "file", os.O_RDONLY, fs.FileMode(0)).Return(file, nil)
+	if got, err := contextual.OpenFile(ctx, f.ro[1], "file", os.O_RDONLY, 0); err != nil || got != file {
+		t.Errorf("OpenFile() = %v, %v; want the file of the layer", got, err)
+	}
+	ro.EXPECT().ReadFile(ctx, "file").Return([]byte("data"), nil)
+	if data, err := contextual.ReadFile(ctx, f.ro[1], "file"); err != nil || string(data) != "data" {
+		t.Errorf("ReadFile() = %q, %v; want data", data, err)
+	}
+}
+
+// scratchOf matches the names of the scratch files name is copied up
+// through.
+func scratchOf(name string) gomock.Matcher {
+	return gomock.Cond(func(s string) bool {
+		return isScratch(s) && strings.HasSuffix(s, "."+name)
+	})
+}
+
+// richLayer is a layer implementing the optional interfaces a readOnly
+// forwards.
+type richLayer struct {
+	contextual.FileSystem
+	pages int
+}
+
+func (l *richLayer) ReadDirPage(ctx context.Context, name, cursor string, n int) ([]fs.DirEntry, string, error) {
+	l.pages++
+	return contextual.ReadDirPage(ctx, l.FileSystem, name, cursor, n)
+}
+
+func (l *richLayer) ETag(ctx context.Context, name string) (string, error) {
+	return "etag", nil
+}
+
+func (l *richLayer) GetXattr(ctx context.Context, name, attr string) ([]byte, error) {
+	return []byte("value"), nil
+}
+
+func (l *richLayer) ListXattr(ctx context.Context, name string) ([]string, error) {
+	return []string{"user.a"}, nil
+}
+
+func (l *richLayer) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	return nil
+}
+
+func (l *richLayer) RemoveXattr(ctx context.Context, name, attr string) error {
+	return nil
+}
+
+func TestFS_readOnlyLayerInterfaces(t *testing.T) {
+	ctx := t.Context()
+	ctrl := gomock.NewController(t)
+	inner := cmockfs.NewMockFileSystem(ctrl)
+	layer := &richLayer{FileSystem: inner}
+	plain := cmockfs.NewMockFileSystem(ctrl)
+	f := New(cmockfs.NewMockFileSystem(ctrl), layer, plain)
+	ro := f.ro[0]
+
+	inner.EXPECT().ReadDir(ctx, "dir").Return(nil, nil).AnyTimes()
+	res := contextual.ReadDirSeq(ctx, ro, "dir", contextual.PageSize(2))
+	for range res.All() {
+	}
+	if err := res.Err(); err != nil || layer.pages == 0 {
+		t.Errorf("ReadDirSeq() error = %v after %d pages; want the layer read a page at a time", err, layer.pages)
+	}
+	if etag, err := ro.(contextual.ETagFS).ETag(ctx, "file"); err != nil || etag != "etag" {
+		t.Errorf("ETag() = %q, %v; want the ETag of the layer", etag, err)
+	}
+	if _, err := f.ro[1].(contextual.ETagFS).ETag(ctx, "file"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ETag() of a layer without ETags error = %v; want ErrUnsupported", err)
+	}
+	if value, err := contextual.GetXattr(ctx, ro, "file", "user.a"); err != nil || string(value) != "value" {
+		t.Errorf("GetXattr() = %q, %v; want the value of the layer", value, err)
+	}
+	if names, err := contextual.ListXattr(ctx, ro, "file"); err != nil || len(names) != 1 {
+		t.Errorf("ListXattr() = %q, %v; want the names of the layer", names, err)
+	}
+	for _, err := range []error{
+		contextual.SetXattr(ctx, ro, "file", "user.a", nil, 0),
+		contextual.RemoveXattr(ctx, ro, "file", "user.a"),
+		contextual.Access(ctx, ro, "file", fsx.W_OK),
+	} {
+		if !errors.Is(err, fsx.ErrReadOnly) {
+			t.Errorf("expected the change to be refused, got %v", err)
+		}
+	}
+	info := mockfs.NewMockFileInfo(ctrl)
+	info.EXPECT().Mode().Return(fs.FileMode(0444)).AnyTimes()
+	inner.EXPECT().Stat(ctx, "file").Return(info, nil)
+	if err := contextual.Access(ctx, ro, "file", fsx.R_OK); err != nil {
+		t.Errorf("Access(R_OK) error = %v", err)
+	}
+}
+
+func TestFS_lockScope(t *testing.T) {
+	ctx := t.Context()
+	mem := memfs.New(memfs.Config{})
+	inner := New(memfs.New(memfs.Config{}), mem)
+	outer := New(memfs.New(memfs.Config{}), inner)
+	SetStrictConsistency(inner, true)
+	SetStrictConsistency(outer, true)
+
+	locked, unlock := outer.lock(ctx, true, "dir")
+	defer unlock()
+	if !outer.locked(locked) {
+		t.Error("expected the context to be marked as locked")
+	}
+	// The union the outer one is layered on still locks.
+	if inner.locked(locked) {
+		t.Error("expected the mark of the outer union not to apply to the inner one")
+	}
+
+	// Files outliving the call lock again.
+	dir, err := mem.Open(ctx, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	merged, ok := outer.mergeDir(locked, ".", dir.(fsx.File)).(*mergedDir)
+	if !ok {
+		t.Fatal("expected a merged directory")
+	}
+	if outer.locked(merged.ctx) {
+		t.Error("expected the directory not to keep the mark of the locks")
+	}
+}
+
+func TestFS_lockTarget(t *testing.T) {
+	ctx := t.Context()
+	rw := memfs.New(memfs.Config{})
+	if err := contextual.Symlink(ctx, rw, "link2", "link1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.Symlink(ctx, rw, "dir/target", "link2"); err != nil {
+		t.Fatal(err)
+	}
+	f := New(rw)
+	SetStrictConsistency(f, true)
+
+	_, unlock := f.lockTarget(ctx, "link1")
+	for _, name := range []string{"link1", "link2", "dir/target"} {
+		if f.locks[stripe(name)].TryLock() {
+			t.Errorf("expected %s to be locked", name)
+		}
+	}
+	unlock()
+	if !f.locks[stripe("dir/target")].TryLock() {
+		t.Error("expected the locks to be released")
+	}
+}
@@ -0,0 +1,65 @@
+package unionfs_test
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/unionfs"
+)
+
+func TestFS_CopyUpSpecial(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, nil)
+	ro := newOSLayer(t, map[string]string{"dir/keep": ""})
+	if err := contextual.CreateSpecial(ctx, ro, "dir/fifo", fs.ModeNamedPipe|0644, 0); err != nil {
+		t.Fatal(err)
+	}
+	f := unionfs.New(rw, ro)
+
+	// Chmod copies the file up to the read-write layer first.
+	if err := f.Chmod(ctx, "dir/fifo", 0600); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+
+	info, err := contextual.Lstat(ctx, rw, "dir/fifo")
+	if err != nil {
+		t.Fatalf("expected copied up file: %v", err)
+	}
+	if info.Mode().Type() != fs.ModeNamedPipe {
+		t.Errorf("expected named pipe, got %v", info.Mode())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestFS_CopyUpSetuidOwned(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, nil)
+	ro := newOSLayer(t, map[string]string{"dir/prog": "#!"})
+	for name, mode := range map[string]fs.FileMode{"dir": fs.ModeDir | fs.ModeSetgid | 0755, "dir/prog": fs.ModeSetuid | fs.ModeSetgid | 0755} {
+		if err := contextual.Chmod(ctx, ro, name, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := unionfs.New(rw, ro)
+	unionfs.SetOwnerMapping(f, contextual.OSResolver, contextual.OSResolver)
+
+	// The ownership is set before the bits, which changing it clears.
+	for _, name := range []string{"dir", "dir/prog"} {
+		if err := f.Chtimes(ctx, name, time.Time{}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]fs.FileMode{"dir": fs.ModeSetgid, "dir/prog": fs.ModeSetuid | fs.ModeSetgid} {
+		info, err := contextual.Lstat(ctx, rw, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode() & (fs.ModeSetuid | fs.ModeSetgid); got != want {
+			t.Errorf("mode of %s = %v; want the bits %v kept", name, info.Mode(), want)
+		}
+	}
+}
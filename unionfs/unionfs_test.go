@@ -5,16 +5,31 @@
 package unionfs_test
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/gwangyi/fsx"
 	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/memfs"
 	"github.com/gwangyi/fsx/mockfs"
 	cmockfs "github.com/gwangyi/fsx/mockfs/contextual"
+	"github.com/gwangyi/fsx/osfs"
 	"github.com/gwangyi/fsx/unionfs"
 	"go.uber.org/mock/gomock"
 )
@@ -52,6 +67,8 @@ func TestFS_Open(t *testing.T) {
 
 		mockFile := mockfs.NewMockFile(ctrl)
 		ro.EXPECT().Open(t.Context(), "test.txt").Return(mockFile, nil)
+		// The wrapped file cannot list a directory, so Open does not check
+		// its type.
 
 		file, err := f.Open(t.Context(), "test.txt")
 		if err != nil {
@@ -113,6 +130,8 @@ func TestFS_OpenFile(t *testing.T) {
 
 		mockFile := mockfs.NewMockFile(ctrl)
 		ro.EXPECT().Open(t.Context(), "test.txt").Return(mockFile, nil)
+		// The wrapped file cannot list a directory, so Open does not check
+		// its type.
 
 		file, err := contextual.OpenFile(t.Context(), f, "test.txt", os.O_RDONLY, 0)
 		if err != nil {
@@ -131,8 +150,9 @@ func TestFS_OpenFile(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW calls Stat on RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		// copyToRW calls Lstat on RW
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 
 		// Find in RO: Stat on RO
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
@@ -148,8 +168,12 @@ func TestFS_OpenFile(t *testing.T) {
 
 		// Create in RW (copyToRW calls OpenFile)
 		rwFile := mockfs.NewMockFile(ctrl)
-		rw.EXPECT().OpenFile(t.Context(), "test.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fs.FileMode(0644)).Return(rwFile, nil)
+		rw.EXPECT().OpenFile(t.Context(), scratchOf("test.txt"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(0644)).Return(rwFile, nil)
 		rwFile.EXPECT().Close().Return(nil)
+		// Verify the copy, and move it into place
+		mockInfo.EXPECT().Size().Return(int64(0)).AnyTimes()
+		rw.EXPECT().Stat(t.Context(), scratchOf("test.txt")).Return(mockInfo, nil)
+		rw.EXPECT().Rename(t.Context(), scratchOf("test.txt"), "test.txt").Return(nil)
 
 		// Remove whiteout
 		rw.EXPECT().Remove(t.Context(), ".wh.test.txt").Return(fs.ErrNotExist)
@@ -208,10 +232,11 @@ func TestFS_Remove(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().Remove(t.Context(), "subdir/test.txt").Return(fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.subdir").Return(nil, fs.ErrNotExist)
 		ro.EXPECT().Stat(t.Context(), "subdir/test.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
 
 		// createWhiteout uses MkdirAll first
-		rw.EXPECT().MkdirAll(t.Context(), "subdir/", fs.FileMode(0755)).Return(nil)
+		rw.EXPECT().MkdirAll(t.Context(), "subdir", fs.FileMode(0755)).Return(nil)
 
 		// Then WriteFile
 		rw.EXPECT().WriteFile(t.Context(), "subdir/.wh.test.txt", nil, fs.FileMode(0644)).Return(nil)
@@ -232,10 +257,11 @@ func TestFS_Remove(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().Remove(t.Context(), "subdir/test.txt").Return(fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.subdir").Return(nil, fs.ErrNotExist)
 		ro.EXPECT().Stat(t.Context(), "subdir/test.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
 
 		// createWhiteout uses MkdirAll first
-		rw.EXPECT().MkdirAll(t.Context(), "subdir/", fs.FileMode(0755)).Return(expectedErr)
+		rw.EXPECT().MkdirAll(t.Context(), "subdir", fs.FileMode(0755)).Return(expectedErr)
 
 		err := contextual.Remove(t.Context(), f, "subdir/test.txt")
 		if !errors.Is(err, expectedErr) {
@@ -255,6 +281,7 @@ func TestFS_ReadDir(t *testing.T) {
 		de1 := mockfs.NewMockDirEntry(ctrl)
 		de1.EXPECT().Name().Return("a.txt").AnyTimes()
 		rw.EXPECT().ReadDir(t.Context(), "dir").Return([]fs.DirEntry{de1}, nil)
+		rw.EXPECT().Stat(t.Context(), ".wh.dir").Return(nil, fs.ErrNotExist)
 
 		de2 := mockfs.NewMockDirEntry(ctrl)
 		de2.EXPECT().Name().Return("b.txt").AnyTimes()
@@ -284,6 +311,7 @@ func TestFS_ReadDir(t *testing.T) {
 		wh := mockfs.NewMockDirEntry(ctrl)
 		wh.EXPECT().Name().Return(".wh.b.txt").AnyTimes()
 		rw.EXPECT().ReadDir(t.Context(), "dir").Return([]fs.DirEntry{wh}, nil)
+		rw.EXPECT().Stat(t.Context(), ".wh.dir").Return(nil, fs.ErrNotExist)
 
 		de1 := mockfs.NewMockDirEntry(ctrl)
 		de1.EXPECT().Name().Return("a.txt").AnyTimes()
@@ -313,6 +341,10 @@ func TestFS_Mkdir(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().Mkdir(t.Context(), "newdir", fs.FileMode(0755)).Return(nil)
+		// The whiteout hides no directory whose files would show through.
+		rw.EXPECT().Stat(t.Context(), ".wh.newdir").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		rw.EXPECT().ReadDir(t.Context(), "newdir").Return(nil, nil)
+		ro.EXPECT().Open(t.Context(), "newdir").Return(nil, fs.ErrNotExist)
 		rw.EXPECT().Remove(t.Context(), ".wh.newdir").Return(nil)
 
 		err := contextual.Mkdir(t.Context(), f, "newdir", 0755)
@@ -336,8 +368,8 @@ func TestFS_Rename(t *testing.T) {
 		// inRO check
 		ro.EXPECT().Stat(t.Context(), "old.txt").Return(nil, fs.ErrNotExist)
 
-		// copyToRW (already in RW, so Stat again)
-		rw.EXPECT().Stat(t.Context(), "old.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		// copyToRW (already in RW, so Lstat again)
+		rw.EXPECT().Lstat(t.Context(), "old.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
 
 		// Rename
 		rw.EXPECT().Rename(t.Context(), "old.txt", "new.txt").Return(nil)
@@ -355,16 +387,18 @@ func TestFS_Rename(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// f.Stat(old.txt)
+		// f.Stat(old.txt), and copyToRW
 		rw.EXPECT().Stat(t.Context(), "old.txt").Return(nil, fs.ErrNotExist)
-		rw.EXPECT().Stat(t.Context(), ".wh.old.txt").Return(nil, fs.ErrNotExist)
-		ro.EXPECT().Stat(t.Context(), "old.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		rw.EXPECT().Stat(t.Context(), ".wh.old.txt").Return(nil, fs.ErrNotExist).Times(2)
+		oldInfo := mockfs.NewMockFileInfo(ctrl)
+		oldInfo.EXPECT().IsDir().Return(false).AnyTimes()
+		ro.EXPECT().Stat(t.Context(), "old.txt").Return(oldInfo, nil)
 
 		// inRO check
 		ro.EXPECT().Stat(t.Context(), "old.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
 
 		// copyToRW
-		rw.EXPECT().Stat(t.Context(), "old.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Lstat(t.Context(), "old.txt").Return(nil, fs.ErrNotExist)
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
 		mockInfo.EXPECT().IsDir().Return(false).AnyTimes()
 		mockInfo.EXPECT().Mode().Return(fs.FileMode(0644)).AnyTimes()
@@ -374,8 +408,12 @@ func TestFS_Rename(t *testing.T) {
 		roFile.EXPECT().Read(gomock.Any()).Return(0, io.EOF)
 		roFile.EXPECT().Close().Return(nil)
 		rwFile := mockfs.NewMockFile(ctrl)
-		rw.EXPECT().OpenFile(t.Context(), "old.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fs.FileMode(0644)).Return(rwFile, nil)
+		rw.EXPECT().OpenFile(t.Context(), scratchOf("old.txt"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(0644)).Return(rwFile, nil)
 		rwFile.EXPECT().Close().Return(nil)
+		// Verify the copy, and move it into place
+		mockInfo.EXPECT().Size().Return(int64(0)).AnyTimes()
+		rw.EXPECT().Stat(t.Context(), scratchOf("old.txt")).Return(mockInfo, nil)
+		rw.EXPECT().Rename(t.Context(), scratchOf("old.txt"), "old.txt").Return(nil)
 		rw.EXPECT().Remove(t.Context(), ".wh.old.txt").Return(nil)
 
 		// Rename
@@ -418,13 +456,15 @@ func TestFS_Rename(t *testing.T) {
 		// f.Stat(old.txt)
 		rw.EXPECT().Stat(t.Context(), "old.txt").Return(nil, fs.ErrNotExist)
 		rw.EXPECT().Stat(t.Context(), ".wh.old.txt").Return(nil, fs.ErrNotExist)
-		ro.EXPECT().Stat(t.Context(), "old.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		oldInfo := mockfs.NewMockFileInfo(ctrl)
+		oldInfo.EXPECT().IsDir().Return(false).AnyTimes()
+		ro.EXPECT().Stat(t.Context(), "old.txt").Return(oldInfo, nil)
 
 		// inRO check
 		ro.EXPECT().Stat(t.Context(), "old.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
 
 		// copyToRW fails
-		rw.EXPECT().Stat(t.Context(), "old.txt").Return(nil, expectedErr)
+		rw.EXPECT().Lstat(t.Context(), "old.txt").Return(nil, expectedErr)
 
 		err := contextual.Rename(t.Context(), f, "old.txt", "new.txt")
 		if !errors.Is(err, expectedErr) {
@@ -445,9 +485,9 @@ func TestFS_Rename(t *testing.T) {
 		// inRO check
 		ro.EXPECT().Stat(t.Context(), "old.txt").Return(nil, fs.ErrNotExist)
 
-		// copyToRW (already in RW, so Stat again)
+		// copyToRW (already in RW, so Lstat again)
 		expectedErr := errors.New("expected")
-		rw.EXPECT().Stat(t.Context(), "old.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		rw.EXPECT().Lstat(t.Context(), "old.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
 
 		// Rename
 		rw.EXPECT().Rename(t.Context(), "old.txt", "new.txt").Return(expectedErr)
@@ -736,8 +776,8 @@ func TestFS_Truncate(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW calls Stat on RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		// copyToRW calls Lstat on RW
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(regularFileInfo(ctrl), nil)
 
 		rw.EXPECT().Truncate(t.Context(), "test.txt", int64(10)).Return(nil)
 
@@ -754,9 +794,9 @@ func TestFS_Truncate(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW fails on first Stat on RW
+		// copyToRW fails on first Lstat on RW
 		expectedErr := errors.New("expected")
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, expectedErr)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, expectedErr)
 
 		err := contextual.Truncate(t.Context(), f, "test.txt", 10)
 		if !errors.Is(err, expectedErr) {
@@ -773,8 +813,8 @@ func TestFS_Chown(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW calls Stat on RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		// copyToRW calls Lstat on RW
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(regularFileInfo(ctrl), nil)
 
 		rw.EXPECT().Chown(t.Context(), "test.txt", "user", "group").Return(nil)
 
@@ -791,9 +831,9 @@ func TestFS_Chown(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW fails on first Stat on RW
+		// copyToRW fails on first Lstat on RW
 		expectedErr := errors.New("expected")
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, expectedErr)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, expectedErr)
 
 		err := contextual.Chown(t.Context(), f, "test.txt", "user", "group")
 		if !errors.Is(err, expectedErr) {
@@ -810,8 +850,8 @@ func TestFS_Chmod(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW calls Stat on RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		// copyToRW calls Lstat on RW
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(regularFileInfo(ctrl), nil)
 
 		rw.EXPECT().Chmod(t.Context(), "test.txt", fs.FileMode(0644)).Return(nil)
 
@@ -828,9 +868,9 @@ func TestFS_Chmod(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW fails on first Stat on RW
+		// copyToRW fails on first Lstat on RW
 		expectedErr := errors.New("expected")
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, expectedErr)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, expectedErr)
 
 		err := contextual.Chmod(t.Context(), f, "test.txt", 0644)
 		if !errors.Is(err, expectedErr) {
@@ -847,8 +887,8 @@ func TestFS_Chtimes(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW calls Stat on RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		// copyToRW calls Lstat on RW
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(regularFileInfo(ctrl), nil)
 
 		now := time.Now()
 		rw.EXPECT().Chtimes(t.Context(), "test.txt", now, now).Return(nil)
@@ -866,9 +906,9 @@ func TestFS_Chtimes(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW fails on first Stat on RW
+		// copyToRW fails on first Lstat on RW
 		expectedErr := errors.New("expected")
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, expectedErr)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, expectedErr)
 
 		now := time.Now()
 		err := contextual.Chtimes(t.Context(), f, "test.txt", now, now)
@@ -886,8 +926,8 @@ func TestFS_Lchown(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW calls Stat on RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		// copyToRW calls Lstat on RW
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(regularFileInfo(ctrl), nil)
 
 		rw.EXPECT().Lchown(t.Context(), "test.txt", "user", "group").Return(nil)
 
@@ -904,9 +944,9 @@ func TestFS_Lchown(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW fails on first Stat on RW
+		// copyToRW fails on first Lstat on RW
 		expectedErr := errors.New("expected")
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, expectedErr)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, expectedErr)
 
 		err := contextual.Lchown(t.Context(), f, "test.txt", "user", "group")
 		if !errors.Is(err, expectedErr) {
@@ -924,6 +964,10 @@ func TestFS_MkdirAll(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().MkdirAll(t.Context(), "newdir", fs.FileMode(0755)).Return(nil)
+		// The whiteout hides no directory whose files would show through.
+		rw.EXPECT().Stat(t.Context(), ".wh.newdir").Return(mockfs.NewMockFileInfo(ctrl), nil)
+		rw.EXPECT().ReadDir(t.Context(), "newdir").Return(nil, nil)
+		ro.EXPECT().Open(t.Context(), "newdir").Return(nil, fs.ErrNotExist)
 		rw.EXPECT().Remove(t.Context(), ".wh.newdir").Return(nil)
 
 		err := contextual.MkdirAll(t.Context(), f, "newdir", 0755)
@@ -978,21 +1022,66 @@ func TestFS_Create(t *testing.T) {
 		ro := cmockfs.NewMockStatFS(ctrl)
 		f := unionfs.New(rw, ro)
 
-		// copyToRW calls Stat on RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		// copyToRW calls Lstat on RW
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
 		// Find in RO
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 		ro.EXPECT().Stat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
 
 		// OpenFile
 		rwFile := mockfs.NewMockFile(ctrl)
 		rw.EXPECT().OpenFile(t.Context(), "test.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, fs.FileMode(0666)).Return(rwFile, nil)
+		// The file is new: a whiteout for it would be removed.
+		rw.EXPECT().Remove(t.Context(), ".wh.test.txt").Return(fs.ErrNotExist)
 
 		file, err := contextual.Create(t.Context(), f, "test.txt")
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
-		if file != rwFile {
-			t.Errorf("expected rwFile, got %v", file)
+		// The file of the read-write layer is wrapped to count the bytes
+		// written through it.
+		rwFile.EXPECT().Write([]byte("x")).Return(1, nil)
+		if n, err := file.Write([]byte("x")); n != 1 || err != nil {
+			t.Errorf("Write() = %d, %v, want 1, nil", n, err)
+		}
+	})
+
+	t.Run("layers", func(t *testing.T) {
+		ctx := t.Context()
+		rw := newOSLayer(t, nil)
+		f := unionfs.New(rw, newOSLayer(t, map[string]string{"dir/old": "old"}))
+		create := func(ctx context.Context, name string) {
+			t.Helper()
+			file, err := f.Create(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The directory of the read-only layer is copied up, and the umask
+		// applies.
+		create(contextual.WithUmask(ctx, 0027), "dir/new")
+		if info, err := contextual.Stat(ctx, rw, "dir/new"); err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("Stat(rw, dir/new) = %v, %v; want mode 0640", info, err)
+		}
+
+		// A recreated file takes the place of its whiteout.
+		if err := f.Remove(ctx, "dir/old"); err != nil {
+			t.Fatal(err)
+		}
+		create(ctx, "dir/old")
+		if _, err := contextual.Stat(ctx, rw, "dir/.wh.old"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the whiteout to be removed, got %v", err)
+		}
+		if data, err := contextual.ReadFile(ctx, f, "dir/old"); err != nil || len(data) != 0 {
+			t.Errorf("ReadFile(dir/old) = %q, %v; want empty", data, err)
+		}
+
+		if _, err := f.OpenFile(ctx, "dir/new", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("expected ErrExist, got %v", err)
 		}
 	})
 }
@@ -1043,6 +1132,7 @@ func TestFS_ReadFile(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().ReadFile(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 
 		data := []byte("hello")
 		ro.EXPECT().ReadFile(t.Context(), "test.txt").Return(data, nil)
@@ -1057,26 +1147,28 @@ func TestFS_ReadFile(t *testing.T) {
 	})
 
 	t.Run("copy on read", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
-		defer ctrl.Finish()
-		rw := cmockfs.NewMockFileSystem(ctrl)
-		ro := cmockfs.NewMockReadFileFS(ctrl)
-		f := unionfs.New(rw, ro)
+		ctx := t.Context()
+		rw := newOSLayer(t, nil)
+		f := unionfs.New(rw, newOSLayer(t, map[string]string{"dir/test.txt": "hello"}))
 		unionfs.SetCopyOnRead(f, true)
 
-		rw.EXPECT().ReadFile(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
-
-		data := []byte("hello")
-		ro.EXPECT().ReadFile(t.Context(), "test.txt").Return(data, nil)
-
-		rw.EXPECT().WriteFile(t.Context(), "test.txt", data, fs.FileMode(0666)).Return(nil)
-
-		res, err := contextual.ReadFile(t.Context(), f, "test.txt")
-		if err != nil {
-			t.Errorf("unexpected error: %v", err)
+		res, err := contextual.ReadFile(ctx, f, "dir/test.txt")
+		if err != nil || string(res) != "hello" {
+			t.Errorf("ReadFile() = %q, %v, want hello", res, err)
 		}
-		if string(res) != string(data) {
-			t.Errorf("expected %s, got %s", data, res)
+		// The file is copied with its permissions.
+		if data, err := contextual.ReadFile(ctx, rw, "dir/test.txt"); err != nil || string(data) != "hello" {
+			t.Errorf("copy = %q, %v, want hello", data, err)
+		}
+		if info, err := contextual.Stat(ctx, rw, "dir/test.txt"); err != nil || info.Mode().Perm() != 0644 {
+			t.Errorf("copy info = %v, %v, want mode 0644", info, err)
+		}
+		// Directories are not copied.
+		if _, err := contextual.ReadFile(ctx, f, "dir"); err == nil {
+			t.Error("ReadFile() of a directory succeeded")
+		}
+		if entries, err := contextual.ReadDir(ctx, rw, "dir"); err != nil || len(entries) != 1 {
+			t.Errorf("ReadDir(rw) = %v, %v, want only the copy", entries, err)
 		}
 	})
 
@@ -1088,6 +1180,7 @@ func TestFS_ReadFile(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().ReadFile(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 		ro.EXPECT().ReadFile(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
 
 		_, err := contextual.ReadFile(t.Context(), f, "test.txt")
@@ -1114,8 +1207,9 @@ func TestFS_CopyOnRead(t *testing.T) {
 		ro.EXPECT().Open(t.Context(), "test.txt").Return(roFile, nil)
 		roFile.EXPECT().Close().Return(nil)
 
-		// copyToRW calls Stat on RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		// copyToRW calls Lstat on RW
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 
 		// Find in RO: Stat on RO
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
@@ -1130,8 +1224,12 @@ func TestFS_CopyOnRead(t *testing.T) {
 
 		// Create in RW
 		rwFile := mockfs.NewMockFile(ctrl)
-		rw.EXPECT().OpenFile(t.Context(), "test.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fs.FileMode(0644)).Return(rwFile, nil)
+		rw.EXPECT().OpenFile(t.Context(), scratchOf("test.txt"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(0644)).Return(rwFile, nil)
 		rwFile.EXPECT().Close().Return(nil)
+		// Verify the copy, and move it into place
+		mockInfo.EXPECT().Size().Return(int64(0)).AnyTimes()
+		rw.EXPECT().Stat(t.Context(), scratchOf("test.txt")).Return(mockInfo, nil)
+		rw.EXPECT().Rename(t.Context(), scratchOf("test.txt"), "test.txt").Return(nil)
 
 		// Remove whiteout
 		rw.EXPECT().Remove(t.Context(), ".wh.test.txt").Return(fs.ErrNotExist)
@@ -1154,14 +1252,14 @@ func TestFS_CopyOnRead(t *testing.T) {
 		unionfs.SetCopyOnRead(f, true)
 
 		rw.EXPECT().OpenFile(t.Context(), "dir", os.O_RDONLY, fs.FileMode(0)).Return(nil, fs.ErrNotExist)
-		rw.EXPECT().Stat(t.Context(), ".wh.dir").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.dir").Return(nil, fs.ErrNotExist).Times(2)
 
 		roFile := mockfs.NewMockFile(ctrl)
 		ro.EXPECT().Open(t.Context(), "dir").Return(roFile, nil)
 		roFile.EXPECT().Close().Return(nil)
 
 		// copyToRW calls
-		rw.EXPECT().Stat(t.Context(), "dir").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Lstat(t.Context(), "dir").Return(nil, fs.ErrNotExist)
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
 		mockInfo.EXPECT().IsDir().Return(true).AnyTimes()
 		mockInfo.EXPECT().Mode().Return(fs.FileMode(0755 | fs.ModeDir)).AnyTimes()
@@ -1188,11 +1286,13 @@ func TestFS_OpenFile_Errors(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		// copyToRW calls
-		rw.EXPECT().Stat(t.Context(), "new.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Lstat(t.Context(), "new.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.new.txt").Return(nil, fs.ErrNotExist)
 		ro.EXPECT().Stat(t.Context(), "new.txt").Return(nil, fs.ErrNotExist)
 
 		rwFile := mockfs.NewMockFile(ctrl)
 		rw.EXPECT().OpenFile(t.Context(), "new.txt", os.O_RDWR|os.O_CREATE, fs.FileMode(0644)).Return(rwFile, nil)
+		rw.EXPECT().Remove(t.Context(), ".wh.new.txt").Return(fs.ErrNotExist)
 
 		_, err := f.OpenFile(t.Context(), "new.txt", os.O_RDWR|os.O_CREATE, 0644)
 		if err != nil {
@@ -1252,7 +1352,7 @@ func TestFS_OpenFile_Errors(t *testing.T) {
 
 		expectedErr := errors.New("copy failed")
 		// copyToRW fails
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, expectedErr)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, expectedErr)
 
 		_, err := f.OpenFile(t.Context(), "test.txt", os.O_RDONLY, 0)
 		if !errors.Is(err, expectedErr) {
@@ -1269,9 +1369,10 @@ func TestFS_OpenFile_Errors(t *testing.T) {
 
 		// copyToRW
 		// Check if already in RW
-		rw.EXPECT().Stat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Lstat(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
 
 		// Find in RO
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 		mockInfo := mockfs.NewMockFileInfo(ctrl)
 		mockInfo.EXPECT().IsDir().Return(false).AnyTimes()
 		mockInfo.EXPECT().Mode().Return(fs.FileMode(0644)).AnyTimes()
@@ -1284,12 +1385,14 @@ func TestFS_OpenFile_Errors(t *testing.T) {
 
 		// Open destination in RW
 		rwFile := mockfs.NewMockFile(ctrl)
-		rw.EXPECT().OpenFile(t.Context(), "test.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fs.FileMode(0644)).Return(rwFile, nil)
+		rw.EXPECT().OpenFile(t.Context(), scratchOf("test.txt"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(0644)).Return(rwFile, nil)
 		rwFile.EXPECT().Close().Return(nil)
 
 		// Fail copy (read from RO file fails)
 		expectedErr := errors.New("read error")
 		roFile.EXPECT().Read(gomock.Any()).Return(0, expectedErr)
+		// The partial copy is removed
+		rw.EXPECT().Remove(gomock.Any(), scratchOf("test.txt")).Return(nil)
 
 		_, err := f.OpenFile(t.Context(), "test.txt", os.O_RDWR|os.O_APPEND, 0)
 		if !errors.Is(err, expectedErr) {
@@ -1323,6 +1426,7 @@ func TestFS_ReadDir_Errors(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().ReadDir(t.Context(), "dir").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.dir").Return(nil, fs.ErrNotExist)
 
 		expectedErr := errors.New("expected")
 		ro.EXPECT().ReadDir(t.Context(), "dir").Return(nil, expectedErr)
@@ -1341,6 +1445,7 @@ func TestFS_ReadDir_Errors(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().ReadDir(t.Context(), "dir").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.dir").Return(nil, fs.ErrNotExist)
 		ro.EXPECT().Open(t.Context(), "dir").Return(nil, fs.ErrNotExist)
 
 		_, err := f.ReadDir(t.Context(), "dir")
@@ -1539,6 +1644,7 @@ func TestFS_ReadFile_Errors(t *testing.T) {
 		f := unionfs.New(rw, ro)
 
 		rw.EXPECT().ReadFile(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(t.Context(), ".wh.test.txt").Return(nil, fs.ErrNotExist)
 
 		expectedErr := errors.New("expected")
 		ro.EXPECT().ReadFile(t.Context(), "test.txt").Return(nil, expectedErr)
@@ -1553,17 +1659,14 @@ func TestFS_ReadFile_Errors(t *testing.T) {
 		ctrl := gomock.NewController(t)
 		defer ctrl.Finish()
 		rw := cmockfs.NewMockFileSystem(ctrl)
-		ro := cmockfs.NewMockReadFileFS(ctrl)
-		f := unionfs.New(rw, ro)
+		f := unionfs.New(rw, newOSLayer(t, map[string]string{"test.txt": "hello"}))
 		unionfs.SetCopyOnRead(f, true)
 
-		rw.EXPECT().ReadFile(t.Context(), "test.txt").Return(nil, fs.ErrNotExist)
-
-		data := []byte("hello")
-		ro.EXPECT().ReadFile(t.Context(), "test.txt").Return(data, nil)
-
+		rw.EXPECT().ReadFile(gomock.Any(), "test.txt").Return(nil, fs.ErrNotExist)
+		rw.EXPECT().Stat(gomock.Any(), gomock.Any()).Return(nil, fs.ErrNotExist).AnyTimes()
+		rw.EXPECT().Lstat(gomock.Any(), gomock.Any()).Return(nil, fs.ErrNotExist).AnyTimes()
 		expectedErr := errors.New("write error")
-		rw.EXPECT().WriteFile(t.Context(), "test.txt", data, fs.FileMode(0666)).Return(expectedErr)
+		rw.EXPECT().OpenFile(gomock.Any(), scratchOf("test.txt"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(0644)).Return(nil, expectedErr)
 
 		_, err := f.ReadFile(t.Context(), "test.txt")
 		if !errors.Is(err, expectedErr) {
@@ -1571,3 +1674,2898 @@ func TestFS_ReadFile_Errors(t *testing.T) {
 		}
 	})
 }
+
+// regularFileInfo returns the FileInfo of a regular file, as far as the mode
+// is concerned.
+func regularFileInfo(ctrl *gomock.Controller) fs.FileInfo {
+	info := mockfs.NewMockFileInfo(ctrl)
+	info.EXPECT().Mode().Return(fs.FileMode(0644)).AnyTimes()
+	return info
+}
+
+// newOSLayer returns a contextual view of a fresh temporary directory
+// populated with the given files.
+func newOSLayer(t *testing.T, files map[string]string) contextual.FS {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys, err := osfs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contextual.ToContextual(fsys)
+}
+
+func TestFS_RenameDirectory(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, nil)
+	ro := newOSLayer(t, map[string]string{
+		"dir/a.txt":     "a",
+		"dir/b.txt":     "b",
+		"dir/sub/c.txt": "c",
+		"dir/sub/d.txt": "d",
+	})
+	f := unionfs.New(rw, ro)
+	unionfs.SetConcurrency(f, 2)
+
+	if err := contextual.Remove(ctx, f, "dir/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.Rename(ctx, f, "dir", "moved"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"moved/a.txt":     "a",
+		"moved/sub/c.txt": "c",
+		"moved/sub/d.txt": "d",
+	} {
+		got, err := contextual.ReadFile(ctx, rw, name)
+		if err != nil {
+			t.Errorf("ReadFile(%q) on RW failed: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("ReadFile(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := contextual.Stat(ctx, f, "moved/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected whiteout-hidden file to stay hidden, got %v", err)
+	}
+	if _, err := contextual.Stat(ctx, f, "dir"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected old directory to be hidden, got %v", err)
+	}
+}
+
+func TestFS_RemovedDirectoryChildren(t *testing.T) {
+	files := map[string]string{"d/a": "a", "d/sub/b": "b"}
+	for _, tc := range []struct {
+		name   string
+		remove func(ctx context.Context, f contextual.FS) error
+	}{
+		{"removeall", func(ctx context.Context, f contextual.FS) error { return contextual.RemoveAll(ctx, f, "d") }},
+		{"rename", func(ctx context.Context, f contextual.FS) error { return contextual.Rename(ctx, f, "d", "e") }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := t.Context()
+			f := unionfs.New(newOSLayer(t, nil), newOSLayer(t, files))
+			if err := tc.remove(ctx, f); err != nil {
+				t.Fatal(err)
+			}
+
+			// The whiteout of the directory hides everything beneath it.
+			for _, name := range []string{"d", "d/a", "d/sub", "d/sub/b"} {
+				if _, err := contextual.Stat(ctx, f, name); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Stat(%s) error = %v; want ErrNotExist", name, err)
+				}
+			}
+			if _, err := contextual.ReadFile(ctx, f, "d/sub/b"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("ReadFile(d/sub/b) error = %v; want ErrNotExist", err)
+			}
+			if _, err := contextual.ReadDir(ctx, f, "d"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("ReadDir(d) error = %v; want ErrNotExist", err)
+			}
+			if err := contextual.Chtimes(ctx, f, "d/a", time.Time{}, time.Now()); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Chtimes(d/a) error = %v; want ErrNotExist", err)
+			}
+			if err := contextual.WriteFile(ctx, f, "d/new", nil, 0644); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("WriteFile(d/new) error = %v; want ErrNotExist", err)
+			}
+			if _, err := contextual.Stat(ctx, f, "d"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat(d) error = %v after writes beneath it; want ErrNotExist", err)
+			}
+
+			// Directories created in its place do not bring it back.
+			if err := contextual.MkdirAll(ctx, f, "d/sub/x", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if names := listNames(t, f, "d"); !slices.Equal(names, []string{"sub"}) {
+				t.Errorf("ReadDir(d) = %v; want [sub]", names)
+			}
+			if names := listNames(t, f, "d/sub"); !slices.Equal(names, []string{"x"}) {
+				t.Errorf("ReadDir(d/sub) = %v; want [x]", names)
+			}
+			if err := contextual.RemoveAll(ctx, f, "d"); err != nil {
+				t.Fatal(err)
+			}
+			if err := contextual.Mkdir(ctx, f, "d", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if names := listNames(t, f, "d"); len(names) != 0 {
+				t.Errorf("ReadDir(d) = %v; want no entries", names)
+			}
+			if _, err := contextual.Stat(ctx, f, "d/sub/b"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat(d/sub/b) error = %v; want ErrNotExist", err)
+			}
+			if issues, err := unionfs.Check(ctx, f); err != nil || len(issues) != 0 {
+				t.Errorf("Check() = %v, %v; want no issues", issues, err)
+			}
+		})
+	}
+}
+
+func TestFS_StrictRename(t *testing.T) {
+	newUnion := func(t *testing.T, files map[string]string) contextual.FS {
+		t.Helper()
+		f := unionfs.New(newOSLayer(t, nil), newOSLayer(t, files))
+		unionfs.SetStrictRename(f, true)
+		return f
+	}
+
+	t.Run("replace read-only file", func(t *testing.T) {
+		ctx := t.Context()
+		f := newUnion(t, map[string]string{"a": "new", "b": "old"})
+		if err := contextual.Rename(ctx, f, "a", "b"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		if got, err := contextual.ReadFile(ctx, f, "b"); err != nil || string(got) != "new" {
+			t.Errorf("ReadFile(b) = %q, %v, want %q", got, err, "new")
+		}
+		if err := contextual.Remove(ctx, f, "b"); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a", "b"} {
+			if _, err := contextual.Stat(ctx, f, name); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected %s to stay hidden, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("read-only parent", func(t *testing.T) {
+		ctx := t.Context()
+		f := newUnion(t, map[string]string{"a": "a", "dir/b": "b"})
+		if err := contextual.Rename(ctx, f, "a", "dir/a"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		if got, err := contextual.ReadFile(ctx, f, "dir/a"); err != nil || string(got) != "a" {
+			t.Errorf("ReadFile(dir/a) = %q, %v", got, err)
+		}
+	})
+
+	t.Run("replace emptied directory", func(t *testing.T) {
+		ctx := t.Context()
+		f := newUnion(t, map[string]string{"src/new": "n", "dst/old": "o"})
+		if err := contextual.Remove(ctx, f, "dst/old"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Rename(ctx, f, "src", "dst"); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+		entries, err := contextual.ReadDir(ctx, f, "dst")
+		if err != nil || len(entries) != 1 || entries[0].Name() != "new" {
+			t.Fatalf("ReadDir(dst) = %v, %v", entries, err)
+		}
+		if err := contextual.Remove(ctx, f, "dst/new"); err != nil {
+			t.Fatal(err)
+		}
+		if entries, err := contextual.ReadDir(ctx, f, "dst"); err != nil || len(entries) != 0 {
+			t.Errorf("ReadDir(dst) = %v, %v, want empty", entries, err)
+		}
+	})
+
+	t.Run("failed rename keeps the target", func(t *testing.T) {
+		ctx := t.Context()
+		rw := newOSLayer(t, nil)
+		f := unionfs.New(fsxtest.NewErrorFS(rw, map[fsxtest.Fault]error{{Op: "rename", Path: "src"}: fs.ErrPermission}), newOSLayer(t, map[string]string{"src/new": "n", "dst/old": "o"}))
+		unionfs.SetStrictRename(f, true)
+		if err := contextual.Remove(ctx, f, "dst/old"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Rename(ctx, f, "src", "dst"); !errors.Is(err, fs.ErrPermission) {
+			t.Fatalf("Rename error = %v; want ErrPermission", err)
+		}
+		// The whiteout of dst/old is still in place.
+		if entries, err := contextual.ReadDir(ctx, f, "dst"); err != nil || len(entries) != 0 {
+			t.Errorf("ReadDir(dst) = %v, %v, want empty", entries, err)
+		}
+	})
+
+	t.Run("target filled meanwhile", func(t *testing.T) {
+		ctx := t.Context()
+		rw := fillingLayer{newOSLayer(t, nil).(contextual.FileSystem)}
+		f := unionfs.New(rw, newOSLayer(t, map[string]string{"src/new": "n", "dst/old": "o"}))
+		unionfs.SetStrictRename(f, true)
+		if err := contextual.Remove(ctx, f, "dst/old"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Rename(ctx, f, "src", "dst"); !errors.Is(err, syscall.ENOTEMPTY) {
+			t.Fatalf("Rename error = %v; want ENOTEMPTY", err)
+		}
+		if names := listNames(t, f, "dst"); !slices.Equal(names, []string{"late"}) {
+			t.Errorf("unexpected dst entries: %v", names)
+		}
+		if names := listNames(t, f, "src"); !slices.Equal(names, []string{"new"}) {
+			t.Errorf("unexpected src entries: %v", names)
+		}
+	})
+
+	tests := []struct {
+		name             string
+		oldname, newname string
+		want             error
+	}{
+		{"dir onto file", "dir", "file", syscall.ENOTDIR},
+		{"file onto dir", "file", "dir", syscall.EISDIR},
+		{"non-empty dir", "dir", "full", syscall.ENOTEMPTY},
+		{"into itself", "dir", "dir/sub", fs.ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+			f := newUnion(t, map[string]string{"file": "f", "dir/c": "c", "full/x": "x"})
+			err := contextual.Rename(ctx, f, tt.oldname, tt.newname)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Rename(%q, %q) = %v, want %v", tt.oldname, tt.newname, err, tt.want)
+			}
+			var linkErr *os.LinkError
+			if !errors.As(err, &linkErr) || linkErr.Op != "rename" {
+				t.Errorf("expected rename *os.LinkError, got %v", err)
+			}
+			if _, err := contextual.Stat(ctx, f, tt.oldname); err != nil {
+				t.Errorf("expected %s to be left in place: %v", tt.oldname, err)
+			}
+		})
+	}
+
+	t.Run("same name", func(t *testing.T) {
+		f := newUnion(t, map[string]string{"file": "f"})
+		if err := contextual.Rename(t.Context(), f, "file", "file"); err != nil {
+			t.Errorf("Rename failed: %v", err)
+		}
+	})
+}
+
+func TestFS_RemoveAll_Parallel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	rw := cmockfs.NewMockDirFS(ctrl)
+	f := unionfs.New(rw)
+	unionfs.SetConcurrency(f, 2)
+
+	notEmpty := errors.New("directory not empty")
+	rw.EXPECT().Remove(t.Context(), "dir").Return(notEmpty)
+	e1 := mockfs.NewMockDirEntry(ctrl)
+	e1.EXPECT().Name().Return("a").AnyTimes()
+	e2 := mockfs.NewMockDirEntry(ctrl)
+	e2.EXPECT().Name().Return("b").AnyTimes()
+	rw.EXPECT().ReadDir(t.Context(), "dir").Return([]fs.DirEntry{e1, e2}, nil)
+	rw.EXPECT().Remove(t.Context(), "dir/a").Return(nil)
+	failure := errors.New("remove failed")
+	rw.EXPECT().Remove(t.Context(), "dir/b").Return(failure)
+	rw.EXPECT().ReadDir(t.Context(), "dir/b").Return(nil, fs.ErrInvalid)
+
+	err := f.RemoveAll(t.Context(), "dir")
+	if !errors.Is(err, failure) {
+		t.Errorf("expected aggregated error to contain %v, got %v", failure, err)
+	}
+}
+
+func TestFS_OpenDirectory_ReadDir(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, map[string]string{
+		"dir/a":     "rw",
+		"dir/.wh.b": "",
+	})
+	ro := newOSLayer(t, map[string]string{
+		"dir/a": "ro",
+		"dir/b": "ro",
+		"dir/c": "ro",
+	})
+	f := unionfs.New(rw, ro)
+
+	file, err := f.Open(ctx, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = file.Close() }()
+
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok {
+		t.Fatal("expected fs.ReadDirFile")
+	}
+
+	var names []string
+	for {
+		entries, err := dir.ReadDir(1)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected one entry per page, got %d", len(entries))
+		}
+		names = append(names, entries[0].Name())
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "c" {
+		t.Errorf("unexpected entries: %v", names)
+	}
+
+	if entries, err := dir.ReadDir(-1); err != nil || len(entries) != 0 {
+		t.Errorf("expected no remaining entries, got %v, %v", entries, err)
+	}
+
+	// fs.ReadDir on the union viewed as an fs.FS sees the same listing.
+	entries, err := fs.ReadDir(contextual.FromContextual(f, ctx), "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(entries))
+	}
+}
+
+func TestFS_CopyOnReadRules(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, nil)
+	ro := newOSLayer(t, map[string]string{
+		"media/big.mp4":     "video",
+		"media/notes.txt":   "notes",
+		"config/app.conf":   "conf",
+		"config/cache.json": "cache",
+	})
+	f := unionfs.New(rw, ro)
+
+	if err := unionfs.SetCopyOnReadRule(ctx, f, "config", unionfs.CopyOnReadAlways); err != nil {
+		t.Fatal(err)
+	}
+	if err := unionfs.SetCopyOnReadRule(ctx, f, "config/*.json", unionfs.CopyOnReadNever); err != nil {
+		t.Fatal(err)
+	}
+	if err := unionfs.SetCopyOnReadRule(ctx, f, "media/*.txt", unionfs.CopyOnReadAlways); err != nil {
+		t.Fatal(err)
+	}
+	if err := unionfs.SetCopyOnReadRule(ctx, f, "media/*.txt", unionfs.CopyOnReadDefault); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore the rules into a fresh union over the same layers.
+	f = unionfs.New(rw, ro)
+	if err := unionfs.LoadCopyOnReadRules(ctx, f); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"media/big.mp4", "media/notes.txt", "config/app.conf", "config/cache.json"} {
+		if _, err := f.ReadFile(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, copied := range map[string]bool{
+		"media/big.mp4":     false,
+		"media/notes.txt":   false,
+		"config/app.conf":   true,
+		"config/cache.json": false,
+	} {
+		_, err := contextual.Stat(ctx, rw, name)
+		if got := err == nil; got != copied {
+			t.Errorf("%s: copied = %v, want %v", name, got, copied)
+		}
+	}
+
+	entries, err := f.ReadDir(ctx, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() == unionfs.PolicyFile {
+			t.Error("expected the policy file to be hidden")
+		}
+	}
+
+	t.Run("overrides global flag", func(t *testing.T) {
+		unionfs.SetCopyOnRead(f, true)
+		if _, err := f.ReadFile(ctx, "media/big.mp4"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, rw, "media/big.mp4"); err != nil {
+			t.Errorf("expected copy with the global flag set: %v", err)
+		}
+		if _, err := f.ReadFile(ctx, "config/cache.json"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, rw, "config/cache.json"); err == nil {
+			t.Error("expected never rule to override the global flag")
+		}
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		if err := unionfs.SetCopyOnReadRule(ctx, f, "[", unionfs.CopyOnReadAlways); !errors.Is(err, path.ErrBadPattern) {
+			t.Errorf("expected ErrBadPattern, got %v", err)
+		}
+	})
+}
+
+func TestFS_Nested(t *testing.T) {
+	ctx := t.Context()
+	base := newOSLayer(t, map[string]string{
+		"a":     "base a",
+		"b":     "base b",
+		"dir/c": "base c",
+	})
+	innerRW := newOSLayer(t, nil)
+	inner := unionfs.New(innerRW, base)
+	if err := inner.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.WriteFile(ctx, "b", []byte("inner b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unionfs.SetCopyOnReadRule(ctx, inner, "dir", unionfs.CopyOnReadNever); err != nil {
+		t.Fatal(err)
+	}
+
+	outerRW := newOSLayer(t, nil)
+	outer := unionfs.New(outerRW, inner)
+
+	if layers := outer.Unwrap(); len(layers) != 3 || layers[0] != outerRW || layers[1] != innerRW || layers[2] != base {
+		t.Errorf("unexpected layers: %v", layers)
+	}
+
+	entries, err := outer.ReadDir(ctx, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "b" || names[1] != "dir" {
+		t.Errorf("unexpected entries: %v", names)
+	}
+
+	if _, err := outer.Stat(ctx, "a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected whiteout to hide a, got %v", err)
+	}
+	if _, err := outer.ReadFile(ctx, "a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected whiteout to hide a, got %v", err)
+	}
+	if _, err := outer.Open(ctx, "a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected whiteout to hide a, got %v", err)
+	}
+	if data, err := outer.ReadFile(ctx, "b"); err != nil || string(data) != "inner b" {
+		t.Errorf("ReadFile(b) = %q, %v", data, err)
+	}
+
+	// Removing a hidden file does not create a whiteout in the outer union.
+	if err := outer.Remove(ctx, "a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if _, err := contextual.Stat(ctx, outerRW, ".wh.a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected whiteout in outer layer: %v", err)
+	}
+}
+
+// fullLayer is a read-write layer that runs out of space: files opened for
+// writing fail their writes with ENOSPC, leaving what was created in place.
+type fullLayer struct {
+	contextual.FileSystem
+}
+
+type fullFile struct {
+	fsx.File
+}
+
+func (fullFile) Write([]byte) (int, error) {
+	return 0, syscall.ENOSPC
+}
+
+func (l fullLayer) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	file, err := contextual.OpenFile(ctx, l.FileSystem, name, flag, mode)
+	if err != nil || flag&fsx.O_ACCMODE == os.O_RDONLY {
+		return file, err
+	}
+	return fullFile{file}, nil
+}
+
+func (l fullLayer) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	return &fs.PathError{Op: "write", Path: name, Err: syscall.ENOSPC}
+}
+
+func TestFS_Full(t *testing.T) {
+	newLayers := func(t *testing.T) (contextual.FileSystem, contextual.FS) {
+		rw := newOSLayer(t, map[string]string{"upper.txt": "upper", ".wh.hidden.txt": ""})
+		ro := newOSLayer(t, map[string]string{"lower.txt": "lower", "hidden.txt": "hidden"})
+		return rw.(contextual.FileSystem), ro
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		ctx := t.Context()
+		rw, ro := newLayers(t)
+		f := unionfs.New(fullLayer{rw}, ro)
+
+		err := contextual.Chmod(ctx, f, "lower.txt", 0600)
+		if !errors.Is(err, syscall.ENOSPC) {
+			t.Errorf("expected ENOSPC, got %v", err)
+		}
+		// The partial copy-up was removed.
+		if _, err := contextual.Stat(ctx, rw, "lower.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected no partial copy in RW, got %v", err)
+		}
+		if unionfs.Degraded(f) {
+			t.Error("expected the union not to be degraded")
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		ctx := t.Context()
+		rw, ro := newLayers(t)
+		f := unionfs.New(fullLayer{rw}, ro)
+		unionfs.SetFullPolicy(f, unionfs.FullReadOnly)
+
+		err := contextual.WriteFile(ctx, f, "new.txt", []byte("new"), 0644)
+		var pErr *fs.PathError
+		if !errors.As(err, &pErr) || pErr.Path != "new.txt" || !errors.Is(err, unionfs.ErrReadOnlyDegraded) || !fsx.IsReadOnly(err) {
+			t.Errorf("expected ErrReadOnlyDegraded, got %v", err)
+		}
+		if !unionfs.Degraded(f) {
+			t.Error("expected the union to be degraded")
+		}
+		// Later writes fail without touching the layer, even those that
+		// would not need space.
+		if err := contextual.Mkdir(ctx, f, "dir", 0755); !errors.Is(err, unionfs.ErrReadOnlyDegraded) {
+			t.Errorf("expected ErrReadOnlyDegraded, got %v", err)
+		}
+		if err := contextual.Rename(ctx, f, "upper.txt", "moved.txt"); !errors.Is(err, unionfs.ErrReadOnlyDegraded) {
+			t.Errorf("expected ErrReadOnlyDegraded, got %v", err)
+		}
+		// Reads keep working, and copy-on-read is skipped.
+		unionfs.SetCopyOnRead(f, true)
+		if data, err := contextual.ReadFile(ctx, f, "lower.txt"); err != nil || string(data) != "lower" {
+			t.Errorf("ReadFile() = %q, %v", data, err)
+		}
+
+		unionfs.SetFullPolicy(f, unionfs.FullReadOnly)
+		if err := contextual.Mkdir(ctx, f, "dir", 0755); err != nil {
+			t.Errorf("Mkdir after reset: %v", err)
+		}
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		ctx := t.Context()
+		rw, ro := newLayers(t)
+		spill := newOSLayer(t, nil)
+		f := unionfs.New(fullLayer{rw}, ro)
+		unionfs.SetSpillLayer(f, spill)
+
+		// Space is available for directories.
+		if err := contextual.Mkdir(ctx, f, "dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, rw, "dir"); err != nil {
+			t.Errorf("expected dir in RW: %v", err)
+		}
+
+		if err := contextual.WriteFile(ctx, f, "new.txt", []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, spill, "new.txt"); err != nil || string(data) != "new" {
+			t.Errorf("ReadFile(spill) = %q, %v", data, err)
+		}
+
+		// Files of the full layer are copied up to the spill layer.
+		if err := contextual.Chmod(ctx, f, "upper.txt", 0600); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, spill, "upper.txt"); err != nil || string(data) != "upper" {
+			t.Errorf("ReadFile(spill) = %q, %v", data, err)
+		}
+
+		// The union still sees every layer, and the whiteouts of the full
+		// layer still apply.
+		for name, want := range map[string]string{"new.txt": "new", "upper.txt": "upper", "lower.txt": "lower"} {
+			if data, err := contextual.ReadFile(ctx, f, name); err != nil || string(data) != want {
+				t.Errorf("ReadFile(%q) = %q, %v", name, data, err)
+			}
+		}
+		if _, err := contextual.Stat(ctx, f, "hidden.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected hidden.txt to stay hidden, got %v", err)
+		}
+		entries, err := contextual.ReadDir(ctx, f, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if want := []string{"dir", "lower.txt", "new.txt", "upper.txt"}; !slices.Equal(names, want) {
+			t.Errorf("ReadDir() = %v, want %v", names, want)
+		}
+
+		// Removing a file of the full layer leaves a whiteout in the spill
+		// layer.
+		if err := contextual.Remove(ctx, f, "dir"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, f, "dir"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected dir to be removed, got %v", err)
+		}
+		if _, err := contextual.Stat(ctx, spill, ".wh.dir"); err != nil {
+			t.Errorf("expected a whiteout in the spill layer: %v", err)
+		}
+	})
+}
+
+func TestFS_Close(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, nil)
+	ro := newOSLayer(t, map[string]string{"file.txt": "data"})
+	f := unionfs.New(rw, unionfs.New(newOSLayer(t, nil), ro))
+
+	if err := contextual.Close(f); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	for i, layer := range []contextual.FS{rw, ro} {
+		if _, err := contextual.Stat(ctx, layer, "."); err == nil {
+			t.Errorf("layer %d: expected Stat to fail once closed", i)
+		}
+	}
+}
+
+// listNames returns the names of the entries of the directory name of fsys.
+func listNames(t *testing.T, fsys contextual.FS, name string) []string {
+	t.Helper()
+	entries, err := contextual.ReadDir(t.Context(), fsys, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestFS_Metadata(t *testing.T) {
+	// exercise removes dir/a, which lives in the read-only layer, and sets a
+	// copy-on-read rule, creating the control files of the union.
+	exercise := func(t *testing.T, f contextual.FS) {
+		t.Helper()
+		ctx := t.Context()
+		if err := contextual.Remove(ctx, f, "dir/a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := unionfs.SetCopyOnReadRule(ctx, f, "dir", unionfs.CopyOnReadNever); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, f, "dir/a"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected whiteout to hide dir/a, got %v", err)
+		}
+		if names := listNames(t, f, "."); !slices.Equal(names, []string{"dir"}) {
+			t.Errorf("unexpected root entries: %v", names)
+		}
+		if names := listNames(t, f, "dir"); !slices.Equal(names, []string{"b"}) {
+			t.Errorf("unexpected dir entries: %v", names)
+		}
+	}
+
+	t.Run("store", func(t *testing.T) {
+		ctx := t.Context()
+		rw := newOSLayer(t, nil)
+		meta := newOSLayer(t, nil)
+		ro := newOSLayer(t, map[string]string{"dir/a": "a", "dir/b": "b"})
+		f := unionfs.New(rw, ro)
+		unionfs.SetMetadataStore(f, meta)
+		exercise(t, f)
+
+		// The read-write layer holds no control files.
+		if names := listNames(t, rw, "."); len(names) != 0 {
+			t.Errorf("unexpected read-write entries: %v", names)
+		}
+		if _, err := contextual.Stat(ctx, meta, "dir/.wh.a"); err != nil {
+			t.Errorf("expected whiteout in the store: %v", err)
+		}
+		if _, err := contextual.Stat(ctx, meta, unionfs.PolicyFile); err != nil {
+			t.Errorf("expected policy file in the store: %v", err)
+		}
+
+		// A file named like a whiteout is a regular file of the layer.
+		if err := contextual.MkdirAll(ctx, f, "dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, f, "dir/.wh.b", []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if names := listNames(t, f, "dir"); !slices.Equal(names, []string{".wh.b", "b"}) {
+			t.Errorf("unexpected dir entries: %v", names)
+		}
+
+		// Recreating the file as a directory removes its whiteout from the
+		// store.
+		if err := contextual.Mkdir(ctx, f, "dir/a", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, meta, "dir/.wh.a"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected whiteout to be removed, got %v", err)
+		}
+
+		// A union stacked on top keeps honoring the whiteouts of the store.
+		if err := contextual.Remove(ctx, f, "dir/b"); err != nil {
+			t.Fatal(err)
+		}
+		outer := unionfs.New(newOSLayer(t, nil), f)
+		if names := listNames(t, outer, "dir"); !slices.Equal(names, []string{".wh.b", "a"}) {
+			t.Errorf("unexpected nested dir entries: %v", names)
+		}
+		if _, err := outer.Stat(ctx, "dir/b"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected whiteout to hide dir/b, got %v", err)
+		}
+
+		if err := contextual.Close(outer); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, meta, "."); err == nil {
+			t.Error("expected the store to be closed")
+		}
+	})
+
+	t.Run("dir", func(t *testing.T) {
+		ctx := t.Context()
+		rw := newOSLayer(t, nil)
+		ro := newOSLayer(t, map[string]string{"dir/a": "a", "dir/b": "b"})
+		f := unionfs.New(rw, ro)
+		unionfs.SetMetadataDir(f, unionfs.MetadataDir)
+		exercise(t, f)
+
+		if names := listNames(t, rw, "."); !slices.Equal(names, []string{unionfs.MetadataDir}) {
+			t.Errorf("unexpected read-write entries: %v", names)
+		}
+		if _, err := contextual.Stat(ctx, rw, path.Join(unionfs.MetadataDir, "dir/.wh.a")); err != nil {
+			t.Errorf("expected whiteout in the metadata directory: %v", err)
+		}
+		if _, err := contextual.Stat(ctx, rw, path.Join(unionfs.MetadataDir, unionfs.PolicyFile)); err != nil {
+			t.Errorf("expected policy file in the metadata directory: %v", err)
+		}
+
+		outer := unionfs.New(newOSLayer(t, nil), f)
+		if names := listNames(t, outer, "."); !slices.Equal(names, []string{"dir"}) {
+			t.Errorf("unexpected nested root entries: %v", names)
+		}
+		if _, err := outer.Stat(ctx, "dir/a"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected whiteout to hide dir/a, got %v", err)
+		}
+	})
+}
+
+func TestFS_MetadataFollowsNames(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(f contextual.FS)
+	}{
+		{"inline", func(f contextual.FS) {}},
+		{"store", func(f contextual.FS) { unionfs.SetMetadataStore(f, newOSLayer(t, nil)) }},
+		{"dir", func(f contextual.FS) { unionfs.SetMetadataDir(f, unionfs.MetadataDir) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := t.Context()
+			ro := newOSLayer(t, map[string]string{"src/a": "a", "src/b": "b", "dst/a": "a", "gone/a": "a"})
+			f := unionfs.New(newOSLayer(t, nil), ro)
+			tc.setup(f)
+
+			// The whiteouts of a directory move with it.
+			if err := contextual.Remove(ctx, f, "src/a"); err != nil {
+				t.Fatal(err)
+			}
+			if err := contextual.Rename(ctx, f, "src", "dst"); err != nil {
+				t.Fatal(err)
+			}
+			if names := listNames(t, f, "dst"); !slices.Equal(names, []string{"b"}) {
+				t.Errorf("unexpected dst entries: %v", names)
+			}
+
+			// The whiteouts of a directory go with it, and the directory
+			// recreated in its place hides the files of the removed one.
+			if err := contextual.Remove(ctx, f, "gone/a"); err != nil {
+				t.Fatal(err)
+			}
+			if err := contextual.RemoveAll(ctx, f, "gone"); err != nil {
+				t.Fatal(err)
+			}
+			if err := contextual.Mkdir(ctx, f, "gone", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if names := listNames(t, f, "gone"); len(names) != 0 {
+				t.Errorf("unexpected entries of the recreated directory: %v", names)
+			}
+		})
+	}
+}
+
+func TestFS_MetadataDirReserved(t *testing.T) {
+	ctx := t.Context()
+	f := unionfs.New(newOSLayer(t, nil), newOSLayer(t, map[string]string{"a": "a"}))
+	unionfs.SetMetadataDir(f, unionfs.MetadataDir)
+	if err := contextual.Remove(ctx, f, "a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{unionfs.MetadataDir, path.Join(unionfs.MetadataDir, ".wh.a")} {
+		if _, err := contextual.Stat(ctx, f, name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Stat(%s) error = %v; want ErrInvalid", name, err)
+		}
+		if err := contextual.RemoveAll(ctx, f, name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("RemoveAll(%s) error = %v; want ErrInvalid", name, err)
+		}
+	}
+	if err := contextual.WriteFile(ctx, f, path.Join(unionfs.MetadataDir, "x"), nil, 0644); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("WriteFile error = %v; want ErrInvalid", err)
+	}
+	if _, err := contextual.Stat(ctx, f, "a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a to stay hidden, got %v", err)
+	}
+}
+
+// fillingLayer creates a file in the directories moved aside to a scratch
+// name, as a concurrent writer would between the checks of a rename and the
+// rename itself.
+type fillingLayer struct {
+	contextual.FileSystem
+}
+
+func (l fillingLayer) Rename(ctx context.Context, oldname, newname string) error {
+	if strings.HasPrefix(path.Base(newname), ".cu.") {
+		if err := contextual.WriteFile(ctx, l.FileSystem, path.Join(oldname, "late"), nil, 0644); err != nil {
+			return err
+		}
+	}
+	return contextual.Rename(ctx, l.FileSystem, oldname, newname)
+}
+
+// flagLayer records the flags of the OpenFile calls made on a layer.
+type flagLayer struct {
+	contextual.FileSystem
+	flags *[]int
+}
+
+func (l flagLayer) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	*l.flags = append(*l.flags, flag)
+	return contextual.OpenFile(ctx, l.FileSystem, name, flag, mode)
+}
+
+// chownLayer records the ownership set with Lchown and Chown.
+type chownLayer struct {
+	contextual.FileSystem
+	owners map[string][2]string
+}
+
+func (l chownLayer) Lchown(ctx context.Context, name, owner, group string) error {
+	l.owners[name] = [2]string{owner, group}
+	return nil
+}
+
+func (l chownLayer) Chown(ctx context.Context, name, owner, group string) error {
+	l.owners[name] = [2]string{owner, group}
+	return nil
+}
+
+func (l chownLayer) Rename(ctx context.Context, oldname, newname string) error {
+	if err := l.FileSystem.Rename(ctx, oldname, newname); err != nil {
+		return err
+	}
+	if owner, ok := l.owners[oldname]; ok {
+		l.owners[newname] = owner
+		delete(l.owners, oldname)
+	}
+	return nil
+}
+
+func TestFS_SymlinkCopyUp(t *testing.T) {
+	ctx := t.Context()
+	ro := newOSLayer(t, map[string]string{"dir/target.txt": "data"})
+	for name, target := range map[string]string{"dir/link": "target.txt", "chain": "dir/link"} {
+		if err := contextual.Symlink(ctx, ro, target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isLink := func(fsys contextual.FS, name string) bool {
+		info, err := contextual.Lstat(ctx, fsys, name)
+		return err == nil && info.Mode()&fs.ModeSymlink != 0
+	}
+
+	t.Run("lchown", func(t *testing.T) {
+		rw := chownLayer{FileSystem: newOSLayer(t, nil).(contextual.FileSystem), owners: make(map[string][2]string)}
+		f := unionfs.New(rw, ro)
+		if err := contextual.Lchown(ctx, f, "dir/link", "alice", "users"); err != nil {
+			t.Fatal(err)
+		}
+		if !isLink(rw, "dir/link") {
+			t.Error("expected the link to be copied as a link")
+		}
+		if _, err := contextual.Lstat(ctx, rw, "dir/target.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the destination not to be copied, got %v", err)
+		}
+		if got := rw.owners["dir/link"]; got != [2]string{"alice", "users"} {
+			t.Errorf("owner of the link = %v", got)
+		}
+	})
+
+	t.Run("chown", func(t *testing.T) {
+		rw := chownLayer{FileSystem: newOSLayer(t, nil).(contextual.FileSystem), owners: make(map[string][2]string)}
+		f := unionfs.New(rw, ro)
+		if err := contextual.Chown(ctx, f, "chain", "alice", "users"); err != nil {
+			t.Fatal(err)
+		}
+		if got := rw.owners["dir/target.txt"]; got != [2]string{"alice", "users"} {
+			t.Errorf("owners = %v; want the destination changed", rw.owners)
+		}
+		if !isLink(rw, "chain") || !isLink(rw, "dir/link") {
+			t.Error("expected the links to be copied as links")
+		}
+	})
+
+	t.Run("chmod", func(t *testing.T) {
+		rw := newOSLayer(t, nil)
+		f := unionfs.New(rw, ro)
+		if err := contextual.Chmod(ctx, f, "chain", 0600); err != nil {
+			t.Fatal(err)
+		}
+		info, err := contextual.Lstat(ctx, rw, "dir/target.txt")
+		if err != nil || info.Mode() != 0600 {
+			t.Errorf("Lstat(dir/target.txt) = %v, %v; want a copy with mode 0600", info, err)
+		}
+		if !isLink(f, "chain") {
+			t.Error("expected chain to stay a link")
+		}
+		if data, err := contextual.ReadFile(ctx, f, "chain"); err != nil || string(data) != "data" {
+			t.Errorf("ReadFile(chain) = %q, %v; want data", data, err)
+		}
+	})
+
+	t.Run("chtimes", func(t *testing.T) {
+		rw := newOSLayer(t, nil)
+		f := unionfs.New(rw, ro)
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		if err := contextual.Chtimes(ctx, f, "dir/link", mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		info, err := contextual.Stat(ctx, f, "dir/target.txt")
+		if err != nil || !info.ModTime().Equal(mtime) {
+			t.Errorf("Stat(dir/target.txt) = %v, %v; want mtime %v", info, err, mtime)
+		}
+		if !isLink(rw, "dir/link") {
+			t.Error("expected the link to be copied as a link")
+		}
+	})
+}
+
+// linklessLayer is a read-write layer that cannot hold symbolic links.
+type linklessLayer struct {
+	contextual.MkdirAllFS
+}
+
+func TestFS_Linkless(t *testing.T) {
+	ctx := t.Context()
+	ro := newOSLayer(t, map[string]string{"dir/target.txt": "data"})
+	for name, target := range map[string]string{"dir/link": "target.txt", "chain": "dir/link"} {
+		if err := contextual.Symlink(ctx, ro, target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newLayer := func(t *testing.T) linklessLayer {
+		return linklessLayer{newOSLayer(t, nil).(contextual.MkdirAllFS)}
+	}
+
+	t.Run("detection", func(t *testing.T) {
+		if err := unionfs.SetLinklessPolicy(unionfs.New(newLayer(t), ro), unionfs.LinklessReject); !errors.Is(err, unionfs.ErrNoLinks) {
+			t.Errorf("expected ErrNoLinks, got %v", err)
+		}
+		if err := unionfs.SetLinklessPolicy(unionfs.New(newOSLayer(t, nil), ro), unionfs.LinklessReject); err != nil {
+			t.Errorf("expected a layer holding links to be accepted, got %v", err)
+		}
+		if err := unionfs.SetLinklessPolicy(unionfs.New(newLayer(t), ro), unionfs.LinklessCopy); err != nil {
+			t.Errorf("expected LinklessCopy to be accepted, got %v", err)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		rw := newLayer(t)
+		f := unionfs.New(rw, ro)
+		if err := contextual.Symlink(ctx, f, "dir/target.txt", "new"); !errors.Is(err, unionfs.ErrNoLinks) {
+			t.Errorf("Symlink: expected ErrNoLinks, got %v", err)
+		}
+		if _, err := contextual.OpenFile(ctx, f, "dir/link", os.O_WRONLY, 0); !errors.Is(err, unionfs.ErrNoLinks) {
+			t.Errorf("OpenFile: expected ErrNoLinks, got %v", err)
+		}
+		if _, err := contextual.Stat(ctx, rw, "dir"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the read-write layer to be left untouched, got %v", err)
+		}
+		if target, err := contextual.ReadLink(ctx, f, "dir/link"); err != nil || target != "target.txt" {
+			t.Errorf("ReadLink(dir/link) = %q, %v; want target.txt", target, err)
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		rw := newLayer(t)
+		f := unionfs.New(rw, ro)
+		if err := unionfs.SetLinklessPolicy(f, unionfs.LinklessCopy); err != nil {
+			t.Fatal(err)
+		}
+		file, err := contextual.OpenFile(ctx, f, "chain", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte("!")); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := contextual.Lstat(ctx, rw, "chain")
+		if err != nil || !info.Mode().IsRegular() {
+			t.Errorf("Lstat(chain) = %v, %v; want a regular file", info, err)
+		}
+		if data, err := contextual.ReadFile(ctx, f, "chain"); err != nil || string(data) != "data!" {
+			t.Errorf("ReadFile(chain) = %q, %v; want data!", data, err)
+		}
+		if data, err := contextual.ReadFile(ctx, f, "dir/target.txt"); err != nil || string(data) != "data" {
+			t.Errorf("ReadFile(dir/target.txt) = %q, %v; want data", data, err)
+		}
+
+		if err := contextual.Symlink(ctx, f, "dir/target.txt", "copy"); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, rw, "copy"); err != nil || string(data) != "data" {
+			t.Errorf("ReadFile(copy) = %q, %v; want data", data, err)
+		}
+		if _, err := contextual.ReadLink(ctx, f, "copy"); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("ReadLink(copy): expected EINVAL, got %v", err)
+		}
+		if target, err := contextual.ReadLink(ctx, f, "dir/link"); err != nil || target != "target.txt" {
+			t.Errorf("ReadLink(dir/link) = %q, %v; want target.txt", target, err)
+		}
+		for _, oldname := range []string{"dir", "/etc/passwd"} {
+			if err := contextual.Symlink(ctx, f, oldname, "bad"); !errors.Is(err, unionfs.ErrNoLinks) {
+				t.Errorf("Symlink(%s): expected ErrNoLinks, got %v", oldname, err)
+			}
+		}
+		if err := contextual.Symlink(ctx, f, "missing", "bad"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Symlink(missing): expected ErrNotExist, got %v", err)
+		}
+	})
+
+	t.Run("wrapped layer", func(t *testing.T) {
+		rw := newLayer(t)
+		// Implements contextual.SymlinkFS, forwarding to a layer that
+		// cannot hold links.
+		f := unionfs.New(contextual.PassthroughFS{Inner: rw}, ro)
+		if err := contextual.Symlink(ctx, f, "dir/target.txt", "new"); !errors.Is(err, unionfs.ErrNoLinks) {
+			t.Errorf("Symlink: expected ErrNoLinks, got %v", err)
+		}
+		if err := unionfs.SetLinklessPolicy(f, unionfs.LinklessReject); !errors.Is(err, unionfs.ErrNoLinks) {
+			t.Errorf("expected ErrNoLinks once found out, got %v", err)
+		}
+		if err := unionfs.SetLinklessPolicy(f, unionfs.LinklessCopy); err != nil {
+			t.Fatal(err)
+		}
+		file, err := contextual.OpenFile(ctx, f, "dir/link", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := contextual.Lstat(ctx, rw, "dir/link")
+		if err != nil || !info.Mode().IsRegular() {
+			t.Errorf("Lstat(dir/link) = %v, %v; want a regular file", info, err)
+		}
+	})
+}
+
+// hashLayer is a read-only layer knowing the digests of its files, whose
+// first torn opens read nothing. Its first open rewrites the file with
+// rewrite, if set, as a writer changing it under a copy-up would.
+type hashLayer struct {
+	contextual.FS
+	sums    map[string][]byte
+	torn    int
+	rewrite string
+	hashes  int
+}
+
+func (h *hashLayer) Hash(ctx context.Context, name string) ([]byte, error) {
+	h.hashes++
+	return h.sums[name], nil
+}
+
+func (h *hashLayer) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	return contextual.Stat(ctx, h.FS, name)
+}
+
+func (h *hashLayer) Open(ctx context.Context, name string) (fs.File, error) {
+	if h.rewrite != "" {
+		if err := contextual.WriteFile(ctx, h.FS, name, []byte(h.rewrite), 0644); err != nil {
+			return nil, err
+		}
+		h.rewrite = ""
+	}
+	f, err := h.FS.Open(ctx, name)
+	if err == nil && h.torn > 0 {
+		h.torn--
+		return tornFile{f}, nil
+	}
+	return f, err
+}
+
+// tornFile is a file whose contents cannot be read.
+type tornFile struct {
+	fs.File
+}
+
+func (tornFile) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func TestFS_CopyUpVerify(t *testing.T) {
+	ctx := t.Context()
+	sum := sha256.Sum256([]byte("data"))
+	changed := sha256.Sum256([]byte("changed"))
+	for _, tc := range []struct {
+		name    string
+		torn    int
+		rewrite string
+		sum     []byte
+		hashes  int
+		want    string
+		err     error
+	}{
+		{name: "valid", sum: sum[:], hashes: 1},
+		{name: "torn once", torn: 1, sum: sum[:], hashes: 1},
+		{name: "torn", torn: 2, sum: sum[:], err: unionfs.ErrCopyMismatch},
+		{name: "digest", sum: []byte("other"), hashes: 2, err: unionfs.ErrCopyMismatch},
+		{name: "changed source", rewrite: "changed", sum: changed[:], hashes: 1, want: "changed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ro := &hashLayer{FS: newOSLayer(t, map[string]string{"a.txt": "data"}), sums: map[string][]byte{"a.txt": tc.sum}, torn: tc.torn, rewrite: tc.rewrite}
+			rw := newOSLayer(t, nil)
+			f := unionfs.New(rw, ro)
+			err := contextual.Chmod(ctx, f, "a.txt", 0600)
+			if !errors.Is(err, tc.err) || (tc.err == nil) != (err == nil) {
+				t.Fatalf("Chmod() = %v; want %v", err, tc.err)
+			}
+			if ro.hashes != tc.hashes {
+				t.Errorf("hashes = %d; want %d", ro.hashes, tc.hashes)
+			}
+			data, err := contextual.ReadFile(ctx, rw, "a.txt")
+			if tc.err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("expected the corrupt copy to be removed, got %q, %v", data, err)
+				}
+				return
+			}
+			want := tc.want
+			if want == "" {
+				want = "data"
+			}
+			if err != nil || string(data) != want {
+				t.Errorf("ReadFile() = %q, %v; want %s", data, err, want)
+			}
+		})
+	}
+}
+
+// flakyLayer is a read-write layer whose first files opened for writing
+// fail their writes with EIO after writing a byte, as a network layer losing
+// its connection would.
+type flakyLayer struct {
+	contextual.FileSystem
+	failures int
+}
+
+type flakyFile struct {
+	fsx.File
+}
+
+func (f flakyFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:min(len(p), 1)])
+	return n, syscall.EIO
+}
+
+func (l *flakyLayer) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	file, err := contextual.OpenFile(ctx, l.FileSystem, name, flag, mode)
+	if err != nil || flag&fsx.O_ACCMODE == os.O_RDONLY || l.failures == 0 {
+		return file, err
+	}
+	l.failures--
+	return flakyFile{file}, nil
+}
+
+func TestFS_CopyUpRetry(t *testing.T) {
+	ctx := t.Context()
+	for _, tc := range []struct {
+		name     string
+		failures int
+		retry    unionfs.CopyUpRetry
+		err      error
+	}{
+		{name: "no retry", failures: 1, err: syscall.EIO},
+		{name: "retried", failures: 2, retry: unionfs.CopyUpRetry{Attempts: 3}},
+		{name: "exhausted", failures: 3, retry: unionfs.CopyUpRetry{Attempts: 3}, err: syscall.EIO},
+		{
+			name: "permanent", failures: 1, err: syscall.EIO,
+			retry: unionfs.CopyUpRetry{Attempts: 3, Transient: func(error) bool { return false }},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ro := newOSLayer(t, map[string]string{"dir/a.txt": "data"})
+			rw := &flakyLayer{FileSystem: memfs.New(memfs.Config{}), failures: tc.failures}
+			f := unionfs.New(rw, ro)
+			unionfs.SetCopyUpRetry(f, tc.retry)
+
+			err := contextual.Chmod(ctx, f, "dir/a.txt", 0600)
+			if !errors.Is(err, tc.err) || (tc.err == nil) != (err == nil) {
+				t.Fatalf("Chmod() = %v; want %v", err, tc.err)
+			}
+			// No partial copy or scratch file is left behind.
+			entries, err := contextual.ReadDir(ctx, rw.FileSystem, "dir")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			want := []string{"a.txt"}
+			if tc.err != nil {
+				want = nil
+			}
+			if !slices.Equal(names, want) {
+				t.Errorf("read-write layer holds %q; want %q", names, want)
+			}
+			if data, err := contextual.ReadFile(ctx, f, "dir/a.txt"); err != nil || string(data) != "data" {
+				t.Errorf("ReadFile() = %q, %v; want data", data, err)
+			}
+		})
+	}
+}
+
+func TestFS_CopyUpRetry_Backoff(t *testing.T) {
+	ro := newOSLayer(t, map[string]string{"a.txt": "data"})
+	rw := &flakyLayer{FileSystem: memfs.New(memfs.Config{}), failures: 1}
+	f := unionfs.New(rw, ro)
+	// The retry waits for the backoff on a clock that never moves, and gives
+	// up once the context is done.
+	ctx, cancel := context.WithCancel(t.Context())
+	unionfs.SetCopyUpRetry(f, unionfs.CopyUpRetry{
+		Attempts: 2,
+		Backoff:  time.Minute,
+		Transient: func(err error) bool {
+			cancel()
+			return unionfs.IsTransient(err)
+		},
+		Clock: fsxtest.NewClock(time.Unix(0, 0)),
+	})
+
+	if err := contextual.Chmod(ctx, f, "a.txt", 0600); !errors.Is(err, syscall.EIO) {
+		t.Errorf("Chmod() = %v; want EIO", err)
+	}
+	if entries, err := contextual.ReadDir(t.Context(), rw.FileSystem, "."); err != nil || len(entries) != 0 {
+		t.Errorf("expected the scratch file to be removed, got %v, %v", entries, err)
+	}
+}
+
+func TestFS_CopyUpScratchNames(t *testing.T) {
+	ctx := t.Context()
+	const reserved = ".cu.0123456789abcdef.f"
+	for _, tc := range []struct {
+		name  string
+		setup func(f contextual.FS, meta contextual.FS)
+		// hidden reports whether reserved names are refused by the union.
+		hidden bool
+	}{
+		{name: "inline", hidden: true},
+		{name: "dir", setup: func(f, _ contextual.FS) { unionfs.SetMetadataDir(f, unionfs.MetadataDir) }},
+		{name: "store", setup: func(f, meta contextual.FS) { unionfs.SetMetadataStore(f, meta) }, hidden: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ro := memfs.New(memfs.Config{})
+			if err := ro.WriteFile(ctx, "f", []byte("lower"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			rw := memfs.New(memfs.Config{})
+			f := unionfs.New(rw, ro)
+			if tc.setup != nil {
+				tc.setup(f, memfs.New(memfs.Config{}))
+			}
+
+			// Names close to those of scratch files are the user's.
+			if err := f.WriteFile(ctx, ".cu.f", []byte("mine"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := f.WriteFile(ctx, reserved, []byte("mine"), 0644)
+			if tc.hidden != errors.Is(err, fs.ErrInvalid) {
+				t.Fatalf("WriteFile(%q) = %v; want ErrInvalid: %v", reserved, err, tc.hidden)
+			}
+
+			if err := f.Chmod(ctx, "f", 0600); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]string{".cu.f": "mine", "f": "lower"} {
+				if data, err := f.ReadFile(ctx, name); err != nil || string(data) != want {
+					t.Errorf("ReadFile(%q) = %q, %v; want %q", name, data, err, want)
+				}
+			}
+			entries, err := f.ReadDir(ctx, ".")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			want := []string{".cu.f", "f"}
+			if !tc.hidden {
+				want = []string{reserved, ".cu.f", "f"}
+			}
+			if !slices.Equal(names, want) {
+				t.Errorf("ReadDir() = %q; want %q", names, want)
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&fs.PathError{Op: "write", Path: "a", Err: syscall.EIO}, true},
+		{syscall.ETIMEDOUT, true},
+		{syscall.ECONNRESET, true},
+		{syscall.ENOSPC, false},
+		{fs.ErrNotExist, false},
+		{fs.ErrPermission, false},
+		{context.Canceled, false},
+		{errors.Join(context.DeadlineExceeded, syscall.EIO), false},
+	} {
+		if got := unionfs.IsTransient(tc.err); got != tc.want {
+			t.Errorf("IsTransient(%v) = %v; want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestFS_CopyUpMode(t *testing.T) {
+	ctx := t.Context()
+	ro := memfs.New(memfs.Config{})
+	if err := contextual.Mkdir(ctx, ro, "shared", 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.WriteFile(ctx, ro, "shared/tool", []byte("tool"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]fs.FileMode{
+		"shared":      fs.ModeDir | fs.ModeSetgid | fs.ModeSticky | 0775,
+		"shared/tool": fs.ModeSetuid | 0755,
+	}
+	for name, mode := range want {
+		if err := contextual.Chmod(ctx, ro, name, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rw := memfs.New(memfs.Config{})
+	f := unionfs.New(rw, ro)
+	for _, name := range []string{"shared", "shared/tool"} {
+		if err := contextual.Chtimes(ctx, f, name, time.Time{}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, mode := range want {
+		if info, err := contextual.Lstat(ctx, rw, name); err != nil || info.Mode() != mode {
+			t.Errorf("Lstat(%s) = %v, %v; want mode %v", name, info, err, mode)
+		}
+	}
+}
+
+func TestFS_CopyUpUmask(t *testing.T) {
+	ctx := contextual.WithUmask(t.Context(), 077)
+	ro := memfs.New(memfs.Config{})
+	if err := contextual.Mkdir(ctx, ro, "dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, perm := range map[string]fs.FileMode{"dir": 0755, "dir/tool": 0755, "dir/moved": 0644} {
+		if name != "dir" {
+			if err := contextual.WriteFile(ctx, ro, name, []byte(name), perm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := contextual.Chmod(ctx, ro, name, perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rw := memfs.New(memfs.Config{})
+	f := unionfs.New(rw, ro)
+	// The copies take the modes of the originals, whatever the umask.
+	if err := contextual.Chtimes(ctx, f, "dir/tool", time.Time{}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.Rename(ctx, f, "dir/moved", "dir/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]fs.FileMode{
+		"dir":         fs.ModeDir | 0755,
+		"dir/tool":    0755,
+		"dir/renamed": 0644,
+	}
+	for name, mode := range want {
+		if info, err := contextual.Lstat(ctx, rw, name); err != nil || info.Mode() != mode {
+			t.Errorf("Lstat(%s) = %v, %v; want mode %v", name, info, err, mode)
+		}
+	}
+
+	// Files created through the union still honour the umask.
+	if err := contextual.WriteFile(ctx, f, "dir/new", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := contextual.Lstat(ctx, rw, "dir/new"); err != nil || info.Mode() != 0600 {
+		t.Errorf("Lstat(dir/new) = %v, %v; want mode %v", info, err, fs.FileMode(0600))
+	}
+}
+
+func TestFS_OwnerMapping(t *testing.T) {
+	ctx := t.Context()
+	ro := newOSLayer(t, map[string]string{"dir/file": "data", "other": "data"})
+	info, err := contextual.Stat(ctx, ro, "dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xinfo := contextual.ExtendFileInfo(info)
+
+	rw := chownLayer{FileSystem: newOSLayer(t, nil).(contextual.FileSystem), owners: make(map[string][2]string)}
+	f := unionfs.New(rw, ro)
+	unionfs.SetOwnerMapping(f,
+		fsxtest.Resolver{Users: map[string]string{xinfo.Owner(): "7"}, Groups: map[string]string{xinfo.Group(): "8"}},
+		fsxtest.Resolver{Users: map[string]string{"alice": "7"}, Groups: map[string]string{"staff": "8"}})
+
+	file, err := contextual.OpenFile(ctx, f, "dir/file", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	if got, want := rw.owners["dir/file"], [2]string{"alice", "staff"}; got != want {
+		t.Errorf("owner of the copy = %v, want %v", got, want)
+	}
+
+	// Without a mapping, copy-up leaves the ownership alone.
+	unionfs.SetOwnerMapping(f, nil, nil)
+	if err := contextual.Chmod(ctx, f, "other", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rw.owners["other"]; ok {
+		t.Error("expected no ownership to be set without a mapping")
+	}
+}
+
+// infoLayer counts the listings made with ReadDirInfos.
+type infoLayer struct {
+	contextual.FileSystem
+	calls *int
+}
+
+func (l infoLayer) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	*l.calls++
+	return contextual.ReadDirInfos(ctx, l.FileSystem, name)
+}
+
+func TestFS_ReadDirInfos(t *testing.T) {
+	ctx := t.Context()
+	var calls int
+	rw := newOSLayer(t, map[string]string{"dir/a": "rw"})
+	ro := infoLayer{FileSystem: newOSLayer(t, map[string]string{"dir/a": "ro-a", "dir/b": "ro-b", "dir/c": "ro-c"}).(contextual.FileSystem), calls: &calls}
+	f := unionfs.New(rw, ro)
+	if err := contextual.Remove(ctx, f, "dir/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := contextual.ReadDirInfos(ctx, f, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the read-only layer to be listed with ReadDirInfos once, got %d", calls)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if want := []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Fatalf("ReadDirInfos() = %v, want %v", got, want)
+	}
+	// The entry of the upper layer wins.
+	if entries[0].Size() != 2 || entries[1].Size() != 4 {
+		t.Errorf("unexpected sizes %d, %d", entries[0].Size(), entries[1].Size())
+	}
+
+	if _, err := contextual.ReadDirInfos(ctx, f, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+// pagedLayer counts the listings of its directories, whole or a page at a
+// time.
+type pagedLayer struct {
+	contextual.FileSystem
+	lists, pages *int
+}
+
+func (l pagedLayer) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	*l.lists++
+	return contextual.ReadDir(ctx, l.FileSystem, name)
+}
+
+func (l pagedLayer) ReadDirPage(ctx context.Context, name, cursor string, n int) ([]fs.DirEntry, string, error) {
+	*l.pages++
+	return contextual.ReadDirPage(ctx, l.FileSystem, name, cursor, n)
+}
+
+func TestFS_ReadDirSeq(t *testing.T) {
+	ctx := t.Context()
+	newLayers := func(t *testing.T) (contextual.FS, contextual.FS) {
+		t.Helper()
+		ro := memfs.New(memfs.Config{})
+		for _, name := range []string{"dir/!x", "dir/-y", "dir/a", "dir/b", "dir/c", "dir/d", "dir/z"} {
+			if err := contextual.MkdirAll(ctx, ro, "dir", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := contextual.WriteFile(ctx, ro, name, []byte("ro"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return newOSLayer(t, map[string]string{"dir/b": "rw", "dir/e": "rw"}), ro
+	}
+	// check compares the streamed listing of dir, read a page at a time,
+	// and that of an open directory with ReadDir.
+	check := func(t *testing.T, f contextual.FS, dir string) {
+		t.Helper()
+		want, err := contextual.ReadDir(ctx, f, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := contextual.ReadDirSeq(ctx, f, dir, contextual.PageSize(1))
+		var got []fs.DirEntry
+		for e := range r.All() {
+			got = append(got, e)
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		eq := func(a, b fs.DirEntry) bool { return a.Name() == b.Name() && a.Type() == b.Type() }
+		if !slices.EqualFunc(got, want, eq) {
+			t.Errorf("ReadDirSeq() = %v; want %v", got, want)
+		}
+
+		file, err := contextual.Open(ctx, f, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		got = nil
+		for {
+			page, err := file.(fs.ReadDirFile).ReadDir(2)
+			got = append(got, page...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !slices.EqualFunc(got, want, eq) {
+			t.Errorf("ReadDir(2) = %v; want %v", got, want)
+		}
+	}
+	remove := func(t *testing.T, f contextual.FS, names ...string) {
+		t.Helper()
+		for _, name := range names {
+			if err := contextual.Remove(ctx, f, name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("inline", func(t *testing.T) {
+		rw, ro := newLayers(t)
+		f := unionfs.New(rw, ro)
+		remove(t, f, "dir/!x", "dir/c", "dir/z")
+		check(t, f, "dir")
+		check(t, f, ".")
+	})
+
+	t.Run("recreated", func(t *testing.T) {
+		rw, ro := newLayers(t)
+		f := unionfs.New(rw, ro)
+		if err := contextual.RemoveAll(ctx, f, "dir"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.MkdirAll(ctx, f, "dir/new", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if names := listNames(t, f, "dir"); !slices.Equal(names, []string{"new"}) {
+			t.Errorf("ReadDir(dir) = %v; want [new]", names)
+		}
+		check(t, f, "dir")
+	})
+
+	t.Run("metadata dir", func(t *testing.T) {
+		rw, ro := newLayers(t)
+		f := unionfs.New(rw, ro)
+		unionfs.SetMetadataDir(f, unionfs.MetadataDir)
+		remove(t, f, "dir/-y", "dir/b", "dir/d")
+		check(t, f, "dir")
+		check(t, f, ".")
+	})
+
+	t.Run("flattened", func(t *testing.T) {
+		rw, ro := newLayers(t)
+		inner := unionfs.New(rw, ro)
+		remove(t, inner, "dir/a", "dir/c")
+		f := unionfs.New(newOSLayer(t, map[string]string{"dir/c": "top"}), inner)
+		remove(t, f, "dir/z")
+		check(t, f, "dir")
+	})
+
+	t.Run("large", func(t *testing.T) {
+		const files = 1000
+		var lists, pages int
+		mem := memfs.New(memfs.Config{})
+		ro := pagedLayer{FileSystem: mem, lists: &lists, pages: &pages}
+		if err := contextual.Mkdir(ctx, mem, "dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i := range files {
+			if err := contextual.WriteFile(ctx, mem, fmt.Sprintf("dir/%04d", i), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		f := unionfs.New(newOSLayer(t, map[string]string{"dir/0500": "rw"}), ro)
+		remove(t, f, "dir/0001")
+
+		r := contextual.ReadDirSeq(ctx, f, "dir", contextual.PageSize(100))
+		n := 0
+		for e := range r.All() {
+			if e.Name() == "0001" {
+				t.Error("expected the removed file to be hidden")
+			}
+			n++
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if n != files-1 {
+			t.Errorf("ReadDirSeq() listed %d entries; want %d", n, files-1)
+		}
+		// The read-only layer is read a page at a time, never whole.
+		if lists != 0 || pages < files/100 {
+			t.Errorf("read-only layer listed whole %d times and in %d pages; want only pages", lists, pages)
+		}
+	})
+
+	t.Run("not exist", func(t *testing.T) {
+		rw, ro := newLayers(t)
+		r := contextual.ReadDirSeq(ctx, unionfs.New(rw, ro), "missing")
+		for range r.All() {
+			t.Error("unexpected entry")
+		}
+		if err := r.Err(); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected ErrNotExist, got %v", err)
+		}
+	})
+}
+
+func TestFS_Manifest(t *testing.T) {
+	ctx := t.Context()
+	files := map[string]string{"a": "a", "dir/b": "b", "dir/c": "c"}
+	src := unionfs.New(newOSLayer(t, nil), newOSLayer(t, files))
+	for _, name := range []string{"a", "dir/b"} {
+		if err := contextual.Remove(ctx, src, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := unionfs.ExportManifest(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"version":1,"whiteouts":["a","dir/b"]}`; string(data) != want {
+		t.Errorf("manifest = %s, want %s", data, want)
+	}
+
+	var decoded unionfs.Manifest
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	dst := unionfs.New(newOSLayer(t, nil), newOSLayer(t, files))
+	unionfs.SetMetadataDir(dst, unionfs.MetadataDir)
+	if err := unionfs.ImportManifest(ctx, dst, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "dir/b"} {
+		if _, err := contextual.Stat(ctx, dst, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to be hidden, got %v", name, err)
+		}
+	}
+	if _, err := contextual.Stat(ctx, dst, "dir/c"); err != nil {
+		t.Errorf("expected dir/c to stay visible: %v", err)
+	}
+	if got, err := unionfs.ExportManifest(ctx, dst); err != nil || !slices.Equal(got.Whiteouts, m.Whiteouts) {
+		t.Errorf("ExportManifest() = %v, %v, want %v", got, err, m.Whiteouts)
+	}
+
+	if err := unionfs.ImportManifest(ctx, dst, &unionfs.Manifest{Version: 2}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for an unknown version, got %v", err)
+	}
+	bad := &unionfs.Manifest{Version: unionfs.ManifestVersion, Whiteouts: []string{"../escape"}}
+	if err := unionfs.ImportManifest(ctx, dst, bad); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected ErrInvalid for an invalid name, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, map[string]string{"b": "b"})
+	ro := newOSLayer(t, map[string]string{"a": "a", "b": "b", "c": "c", "x": "x"})
+	f := unionfs.New(rw, ro)
+	if err := contextual.Remove(ctx, f, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.Remove(ctx, ro, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.WriteFile(ctx, f, "c", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"x", ".wh.y"} {
+		if err := contextual.Mkdir(ctx, rw, dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := unionfs.Check(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []unionfs.Issue{
+		{Kind: unionfs.IssueStaleWhiteout, Name: "a"},
+		{Kind: unionfs.IssueRedundantCopy, Name: "b"},
+		{Kind: unionfs.IssueControlMode, Name: "y"},
+		{Kind: unionfs.IssueTypeConflict, Name: "x"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Check() = %v; want %v", got, want)
+	}
+
+	got, err = unionfs.Check(ctx, f, unionfs.Repair())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want[0].Fixed, want[1].Fixed = true, true
+	if !slices.Equal(got, want) {
+		t.Errorf("Check(Repair()) = %v; want %v", got, want)
+	}
+	if _, err := contextual.Stat(ctx, rw, "b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the copy of b to be removed, got %v", err)
+	}
+	if data, err := contextual.ReadFile(ctx, f, "b"); err != nil || string(data) != "b" {
+		t.Errorf("ReadFile(b) = %q, %v; want b", data, err)
+	}
+	got, err = unionfs.Check(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := want[2:]; !slices.Equal(got, want) {
+		t.Errorf("Check() after repair = %v; want %v", got, want)
+	}
+	if s := (unionfs.Issue{Kind: unionfs.IssueStaleWhiteout, Name: "a", Fixed: true}).String(); s != "stale-whiteout a (fixed)" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestFS_OpenFile_Flags(t *testing.T) {
+	tests := []struct {
+		name       string
+		flag       int
+		copyOnRead bool
+		// wantErr is the error expected from OpenFile.
+		wantErr error
+		// write is written to the opened file, if any.
+		write string
+		// want is the content of the file in the union afterwards.
+		want string
+		// copied tells whether the file ends up in the read-write layer.
+		copied bool
+		// reopened tells whether copy-on-read reopened the file in the
+		// read-write layer, and reopen is the flag it used.
+		reopened bool
+		reopen   int
+	}{
+		{name: "read", flag: os.O_RDONLY, want: "lower"},
+		{name: "read copy", flag: os.O_RDONLY, copyOnRead: true, want: "lower", copied: true, reopened: true, reopen: os.O_RDONLY},
+		{name: "read excl copy", flag: os.O_RDONLY | os.O_EXCL, copyOnRead: true, wantErr: fs.ErrInvalid, want: "lower"},
+		{name: "read truncate", flag: os.O_RDONLY | os.O_TRUNC, wantErr: fs.ErrInvalid, want: "lower"},
+		{name: "read sync copy", flag: os.O_RDONLY | os.O_SYNC, copyOnRead: true, want: "lower", copied: true, reopened: true, reopen: os.O_RDONLY | os.O_SYNC},
+		{name: "create excl", flag: os.O_WRONLY | os.O_CREATE | os.O_EXCL, wantErr: fs.ErrExist, want: "lower"},
+		{name: "create excl copy", flag: os.O_RDWR | os.O_CREATE | os.O_EXCL, copyOnRead: true, wantErr: fs.ErrExist, want: "lower"},
+		{name: "create", flag: os.O_RDWR | os.O_CREATE, write: "L", want: "Lower", copied: true},
+		{name: "append", flag: os.O_WRONLY | os.O_APPEND, write: "!", want: "lower!", copied: true},
+		{name: "create append", flag: os.O_WRONLY | os.O_CREATE | os.O_APPEND, write: "!", want: "lower!", copied: true},
+		{name: "truncate", flag: os.O_WRONLY | os.O_TRUNC, write: "new", want: "new", copied: true},
+		{name: "sync", flag: os.O_WRONLY | os.O_SYNC, write: "L", want: "Lower", copied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+			var flags []int
+			rw := flagLayer{newOSLayer(t, nil).(contextual.FileSystem), &flags}
+			ro := newOSLayer(t, map[string]string{"file.txt": "lower"})
+			f := unionfs.New(rw, ro)
+			unionfs.SetCopyOnRead(f, tt.copyOnRead)
+
+			file, err := f.OpenFile(ctx, "file.txt", tt.flag, 0644)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.write != "" {
+					if _, err := file.Write([]byte(tt.write)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := file.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := contextual.Stat(ctx, rw, "file.txt"); (err == nil) != tt.copied {
+				t.Errorf("copied = %v, want %v", err == nil, tt.copied)
+			}
+			if tt.reopened && (len(flags) == 0 || flags[len(flags)-1] != tt.reopen) {
+				t.Errorf("reopened with %v, want %#x", flags, tt.reopen)
+			}
+			if data, err := f.ReadFile(ctx, "file.txt"); err != nil || string(data) != tt.want {
+				t.Errorf("ReadFile() = %q, %v, want %q", data, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFS_ErrorOps(t *testing.T) {
+	ro := newOSLayer(t, map[string]string{"file.txt": "data"})
+	fsxtest.CheckErrorOps(t, unionfs.New(newOSLayer(t, nil), ro), "missing")
+}
+
+// blockingLayer blocks removals until release is closed.
+type blockingLayer struct {
+	contextual.FileSystem
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (l blockingLayer) Remove(ctx context.Context, name string) error {
+	close(l.entered)
+	<-l.release
+	return contextual.Remove(ctx, l.FileSystem, name)
+}
+
+func TestFS_StrictConsistency(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, nil)
+	f := unionfs.New(rw, newOSLayer(t, map[string]string{"file.txt": "base"}))
+	unionfs.SetStrictConsistency(f, true)
+
+	ops := []func() error{
+		func() error {
+			file, err := f.OpenFile(ctx, "file.txt", os.O_WRONLY|os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			return file.Close()
+		},
+		func() error { return f.WriteFile(ctx, "file.txt", []byte("new"), 0644) },
+		func() error { return f.Remove(ctx, "file.txt") },
+		func() error {
+			data, err := f.ReadFile(ctx, "file.txt")
+			if err == nil && !slices.Contains([]string{"base", "new", ""}, string(data)) {
+				return fmt.Errorf("unexpected contents %q", data)
+			}
+			return err
+		},
+		func() error {
+			_, err := f.Stat(ctx, "file.txt")
+			return err
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Go(func() {
+			for j := range 200 {
+				if err := ops[(i+j)%len(ops)](); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					t.Error(err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	// The union is left consistent: once removed, the file is gone from
+	// every view, and it can be written again.
+	if err := f.Remove(ctx, "file.txt"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	if _, err := f.Stat(ctx, "file.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() after Remove: %v", err)
+	}
+	if entries, err := f.ReadDir(ctx, "."); err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir() after Remove = %v, %v", entries, err)
+	}
+	if err := f.WriteFile(ctx, "file.txt", []byte("last"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := f.ReadFile(ctx, "file.txt"); err != nil || string(data) != "last" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+
+	// Internal calls of a locked operation do not deadlock.
+	if err := f.Rename(ctx, "file.txt", "file.txt"); err != nil {
+		t.Error(err)
+	}
+	unionfs.SetStrictRename(f, true)
+	if err := f.Rename(ctx, "file.txt", "moved.txt"); err != nil {
+		t.Error(err)
+	}
+
+	// A Stat of a path waits for the Remove in progress on it.
+	layer := blockingLayer{FileSystem: rw.(contextual.FileSystem), entered: make(chan struct{}), release: make(chan struct{})}
+	f = unionfs.New(layer)
+	unionfs.SetStrictConsistency(f, true)
+	if err := f.WriteFile(ctx, "file.txt", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	removed := make(chan error, 1)
+	go func() { removed <- f.Remove(ctx, "file.txt") }()
+	<-layer.entered
+	stat := make(chan error, 1)
+	go func() {
+		_, err := f.Stat(ctx, "file.txt")
+		stat <- err
+	}()
+	select {
+	case err := <-stat:
+		t.Fatalf("Stat() returned %v while Remove was in progress", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(layer.release)
+	if err := <-removed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-stat; !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() after Remove: %v", err)
+	}
+}
+
+func TestFS_HandleContinuity(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		enabled bool
+		want    string
+	}{
+		{name: "disabled", want: "456"},
+		{name: "enabled", enabled: true, want: "EFG"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := t.Context()
+			f := unionfs.New(newOSLayer(t, nil), newOSLayer(t, map[string]string{"file.txt": "0123456789"}))
+			unionfs.SetHandleContinuity(f, tt.enabled)
+
+			reader, err := f.Open(ctx, "file.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = reader.Close() }()
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "0123" {
+				t.Fatalf("Read() = %q, %v", buf, err)
+			}
+
+			// Writing copies the file up and changes the copy.
+			writer, err := f.OpenFile(ctx, "file.txt", os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := writer.Write([]byte("ABCDEFGHIJ")); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			buf = make([]byte, 3)
+			if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != tt.want {
+				t.Errorf("Read() after copy-up = %q, %v, want %q", buf, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("seek and copy-on-read", func(t *testing.T) {
+		ctx := t.Context()
+		f := unionfs.New(newOSLayer(t, nil), newOSLayer(t, map[string]string{"file.txt": "0123456789"}))
+		unionfs.SetHandleContinuity(f, true)
+
+		reader, err := f.Open(ctx, "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = reader.Close() }()
+		if _, err := reader.(io.Seeker).Seek(6, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		// A copy made by copy-on-read is followed too.
+		unionfs.SetCopyOnRead(f, true)
+		if _, err := f.ReadFile(ctx, "file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Truncate(ctx, "file.txt", 8); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := io.ReadAll(reader); err != nil || string(data) != "67" {
+			t.Errorf("ReadAll() = %q, %v, want %q", data, err, "67")
+		}
+		buf := make([]byte, 2)
+		if _, err := reader.(io.ReaderAt).ReadAt(buf, 0); err != nil || string(buf) != "01" {
+			t.Errorf("ReadAt() = %q, %v", buf, err)
+		}
+		if info, err := reader.Stat(); err != nil || info.Size() != 8 {
+			t.Errorf("Stat() = %v, %v, want the size of the copy", info, err)
+		}
+	})
+}
+
+func TestFS_InvalidPaths(t *testing.T) {
+	// Invalid names never reach the layers.
+	ctrl := gomock.NewController(t)
+	fsxtest.CheckInvalidPaths(t, unionfs.New(cmockfs.NewMockFileSystem(ctrl), cmockfs.NewMockFileSystem(ctrl)))
+}
+
+func TestFS_Stats(t *testing.T) {
+	ctx := t.Context()
+	files := map[string]string{"a": "12345", "b": "123", "dir/c": "1", "dir/d": "1"}
+	for _, metaDir := range []bool{false, true} {
+		t.Run(fmt.Sprintf("metaDir=%v", metaDir), func(t *testing.T) {
+			fsys := unionfs.New(newOSLayer(t, nil), newOSLayer(t, files))
+			if metaDir {
+				unionfs.SetMetadataDir(fsys, unionfs.MetadataDir)
+			}
+			unionfs.SetCopyOnRead(fsys, true)
+
+			// a is copied up by a write and b by a read.
+			f, err := contextual.OpenFile(ctx, fsys, "a", os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Write([]byte("67")); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := contextual.ReadFile(ctx, fsys, "b"); err != nil {
+				t.Fatal(err)
+			}
+			if err := contextual.WriteFile(ctx, fsys, "new", []byte("1234"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"dir/c", "dir/d"} {
+				if err := contextual.Remove(ctx, fsys, name); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := unionfs.Stats(ctx, fsys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := unionfs.Usage{CopyUps: 2, CopyUpBytes: 8, WrittenBytes: 6, Whiteouts: 2}
+			if got != want {
+				t.Errorf("Stats() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// vanishingLayer stands for a cache evicting its files as they are opened.
+type vanishingLayer struct {
+	contextual.FS
+}
+
+func (v vanishingLayer) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	return contextual.Stat(ctx, v.FS, name)
+}
+
+func (v vanishingLayer) Open(ctx context.Context, name string) (fs.File, error) {
+	if err := contextual.Remove(ctx, v.FS, name); err != nil {
+		return nil, err
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func TestFS_Refetch(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("refetch", func(t *testing.T) {
+		origin := newOSLayer(t, map[string]string{"a": "a"})
+		cache := newOSLayer(t, map[string]string{"a": "a"})
+		f := unionfs.New(newOSLayer(t, nil), cache)
+		var fetched []string
+		unionfs.SetRefetch(f, func(ctx context.Context, name string) error {
+			fetched = append(fetched, name)
+			data, err := contextual.ReadFile(ctx, origin, name)
+			if err != nil {
+				return err
+			}
+			return contextual.WriteFile(ctx, cache, name, data, 0644)
+		})
+
+		// The file is evicted from the cache after it was seen.
+		if _, err := contextual.Stat(ctx, f, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.Remove(ctx, cache, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, f, "a"); err != nil || string(data) != "a" {
+			t.Errorf("ReadFile() = %q, %v, want a", data, err)
+		}
+		if err := contextual.Remove(ctx, cache, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, f, "a"); err != nil {
+			t.Errorf("Stat() = %v", err)
+		}
+		// Missing files are fetched once and reported missing.
+		if _, err := contextual.Stat(ctx, f, "missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat() = %v, want ErrNotExist", err)
+		}
+		if want := []string{"a", "a", "missing"}; !slices.Equal(fetched, want) {
+			t.Errorf("fetched %v, want %v", fetched, want)
+		}
+	})
+
+	t.Run("creations", func(t *testing.T) {
+		f := unionfs.New(newOSLayer(t, nil), newOSLayer(t, nil))
+		var fetched []string
+		unionfs.SetRefetch(f, func(ctx context.Context, name string) error {
+			fetched = append(fetched, name)
+			return nil
+		})
+		file, err := contextual.OpenFile(ctx, f, "new", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := contextual.WriteFile(ctx, f, "written", nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if len(fetched) != 0 {
+			t.Errorf("fetched %v for new files, want nothing", fetched)
+		}
+	})
+
+	t.Run("nested unions", func(t *testing.T) {
+		inner := unionfs.New(newOSLayer(t, nil), newOSLayer(t, nil))
+		f := unionfs.New(inner, newOSLayer(t, nil))
+		var fetched []string
+		for _, u := range []contextual.FS{inner, f} {
+			unionfs.SetRefetch(u, func(ctx context.Context, name string) error {
+				fetched = append(fetched, name)
+				return nil
+			})
+		}
+		// The retry of the outer union looks the file up again through the
+		// inner one, which refetches it too.
+		if _, err := contextual.Stat(ctx, f, "missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat() = %v, want ErrNotExist", err)
+		}
+		n := 0
+		for _, name := range fetched {
+			if name == "missing" {
+				n++
+			}
+		}
+		if n != 3 {
+			t.Errorf("fetched %v, want the inner, outer then inner union to refetch missing", fetched)
+		}
+	})
+
+	t.Run("copy-up falls back to lower layers", func(t *testing.T) {
+		rw := newOSLayer(t, nil)
+		cache := newOSLayer(t, map[string]string{"a": "cached"})
+		f := unionfs.New(rw, vanishingLayer{cache}, newOSLayer(t, map[string]string{"a": "origin"}))
+
+		file, err := contextual.OpenFile(ctx, f, "a", os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte("+")); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(ctx, rw, "a"); err != nil || string(data) != "origin+" {
+			t.Errorf("copy = %q, %v, want origin+", data, err)
+		}
+	})
+}
+
+func TestFS_LinkPolicy(t *testing.T) {
+	ctx := t.Context()
+	ro := newOSLayer(t, map[string]string{"a.txt": "a", "secret/key": "k", "dir/b.txt": "b"})
+	links := map[string]string{
+		"dir/abs":     "/etc/passwd",
+		"dir/up":      "../../x/y",
+		"dir/ok":      "../a.txt",
+		"dir/gone":    "../secret/key",
+		"dir/root":    "/",
+		"dir/sibling": "b.txt",
+	}
+	for name, target := range links {
+		if err := contextual.Symlink(ctx, ro, target, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := unionfs.New(newOSLayer(t, nil), ro)
+	if err := contextual.RemoveAll(ctx, f, "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy unionfs.LinkPolicy
+		want   map[string]string
+	}{
+		{unionfs.LinkPassthrough, links},
+		{unionfs.LinkRebase, map[string]string{
+			"dir/abs":     "../etc/passwd",
+			"dir/up":      "../x/y",
+			"dir/ok":      "../a.txt",
+			"dir/gone":    "../secret/key",
+			"dir/root":    "..",
+			"dir/sibling": "b.txt",
+		}},
+		{unionfs.LinkReject, map[string]string{
+			"dir/ok":      "../a.txt",
+			"dir/sibling": "b.txt",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.policy), func(t *testing.T) {
+			unionfs.SetLinkPolicy(f, tt.policy)
+			for name := range links {
+				got, err := contextual.ReadLink(ctx, f, name)
+				want, ok := tt.want[name]
+				if !ok {
+					if !errors.Is(err, unionfs.ErrUnsafeLink) {
+						t.Errorf("ReadLink(%q) = %q, %v; want ErrUnsafeLink", name, got, err)
+					}
+					continue
+				}
+				if err != nil || got != want {
+					t.Errorf("ReadLink(%q) = %q, %v; want %q", name, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFS_LinkPolicy_Follow(t *testing.T) {
+	ctx := t.Context()
+	for _, tt := range []struct {
+		policy unionfs.LinkPolicy
+		// abs and gone tell whether the links are followed.
+		abs, gone bool
+	}{
+		{unionfs.LinkPassthrough, false, true},
+		{unionfs.LinkRebase, true, true},
+		{unionfs.LinkReject, false, false},
+	} {
+		t.Run(fmt.Sprint(tt.policy), func(t *testing.T) {
+			ro := newOSLayer(t, map[string]string{"etc/passwd": "p", "secret/key": "k"})
+			for name, target := range map[string]string{"abs": "/etc/passwd", "gone": "secret/key"} {
+				if err := contextual.Symlink(ctx, ro, target, name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f := unionfs.New(newOSLayer(t, nil), ro)
+			unionfs.SetLinkPolicy(f, tt.policy)
+			if err := contextual.RemoveAll(ctx, f, "secret"); err != nil {
+				t.Fatal(err)
+			}
+
+			// Changing the file of the union an absolute link happens to
+			// name would change a file the link does not refer to.
+			err := contextual.Chmod(ctx, f, "abs", 0600)
+			if tt.abs {
+				if info, _ := contextual.Stat(ctx, f, "etc/passwd"); err != nil || info.Mode().Perm() != 0600 {
+					t.Errorf("Chmod(abs) error = %v; want etc/passwd changed", err)
+				}
+			} else if !errors.Is(err, unionfs.ErrUnsafeLink) {
+				t.Errorf("Chmod(abs) error = %v; want ErrUnsafeLink", err)
+			}
+
+			err = contextual.Chmod(ctx, f, "gone", 0600)
+			if tt.gone {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Chmod(gone) error = %v; want ErrNotExist", err)
+				}
+			} else if !errors.Is(err, unionfs.ErrUnsafeLink) {
+				t.Errorf("Chmod(gone) error = %v; want ErrUnsafeLink", err)
+			}
+		})
+	}
+}
+
+func TestFS_WhiteoutObserver(t *testing.T) {
+	ctx := t.Context()
+	ro := newOSLayer(t, map[string]string{"a": "a", "b": "b", "dir/c": "c"})
+	f := unionfs.New(newOSLayer(t, nil), ro)
+	var events []unionfs.WhiteoutEvent
+	unionfs.SetWhiteoutObserver(f, func(_ context.Context, e unionfs.WhiteoutEvent) {
+		events = append(events, e)
+	})
+
+	if err := f.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Mkdir(ctx, "a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Rename(ctx, "b", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.RemoveAll(ctx, "dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contextual.RemoveAll(ctx, ro, "dir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unionfs.Stats(ctx, f); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []unionfs.WhiteoutEvent{
+		{Action: unionfs.WhiteoutCreated, Name: "a", Op: "remove"},
+		{Action: unionfs.WhiteoutRemoved, Name: "a", Op: "mkdir"},
+		{Action: unionfs.WhiteoutCreated, Name: "b", Op: "rename"},
+		{Action: unionfs.WhiteoutCreated, Name: "dir", Op: "removeall"},
+		{Action: unionfs.WhiteoutStale, Name: "dir", Op: "stats"},
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %v; want %v", events, want)
+	}
+	if got := unionfs.WhiteoutStale.String(); got != "stale" {
+		t.Errorf("String() = %q; want stale", got)
+	}
+}
+
+func TestFS_AppendOverlay(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, nil)
+	ro := newOSLayer(t, map[string]string{"log/app.log": "base\n", "other": "other"})
+	f := unionfs.New(rw, ro)
+	unionfs.SetAppendOverlay(f, true)
+
+	appendTo := func(name, data string, size int64) {
+		t.Helper()
+		file, err := f.OpenFile(ctx, name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := file.Stat(); err != nil || info.Size() != size {
+			t.Errorf("Stat() = %v, %v; want size %d", info, err, size)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendTo("log/app.log", "one\n", 9)
+	appendTo("log/app.log", "two\n", 13)
+	const want = "base\none\ntwo\n"
+
+	if _, err := contextual.Stat(ctx, rw, "log/app.log"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("file copied to the read-write layer: %v", err)
+	}
+	if data, err := f.ReadFile(ctx, "log/app.log"); err != nil || string(data) != want {
+		t.Errorf("ReadFile() = %q, %v; want %q", data, err, want)
+	}
+	if info, err := f.Stat(ctx, "log/app.log"); err != nil || info.Size() != int64(len(want)) {
+		t.Errorf("Stat() = %v, %v; want size %d", info, err, len(want))
+	}
+	entries, err := f.ReadDir(ctx, "log")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "app.log" {
+		t.Fatalf("ReadDir() = %v, %v; want app.log only", entries, err)
+	}
+	if info, err := entries[0].Info(); err != nil || info.Size() != int64(len(want)) {
+		t.Errorf("Info() = %v, %v; want size %d", info, err, len(want))
+	}
+
+	file, err := f.Open(ctx, "log/app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(file); err != nil || string(data) != want {
+		t.Errorf("ReadAll() = %q, %v; want %q", data, err, want)
+	}
+	buf := make([]byte, 6)
+	if n, err := file.(io.ReaderAt).ReadAt(buf, 3); err != nil || string(buf[:n]) != "e\none\n" {
+		t.Errorf("ReadAt() = %q, %v", buf[:n], err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An in-place modification copies the stitched contents.
+	if err := f.Chmod(ctx, "log/app.log", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := contextual.ReadFile(ctx, rw, "log/app.log"); err != nil || string(data) != want {
+		t.Errorf("copy = %q, %v; want %q", data, err, want)
+	}
+	if entries, err := contextual.ReadDir(ctx, rw, "log"); err != nil || len(entries) != 1 {
+		t.Errorf("read-write layer holds %v, %v; want the copy only", entries, err)
+	}
+
+	// Removing the file drops its tail.
+	appendTo("other", "!", 6)
+	if err := f.Remove(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WriteFile(ctx, "other", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := f.ReadFile(ctx, "other"); err != nil || string(data) != "new" {
+		t.Errorf("ReadFile() = %q, %v; want new", data, err)
+	}
+	if err := f.Remove(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Stat(ctx, "other"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() error = %v; want ErrNotExist", err)
+	}
+}
+
+// eofLayer opens files whose ReadAt reports io.EOF along with the bytes
+// reaching the end of the file, as io.ReaderAt allows.
+type eofLayer struct {
+	contextual.FileSystem
+}
+
+func (l eofLayer) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (fsx.File, error) {
+	f, err := contextual.OpenFile(ctx, l.FileSystem, name, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	return eofFile{f}, nil
+}
+
+type eofFile struct {
+	fsx.File
+}
+
+func (f eofFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.(io.ReaderAt).ReadAt(p, off)
+	if info, statErr := f.Stat(); err == nil && statErr == nil && off+int64(n) == info.Size() {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestFS_AppendOverlayEOF(t *testing.T) {
+	ctx := t.Context()
+	ro := eofLayer{newOSLayer(t, map[string]string{"log": "base\n"}).(contextual.FileSystem)}
+	f := unionfs.New(newOSLayer(t, nil), ro)
+	unionfs.SetAppendOverlay(f, true)
+	file, err := f.OpenFile(ctx, "log", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("tail\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := f.Open(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+	// The read spans the end of the base and the start of the tail.
+	buf := make([]byte, 4)
+	if n, err := r.(io.ReaderAt).ReadAt(buf, 3); err != nil || string(buf[:n]) != "e\nta" {
+		t.Errorf("ReadAt() = %q, %v; want %q", buf[:n], err, "e\nta")
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != "base\ntail\n" {
+		t.Errorf("ReadAll() = %q, %v", data, err)
+	}
+}
+
+func TestNewDryRun(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, map[string]string{"upper": "upper"})
+	ro := newOSLayer(t, map[string]string{"a": "hello", "dir/b": "b"})
+	union := unionfs.New(rw, ro)
+	var plans []string
+	var size int64
+	dry := unionfs.NewDryRun(union, func(_ context.Context, p unionfs.Plan) {
+		plans = append(plans, p.String())
+		size += p.Bytes()
+	})
+
+	if err := dry.Chmod(ctx, "a", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := dry.Remove(ctx, "dir/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dry.WriteFile(ctx, "new", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dry.Rename(ctx, "upper", "moved"); err != nil {
+		t.Fatal(err)
+	}
+	file, err := dry.OpenFile(ctx, "dir", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	file, err = dry.OpenFile(ctx, "a", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(", world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"chmod a: copy-up a (5 bytes), modify a",
+		"remove dir/b: create dir, whiteout dir/b",
+		"writefile new: create new, write new (3 bytes)",
+		"rename upper -> moved: copy-up upper (5 bytes), rename upper -> moved, whiteout upper",
+		"open a: no changes",
+		"write a: write a (7 bytes)",
+	}
+	if !slices.Equal(plans, want) {
+		t.Errorf("plans = %q; want %q", plans, want)
+	}
+	if size != 20 {
+		t.Errorf("size = %d; want 20", size)
+	}
+
+	// The view observes its changes, while the union is left untouched.
+	if data, err := dry.ReadFile(ctx, "a"); err != nil || string(data) != "hello, world" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+	if _, err := dry.Stat(ctx, "dir/b"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(dir/b) error = %v; want ErrNotExist", err)
+	}
+	for _, name := range []string{"a", "dir/b", "upper"} {
+		if _, err := union.Stat(ctx, name); err != nil {
+			t.Errorf("union.Stat(%s) error = %v", name, err)
+		}
+	}
+	for _, name := range []string{"new", "moved", "a"} {
+		if _, err := contextual.Stat(ctx, rw, name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("rw.Stat(%s) error = %v; want ErrNotExist", name, err)
+		}
+	}
+	if err := contextual.Close(dry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := union.Stat(ctx, "a"); err != nil {
+		t.Errorf("union closed by the view: %v", err)
+	}
+}
+
+func TestChangeToken(t *testing.T) {
+	ctx := t.Context()
+	rw := newOSLayer(t, nil)
+	ro := newOSLayer(t, map[string]string{"a/b/file": "data", "c/file": "data"})
+	f := unionfs.New(rw, ro)
+
+	tokens := func() map[string]uint64 {
+		m := make(map[string]uint64)
+		for _, name := range []string{".", "a", "a/b", "a/b/file", "c", "c/file", "../x"} {
+			m[name] = unionfs.ChangeToken(f, name)
+		}
+		return m
+	}
+	check := func(t *testing.T, before map[string]uint64, changed ...string) map[string]uint64 {
+		t.Helper()
+		after := tokens()
+		for name, token := range after {
+			switch {
+			case token < before[name]:
+				t.Errorf("token of %s went back from %d to %d", name, before[name], token)
+			case slices.Contains(changed, name) && token == before[name]:
+				t.Errorf("expected the token of %s to change", name)
+			case !slices.Contains(changed, name) && token != before[name]:
+				t.Errorf("expected the token of %s to stay, got %d; was %d", name, token, before[name])
+			}
+		}
+		return after
+	}
+
+	before := tokens()
+	if before["."] == 0 {
+		t.Error("expected tokens to start from the creation time")
+	}
+	// Reads change nothing.
+	if _, err := contextual.ReadFile(ctx, f, "a/b/file"); err != nil {
+		t.Fatal(err)
+	}
+	before = check(t, before)
+
+	t.Run("truncate", func(t *testing.T) {
+		if err := contextual.Truncate(ctx, f, "c/file", 1); err != nil {
+			t.Fatal(err)
+		}
+		before = check(t, before, ".", "../x", "c", "c/file")
+	})
+
+	t.Run("open file", func(t *testing.T) {
+		file, err := contextual.OpenFile(ctx, f, "a/b/file", os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = file.Close() }()
+		before = check(t, before, ".", "../x", "a", "a/b", "a/b/file")
+		if _, err := file.Write([]byte("more")); err != nil {
+			t.Fatal(err)
+		}
+		before = check(t, before, ".", "../x", "a", "a/b", "a/b/file")
+	})
+
+	t.Run("remove parent", func(t *testing.T) {
+		if err := contextual.RemoveAll(ctx, f, "a/b"); err != nil {
+			t.Fatal(err)
+		}
+		before = check(t, before, ".", "../x", "a", "a/b", "a/b/file")
+	})
+
+	t.Run("symlink", func(t *testing.T) {
+		if err := contextual.Symlink(ctx, f, "c/file", "link"); err != nil {
+			t.Fatal(err)
+		}
+		before = check(t, before, ".", "../x")
+		if err := contextual.Chmod(ctx, f, "link", 0600); err != nil {
+			t.Fatal(err)
+		}
+		before = check(t, before, ".", "../x", "c", "c/file")
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		if err := contextual.Remove(ctx, f, "c/missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected fs.ErrNotExist, got %v", err)
+		}
+		before = check(t, before, ".", "../x", "c")
+	})
+}
+
+func TestWithLayers(t *testing.T) {
+	ctx := t.Context()
+	base := newOSLayer(t, map[string]string{"shared.txt": "base", "base.txt": "base"})
+	tenantA := newOSLayer(t, map[string]string{"shared.txt": "a", "a.txt": "a"})
+	tenantB := newOSLayer(t, map[string]string{"shared.txt": "b", "b.txt": "b"})
+	rw := newOSLayer(t, nil)
+	f := unionfs.New(rw, tenantA, tenantB, base)
+
+	view := func(t *testing.T, layers ...int) context.Context {
+		t.Helper()
+		ctx, err := unionfs.WithLayers(ctx, f, layers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+	names := func(t *testing.T, ctx context.Context) []string {
+		t.Helper()
+		entries, err := contextual.ReadDir(ctx, f, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	t.Run("selection", func(t *testing.T) {
+		a, b := view(t, 0, 2), view(t, 2, 1)
+		for _, tc := range []struct {
+			ctx   context.Context
+			want  string
+			names []string
+		}{
+			{ctx, "a", []string{"a.txt", "b.txt", "base.txt", "shared.txt"}},
+			{a, "a", []string{"a.txt", "base.txt", "shared.txt"}},
+			{b, "base", []string{"b.txt", "base.txt", "shared.txt"}},
+		} {
+			if data, err := contextual.ReadFile(tc.ctx, f, "shared.txt"); err != nil || string(data) != tc.want {
+				t.Errorf("ReadFile(shared.txt) = %q, %v; want %q", data, err, tc.want)
+			}
+			if got := names(t, tc.ctx); !slices.Equal(got, tc.names) {
+				t.Errorf("ReadDir = %v; want %v", got, tc.names)
+			}
+		}
+		if _, err := contextual.Stat(a, f, "b.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(b.txt) through view a: expected ErrNotExist, got %v", err)
+		}
+		if got := names(t, view(t)); len(got) != 0 {
+			t.Errorf("ReadDir through an empty view = %v; want nothing", got)
+		}
+	})
+
+	t.Run("copy-up", func(t *testing.T) {
+		if err := contextual.WriteFile(view(t, 1), f, "b.txt", []byte("b2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := contextual.ReadFile(view(t, 0), f, "b.txt"); err != nil || string(data) != "b2" {
+			t.Errorf("expected the read-write layer to be shared by the views, got %q, %v", data, err)
+		}
+		if err := contextual.Remove(view(t, 0), f, "a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, f, "a.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the whiteout to hide a.txt from every view, got %v", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, layers := range [][]int{{3}, {-1}, {0, 0}} {
+			if _, err := unionfs.WithLayers(ctx, f, layers...); !errors.Is(err, unionfs.ErrInvalidLayers) {
+				t.Errorf("WithLayers(%v): expected ErrInvalidLayers, got %v", layers, err)
+			}
+		}
+	})
+
+	t.Run("other unions", func(t *testing.T) {
+		other := unionfs.New(newOSLayer(t, nil), tenantB)
+		ctx := view(t, 0)
+		if _, err := contextual.Stat(ctx, other, "b.txt"); err != nil {
+			t.Errorf("expected the selection to leave other unions alone, got %v", err)
+		}
+		ctx, err := unionfs.WithLayers(ctx, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := contextual.Stat(ctx, other, "b.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected an empty view of the other union, got %v", err)
+		}
+		if _, err := contextual.Stat(ctx, f, "base.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the first selection to be kept, got %v", err)
+		}
+	})
+}
+
+func TestSeal(t *testing.T) {
+	ctx := t.Context()
+	rw := memfs.New(memfs.Config{})
+	ro := memfs.New(memfs.Config{})
+	if err := ro.WriteFile(ctx, "base.txt", []byte("base"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := unionfs.New(rw, ro)
+	unionfs.SetCopyOnRead(f, true)
+	unionfs.SetStrictConsistency(f, true)
+	if err := f.WriteFile(ctx, "warm.txt", []byte("warm"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if unionfs.Sealed(f) {
+		t.Fatal("Sealed() = true before Seal")
+	}
+	unionfs.Seal(f)
+	if !unionfs.Sealed(f) {
+		t.Fatal("Sealed() = false after Seal")
+	}
+
+	for op, fn := range map[string]func() error{
+		"create":    func() error { _, err := f.Create(ctx, "new.txt"); return err },
+		"open":      func() error { _, err := f.OpenFile(ctx, "warm.txt", os.O_WRONLY, 0); return err },
+		"writefile": func() error { return f.WriteFile(ctx, "warm.txt", nil, 0644) },
+		"remove":    func() error { return f.Remove(ctx, "base.txt") },
+		"removeall": func() error { return f.RemoveAll(ctx, ".") },
+		"mkdir":     func() error { return f.Mkdir(ctx, "dir", 0755) },
+		"rename":    func() error { return f.Rename(ctx, "warm.txt", "moved.txt") },
+		"symlink":   func() error { return f.Symlink(ctx, "base.txt", "link") },
+		"chmod":     func() error { return f.Chmod(ctx, "base.txt", 0600) },
+		"truncate":  func() error { return f.Truncate(ctx, "warm.txt", 0) },
+		"rule": func() error {
+			return unionfs.SetCopyOnReadRule(ctx, f, "dir", unionfs.CopyOnReadNever)
+		},
+		"check": func() error { _, err := unionfs.Check(ctx, f, unionfs.Repair()); return err },
+	} {
+		if err := fn(); !errors.Is(err, unionfs.ErrSealed) || !errors.Is(err, fs.ErrPermission) || !errors.Is(err, fsx.ErrReadOnly) {
+			t.Errorf("%s error = %v; want ErrSealed", op, err)
+		}
+	}
+
+	if _, err := unionfs.Check(ctx, f); err != nil {
+		t.Errorf("Check() error = %v; want a sealed union checked", err)
+	}
+
+	// Reads still work, without copying up.
+	for name, want := range map[string]string{"base.txt": "base", "warm.txt": "warm"} {
+		if got, err := contextual.ReadFile(ctx, f, name); err != nil || string(got) != want {
+			t.Errorf("ReadFile(%s) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	file, err := f.Open(ctx, "base.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	entries, err := rw.ReadDir(ctx, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "warm.txt" {
+		t.Errorf("read-write layer holds %v; want only warm.txt", entries)
+	}
+}
+
+// scratchOf matches the names of the scratch files name is copied up
+// through.
+func scratchOf(name string) gomock.Matcher {
+	dir, file := path.Split(name)
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(dir) + `\.cu\.[0-9a-f]{16}\.` + regexp.QuoteMeta(file) + `$`)
+	return gomock.Cond(pattern.MatchString)
+}
@@ -0,0 +1,67 @@
+package unionfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// ErrCopyMismatch is returned, wrapped in an *fs.PathError, when the copy of
+// a file to the read-write layer does not match the file of the read-only
+// layer, even after copying it again: its size differs, or its digest does
+// when the read-only layer implements contextual.HashFS. The copy is removed
+// rather than left to shadow the original.
+var ErrCopyMismatch = errors.New("copy-up does not match its source")
+
+// copyUpAttempts is the number of times a file is copied to the read-write
+// layer before a mismatching copy fails the copy-up. A torn copy, from a
+// flaky source or a full layer, usually goes away when copying again.
+const copyUpAttempts = 2
+
+// verifyCopy checks the copy of the regular file name, described by info in
+// the read-only layer src, that was just written to dst in the read-write
+// layer, n bytes long. The bytes copied and the size of the copy must be those of
+// the original, with its appended tail if any. If src knows the digests of
+// its files, the copy is read back and its digest compared too, which is
+// skipped for files with a tail, whose digest src does not know.
+func (f *filesystem) verifyCopy(ctx context.Context, src contextual.FS, name, dst string, info fs.FileInfo, n int64) error {
+	want := info.Size()
+	t, err := f.readTail(ctx, name)
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		want = t.offset + t.size
+	}
+	if n != want {
+		return fmt.Errorf("%w: copied %d bytes of %d", ErrCopyMismatch, n, want)
+	}
+	copied, err := contextual.Stat(ctx, f.rw, dst)
+	if err != nil {
+		return err
+	}
+	if copied.Size() != want {
+		return fmt.Errorf("%w: copy holds %d bytes of %d", ErrCopyMismatch, copied.Size(), want)
+	}
+
+	layer, ok := unwrapReadOnly(src).(contextual.HashFS)
+	if !ok || t != nil {
+		return nil
+	}
+	wantSum, err := layer.Hash(ctx, name)
+	if err != nil {
+		return err
+	}
+	sum, err := contextual.Hash(ctx, f.rw, dst)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, wantSum) {
+		return fmt.Errorf("%w: digest %x, want %x", ErrCopyMismatch, sum, wantSum)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package unionfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// ErrInvalidLayers is returned by WithLayers for a selection naming a layer
+// the union does not have, or naming a layer twice.
+var ErrInvalidLayers = errors.New("invalid selection of read-only layers")
+
+// view is a sequence of read-only layers searched by an operation, with the
+// whiteouts hiding the files of the layers below each of them.
+type view struct {
+	ro        []contextual.FS
+	whiteouts []*metadata
+}
+
+// hiddenBelow reports whether name, missing from the i-th layer of the view,
+// is hidden from the layers below it by a whiteout in that layer, of name or
+// of a directory holding it. Only read-write layers of flattened nested
+// unions are probed.
+func (v view) hiddenBelow(ctx context.Context, i int, name string) bool {
+	m := v.whiteouts[i]
+	if m == nil {
+		return false
+	}
+	for p := name; p != "."; p = path.Dir(p) {
+		if m.hasWhiteout(ctx, v.ro[i], p) {
+			return true
+		}
+	}
+	return false
+}
+
+// layers returns the view of every read-only layer of the union, used by the
+// operations that maintain the read-write layer for all the views, such as
+// Check.
+func (f *filesystem) layers() view {
+	return view{ro: f.ro, whiteouts: f.whiteouts}
+}
+
+// view returns the read-only layers visible to the operations run with ctx.
+func (f *filesystem) view(ctx context.Context) view {
+	if s, ok := ctx.Value(layersKey{}).(*layerSelection); ok {
+		for ; s != nil; s = s.next {
+			if s.union == f {
+				return s.view
+			}
+		}
+	}
+	return f.layers()
+}
+
+// layersKey is the key of the *layerSelection set by WithLayers.
+type layersKey struct{}
+
+// layerSelection is the view of a union selected by WithLayers, linked to
+// the selections made for other unions with the same context.
+type layerSelection struct {
+	union *filesystem
+	view  view
+	next  *layerSelection
+}
+
+// WithLayers returns a context making the operations of the union fs run
+// with it search only the read-only layers selected by layers, in the order
+// given, so that one union can serve several views of its contents, such as
+// feature-flagged content layers per tenant, without building a union per
+// view. Layers are numbered from 0 in the order they were given to New, the
+// layers of a nested union being numbered as if they had been given in its
+// place. No layer is selected twice, but a selection can be empty, leaving
+// only the read-write layer visible. Selections made for other unions with
+// the same context are kept. The lower half of a layer set with
+// SetSpillLayer is always searched first, and is not numbered.
+//
+// The read-write layer is shared by every view: files written or copied up
+// through one view are seen through all of them. Removing a file through a
+// view whites it out for every view only if a read-only layer of that view
+// holds it. Check considers every layer, whatever ctx selects. Caches
+// layered over the union, for instance with ChangeToken, do not tell the
+// views apart.
+//
+// WithLayers should be called once the union is configured. It returns an
+// error wrapping ErrInvalidLayers if layers names a layer fs does not have,
+// or names one twice.
+func WithLayers(ctx context.Context, fs contextual.FS, layers ...int) (context.Context, error) {
+	f := fs.(*filesystem)
+	selectable := len(f.ro) - f.pinned
+	v := view{
+		ro:        append([]contextual.FS(nil), f.ro[:f.pinned]...),
+		whiteouts: append([]*metadata(nil), f.whiteouts[:f.pinned]...),
+	}
+	seen := make(map[int]bool, len(layers))
+	for _, i := range layers {
+		if i < 0 || i >= selectable {
+			return nil, fmt.Errorf("%w: no layer %d among %d", ErrInvalidLayers, i, selectable)
+		}
+		if seen[i] {
+			return nil, fmt.Errorf("%w: layer %d selected twice", ErrInvalidLayers, i)
+		}
+		seen[i] = true
+		v.ro = append(v.ro, f.ro[f.pinned+i])
+		v.whiteouts = append(v.whiteouts, f.whiteouts[f.pinned+i])
+	}
+	next, _ := ctx.Value(layersKey{}).(*layerSelection)
+	return context.WithValue(ctx, layersKey{}, &layerSelection{union: f, view: v, next: next}), nil
+}
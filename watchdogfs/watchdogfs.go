@@ -0,0 +1,348 @@
+// Package watchdogfs provides a contextual filesystem wrapper that reports
+// operations taking longer than a threshold.
+//
+// Stacks of layers make it hard to tell why an operation is slow: an Open
+// blocked for 30 seconds may wait on a lock of a union, a semaphore, or a
+// remote server several layers below. A watchdog can be inserted between any
+// two layers, or several of them, to find which one stalls. An operation
+// still running once the threshold passes is reported right away, with the
+// stacks of all goroutines if Config.CaptureStack is set, so that hung
+// operations are diagnosed before they return, if ever. Slow operations are
+// reported again when they complete.
+//
+// Only the calls to the filesystem are watched. Reads and writes made
+// through an open file handle are not.
+package watchdogfs
+
+import (
+	"context"
+	"io/fs"
+	"runtime"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+)
+
+// DefaultThreshold is the duration after which an operation is reported
+// unless Config.Threshold is set.
+const DefaultThreshold = 10 * time.Second
+
+// Config specifies the configuration for watchdogfs.
+type Config struct {
+	// Threshold is the duration after which an operation is slow. If 0,
+	// DefaultThreshold is used.
+	Threshold time.Duration
+	// CaptureStack makes the reports of operations still running carry the
+	// stacks of all goroutines, showing where the operation is blocked and
+	// what it waits for. Capturing them stops the world briefly.
+	CaptureStack bool
+	// OnSlow receives the reports of slow operations. It is called from
+	// another goroutine when an operation reaches the threshold, and from the
+	// goroutine of the operation when it completes, so it must be safe for
+	// concurrent use. If nil, operations are not watched.
+	OnSlow func(SlowOperation)
+	// Clock is the time source measuring the operations. If nil,
+	// contextual.RealClock is used.
+	Clock contextual.Clock
+}
+
+// SlowOperation describes an operation that reached the threshold.
+type SlowOperation struct {
+	// Op is the name of the operation, such as "open" or "stat".
+	Op string
+	// Path is the name the operation was called with.
+	Path string
+	// NewPath is the second name of Rename and Symlink, and empty for other
+	// operations.
+	NewPath string
+	// Start is when the operation started.
+	Start time.Time
+	// Elapsed is how long the operation had been running when reported.
+	Elapsed time.Duration
+	// Done reports whether the operation completed, in which case Elapsed is
+	// its duration and Err its result.
+	Done bool
+	// Err is the error returned by the completed operation, if any.
+	Err error
+	// Stack holds the stacks of all goroutines, in the format of
+	// runtime.Stack, when the operation reached the threshold. It is only set
+	// for operations still running if Config.CaptureStack is set.
+	Stack []byte
+}
+
+// filesystem watches the calls forwarded to the embedded PassthroughFS.
+type filesystem struct {
+	contextual.PassthroughFS
+	config Config
+}
+
+// New creates a new watchdogfs wrapping fsys.
+func New(fsys contextual.FS, config Config) contextual.FileSystem {
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultThreshold
+	}
+	return &filesystem{PassthroughFS: contextual.PassthroughFS{Inner: fsys}, config: config}
+}
+
+// Unwrap returns the filesystem the watched calls are forwarded to.
+func (f *filesystem) Unwrap() contextual.FS {
+	return f.Inner
+}
+
+// watch starts watching the operation op on name, and newname for two-name
+// operations. The returned function must be called with the result of the
+// operation once it completes.
+func (f *filesystem) watch(op, name, newname string) func(err error) {
+	if f.config.OnSlow == nil {
+		return func(error) {}
+	}
+	clock := contextual.ClockOr(f.config.Clock)
+	start := clock.Now()
+	slow := SlowOperation{Op: op, Path: name, NewPath: newname, Start: start}
+
+	timer := clock.NewTimer(f.config.Threshold)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-timer.C():
+			report := slow
+			report.Elapsed = clock.Now().Sub(start)
+			if f.config.CaptureStack {
+				report.Stack = stacks()
+			}
+			f.config.OnSlow(report)
+		case <-done:
+		}
+	}()
+
+	return func(err error) {
+		timer.Stop()
+		close(done)
+		// The report of the running operation comes first.
+		<-stopped
+		if elapsed := clock.Now().Sub(start); elapsed >= f.config.Threshold {
+			report := slow
+			report.Elapsed = elapsed
+			report.Done = true
+			report.Err = err
+			f.config.OnSlow(report)
+		}
+	}
+}
+
+// stacks returns the stacks of all goroutines.
+func stacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+func (f *filesystem) Open(ctx context.Context, name string) (fs.File, error) {
+	done := f.watch("open", name, "")
+	v, err := f.PassthroughFS.Open(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) Create(ctx context.Context, name string) (contextual.File, error) {
+	done := f.watch("open", name, "")
+	v, err := f.PassthroughFS.Create(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) OpenFile(ctx context.Context, name string, flag int, mode fs.FileMode) (contextual.File, error) {
+	done := f.watch("open", name, "")
+	v, err := f.PassthroughFS.OpenFile(ctx, name, flag, mode)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) Remove(ctx context.Context, name string) error {
+	done := f.watch("remove", name, "")
+	err := f.PassthroughFS.Remove(ctx, name)
+	done(err)
+	return err
+}
+
+func (f *filesystem) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	done := f.watch("readfile", name, "")
+	v, err := f.PassthroughFS.ReadFile(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	done := f.watch("stat", name, "")
+	v, err := f.PassthroughFS.Stat(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) Lstat(ctx context.Context, name string) (fs.FileInfo, error) {
+	done := f.watch("lstat", name, "")
+	v, err := f.PassthroughFS.Lstat(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) ReadDir(ctx context.Context, name string) ([]fs.DirEntry, error) {
+	done := f.watch("readdir", name, "")
+	v, err := f.PassthroughFS.ReadDir(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) Mkdir(ctx context.Context, name string, perm fs.FileMode) error {
+	done := f.watch("mkdir", name, "")
+	err := f.PassthroughFS.Mkdir(ctx, name, perm)
+	done(err)
+	return err
+}
+
+func (f *filesystem) MkdirAll(ctx context.Context, name string, perm fs.FileMode) error {
+	done := f.watch("mkdir", name, "")
+	err := f.PassthroughFS.MkdirAll(ctx, name, perm)
+	done(err)
+	return err
+}
+
+func (f *filesystem) RemoveAll(ctx context.Context, name string) error {
+	done := f.watch("removeall", name, "")
+	err := f.PassthroughFS.RemoveAll(ctx, name)
+	done(err)
+	return err
+}
+
+func (f *filesystem) Rename(ctx context.Context, oldname, newname string) error {
+	done := f.watch("rename", oldname, newname)
+	err := f.PassthroughFS.Rename(ctx, oldname, newname)
+	done(err)
+	return err
+}
+
+func (f *filesystem) RenameWithOptions(ctx context.Context, oldname, newname string, flags fsx.RenameFlags) error {
+	done := f.watch("rename", oldname, newname)
+	err := f.PassthroughFS.RenameWithOptions(ctx, oldname, newname, flags)
+	done(err)
+	return err
+}
+
+func (f *filesystem) Symlink(ctx context.Context, oldname, newname string) error {
+	done := f.watch("symlink", oldname, newname)
+	err := f.PassthroughFS.Symlink(ctx, oldname, newname)
+	done(err)
+	return err
+}
+
+func (f *filesystem) ReadLink(ctx context.Context, name string) (string, error) {
+	done := f.watch("readlink", name, "")
+	v, err := f.PassthroughFS.ReadLink(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) CreateSpecial(ctx context.Context, name string, mode fs.FileMode, dev uint64) error {
+	done := f.watch("mknod", name, "")
+	err := f.PassthroughFS.CreateSpecial(ctx, name, mode, dev)
+	done(err)
+	return err
+}
+
+func (f *filesystem) Lchown(ctx context.Context, name, owner, group string) error {
+	done := f.watch("lchown", name, "")
+	err := f.PassthroughFS.Lchown(ctx, name, owner, group)
+	done(err)
+	return err
+}
+
+func (f *filesystem) Chown(ctx context.Context, name, owner, group string) error {
+	done := f.watch("chown", name, "")
+	err := f.PassthroughFS.Chown(ctx, name, owner, group)
+	done(err)
+	return err
+}
+
+func (f *filesystem) Chmod(ctx context.Context, name string, mode fs.FileMode) error {
+	done := f.watch("chmod", name, "")
+	err := f.PassthroughFS.Chmod(ctx, name, mode)
+	done(err)
+	return err
+}
+
+func (f *filesystem) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	done := f.watch("chtimes", name, "")
+	err := f.PassthroughFS.Chtimes(ctx, name, atime, mtime)
+	done(err)
+	return err
+}
+
+func (f *filesystem) Truncate(ctx context.Context, name string, size int64) error {
+	done := f.watch("truncate", name, "")
+	err := f.PassthroughFS.Truncate(ctx, name, size)
+	done(err)
+	return err
+}
+
+func (f *filesystem) WriteFile(ctx context.Context, name string, data []byte, perm fs.FileMode) error {
+	done := f.watch("writefile", name, "")
+	err := f.PassthroughFS.WriteFile(ctx, name, data, perm)
+	done(err)
+	return err
+}
+
+func (f *filesystem) Access(ctx context.Context, name string, mode uint32) error {
+	done := f.watch("access", name, "")
+	err := f.PassthroughFS.Access(ctx, name, mode)
+	done(err)
+	return err
+}
+
+func (f *filesystem) ReadDirInfos(ctx context.Context, name string) ([]contextual.FileInfoEntry, error) {
+	done := f.watch("readdir", name, "")
+	v, err := f.PassthroughFS.ReadDirInfos(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) Glob(ctx context.Context, pattern string) ([]string, error) {
+	done := f.watch("glob", pattern, "")
+	v, err := f.PassthroughFS.Glob(ctx, pattern)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) GetXattr(ctx context.Context, name, attr string) ([]byte, error) {
+	done := f.watch("getxattr", name, "")
+	v, err := f.PassthroughFS.GetXattr(ctx, name, attr)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) SetXattr(ctx context.Context, name, attr string, value []byte, flags int) error {
+	done := f.watch("setxattr", name, "")
+	err := f.PassthroughFS.SetXattr(ctx, name, attr, value, flags)
+	done(err)
+	return err
+}
+
+func (f *filesystem) ListXattr(ctx context.Context, name string) ([]string, error) {
+	done := f.watch("listxattr", name, "")
+	v, err := f.PassthroughFS.ListXattr(ctx, name)
+	done(err)
+	return v, err
+}
+
+func (f *filesystem) RemoveXattr(ctx context.Context, name, attr string) error {
+	done := f.watch("removexattr", name, "")
+	err := f.PassthroughFS.RemoveXattr(ctx, name, attr)
+	done(err)
+	return err
+}
@@ -0,0 +1,154 @@
+package watchdogfs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/fsxtest"
+	"github.com/gwangyi/fsx/osfs"
+	"github.com/gwangyi/fsx/watchdogfs"
+)
+
+// stallingFS blocks Stat until release is closed.
+type stallingFS struct {
+	contextual.FS
+	release chan struct{}
+}
+
+func (s stallingFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	<-s.release
+	return contextual.Stat(ctx, s.FS, name)
+}
+
+func newBase(t *testing.T) contextual.FS {
+	t.Helper()
+	root, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contextual.ToContextual(root)
+}
+
+func TestWatchdog(t *testing.T) {
+	ctx := t.Context()
+	clock := fsxtest.NewClock(time.Unix(1000, 0))
+	base := stallingFS{FS: newBase(t), release: make(chan struct{})}
+	reports := make(chan watchdogfs.SlowOperation, 2)
+	fsys := watchdogfs.New(base, watchdogfs.Config{
+		Threshold:    time.Second,
+		CaptureStack: true,
+		OnSlow:       func(op watchdogfs.SlowOperation) { reports <- op },
+		Clock:        clock,
+	})
+
+	var wg sync.WaitGroup
+	var statErr error
+	wg.Go(func() { _, statErr = fsys.Stat(ctx, "missing") })
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(1500 * time.Millisecond)
+
+	running := <-reports
+	if running.Op != "stat" || running.Path != "missing" || running.Done || running.Elapsed != 1500*time.Millisecond {
+		t.Errorf("running report = %+v; want a running stat of missing after 1.5s", running)
+	}
+	if !running.Start.Equal(time.Unix(1000, 0)) {
+		t.Errorf("Start = %v; want %v", running.Start, time.Unix(1000, 0))
+	}
+	if !bytes.Contains(running.Stack, []byte("stallingFS")) {
+		t.Errorf("Stack does not show the stalled call:\n%s", running.Stack)
+	}
+
+	clock.Advance(time.Second)
+	close(base.release)
+	wg.Wait()
+	done := <-reports
+	if !done.Done || done.Elapsed != 2500*time.Millisecond || !errors.Is(done.Err, fs.ErrNotExist) || done.Stack != nil {
+		t.Errorf("done report = %+v; want a completed stat after 2.5s without stack", done)
+	}
+	if !errors.Is(statErr, fs.ErrNotExist) {
+		t.Errorf("Stat error = %v; want ErrNotExist", statErr)
+	}
+	if clock.Timers() != 0 {
+		t.Errorf("%d timers left running", clock.Timers())
+	}
+}
+
+func TestWatchdog_Fast(t *testing.T) {
+	ctx := t.Context()
+	clock := fsxtest.NewClock(time.Unix(1000, 0))
+	base := newBase(t)
+	var reports []watchdogfs.SlowOperation
+	fsys := watchdogfs.New(base, watchdogfs.Config{
+		OnSlow: func(op watchdogfs.SlowOperation) { reports = append(reports, op) },
+		Clock:  clock,
+	})
+
+	if err := contextual.WriteFile(ctx, fsys, "a", []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := contextual.Rename(ctx, fsys, "a", "b"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if data, err := contextual.ReadFile(ctx, fsys, "b"); err != nil || string(data) != "hello" {
+		t.Errorf("ReadFile = %q, %v; want hello", data, err)
+	}
+	if len(reports) != 0 {
+		t.Errorf("fast operations reported: %+v", reports)
+	}
+	if clock.Timers() != 0 {
+		t.Errorf("%d timers left running", clock.Timers())
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		fsxtest.CheckErrorOps(t, fsys, "missing")
+		fsxtest.CheckInvalidPaths(t, fsys)
+	})
+}
+
+func TestWatchdog_RenameWithOptions(t *testing.T) {
+	ctx := t.Context()
+	clock := fsxtest.NewClock(time.Unix(1000, 0))
+	base := newBase(t)
+	for _, name := range []string{"a", "b"} {
+		if err := contextual.WriteFile(ctx, base, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reports := make(chan watchdogfs.SlowOperation, 2)
+	fsys := watchdogfs.New(fsxtest.NewLatencyFS(base, fsxtest.Latency{
+		Ops:   map[string]time.Duration{"rename": 2 * time.Second},
+		Clock: clock,
+	}), watchdogfs.Config{
+		Threshold: time.Second,
+		OnSlow:    func(op watchdogfs.SlowOperation) { reports <- op },
+		Clock:     clock,
+	})
+
+	var wg sync.WaitGroup
+	var renameErr error
+	wg.Go(func() { renameErr = contextual.RenameWithOptions(ctx, fsys, "a", "b", fsx.RenameNoReplace) })
+	for clock.Timers() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(2 * time.Second)
+	wg.Wait()
+
+	if !errors.Is(renameErr, fs.ErrExist) {
+		t.Errorf("RenameWithOptions(noreplace) error = %v; want ErrExist", renameErr)
+	}
+	for _, done := range []bool{false, true} {
+		r := <-reports
+		if r.Op != "rename" || r.Path != "a" || r.NewPath != "b" || r.Done != done {
+			t.Errorf("report = %+v; want the rename of a to b, done %v", r, done)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package fsx
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/gwangyi/fsx/internal"
+)
+
+// Flags for SetXattr, matching the values used by setxattr(2) on Linux.
+const (
+	// XATTR_CREATE fails with fs.ErrExist if the attribute already exists.
+	XATTR_CREATE = internal.XATTR_CREATE
+	// XATTR_REPLACE fails with ErrNoXattr if the attribute does not exist.
+	XATTR_REPLACE = internal.XATTR_REPLACE
+)
+
+// ErrNoXattr is returned when the requested extended attribute does not
+// exist. It is an alias for syscall.ENODATA on Linux.
+var ErrNoXattr = internal.ErrNoXattr
+
+// XattrFS is the interface implemented by a file system that supports
+// extended attributes.
+//
+// Attribute names are made of a namespace and a name, as on Linux:
+// "user.", "trusted.", "security." or "system.", followed by a non-empty
+// name. Implementations on systems without namespaces map them to their own
+// conventions, so that names such as "trusted.overlay.opaque" round-trip.
+type XattrFS interface {
+	fs.FS
+
+	// GetXattr returns the value of the extended attribute attr of the named
+	// file. If the attribute does not exist, the error wraps ErrNoXattr.
+	GetXattr(name, attr string) ([]byte, error)
+
+	// SetXattr sets the value of the extended attribute attr of the named
+	// file. flags is zero, XATTR_CREATE or XATTR_REPLACE.
+	SetXattr(name, attr string, value []byte, flags int) error
+
+	// ListXattr returns the names of the extended attributes of the named
+	// file.
+	ListXattr(name string) ([]string, error)
+
+	// RemoveXattr removes the extended attribute attr of the named file. If
+	// the attribute does not exist, the error wraps ErrNoXattr.
+	RemoveXattr(name, attr string) error
+}
+
+// GetXattr returns the value of the extended attribute attr of the named
+// file in the provided filesystem.
+// If fsys implements XattrFS, it calls fsys.GetXattr.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func GetXattr(fsys fs.FS, name, attr string) ([]byte, error) {
+	if err := internal.CheckXattr("getxattr", name, attr); err != nil {
+		return nil, err
+	}
+	if xfs, ok := fsys.(XattrFS); ok {
+		value, err := xfs.GetXattr(name, attr)
+		return value, internal.IntoPathErr("getxattr", name, err)
+	}
+	return nil, internal.IntoPathErr("getxattr", name, errors.ErrUnsupported)
+}
+
+// SetXattr sets the value of the extended attribute attr of the named file
+// in the provided filesystem. flags is zero, XATTR_CREATE or XATTR_REPLACE.
+// If fsys implements XattrFS, it calls fsys.SetXattr.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func SetXattr(fsys fs.FS, name, attr string, value []byte, flags int) error {
+	if err := internal.CheckXattr("setxattr", name, attr); err != nil {
+		return err
+	}
+	if flags&^(XATTR_CREATE|XATTR_REPLACE) != 0 || flags == XATTR_CREATE|XATTR_REPLACE {
+		return internal.IntoPathErr("setxattr", name, fs.ErrInvalid)
+	}
+	if xfs, ok := fsys.(XattrFS); ok {
+		return internal.IntoPathErr("setxattr", name, xfs.SetXattr(name, attr, value, flags))
+	}
+	return internal.IntoPathErr("setxattr", name, errors.ErrUnsupported)
+}
+
+// ListXattr returns the names of the extended attributes of the named file
+// in the provided filesystem.
+// If fsys implements XattrFS, it calls fsys.ListXattr.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func ListXattr(fsys fs.FS, name string) ([]string, error) {
+	if err := internal.CheckPath("listxattr", name); err != nil {
+		return nil, err
+	}
+	if xfs, ok := fsys.(XattrFS); ok {
+		names, err := xfs.ListXattr(name)
+		return names, internal.IntoPathErr("listxattr", name, err)
+	}
+	return nil, internal.IntoPathErr("listxattr", name, errors.ErrUnsupported)
+}
+
+// RemoveXattr removes the extended attribute attr of the named file in the
+// provided filesystem.
+// If fsys implements XattrFS, it calls fsys.RemoveXattr.
+// Otherwise, it returns an error indicating that the operation is unsupported.
+func RemoveXattr(fsys fs.FS, name, attr string) error {
+	if err := internal.CheckXattr("removexattr", name, attr); err != nil {
+		return err
+	}
+	if xfs, ok := fsys.(XattrFS); ok {
+		return internal.IntoPathErr("removexattr", name, xfs.RemoveXattr(name, attr))
+	}
+	return internal.IntoPathErr("removexattr", name, errors.ErrUnsupported)
+}
@@ -0,0 +1,78 @@
+package fsx_test
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+
+	"github.com/gwangyi/fsx"
+	"github.com/gwangyi/fsx/contextual"
+	"github.com/gwangyi/fsx/osfs"
+)
+
+func TestXattr_Linux(t *testing.T) {
+	fsys, err := osfs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsx.WriteFile(fsys, "file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsx.SetXattr(fsys, "file", "user.comment", []byte("hello"), 0); errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("extended attributes are not supported: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := fsx.GetXattr(fsys, "file", "user.comment"); err != nil || string(value) != "hello" {
+		t.Errorf("GetXattr() = %q, %v; want hello", value, err)
+	}
+	cfs := contextual.ToContextual(fsys)
+	if value, err := contextual.GetXattr(t.Context(), cfs, "file", "user.comment"); err != nil || string(value) != "hello" {
+		t.Errorf("contextual.GetXattr() = %q, %v; want hello", value, err)
+	}
+	if err := fsx.SetXattr(fsys, "file", "user.comment", []byte("again"), fsx.XATTR_CREATE); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("SetXattr(XATTR_CREATE) error = %v; want ErrExist", err)
+	}
+	if err := fsx.SetXattr(fsys, "file", "user.missing", nil, fsx.XATTR_REPLACE); !errors.Is(err, fsx.ErrNoXattr) {
+		t.Errorf("SetXattr(XATTR_REPLACE) error = %v; want ErrNoXattr", err)
+	}
+	if _, err := fsx.GetXattr(fsys, "file", "user.missing"); !errors.Is(err, fsx.ErrNoXattr) {
+		t.Errorf("GetXattr() error = %v; want ErrNoXattr", err)
+	}
+
+	// Overlay metadata lives in the trusted namespace, which needs
+	// CAP_SYS_ADMIN.
+	wantNames := []string{"user.comment"}
+	if err := fsx.SetXattr(fsys, "file", "trusted.overlay.opaque", []byte("y"), 0); err == nil {
+		wantNames = append(wantNames, "trusted.overlay.opaque")
+	} else if !errors.Is(err, fs.ErrPermission) && !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SetXattr(trusted.overlay.opaque) error = %v", err)
+	}
+	names, err := fsx.ListXattr(fsys, "file")
+	slices.Sort(names)
+	slices.Sort(wantNames)
+	if err != nil || !slices.Equal(names, wantNames) {
+		t.Errorf("ListXattr() = %v, %v; want %v", names, err, wantNames)
+	}
+
+	if err := fsx.RemoveXattr(fsys, "file", "user.comment"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsx.RemoveXattr(fsys, "file", "user.comment"); !errors.Is(err, fsx.ErrNoXattr) {
+		t.Errorf("RemoveXattr() error = %v; want ErrNoXattr", err)
+	}
+
+	for _, attr := range []string{"comment", "user.", "other.x", ""} {
+		if _, err := fsx.GetXattr(fsys, "file", attr); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("GetXattr(%q) error = %v; want ErrInvalid", attr, err)
+		}
+	}
+	if _, err := fsx.ListXattr(fsys, "../escape"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("ListXattr() error = %v; want ErrInvalid", err)
+	}
+	if _, err := fsx.GetXattr(fsys, "missing", "user.comment"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("GetXattr() error = %v; want ErrNotExist", err)
+	}
+}